@@ -0,0 +1,296 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File admin.go contains an http.Handler which exposes read-only JSON
+// endpoints for browsing the collections of a pool.
+
+package zoom
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// AdminOptions controls what is exposed by the handler returned by
+// NewAdminHandlerWithOptions.
+type AdminOptions struct {
+	// Collections are the names of the collections which are exposed. If
+	// empty, all the collections registered with the pool are exposed.
+	Collections []string
+	// Queries maps the names of the collections which may be queried to the
+	// options used to parse the queries (see ParseQueryWithOptions), which
+	// determine the fields that may be used to filter and order the models.
+	// If Queries is nil, all exposed collections may be queried on any indexed
+	// field. If it is not nil, only the collections in it may be queried. Only
+	// indexed collections may be queried in any case.
+	Queries map[string]ParseQueryOptions
+	// MaxLimit is the maximum number of models returned by a query if the
+	// ParseQueryOptions for the collection do not have a MaxLimit. If it is 0,
+	// any number of models may be returned.
+	MaxLimit uint
+}
+
+// DefaultAdminOptions is the default set of options for NewAdminHandler, which
+// exposes all collections and returns at most 100 models from each query.
+var DefaultAdminOptions = AdminOptions{
+	MaxLimit: 100,
+}
+
+// WithCollections returns a new copy of the options with the Collections
+// property set to the given value. It does not mutate the original options.
+func (options AdminOptions) WithCollections(names ...string) AdminOptions {
+	options.Collections = names
+	return options
+}
+
+// WithQuery returns a new copy of the options with the Queries property
+// mapping the given collection name to queryOptions. It does not mutate the
+// original options.
+func (options AdminOptions) WithQuery(name string, queryOptions ParseQueryOptions) AdminOptions {
+	queries := map[string]ParseQueryOptions{}
+	for otherName, otherOptions := range options.Queries {
+		queries[otherName] = otherOptions
+	}
+	queries[name] = queryOptions
+	options.Queries = queries
+	return options
+}
+
+// WithMaxLimit returns a new copy of the options with the MaxLimit property
+// set to the given value. It does not mutate the original options.
+func (options AdminOptions) WithMaxLimit(maxLimit uint) AdminOptions {
+	options.MaxLimit = maxLimit
+	return options
+}
+
+// adminHandler is the http.Handler returned by NewAdminHandlerWithOptions.
+type adminHandler struct {
+	pool    *Pool
+	options AdminOptions
+}
+
+// adminCollectionStats is the JSON representation of a collection returned by
+// the admin handler.
+type adminCollectionStats struct {
+	Name      string `json:"name"`
+	Schema    Schema `json:"schema"`
+	Indexed   bool   `json:"indexed"`
+	Count     *int   `json:"count,omitempty"`
+	Queryable bool   `json:"queryable"`
+}
+
+// NewAdminHandler is like NewAdminHandlerWithOptions but uses
+// DefaultAdminOptions.
+func NewAdminHandler(p *Pool) http.Handler {
+	return NewAdminHandlerWithOptions(p, DefaultAdminOptions)
+}
+
+// NewAdminHandlerWithOptions returns an http.Handler which exposes read-only
+// JSON endpoints for the collections registered with the pool, so that a
+// lightweight data browser can be embedded into a service for debugging. The
+// paths are relative to the root of the handler, so it is usually mounted
+// with http.StripPrefix, e.g.:
+//
+//	mux.Handle("/debug/zoom/", http.StripPrefix("/debug/zoom", zoom.NewAdminHandler(pool)))
+//
+// The following endpoints are supported:
+//
+//	GET /collections                  the name, schema, and number of models
+//	                                  (for indexed collections) of each
+//	                                  exposed collection
+//	GET /collections/{name}           the same for a single collection
+//	GET /collections/{name}/models/{id}
+//	                                  the model with the given id, as encoded
+//	                                  by encoding/json
+//	GET /collections/{name}/query     the models which match a query built
+//	                                  from the URL parameters with
+//	                                  ParseQueryWithOptions
+//
+// Errors are returned as a JSON object with an "error" property. The handler
+// never writes to the database, but it does not authenticate requests either,
+// so it should only be reachable by trusted clients. Dynamic collections are
+// not exposed.
+func NewAdminHandlerWithOptions(p *Pool, options AdminOptions) http.Handler {
+	return &adminHandler{
+		pool:    p,
+		options: options,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAdminError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "collections" {
+		writeAdminError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+		return
+	}
+	if len(parts) == 1 {
+		h.serveCollections(w)
+		return
+	}
+	c, found := h.collection(parts[1])
+	if !found {
+		writeAdminError(w, http.StatusNotFound, "unknown collection %s", parts[1])
+		return
+	}
+	switch {
+	case len(parts) == 2:
+		stats, err := h.stats(c)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+		writeAdminJSON(w, stats)
+	case len(parts) == 4 && parts[2] == "models":
+		h.serveModel(w, c, parts[3])
+	case len(parts) == 3 && parts[2] == "query":
+		h.serveQuery(w, r, c)
+	default:
+		writeAdminError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+// collection returns the exposed collection with the given name, and false if
+// there is none.
+func (h *adminHandler) collection(name string) (*Collection, bool) {
+	if len(h.options.Collections) > 0 && !stringSliceContains(h.options.Collections, name) {
+		return nil, false
+	}
+	return h.pool.Collection(name)
+}
+
+// queryOptions returns the options used to parse queries for c, and false if
+// c may not be queried.
+func (h *adminHandler) queryOptions(c *Collection) (ParseQueryOptions, bool) {
+	queryOptions := DefaultParseQueryOptions
+	if !c.index {
+		return queryOptions, false
+	}
+	if h.options.Queries != nil {
+		var found bool
+		if queryOptions, found = h.options.Queries[c.Name()]; !found {
+			return queryOptions, false
+		}
+	}
+	if queryOptions.MaxLimit == 0 {
+		queryOptions.MaxLimit = h.options.MaxLimit
+	}
+	return queryOptions, true
+}
+
+// stats returns the JSON representation of c.
+func (h *adminHandler) stats(c *Collection) (adminCollectionStats, error) {
+	_, queryable := h.queryOptions(c)
+	stats := adminCollectionStats{
+		Name:      c.Name(),
+		Schema:    c.spec.schema(),
+		Indexed:   c.index,
+		Queryable: queryable,
+	}
+	if c.index {
+		count, err := c.Count()
+		if err != nil {
+			return stats, err
+		}
+		stats.Count = &count
+	}
+	return stats, nil
+}
+
+// serveCollections writes the JSON representation of each exposed collection,
+// sorted by name.
+func (h *adminHandler) serveCollections(w http.ResponseWriter) {
+	h.pool.registryMut.RLock()
+	names := []string{}
+	for name := range h.pool.modelNameToCollection {
+		names = append(names, name)
+	}
+	h.pool.registryMut.RUnlock()
+	sort.Strings(names)
+	result := []adminCollectionStats{}
+	for _, name := range names {
+		c, found := h.collection(name)
+		if !found {
+			continue
+		}
+		stats, err := h.stats(c)
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+		result = append(result, stats)
+	}
+	writeAdminJSON(w, result)
+}
+
+// serveModel writes the model in c with the given id.
+func (h *adminHandler) serveModel(w http.ResponseWriter, c *Collection, id string) {
+	model := c.NewModel()
+	if err := c.Find(id, model); err != nil {
+		if _, notFound := err.(ModelNotFoundError); notFound {
+			writeAdminError(w, http.StatusNotFound, "%s", err.Error())
+			return
+		}
+		writeAdminError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	writeAdminJSON(w, model)
+}
+
+// serveQuery writes the models in c which match the query built from the URL
+// parameters of r.
+func (h *adminHandler) serveQuery(w http.ResponseWriter, r *http.Request, c *Collection) {
+	queryOptions, queryable := h.queryOptions(c)
+	if !queryable {
+		writeAdminError(w, http.StatusForbidden, "collection %s may not be queried", c.Name())
+		return
+	}
+	q, err := c.ParseQueryWithOptions(r.URL.Query(), queryOptions)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+	models := reflect.New(reflect.SliceOf(c.spec.typ))
+	// Make sure an empty result is encoded as [] instead of null.
+	models.Elem().Set(reflect.MakeSlice(models.Elem().Type(), 0, 0))
+	if err := q.Run(models.Interface()); err != nil {
+		if errors.Is(err, ErrInvalidQuery) || errors.Is(err, ErrUnindexedField) {
+			writeAdminError(w, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		writeAdminError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	writeAdminJSON(w, models.Interface())
+}
+
+// writeAdminJSON writes value encoded as JSON with a 200 OK status.
+func writeAdminJSON(w http.ResponseWriter, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "could not encode the response: %s", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// writeAdminError writes a JSON object with an error message and the given
+// status.
+func writeAdminError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	data, _ := json.Marshal(map[string]string{"error": fmt.Sprintf(format, args...)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
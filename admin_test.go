@@ -0,0 +1,104 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File admin_test.go tests the code in admin.go
+
+package zoom
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type adminTestModel struct {
+	Name string `zoom:"index"`
+	Age  int    `zoom:"index"`
+	RandomID
+}
+
+func TestAdminHandler(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&adminTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	hidden, err := pool.NewCollectionWithOptions(&adminTestModel{}, DefaultCollectionOptions.WithName("hiddenAdminTestModel").WithIndex(true))
+	require.NoError(t, err)
+	models := []*adminTestModel{{Name: "alice", Age: 30}, {Name: "bob", Age: 20}, {Name: "carol", Age: 40}}
+	tx := pool.NewTransaction()
+	for _, model := range models {
+		tx.Save(col, model)
+	}
+	tx.Save(hidden, &adminTestModel{Name: "dave"})
+	require.NoError(t, tx.Exec())
+
+	options := DefaultAdminOptions.WithCollections(col.Name(), "unindexedAdminTestModel").WithQuery(col.Name(), DefaultParseQueryOptions.WithFields("Age").WithMaxLimit(2))
+	handler := NewAdminHandlerWithOptions(pool, options)
+	get := func(method string, path string, result interface{}) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		if result != nil {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), result))
+		}
+		return recorder.Code
+	}
+
+	// Collections should be listed with their stats
+	_, err = pool.NewCollectionWithOptions(&adminTestModel{}, DefaultCollectionOptions.WithName("unindexedAdminTestModel"))
+	require.NoError(t, err)
+	stats := []adminCollectionStats{}
+	require.Equal(t, http.StatusOK, get("GET", "/collections", &stats))
+	require.Len(t, stats, 2)
+	assert.Equal(t, col.Name(), stats[0].Name)
+	require.NotNil(t, stats[0].Count)
+	assert.Equal(t, 3, *stats[0].Count)
+	assert.True(t, stats[0].Queryable)
+	assert.Len(t, stats[0].Schema.Fields, 2)
+	assert.Equal(t, "unindexedAdminTestModel", stats[1].Name)
+	assert.Nil(t, stats[1].Count)
+	assert.False(t, stats[1].Queryable)
+	single := adminCollectionStats{}
+	require.Equal(t, http.StatusOK, get("GET", "/collections/"+col.Name(), &single))
+	assert.Equal(t, stats[0], single)
+
+	// Models should be found by id
+	found := &adminTestModel{}
+	require.Equal(t, http.StatusOK, get("GET", "/collections/"+col.Name()+"/models/"+models[1].ID, found))
+	assert.Equal(t, models[1], found)
+	assert.Equal(t, http.StatusNotFound, get("GET", "/collections/"+col.Name()+"/models/missing", nil))
+
+	// Queries should only use the allowed fields and limit
+	results := []*adminTestModel{}
+	require.Equal(t, http.StatusOK, get("GET", "/collections/"+col.Name()+"/query?filter=Age>=25&order=-Age", &results))
+	assert.Equal(t, []*adminTestModel{models[2], models[0]}, results)
+	require.Equal(t, http.StatusOK, get("GET", "/collections/"+col.Name()+"/query?order=Age", &results))
+	assert.Equal(t, []*adminTestModel{models[1], models[0]}, results)
+	require.Equal(t, http.StatusOK, get("GET", "/collections/"+col.Name()+"/query?filter=Age>50", &results))
+	assert.Empty(t, results)
+	assert.Equal(t, http.StatusBadRequest, get("GET", "/collections/"+col.Name()+"/query?filter=Name=alice", nil))
+	assert.Equal(t, http.StatusBadRequest, get("GET", "/collections/"+col.Name()+"/query?limit=3", nil))
+	assert.Equal(t, http.StatusForbidden, get("GET", "/collections/unindexedAdminTestModel/query", nil))
+
+	// Collections which are not exposed, unknown paths, and writes should be
+	// rejected
+	errorResponse := map[string]string{}
+	assert.Equal(t, http.StatusNotFound, get("GET", "/collections/hiddenAdminTestModel", &errorResponse))
+	assert.NotEmpty(t, errorResponse["error"])
+	assert.Equal(t, http.StatusNotFound, get("GET", "/collections/"+col.Name()+"/other", nil))
+	assert.Equal(t, http.StatusNotFound, get("GET", "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, get("DELETE", "/collections/"+col.Name()+"/models/"+models[0].ID, nil))
+	count, err := col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
@@ -0,0 +1,151 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File audit.go contains code related to the audit log, which records a
+// history of the changes made to each model in a collection.
+
+package zoom
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultAuditMaxLen is the number of changes kept for each model if
+// CollectionOptions.Audit is true and CollectionOptions.AuditMaxLen is 0.
+const DefaultAuditMaxLen = 100
+
+// ChangeAction is the kind of change recorded in the audit log.
+type ChangeAction string
+
+const (
+	// ChangeSave is recorded when a model is saved with Save.
+	ChangeSave ChangeAction = "save"
+	// ChangeUpdate is recorded when a model is saved with SaveFields.
+	ChangeUpdate ChangeAction = "update"
+	// ChangeDelete is recorded when a model is deleted with Delete.
+	ChangeDelete ChangeAction = "delete"
+)
+
+// Change is a single record in the audit log of a model. It is returned by
+// Collection.History.
+type Change struct {
+	// ID is the id of the entry in the Redis stream.
+	ID string
+	// Time is the time at which the change was recorded, according to the clock
+	// of the Redis server (with millisecond precision).
+	Time time.Time
+	// Actor identifies who made the change. It is the value passed to
+	// Transaction.WithActor, or an empty string if WithActor was not called.
+	Actor string
+	// Action is the kind of change.
+	Action ChangeAction
+	// Fields contains the names of the fields which were saved. It is empty for
+	// deletions.
+	Fields []string
+}
+
+// WithActor sets the actor which is recorded in the audit log for each change
+// made by the transaction and returns the transaction. actor can be any string
+// that identifies who made the change, e.g. a user id or the name of a
+// service. It has no effect on collections which are not audited.
+func (t *Transaction) WithActor(actor string) *Transaction {
+	t.actor = actor
+	return t
+}
+
+// historyKey returns the key of the stream which holds the audit log for the
+// model with the given id.
+func (ms *modelSpec) historyKey(id string) string {
+	return ms.name + ":history:" + id
+}
+
+// recordChange adds a command to the transaction which appends a change
+// record to the audit log for the model with the given id. It does nothing if
+// c is not audited.
+func (t *Transaction) recordChange(c *Collection, id string, action ChangeAction, fieldNames []string) {
+	if !c.audit {
+		return
+	}
+	args := redis.Args{c.spec.historyKey(id), "MAXLEN", "~", c.auditMaxLen, "*", "action", string(action)}
+	if t.actor != "" {
+		args = args.Add("actor", t.actor)
+	}
+	if len(fieldNames) > 0 {
+		args = args.Add("fields", strings.Join(fieldNames, ","))
+	}
+	t.modelCommand(id, "XADD", args, nil)
+}
+
+// History returns up to n of the most recent changes to the model with the
+// given id, starting with the most recent. If n is 0, all the changes that
+// have been kept are returned. Changes are only recorded if the Audit option
+// was set for the collection. Each model keeps at least AuditMaxLen changes
+// (Redis may keep a few more, since the stream is trimmed approximately), and
+// the history is kept after the model is deleted.
+func (c *Collection) History(id string, n int) ([]Change, error) {
+	if c == nil {
+		return nil, newNilCollectionError("History")
+	}
+	args := redis.Args{c.spec.historyKey(id), "+", "-"}
+	if n > 0 {
+		args = args.Add("COUNT", n)
+	}
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	entries, err := redis.Values(conn.Do("XREVRANGE", args...))
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]Change, 0, len(entries))
+	for _, entry := range entries {
+		change, err := parseChange(entry)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// parseChange converts a single entry in a reply from XRANGE or XREVRANGE to
+// a Change.
+func parseChange(entry interface{}) (Change, error) {
+	values, err := redis.Values(entry, nil)
+	if err != nil {
+		return Change{}, err
+	}
+	if len(values) != 2 {
+		return Change{}, fmt.Errorf("zoom: unexpected stream entry in audit log: %v", values)
+	}
+	id, err := redis.String(values[0], nil)
+	if err != nil {
+		return Change{}, err
+	}
+	fields, err := redis.StringMap(values[1], nil)
+	if err != nil {
+		return Change{}, err
+	}
+	// The first part of a stream entry id is a Unix timestamp in milliseconds
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return Change{}, fmt.Errorf("zoom: invalid stream entry id in audit log: %s", id)
+	}
+	change := Change{
+		ID:     id,
+		Time:   time.Unix(0, ms*int64(time.Millisecond)),
+		Actor:  fields["actor"],
+		Action: ChangeAction(fields["action"]),
+	}
+	if fields["fields"] != "" {
+		change.Fields = strings.Split(fields["fields"], ",")
+	}
+	return change, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File audit_test.go tests the code in audit.go
+
+package zoom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditedModel is a model type that is only used for testing the audit log
+type auditedModel struct {
+	Name string
+	Age  int
+	RandomID
+}
+
+func TestHistory(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	options := DefaultCollectionOptions.WithAudit(true).WithAuditMaxLen(3)
+	col, err := testPool.NewCollectionWithOptions(&auditedModel{}, options)
+	require.NoError(t, err)
+	defer func() {
+		// Effectively unregister the type by removing it from the map
+		delete(testPool.modelNameToSpec, col.Name())
+		delete(testPool.modelTypeToSpec, col.spec.typ)
+	}()
+
+	before := time.Now().Add(-time.Second)
+	model := &auditedModel{Name: "Alice", Age: 30}
+	require.NoError(t, col.Save(model))
+	model.Age = 31
+	tx := testPool.NewTransaction().WithActor("bob")
+	tx.SaveFields(col, []string{"Age"}, model)
+	require.NoError(t, tx.Exec())
+	_, err = col.Delete(model.ID)
+	require.NoError(t, err)
+
+	changes, err := col.History(model.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, ChangeDelete, changes[0].Action)
+	assert.Empty(t, changes[0].Fields)
+	assert.Equal(t, ChangeUpdate, changes[1].Action)
+	assert.Equal(t, "bob", changes[1].Actor)
+	assert.Equal(t, []string{"Age"}, changes[1].Fields)
+	assert.Equal(t, ChangeSave, changes[2].Action)
+	assert.Equal(t, "", changes[2].Actor)
+	assert.Equal(t, []string{"Name", "Age"}, changes[2].Fields)
+	for _, change := range changes {
+		assert.NotEmpty(t, change.ID)
+		assert.True(t, change.Time.After(before), "Wrong time for change: %s", change.Time)
+	}
+
+	// n should limit the number of changes returned
+	changes, err = col.History(model.ID, 1)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, ChangeDelete, changes[0].Action)
+
+	// The history should be capped at AuditMaxLen
+	for i := 0; i < 10; i++ {
+		require.NoError(t, col.Save(model))
+	}
+	changes, err = col.History(model.ID, 0)
+	require.NoError(t, err)
+	assert.True(t, len(changes) >= 3 && len(changes) < 13, "Expected the history to be trimmed but got %d changes", len(changes))
+
+	// Models in collections which are not audited should not have a history
+	other := &indexedTestModel{}
+	require.NoError(t, indexedTestModels.Save(other))
+	changes, err = indexedTestModels.History(other.ID, 0)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
@@ -7,6 +7,7 @@
 package zoom
 
 import (
+	"fmt"
 	"math/rand"
 	"testing"
 )
@@ -97,6 +98,60 @@ func BenchmarkSave100(b *testing.B) {
 	}
 }
 
+// BenchmarkMainHashArgs converts the fields of a single model into the args
+// for HMSET, which is the part of Save that does not involve the network.
+func BenchmarkMainHashArgs(b *testing.B) {
+	testingSetUp()
+	defer testingTearDown()
+
+	mr := &modelRef{
+		collection: indexedTestModels,
+		model:      createIndexedTestModels(1)[0],
+		spec:       indexedTestModels.spec,
+	}
+	mr.model.SetModelID("id")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := mr.mainHashArgs(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkScanModel scans the fields of a single model from a reply, which is
+// the part of Find that does not involve the network.
+func BenchmarkScanModel(b *testing.B) {
+	testingSetUp()
+	defer testingTearDown()
+
+	fieldNames := indexedTestModels.spec.fieldNames()
+	replies := []interface{}{}
+	mr := &modelRef{
+		collection: indexedTestModels,
+		model:      createIndexedTestModels(1)[0],
+		spec:       indexedTestModels.spec,
+	}
+	args, err := mr.mainHashArgs()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 2; i < len(args); i += 2 {
+		replies = append(replies, []byte(fmt.Sprint(args[i])))
+	}
+	model := &indexedTestModel{}
+	mr.model = model
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := scanModel(fieldNames, replies, mr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // BenchmarkFind finds one model at a time randomly from
 // a set of 1,000 models
 func BenchmarkFind(b *testing.B) {
@@ -377,6 +432,18 @@ func BenchmarkQueryFilterBool100From1000(b *testing.B) {
 	benchmarkQueryFilterBool(b, 100, 1000)
 }
 
+// BenchmarkQueryFilterRangeInt100From1000 runs a query which selects 100
+// models out of 1,000 total with a single range filter on the Int field
+func BenchmarkQueryFilterRangeInt100From1000(b *testing.B) {
+	benchmarkQueryFilterIntRange(b, true)
+}
+
+// BenchmarkQueryFilterPairInt100From1000 runs the same query as
+// BenchmarkQueryFilterRangeInt100From1000 with two separate filters
+func BenchmarkQueryFilterPairInt100From1000(b *testing.B) {
+	benchmarkQueryFilterIntRange(b, false)
+}
+
 // BenchmarkQueryOrderInt100 runs a query which finds all 100 models ordered
 // by the Int field
 func BenchmarkQueryOrderInt100(b *testing.B) {
@@ -413,6 +480,30 @@ func BenchmarkQueryOrderBool10000(b *testing.B) {
 	benchmarkQueryOrder(b, 10000, "Bool")
 }
 
+// BenchmarkQueryFilterBitmap20000 runs a query with an equality filter on two
+// fields with bitmap indexes, which selects 2,500 models out of 20,000 total
+func BenchmarkQueryFilterBitmap20000(b *testing.B) {
+	benchmarkQueryFilterLowCardinality(b, &bitmapBenchModel{}, 0)
+}
+
+// BenchmarkQueryFilterSortedSet20000 runs the same query as
+// BenchmarkQueryFilterBitmap20000 on fields with regular indexes
+func BenchmarkQueryFilterSortedSet20000(b *testing.B) {
+	benchmarkQueryFilterLowCardinality(b, &sortedSetBenchModel{}, 0)
+}
+
+// BenchmarkQueryFilterBitmapLimit20000 runs the same query as
+// BenchmarkQueryFilterBitmap20000 with a limit of 10
+func BenchmarkQueryFilterBitmapLimit20000(b *testing.B) {
+	benchmarkQueryFilterLowCardinality(b, &bitmapBenchModel{}, 10)
+}
+
+// BenchmarkQueryFilterSortedSetLimit20000 runs the same query as
+// BenchmarkQueryFilterSortedSet20000 with a limit of 10
+func BenchmarkQueryFilterSortedSetLimit20000(b *testing.B) {
+	benchmarkQueryFilterLowCardinality(b, &sortedSetBenchModel{}, 10)
+}
+
 // BenchmarkComplexQuery runs a query which incorporates nearly all options.
 // The query has a filter on the String and Int Fields, is ordered in reverse
 // by the Bool field, and includes only Bool and Int. Out of 1,000 models created,
@@ -484,6 +575,26 @@ func benchmarkQueryFilterInt(b *testing.B, selected int, total int) {
 	benchmarkQuery(b, indexedTestModels.NewQuery().Filter("Int =", 1))
 }
 
+func benchmarkQueryFilterIntRange(b *testing.B, useRange bool) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := createIndexedTestModels(1000)
+	t := testPool.NewTransaction()
+	for i, model := range models {
+		model.Int = i
+		t.Save(indexedTestModels, model)
+	}
+	if err := t.Exec(); err != nil {
+		b.Fatal(err)
+	}
+	if useRange {
+		benchmarkQuery(b, indexedTestModels.NewQuery().FilterRange("Int", 450, 550, false))
+	} else {
+		benchmarkQuery(b, indexedTestModels.NewQuery().Filter("Int >", 450).Filter("Int <", 550))
+	}
+}
+
 func benchmarkQueryFilterString(b *testing.B, selected int, total int) {
 	testingSetUp()
 	defer testingTearDown()
@@ -535,6 +646,62 @@ func benchmarkQueryOrder(b *testing.B, n int, field string) {
 	benchmarkQuery(b, q)
 }
 
+// bitmapBenchModel is a model with two low-cardinality fields with bitmap
+// indexes.
+type bitmapBenchModel struct {
+	Color  string `zoom:"index,bitmap"`
+	Active bool   `zoom:"index,bitmap"`
+	RandomID
+}
+
+// sortedSetBenchModel has the same fields as bitmapBenchModel with regular
+// indexes.
+type sortedSetBenchModel struct {
+	Color  string `zoom:"index"`
+	Active bool   `zoom:"index"`
+	RandomID
+}
+
+// benchmarkQueryFilterLowCardinality saves 20,000 models of the type of model
+// (which must be bitmapBenchModel or sortedSetBenchModel), one in every eight
+// of which is red and active, and benchmarks a query which finds them with an
+// equality filter on each field.
+func benchmarkQueryFilterLowCardinality(b *testing.B, model Model, limit uint) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(model, DefaultCollectionOptions.WithIndex(true))
+	if err != nil {
+		b.Fatal(err)
+	}
+	colors := []string{"red", "green", "blue", "yellow"}
+	for batch := 0; batch < 20; batch++ {
+		t := pool.NewTransaction()
+		for i := batch * 1000; i < (batch+1)*1000; i++ {
+			color, active := colors[i%len(colors)], i%8 < 4
+			if _, ok := model.(*bitmapBenchModel); ok {
+				t.Save(col, &bitmapBenchModel{Color: color, Active: active})
+			} else {
+				t.Save(col, &sortedSetBenchModel{Color: color, Active: active})
+			}
+		}
+		if err := t.Exec(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := col.NewQuery().Filter("Color =", "red").Filter("Active =", true).Limit(limit)
+		if _, err := q.IDs(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // selectUnique selects num random, unique strings from a slice of strings
 func selectUnique(num int, ids []string) []string {
 	selected := make(map[string]bool)
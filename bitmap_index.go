@@ -0,0 +1,174 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File bitmap_index.go contains code for maintaining and querying bitmap
+// indexes, which are used for equality filters on fields with a small number
+// of distinct values.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// hasBitmapIndex returns true iff fs is an indexed field with a bitmap index.
+func (fs *fieldSpec) hasBitmapIndex() bool {
+	return fs.bitmap && fs.indexKind != noIndex
+}
+
+// bitmapValuesKey returns the key for the set which contains all the values of
+// the field fs which have a bitmap. The bitmap for each value is stored in
+// the same key followed by a colon and the value.
+func (ms *modelSpec) bitmapValuesKey(fs *fieldSpec) string {
+	return ms.indexKeyForField(fs) + ":bitmap"
+}
+
+// bitmapKey returns the key for the bitmap which contains the models for which
+// the field fs has the given value (as it is stored in the main hash).
+func (ms *modelSpec) bitmapKey(fs *fieldSpec, value string) string {
+	return ms.bitmapValuesKey(fs) + ":" + value
+}
+
+// bitmapIDsKey returns the key for the hash which maps the offsets of models in
+// the bitmap indexes to their ids.
+func (ms *modelSpec) bitmapIDsKey() string {
+	return ms.name + ":bitmap:ids"
+}
+
+// bitmapValue returns the value of a field with a bitmap index as it is stored
+// in the main hash (and in the key of the bitmap for the value).
+func (ms *modelSpec) bitmapValue(fs *fieldSpec, fieldVal reflect.Value) (string, error) {
+	for fieldVal.Kind() == reflect.Ptr {
+		fieldVal = fieldVal.Elem()
+	}
+	value, err := ms.hashValue(fs, fieldVal)
+	if err != nil {
+		return "", err
+	}
+	// Format the value the same way as redigo does for HMSET.
+	if b, ok := value.(bool); ok {
+		if b {
+			return "1", nil
+		}
+		return "0", nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// saveBitmapIndexes adds commands to the transaction for saving the bitmap
+// indexes for the fields in fieldNames which have one.
+func (t *Transaction) saveBitmapIndexes(mr *modelRef, fieldNames []string) {
+	var args redis.Args
+	for _, fs := range mr.spec.fields {
+		if !fs.hasBitmapIndex() || !stringSliceContains(fieldNames, fs.name) {
+			continue
+		}
+		value, err := mr.spec.bitmapValue(fs, mr.fieldValue(fs.name))
+		if err != nil {
+			t.setError(err)
+			return
+		}
+		if args == nil {
+			args = redis.Args{mr.spec.name, mr.model.ModelID(), "save", mr.spec.indexKeyPrefix()}
+		}
+		args = args.Add(fs.redisName, value)
+	}
+	if args != nil {
+		// NOTE: this invokes a lua script which is defined in scripts/update_bitmap_index.lua
+		t.modelScript(mr.model.ModelID(), updateBitmapIndexScript, args, nil)
+	}
+}
+
+// updateBitmapIndexes adds a command to the transaction which removes the
+// model with the given id from the bitmap indexes for the given fields (if mode
+// is "delete") or adds it to them based on the values stored in the main hash
+// (if mode is "sync"). If mode is "synclist", id is the key of a list of ids
+// and each of the models is synced.
+func (t *Transaction) updateBitmapIndexes(spec *modelSpec, id string, mode string, fields []*fieldSpec) {
+	modelID := id
+	if mode == "synclist" {
+		modelID = ""
+	}
+	args := redis.Args{spec.name, id, mode, spec.indexKeyPrefix()}
+	for _, fs := range fields {
+		if fs.hasBitmapIndex() {
+			args = args.Add(fs.redisName)
+		}
+	}
+	if len(args) > 4 {
+		// NOTE: this invokes a lua script which is defined in scripts/update_bitmap_index.lua
+		t.modelScript(modelID, updateBitmapIndexScript, args, nil)
+	}
+}
+
+// isBitmapFilter returns true iff filter can be evaluated using a bitmap
+// index, i.e. it is an equality or IN filter on a field with a bitmap index.
+func isBitmapFilter(filter filter) bool {
+	return filter.fieldSpec.hasBitmapIndex() && (filter.op == equalOp || filter.op == inOp)
+}
+
+// intersectBitmapFilters adds commands to the query transaction which, when
+// run, will intersect origKey with the ids of models which match all the given
+// bitmap filters and store the result in destKey. The bitmaps for the filters
+// are combined with BITOP first, so that only the ids of the models which match
+// all of them are extracted. If origKey is the index of all models, the ids are
+// stored in destKey directly (scored by their offset in the bitmaps), and if
+// maxIDs is not 0, at most maxIDs ids are extracted.
+func intersectBitmapFilters(q *query, tx *Transaction, filters []filter, origKey string, destKey string, maxIDs int) error {
+	spec := q.collection.spec
+	bitmapKeys := redis.Args{}
+	tmpKeys := redis.Args{}
+	for _, filter := range filters {
+		if filter.op == equalOp {
+			value, err := spec.bitmapValue(filter.fieldSpec, filter.value)
+			if err != nil {
+				return err
+			}
+			bitmapKeys = bitmapKeys.Add(spec.bitmapKey(filter.fieldSpec, value))
+			continue
+		}
+		// The bitmap for an IN filter is the union of the bitmaps for each value.
+		valueKeys := redis.Args{}
+		for i := 0; i < filter.value.Len(); i++ {
+			value, err := spec.bitmapValue(filter.fieldSpec, filter.value.Index(i))
+			if err != nil {
+				return err
+			}
+			valueKeys = valueKeys.Add(spec.bitmapKey(filter.fieldSpec, value))
+		}
+		inKey := tx.newTmpKey("tmp:bitmap:in")
+		tmpKeys = tmpKeys.Add(inKey)
+		if len(valueKeys) == 0 {
+			// No model can match an empty list of values.
+			tx.Command("DEL", redis.Args{inKey}, nil)
+		} else {
+			tx.Command("BITOP", redis.Args{"OR", inKey}.AddFlat(valueKeys), nil)
+		}
+		bitmapKeys = bitmapKeys.Add(inKey)
+	}
+	bitmapKey := bitmapKeys[0].(string)
+	if len(bitmapKeys) > 1 {
+		bitmapKey = tx.newTmpKey("tmp:bitmap:and")
+		tmpKeys = tmpKeys.Add(bitmapKey)
+		tx.Command("BITOP", redis.Args{"AND", bitmapKey}.AddFlat(bitmapKeys), nil)
+	}
+	if origKey == spec.indexKey() {
+		// Every model in the bitmaps is in the index of all models, so there is
+		// nothing to intersect with.
+		// NOTE: this invokes a lua script which is defined in scripts/extract_ids_from_bitmap.lua
+		tx.Script(extractIdsFromBitmapScript, redis.Args{spec.bitmapIDsKey(), bitmapKey, destKey, maxIDs}, nil)
+	} else {
+		filterKey := tx.newTmpKey("tmp:filter:bitmap")
+		tmpKeys = tmpKeys.Add(filterKey)
+		tx.Script(extractIdsFromBitmapScript, redis.Args{spec.bitmapIDsKey(), bitmapKey, filterKey, 0}, nil)
+		tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, filterKey, "WEIGHTS", 1, 0}, nil)
+	}
+	if len(tmpKeys) > 0 {
+		tx.Command("DEL", tmpKeys, nil)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File bitmap_index_test.go tests the code in bitmap_index.go
+
+package zoom
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bitmapTestModel struct {
+	Color    string `zoom:"index,bitmap"`
+	Active   bool   `zoom:"index,bitmap"`
+	Priority int64  `zoom:"index,bitmap"`
+	Int      int    `zoom:"index"`
+	RandomID
+}
+
+func newBitmapTestCollection(t *testing.T) (*Pool, *Collection) {
+	pool := NewPoolWithOptions(testPool.options)
+	col, err := pool.NewCollectionWithOptions(&bitmapTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	return pool, col
+}
+
+// bitmapTestIDs returns the sorted ids of the models which satisfy match.
+func bitmapTestIDs(models []*bitmapTestModel, match func(m *bitmapTestModel) bool) []string {
+	ids := []string{}
+	for _, m := range models {
+		if match(m) {
+			ids = append(ids, m.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestBitmapIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newBitmapTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	colors := []string{"red", "green", "blue"}
+	models := []*bitmapTestModel{}
+	for i := 0; i < 30; i++ {
+		model := &bitmapTestModel{
+			Color:    colors[i%3],
+			Active:   i%2 == 0,
+			Priority: int64(i%4 - 1),
+			Int:      i,
+		}
+		require.NoError(t, col.Save(model))
+		models = append(models, model)
+	}
+
+	checkQuery := func(query *Query, match func(m *bitmapTestModel) bool) {
+		ids, err := query.IDs()
+		require.NoError(t, err)
+		sort.Strings(ids)
+		assert.Equal(t, bitmapTestIDs(models, match), ids, query.String())
+	}
+	checkQuery(col.NewQuery().Filter("Color =", "red"), func(m *bitmapTestModel) bool {
+		return m.Color == "red"
+	})
+	checkQuery(col.NewQuery().Filter("Color =", "red").Filter("Active =", true), func(m *bitmapTestModel) bool {
+		return m.Color == "red" && m.Active
+	})
+	checkQuery(col.NewQuery().Filter("Priority =", int64(-1)).Filter("Active =", false), func(m *bitmapTestModel) bool {
+		return m.Priority == -1 && !m.Active
+	})
+	checkQuery(col.NewQuery().Filter("Color IN", []string{"red", "blue"}).Filter("Int <", 20), func(m *bitmapTestModel) bool {
+		return m.Color != "green" && m.Int < 20
+	})
+	checkQuery(col.NewQuery().Filter("Color IN", []string{}), func(m *bitmapTestModel) bool {
+		return false
+	})
+	checkQuery(col.NewQuery().Filter("Color =", "purple"), func(m *bitmapTestModel) bool {
+		return false
+	})
+	checkQuery(col.NewQuery().Filter("Color !=", "red").Filter("Active =", true), func(m *bitmapTestModel) bool {
+		return m.Color != "red" && m.Active
+	})
+
+	// The order of the query should be preserved
+	ids, err := col.NewQuery().Filter("Color =", "blue").Order("-Int").IDs()
+	require.NoError(t, err)
+	expected := []string{}
+	for i := len(models) - 1; i >= 0; i-- {
+		if models[i].Color == "blue" {
+			expected = append(expected, models[i].ID)
+		}
+	}
+	assert.Equal(t, expected, ids)
+
+	// Queries which only have bitmap filters and a limit only extract the ids
+	// they need. Consecutive pages should add up to all of the results.
+	newQuery := func() *Query {
+		return col.NewQuery().Filter("Color =", "red").Filter("Active =", true)
+	}
+	all, err := newQuery().IDs()
+	require.NoError(t, err)
+	pages := []string{}
+	for offset := uint(0); offset < uint(len(all)); offset += 2 {
+		page, err := newQuery().Offset(offset).Limit(2).IDs()
+		require.NoError(t, err)
+		assert.True(t, len(page) > 0 && len(page) <= 2, "wrong number of ids in page: %v", page)
+		pages = append(pages, page...)
+	}
+	assert.Equal(t, all, pages)
+	count, err := newQuery().Limit(2).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Updating and deleting models should update the bitmaps
+	models[0].Color = "green"
+	require.NoError(t, col.Save(models[0]))
+	models[1].Active = true
+	require.NoError(t, col.SaveFields([]string{"Active"}, models[1]))
+	_, err = col.Delete(models[2].ID)
+	require.NoError(t, err)
+	models = models[:2+copy(models[2:], models[3:])]
+	checkQuery(col.NewQuery().Filter("Color =", "green").Filter("Active =", true), func(m *bitmapTestModel) bool {
+		return m.Color == "green" && m.Active
+	})
+	checkQuery(col.NewQuery().Filter("Color =", "blue"), func(m *bitmapTestModel) bool {
+		return m.Color == "blue"
+	})
+
+	// Updating models with a query should update the bitmaps
+	count, err = col.NewQuery().Filter("Color =", "blue").Update(map[string]interface{}{"Color": "purple"})
+	require.NoError(t, err)
+	assert.NotZero(t, count)
+	for _, m := range models {
+		if m.Color == "blue" {
+			m.Color = "purple"
+		}
+	}
+	checkQuery(col.NewQuery().Filter("Color =", "blue"), func(m *bitmapTestModel) bool {
+		return false
+	})
+	checkQuery(col.NewQuery().Filter("Color IN", []string{"purple", "red"}), func(m *bitmapTestModel) bool {
+		return m.Color == "purple" || m.Color == "red"
+	})
+
+	// Dropping the index should delete the bitmaps
+	require.NoError(t, col.DropIndex("Color"))
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	for _, color := range append(colors, "purple", "") {
+		exists, err := redis.Bool(conn.Do("EXISTS", col.spec.bitmapValuesKey(col.spec.fieldsByName["Color"])+":"+color))
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+}
+
+func TestBitmapIndexDelete(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newBitmapTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	colors := []string{"red", "green", "blue"}
+	models := []*bitmapTestModel{}
+	for i := 0; i < 12; i++ {
+		model := &bitmapTestModel{Color: colors[i%3], Active: i%2 == 0, Int: i}
+		require.NoError(t, col.Save(model))
+		models = append(models, model)
+	}
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	// checkBitmaps checks the number of models in the bitmap for each color and
+	// the number of models which have an offset.
+	checkBitmaps := func(expected map[string]int, numOffsets int) {
+		for _, color := range colors {
+			count, err := redis.Int(conn.Do("BITCOUNT", col.spec.bitmapKey(col.spec.fieldsByName["Color"], color)))
+			require.NoError(t, err)
+			assert.Equal(t, expected[color], count, "wrong number of models in bitmap for %s", color)
+		}
+		for _, key := range []string{col.Name() + ":bitmap:offsets", col.spec.bitmapIDsKey()} {
+			count, err := redis.Int(conn.Do("HLEN", key))
+			require.NoError(t, err)
+			assert.Equal(t, numOffsets, count, "wrong number of entries in %s", key)
+		}
+	}
+	checkBitmaps(map[string]int{"red": 4, "green": 4, "blue": 4}, 12)
+
+	_, err := col.Delete(models[0].ID)
+	require.NoError(t, err)
+	checkBitmaps(map[string]int{"red": 3, "green": 4, "blue": 4}, 11)
+
+	count, err := col.NewQuery().Filter("Color =", "green").Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	checkBitmaps(map[string]int{"red": 3, "blue": 4}, 7)
+
+	count, err = col.DeleteWhere(FilterSpec{Filter: "Active =", Value: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	checkBitmaps(map[string]int{"red": 2, "blue": 2}, 4)
+
+	count, err = col.DeleteAll()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	checkBitmaps(map[string]int{}, 0)
+}
+
+func TestBitmapIndexBuild(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newBitmapTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	models := []*bitmapTestModel{}
+	for i := 0; i < 10; i++ {
+		model := &bitmapTestModel{Color: []string{"red", "blue"}[i%2]}
+		require.NoError(t, col.Save(model))
+		models = append(models, model)
+	}
+	// Simulate models which were saved before the bitmap index existed.
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	fs := col.spec.fieldsByName["Color"]
+	_, err := conn.Do("DEL", col.spec.bitmapKey(fs, "red"), col.spec.bitmapKey(fs, "blue"), col.spec.bitmapValuesKey(fs))
+	require.NoError(t, err)
+	ids, err := col.NewQuery().Filter("Color =", "red").IDs()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	build, err := col.BuildIndex("Color")
+	require.NoError(t, err)
+	require.NoError(t, build.Wait())
+	ids, err = col.NewQuery().Filter("Color =", "red").IDs()
+	require.NoError(t, err)
+	sort.Strings(ids)
+	assert.Equal(t, bitmapTestIDs(models, func(m *bitmapTestModel) bool {
+		return m.Color == "red"
+	}), ids)
+}
+
+func TestBitmapIndexInvalidTags(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	type withoutIndex struct {
+		Color string `zoom:"bitmap"`
+		RandomID
+	}
+	_, err := pool.NewCollection(&withoutIndex{})
+	assert.Error(t, err)
+	type floatField struct {
+		Float float64 `zoom:"index,bitmap"`
+		RandomID
+	}
+	_, err = pool.NewCollection(&floatField{})
+	assert.Error(t, err)
+	type pointerField struct {
+		Color *string `zoom:"index,bitmap"`
+		RandomID
+	}
+	_, err = pool.NewCollection(&pointerField{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,382 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File cache.go contains code related to the optional in-process model cache,
+// which sits in front of Find and FindFields.
+
+package zoom
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// modelCache is a size-limited LRU cache of the field values for models,
+// keyed by collection name and model id. It is safe for concurrent use.
+type modelCache struct {
+	size    int
+	ttl     time.Duration
+	mut     sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// epoch is incremented every time an entry is invalidated. It is used to
+	// detect whether values read from the database might have been made stale
+	// by a write that happened while they were being read.
+	epoch uint64
+}
+
+// cacheEntry is a single entry in a modelCache.
+type cacheEntry struct {
+	key            string
+	collectionName string
+	// values maps field names to the values for those fields as they are stored
+	// in the main hash. A nil value means the field was not set.
+	values  map[string][]byte
+	expires time.Time
+}
+
+// newModelCache creates and returns a new cache which will hold at most size
+// models. If ttl is greater than 0, entries will expire after the given
+// duration.
+func newModelCache(size int, ttl time.Duration) *modelCache {
+	return &modelCache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// modelCacheKey returns the key for the model in the cache. Collection names
+// cannot contain a colon, so the key is unique.
+func modelCacheKey(collectionName string, id string) string {
+	return collectionName + ":" + id
+}
+
+// currentEpoch returns the current epoch of the cache. Pass it to add to
+// ensure that values are only added if there have been no invalidations since
+// they were read.
+func (mc *modelCache) currentEpoch() uint64 {
+	mc.mut.Lock()
+	defer mc.mut.Unlock()
+	return mc.epoch
+}
+
+// get returns copies of the cached values for the given fields of the model
+// with the given collection name and id, in the same order as fieldNames. It
+// returns false if the model is not in the cache or any of the fieldNames are
+// not cached.
+func (mc *modelCache) get(collectionName string, id string, fieldNames []string) ([]interface{}, bool) {
+	mc.mut.Lock()
+	defer mc.mut.Unlock()
+	elem, found := mc.entries[modelCacheKey(collectionName, id)]
+	if !found {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		mc.removeElement(elem)
+		return nil, false
+	}
+	values := make([]interface{}, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		value, found := entry.values[fieldName]
+		if !found {
+			return nil, false
+		}
+		if value != nil {
+			// Copy the value so that scanning it into a []byte field does not
+			// share memory with the cache.
+			values[i] = append([]byte{}, value...)
+		}
+	}
+	mc.lru.MoveToFront(elem)
+	return values, true
+}
+
+// add adds the given field values to the cache for the model with the given
+// collection name and id, evicting the least recently used model if the cache
+// is full. The values are not added if the cache has been invalidated since
+// epoch.
+func (mc *modelCache) add(collectionName string, id string, epoch uint64, fieldNames []string, fieldValues []interface{}) {
+	values := map[string][]byte{}
+	for i, fieldName := range fieldNames {
+		if fieldValues[i] == nil {
+			values[fieldName] = nil
+			continue
+		}
+		value, err := redis.Bytes(fieldValues[i], nil)
+		if err != nil {
+			return
+		}
+		values[fieldName] = append([]byte{}, value...)
+	}
+	entry := &cacheEntry{
+		key:            modelCacheKey(collectionName, id),
+		collectionName: collectionName,
+		values:         values,
+	}
+	if mc.ttl > 0 {
+		entry.expires = time.Now().Add(mc.ttl)
+	}
+	mc.mut.Lock()
+	defer mc.mut.Unlock()
+	if mc.epoch != epoch {
+		return
+	}
+	if elem, found := mc.entries[entry.key]; found {
+		mc.removeElement(elem)
+	}
+	mc.entries[entry.key] = mc.lru.PushFront(entry)
+	for mc.lru.Len() > mc.size {
+		mc.removeElement(mc.lru.Back())
+	}
+}
+
+// removeElement removes elem from the cache. The caller must hold mc.mut.
+func (mc *modelCache) removeElement(elem *list.Element) {
+	mc.lru.Remove(elem)
+	delete(mc.entries, elem.Value.(*cacheEntry).key)
+}
+
+// invalidateModel removes the model with the given collection name and id
+// from the cache.
+func (mc *modelCache) invalidateModel(collectionName string, id string) {
+	mc.mut.Lock()
+	defer mc.mut.Unlock()
+	mc.epoch++
+	if elem, found := mc.entries[modelCacheKey(collectionName, id)]; found {
+		mc.removeElement(elem)
+	}
+}
+
+// invalidateCollection removes all the models in the collection with the given
+// name from the cache.
+func (mc *modelCache) invalidateCollection(collectionName string) {
+	mc.mut.Lock()
+	defer mc.mut.Unlock()
+	mc.epoch++
+	for elem := mc.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*cacheEntry).collectionName == collectionName {
+			mc.removeElement(elem)
+		}
+		elem = next
+	}
+}
+
+// clear removes all models from the cache.
+func (mc *modelCache) clear() {
+	mc.mut.Lock()
+	defer mc.mut.Unlock()
+	mc.epoch++
+	mc.entries = map[string]*list.Element{}
+	mc.lru.Init()
+}
+
+// cacheInvalidation describes a model or collection which should be removed
+// from the cache after a transaction is executed. If id is empty, all the
+// models in the collection should be removed.
+type cacheInvalidation struct {
+	collectionName string
+	id             string
+}
+
+// message returns the message published on the invalidation channel for i.
+// Since collection names cannot contain a colon, a message without a colon
+// refers to the entire collection.
+func (i cacheInvalidation) message() string {
+	if i.id == "" {
+		return i.collectionName
+	}
+	return modelCacheKey(i.collectionName, i.id)
+}
+
+// parseCacheInvalidation parses a message published on the invalidation
+// channel.
+func parseCacheInvalidation(message string) cacheInvalidation {
+	parts := strings.SplitN(message, ":", 2)
+	if len(parts) == 1 {
+		return cacheInvalidation{collectionName: parts[0]}
+	}
+	return cacheInvalidation{collectionName: parts[0], id: parts[1]}
+}
+
+// apply removes the model or collection described by i from mc.
+func (i cacheInvalidation) apply(mc *modelCache) {
+	if i.id == "" {
+		mc.invalidateCollection(i.collectionName)
+	} else {
+		mc.invalidateModel(i.collectionName, i.id)
+	}
+}
+
+// cacheFor returns the model cache for the pool if the models in c can be
+// cached, or nil otherwise. Models with fields stored in their own key are
+// never cached, and neither are models with fields with the ttl option, since
+// the cache would keep returning the fields after they expire.
+func (p *Pool) cacheFor(c *Collection) *modelCache {
+	if p.cache == nil || len(c.spec.keyFields) > 0 || c.spec.hasFieldTTL() {
+		return nil
+	}
+	return p.cache
+}
+
+// findInCache scans the cached values for the given fields of the model with
+// the given id into model. It returns false if the cache is disabled or does
+// not contain the model, in which case the caller should read the model from
+// the database.
+func (c *Collection) findInCache(id string, fieldNames []string, model Model) (bool, error) {
+	cache := c.pool.cacheFor(c)
+	if cache == nil || len(fieldNames) == 0 {
+		return false, nil
+	}
+	if err := c.checkModelType(model); err != nil {
+		// Let the caller return the appropriate error.
+		return false, nil
+	}
+	values, found := cache.get(c.Name(), id, fieldNames)
+	if !found {
+		return false, nil
+	}
+	model.SetModelID(id)
+	mr := &modelRef{
+		collection: c,
+		model:      model,
+		spec:       c.spec,
+	}
+	return true, scanModel(fieldNames, values, mr)
+}
+
+// newCachingHandler returns a ReplyHandler which adds the reply from an HMGET
+// command for the given fields to the cache and then calls handler.
+func newCachingHandler(cache *modelCache, c *Collection, id string, fieldNames []string, handler ReplyHandler) ReplyHandler {
+	epoch := cache.currentEpoch()
+	return func(reply interface{}) error {
+		if fieldValues, err := redis.Values(reply, nil); err == nil && len(fieldValues) == len(fieldNames) {
+			cache.add(c.Name(), id, epoch, fieldNames, fieldValues)
+		}
+		return handler(reply)
+	}
+}
+
+// invalidateCachedModel records that the model with the given id should be
+// removed from the cache when the transaction is executed. If the pool has a
+// cache invalidation channel, it also adds a PUBLISH command to the
+// transaction so that other processes can do the same.
+func (t *Transaction) invalidateCachedModel(c *Collection, id string) {
+	t.invalidateCache(cacheInvalidation{collectionName: c.Name(), id: id})
+}
+
+// invalidateCachedCollection is like invalidateCachedModel but for all the
+// models in the collection with the given name.
+func (t *Transaction) invalidateCachedCollection(collectionName string) {
+	t.invalidateCache(cacheInvalidation{collectionName: collectionName})
+}
+
+func (t *Transaction) invalidateCache(i cacheInvalidation) {
+	if t.pool == nil || t.pool.cache == nil {
+		return
+	}
+	t.mut.Lock()
+	t.invalidations = append(t.invalidations, i)
+	t.mut.Unlock()
+	if channel := t.pool.options.CacheInvalidationChannel; channel != "" {
+		t.Command("PUBLISH", redis.Args{channel, i.message()}, nil)
+	}
+}
+
+// applyCacheInvalidations removes all the models recorded by
+// invalidateCachedModel and invalidateCachedCollection from the cache.
+func (t *Transaction) applyCacheInvalidations() {
+	for _, i := range t.invalidations {
+		i.apply(t.pool.cache)
+	}
+	t.invalidations = nil
+}
+
+// cacheSubscriber listens for messages on the cache invalidation channel and
+// removes the corresponding models from the cache.
+type cacheSubscriber struct {
+	pool   *Pool
+	mut    sync.Mutex
+	psc    *redis.PubSubConn
+	closed bool
+	done   chan struct{}
+}
+
+// startCacheSubscriber starts listening for messages on the cache
+// invalidation channel in a separate goroutine.
+func (p *Pool) startCacheSubscriber() {
+	p.cacheSubscriber = &cacheSubscriber{
+		pool: p,
+		done: make(chan struct{}),
+	}
+	go p.cacheSubscriber.listen()
+}
+
+// listen subscribes to the invalidation channel and receives messages until
+// the subscriber is closed. If the connection is lost, the entire cache is
+// cleared (since invalidations may have been missed) and listen subscribes
+// again after a short delay.
+func (s *cacheSubscriber) listen() {
+	defer close(s.done)
+	for {
+		s.mut.Lock()
+		if s.closed {
+			s.mut.Unlock()
+			return
+		}
+		// Use a connection outside of the pool, so that closing it will cause
+		// Receive to return immediately.
+		conn, err := s.pool.redisPool.Dial()
+		if err == nil {
+			psc := &redis.PubSubConn{Conn: conn}
+			s.psc = psc
+			s.mut.Unlock()
+			if err := psc.Subscribe(s.pool.options.CacheInvalidationChannel); err == nil {
+				s.receive(psc)
+			}
+			_ = psc.Close()
+		} else {
+			s.mut.Unlock()
+		}
+		s.pool.cache.clear()
+		s.mut.Lock()
+		closed := s.closed
+		s.mut.Unlock()
+		if closed {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// receive handles messages from psc until there is an error.
+func (s *cacheSubscriber) receive(psc *redis.PubSubConn) {
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			parseCacheInvalidation(string(v.Data)).apply(s.pool.cache)
+		case error:
+			return
+		}
+	}
+}
+
+// close stops the subscriber and closes its connection. It blocks until the
+// subscriber goroutine has exited.
+func (s *cacheSubscriber) close() {
+	s.mut.Lock()
+	s.closed = true
+	if s.psc != nil {
+		_ = s.psc.Close()
+	}
+	s.mut.Unlock()
+	<-s.done
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File cache_test.go tests the code in cache.go
+
+package zoom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCachingPool returns a new pool with the same options as testPool but
+// with the given cache options, and a collection of testModels registered with
+// the new pool.
+func newCachingPool(t *testing.T, options PoolOptions) (*Pool, *Collection) {
+	pool := NewPoolWithOptions(options)
+	collection, err := pool.NewCollectionWithOptions(&testModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	return pool, collection
+}
+
+func TestModelCacheLRU(t *testing.T) {
+	cache := newModelCache(2, 0)
+	fieldNames := []string{"Int"}
+	cache.add("a", "1", cache.currentEpoch(), fieldNames, []interface{}{[]byte("1")})
+	cache.add("a", "2", cache.currentEpoch(), fieldNames, []interface{}{[]byte("2")})
+	// Reading the first model should make the second one the least recently used
+	_, found := cache.get("a", "1", fieldNames)
+	assert.True(t, found)
+	cache.add("a", "3", cache.currentEpoch(), fieldNames, []interface{}{[]byte("3")})
+	_, found = cache.get("a", "2", fieldNames)
+	assert.False(t, found, "Expected least recently used model to be evicted")
+	values, found := cache.get("a", "1", fieldNames)
+	require.True(t, found)
+	assert.Equal(t, []interface{}{[]byte("1")}, values)
+	_, found = cache.get("a", "1", []string{"String"})
+	assert.False(t, found, "Expected get to fail for a field which is not cached")
+
+	// Values read before an invalidation should not be added
+	epoch := cache.currentEpoch()
+	cache.invalidateModel("a", "3")
+	cache.add("a", "3", epoch, fieldNames, []interface{}{[]byte("3")})
+	_, found = cache.get("a", "3", fieldNames)
+	assert.False(t, found, "Expected stale values to not be added")
+
+	cache.add("b", "1", cache.currentEpoch(), fieldNames, []interface{}{[]byte("1")})
+	cache.invalidateCollection("a")
+	_, found = cache.get("a", "1", fieldNames)
+	assert.False(t, found)
+	_, found = cache.get("b", "1", fieldNames)
+	assert.True(t, found)
+}
+
+func TestModelCacheTTL(t *testing.T) {
+	cache := newModelCache(10, 10*time.Millisecond)
+	fieldNames := []string{"Int"}
+	cache.add("a", "1", cache.currentEpoch(), fieldNames, []interface{}{[]byte("1")})
+	_, found := cache.get("a", "1", fieldNames)
+	assert.True(t, found)
+	time.Sleep(20 * time.Millisecond)
+	_, found = cache.get("a", "1", fieldNames)
+	assert.False(t, found, "Expected cached model to expire")
+}
+
+func TestFindWithCache(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, models := newCachingPool(t, testPool.options.WithCacheSize(10))
+	defer func() {
+		_ = pool.Close()
+	}()
+	model := createTestModels(1)[0]
+	require.NoError(t, models.Save(model))
+	got := &testModel{}
+	require.NoError(t, models.Find(model.ID, got))
+	assert.Equal(t, model, got)
+
+	// Change the model directly in the database. Find should return the cached
+	// values.
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("HSET", models.ModelKey(model.ID), "String", "changed")
+	require.NoError(t, err)
+	got = &testModel{}
+	require.NoError(t, models.Find(model.ID, got))
+	assert.Equal(t, model.String, got.String)
+	got = &testModel{}
+	require.NoError(t, models.FindFields(model.ID, []string{"Int"}, got))
+	assert.Equal(t, model.Int, got.Int)
+	assert.Equal(t, "", got.String)
+
+	// Saving the model through the pool should invalidate the cache
+	model.Int++
+	require.NoError(t, models.SaveFields([]string{"Int"}, model))
+	got = &testModel{}
+	require.NoError(t, models.Find(model.ID, got))
+	assert.Equal(t, model.Int, got.Int)
+	assert.Equal(t, "changed", got.String)
+
+	// So should deleting it
+	_, err = models.Delete(model.ID)
+	require.NoError(t, err)
+	err = models.Find(model.ID, &testModel{})
+	assert.IsType(t, ModelNotFoundError{}, err)
+}
+
+func TestCacheInvalidationChannel(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	options := testPool.options.WithCacheSize(10).WithCacheInvalidationChannel("zoom:test:cache")
+	poolA, modelsA := newCachingPool(t, options)
+	defer func() {
+		_ = poolA.Close()
+	}()
+	poolB, modelsB := newCachingPool(t, options)
+	defer func() {
+		_ = poolB.Close()
+	}()
+
+	model := createTestModels(1)[0]
+	require.NoError(t, modelsB.Save(model))
+	// Populate the cache for poolA
+	require.NoError(t, modelsA.Find(model.ID, &testModel{}))
+
+	// Saving the model with poolB should eventually invalidate the cache for
+	// poolA. We keep saving in case poolA was not subscribed yet.
+	model.String = "changed"
+	got := &testModel{}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		require.NoError(t, modelsB.Save(model))
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, modelsA.Find(model.ID, got))
+		if got.String == model.String {
+			break
+		}
+	}
+	assert.Equal(t, model.String, got.String)
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File claim.go contains code for claiming models which match a query, so
+// that an indexed collection can be used as a simple work queue.
+
+package zoom
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/garyburd/redigo/redis"
+)
+
+// ClaimOne atomically finds the first model that fits the query criteria,
+// according to Order and Offset, which is not already claimed, scans its values
+// into model and claims it with a lease which expires after lease. Limit
+// restricts the number of candidates which are considered. If no unclaimed
+// model fits the criteria, ClaimOne returns a ModelNotFoundError. A claim is
+// the same as the lock acquired by Collection.Lock, so the returned Lock can be
+// used to release the claim once the model has been processed (e.g. after
+// changing its Status so that it no longer fits the query) or to extend it. If
+// the lease expires first, the model can be claimed again, so a worker which
+// crashes does not prevent the model from being processed. For example:
+//
+//	lock, err := Jobs.NewQuery().Filter("Status =", "pending").ClaimOne(job, time.Minute)
+//
+// ClaimOne does not support StrictScan or the document layout.
+func (q *Query) ClaimOne(model Model, lease time.Duration) (*Lock, error) {
+	q = &Query{query: q.query.intercept("ClaimOne")}
+	if q.hasError() {
+		return nil, q.err
+	}
+	if lease < time.Millisecond {
+		return nil, fmt.Errorf("zoom: error in ClaimOne: lease must be at least one millisecond but got %s", lease)
+	}
+	spec := q.collection.spec
+	if err := spec.checkModelType(model); err != nil {
+		return nil, err
+	}
+	if err := q.collection.checkHashLayout("ClaimOne"); err != nil {
+		return nil, err
+	}
+	tx := q.newTransaction()
+	idsKey, tmpKeys, err := generateIDsSet(q.query, tx)
+	if err != nil {
+		return nil, err
+	}
+	limit := int(q.limit)
+	if limit == 0 {
+		limit = -1
+	}
+	// Store the ids of the candidates in order, so that the claim_one script
+	// can check them one at a time.
+	listKey := tx.newTmpKey("tmp:claim:" + spec.name)
+	sortArgs := spec.sortArgs(idsKey, nil, limit, q.offset, q.order.kind == descendingOrder)
+	tx.Command("SORT", append(sortArgs, "STORE", listKey), nil)
+	token := uniuri.New()
+	args := redis.Args{spec.name, listKey, token, lease.Milliseconds()}
+	for _, redisName := range q.redisFieldNames() {
+		args = args.Add(redisName)
+	}
+	tx.Script(claimOneScript, args, newScanOneModelHandler(q.query, spec, append(q.fieldNames(), "-"), model))
+	tx.Command("DEL", (redis.Args{listKey}).Add(tmpKeys...), nil)
+	if err := tx.Exec(); err != nil {
+		return nil, err
+	}
+	return &Lock{
+		pool:  q.pool,
+		key:   spec.lockKey(model.ModelID()),
+		token: token,
+	}, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File claim_test.go tests the code in claim.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryClaimOne(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*indexedTestModel{
+		{Int: 1, String: "pending"},
+		{Int: 2, String: "done"},
+		{Int: 3, String: "pending"},
+	}
+	tx := testPool.NewTransaction()
+	for _, model := range models {
+		tx.Save(indexedTestModels, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	query := indexedTestModels.NewQuery().Filter("String =", "pending").Order("Int")
+
+	// Each claim should return the next model which is not claimed
+	first := &indexedTestModel{}
+	firstLock, err := query.ClaimOne(first, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, models[0], first)
+	assert.Equal(t, indexedTestModels.spec.lockKey(models[0].ModelID()), firstLock.Key())
+	second := &indexedTestModel{}
+	secondLock, err := query.ClaimOne(second, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, models[2], second)
+
+	// All the pending models are claimed
+	_, err = query.ClaimOne(&indexedTestModel{}, time.Minute)
+	assert.IsType(t, ModelNotFoundError{}, err)
+
+	// A claimed model cannot be locked by another client
+	_, err = indexedTestModels.Lock(models[0].ModelID(), time.Minute)
+	assert.True(t, errors.Is(err, ErrLocked), "err: %v", err)
+
+	// Once the claim is released, the model can be claimed again
+	require.NoError(t, firstLock.Unlock())
+	again := &indexedTestModel{}
+	againLock, err := query.ClaimOne(again, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, models[0], again)
+	require.NoError(t, againLock.Unlock())
+	require.NoError(t, secondLock.Unlock())
+
+	// Include should restrict the fields which are scanned
+	partial := &indexedTestModel{}
+	partialLock, err := query.Include("Int").ClaimOne(partial, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, &indexedTestModel{Int: 1, RandomID: models[0].RandomID}, partial)
+	require.NoError(t, partialLock.Unlock())
+
+	// Deleted models should not be claimed
+	_, err = indexedTestModels.Delete(models[0].ModelID())
+	require.NoError(t, err)
+	remaining := &indexedTestModel{}
+	remainingLock, err := indexedTestModels.NewQuery().Filter("String =", "pending").ClaimOne(remaining, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, models[2], remaining)
+	require.NoError(t, remainingLock.Unlock())
+
+	// The lease must be positive
+	_, err = query.ClaimOne(&indexedTestModel{}, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,335 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File generate.go contains the generate command, which writes code that lets
+// Zoom read and write the fields of model types without reflection (see
+// zoom.GeneratedFields). It is intended to be used with go generate, e.g.:
+//
+//	//go:generate zoom generate . Person Post
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/albrow/zoom"
+)
+
+// generatedFileName is the name of the file written by the generate command
+// if the -output flag is not given.
+const generatedFileName = "zoom_generated.go"
+
+// scalarKind describes how a generated field is parsed from the main hash.
+type scalarKind string
+
+const (
+	intScalar    scalarKind = "int"
+	uintScalar   scalarKind = "uint"
+	floatScalar  scalarKind = "float"
+	boolScalar   scalarKind = "bool"
+	stringScalar scalarKind = "string"
+	bytesScalar  scalarKind = "bytes"
+)
+
+// basicKinds maps the names of the predeclared types which Zoom stores as
+// primitives to their scalarKind. Other numeric types (e.g. complex128 or
+// uintptr) are not primitives, so they are left to reflection.
+var basicKinds = map[string]scalarKind{
+	"int": intScalar, "int8": intScalar, "int16": intScalar, "int32": intScalar, "int64": intScalar, "rune": intScalar,
+	"uint": uintScalar, "uint8": uintScalar, "uint16": uintScalar, "uint32": uintScalar, "uint64": uintScalar, "byte": uintScalar,
+	"float32": floatScalar, "float64": floatScalar,
+	"bool":   boolScalar,
+	"string": stringScalar,
+}
+
+// generatedField is a field of a model type for which code is generated.
+type generatedField struct {
+	// Name is the name of the field.
+	Name string
+	// Type is the type of the field (or the type it points to) as written in
+	// the source, e.g. "int" or "Status".
+	Type string
+	// Kind is the kind of the underlying type.
+	Kind scalarKind
+	// Pointer is true iff the field is a pointer to Type.
+	Pointer bool
+	// Duration is true iff Type is time.Duration, which is stored as an int64.
+	Duration bool
+}
+
+// generatedModel is a model type for which code is generated.
+type generatedModel struct {
+	Name   string
+	Fields []generatedField
+}
+
+// generateCode writes a file with an implementation of zoom.GeneratedFields for
+// each of the given model types in the package in a directory.
+func generateCode(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	output := flags.String("output", "", "the file to write (by default, "+generatedFileName+" in the directory)")
+	args, err := parseArgs(flags, args, 2, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	dir, typeNames := args[0], args[1:]
+	if *output == "" {
+		*output = filepath.Join(dir, generatedFileName)
+	}
+	src, err := generateSource(dir, typeNames, filepath.Base(*output))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "wrote %s\n", *output)
+	return nil
+}
+
+// generateSource parses the package in dir and returns the formatted source of
+// the generated file for the given model types. The file with the name
+// skipFile (i.e. the output of a previous run) is not parsed.
+func generateSource(dir string, typeNames []string, skipFile string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != skipFile
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected exactly one package in %s but found %d", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	// Collect all the type declarations so that fields with a named type can
+	// be resolved to the underlying type.
+	typeSpecs := map[string]*ast.TypeSpec{}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				typeSpecs[typeSpec.Name.Name] = typeSpec
+			}
+		}
+	}
+	models := []generatedModel{}
+	for _, typeName := range typeNames {
+		typeSpec, found := typeSpecs[typeName]
+		if !found {
+			return nil, fmt.Errorf("type %s was not found in package %s", typeName, pkg.Name)
+		}
+		structType, ok := typeSpec.Type.(*ast.StructType)
+		if !ok {
+			return nil, fmt.Errorf("type %s is not a struct", typeName)
+		}
+		model := generatedModel{Name: typeName}
+		for _, field := range structType.Fields.List {
+			// Embedded fields (including inlined structs) are left to reflection.
+			if len(field.Names) == 0 || skipFieldTag(field.Tag) {
+				continue
+			}
+			gf, ok := resolveFieldType(field.Type, typeSpecs)
+			if !ok {
+				continue
+			}
+			for _, name := range field.Names {
+				if !name.IsExported() {
+					continue
+				}
+				gf.Name = name.Name
+				model.Fields = append(model.Fields, gf)
+			}
+		}
+		models = append(models, model)
+	}
+	return writeGeneratedSource(pkg.Name, models)
+}
+
+// skipFieldTag returns true iff the struct tag of a field means that Zoom does
+// not store it in the main hash as a primitive (e.g. because it is ignored or
+// is an enum field), so no code should be generated for it.
+func skipFieldTag(tag *ast.BasicLit) bool {
+	if tag == nil {
+		return false
+	}
+	value, err := strconv.Unquote(tag.Value)
+	if err != nil {
+		return true
+	}
+	structTag := reflect.StructTag(value)
+	if structTag.Get("redis") == "-" {
+		return true
+	}
+	for _, option := range strings.Split(structTag.Get("zoom"), ",") {
+		switch {
+		case strings.HasPrefix(option, "enum="), option == "hash", option == "list", option == "set":
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFieldType returns a generatedField describing expr, the type of a
+// field, if it is a primitive, a named type whose underlying type is a
+// primitive, time.Duration, or a pointer to one of those. Otherwise it returns
+// false.
+func resolveFieldType(expr ast.Expr, typeSpecs map[string]*ast.TypeSpec) (generatedField, bool) {
+	gf := generatedField{}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		gf.Pointer = true
+		expr = star.X
+	}
+	switch t := expr.(type) {
+	case *ast.SelectorExpr:
+		// Durations are stored as int64 but pointers to them are not converted,
+		// so only plain durations are generated.
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Duration" && !gf.Pointer {
+			gf.Type, gf.Kind, gf.Duration = "time.Duration", intScalar, true
+			return gf, true
+		}
+		return gf, false
+	case *ast.ArrayType:
+		if isByteSlice(t) && !gf.Pointer {
+			gf.Type, gf.Kind = "[]byte", bytesScalar
+			return gf, true
+		}
+		return gf, false
+	case *ast.Ident:
+		gf.Type = t.Name
+		if kind, found := basicKinds[t.Name]; found {
+			gf.Kind = kind
+			return gf, true
+		}
+		typeSpec, found := typeSpecs[t.Name]
+		if !found || typeSpec.Assign.IsValid() {
+			return gf, false
+		}
+		switch underlying := typeSpec.Type.(type) {
+		case *ast.Ident:
+			if kind, found := basicKinds[underlying.Name]; found {
+				gf.Kind = kind
+				return gf, true
+			}
+		case *ast.ArrayType:
+			if isByteSlice(underlying) && !gf.Pointer {
+				gf.Kind = bytesScalar
+				return gf, true
+			}
+		}
+	}
+	return gf, false
+}
+
+// isByteSlice returns true iff t is []byte or []uint8.
+func isByteSlice(t *ast.ArrayType) bool {
+	elem, ok := t.Elt.(*ast.Ident)
+	return ok && t.Len == nil && (elem.Name == "byte" || elem.Name == "uint8")
+}
+
+// writeGeneratedSource returns the formatted source of the generated file.
+func writeGeneratedSource(pkgName string, models []generatedModel) ([]byte, error) {
+	imports := map[string]bool{}
+	body := &bytes.Buffer{}
+	for _, model := range models {
+		writeHashValue(body, model)
+		writeScanHashField(body, model, imports)
+	}
+	src := &bytes.Buffer{}
+	fmt.Fprintf(src, "// Code generated by zoom generate. DO NOT EDIT.\n\npackage %s\n\n", pkgName)
+	if len(imports) > 0 {
+		names := []string{}
+		for name := range imports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(src, "import (\n")
+		for _, name := range names {
+			fmt.Fprintf(src, "\t%q\n", name)
+		}
+		fmt.Fprintf(src, ")\n\n")
+	}
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// writeHashValue writes the ZoomHashValue method for model. It returns the
+// same values Zoom would compute with reflection.
+func writeHashValue(w io.Writer, model generatedModel) {
+	fmt.Fprintf(w, "// ZoomHashValue implements zoom.GeneratedFields.\n")
+	fmt.Fprintf(w, "func (m *%s) ZoomHashValue(fieldName string) (interface{}, bool) {\n", model.Name)
+	fmt.Fprintf(w, "switch fieldName {\n")
+	for _, field := range model.Fields {
+		fmt.Fprintf(w, "case %q:\n", field.Name)
+		switch {
+		case field.Pointer:
+			fmt.Fprintf(w, "if m.%s == nil {\nreturn \"NULL\", true\n}\nreturn *m.%s, true\n", field.Name, field.Name)
+		case field.Duration:
+			fmt.Fprintf(w, "return int64(m.%s), true\n", field.Name)
+		default:
+			fmt.Fprintf(w, "return m.%s, true\n", field.Name)
+		}
+	}
+	fmt.Fprintf(w, "}\nreturn nil, false\n}\n\n")
+}
+
+// writeScanHashField writes the ZoomScanHashField method for model and adds
+// the packages it uses to imports. Like Zoom, it leaves the field unchanged if
+// the value is empty (or "NULL" for pointers).
+func writeScanHashField(w io.Writer, model generatedModel, imports map[string]bool) {
+	fmt.Fprintf(w, "// ZoomScanHashField implements zoom.GeneratedFields.\n")
+	fmt.Fprintf(w, "func (m *%s) ZoomScanHashField(fieldName string, src []byte) (bool, error) {\n", model.Name)
+	fmt.Fprintf(w, "switch fieldName {\n")
+	for _, field := range model.Fields {
+		fmt.Fprintf(w, "case %q:\n", field.Name)
+		target := "m." + field.Name
+		if field.Pointer {
+			fmt.Fprintf(w, "if string(src) == \"NULL\" {\nreturn true, nil\n}\n")
+			fmt.Fprintf(w, "v := new(%s)\nm.%s = v\n", field.Type, field.Name)
+			target = "*v"
+		}
+		fmt.Fprintf(w, "if len(src) == 0 {\nreturn true, nil\n}\n")
+		switch field.Kind {
+		case intScalar, uintScalar, floatScalar, boolScalar:
+			imports["fmt"], imports["strconv"] = true, true
+			parse := map[scalarKind]string{
+				intScalar:   "strconv.ParseInt(string(src), 10, 0)",
+				uintScalar:  "strconv.ParseUint(string(src), 10, 0)",
+				floatScalar: "strconv.ParseFloat(string(src), 64)",
+				boolScalar:  "strconv.ParseBool(string(src))",
+			}[field.Kind]
+			fmt.Fprintf(w, "parsed, err := %s\n", parse)
+			fmt.Fprintf(w, "if err != nil {\nreturn true, fmt.Errorf(\"zoom: could not convert %%s to %s\", string(src))\n}\n", field.Kind)
+			fmt.Fprintf(w, "%s = %s(parsed)\n", target, field.Type)
+		case stringScalar, bytesScalar:
+			fmt.Fprintf(w, "%s = %s(src)\n", target, field.Type)
+		}
+		if field.Duration {
+			imports["time"] = true
+		}
+		fmt.Fprintf(w, "return true, nil\n")
+	}
+	fmt.Fprintf(w, "}\nreturn false, nil\n}\n\n")
+}
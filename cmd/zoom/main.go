@@ -0,0 +1,755 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File main.go contains the zoom command-line tool, which can be used to
+// inspect and maintain the data that Zoom stores in a Redis database. Since the
+// tool does not have access to the Go types of the models, it works directly
+// with the keys Zoom uses (e.g. "Person:all" for the set of all ids and
+// "Person:<id>" for the hash of each model), and the indexed fields must be
+// described with the -index flag where needed.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/albrow/zoom"
+	"github.com/garyburd/redigo/redis"
+)
+
+const usage = `Usage: zoom [flags] <command> [arguments]
+
+The commands are:
+
+	collections [pattern]                 list collections with a name matching pattern (default "*")
+	stats <collection>                    show the number of models and the size of each index
+	dump <collection>                     write all models to stdout as JSON, one per line
+	restore [-index ...] <collection>     read models written by dump from stdin and save them
+	rebuild [-index ...] [-reset] <collection>
+	                                      make the indexes consistent with the models
+	filter [-kind kind] [-models] <collection> <field> <op> <value>
+	                                      print the ids (or models) matching a filter
+	generate [-output file] <dir> <type>...
+	                                      write code which lets Zoom read and write the
+	                                      given model types without reflection
+
+The -index flag describes an indexed field as name:kind or name:kind:pointer,
+where kind is one of numeric, boolean, string, integer, or enum. It can be
+repeated. For enum fields, the filter command expects the position of the value
+in the list of allowed values (starting at 0) instead of the value itself.
+If it is not given, the indexes are read from the schema which Zoom stores in
+Redis when the collection is registered.
+
+The flags are:
+`
+
+// batchSize is the number of models which are read or written in a single
+// transaction.
+const batchSize = 100
+
+// command is a subcommand of the command-line tool.
+type command func(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error
+
+var commands = map[string]command{
+	"collections": listCollections,
+	"stats":       showStats,
+	"dump":        dumpModels,
+	"restore":     restoreModels,
+	"rebuild":     rebuildIndexes,
+	"filter":      filterModels,
+	"generate":    generateCode,
+}
+
+func main() {
+	flags := flag.NewFlagSet("zoom", flag.ExitOnError)
+	address := flags.String("address", zoom.DefaultPoolOptions.Address, "address of the Redis server")
+	network := flags.String("network", zoom.DefaultPoolOptions.Network, "network to use when connecting to Redis")
+	database := flags.Int("database", zoom.DefaultPoolOptions.Database, "Redis database to use")
+	password := flags.String("password", "", "password for the Redis server")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	cmd, found := commands[flags.Arg(0)]
+	if !found {
+		fmt.Fprintf(os.Stderr, "zoom: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+	pool := zoom.NewPoolWithOptions(zoom.DefaultPoolOptions.
+		WithAddress(*address).
+		WithNetwork(*network).
+		WithDatabase(*database).
+		WithPassword(*password))
+	defer func() {
+		_ = pool.Close()
+	}()
+	if err := cmd(pool, flags.Args()[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "zoom: %s\n", err.Error())
+		_ = pool.Close()
+		os.Exit(1)
+	}
+}
+
+// indexFlags is a flag.Value which collects the indexed fields given with
+// repeated -index flags.
+type indexFlags []zoom.FieldIndex
+
+func (f *indexFlags) String() string {
+	parts := []string{}
+	for _, index := range *f {
+		part := index.RedisName + ":" + index.Kind
+		if index.Pointer {
+			part += ":pointer"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *indexFlags) Set(value string) error {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || (len(parts) == 3 && parts[2] != "pointer") {
+		return fmt.Errorf("invalid index %q (should be name:kind or name:kind:pointer)", value)
+	}
+	if err := checkIndexKind(parts[1]); err != nil {
+		return err
+	}
+	*f = append(*f, zoom.FieldIndex{
+		RedisName: parts[0],
+		Kind:      parts[1],
+		Pointer:   len(parts) == 3,
+	})
+	return nil
+}
+
+// storedSchema returns the schema of the collection with the given name which
+// was stored in Redis when it was registered, or a schema with only the name
+// (and no fields) if there is no stored schema.
+func storedSchema(pool *zoom.Pool, name string) (zoom.Schema, error) {
+	schema, found, err := pool.StoredSchema(name)
+	if err != nil {
+		return zoom.Schema{}, err
+	} else if !found {
+		return zoom.Schema{Name: name}, nil
+	}
+	return schema, nil
+}
+
+// schemaIndexes returns the indexed fields of a collection according to its
+// stored schema (see storedSchema). It returns an empty slice if there is no
+// stored schema. Collated string indexes are not included, since their members
+// can only be computed with the collation function of the collection.
+func schemaIndexes(schema zoom.Schema) indexFlags {
+	indexes := indexFlags{}
+	for _, field := range schema.Fields {
+		if field.Index != "" && field.Index != "collated" {
+			indexes = append(indexes, zoom.FieldIndex{
+				RedisName: field.RedisName,
+				Kind:      field.Index,
+				Pointer:   strings.HasPrefix(field.Type, "*"),
+			})
+		}
+	}
+	return indexes
+}
+
+// setKeys sets the key of each index to the key given by the schema, so that
+// indexes in the index namespace (see CollectionOptions.IndexNamespace) are
+// found.
+func (f indexFlags) setKeys(schema zoom.Schema) {
+	for i := range f {
+		f[i].Key = schema.IndexKey(f[i].RedisName)
+	}
+}
+
+// checkIndexKind returns an error if kind is not a valid kind of index.
+func checkIndexKind(kind string) error {
+	switch kind {
+	case "numeric", "boolean", "string", "integer", "enum":
+		return nil
+	}
+	return fmt.Errorf("invalid index kind %q (should be one of numeric, boolean, string, integer, or enum)", kind)
+}
+
+// parseArgs parses the flags for a command and returns the remaining
+// arguments. It returns an error if the number of remaining arguments is not
+// between min and max.
+func parseArgs(flags *flag.FlagSet, args []string, min int, max int) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() < min || flags.NArg() > max {
+		return nil, fmt.Errorf("wrong number of arguments for %s (see zoom -help)", flags.Name())
+	}
+	return flags.Args(), nil
+}
+
+// indexIsSorted returns true iff the set of all ids for the collection with
+// the given name is a sorted set (see zoom.CollectionOptions.SortedIndex).
+func indexIsSorted(conn redis.Conn, name string) (bool, error) {
+	keyType, err := redis.String(conn.Do("TYPE", name+":all"))
+	if err != nil {
+		return false, err
+	}
+	return keyType == "zset", nil
+}
+
+// collectionIDs calls fn with batches of ids from the set of all ids for the
+// collection with the given name.
+func collectionIDs(conn redis.Conn, name string, fn func(ids []string) error) error {
+	sorted, err := indexIsSorted(conn, name)
+	if err != nil {
+		return err
+	}
+	command := "SSCAN"
+	if sorted {
+		command = "ZSCAN"
+	}
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do(command, name+":all", cursor, "COUNT", batchSize))
+		if err != nil {
+			return err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return err
+		}
+		ids, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return err
+		}
+		if sorted {
+			// ZSCAN returns each id followed by its score.
+			members := ids
+			ids = make([]string, 0, len(members)/2)
+			for i := 0; i < len(members); i += 2 {
+				ids = append(ids, members[i])
+			}
+		}
+		if len(ids) > 0 {
+			if err := fn(ids); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// listCollections prints the name of each collection matching the pattern
+// (i.e. each set or sorted set with a key of the form <name>:all) and its
+// number of models.
+func listCollections(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error {
+	args, err := parseArgs(flag.NewFlagSet("collections", flag.ContinueOnError), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	pattern := "*"
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	counts := map[string]int{}
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern+":all", "COUNT", batchSize))
+		if err != nil {
+			return err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return err
+		}
+		keys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			keyType, err := redis.String(conn.Do("TYPE", key))
+			if err != nil {
+				return err
+			}
+			var count int
+			switch keyType {
+			case "set":
+				count, err = redis.Int(conn.Do("SCARD", key))
+			case "zset":
+				count, err = redis.Int(conn.Do("ZCARD", key))
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			counts[strings.TrimSuffix(key, ":all")] = count
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	names := []string{}
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "%s\t%d\n", name, counts[name])
+	}
+	return nil
+}
+
+// showStats prints the number of models in a collection, and the size of the
+// index for each field of a randomly chosen model (or the first model, if the
+// set of all ids is a sorted set).
+func showStats(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error {
+	args, err := parseArgs(flag.NewFlagSet("stats", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	schema, err := storedSchema(pool, name)
+	if err != nil {
+		return err
+	}
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	sorted, err := indexIsSorted(conn, name)
+	if err != nil {
+		return err
+	}
+	cardCommand := "SCARD"
+	if sorted {
+		cardCommand = "ZCARD"
+	}
+	count, err := redis.Int(conn.Do(cardCommand, name+":all"))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "models\t%d\n", count)
+	if count == 0 {
+		return nil
+	}
+	var id string
+	if sorted {
+		var ids []string
+		if ids, err = redis.Strings(conn.Do("ZRANGE", name+":all", 0, 0)); err == nil && len(ids) > 0 {
+			id = ids[0]
+		}
+	} else {
+		id, err = redis.String(conn.Do("SRANDMEMBER", name+":all"))
+	}
+	if err != nil {
+		return err
+	}
+	fields, err := redis.Strings(conn.Do("HKEYS", name+":"+id))
+	if err != nil {
+		return err
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		indexKey := schema.IndexKey(field)
+		for _, key := range []string{indexKey, indexKey + ":null"} {
+			keyType, err := redis.String(conn.Do("TYPE", key))
+			if err != nil {
+				return err
+			}
+			var size int
+			switch keyType {
+			case "zset":
+				size, err = redis.Int(conn.Do("ZCARD", key))
+			case "set":
+				size, err = redis.Int(conn.Do("SCARD", key))
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s\t%s\t%d\n", key, keyType, size)
+		}
+	}
+	return nil
+}
+
+// dumpedModel is the JSON representation of a model written by dump and read
+// by restore. Values which are not valid UTF-8 (e.g. fields encoded with gob)
+// are stored in BinaryFields, which are base64 encoded.
+type dumpedModel struct {
+	ID           string            `json:"id"`
+	Fields       map[string]string `json:"fields"`
+	BinaryFields map[string][]byte `json:"binaryFields,omitempty"`
+}
+
+// writeModels writes the models with the given ids as JSON, one per line.
+// Models which no longer exist are skipped.
+func writeModels(conn redis.Conn, name string, ids []string, out io.Writer) error {
+	for _, id := range ids {
+		if err := conn.Send("HGETALL", name+":"+id); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	for _, id := range ids {
+		values, err := redis.ByteSlices(conn.Receive())
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			continue
+		}
+		model := dumpedModel{ID: id, Fields: map[string]string{}}
+		for i := 0; i+1 < len(values); i += 2 {
+			field, value := string(values[i]), values[i+1]
+			if utf8.Valid(value) {
+				model.Fields[field] = string(value)
+			} else {
+				if model.BinaryFields == nil {
+					model.BinaryFields = map[string][]byte{}
+				}
+				model.BinaryFields[field] = value
+			}
+		}
+		if err := encoder.Encode(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpModels writes all the models in a collection as JSON, one per line.
+func dumpModels(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error {
+	args, err := parseArgs(flag.NewFlagSet("dump", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	idConn := pool.NewConn()
+	defer func() {
+		_ = idConn.Close()
+	}()
+	return collectionIDs(idConn, name, func(ids []string) error {
+		return writeModels(conn, name, ids, out)
+	})
+}
+
+// restoreModels reads models written by dump and saves them in a collection,
+// then updates the indexes given with the -index flag (or in the stored
+// schema). Unless the collection already has a set of all ids which is not a
+// sorted set, the models are added to a sorted set of all ids with the time
+// they were restored as their score.
+func restoreModels(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	indexes := indexFlags{}
+	flags.Var(&indexes, "index", "an indexed field")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	schema, err := storedSchema(pool, name)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		indexes = schemaIndexes(schema)
+	}
+	indexes.setKeys(schema)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	conn := pool.NewConn()
+	keyType, err := redis.String(conn.Do("TYPE", name+":all"))
+	_ = conn.Close()
+	if err != nil {
+		return err
+	}
+	tx := pool.NewTransaction()
+	restored, pending := 0, 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		model := dumpedModel{}
+		if err := json.Unmarshal([]byte(line), &model); err != nil {
+			return fmt.Errorf("invalid model on line %d: %s", restored+1, err.Error())
+		}
+		if model.ID == "" {
+			return fmt.Errorf("invalid model on line %d: id is empty", restored+1)
+		}
+		hashArgs := redis.Args{name + ":" + model.ID}
+		for field, value := range model.Fields {
+			hashArgs = hashArgs.Add(field, value)
+		}
+		for field, value := range model.BinaryFields {
+			hashArgs = hashArgs.Add(field, value)
+		}
+		if len(hashArgs) > 1 {
+			tx.Command("HMSET", hashArgs, nil)
+		}
+		if keyType == "set" {
+			tx.Command("SADD", redis.Args{name + ":all", model.ID}, nil)
+		} else {
+			tx.Command("ZADD", redis.Args{name + ":all", "NX", time.Now().UnixNano() / int64(time.Millisecond), model.ID}, nil)
+		}
+		if len(indexes) > 0 {
+			tx.SyncModelIndexes(name, model.ID, true, indexes)
+		}
+		restored++
+		pending++
+		if pending == batchSize {
+			if err := tx.Exec(); err != nil {
+				return err
+			}
+			tx, pending = pool.NewTransaction(), 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := tx.Exec(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "restored %d models\n", restored)
+	return nil
+}
+
+// rebuildIndexes makes the indexes given with the -index flag (or in the stored
+// schema) consistent with the models in a collection. If -reset is given, the indexes are deleted
+// first, which also removes any ids which are no longer in the collection.
+func rebuildIndexes(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	indexes := indexFlags{}
+	flags.Var(&indexes, "index", "an indexed field")
+	reset := flags.Bool("reset", false, "delete the indexes before rebuilding them")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	schema, err := storedSchema(pool, name)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		indexes = schemaIndexes(schema)
+	}
+	indexes.setKeys(schema)
+	if len(indexes) == 0 {
+		return fmt.Errorf("rebuild requires at least one -index flag or a stored schema with indexed fields for %s", name)
+	}
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if *reset {
+		keys := redis.Args{}
+		for _, index := range indexes {
+			keys = keys.Add(index.Key, index.Key+":null")
+		}
+		if _, err := conn.Do("DEL", keys...); err != nil {
+			return err
+		}
+	}
+	rebuilt := 0
+	if err := collectionIDs(conn, name, func(ids []string) error {
+		tx := pool.NewTransaction()
+		for _, id := range ids {
+			tx.SyncModelIndexes(name, id, true, indexes)
+		}
+		rebuilt += len(ids)
+		return tx.Exec()
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "rebuilt indexes for %d models\n", rebuilt)
+	return nil
+}
+
+// filterModels prints the ids (or, with -models, the models) for which the
+// value of an indexed field matches a filter.
+func filterModels(pool *zoom.Pool, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("filter", flag.ContinueOnError)
+	kind := flags.String("kind", "", "the kind of index on the field (by default, the kind in the stored schema)")
+	printModels := flags.Bool("models", false, "print the models as JSON instead of the ids")
+	args, err := parseArgs(flags, args, 4, 4)
+	if err != nil {
+		return err
+	}
+	name, field, op, value := args[0], args[1], args[2], args[3]
+	schema, err := storedSchema(pool, name)
+	if err != nil {
+		return err
+	}
+	if *kind == "" {
+		for _, index := range schemaIndexes(schema) {
+			if index.RedisName == field {
+				*kind = index.Kind
+			}
+		}
+		if *kind == "" {
+			return fmt.Errorf("the stored schema for %s does not have an index on %s (use -kind to specify one)", name, field)
+		}
+	}
+	if err := checkIndexKind(*kind); err != nil {
+		return err
+	}
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	indexKey := schema.IndexKey(field)
+	var ids []string
+	switch *kind {
+	case "numeric", "boolean", "enum":
+		if *kind == "boolean" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean value %q", value)
+			}
+			value = "0"
+			if b {
+				value = "1"
+			}
+		} else if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("invalid numeric value %q", value)
+		}
+		min, max, err := scoreRange(op, value)
+		if err != nil {
+			return err
+		}
+		if ids, err = redis.Strings(conn.Do("ZRANGEBYSCORE", indexKey, min, max)); err != nil {
+			return err
+		}
+	case "string", "integer":
+		if *kind == "integer" {
+			if value, err = encodeInteger(value); err != nil {
+				return err
+			}
+		} else {
+			value = encodeString(value)
+		}
+		min, max, err := lexRange(op, value)
+		if err != nil {
+			return err
+		}
+		members, err := redis.Strings(conn.Do("ZRANGEBYLEX", indexKey, min, max))
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			// Members of string indexes consist of the value, a null byte, and
+			// the id.
+			ids = append(ids, member[strings.LastIndex(member, "\x00")+1:])
+		}
+	}
+	if *printModels {
+		return writeModels(conn, name, ids, out)
+	}
+	for _, id := range ids {
+		fmt.Fprintln(out, id)
+	}
+	return nil
+}
+
+// scoreRange returns the min and max arguments for ZRANGEBYSCORE for the given
+// filter operator and value.
+func scoreRange(op string, value string) (min string, max string, err error) {
+	switch op {
+	case "=":
+		return value, value, nil
+	case "<":
+		return "-inf", "(" + value, nil
+	case ">":
+		return "(" + value, "+inf", nil
+	case "<=":
+		return "-inf", value, nil
+	case ">=":
+		return value, "+inf", nil
+	}
+	return "", "", fmt.Errorf("invalid filter operator %q (should be one of =, <, >, <=, or >=)", op)
+}
+
+// lexRange returns the min and max arguments for ZRANGEBYLEX on a string index
+// for the given filter operator and value.
+func lexRange(op string, value string) (min string, max string, err error) {
+	// Every member which starts with value followed by a null byte is less
+	// than value followed by a null byte and the byte 0xFF.
+	upper := value + "\x00\xff"
+	switch op {
+	case "=":
+		return "[" + value, "(" + upper, nil
+	case "<":
+		return "-", "(" + value, nil
+	case ">":
+		return "(" + upper, "+", nil
+	case "<=":
+		return "-", "(" + upper, nil
+	case ">=":
+		return "[" + value, "+", nil
+	}
+	return "", "", fmt.Errorf("invalid filter operator %q (should be one of =, <, >, <=, or >=)", op)
+}
+
+// encodeInteger converts an int64 or uint64 value to the format used in
+// integer indexes, which sorts in the same order as the numbers: "1" followed
+// by the value padded to 20 digits for non-negative values, and "0" followed by
+// the nines' complement of the absolute value padded to 20 digits for negative
+// values.
+func encodeInteger(value string) (string, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n < 0 {
+		digits := []byte(padDigits(strconv.FormatInt(n, 10)[1:]))
+		for i, digit := range digits {
+			digits[i] = '9' - digit + '0'
+		}
+		return "0" + string(digits), nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid integer value %q", value)
+	}
+	return "1" + padDigits(strconv.FormatUint(n, 10)), nil
+}
+
+// encodeString escapes a value to the format used in string indexes, whose
+// members consist of the value, a null byte, and the id: null bytes are
+// replaced with the bytes 0x01 0x01 and 0x01 bytes with 0x01 0x02.
+func encodeString(value string) string {
+	value = strings.ReplaceAll(value, "\x01", "\x01\x02")
+	return strings.ReplaceAll(value, "\x00", "\x01\x01")
+}
+
+// padDigits pads digits with leading zeros to a length of 20, which is the
+// maximum number of digits in a uint64.
+func padDigits(digits string) string {
+	return strings.Repeat("0", 20-len(digits)) + digits
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File main.go contains the zoombench command-line tool, which runs a load
+// test against a Redis server with the zoombench package and prints the
+// throughput and latency of reads and writes.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/albrow/zoom"
+	"github.com/albrow/zoom/zoombench"
+)
+
+const usage = `Usage: zoombench [flags]
+
+zoombench saves and finds models against a Redis server from several
+goroutines at once and reports the number of operations per second and the
+latency of reads and writes. The models are saved in a collection named by the
+-name flag, which is emptied before and after the run, so use a database which
+does not contain data you want to keep.
+
+The flags are:
+`
+
+func main() {
+	defaults := zoombench.DefaultOptions
+	flags := flag.NewFlagSet("zoombench", flag.ExitOnError)
+	address := flags.String("address", zoom.DefaultPoolOptions.Address, "address of the Redis server")
+	network := flags.String("network", zoom.DefaultPoolOptions.Network, "network to use when connecting to Redis")
+	database := flags.Int("database", zoom.DefaultPoolOptions.Database, "Redis database to use")
+	password := flags.String("password", "", "password for the Redis server")
+	concurrency := flags.Int("concurrency", defaults.Concurrency, "number of goroutines sending operations")
+	duration := flags.Duration("duration", defaults.Duration, "how long to send operations for")
+	fields := flags.Int("fields", defaults.Fields, "number of unindexed string fields of each model")
+	fieldSize := flags.Int("field-size", defaults.FieldSize, "length of each string field in bytes")
+	indexes := flags.Int("indexes", defaults.Indexes, "number of indexed int64 fields of each model")
+	models := flags.Int("models", defaults.Models, "number of models to read and overwrite")
+	name := flags.String("name", defaults.Name, "name of the collection to use")
+	readRatio := flags.Float64("read-ratio", defaults.ReadRatio, "fraction of the operations which are reads (between 0 and 1)")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	options := defaults.
+		WithConcurrency(*concurrency).
+		WithDuration(*duration).
+		WithFields(*fields).
+		WithFieldSize(*fieldSize).
+		WithIndexes(*indexes).
+		WithModels(*models).
+		WithName(*name).
+		WithReadRatio(*readRatio)
+	poolOptions := zoom.DefaultPoolOptions.
+		WithAddress(*address).
+		WithNetwork(*network).
+		WithDatabase(*database).
+		WithPassword(*password)
+	// Make sure each goroutine can have its own connection.
+	if poolOptions.MaxActive != 0 && poolOptions.MaxActive < options.Concurrency {
+		poolOptions = poolOptions.WithMaxActive(options.Concurrency)
+	}
+	if poolOptions.MaxIdle < options.Concurrency {
+		poolOptions = poolOptions.WithMaxIdle(options.Concurrency)
+	}
+	pool := zoom.NewPoolWithOptions(poolOptions)
+	result, err := zoombench.Run(pool, options)
+	_ = pool.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := result.Report(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "zoombench: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
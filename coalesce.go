@@ -0,0 +1,79 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File coalesce.go contains code for removing redundant saves of the same
+// model from a transaction.
+
+package zoom
+
+import (
+	"sort"
+	"strings"
+)
+
+// CoalesceSaves marks the transaction so that, if the same fields of the same
+// model are saved more than once (with Save, SaveFields, or SaveChanged), only
+// the last save is sent to Redis, and returns the transaction. This is common
+// when a transaction is built from a stream of events, and coalescing the saves
+// reduces the size of bulk imports. Saves are only coalesced if every action
+// which was added to the transaction in between is another save, so that no
+// command or query in the transaction can observe the difference. Since only
+// the last save is sent, the audit log (see CollectionOptions.Audit) and the
+// outbox (see CollectionOptions.Outbox) only record the last save as well. The
+// saves of a coalescing transaction should not be added concurrently from
+// several goroutines.
+func (t *Transaction) CoalesceSaves() *Transaction {
+	t.coalesce = true
+	return t
+}
+
+// actionRange is the range of actions in a transaction which were added by a
+// single save, from start (inclusive) to end (exclusive).
+type actionRange struct {
+	start int
+	end   int
+}
+
+// beginSave returns the number of actions in the transaction before a save
+// adds its commands, which should be passed to endSave afterwards. If any
+// other actions were added since the last save, the saves before them can no
+// longer be coalesced.
+func (t *Transaction) beginSave() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.coalesce && len(t.actions) != t.savesEnd {
+		t.saves = nil
+	}
+	return len(t.actions)
+}
+
+// endSave records that the actions from start to the end of the transaction
+// saved the given fields of the model with the given id in collection c. If
+// the transaction coalesces saves (see CoalesceSaves) and the same fields of
+// the model were already saved, the actions of the earlier save are removed.
+func (t *Transaction) endSave(c *Collection, id string, fieldNames []string, start int) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if !t.coalesce {
+		return
+	}
+	sorted := append([]string{}, fieldNames...)
+	sort.Strings(sorted)
+	key := c.Name() + ":" + id + ":" + strings.Join(sorted, ",")
+	if prev, found := t.saves[key]; found {
+		n := prev.end - prev.start
+		t.actions = append(t.actions[:prev.start], t.actions[prev.end:]...)
+		start -= n
+		for otherKey, r := range t.saves {
+			if r.start >= prev.end {
+				t.saves[otherKey] = actionRange{start: r.start - n, end: r.end - n}
+			}
+		}
+	}
+	if t.saves == nil {
+		t.saves = map[string]actionRange{}
+	}
+	t.saves[key] = actionRange{start: start, end: len(t.actions)}
+	t.savesEnd = len(t.actions)
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File coalesce_test.go tests the code in coalesce.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesceSaves(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(2)
+	require.NoError(t, err)
+	a, b := models[0], models[1]
+
+	// Only the last save of a should be sent
+	tx := testPool.NewTransaction().CoalesceSaves()
+	a.Int = 100
+	tx.Save(indexedTestModels, a)
+	tx.Save(indexedTestModels, b)
+	a.Int = 200
+	tx.Save(indexedTestModels, a)
+	got, err := tx.DryRun()
+	require.NoError(t, err)
+	expectedTx := testPool.NewTransaction()
+	expectedTx.Save(indexedTestModels, b)
+	expectedTx.Save(indexedTestModels, a)
+	expected, err := expectedTx.DryRun()
+	require.NoError(t, err)
+	// The insertion times in the index of all models depend on when each
+	// transaction was built, so they are left out of the comparison.
+	withoutInsertionTimes := func(descriptions []CommandDescription) []CommandDescription {
+		for _, desc := range descriptions {
+			if desc.Name == "ZADD" && desc.Args[0] == indexedTestModels.IndexKey() {
+				desc.Args[2] = 0
+			}
+		}
+		return descriptions
+	}
+	assert.Equal(t, withoutInsertionTimes(expected), withoutInsertionTimes(got))
+
+	// The indexes should be up to date after the coalesced saves
+	tx = testPool.NewTransaction().CoalesceSaves()
+	a.Int = 300
+	tx.Save(indexedTestModels, a)
+	a.Int = 400
+	tx.Save(indexedTestModels, a)
+	tx.Save(indexedTestModels, a)
+	require.NoError(t, tx.Exec())
+	found := &indexedTestModel{}
+	require.NoError(t, indexedTestModels.Find(a.ID, found))
+	assert.Equal(t, a, found)
+	ids, err := indexedTestModels.NewQuery().Filter("Int =", 400).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{a.ID}, ids)
+	count, err := indexedTestModels.NewQuery().Filter("Int =", 300).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Saves should not be coalesced across other actions, saves of other
+	// fields, or in transactions which do not coalesce saves
+	numCommands := func(tx *Transaction) int {
+		descriptions, err := tx.DryRun()
+		require.NoError(t, err)
+		return len(descriptions)
+	}
+	single := testPool.NewTransaction()
+	single.Save(indexedTestModels, a)
+	singleCount := numCommands(single)
+	for _, tx := range []*Transaction{testPool.NewTransaction(), testPool.NewTransaction().CoalesceSaves()} {
+		tx.Save(indexedTestModels, a)
+		tx.Command("HGET", redis.Args{indexedTestModels.ModelKey(a.ID), "Int"}, nil)
+		tx.Save(indexedTestModels, a)
+		assert.Equal(t, 2*singleCount+1, numCommands(tx))
+	}
+	tx = testPool.NewTransaction().CoalesceSaves()
+	tx.SaveFields(indexedTestModels, []string{"Int", "String"}, a)
+	tx.SaveFields(indexedTestModels, []string{"String", "Int"}, a)
+	tx.SaveFields(indexedTestModels, []string{"Int"}, a)
+	fields := testPool.NewTransaction()
+	fields.SaveFields(indexedTestModels, []string{"Int", "String"}, a)
+	fields.SaveFields(indexedTestModels, []string{"Int"}, a)
+	assert.Equal(t, numCommands(fields), numCommands(tx))
+}
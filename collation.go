@@ -0,0 +1,158 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File collation.go contains code for string indexes which are ordered by a
+// collation (e.g. the rules of a particular language) instead of byte by byte.
+
+package zoom
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// CollationFunc converts the value of a string field to a sort key, so that
+// comparing the sort keys of two values byte by byte gives the order of the
+// values under some collation. Values which should be considered equal (e.g.
+// "resume" and "résumé" when accents are ignored) must have the same sort key.
+// The KeyFromString method of a collate.Collator from the
+// golang.org/x/text/collate package is a typical implementation. Since a
+// collate.Collator is not safe for concurrent use, it needs to be protected by
+// a mutex, e.g.:
+//
+//	var mut sync.Mutex
+//	collator := collate.New(language.Swedish)
+//	options := zoom.DefaultCollectionOptions.WithIndex(true).WithCollation("Name", func(value string) []byte {
+//	  mut.Lock()
+//	  defer mut.Unlock()
+//	  return collator.KeyFromString(&collate.Buffer{}, value)
+//	})
+//
+// A CollationFunc must be deterministic, since the indexes store the sort keys.
+// If the collation of a field changes, the index should be rebuilt with
+// Collection.BuildIndex.
+type CollationFunc func(value string) []byte
+
+// setCollations sets the collation function of each field in collations (a
+// map of field names to functions). It returns an error if any of the fields
+// cannot be collated.
+func (ms *modelSpec) setCollations(collations map[string]CollationFunc) error {
+	for fieldName, collate := range collations {
+		fs, found := ms.fieldsByName[fieldName]
+		if !found {
+			return newKindError(ErrFieldNotFound, "zoom: cannot set the collation of field %s because type %s has no field with that name", fieldName, ms.typ.String())
+		}
+		switch {
+		case collate == nil:
+			return fmt.Errorf("zoom: the collation of field %s in type %s cannot be nil", fieldName, ms.typ.String())
+		case fs.indexKind != stringIndex:
+			return newKindError(ErrUnindexedField, "zoom: cannot set the collation of field %s in type %s because it does not have a string index", fieldName, ms.typ.String())
+		case fs.hasBitmapIndex():
+			return fmt.Errorf("zoom: cannot set the collation of field %s in type %s because it has a bitmap index", fieldName, ms.typ.String())
+		case fs.ref != nil:
+			return fmt.Errorf("zoom: cannot set the collation of field %s in type %s because it has the ref option", fieldName, ms.typ.String())
+		}
+		fs.collate = collate
+	}
+	return nil
+}
+
+// collationKey returns the string which represents value in the collated
+// string index for fs. The sort key is hex encoded, which keeps the order of
+// the keys and ensures they do not contain the NULL character which separates
+// the value from the id in the members of string indexes.
+func (fs *fieldSpec) collationKey(value string) string {
+	return hex.EncodeToString(fs.collate(value))
+}
+
+// indexKindName returns the kind of the index on fs as it is passed to the
+// Lua scripts, which is "collated" for string indexes with a collation and the
+// name of the indexKind otherwise.
+func (fs *fieldSpec) indexKindName() string {
+	if fs.indexKind == stringIndex && fs.collate != nil {
+		return "collated"
+	}
+	return fs.indexKind.String()
+}
+
+// collatedMembersKey returns the key for the hash which maps the id of each
+// model to its member in the collated string index on fs. Since the collation
+// key cannot be computed by the Lua scripts, this is how they find the member
+// to remove when a model is updated or deleted.
+func (ms *modelSpec) collatedMembersKey(fs *fieldSpec) string {
+	return ms.indexKeyForField(fs) + ":collated"
+}
+
+// collatedFields returns the fields of ms which have a collated string index.
+func (ms *modelSpec) collatedFields() []*fieldSpec {
+	fields := []*fieldSpec{}
+	for _, fs := range ms.fieldsWithComputed() {
+		if fs.indexKind == stringIndex && fs.collate != nil {
+			fields = append(fields, fs)
+		}
+	}
+	return fields
+}
+
+// collateBound converts a ZRANGEBYLEX-style bound on the value of fs to a
+// bound on the collation keys if fs has a collation. Other bounds are returned
+// unchanged.
+func (fs *fieldSpec) collateBound(bound string) string {
+	if fs.collate == nil || bound == "-" || bound == "+" || len(bound) == 0 || (bound[0] != '[' && bound[0] != '(') {
+		return bound
+	}
+	return bound[:1] + fs.collationKey(bound[1:])
+}
+
+// addCollatedMember adds commands to the transaction which will add the model
+// with the given id and value for fs to the collated string index on fs. The
+// old member (if any) should be removed first with deleteStringIndex.
+func (t *Transaction) addCollatedMember(spec *modelSpec, fs *fieldSpec, id string, value string) {
+	member := fs.collationKey(value) + nullString + id
+	t.modelCommand(id, "ZADD", redis.Args{spec.indexKeyForField(fs), 0, member}, nil)
+	t.modelCommand(id, "HSET", redis.Args{spec.collatedMembersKey(fs), id, member}, nil)
+}
+
+// syncCollatedIndexes makes the collated string indexes on the given fields
+// consistent with the values in the main hashes of the models with the given
+// ids. Since the collation keys can only be computed in Go, the values are
+// read before the indexes are updated, so unlike SyncModelIndexes it is not
+// atomic.
+func (c *Collection) syncCollatedIndexes(ids []string, fields []*fieldSpec) error {
+	if len(ids) == 0 || len(fields) == 0 {
+		return nil
+	}
+	redisNames := make([]string, len(fields))
+	for i, fs := range fields {
+		redisNames[i] = fs.redisName
+	}
+	values := make([][]interface{}, len(ids))
+	t := c.pool.NewTransaction()
+	for i, id := range ids {
+		i := i
+		t.Command("HMGET", redis.Args{c.ModelKey(id)}.AddFlat(redisNames), func(reply interface{}) error {
+			var err error
+			values[i], err = redis.Values(reply, nil)
+			return err
+		})
+	}
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	t = c.pool.NewTransaction()
+	for i, id := range ids {
+		for j, fs := range fields {
+			t.deleteStringIndex(c.spec, id, fs)
+			value, err := redis.String(values[i][j], nil)
+			if err != nil || (fs.kind == pointerField && value == "NULL") {
+				// The model does not exist or the field is nil.
+				continue
+			}
+			t.addCollatedMember(c.spec, fs, id, value)
+		}
+	}
+	return t.Exec()
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File collation_test.go tests the code in collation.go
+
+package zoom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type collatedTestModel struct {
+	Name     string  `zoom:"index"`
+	Nickname *string `zoom:"index"`
+	Int      int     `zoom:"index"`
+	RandomID
+}
+
+// caseInsensitive is a collation which ignores case, so that e.g. "Bob" is
+// ordered between "alice" and "carol".
+func caseInsensitive(value string) []byte {
+	return []byte(strings.ToLower(value))
+}
+
+func TestCollation(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	options := DefaultCollectionOptions.WithIndex(true).WithCollation("Name", caseInsensitive).WithCollation("Nickname", caseInsensitive)
+	col, err := pool.NewCollectionWithOptions(&collatedTestModel{}, options)
+	require.NoError(t, err)
+	names := func(q *Query) []string {
+		models := []*collatedTestModel{}
+		require.NoError(t, q.Run(&models))
+		result := []string{}
+		for _, model := range models {
+			result = append(result, model.Name)
+		}
+		return result
+	}
+	nick := "BOBBY"
+	models := []*collatedTestModel{
+		{Name: "carol"},
+		{Name: "Bob", Nickname: &nick},
+		{Name: "alice"},
+		{Name: "Dave"},
+	}
+	tx := pool.NewTransaction()
+	for _, model := range models {
+		tx.Save(col, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	// Ordering and filters should use the collation
+	assert.Equal(t, []string{"alice", "Bob", "carol", "Dave"}, names(col.NewQuery().Order("Name")))
+	assert.Equal(t, []string{"Dave", "carol", "Bob", "alice"}, names(col.NewQuery().Order("-Name")))
+	assert.Equal(t, []string{"Bob"}, names(col.NewQuery().Filter("Name =", "BOB")))
+	assert.Equal(t, []string{"alice", "Bob"}, names(col.NewQuery().Filter("Name <", "C").Order("Name")))
+	assert.Equal(t, []string{"alice", "carol", "Dave"}, names(col.NewQuery().Filter("Name !=", "bob").Order("Name")))
+	assert.Equal(t, []string{"Bob", "carol"}, names(col.NewQuery().FilterRange("Name", "b", "D", false).Order("Name")))
+	assert.Equal(t, []string{"Bob"}, names(col.NewQuery().Filter("Nickname =", "bobby")))
+	count, err := col.NewQuery().Filter("Name >=", "CAROL").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	tx = pool.NewTransaction()
+	tx.ExtractIDsByLexRange(col, "Name", "collatedIDs", "[B", "(d")
+	ids := []string{}
+	tx.Command("ZRANGE", redis.Args{"collatedIDs", 0, -1}, NewScanStringsHandler(&ids))
+	require.NoError(t, tx.Exec())
+	assert.Equal(t, []string{models[1].ID, models[0].ID}, ids)
+
+	// Saving, updating, and deleting models should replace their members
+	indexKey, err := col.FieldIndexKey("Name")
+	require.NoError(t, err)
+	checkMembers := func(expected int) {
+		conn := pool.NewConn()
+		defer func() {
+			_ = conn.Close()
+		}()
+		card, err := redis.Int(conn.Do("ZCARD", indexKey))
+		require.NoError(t, err)
+		assert.Equal(t, expected, card)
+		hlen, err := redis.Int(conn.Do("HLEN", col.spec.collatedMembersKey(col.spec.fieldsByName["Name"])))
+		require.NoError(t, err)
+		assert.Equal(t, expected, hlen)
+	}
+	models[2].Name = "Erin"
+	require.NoError(t, col.Save(models[2]))
+	checkMembers(4)
+	updated, err := col.NewQuery().Filter("Name =", "DAVE").Update(map[string]interface{}{"Name": "aaron"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	old := &collatedTestModel{}
+	require.NoError(t, col.GetSet(models[1].ID, map[string]interface{}{"Name": "Zed", "Nickname": (*string)(nil)}, old))
+	assert.Equal(t, "Bob", old.Name)
+	assert.Equal(t, []string{"aaron", "carol", "Erin", "Zed"}, names(col.NewQuery().Order("Name")))
+	assert.Empty(t, names(col.NewQuery().Filter("Nickname =", "bobby")))
+	checkMembers(4)
+	_, err = col.Delete(models[0].ID)
+	require.NoError(t, err)
+	checkMembers(3)
+	deleted, err := col.NewQuery().Filter("Name <", "b").Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	checkMembers(2)
+
+	// Indexes should be synced with values written outside of Zoom
+	require.NoError(t, col.SetRawField(models[2].ID, "Name", "Anna"))
+	conn := pool.NewConn()
+	_, err = conn.Do("HSET", col.ModelKey(models[1].ID), "Name", "adam")
+	_ = conn.Close()
+	require.NoError(t, err)
+	require.NoError(t, col.syncCollatedIndexes([]string{models[1].ID, models[2].ID}, col.spec.collatedFields()))
+	assert.Equal(t, []string{"adam", "Anna"}, names(col.NewQuery().Order("Name")))
+	checkMembers(2)
+
+	// Collations are only allowed on fields with a string index
+	_, err = pool.NewCollectionWithOptions(&collatedTestModel{}, options.WithName("badCollatedTestModel").WithCollation("Int", caseInsensitive))
+	assert.Error(t, err)
+	_, err = pool.NewCollectionWithOptions(&collatedTestModel{}, options.WithName("badCollatedTestModel").WithCollation("Missing", caseInsensitive))
+	assert.Error(t, err)
+	_, err = pool.NewCollectionWithOptions(&collatedTestModel{}, options.WithName("badCollatedTestModel").WithCollation("Name", nil))
+	assert.Error(t, err)
+	_, err = pool.NewDynamicCollection(Schema{Name: "dynamicCollatedTestModel"}, options)
+	assert.Error(t, err)
+	assert.Error(t, col.NewQuery().Join("Name", col).Run(&[]*collatedTestModel{}))
+}
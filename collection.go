@@ -13,23 +13,77 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
-var collections = list.New()
+var (
+	// collections is the list of all registered collections in all pools. It
+	// is used to find the collection for a model when no pool is available.
+	collections    = list.New()
+	collectionsMut sync.RWMutex
+)
 
 // Collection represents a specific registered type of model. It has methods
 // for saving, finding, and deleting models of a specific type. Use the
 // NewCollection method to create a new collection.
 type Collection struct {
-	spec  *modelSpec
-	pool  *Pool
-	index bool
+	spec         *modelSpec
+	pool         *Pool
+	index        bool
+	rediSearch   bool
+	redisTime    bool
+	audit        bool
+	auditMaxLen  int
+	outbox       bool
+	outboxMaxLen int
+	strictScan   bool
+	defaultScope func(q *Query)
+	// defaultOrder is the order of FindAll and queries without an Order (see
+	// CollectionOptions.DefaultOrderField).
+	defaultOrder order
+	eviction     EvictionOptions
+	// sortedIndex is true iff the index of all models is created as a sorted
+	// set when it does not exist yet (see CollectionOptions.SortedIndex). An
+	// existing index keeps its type, which is checked whenever it is used.
+	sortedIndex bool
 }
 
 // CollectionOptions contains various options for a pool.
 type CollectionOptions struct {
+	// If Audit is true, Zoom will record a change in the audit log of a model
+	// each time it is saved with Save or SaveFields or deleted with Delete. The
+	// audit log for each model is kept in a capped Redis stream and can be read
+	// with Collection.History. Bulk operations (DeleteAll, Query.Delete, and
+	// Query.Update) are not recorded.
+	Audit bool
+	// AuditMaxLen is the number of changes kept in the audit log for each model.
+	// Older changes are removed. If AuditMaxLen is 0, DefaultAuditMaxLen is
+	// used. It has no effect if Audit is false.
+	AuditMaxLen int
+	// Collations maps the names of fields with a string index to the
+	// CollationFunc which is used to order them (see WithCollation). Fields
+	// which are not in the map are ordered byte by byte.
+	Collations map[string]CollationFunc
+	// DefaultOrderField, if not empty, is the name of an indexed field by which
+	// FindAll, FindAllInBatches, ForEachBatch, and queries without an Order
+	// sort the models, e.g. "CreatedAt". Like the argument to Query.Order, it
+	// may start with a "-" to sort in descending order. The models are sorted
+	// server-side using the index on the field, which must not be a pointer. A
+	// query with an Order is sorted by that field instead.
+	DefaultOrderField string
+	// DefaultScope, if not nil, is called with every query created for the
+	// collection with NewQuery or Transaction.Query, so that modifiers which
+	// apply to almost every query (e.g. Filter("Deleted =", false) or a filter
+	// on the tenant) do not have to be repeated everywhere. Use Unscoped to
+	// remove them from a single query. Queries created with UnmarshalQuery
+	// already include the modifiers of the scope, so it is not applied again.
+	DefaultScope func(q *Query)
+	// Eviction, if MaxModels or MaxAge is not 0, bounds the size of the
+	// collection, so that it can be used as a cache (see EvictionOptions).
+	Eviction EvictionOptions
 	// FallbackMarshalerUnmarshaler is used to marshal/unmarshal any type into a
 	// slice of bytes which is suitable for storing in the database. If Zoom does
 	// not know how to directly encode a certain type into bytes, it will use the
@@ -37,12 +91,43 @@ type CollectionOptions struct {
 	// JSONMarshalerUnmarshaler out of the box. You are also free to write your
 	// own implementation.
 	FallbackMarshalerUnmarshaler MarshalerUnmarshaler
+	// If Outbox is true, each transaction which saves a model with Save or
+	// SaveFields or deletes a model with Delete will also append an event
+	// describing the mutation to a Redis stream for the collection (see
+	// OutboxKey). Since the event is appended inside the same transaction, it is
+	// published iff the mutation is committed. Downstream consumers can read the
+	// events with ReadOutbox (or with XREADGROUP directly). Bulk operations
+	// (DeleteAll, Query.Delete, and Query.Update) do not append events.
+	Outbox bool
+	// OutboxMaxLen is the approximate number of events kept in the outbox.
+	// Older events are removed, even if they have not been read. If OutboxMaxLen
+	// is 0, DefaultOutboxMaxLen is used. It has no effect if Outbox is false.
+	OutboxMaxLen int
 	// If Index is true, any model in the collection that is saved will be added
-	// to a set in Redis which acts as an index on all models in the collection.
-	// The key for the set is exposed via the IndexKey method. Queries and the
-	// FindAll, Count, and DeleteAll methods will not work for unindexed
-	// collections. This may change in future versions.
+	// to a set (or sorted set, see SortedIndex) in Redis which acts as an index
+	// on all models in the collection. The key for the set is exposed via the
+	// IndexKey method. Queries and the FindAll, Count, and DeleteAll methods
+	// will not work for unindexed collections. This may change in future
+	// versions.
 	Index bool
+	// If IndexNamespace is true, the keys of the field indexes (and all the
+	// other keys which belong to them, e.g. null and enum indexes) start with
+	// "<name>:index:" instead of "<name>:", e.g. "User:index:Age" instead of
+	// "User:Age". Without it, the key of the index on a field is the same as
+	// the key of the main hash of a model whose id is the name of the field as
+	// it is stored in Redis, so that saving such a model would corrupt the
+	// index. IndexNamespace defaults to false to stay compatible with existing
+	// data. Use Collection.MigrateIndexNamespace to move the existing indexes
+	// of a collection to the new keys before enabling it.
+	IndexNamespace bool
+	// Layout determines how the fields of the models are stored. The default
+	// HashLayout stores each field in its own field of a hash, while
+	// DocumentLayout stores all of them as a single JSON document, which is
+	// more compact but does not support updating individual fields (see
+	// DocumentLayout), and JSONLayout stores them as a RedisJSON document (see
+	// JSONLayout). The layout of a collection which already has models
+	// cannot be changed, since existing models would not be readable.
+	Layout Layout
 	// Name is a unique string identifier to use for the collection in Redis. All
 	// models in this collection that are saved in the database will use the
 	// collection name as a prefix. If Name is an empty string, Zoom will use the
@@ -51,13 +136,121 @@ type CollectionOptions struct {
 	// name corresponding to *models.User would be "User". If a custom name is
 	// provided, it cannot contain a colon.
 	Name string
+	// NewModel, if not nil, is used to allocate the models which are scanned
+	// into a slice (e.g. by FindAll or Query.Run) when the slice is not long
+	// enough, instead of allocating a new model each time. Together with
+	// ReleaseModel, it allows high-throughput readers to recycle models (e.g.
+	// with a sync.Pool) and reduce the pressure on the garbage collector.
+	// NewModel must return a pointer to a model of the type of the collection
+	// with all of its fields set to their zero values, since only the fields
+	// which are read are set. It is not supported for dynamic collections.
+	NewModel func() Model
+	// ReleaseModel, if not nil, is called with each model which is passed to
+	// Collection.ReleaseModels, so that the model can be reused by NewModel
+	// (e.g. by resetting it and putting it back into a sync.Pool). The model
+	// must not be used after it has been released. It is not supported for
+	// dynamic collections.
+	ReleaseModel func(Model)
+	// If SortedIndex is true, the index of all models (see Index) is a sorted
+	// set scored by the time each model was first saved, in milliseconds, rather
+	// than a set. FindAll and queries without an order (or DefaultOrderField)
+	// then return models in the order they were first saved, so that e.g.
+	// Query.Last returns the most recently saved models, and DeleteSavedBetween
+	// can delete models by the time they were saved. If the index of an
+	// existing collection is already a set in Redis, it remains a set (see
+	// Collection.SortedIndex) until it is converted with
+	// Collection.MigrateToSortedIndex. SortedIndex has no effect if Index is
+	// false.
+	SortedIndex bool
+	// If StrictScan is true, Find, FindFields, FindAll, and the Run, RunOne,
+	// First, LastOne, and RunExactlyOne query methods also compare the fields
+	// in the main hash of each model they read with the fields of the model
+	// type. If any hash has fields the model type does not have (e.g. because
+	// they were written by an older version of the type or by another
+	// application) or lacks fields the model type has, they return a
+	// StrictScanError after scanning the models, so that schema drift is
+	// detected at read time. Models read from the in-process cache are not
+	// checked.
+	StrictScan bool
+	// If UseJSONTags is true, the name in the json struct tag of a field (e.g.
+	// "first_name" for `json:"first_name,omitempty"`) is used as the name of
+	// the field in Redis if the field does not have a redis struct tag. This
+	// makes it easier for applications written in other languages, which
+	// usually expect the same names as the JSON encoding, to read the models.
+	// The redis tag always takes precedence, and fields whose json tag is "-"
+	// or does not have a name use the name of the field as usual.
+	UseJSONTags bool
+	// If UseRediSearch is true, Zoom will create a RediSearch index (using
+	// FT.CREATE) for the collection when it is created, with a schema derived
+	// from the indexed and full-text fields of the model type. Queries which can
+	// be expressed with the RediSearch query syntax will then be executed with
+	// FT.SEARCH instead of the default sorted set indexes, which are still
+	// maintained and used for all other queries. UseRediSearch requires Index to
+	// be true and a Redis server with the RediSearch module (e.g. Redis Stack).
+	UseRediSearch bool
+	// If UseRedisTime is true, the CreatedAt and UpdatedAt fields of models
+	// which embed Timestamps are set using the clock of the Redis server (via
+	// the TIME command) instead of the local clock. This is useful if models are
+	// saved by processes running on different machines whose clocks may not be
+	// in sync, but it costs an extra round trip each time a model is saved.
+	UseRedisTime bool
 }
 
 // DefaultCollectionOptions is the default set of options for a collection.
 var DefaultCollectionOptions = CollectionOptions{
 	FallbackMarshalerUnmarshaler: GobMarshalerUnmarshaler,
-	Index: false,
-	Name:  "",
+	Index:       false,
+	Name:        "",
+	SortedIndex: true,
+}
+
+// WithAudit returns a new copy of the options with the Audit property set to
+// the given value. It does not mutate the original options.
+func (options CollectionOptions) WithAudit(audit bool) CollectionOptions {
+	options.Audit = audit
+	return options
+}
+
+// WithAuditMaxLen returns a new copy of the options with the AuditMaxLen
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithAuditMaxLen(maxLen int) CollectionOptions {
+	options.AuditMaxLen = maxLen
+	return options
+}
+
+// WithCollation returns a new copy of the options with the Collations
+// property mapping fieldName to collate. It does not mutate the original
+// options.
+func (options CollectionOptions) WithCollation(fieldName string, collate CollationFunc) CollectionOptions {
+	collations := map[string]CollationFunc{}
+	for name, fn := range options.Collations {
+		collations[name] = fn
+	}
+	collations[fieldName] = collate
+	options.Collations = collations
+	return options
+}
+
+// WithDefaultOrderField returns a new copy of the options with the
+// DefaultOrderField property set to the given value. It does not mutate the
+// original options.
+func (options CollectionOptions) WithDefaultOrderField(fieldName string) CollectionOptions {
+	options.DefaultOrderField = fieldName
+	return options
+}
+
+// WithDefaultScope returns a new copy of the options with the DefaultScope
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithDefaultScope(scope func(q *Query)) CollectionOptions {
+	options.DefaultScope = scope
+	return options
+}
+
+// WithEviction returns a new copy of the options with the Eviction property
+// set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithEviction(eviction EvictionOptions) CollectionOptions {
+	options.Eviction = eviction
+	return options
 }
 
 // WithFallbackMarshalerUnmarshaler returns a new copy of the options with the
@@ -75,6 +268,84 @@ func (options CollectionOptions) WithIndex(index bool) CollectionOptions {
 	return options
 }
 
+// WithIndexNamespace returns a new copy of the options with the
+// IndexNamespace property set to the given value. It does not mutate the
+// original options.
+func (options CollectionOptions) WithIndexNamespace(indexNamespace bool) CollectionOptions {
+	options.IndexNamespace = indexNamespace
+	return options
+}
+
+// WithLayout returns a new copy of the options with the Layout property set to
+// the given value. It does not mutate the original options.
+func (options CollectionOptions) WithLayout(layout Layout) CollectionOptions {
+	options.Layout = layout
+	return options
+}
+
+// WithOutbox returns a new copy of the options with the Outbox property set to
+// the given value. It does not mutate the original options.
+func (options CollectionOptions) WithOutbox(outbox bool) CollectionOptions {
+	options.Outbox = outbox
+	return options
+}
+
+// WithOutboxMaxLen returns a new copy of the options with the OutboxMaxLen
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithOutboxMaxLen(maxLen int) CollectionOptions {
+	options.OutboxMaxLen = maxLen
+	return options
+}
+
+// WithNewModel returns a new copy of the options with the NewModel property
+// set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithNewModel(newModel func() Model) CollectionOptions {
+	options.NewModel = newModel
+	return options
+}
+
+// WithReleaseModel returns a new copy of the options with the ReleaseModel
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithReleaseModel(releaseModel func(Model)) CollectionOptions {
+	options.ReleaseModel = releaseModel
+	return options
+}
+
+// WithSortedIndex returns a new copy of the options with the SortedIndex
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithSortedIndex(sortedIndex bool) CollectionOptions {
+	options.SortedIndex = sortedIndex
+	return options
+}
+
+// WithStrictScan returns a new copy of the options with the StrictScan
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithStrictScan(strictScan bool) CollectionOptions {
+	options.StrictScan = strictScan
+	return options
+}
+
+// WithUseJSONTags returns a new copy of the options with the UseJSONTags
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithUseJSONTags(useJSONTags bool) CollectionOptions {
+	options.UseJSONTags = useJSONTags
+	return options
+}
+
+// WithUseRediSearch returns a new copy of the options with the UseRediSearch
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithUseRediSearch(useRediSearch bool) CollectionOptions {
+	options.UseRediSearch = useRediSearch
+	return options
+}
+
+// WithUseRedisTime returns a new copy of the options with the UseRedisTime
+// property set to the given value. It does not mutate the original options.
+func (options CollectionOptions) WithUseRedisTime(useRedisTime bool) CollectionOptions {
+	options.UseRedisTime = useRedisTime
+	return options
+}
+
 // WithName returns a new copy of the options with the Name property set to the
 // given value. It does not mutate the original options.
 func (options CollectionOptions) WithName(name string) CollectionOptions {
@@ -88,48 +359,122 @@ func (options CollectionOptions) WithName(name string) CollectionOptions {
 // to a struct. NewCollection will use all the default options for the
 // collection, which are specified in DefaultCollectionOptions. If you want to
 // specify different options, use the NewCollectionWithOptions method.
+//
+// NewCollection is safe to call from multiple goroutines. The same model type
+// can be registered in any number of pools (e.g. one for each database in a
+// multi-tenant setup).
 func (p *Pool) NewCollection(model Model) (*Collection, error) {
 	return p.NewCollectionWithOptions(model, DefaultCollectionOptions)
 }
 
 // NewCollectionWithOptions registers and returns a new collection of the given
-// model type and with the provided options.
+// model type and with the provided options. If options.Name is not empty, the
+// model type may already be registered with the pool under a different name.
+// This allows the same type to be stored in more than one collection, e.g. one
+// for each tenant, although Transaction.Watch and NewScanModelHandler cannot
+// tell such collections apart.
 func (p *Pool) NewCollectionWithOptions(model Model, options CollectionOptions) (*Collection, error) {
 	typ := reflect.TypeOf(model)
 	// If options.Name is empty use the name of the concrete model type (without
 	// the package prefix).
-	if options.Name == "" {
+	customName := options.Name != ""
+	if !customName {
 		options.Name = getDefaultModelSpecName(typ)
 	} else if strings.Contains(options.Name, ":") {
 		return nil, fmt.Errorf("zoom: CollectionOptions.Name cannot contain a colon. Got: %s", options.Name)
 	}
 
-	// Make sure the name and type have not been previously registered
+	// Make sure the name and type have not been previously registered. This is
+	// checked again when the collection is added to the maps, since the lock is
+	// not held while Redis is accessed below.
+	p.registryMut.RLock()
+	err := p.checkRegistrationLocked(model, options.Name, customName)
+	p.registryMut.RUnlock()
+	if err != nil {
+		return nil, err
+	}
 	switch {
-	case p.typeIsRegistered(typ):
-		return nil, fmt.Errorf("zoom: Error in NewCollection: The type %T has already been registered", model)
-	case p.nameIsRegistered(options.Name):
-		return nil, fmt.Errorf("zoom: Error in NewCollection: The name %s has already been registered", options.Name)
 	case !typeIsPointerToStruct(typ):
 		return nil, fmt.Errorf("zoom: NewCollection requires a pointer to a struct as an argument. Got type %T", model)
+	case options.UseRediSearch && !options.Index:
+		return nil, fmt.Errorf("zoom: Error in NewCollection: CollectionOptions.UseRediSearch requires CollectionOptions.Index to be true")
+	case options.AuditMaxLen < 0:
+		return nil, fmt.Errorf("zoom: Error in NewCollection: CollectionOptions.AuditMaxLen cannot be negative. Got: %d", options.AuditMaxLen)
+	case options.OutboxMaxLen < 0:
+		return nil, fmt.Errorf("zoom: Error in NewCollection: CollectionOptions.OutboxMaxLen cannot be negative. Got: %d", options.OutboxMaxLen)
+	}
+	if err := options.Eviction.validate(); err != nil {
+		return nil, fmt.Errorf("zoom: Error in NewCollection: %w", err)
+	}
+	if options.AuditMaxLen == 0 {
+		options.AuditMaxLen = DefaultAuditMaxLen
+	}
+	if options.OutboxMaxLen == 0 {
+		options.OutboxMaxLen = DefaultOutboxMaxLen
 	}
 
 	// Compile the spec for this model and store it in the maps
-	spec, err := compileModelSpec(typ)
+	spec, err := compileModelSpecWithOptions(typ, options)
 	if err != nil {
 		return nil, err
 	}
 	spec.name = options.Name
 	spec.fallback = options.FallbackMarshalerUnmarshaler
-	p.modelTypeToSpec[typ] = spec
-	p.modelNameToSpec[options.Name] = spec
+	spec.indexNamespace = options.IndexNamespace
+	spec.newModel = options.NewModel
+	spec.releaseModel = options.ReleaseModel
+	if err := spec.setCollations(options.Collations); err != nil {
+		return nil, fmt.Errorf("zoom: Error in NewCollection: %w", err)
+	}
+	if err := spec.setLayout(options); err != nil {
+		return nil, fmt.Errorf("zoom: Error in NewCollection: %w", err)
+	}
+	defaultOrder, err := spec.parseDefaultOrder(options.DefaultOrderField)
+	if err != nil {
+		return nil, fmt.Errorf("zoom: Error in NewCollection: %w", err)
+	}
 
 	collection := &Collection{
-		spec:  spec,
-		pool:  p,
-		index: options.Index,
+		spec:         spec,
+		pool:         p,
+		index:        options.Index,
+		rediSearch:   options.UseRediSearch,
+		redisTime:    options.UseRedisTime,
+		audit:        options.Audit,
+		auditMaxLen:  options.AuditMaxLen,
+		outbox:       options.Outbox,
+		outboxMaxLen: options.OutboxMaxLen,
+		strictScan:   options.StrictScan,
+		defaultScope: options.DefaultScope,
+		defaultOrder: defaultOrder,
+		eviction:     options.Eviction,
+		sortedIndex:  options.Index && options.SortedIndex,
+	}
+	if collection.rediSearch {
+		if err := collection.EnsureSearchIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Hold the lock from the final check until the collection is added to the
+	// maps so that concurrent registrations cannot use the same name.
+	p.registryMut.Lock()
+	if err := p.checkRegistrationLocked(model, options.Name, customName); err != nil {
+		p.registryMut.Unlock()
+		return nil, err
+	}
+	if !p.typeIsRegisteredLocked(typ) {
+		p.modelTypeToSpec[typ] = spec
 	}
+	p.modelNameToSpec[options.Name] = spec
+	p.modelNameToCollection[options.Name] = collection
+	p.registryMut.Unlock()
 	addCollection(collection)
+	// Record the schema for the collection unless a previous registration
+	// already did. Registration does not otherwise require Redis to be
+	// reachable, so if this fails the schema is stored by CheckSchemaDrift
+	// instead.
+	_ = p.storeSchema(spec, false)
 	return collection, nil
 }
 
@@ -140,9 +485,34 @@ func (c *Collection) Name() string {
 	return c.spec.name
 }
 
+// NewModel returns a new, empty model of the type registered for the
+// collection, e.g. to decode a model from another format before saving it.
+func (c *Collection) NewModel() Model {
+	return reflect.New(c.spec.typ.Elem()).Interface().(Model)
+}
+
+// Pool returns the pool with which the collection was registered.
+func (c *Collection) Pool() *Pool {
+	return c.pool
+}
+
+// Collection returns the collection which was registered with the pool under
+// the given name, and false if there is none.
+func (p *Pool) Collection(name string) (*Collection, bool) {
+	p.registryMut.RLock()
+	defer p.registryMut.RUnlock()
+	if !p.nameIsRegisteredLocked(name) {
+		return nil, false
+	}
+	collection, found := p.modelNameToCollection[name]
+	return collection, found
+}
+
 // addCollection adds the given spec to the list of collections iff it has not
 // already been added.
 func addCollection(collection *Collection) {
+	collectionsMut.Lock()
+	defer collectionsMut.Unlock()
 	for e := collections.Front(); e != nil; e = e.Next() {
 		otherCollection := e.Value.(*Collection)
 		if collection.spec.typ == otherCollection.spec.typ {
@@ -155,9 +525,12 @@ func addCollection(collection *Collection) {
 }
 
 // getCollectionForModel returns the Collection corresponding to the type of
-// model.
+// model. If the type was registered more than once, it returns the first
+// collection that was registered.
 func getCollectionForModel(model Model) (*Collection, error) {
 	typ := reflect.TypeOf(model)
+	collectionsMut.RLock()
+	defer collectionsMut.RUnlock()
 	for e := collections.Front(); e != nil; e = e.Next() {
 		col := e.Value.(*Collection)
 		if col.spec.typ == typ {
@@ -167,12 +540,62 @@ func getCollectionForModel(model Model) (*Collection, error) {
 	return nil, fmt.Errorf("Could not find Collection for type %T", model)
 }
 
+// collectionForModel returns the Collection registered with p which
+// corresponds to the type of model. It returns an error if the type was
+// registered with p under more than one name. If the type was not registered
+// with p at all, it falls back to getCollectionForModel.
+func (p *Pool) collectionForModel(model Model) (*Collection, error) {
+	typ := reflect.TypeOf(model)
+	p.registryMut.RLock()
+	defer p.registryMut.RUnlock()
+	var found *Collection
+	for name, spec := range p.modelNameToSpec {
+		if spec.typ != typ {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("zoom: type %T is registered under more than one name", model)
+		}
+		found = p.modelNameToCollection[name]
+	}
+	if found == nil {
+		return getCollectionForModel(model)
+	}
+	return found, nil
+}
+
 func (p *Pool) typeIsRegistered(typ reflect.Type) bool {
+	p.registryMut.RLock()
+	defer p.registryMut.RUnlock()
+	return p.typeIsRegisteredLocked(typ)
+}
+
+// checkRegistrationLocked returns an error if a collection for model cannot be
+// registered with the given name because the name (or, unless customName is
+// true, the type of model) has already been registered. The caller must hold
+// registryMut.
+func (p *Pool) checkRegistrationLocked(model Model, name string, customName bool) error {
+	switch {
+	case !customName && p.typeIsRegisteredLocked(reflect.TypeOf(model)):
+		return fmt.Errorf("zoom: Error in NewCollection: The type %T has already been registered", model)
+	case p.nameIsRegisteredLocked(name):
+		return fmt.Errorf("zoom: Error in NewCollection: The name %s has already been registered", name)
+	}
+	return nil
+}
+
+func (p *Pool) typeIsRegisteredLocked(typ reflect.Type) bool {
 	_, found := p.modelTypeToSpec[typ]
 	return found
 }
 
 func (p *Pool) nameIsRegistered(name string) bool {
+	p.registryMut.RLock()
+	defer p.registryMut.RUnlock()
+	return p.nameIsRegisteredLocked(name)
+}
+
+func (p *Pool) nameIsRegisteredLocked(name string) bool {
 	_, found := p.modelNameToSpec[name]
 	return found
 }
@@ -260,47 +683,149 @@ func (t *Transaction) Save(c *Collection, model Model) {
 		return
 	}
 	if err := c.checkModelType(model); err != nil {
-		t.setError(fmt.Errorf("zoom: Error in Save or Transaction.Save: %s", err.Error()))
+		t.setError(fmt.Errorf("zoom: Error in Save or Transaction.Save: %w", err))
 		return
 	}
-	// Create a modelRef and start a transaction
 	mr := &modelRef{
 		collection: c,
 		model:      model,
 		spec:       c.spec,
 	}
+	// Set the id of models with a primary field before anything else reads it
+	if err := t.setPrimaryKey(mr); err != nil {
+		t.setError(err)
+		return
+	}
+	// Set the timestamps (if any) before anything else reads the field values
+	if ts := c.modelTimestamps(model); ts != nil {
+		now, err := t.timestamp(c)
+		if err != nil {
+			t.setError(err)
+			return
+		}
+		if ts.CreatedAt == 0 {
+			ts.CreatedAt = now
+		}
+		ts.UpdatedAt = now
+	}
+	start := t.beginSave()
+	t.saveModelRef(mr)
+	t.endSave(c, model.ModelID(), c.spec.allFieldNames(), start)
+}
+
+// saveModelRef adds commands to the transaction for saving all the fields of
+// the model behind mr, including its indexes. The type of the model should
+// already have been checked.
+func (t *Transaction) saveModelRef(mr *modelRef) {
+	c := mr.collection
+	model := mr.model
+	fieldNames := mr.spec.fieldNames()
+	if err := t.checkProtectedFields(mr, fieldNames); err != nil {
+		t.setError(err)
+		return
+	}
 	// Save indexes
 	// This must happen first, because it relies on reading the old field values
 	// from the hash for string indexes (if any)
 	t.saveFieldIndexes(mr)
+	t.saveFullTextIndexes(fieldNames, mr)
 	// Save the model fields in a hash in the database
 	hashArgs, err := mr.mainHashArgs()
 	if err != nil {
 		t.setError(err)
 	}
-	if len(hashArgs) > 1 {
+	if mr.spec.document {
+		// Save all the fields as a single document
+		t.saveDocument(mr, hashArgs)
+	} else if len(hashArgs) > 1 {
 		// Only save the main hash if there are any fields
 		// The first element in hashArgs is the model key,
 		// so there are fields if the length is greater than
 		// 1.
-		t.Command("HMSET", hashArgs, nil)
+		t.modelCommand(model.ModelID(), "HMSET", hashArgs, nil)
+		t.expireFields(mr.spec, model.ModelID(), hashArgs)
 	}
+	// Save any fields which are stored in their own key
+	t.saveKeyFields(mr)
 	// Add the model id to the set of all models for this collection
 	if c.index {
-		t.Command("SADD", redis.Args{c.IndexKey(), model.ModelID()}, nil)
+		t.addToIndex(c, model.ModelID())
+	}
+	allFieldNames := c.spec.allFieldNames()
+	t.recordChange(c, model.ModelID(), ChangeSave, allFieldNames)
+	t.publishEvent(c, ChangeSave, model.ModelID(), hashArgs)
+	t.invalidateCachedModel(c, model.ModelID())
+	t.trackEviction(c, model.ModelID())
+	t.recordSavedFields(mr, allFieldNames)
+}
+
+// modelTimestamps returns the embedded Timestamps of model, or nil if model
+// does not embed Timestamps or the timestamp fields are not saved (e.g.
+// because of a `redis:"-"` struct tag).
+func (c *Collection) modelTimestamps(model Model) *Timestamps {
+	if !c.hasTimestamps() {
+		return nil
+	}
+	return model.(timestamped).timestamps()
+}
+
+// withUpdatedAt returns fieldValues with UpdatedAt set to the current time if
+// the model type for c has timestamps and fieldValues does not already include
+// UpdatedAt. The given map is not mutated.
+func (t *Transaction) withUpdatedAt(c *Collection, fieldValues map[string]interface{}) (map[string]interface{}, error) {
+	if _, found := fieldValues["UpdatedAt"]; found || !c.hasTimestamps() {
+		return fieldValues, nil
+	}
+	now, err := t.timestamp(c)
+	if err != nil {
+		return nil, err
+	}
+	withTimestamp := map[string]interface{}{"UpdatedAt": now}
+	for fieldName, value := range fieldValues {
+		withTimestamp[fieldName] = value
+	}
+	return withTimestamp, nil
+}
+
+// hasTimestamps returns true iff the model type for c embeds Timestamps and
+// the timestamp fields are saved.
+func (c *Collection) hasTimestamps() bool {
+	if _, ok := reflect.Zero(c.spec.typ).Interface().(timestamped); !ok {
+		return false
+	}
+	_, found := c.spec.fieldsByName["UpdatedAt"]
+	return found
+}
+
+// timestamp returns the current time as the number of nanoseconds since the
+// Unix epoch. If c uses Redis time, timestamp sends the TIME command to Redis
+// immediately. Otherwise it uses the local clock.
+func (t *Transaction) timestamp(c *Collection) (int64, error) {
+	if !c.redisTime {
+		return time.Now().UnixNano(), nil
+	}
+	t.mut.Lock()
+	reply, err := redis.Ints(t.conn.Do("TIME"))
+	t.mut.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("zoom: could not get time from Redis: %w", err)
+	}
+	if len(reply) != 2 {
+		return 0, fmt.Errorf("zoom: unexpected reply from TIME command: %v", reply)
 	}
+	return int64(reply[0])*int64(time.Second) + int64(reply[1])*int64(time.Microsecond), nil
 }
 
 // saveFieldIndexes adds commands to the transaction for saving the indexes
 // for all indexed fields.
 func (t *Transaction) saveFieldIndexes(mr *modelRef) {
-	t.saveFieldIndexesForFields(mr.spec.fieldNames(), mr)
+	t.saveFieldIndexesForFields(mr.spec.fieldNamesWithComputed(), mr)
 }
 
 // saveFieldIndexesForFields works like saveFieldIndexes, but only saves the
 // indexes for the given fieldNames.
 func (t *Transaction) saveFieldIndexesForFields(fieldNames []string, mr *modelRef) {
-	for _, fs := range mr.spec.fields {
+	for _, fs := range mr.spec.fieldsWithComputed() {
 		// Skip fields whose names do not appear in fieldNames.
 		if !stringSliceContains(fieldNames, fs.name) {
 			continue
@@ -312,10 +837,16 @@ func (t *Transaction) saveFieldIndexesForFields(fieldNames []string, mr *modelRe
 			t.saveNumericIndex(mr, fs)
 		case booleanIndex:
 			t.saveBooleanIndex(mr, fs)
-		case stringIndex:
+		case stringIndex, integerIndex:
 			t.saveStringIndex(mr, fs)
+		case enumIndex:
+			t.saveEnumIndex(mr, fs)
+		}
+		if fs.hasNullIndex() {
+			t.saveNullIndex(mr, fs)
 		}
 	}
+	t.saveBitmapIndexes(mr, fieldNames)
 }
 
 // saveNumericIndex adds commands to the transaction for saving a numeric
@@ -323,6 +854,9 @@ func (t *Transaction) saveFieldIndexesForFields(fieldNames []string, mr *modelRe
 func (t *Transaction) saveNumericIndex(mr *modelRef, fs *fieldSpec) {
 	fieldValue := mr.fieldValue(fs.name)
 	if fieldValue.Kind() == reflect.Ptr && fieldValue.IsNil() {
+		// Remove the old value (if any). The model is added to the null index
+		// instead.
+		t.deleteNumericOrBooleanIndex(fs, mr.spec, mr.model.ModelID())
 		return
 	}
 	score := numericScore(fieldValue)
@@ -330,7 +864,7 @@ func (t *Transaction) saveNumericIndex(mr *modelRef, fs *fieldSpec) {
 	if err != nil {
 		t.setError(err)
 	}
-	t.Command("ZADD", redis.Args{indexKey, score, mr.model.ModelID()}, nil)
+	t.modelCommand(mr.model.ModelID(), "ZADD", redis.Args{indexKey, score, mr.model.ModelID()}, nil)
 }
 
 // saveBooleanIndex adds commands to the transaction for saving a boolean
@@ -338,6 +872,9 @@ func (t *Transaction) saveNumericIndex(mr *modelRef, fs *fieldSpec) {
 func (t *Transaction) saveBooleanIndex(mr *modelRef, fs *fieldSpec) {
 	fieldValue := mr.fieldValue(fs.name)
 	if fieldValue.Kind() == reflect.Ptr && fieldValue.IsNil() {
+		// Remove the old value (if any). The model is added to the null index
+		// instead.
+		t.deleteNumericOrBooleanIndex(fs, mr.spec, mr.model.ModelID())
 		return
 	}
 	score := boolScore(fieldValue)
@@ -345,14 +882,15 @@ func (t *Transaction) saveBooleanIndex(mr *modelRef, fs *fieldSpec) {
 	if err != nil {
 		t.setError(err)
 	}
-	t.Command("ZADD", redis.Args{indexKey, score, mr.model.ModelID()}, nil)
+	t.modelCommand(mr.model.ModelID(), "ZADD", redis.Args{indexKey, score, mr.model.ModelID()}, nil)
 }
 
-// saveStringIndex adds commands to the transaction for saving a string
-// index on the given field. This includes removing the old index (if any).
+// saveStringIndex adds commands to the transaction for saving a string (or
+// integer) index on the given field. This includes removing the old index (if
+// any).
 func (t *Transaction) saveStringIndex(mr *modelRef, fs *fieldSpec) {
 	// Remove the old index (if any)
-	t.deleteStringIndex(mr.spec.name, mr.model.ModelID(), fs.redisName)
+	t.deleteStringIndex(mr.spec, mr.model.ModelID(), fs)
 	fieldValue := mr.fieldValue(fs.name)
 	for fieldValue.Kind() == reflect.Ptr {
 		if fieldValue.IsNil() {
@@ -360,12 +898,29 @@ func (t *Transaction) saveStringIndex(mr *modelRef, fs *fieldSpec) {
 		}
 		fieldValue = fieldValue.Elem()
 	}
-	member := fieldValue.String() + nullString + mr.model.ModelID()
+	value := fieldValue.String()
+	if fs.collate != nil {
+		t.addCollatedMember(mr.spec, fs, mr.model.ModelID(), value)
+		return
+	}
+	member := stringIndexValue(fs, fieldValue) + nullString + mr.model.ModelID()
 	indexKey, err := mr.spec.fieldIndexKey(fs.name)
 	if err != nil {
 		t.setError(err)
 	}
-	t.Command("ZADD", redis.Args{indexKey, 0, member}, nil)
+	t.modelCommand(mr.model.ModelID(), "ZADD", redis.Args{indexKey, 0, member}, nil)
+	t.modelCommand(mr.model.ModelID(), "HSET", redis.Args{mr.spec.stringMembersKey(fs), mr.model.ModelID(), member}, nil)
+}
+
+// saveNullIndex adds commands to the transaction for adding the model to the
+// null index on the given pointer field if the field is nil, or removing it from
+// the null index otherwise.
+func (t *Transaction) saveNullIndex(mr *modelRef, fs *fieldSpec) {
+	command := "SREM"
+	if mr.fieldValue(fs.name).IsNil() {
+		command = "SADD"
+	}
+	t.modelCommand(mr.model.ModelID(), command, redis.Args{mr.spec.nullIndexKey(fs), mr.model.ModelID()}, nil)
 }
 
 // SaveFields saves only the given fields of the model. SaveFields uses
@@ -397,15 +952,34 @@ func (c *Collection) SaveFields(fieldNames []string, model Model) error {
 func (t *Transaction) SaveFields(c *Collection, fieldNames []string, model Model) {
 	// Check the model type
 	if err := c.checkModelType(model); err != nil {
-		t.setError(fmt.Errorf("zoom: Error in SaveFields or Transaction.SaveFields: %s", err.Error()))
+		t.setError(fmt.Errorf("zoom: Error in SaveFields or Transaction.SaveFields: %w", err))
+		return
+	}
+	if err := c.checkHashLayout("SaveFields"); err != nil {
+		t.setError(err)
 		return
 	}
 	// Check the given field names
 	for _, fieldName := range fieldNames {
-		if !stringSliceContains(c.spec.fieldNames(), fieldName) {
-			t.setError(fmt.Errorf("zoom: Error in SaveFields or Transaction.SaveFields: Collection %s does not have field named %s", c.Name(), fieldName))
+		if _, found := c.spec.keyFieldByName(fieldName); found {
+			continue
+		}
+		if !stringSliceContains(c.spec.fieldNamesWithComputed(), fieldName) {
+			t.setError(newKindError(ErrFieldNotFound, "zoom: Error in SaveFields or Transaction.SaveFields: Collection %s does not have field named %s", c.Name(), fieldName))
+			return
+		}
+	}
+	// Set UpdatedAt (if the model has timestamps) and make sure it is saved
+	if ts := c.modelTimestamps(model); ts != nil {
+		now, err := t.timestamp(c)
+		if err != nil {
+			t.setError(err)
 			return
 		}
+		ts.UpdatedAt = now
+		if !stringSliceContains(fieldNames, "UpdatedAt") {
+			fieldNames = append(fieldNames[:len(fieldNames):len(fieldNames)], "UpdatedAt")
+		}
 	}
 	// Create a modelRef and start a transaction
 	mr := &modelRef{
@@ -413,10 +987,20 @@ func (t *Transaction) SaveFields(c *Collection, fieldNames []string, model Model
 		model:      model,
 		spec:       c.spec,
 	}
+	if err := t.setPrimaryKey(mr); err != nil {
+		t.setError(err)
+		return
+	}
+	if err := t.checkProtectedFields(mr, fieldNames); err != nil {
+		t.setError(err)
+		return
+	}
+	start := t.beginSave()
 	// Update indexes
 	// This must happen first, because it relies on reading the old field values
 	// from the hash for string indexes (if any)
 	t.saveFieldIndexesForFields(fieldNames, mr)
+	t.saveFullTextIndexes(fieldNames, mr)
 	// Get the main hash args.
 	hashArgs, err := mr.mainHashArgsForFields(fieldNames)
 	if err != nil {
@@ -428,12 +1012,88 @@ func (t *Transaction) SaveFields(c *Collection, fieldNames []string, model Model
 		// The first element in hashArgs is the model key,
 		// so there are fields if the length is greater than
 		// 1.
-		t.Command("HMSET", hashArgs, nil)
+		t.modelCommand(model.ModelID(), "HMSET", hashArgs, nil)
+		t.expireFields(mr.spec, model.ModelID(), hashArgs)
 	}
+	// Save any fields which are stored in their own key
+	t.saveKeyFieldsForFields(fieldNames, mr)
 	// Add the model id to the set of all models for this collection
 	if c.index {
-		t.Command("SADD", redis.Args{c.IndexKey(), model.ModelID()}, nil)
+		t.addToIndex(c, model.ModelID())
 	}
+	t.recordChange(c, model.ModelID(), ChangeUpdate, fieldNames)
+	t.publishEvent(c, ChangeUpdate, model.ModelID(), hashArgs)
+	t.invalidateCachedModel(c, model.ModelID())
+	t.trackEviction(c, model.ModelID())
+	t.recordSavedFields(mr, fieldNames)
+	t.endSave(c, model.ModelID(), fieldNames, start)
+}
+
+// GetSet atomically sets the fields of the model with the given id to the
+// given values (a map of field names to values), updating any field indexes as
+// needed, and scans the values that the fields had before into old. Only the
+// given fields (and the id) of old are set. It works like Query.Update, so the
+// same restrictions apply to fieldValues, UpdatedAt is set if the model has
+// timestamps, and the update is not recorded in the audit log or outbox. It
+// returns a ModelNotFoundError if the model does not exist, in which case
+// nothing is changed.
+func (c *Collection) GetSet(id string, fieldValues map[string]interface{}, old Model) error {
+	t := c.pool.NewTransaction()
+	t.GetSet(c, id, fieldValues, old)
+	return t.Exec()
+}
+
+// GetSet is like Collection.GetSet but runs inside an existing transaction.
+// The values are scanned into old when the transaction is executed. Any errors
+// encountered will be added to the transaction and returned as an error when
+// the transaction is executed.
+func (t *Transaction) GetSet(c *Collection, id string, fieldValues map[string]interface{}, old Model) {
+	if c == nil {
+		t.setError(newNilCollectionError("GetSet"))
+		return
+	}
+	if err := c.checkModelType(old); err != nil {
+		t.setError(fmt.Errorf("zoom: Error in GetSet or Transaction.GetSet: %w", err))
+		return
+	}
+	if err := c.checkHashLayout("GetSet"); err != nil {
+		t.setError(err)
+		return
+	}
+	fieldValues, err := t.withUpdatedAt(c, fieldValues)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	fieldArgs, err := c.spec.updateArgs(fieldValues)
+	if err != nil {
+		t.setError(fmt.Errorf("zoom: Error in GetSet or Transaction.GetSet: %w", err))
+		return
+	}
+	// The script returns the old values in the same order as the fields in
+	// fieldArgs, which are ordered like c.spec.fields.
+	fieldNames := []string{}
+	for _, fs := range c.spec.fields {
+		if _, found := fieldValues[fs.name]; found {
+			fieldNames = append(fieldNames, fs.name)
+		}
+	}
+	mr := &modelRef{
+		collection: c,
+		model:      old,
+		spec:       c.spec,
+	}
+	old.SetModelID(id)
+	args := redis.Args{c.Name(), id, c.spec.indexKeyPrefix()}
+	args = append(args, fieldArgs...)
+	t.modelScript(id, getSetModelScript, args, func(reply interface{}) error {
+		if reply == nil {
+			return newModelNotFoundError(mr)
+		}
+		return newScanModelRefHandler(fieldNames, mr)(reply)
+	})
+	t.invalidateCachedModel(c, id)
+	t.touchEviction(c, id)
 }
 
 // Find retrieves a model with the given id from redis and scans its values
@@ -441,8 +1101,14 @@ func (t *Transaction) SaveFields(c *Collection, fieldNames []string, model Model
 // corresponding to the Collection. Find will mutate the struct, filling in its
 // fields and overwriting any previous values. It returns an error if a model
 // with the given id does not exist, if the given model was the wrong type, or
-// if there was a problem connecting to the database.
+// if there was a problem connecting to the database. If the Pool has a model
+// cache (see PoolOptions.CacheSize), Find will use the cached values instead of
+// reading from the database when possible. Fields with the lazy option are not
+// read (see FindWith).
 func (c *Collection) Find(id string, model Model) error {
+	if found, err := c.findInCache(id, c.spec.defaultFieldNames(), model); found {
+		return err
+	}
 	t := c.pool.NewTransaction()
 	t.Find(c, id, model)
 	if err := t.Exec(); err != nil {
@@ -458,14 +1124,86 @@ func (c *Collection) Find(id string, model Model) error {
 // will be added to the transaction and returned as an error when the transaction is
 // executed.
 func (t *Transaction) Find(c *Collection, id string, model Model) {
+	t.find("Find", c, id, model, nil, nil)
+}
+
+// FindWith is like Find, but also reads the given fields with the lazy option,
+// which Find does not read by default (e.g. because they hold large values
+// which are rarely needed). FindWith returns an error if any of the given
+// fieldNames are not found in the model type.
+func (c *Collection) FindWith(id string, model Model, fieldNames ...string) error {
+	t := c.pool.NewTransaction()
+	t.FindWith(c, id, model, fieldNames...)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FindWith is like Collection.FindWith but runs inside an existing
+// transaction. Any errors encountered will be added to the transaction and
+// returned as an error when the transaction is executed.
+func (t *Transaction) FindWith(c *Collection, id string, model Model, fieldNames ...string) {
+	t.find("FindWith", c, id, model, fieldNames, nil)
+}
+
+// FindWithPresence is like Find, but also reports which fields were actually
+// stored in Redis, which makes it possible to tell a field whose stored value
+// is the zero value apart from a field which is missing (e.g. because it was
+// added to the model type after the model was saved). The returned map has an
+// entry for each field of the model type which is stored in the main hash and
+// read by Find, i.e. all fields except those stored in their own key and those
+// with the lazy option, and the entry is true iff the field was present. Like
+// Find, FindWithPresence does not change the fields of model which were
+// missing. Unlike Find, it always reads from the database and never from the
+// in-process model cache.
+func (c *Collection) FindWithPresence(id string, model Model) (map[string]bool, error) {
+	present := map[string]bool{}
+	t := c.pool.NewTransaction()
+	t.FindWithPresence(c, id, model, present)
+	if err := t.Exec(); err != nil {
+		return nil, err
+	}
+	return present, nil
+}
+
+// FindWithPresence is like Collection.FindWithPresence but runs inside an
+// existing transaction. When the transaction is executed, it scans the values
+// into model and sets an entry in present (which must not be nil) for each
+// field in the main hash. Any errors encountered will be added to the
+// transaction and returned as an error when the transaction is executed.
+func (t *Transaction) FindWithPresence(c *Collection, id string, model Model, present map[string]bool) {
+	if present == nil {
+		t.setError(fmt.Errorf("zoom: Error in FindWithPresence or Transaction.FindWithPresence: present must not be nil"))
+		return
+	}
+	t.find("FindWithPresence", c, id, model, nil, present)
+}
+
+// find adds the commands for Find, FindWith, and FindWithPresence to the
+// transaction. method is the name of the method, which is used in error
+// messages. The fields in the main hash which are read by default are read
+// along with any lazy fields in lazyFieldNames. If present is not nil, the
+// presence of each field which is read is recorded in it.
+func (t *Transaction) find(method string, c *Collection, id string, model Model, lazyFieldNames []string, present map[string]bool) {
 	if c == nil {
-		t.setError(newNilCollectionError("Find"))
+		t.setError(newNilCollectionError(method))
 		return
 	}
 	if err := c.checkModelType(model); err != nil {
-		t.setError(fmt.Errorf("zoom: Error in Find or Transaction.Find: %s", err.Error()))
+		t.setError(fmt.Errorf("zoom: Error in %s or Transaction.%s: %w", method, method, err))
 		return
 	}
+	fieldNames := c.spec.defaultFieldNames()
+	for _, fieldName := range lazyFieldNames {
+		if !stringSliceContains(c.spec.fieldNames(), fieldName) {
+			t.setError(newKindError(ErrFieldNotFound, "zoom: Error in %s or Transaction.%s: Collection %s does not have field named %s", method, method, c.Name(), fieldName))
+			return
+		}
+		if !stringSliceContains(fieldNames, fieldName) {
+			fieldNames = append(fieldNames, fieldName)
+		}
+	}
 	model.SetModelID(id)
 	mr := &modelRef{
 		collection: c,
@@ -473,13 +1211,26 @@ func (t *Transaction) Find(c *Collection, id string, model Model) {
 		spec:       c.spec,
 	}
 	// Check if the model actually exists
-	t.Command("EXISTS", redis.Args{mr.key()}, newModelExistsHandler(c, id))
+	t.modelCommand(id, "EXISTS", redis.Args{mr.key()}, newModelExistsHandler(c, id))
 	// Get the fields from the main hash for this model
-	args := redis.Args{mr.key()}
-	for _, fieldName := range mr.spec.fieldRedisNames() {
-		args = append(args, fieldName)
+	redisNames := make([]string, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		redisNames[i] = mr.spec.fieldsByName[fieldName].redisName
+	}
+	handler := newScanModelRefHandler(fieldNames, mr)
+	if present != nil {
+		handler = newPresenceHandler(fieldNames, present, handler)
+	}
+	if cache := t.pool.cacheFor(c); cache != nil {
+		handler = newCachingHandler(cache, c, id, fieldNames, handler)
 	}
-	t.Command("HMGET", args, newScanModelRefHandler(mr.spec.fieldNames(), mr))
+	t.readModelFields(mr, redisNames, handler)
+	// Get any fields which are stored in their own key
+	t.findKeyFields(mr)
+	if c.strictScan {
+		t.checkHashFieldsForIDs(c, id)
+	}
+	t.touchEviction(c, id)
 }
 
 // FindFields is like Find but finds and sets only the specified fields. Any
@@ -487,6 +1238,9 @@ func (t *Transaction) Find(c *Collection, id string, model Model) {
 // FindFields will return an error if any of the given fieldNames are not found
 // in the model type.
 func (c *Collection) FindFields(id string, fieldNames []string, model Model) error {
+	if found, err := c.findInCache(id, fieldNames, model); found {
+		return err
+	}
 	t := c.pool.NewTransaction()
 	t.FindFields(c, id, fieldNames, model)
 	if err := t.Exec(); err != nil {
@@ -501,7 +1255,7 @@ func (c *Collection) FindFields(id string, fieldNames []string, model Model) err
 // in the model type.
 func (t *Transaction) FindFields(c *Collection, id string, fieldNames []string, model Model) {
 	if err := c.checkModelType(model); err != nil {
-		t.setError(fmt.Errorf("zoom: Error in FindFields or Transaction.FindFields: %s", err.Error()))
+		t.setError(fmt.Errorf("zoom: Error in FindFields or Transaction.FindFields: %w", err))
 		return
 	}
 	// Set the model id and create a modelRef
@@ -512,22 +1266,34 @@ func (t *Transaction) FindFields(c *Collection, id string, fieldNames []string,
 		model:      model,
 	}
 	// Check the given field names and append the corresponding redis field names
-	// to args.
-	args := redis.Args{mr.key()}
+	// to redisNames.
+	redisNames := []string{}
+	hashFieldNames := []string{}
 	for _, fieldName := range fieldNames {
+		if _, found := c.spec.keyFieldByName(fieldName); found {
+			continue
+		}
 		if !stringSliceContains(c.spec.fieldNames(), fieldName) {
-			t.setError(fmt.Errorf("zoom: Error in FindFields or Transaction.FindFields: Collection %s does not have field named %s", c.Name(), fieldName))
+			t.setError(newKindError(ErrFieldNotFound, "zoom: Error in FindFields or Transaction.FindFields: Collection %s does not have field named %s", c.Name(), fieldName))
 			return
 		}
-		// args is an array of arguments passed to the HMGET command. We want to
-		// use the redis names corresponding to each field name. The redis names
-		// may be customized via struct tags.
-		args = append(args, c.spec.fieldsByName[fieldName].redisName)
+		// We want to use the redis names corresponding to each field name. The
+		// redis names may be customized via struct tags.
+		redisNames = append(redisNames, c.spec.fieldsByName[fieldName].redisName)
+		hashFieldNames = append(hashFieldNames, fieldName)
 	}
 	// Check if the model actually exists.
-	t.Command("EXISTS", redis.Args{mr.key()}, newModelExistsHandler(c, id))
+	t.modelCommand(id, "EXISTS", redis.Args{mr.key()}, newModelExistsHandler(c, id))
 	// Get the fields from the main hash for this model
-	t.Command("HMGET", args, newScanModelRefHandler(fieldNames, mr))
+	if len(hashFieldNames) > 0 {
+		t.readModelFields(mr, redisNames, newScanModelRefHandler(hashFieldNames, mr))
+	}
+	// Get any fields which are stored in their own key
+	t.findKeyFieldsForFields(fieldNames, mr)
+	if c.strictScan {
+		t.checkHashFieldsForIDs(c, id)
+	}
+	t.touchEviction(c, id)
 }
 
 // FindAll finds all the models of the given type. It executes the commands needed
@@ -567,12 +1333,142 @@ func (t *Transaction) FindAll(c *Collection, models interface{}) {
 	// Since this is somewhat type-unsafe, we need to verify that
 	// models is the correct type
 	if err := c.checkModelsType(models); err != nil {
-		t.setError(fmt.Errorf("zoom: Error in FindAll or Transaction.FindAll: %s", err.Error()))
+		t.setError(fmt.Errorf("zoom: Error in FindAll or Transaction.FindAll: %w", err))
 		return
 	}
-	sortArgs := c.spec.sortArgs(c.spec.indexKey(), c.spec.fieldRedisNames(), 0, 0, false)
-	fieldNames := append(c.spec.fieldNames(), "-")
-	t.Command("SORT", sortArgs, newScanModelsHandler(c.spec, fieldNames, models))
+	fieldNames := c.spec.defaultFieldNames()
+	redisNames := make([]string, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		redisNames[i] = c.spec.fieldsByName[fieldName].redisName
+	}
+	idsKey, reverse, tmpKeys, err := t.allIDs(c)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	sortArgs := c.spec.sortArgs(idsKey, redisNames, 0, 0, reverse)
+	fieldNames = append(fieldNames, "-")
+	t.sortModelsWithKeyFields(c.spec, sortArgs, redisNames, c.spec.keyFields, modelsIndexer(models), newScanModelsHandler(c.spec, fieldNames, models))
+	if c.strictScan {
+		t.checkHashFieldsForSort(c, idsKey, 0, 0, reverse)
+	}
+	if len(tmpKeys) > 0 {
+		t.Command("DEL", tmpKeys, nil)
+	}
+}
+
+// FindAllInBatches is like FindAll, but reads the models in batches of at most
+// batchSize models (using SORT with a LIMIT window), each in its own
+// transaction. FindAll reads the fields of every model in a single reply, which
+// for very large collections can use several times as much memory as the
+// models themselves. FindAllInBatches only ever holds the reply for a single
+// batch. Unlike FindAll, it is not atomic, so models which are saved or deleted
+// while it runs may be missed or returned twice. The models in models are not
+// reused. To process a large collection without holding all the models in
+// memory at once, use ForEachBatch instead.
+func (c *Collection) FindAllInBatches(models interface{}, batchSize int) error {
+	if err := c.checkModelsType(models); err != nil {
+		return fmt.Errorf("zoom: Error in FindAllInBatches: %w", err)
+	}
+	modelsVal := reflect.ValueOf(models).Elem()
+	results := reflect.MakeSlice(modelsVal.Type(), 0, 0)
+	batch := reflect.New(modelsVal.Type())
+	if err := c.forEachBatch("FindAllInBatches", batch.Interface(), batchSize, func() error {
+		results = reflect.AppendSlice(results, batch.Elem())
+		return nil
+	}); err != nil {
+		return err
+	}
+	modelsVal.Set(results)
+	return nil
+}
+
+// ForEachBatch reads all the models in the collection in batches of at most
+// batchSize models (see FindAllInBatches). For each batch, it sets models,
+// which must be a pointer to a slice of models of the type of the collection,
+// to the models in the batch and then calls fn. New models are allocated for
+// each batch, so fn may keep references to them, but the memory for models
+// which are no longer referenced can be reclaimed before the next batch is
+// read. If fn returns an error, ForEachBatch stops and returns it. Like
+// FindAllInBatches, ForEachBatch is not atomic.
+func (c *Collection) ForEachBatch(models interface{}, batchSize int, fn func() error) error {
+	return c.forEachBatch("ForEachBatch", models, batchSize, fn)
+}
+
+// forEachBatch implements ForEachBatch. methodName is used in errors.
+func (c *Collection) forEachBatch(methodName string, models interface{}, batchSize int, fn func() error) error {
+	if c == nil {
+		return newNilCollectionError(methodName)
+	}
+	if !c.index {
+		return newUnindexedCollectionError(methodName)
+	}
+	if batchSize < 1 {
+		return fmt.Errorf("zoom: Error in %s: batchSize must be at least 1 but got %d", methodName, batchSize)
+	}
+	if err := c.checkModelsType(models); err != nil {
+		return fmt.Errorf("zoom: Error in %s: %w", methodName, err)
+	}
+	fieldNames := c.spec.defaultFieldNames()
+	redisNames := make([]string, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		redisNames[i] = c.spec.fieldsByName[fieldName].redisName
+	}
+	fieldNames = append(fieldNames, "-")
+	modelsVal := reflect.ValueOf(models).Elem()
+	for offset := uint(0); ; offset += uint(batchSize) {
+		// Set the length to 0 so that the handler allocates new models instead
+		// of scanning into the models from the previous batch.
+		modelsVal.SetLen(0)
+		t := c.pool.NewTransaction()
+		idsKey, reverse, tmpKeys, err := t.allIDs(c)
+		if err != nil {
+			return err
+		}
+		sortArgs := c.spec.sortArgs(idsKey, redisNames, batchSize, offset, reverse)
+		t.sortModelsWithKeyFields(c.spec, sortArgs, redisNames, c.spec.keyFields, modelsIndexer(models), newScanModelsHandler(c.spec, fieldNames, models))
+		if c.strictScan {
+			t.checkHashFieldsForSort(c, idsKey, batchSize, offset, reverse)
+		}
+		if len(tmpKeys) > 0 {
+			t.Command("DEL", tmpKeys, nil)
+		}
+		if err := t.Exec(); err != nil {
+			return err
+		}
+		numModels := modelsVal.Len()
+		if numModels == 0 {
+			return nil
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		if numModels < batchSize {
+			return nil
+		}
+	}
+}
+
+// FindIDRange finds the models whose ids are lexically between minID and maxID
+// (inclusive) and scans them into models, in the order of their ids. An empty
+// minID or maxID means that there is no lower or upper bound. Since the ids
+// generated by RandomID start with the time they were generated, FindIDRange
+// can be used with IDForTime to find the models which were created in a
+// certain time window without a CreatedAt field. models must be a pointer to a
+// slice of models with a type corresponding to the Collection. The default
+// scope of the collection (if any) is not applied. See Query.FilterID for more
+// information.
+func (c *Collection) FindIDRange(minID string, maxID string, models interface{}) error {
+	if c == nil {
+		return newNilCollectionError("FindIDRange")
+	}
+	// Every id is greater than or equal to an empty string, but the filter
+	// still makes the query return the models in the order of their ids.
+	q := (&Query{query: newQuery(c)}).FilterID(">=", minID)
+	if maxID != "" {
+		q.FilterID("<=", maxID)
+	}
+	return q.Run(models)
 }
 
 // Exists returns true if the collection has a model with the given id. It
@@ -596,7 +1492,52 @@ func (t *Transaction) Exists(c *Collection, id string, exists *bool) {
 		t.setError(newNilCollectionError("Exists"))
 		return
 	}
-	t.Command("EXISTS", redis.Args{c.ModelKey(id)}, NewScanBoolHandler(exists))
+	t.modelCommand(id, "EXISTS", redis.Args{c.ModelKey(id)}, NewScanBoolHandler(exists))
+}
+
+// ExistsMany is like Exists but checks many ids at once. It returns a map from
+// each of the given ids to true if the collection has a model with that id and
+// false otherwise. The EXISTS commands for all the ids are sent in a single
+// transaction, so there is only one round trip to the database no matter how
+// many ids there are. It returns an error if there was a problem connecting to
+// the database.
+func (c *Collection) ExistsMany(ids []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return exists, nil
+	}
+	t := c.pool.NewTransaction()
+	t.ExistsMany(c, ids, exists)
+	if err := t.Exec(); err != nil {
+		return nil, err
+	}
+	return exists, nil
+}
+
+// ExistsMany sets exists[id] to true for each of the given ids for which a
+// model exists in the given collection, and to false for the others. The first
+// error encountered (if any) will be added to the transaction and returned
+// when the transaction is executed.
+func (t *Transaction) ExistsMany(c *Collection, ids []string, exists map[string]bool) {
+	if c == nil {
+		t.setError(newNilCollectionError("ExistsMany"))
+		return
+	}
+	if exists == nil {
+		t.setError(fmt.Errorf("zoom: error in Transaction.ExistsMany: exists cannot be nil"))
+		return
+	}
+	for _, id := range ids {
+		id := id
+		t.modelCommand(id, "EXISTS", redis.Args{c.ModelKey(id)}, func(reply interface{}) error {
+			found, err := redis.Bool(reply, nil)
+			if err != nil {
+				return err
+			}
+			exists[id] = found
+			return nil
+		})
+	}
 }
 
 // Count returns the number of models of the given type that exist in the database.
@@ -624,7 +1565,7 @@ func (t *Transaction) Count(c *Collection, count *int) {
 		t.setError(newUnindexedCollectionError("Count"))
 		return
 	}
-	t.Command("SCARD", redis.Args{c.IndexKey()}, NewScanIntHandler(count))
+	t.countIndex(c, NewScanIntHandler(count))
 }
 
 // Delete removes the model with the given type and id from the database. It will
@@ -648,16 +1589,38 @@ func (c *Collection) Delete(id string) (bool, error) {
 // the value of deleted will be set to false. Any errors encountered will be
 // added to the transaction and returned as an error when the transaction is
 // executed. You may pass in nil for deleted if you do not care whether or not
-// the model was deleted.
+// the model was deleted. If fields of other collections reference the model
+// (see the ref option of the zoom struct tag), the references are enforced
+// according to their ondelete option.
 func (t *Transaction) Delete(c *Collection, id string, deleted *bool) {
 	if c == nil {
 		t.setError(newNilCollectionError("Delete"))
 		return
 	}
+	if err := t.deleteModel(c, id, deleted, map[string]bool{}); err != nil {
+		t.setError(err)
+	}
+}
+
+// deleteModel is like Delete but returns an error if the model cannot be
+// deleted because of a reference to it. deletedKeys contains the keys of the
+// models which are already being deleted by the transaction (see
+// deleteReferences), and deleteModel does nothing if it contains the key of
+// the model.
+func (t *Transaction) deleteModel(c *Collection, id string, deleted *bool, deletedKeys map[string]bool) error {
+	key := c.ModelKey(id)
+	if deletedKeys[key] {
+		return nil
+	}
+	deletedKeys[key] = true
+	if err := t.deleteReferences(c, id, deletedKeys); err != nil {
+		return err
+	}
 	// Delete any field indexes
 	// This must happen first, because it relies on reading the old field values
 	// from the hash for string indexes (if any)
 	t.deleteFieldIndexes(c, id)
+	t.deleteFullTextIndexes(c, id)
 	var handler ReplyHandler
 	if deleted == nil {
 		handler = nil
@@ -665,25 +1628,39 @@ func (t *Transaction) Delete(c *Collection, id string, deleted *bool) {
 		handler = NewScanBoolHandler(deleted)
 	}
 	// Delete the main hash
-	t.Command("DEL", redis.Args{c.Name() + ":" + id}, handler)
+	t.modelCommand(id, "DEL", redis.Args{c.Name() + ":" + id}, handler)
+	// Delete any fields which are stored in their own key
+	t.deleteKeyFields(c, id)
 	// Remvoe the id from the index of all models for the given type
-	t.Command("SREM", redis.Args{c.IndexKey(), id}, nil)
+	t.removeFromIndex(c, id)
+	t.recordChange(c, id, ChangeDelete, nil)
+	t.publishEvent(c, ChangeDelete, id, nil)
+	t.invalidateCachedModel(c, id)
+	t.untrackEviction(c, id)
+	return nil
 }
 
 // deleteFieldIndexes adds commands to the transaction for deleting the field
 // indexes for all indexed fields of the given model type.
 func (t *Transaction) deleteFieldIndexes(c *Collection, id string) {
-	for _, fs := range c.spec.fields {
+	for _, fs := range c.spec.fieldsWithComputed() {
 		switch fs.indexKind {
 		case noIndex:
 			continue
 		case numericIndex, booleanIndex:
 			t.deleteNumericOrBooleanIndex(fs, c.spec, id)
-		case stringIndex:
+		case stringIndex, integerIndex:
 			// NOTE: this invokes a lua script which is defined in scripts/delete_string_index.lua
-			t.deleteStringIndex(c.Name(), id, fs.redisName)
+			t.deleteStringIndex(c.spec, id, fs)
+		case enumIndex:
+			// NOTE: this invokes a lua script which is defined in scripts/update_enum_index.lua
+			t.updateEnumIndex(c.spec, fs, id, -1)
+		}
+		if fs.hasNullIndex() {
+			t.modelCommand(id, "SREM", redis.Args{c.spec.nullIndexKey(fs), id}, nil)
 		}
 	}
+	t.updateBitmapIndexes(c.spec, id, "delete", c.spec.fields)
 }
 
 // deleteNumericOrBooleanIndex removes the model from a numeric or boolean index for the given
@@ -693,12 +1670,14 @@ func (t *Transaction) deleteNumericOrBooleanIndex(fs *fieldSpec, ms *modelSpec,
 	if err != nil {
 		t.setError(err)
 	}
-	t.Command("ZREM", redis.Args{indexKey, modelID}, nil)
+	t.modelCommand(modelID, "ZREM", redis.Args{indexKey, modelID}, nil)
 }
 
 // DeleteAll deletes all the models of the given type in a single transaction. See
 // http://redis.io/topics/transactions. It returns the number of models deleted
-// and an error if there was a problem connecting to the database.
+// and an error if there was a problem connecting to the database. DeleteAll
+// returns an error if the models are referenced by a field with the ref option,
+// which only Delete enforces.
 func (c *Collection) DeleteAll() (int, error) {
 	t := c.pool.NewTransaction()
 	count := 0
@@ -723,13 +1702,17 @@ func (t *Transaction) DeleteAll(c *Collection, count *int) {
 		t.setError(newUnindexedCollectionError("DeleteAll"))
 		return
 	}
+	if err := c.checkUnreferenced("DeleteAll"); err != nil {
+		t.setError(err)
+		return
+	}
 	var handler ReplyHandler
 	if count == nil {
 		handler = nil
 	} else {
 		handler = NewScanIntHandler(count)
 	}
-	t.DeleteModelsBySetIDs(c.IndexKey(), c.Name(), handler)
+	t.deleteModelsBySetIDs(c.IndexKey(), c.spec, handler)
 }
 
 // checkModelType returns an error iff model is not of the registered type that
@@ -8,8 +8,12 @@
 package zoom
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
 )
 
 // collectionTestModel is a model type that is only used for testing
@@ -56,6 +60,257 @@ func TestNewCollectionWithName(t *testing.T) {
 	delete(testPool.modelTypeToSpec, col.spec.typ)
 }
 
+func TestNewCollectionSameTypeMultipleNames(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type tenantModel struct {
+		Name string
+		RandomID
+	}
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	// Register the same type under several names concurrently
+	names := []string{"tenantA", "tenantB", "tenantC", "tenantD"}
+	errs := make(chan error, len(names))
+	for _, name := range names {
+		go func(name string) {
+			_, err := pool.NewCollectionWithOptions(&tenantModel{}, DefaultCollectionOptions.WithName(name))
+			errs <- err
+		}(name)
+	}
+	for range names {
+		if err := <-errs; err != nil {
+			t.Fatalf("Unexpected error in NewCollectionWithOptions: %s", err.Error())
+		}
+	}
+	for _, name := range names {
+		col, found := pool.Collection(name)
+		if !found {
+			t.Fatalf("Collection %s was not found", name)
+		}
+		if col.Name() != name {
+			t.Errorf("Expected collection to have name %s but got %s", name, col.Name())
+		}
+	}
+	if _, found := pool.Collection("tenantModel"); found {
+		t.Error("Expected Collection to return false for an unregistered name")
+	}
+
+	// Registering the type again without a custom name should fail, and so
+	// should reusing a name.
+	if _, err := pool.NewCollection(&tenantModel{}); err == nil {
+		t.Error("Expected an error when registering the same type without a custom name")
+	}
+	if _, err := pool.NewCollectionWithOptions(&collectionTestModel{}, DefaultCollectionOptions.WithName("tenantA")); err == nil {
+		t.Error("Expected an error when registering a name which is already registered")
+	}
+
+	// Models in each collection should be stored separately
+	tenantA, _ := pool.Collection("tenantA")
+	tenantB, _ := pool.Collection("tenantB")
+	model := &tenantModel{Name: "foo"}
+	if err := tenantA.Save(model); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := tenantB.Exists(model.ID); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Error("Expected model saved in tenantA to not exist in tenantB")
+	}
+
+	// Watch cannot tell which collection the model belongs to
+	tx := pool.NewTransaction()
+	if err := tx.Watch(model); err == nil {
+		t.Error("Expected an error from Watch for a type registered under more than one name")
+	}
+	_ = tx.Exec()
+}
+
+func TestNewCollectionSameTypeMultiplePools(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type sharedModel struct {
+		Name string
+		RandomID
+	}
+	pools := []*Pool{
+		NewPoolWithOptions(testPool.options),
+		NewPoolWithOptions(testPool.options.WithDatabase(testPool.options.Database + 1)),
+	}
+	cols := make([]*Collection, len(pools))
+	errs := make(chan error, len(pools))
+	for i, pool := range pools {
+		defer func(pool *Pool) {
+			_ = pool.Close()
+		}(pool)
+		go func(i int, pool *Pool) {
+			col, err := pool.NewCollection(&sharedModel{})
+			cols[i] = col
+			errs <- err
+		}(i, pool)
+	}
+	for range pools {
+		if err := <-errs; err != nil {
+			t.Fatalf("Unexpected error in NewCollection: %s", err.Error())
+		}
+	}
+	for i, pool := range pools {
+		col, found := pool.Collection("sharedModel")
+		if !found {
+			t.Fatalf("Collection was not found in pool %d", i)
+		}
+		if col != cols[i] {
+			t.Errorf("Collection returned the wrong collection for pool %d", i)
+		}
+	}
+
+	// Watch should use the collection registered with the transaction's pool
+	model := &sharedModel{Name: "foo"}
+	if err := cols[1].Save(model); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := cols[1].Delete(model.ID); err != nil {
+			t.Error(err)
+		}
+	}()
+	tx := pools[1].NewTransaction()
+	if err := tx.Watch(model); err != nil {
+		t.Fatal(err)
+	}
+	tx.Command("PING", nil, nil)
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := cols[0].Exists(model.ID); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Error("Expected model saved in one database to not exist in the other")
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Save should set both CreatedAt and UpdatedAt
+	before := time.Now().UnixNano()
+	model := &timestampedModel{Name: "foo"}
+	if err := timestampedModels.Save(model); err != nil {
+		t.Fatal(err)
+	}
+	if model.CreatedAt < before || model.CreatedAt > time.Now().UnixNano() {
+		t.Errorf("CreatedAt was not set to the current time. Got %d", model.CreatedAt)
+	}
+	if model.UpdatedAt != model.CreatedAt {
+		t.Errorf("Expected UpdatedAt to equal CreatedAt (%d) but got %d", model.CreatedAt, model.UpdatedAt)
+	}
+	createdAt := model.CreatedAt
+
+	// Saving again should only change UpdatedAt
+	time.Sleep(time.Millisecond)
+	if err := timestampedModels.Save(model); err != nil {
+		t.Fatal(err)
+	}
+	if model.CreatedAt != createdAt {
+		t.Errorf("CreatedAt was changed by Save. Expected %d but got %d", createdAt, model.CreatedAt)
+	}
+	if model.UpdatedAt <= createdAt {
+		t.Errorf("UpdatedAt was not updated by Save. Got %d", model.UpdatedAt)
+	}
+
+	// SaveFields should save UpdatedAt even if it is not in fieldNames
+	time.Sleep(time.Millisecond)
+	model.Name = "bar"
+	if err := timestampedModels.SaveFields([]string{"Name"}, model); err != nil {
+		t.Fatal(err)
+	}
+	got := &timestampedModel{}
+	if err := timestampedModels.Find(model.ID, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(model, got) {
+		t.Errorf("Model was not saved correctly.\nExpected: %+v\nGot:      %+v", model, got)
+	}
+
+	// Query.Update should also set UpdatedAt
+	updatedAt := model.UpdatedAt
+	time.Sleep(time.Millisecond)
+	if _, err := timestampedModels.NewQuery().Update(map[string]interface{}{"Name": "baz"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := timestampedModels.Find(model.ID, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.UpdatedAt <= updatedAt {
+		t.Errorf("UpdatedAt was not updated by Query.Update. Got %d", got.UpdatedAt)
+	}
+
+	// The timestamps should be indexed
+	other := &timestampedModel{Name: "other"}
+	if err := timestampedModels.Save(other); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := timestampedModels.NewQuery().Order("-CreatedAt").IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{other.ID, model.ID}; !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Wrong ids for Order(\"-CreatedAt\"). Expected %v but got %v", expected, ids)
+	}
+	count, err := timestampedModels.NewQuery().Filter("UpdatedAt >", got.UpdatedAt).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 model to be updated after %d but got %d", got.UpdatedAt, count)
+	}
+}
+
+func TestTimestampsUseRedisTime(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type redisTimeModel struct {
+		Timestamps
+		RandomID
+	}
+	options := DefaultCollectionOptions.WithUseRedisTime(true)
+	col, err := testPool.NewCollectionWithOptions(&redisTimeModel{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// Effectively unregister the type by removing it from the map
+		delete(testPool.modelNameToSpec, col.Name())
+		delete(testPool.modelTypeToSpec, col.spec.typ)
+	}()
+
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	serverTime, err := redis.Ints(conn.Do("TIME"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := int64(serverTime[0]) * int64(time.Second)
+	model := &redisTimeModel{}
+	if err := col.Save(model); err != nil {
+		t.Fatal(err)
+	}
+	if model.CreatedAt < before || model.CreatedAt > before+int64(time.Minute) {
+		t.Errorf("CreatedAt was not set to the Redis server time (%d). Got %d", before, model.CreatedAt)
+	}
+	if model.UpdatedAt != model.CreatedAt {
+		t.Errorf("Expected UpdatedAt to equal CreatedAt (%d) but got %d", model.CreatedAt, model.UpdatedAt)
+	}
+}
+
 func testRegisteredCollectionType(t *testing.T, collection *Collection, expectedName string, expectedType reflect.Type) {
 	// Check that the name and type are correct
 	if collection.Name() != expectedName {
@@ -88,6 +343,7 @@ func testRegisteredCollectionType(t *testing.T, collection *Collection, expected
 			redisName: "Int",
 			typ:       reflect.TypeOf(1),
 			indexKind: noIndex,
+			index:     []int{0},
 		},
 		"Bool": &fieldSpec{
 			kind:      primativeField,
@@ -95,6 +351,7 @@ func testRegisteredCollectionType(t *testing.T, collection *Collection, expected
 			redisName: "Bool",
 			typ:       reflect.TypeOf(true),
 			indexKind: noIndex,
+			index:     []int{1},
 		},
 		"String": &fieldSpec{
 			kind:      primativeField,
@@ -102,6 +359,7 @@ func testRegisteredCollectionType(t *testing.T, collection *Collection, expected
 			redisName: "String",
 			typ:       reflect.TypeOf(""),
 			indexKind: noIndex,
+			index:     []int{2},
 		},
 	}
 	for _, expectedField := range expectedFields {
@@ -273,6 +531,189 @@ func TestFindModelNotFound(t *testing.T) {
 	}
 }
 
+func TestFindWithPresence(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Save a model with zero values and then remove one of its fields, as if
+	// the field had been added to the type after the model was saved.
+	model := &testModel{String: "foo"}
+	if err := testModels.Save(model); err != nil {
+		t.Fatal(err)
+	}
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("HDEL", testModels.ModelKey(model.ModelID()), "Bool"); err != nil {
+		t.Fatal(err)
+	}
+
+	modelCopy := &testModel{}
+	present, err := testModels.FindWithPresence(model.ModelID(), modelCopy)
+	if err != nil {
+		t.Fatalf("Unexpected error in testModels.FindWithPresence: %s", err.Error())
+	}
+	if !reflect.DeepEqual(model, modelCopy) {
+		t.Errorf("Found model was incorrect.\n\tExpected: %+v\n\tBut got:  %+v", model, modelCopy)
+	}
+	expectedPresent := map[string]bool{"Int": true, "String": true, "Bool": false}
+	if !reflect.DeepEqual(expectedPresent, present) {
+		t.Errorf("present was incorrect.\n\tExpected: %v\n\tBut got:  %v", expectedPresent, present)
+	}
+
+	// Models which do not exist should still return a ModelNotFoundError
+	if _, err := testModels.FindWithPresence("fake-id", &testModel{}); err == nil {
+		t.Errorf("Expected error in testModels.FindWithPresence but got none")
+	} else if _, ok := err.(ModelNotFoundError); !ok {
+		t.Errorf("Expected error to be a ModelNotFoundError but got: %T: %s", err, err.Error())
+	}
+
+	// The present map must not be nil
+	tx := testPool.NewTransaction()
+	tx.FindWithPresence(testModels, model.ModelID(), &testModel{}, nil)
+	if err := tx.Exec(); err == nil {
+		t.Errorf("Expected error in Transaction.FindWithPresence with a nil map but got none")
+	}
+}
+
+// lazyTestModel is a model type with a lazy field, used for testing FindWith.
+type lazyTestModel struct {
+	Name string `zoom:"index"`
+	Blob []byte `zoom:"lazy"`
+	RandomID
+}
+
+func TestFindWith(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&lazyTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := &lazyTestModel{Name: "foo", Blob: []byte("a very large value")}
+	if err := col.Save(model); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lazy field should not be read by Find, FindAll, or queries
+	found := &lazyTestModel{}
+	if err := col.Find(model.ModelID(), found); err != nil {
+		t.Fatalf("Unexpected error in Find: %s", err.Error())
+	}
+	expected := &lazyTestModel{Name: "foo", RandomID: model.RandomID}
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("Model found with Find was incorrect.\n\tExpected: %+v\n\tBut got:  %+v", expected, found)
+	}
+	all := []*lazyTestModel{}
+	if err := col.FindAll(&all); err != nil {
+		t.Fatalf("Unexpected error in FindAll: %s", err.Error())
+	}
+	if !reflect.DeepEqual([]*lazyTestModel{expected}, all) {
+		t.Errorf("Models found with FindAll were incorrect.\n\tExpected: %+v\n\tBut got:  %+v", expected, all)
+	}
+	queried := []*lazyTestModel{}
+	if err := col.NewQuery().Filter("Name =", "foo").Run(&queried); err != nil {
+		t.Fatalf("Unexpected error in Query.Run: %s", err.Error())
+	}
+	if !reflect.DeepEqual([]*lazyTestModel{expected}, queried) {
+		t.Errorf("Models found with Query.Run were incorrect.\n\tExpected: %+v\n\tBut got:  %+v", expected, queried)
+	}
+
+	// The lazy field should be read when it is requested explicitly
+	found = &lazyTestModel{}
+	if err := col.FindWith(model.ModelID(), found, "Blob"); err != nil {
+		t.Fatalf("Unexpected error in FindWith: %s", err.Error())
+	}
+	if !reflect.DeepEqual(model, found) {
+		t.Errorf("Model found with FindWith was incorrect.\n\tExpected: %+v\n\tBut got:  %+v", model, found)
+	}
+	queried = []*lazyTestModel{}
+	if err := col.NewQuery().Include("Name", "Blob").Run(&queried); err != nil {
+		t.Fatalf("Unexpected error in Query.Run with Include: %s", err.Error())
+	}
+	if !reflect.DeepEqual([]*lazyTestModel{model}, queried) {
+		t.Errorf("Models found with Include were incorrect.\n\tExpected: %+v\n\tBut got:  %+v", model, queried)
+	}
+
+	if err := col.FindWith(model.ModelID(), &lazyTestModel{}, "Fake"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("Expected an ErrFieldNotFound error in FindWith but got: %v", err)
+	}
+
+	// The lazy option cannot be used on fields stored in their own key
+	type lazySet struct {
+		Attrs []string `zoom:"set,lazy"`
+		RandomID
+	}
+	if _, err := testPool.NewCollection(&lazySet{}); err == nil {
+		t.Error("Expected an error for a lazy set field but got none")
+	}
+}
+
+// jsonTagsTestModel is a model type with json struct tags, used for testing
+// the UseJSONTags option.
+type jsonTagsTestModel struct {
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name" redis:"surname"`
+	Age       int    `json:",omitempty"`
+	Secret    string `json:"-"`
+	RandomID
+}
+
+func TestUseJSONTags(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&jsonTagsTestModel{}, DefaultCollectionOptions.WithUseJSONTags(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := &jsonTagsTestModel{FirstName: "Ada", LastName: "Lovelace", Age: 36, Secret: "x"}
+	if err := col.Save(model); err != nil {
+		t.Fatal(err)
+	}
+
+	// The json tag should be used unless there is a redis tag or the json tag
+	// does not have a name
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	got, err := redis.StringMap(conn.Do("HGETALL", col.ModelKey(model.ModelID())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"first_name": "Ada", "surname": "Lovelace", "Age": "36", "Secret": "x"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Stored hash was incorrect.\n\tExpected: %v\n\tBut got:  %v", expected, got)
+	}
+	found := &jsonTagsTestModel{}
+	if err := col.Find(model.ModelID(), found); err != nil {
+		t.Fatalf("Unexpected error in Find: %s", err.Error())
+	}
+	if !reflect.DeepEqual(model, found) {
+		t.Errorf("Found model was incorrect.\n\tExpected: %+v\n\tBut got:  %+v", model, found)
+	}
+
+	// Without the option, json tags should be ignored
+	spec, err := compileModelSpec(reflect.TypeOf(&jsonTagsTestModel{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redisName := spec.fieldsByName["FirstName"].redisName; redisName != "FirstName" {
+		t.Errorf("Expected redis name FirstName without UseJSONTags but got %s", redisName)
+	}
+}
+
 func TestFindAll(t *testing.T) {
 	testingSetUp()
 	defer testingTearDown()
@@ -318,6 +759,73 @@ func TestFindAll(t *testing.T) {
 	}
 }
 
+func TestFindAllInBatches(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveTestModels(7)
+	if err != nil {
+		t.Fatalf("Unexpected error saving test models: %s", err.Error())
+	}
+	modelsByID := map[string]*testModel{}
+	for _, model := range models {
+		modelsByID[model.ModelID()] = model
+	}
+
+	// 7 models in batches of 3 and 7 (an exact multiple of the number of models)
+	for _, batchSize := range []int{3, 7} {
+		got := []*testModel{{Int: 42}}
+		if err := testModels.FindAllInBatches(&got, batchSize); err != nil {
+			t.Fatalf("Unexpected error in FindAllInBatches: %s", err.Error())
+		}
+		if len(got) != len(models) {
+			t.Errorf("Expected %d models with batch size %d but got %d", len(models), batchSize, len(got))
+		}
+		for _, model := range got {
+			if expected := modelsByID[model.ModelID()]; !reflect.DeepEqual(expected, model) {
+				t.Errorf("Found model was incorrect.\n\tExpected: %+v\n\tBut got:  %+v", expected, model)
+			}
+		}
+	}
+
+	// ForEachBatch calls fn once per batch with new models each time
+	batch := []*testModel{}
+	sizes := []int{}
+	seen := map[*testModel]bool{}
+	if err := testModels.ForEachBatch(&batch, 3, func() error {
+		sizes = append(sizes, len(batch))
+		for _, model := range batch {
+			if seen[model] {
+				t.Errorf("Model %s was reused from a previous batch", model.ModelID())
+			}
+			seen[model] = true
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error in ForEachBatch: %s", err.Error())
+	}
+	if expected := []int{3, 3, 1}; !reflect.DeepEqual(expected, sizes) {
+		t.Errorf("Expected batches of sizes %v but got %v", expected, sizes)
+	}
+
+	// An error returned by fn stops the iteration
+	errStop := errors.New("stop")
+	calls := 0
+	if err := testModels.ForEachBatch(&batch, 3, func() error {
+		calls++
+		return errStop
+	}); err != errStop {
+		t.Errorf("Expected ForEachBatch to return errStop but got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected fn to be called once but got %d", calls)
+	}
+
+	if err := testModels.FindAllInBatches(&batch, 0); err == nil {
+		t.Error("Expected an error for a batch size of 0 but got none")
+	}
+}
+
 func TestExists(t *testing.T) {
 	testingSetUp()
 	defer testingTearDown()
@@ -348,6 +856,49 @@ func TestExists(t *testing.T) {
 	}
 }
 
+func TestExistsMany(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Create and save some test models
+	models, err := createAndSaveTestModels(3)
+	if err != nil {
+		t.Errorf("Unexpected error saving test models: %s", err.Error())
+	}
+
+	// Expect exists to be true for the saved models and false for the others
+	ids := []string{models[0].ID, "invalidID", models[2].ID}
+	exists, err := testModels.ExistsMany(ids)
+	if err != nil {
+		t.Errorf("Unexpected error in testModels.ExistsMany: %s", err.Error())
+	}
+	expected := map[string]bool{models[0].ID: true, "invalidID": false, models[2].ID: true}
+	if !reflect.DeepEqual(expected, exists) {
+		t.Errorf("Expected exists to be %v, but got: %v", expected, exists)
+	}
+
+	// Expect an empty map if there are no ids
+	exists, err = testModels.ExistsMany(nil)
+	if err != nil {
+		t.Errorf("Unexpected error in testModels.ExistsMany: %s", err.Error())
+	}
+	if len(exists) != 0 {
+		t.Errorf("Expected exists to be empty, but got: %v", exists)
+	}
+
+	// Expect one EXISTS command for each id in a single transaction
+	tx := testPool.NewTransaction()
+	exists = map[string]bool{}
+	tx.ExistsMany(testModels, ids, exists)
+	commands, err := tx.DryRun()
+	if err != nil {
+		t.Errorf("Unexpected error in DryRun: %s", err.Error())
+	}
+	if len(commands) != len(ids) {
+		t.Errorf("Expected %d commands, but got: %v", len(ids), commands)
+	}
+}
+
 func TestCount(t *testing.T) {
 	testingSetUp()
 	defer testingTearDown()
@@ -443,3 +994,58 @@ func TestDeleteAll(t *testing.T) {
 	// Make sure the models were deleted
 	expectModelsDoNotExist(t, testModels, Models(models))
 }
+
+func TestGetSet(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(2)
+	if err != nil {
+		t.Fatalf("Unexpected error saving test models: %s", err.Error())
+	}
+	model := models[0]
+	old := &indexedTestModel{}
+	if err := indexedTestModels.GetSet(model.ID, map[string]interface{}{"Int": 123, "String": "new"}, old); err != nil {
+		t.Fatalf("Unexpected error in GetSet: %s", err.Error())
+	}
+	expected := &indexedTestModel{Int: model.Int, String: model.String}
+	expected.ID = model.ID
+	if !reflect.DeepEqual(expected, old) {
+		t.Errorf("Expected old values: %+v\nBut got:  %+v", expected, old)
+	}
+
+	// The new values should be saved and indexed
+	got := &indexedTestModel{}
+	if err := indexedTestModels.Find(model.ID, got); err != nil {
+		t.Fatal(err)
+	}
+	expected = &indexedTestModel{Int: 123, String: "new", Bool: model.Bool}
+	expected.ID = model.ID
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: %+v\nBut got:  %+v", expected, got)
+	}
+	expectIndexExists(t, indexedTestModels, got, "Int")
+	expectIndexExists(t, indexedTestModels, got, "String")
+	ids, err := indexedTestModels.NewQuery().Filter("String =", model.String).IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("Expected the old string index to be removed but got ids: %v", ids)
+	}
+
+	// GetSet should fail without changing anything if the model does not exist
+	err = indexedTestModels.GetSet("invalid", map[string]interface{}{"Int": 1}, &indexedTestModel{})
+	if _, ok := err.(ModelNotFoundError); !ok {
+		t.Errorf("Expected a ModelNotFoundError but got: %v", err)
+	}
+	expectKeyDoesNotExist(t, indexedTestModels.ModelKey("invalid"))
+
+	// Invalid field names and values should return an error
+	if err := indexedTestModels.GetSet(model.ID, map[string]interface{}{"Invalid": 1}, old); err == nil {
+		t.Error("Expected an error for an invalid field name but got none")
+	}
+	if err := indexedTestModels.GetSet(model.ID, map[string]interface{}{"Int": "1"}, old); err == nil {
+		t.Error("Expected an error for an invalid value but got none")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File command_group.go contains code for building groups of commands which
+// can be composed into a single transaction.
+
+package zoom
+
+import (
+	"fmt"
+)
+
+// CommandGroup is a named group of commands and scripts which can be built
+// independently (e.g. by different packages) and then added to a transaction
+// with AddGroup, so that the commands of several groups are sent in a single
+// call to Exec. CommandGroup embeds a Transaction, so commands are added to a
+// group with the usual transaction methods (e.g. Save, Find, Command, or
+// Query). The handlers of the commands in a group are called with their own
+// replies just like for any other commands. If a command in a group fails,
+// the CommandError in the TransactionError returned by Exec includes the name
+// of the group, and if an error occurs while the group is being built (e.g.
+// because a model type is not registered), Exec returns it prefixed with the
+// name of the group. Groups can be nested by adding a group to another group,
+// in which case the name of the inner group is prefixed with the name of the
+// outer group and a slash, e.g. "billing/invoices".
+//
+// A group cannot watch keys, and can only be added to a single transaction.
+// After it has been added, the group is empty and cannot be used again.
+type CommandGroup struct {
+	*Transaction
+	name string
+}
+
+// NewCommandGroup returns a new, empty group of commands with the given name,
+// which is used to attribute errors to the group. The group should be added to
+// a transaction from the same pool with AddGroup.
+func (p *Pool) NewCommandGroup(name string) *CommandGroup {
+	return &CommandGroup{
+		Transaction: p.NewTransaction(),
+		name:        name,
+	}
+}
+
+// Name returns the name of the group.
+func (g *CommandGroup) Name() string {
+	return g.name
+}
+
+// AddGroup adds all the commands and scripts in group to the transaction, in
+// the order they were added to the group, after any commands which were
+// already added to the transaction. Cache invalidations, temporary keys and
+// any other effects of the commands in the group are also moved to the
+// transaction, and group is left empty. AddGroup does not send anything to
+// Redis.
+func (t *Transaction) AddGroup(group *CommandGroup) {
+	if group.Transaction == t {
+		t.setError(fmt.Errorf("zoom: Error in AddGroup: cannot add CommandGroup %s to itself", group.name))
+		return
+	}
+	if group.pool != t.pool {
+		t.setError(fmt.Errorf("zoom: Error in AddGroup: CommandGroup %s belongs to a different pool", group.name))
+		return
+	}
+	group.mut.Lock()
+	if group.done {
+		group.mut.Unlock()
+		t.setError(fmt.Errorf("zoom: Error in AddGroup: CommandGroup %s was already executed or added to a transaction", group.name))
+		return
+	}
+	group.done = true
+	_ = group.conn.Close()
+	actions, groupErr, watching := group.actions, group.err, group.watching
+	invalidations, tmpKeys, onSuccess := group.invalidations, group.tmpKeys, group.onSuccess
+	group.actions, group.invalidations, group.tmpKeys, group.onSuccess = nil, nil, nil, nil
+	group.mut.Unlock()
+
+	if groupErr != nil {
+		t.setError(fmt.Errorf("zoom: Error in CommandGroup %s: %w", group.name, groupErr))
+		return
+	}
+	if len(watching) > 0 {
+		t.setError(fmt.Errorf("zoom: Error in AddGroup: CommandGroup %s cannot watch keys. Use Watch or WatchKey on the transaction instead", group.name))
+		return
+	}
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for _, a := range actions {
+		if a.group == "" {
+			a.group = group.name
+		} else {
+			a.group = group.name + "/" + a.group
+		}
+		t.actions = append(t.actions, a)
+	}
+	t.invalidations = append(t.invalidations, invalidations...)
+	t.tmpKeys = append(t.tmpKeys, tmpKeys...)
+	t.onSuccess = append(t.onSuccess, onSuccess...)
+}
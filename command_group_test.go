@@ -0,0 +1,107 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File command_group_test.go tests the code in command_group.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddGroup(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := createTestModels(2)
+	found := &testModel{}
+	count := 0
+
+	// Groups are built independently of the transaction
+	newTx := func() (*Transaction, *CommandGroup) {
+		saves := testPool.NewCommandGroup("saves")
+		saves.Save(testModels, models[0])
+		saves.Save(testModels, models[1])
+		finds := testPool.NewCommandGroup("finds")
+		finds.Find(testModels, models[0].ModelID(), found)
+		counts := testPool.NewCommandGroup("counts")
+		counts.Command("ZCARD", redis.Args{testModels.IndexKey()}, NewScanIntHandler(&count))
+		finds.AddGroup(counts)
+		tx := testPool.NewTransaction()
+		tx.Command("SET", redis.Args{"before", "value"}, nil)
+		tx.AddGroup(saves)
+		tx.AddGroup(finds)
+		return tx, saves
+	}
+	tx, _ := newTx()
+	descriptions, err := tx.DryRun()
+	require.NoError(t, err)
+	assert.Equal(t, "", descriptions[0].Group)
+	assert.Equal(t, "saves", descriptions[1].Group)
+	assert.Equal(t, "finds/counts", descriptions[len(descriptions)-1].Group)
+
+	tx, saves := newTx()
+	assert.Equal(t, "saves", saves.Name())
+	require.NoError(t, tx.Exec())
+	assert.Equal(t, models[0], found)
+	assert.Equal(t, 2, count)
+
+	// A group can only be added once
+	tx = testPool.NewTransaction()
+	tx.AddGroup(saves)
+	assert.Error(t, tx.Exec())
+}
+
+func TestAddGroupErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("SET", "notAList", "value")
+	require.NoError(t, err)
+	models := createTestModels(1)
+
+	// Errors from Redis should be attributed to the group
+	good := testPool.NewCommandGroup("good")
+	good.Save(testModels, models[0])
+	bad := testPool.NewCommandGroup("bad")
+	bad.Command("LPUSH", redis.Args{"notAList", "a"}, nil)
+	tx := testPool.NewTransaction()
+	tx.AddGroup(good)
+	tx.AddGroup(bad)
+	err = tx.Exec()
+	txErr := TransactionError{}
+	require.True(t, errors.As(err, &txErr), "unexpected error: %v", err)
+	require.Len(t, txErr.Errors, 1)
+	assert.Equal(t, "bad", txErr.Errors[0].Group)
+	assert.Equal(t, []string{"bad"}, txErr.FailedGroups())
+	assert.Contains(t, err.Error(), "bad")
+	exists, err := testModels.Exists(models[0].ModelID())
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Errors which occur while building the group should include its name
+	invalid := testPool.NewCommandGroup("invalid")
+	invalid.Save(indexedTestModels, &testModel{})
+	tx = testPool.NewTransaction()
+	tx.AddGroup(invalid)
+	err = tx.Exec()
+	assert.True(t, errors.Is(err, ErrWrongModelType), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "invalid")
+
+	// Groups cannot watch keys
+	watching := testPool.NewCommandGroup("watching")
+	require.NoError(t, watching.WatchKey("key"))
+	tx = testPool.NewTransaction()
+	tx.AddGroup(watching)
+	assert.Error(t, tx.Exec())
+}
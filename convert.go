@@ -8,6 +8,8 @@
 package zoom
 
 import (
+	"encoding"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -27,8 +29,15 @@ func scanModel(fieldNames []string, fieldValues []interface{}, mr *modelRef) err
 	if fieldValues == nil || len(fieldValues) == 0 {
 		return newModelNotFoundError(mr)
 	}
+	generated := mr.generatedFields()
 	for i, reply := range fieldValues {
 		if reply == nil {
+			// Fields with a ttl are absent once they expire, so make sure the
+			// model does not keep an old value.
+			if fs, found := ms.fieldsByName[fieldNames[i]]; found && fs.ttl > 0 {
+				fieldVal := mr.fieldValue(fs.name)
+				fieldVal.Set(reflect.Zero(fieldVal.Type()))
+			}
 			continue
 		}
 		fieldName := fieldNames[i]
@@ -44,25 +53,41 @@ func scanModel(fieldNames []string, fieldValues []interface{}, mr *modelRef) err
 		}
 		fs, found := ms.fieldsByName[fieldName]
 		if !found {
-			return fmt.Errorf("zoom: Error in scanModel: Could not find field %s in %T", fieldName, mr.model)
+			return newKindError(ErrFieldNotFound, "zoom: Error in scanModel: Could not find field %s in %T", fieldName, mr.model)
 		}
-		fieldVal := mr.fieldValue(fieldName)
-		switch fs.kind {
-		case primativeField:
-			if err := scanPrimitiveVal(replyBytes, fieldVal); err != nil {
-				return err
-			}
-		case pointerField:
-			if err := scanPointerVal(replyBytes, fieldVal); err != nil {
-				return err
-			}
-		default:
-			if err := scanInconvertibleVal(mr.spec.fallback, replyBytes, fieldVal); err != nil {
-				return err
+		if fs.method != "" {
+			// Computed fields are never read back into the model.
+			continue
+		}
+		if generated != nil && fs.usesGeneratedCode() {
+			if ok, err := generated.ZoomScanHashField(fieldName, replyBytes); ok {
+				if err != nil {
+					return err
+				}
+				continue
 			}
 		}
+		if err := ms.scanFieldVal(fs, replyBytes, mr.fieldValue(fieldName)); err != nil {
+			return err
+		}
+	}
+	return mr.recordLoadedFields(fieldNames)
+}
+
+// scanFieldVal converts src, the value of the field described by fs in the
+// main hash, into the type of the field and then sets dest to that value.
+func (ms *modelSpec) scanFieldVal(fs *fieldSpec, src []byte, dest reflect.Value) error {
+	switch fs.kind {
+	case primativeField:
+		if fs.enum != nil {
+			return fs.scanEnumVal(src, dest)
+		}
+		return scanPrimitiveVal(src, dest)
+	case pointerField:
+		return scanPointerVal(src, dest)
+	default:
+		return scanInconvertibleVal(ms.fallback, src, dest)
 	}
-	return nil
 }
 
 // scanPrimitiveVal converts a slice of bytes response from redis into the type of dest
@@ -120,15 +145,119 @@ func scanPointerVal(src []byte, dest reflect.Value) error {
 }
 
 // scanIncovertibleVal unmarshals src into dest using the given
-// MarshalerUnmarshaler
+// MarshalerUnmarshaler, unless the type of dest implements
+// encoding.TextUnmarshaler or encoding.BinaryUnmarshaler (see
+// interfaceEncodingForType).
 func scanInconvertibleVal(marshalerUnmarshaler MarshalerUnmarshaler, src []byte, dest reflect.Value) error {
 	// Skip empty or nil fields
 	if len(src) == 0 || string(src) == "NULL" {
 		return nil
 	}
-	// TODO: account for json, msgpack or other custom fallbacks
-	if err := marshalerUnmarshaler.Unmarshal(src, dest.Addr().Interface()); err != nil {
-		return err
+	err := unmarshalInterfaceVal(src, dest)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errNoInterfaceEncoding):
+		return marshalerUnmarshaler.Unmarshal(src, dest.Addr().Interface())
+	default:
+		// The value may have been stored with the MarshalerUnmarshaler by an
+		// older version of Zoom, so try that before giving up.
+		if fallbackErr := marshalerUnmarshaler.Unmarshal(src, dest.Addr().Interface()); fallbackErr != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// interfaceEncoding is the way a value of an inconvertible type is encoded if
+// the type implements the interfaces in the encoding package.
+type interfaceEncoding int
+
+const (
+	noInterfaceEncoding     interfaceEncoding = iota // use the MarshalerUnmarshaler
+	textInterfaceEncoding                            // encoding.TextMarshaler
+	binaryInterfaceEncoding                          // encoding.BinaryMarshaler
+)
+
+var (
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// errNoInterfaceEncoding is returned by unmarshalInterfaceVal when the type
+// of dest does not implement the interfaces in the encoding package.
+var errNoInterfaceEncoding = errors.New("zoom: type does not implement encoding.TextUnmarshaler or encoding.BinaryUnmarshaler")
+
+// interfaceEncodingForType returns the way values of an inconvertible type
+// (or of the type it points to) should be encoded. Types which implement both
+// encoding.TextMarshaler and encoding.TextUnmarshaler (with a value or pointer
+// receiver) are stored as text, e.g. so that a UUID stays readable. Otherwise
+// types which implement both encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler are stored in their binary form. All other types
+// use the MarshalerUnmarshaler of the collection.
+func interfaceEncodingForType(typ reflect.Type) interfaceEncoding {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	ptrType := reflect.PtrTo(typ)
+	switch {
+	case ptrType.Implements(textMarshalerType) && ptrType.Implements(textUnmarshalerType):
+		return textInterfaceEncoding
+	case ptrType.Implements(binaryMarshalerType) && ptrType.Implements(binaryUnmarshalerType):
+		return binaryInterfaceEncoding
+	default:
+		return noInterfaceEncoding
+	}
+}
+
+// marshalInterfaceVal encodes val, which must not be a nil pointer, with enc,
+// which is the result of interfaceEncodingForType for the type of val (or the
+// type it points to) and must not be noInterfaceEncoding.
+func marshalInterfaceVal(val reflect.Value, enc interfaceEncoding) (data []byte, err error) {
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	// Copy the value so that methods with a pointer receiver can be called
+	// even if val is not addressable (e.g. because it is an element of a map).
+	ptr := reflect.New(val.Type())
+	ptr.Elem().Set(val)
+	if enc == textInterfaceEncoding {
+		data, err = ptr.Interface().(encoding.TextMarshaler).MarshalText()
+	} else {
+		data, err = ptr.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	}
+	return data, err
+}
+
+// unmarshalInterfaceVal decodes src according to interfaceEncodingForType and
+// sets dest to the result, allocating a new value if dest is a pointer. It
+// returns errNoInterfaceEncoding if the type of dest does not implement the
+// interfaces in the encoding package.
+func unmarshalInterfaceVal(src []byte, dest reflect.Value) error {
+	typ := dest.Type()
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	enc := interfaceEncodingForType(typ)
+	if enc == noInterfaceEncoding {
+		return errNoInterfaceEncoding
+	}
+	ptr := reflect.New(typ)
+	var err error
+	if enc == textInterfaceEncoding {
+		err = ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText(src)
+	} else {
+		err = ptr.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(src)
+	}
+	if err != nil {
+		return fmt.Errorf("zoom: could not unmarshal %q into %s: %w", src, dest.Type(), err)
+	}
+	if dest.Kind() == reflect.Ptr {
+		dest.Set(ptr)
+	} else {
+		dest.Set(ptr.Elem())
 	}
 	return nil
 }
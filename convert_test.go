@@ -8,9 +8,13 @@
 package zoom
 
 import (
+	"math/big"
+	"net/url"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/garyburd/redigo/redis"
 )
 
 func TestConvertPrimatives(t *testing.T) {
@@ -103,6 +107,65 @@ func TestJSONFallback(t *testing.T) {
 	testConvertType(t, jsonModels, model)
 }
 
+func TestEncodingInterfaces(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type encodingModel struct {
+		Time    time.Time
+		Big     *big.Int
+		URL     url.URL
+		BigList []*big.Int `zoom:"list"`
+		RandomID
+	}
+	encodingModels, err := testPool.NewCollection(&encodingModel{})
+	if err != nil {
+		t.Fatalf("Unexpected error in testPool.NewCollection: %s", err.Error())
+	}
+	model := &encodingModel{
+		Time:    time.Date(2015, time.March, 14, 9, 26, 53, 0, time.UTC),
+		Big:     big.NewInt(1234567890),
+		URL:     url.URL{Scheme: "https", Host: "example.com", Path: "/a"},
+		BigList: []*big.Int{big.NewInt(1), big.NewInt(-2)},
+	}
+	testConvertType(t, encodingModels, model)
+
+	// Types which implement encoding.TextMarshaler should be stored as text, and
+	// types which only implement encoding.BinaryMarshaler in binary form.
+	key := encodingModels.ModelKey(model.ModelID())
+	expectFieldEquals(t, key, "Time", nil, []byte("2015-03-14T09:26:53Z"))
+	expectFieldEquals(t, key, "Big", nil, []byte("1234567890"))
+	expectFieldEquals(t, key, "URL", nil, []byte("https://example.com/a"))
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	list, err := redis.Strings(conn.Do("LRANGE", key+":BigList", 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"1", "-2"}, list) {
+		t.Errorf("Elements of list field were incorrect. Expected [1 -2] but got %v", list)
+	}
+
+	// Values stored with the fallback MarshalerUnmarshaler (e.g. by an older
+	// version of Zoom) should still be readable.
+	gobTime, err := GobMarshalerUnmarshaler.Marshal(model.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("HSET", key, "Time", gobTime); err != nil {
+		t.Fatal(err)
+	}
+	modelCopy := &encodingModel{}
+	if err := encodingModels.Find(model.ModelID(), modelCopy); err != nil {
+		t.Fatalf("Unexpected error in Find: %s", err.Error())
+	}
+	if !modelCopy.Time.Equal(model.Time) {
+		t.Errorf("Time stored with gob was incorrect. Expected %s but got %s", model.Time, modelCopy.Time)
+	}
+}
+
 type Embeddable struct {
 	Int    int
 	String string
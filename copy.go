@@ -0,0 +1,98 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File copy.go contains code for copying models from one pool (i.e. one Redis
+// database) to another.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// copyBatchSize is the number of models read and saved at a time by CopyTo.
+const copyBatchSize = 1000
+
+// CopyTo copies the models which match query from the collection to the
+// collection registered with otherPool under the same name, and returns the
+// number of models that were copied. It is useful for migrating a tenant to
+// another Redis instance or promoting data from one environment to another.
+// If query is nil, all the models in the collection are copied. The models
+// are read in batches, in the order of the query, and each batch is saved in
+// a single transaction on otherPool, so any field indexes are rebuilt there
+// according to the options of the destination collection. Existing models
+// with the same ids are overwritten, and the timestamps (see Timestamps) of
+// the models are copied as-is. Like ForEachBatch, CopyTo is not atomic: if it
+// returns an error, some of the models may already have been copied, and
+// models which are saved or deleted in the source collection while it runs
+// may be missed or copied twice. CopyTo returns an error if the query is for a
+// different collection or if no collection with the same name and model type
+// is registered with otherPool.
+func (c *Collection) CopyTo(otherPool *Pool, query *Query) (int, error) {
+	if c == nil {
+		return 0, newNilCollectionError("CopyTo")
+	}
+	if otherPool == nil {
+		return 0, fmt.Errorf("zoom: Error in CopyTo: otherPool cannot be nil")
+	}
+	if query == nil {
+		query = c.NewQuery()
+	} else if query.collection != c {
+		return 0, fmt.Errorf("zoom: Error in CopyTo: the query is for the collection %s, not %s", query.collection.Name(), c.Name())
+	}
+	dest, found := otherPool.Collection(c.Name())
+	if !found {
+		return 0, fmt.Errorf("zoom: Error in CopyTo: no collection named %s is registered with the destination pool", c.Name())
+	}
+	if dest.spec.typ != c.spec.typ {
+		return 0, newKindError(ErrWrongModelType, "zoom: Error in CopyTo: the collection %s has type %s in the destination pool but %s in the source pool", c.Name(), dest.spec.typ, c.spec.typ)
+	}
+	count := 0
+	for offset := query.offset; ; offset += copyBatchSize {
+		limit := uint(copyBatchSize)
+		if query.hasLimit() {
+			remaining := query.limit - (offset - query.offset)
+			if remaining == 0 {
+				return count, nil
+			}
+			if remaining < limit {
+				limit = remaining
+			}
+		}
+		// Every field must be read, since saving a model writes all of its
+		// fields.
+		batch := &Query{query: query.query.copy()}
+		batch.includes = nil
+		batch.excludes = nil
+		batch.offset = offset
+		batch.limit = limit
+		models := reflect.New(reflect.SliceOf(c.spec.typ))
+		if err := batch.Run(models.Interface()); err != nil {
+			return count, err
+		}
+		modelsVal := models.Elem()
+		numModels := modelsVal.Len()
+		if numModels == 0 {
+			return count, nil
+		}
+		// Save the models through saveModelRef instead of Save, which would
+		// change their UpdatedAt timestamp.
+		t := otherPool.NewTransaction()
+		for i := 0; i < numModels; i++ {
+			t.saveModelRef(&modelRef{
+				collection: dest,
+				model:      modelsVal.Index(i).Interface().(Model),
+				spec:       dest.spec,
+			})
+		}
+		if err := t.Exec(); err != nil {
+			return count, err
+		}
+		count += numModels
+		if uint(numModels) < limit {
+			return count, nil
+		}
+	}
+}
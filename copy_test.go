@@ -0,0 +1,66 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File copy_test.go tests the code in copy.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyTo(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	options := testPool.options
+	otherPool := NewPoolWithOptions(options.WithDatabase(options.Database + 3))
+	defer func() {
+		conn := otherPool.NewConn()
+		_, _ = conn.Do("FLUSHDB")
+		_ = conn.Close()
+		_ = otherPool.Close()
+	}()
+	_, err := indexedTestModels.CopyTo(otherPool, nil)
+	assert.Error(t, err, "expected an error because the collection is not registered with otherPool")
+	dest, err := otherPool.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true).WithName(indexedTestModels.Name()))
+	require.NoError(t, err)
+
+	models := []*indexedTestModel{}
+	tx := testPool.NewTransaction()
+	for i := 0; i < 10; i++ {
+		model := &indexedTestModel{Int: i, String: randomString(), Bool: randomBool()}
+		tx.Save(indexedTestModels, model)
+		models = append(models, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	// Only the models which match the query should be copied, and the indexes
+	// should be rebuilt in the destination pool
+	count, err := indexedTestModels.CopyTo(otherPool, indexedTestModels.NewQuery().Filter("Int >=", 3).Order("Int").Offset(1).Limit(4).Include("Int"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	copied := []*indexedTestModel{}
+	require.NoError(t, dest.NewQuery().Order("Int").Run(&copied))
+	assert.Equal(t, models[4:8], copied)
+	ids, err := dest.NewQuery().Filter("Int <", 6).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[4].ID, models[5].ID}, ids)
+
+	// Copying everything should overwrite the models which were already copied
+	count, err = indexedTestModels.CopyTo(otherPool, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 10, count)
+	total, err := dest.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 10, total)
+
+	_, err = indexedTestModels.CopyTo(otherPool, testModels.NewQuery())
+	assert.Error(t, err)
+	_, err = indexedTestModels.CopyTo(nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File default_order.go contains code for ordering FindAll and queries without
+// an Order by the default order field of a collection.
+
+package zoom
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseDefaultOrder returns the order described by fieldName, which has the
+// same format as the argument to Query.Order, or an empty order if fieldName is
+// empty. It returns an error if the field does not exist, is not indexed, or is
+// a pointer, since models for which a pointer field is nil are not in the
+// field index and would be missing from FindAll.
+func (spec *modelSpec) parseDefaultOrder(fieldName string) (order, error) {
+	if fieldName == "" {
+		return order{}, nil
+	}
+	kind := ascendingOrder
+	if strings.HasPrefix(fieldName, "-") {
+		kind = descendingOrder
+		fieldName = fieldName[1:]
+	}
+	fs, found := spec.fieldsByName[fieldName]
+	switch {
+	case !found:
+		return order{}, newKindError(ErrFieldNotFound, "CollectionOptions.DefaultOrderField: could not find field %s in type %s", fieldName, spec.typ.String())
+	case fs.indexKind == noIndex:
+		return order{}, newKindError(ErrUnindexedField, "CollectionOptions.DefaultOrderField: %s is not an indexed field", fieldName)
+	case fs.kind == pointerField:
+		return order{}, fmt.Errorf("CollectionOptions.DefaultOrderField: %s is a pointer, so models for which it is nil could not be ordered", fieldName)
+	}
+	return order{
+		fieldName: fs.name,
+		redisName: fs.redisName,
+		kind:      kind,
+	}, nil
+}
+
+// applyDefaultOrder orders the query by the default order field of the
+// collection (see CollectionOptions.DefaultOrderField), if any. The default
+// order is replaced by Order and is not included in String or the JSON
+// encoding of the query.
+func (q *query) applyDefaultOrder() {
+	if q.collection.defaultOrder.fieldName == "" || q.hasError() {
+		return
+	}
+	q.order = q.collection.defaultOrder
+	q.defaultOrder = true
+}
+
+// hasExplicitOrder returns true iff the query has an order which was given
+// with Order, rather than the default order of the collection.
+func (q *query) hasExplicitOrder() bool {
+	return q.hasOrder() && !q.defaultOrder
+}
+
+// allIDs adds commands to the transaction which create a key that contains the
+// ids of all models in the collection, in the default order of the collection
+// (see CollectionOptions.DefaultOrderField) if it has one. Without a default
+// order it is simply the index of all models. reverse is true if the ids must
+// be read in reverse order. tmpKeys should be deleted after the ids have been
+// read.
+func (t *Transaction) allIDs(c *Collection) (idsKey string, reverse bool, tmpKeys []interface{}, err error) {
+	if c.defaultOrder.fieldName == "" {
+		return c.spec.indexKey(), false, nil, nil
+	}
+	q := newQuery(c)
+	q.applyDefaultOrder()
+	idsKey, tmpKeys, err = generateIDsSet(q, t)
+	return idsKey, c.defaultOrder.kind == descendingOrder, tmpKeys, err
+}
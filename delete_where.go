@@ -0,0 +1,87 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File delete_where.go contains code for deleting all the models which match
+// a set of filters without reading their ids.
+
+package zoom
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// FilterSpec is a filter which can be passed to Collection.DeleteWhere. Filter
+// and Value have the same meaning as the arguments to Query.Filter, e.g.
+//
+//	FilterSpec{Filter: "Age >=", Value: 18}
+type FilterSpec struct {
+	Filter string
+	Value  interface{}
+}
+
+// DeleteWhere deletes all the models in the collection which match all of the
+// given filters, including their field indexes, and returns the number of
+// models that were deleted. Same as with Query.Filter, each filter must be on
+// an indexed field. The ids of the matching models are extracted into a
+// temporary set and the models are deleted by a Lua script in the same
+// transaction, so the ids are never sent to the client and DeleteWhere is
+// atomic. If the collection has a default scope (see
+// CollectionOptions.DefaultScope), only the models in the scope are deleted.
+// If no filters are given, DeleteWhere deletes all the models in the scope.
+// DeleteWhere will return an error if the collection is not indexed, if any
+// of the filters are invalid, or if the models are referenced by a field with
+// the ref option, which only Delete enforces.
+func (c *Collection) DeleteWhere(filters ...FilterSpec) (int, error) {
+	q := c.NewQuery()
+	for _, f := range filters {
+		q.Filter(f.Filter, f.Value)
+	}
+	tx := q.newTransaction()
+	var count int
+	newTransactionQuery(q.query, tx).deleteWhere(&count)
+	if err := tx.Exec(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// deleteWhere is like Delete, but deletes the models directly from the set of
+// matching ids instead of storing them in a list first. The order of the query
+// does not matter, so it is ignored unless the query has a Last modifier. The
+// ids can only be limited by sorting them into a list, so if the query has a
+// Limit or Offset, deleteWhere falls back to Delete.
+func (q *TransactionQuery) deleteWhere(count *int) {
+	q = q.intercept("DeleteWhere")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if err := q.collection.checkUnreferenced("DeleteWhere"); err != nil {
+		q.tx.setError(err)
+		return
+	}
+	if q.hasLimit() || q.hasOffset() {
+		q.Delete(count)
+		return
+	}
+	query := q.query
+	if query.hasOrder() && !query.hasLast() {
+		query = query.copy()
+		query.order = order{}
+		query.defaultOrder = false
+	}
+	idsKey, tmpKeys, err := generateIDsSet(query, q.tx)
+	if err != nil {
+		q.tx.setError(err)
+		return
+	}
+	var handler ReplyHandler
+	if count != nil {
+		handler = NewScanIntHandler(count)
+	}
+	q.tx.deleteModelsByListIDs(idsKey, query.collection.spec, handler)
+	if len(tmpKeys) > 0 {
+		q.tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
+	}
+}
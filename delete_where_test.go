@@ -0,0 +1,60 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File delete_where_test.go tests the code in delete_where.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteWhere(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*indexedTestModel{}
+	tx := testPool.NewTransaction()
+	for i := 0; i < 10; i++ {
+		model := &indexedTestModel{Int: i, String: randomString(), Bool: i%2 == 0}
+		tx.Save(indexedTestModels, model)
+		models = append(models, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	count, err := indexedTestModels.DeleteWhere(
+		FilterSpec{Filter: "Int >=", Value: 4},
+		FilterSpec{Filter: "Bool =", Value: true},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	for _, model := range models {
+		if model.Int >= 4 && model.Bool {
+			expectModelDoesNotExist(t, indexedTestModels, model)
+			for _, fieldName := range []string{"Int", "String", "Bool"} {
+				expectIndexDoesNotExist(t, indexedTestModels, model, fieldName)
+			}
+		} else {
+			expectModelExists(t, indexedTestModels, model)
+			expectIndexExists(t, indexedTestModels, model, "Int")
+		}
+	}
+	remaining, err := indexedTestModels.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 7, remaining)
+
+	// Without any filters, all the models are deleted
+	count, err = indexedTestModels.DeleteWhere()
+	require.NoError(t, err)
+	assert.Equal(t, 7, count)
+	remaining, err = indexedTestModels.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+
+	_, err = indexedTestModels.DeleteWhere(FilterSpec{Filter: "Invalid =", Value: 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,519 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File dynamic.go contains DynamicCollection and DynamicQuery, which work with
+// models represented as maps and a schema which is defined at runtime instead
+// of a Go struct type.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DynamicIDKey is the key which holds the id of a model in the maps used by
+// DynamicCollection and DynamicQuery.
+const DynamicIDKey = "ID"
+
+// DynamicCollection is like Collection, but the models are represented as maps
+// of field names to values (e.g. map[string]interface{}{"Name": "Bob"}) and the
+// fields are described by a Schema instead of a Go struct type. It is useful
+// for services which only learn about the shape of their data at runtime,
+// e.g. plugin systems. Use Pool.NewDynamicCollection to create one. Models
+// saved in a DynamicCollection are stored exactly like models of a struct type
+// with the same fields, so the two can be used interchangeably.
+type DynamicCollection struct {
+	collection *Collection
+}
+
+// dynamicFieldTypes maps the types which can be used for the fields of a
+// DynamicCollection (see SchemaField.Type) to the corresponding Go types.
+var dynamicFieldTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, value := range []interface{}{
+		"", []byte{}, false,
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+	} {
+		typ := reflect.TypeOf(value)
+		dynamicFieldTypes[typ.String()] = typ
+		if typ.Kind() != reflect.Slice {
+			dynamicFieldTypes[reflect.PtrTo(typ).String()] = reflect.PtrTo(typ)
+		}
+	}
+}
+
+// NewDynamicCollection registers and returns a new DynamicCollection with the
+// given schema. The name of the collection is schema.Name, and options.Name is
+// ignored. The type of each field must be the name of a primitive Go type
+// (e.g. "string", "int64", or "float64") or a pointer to one (e.g. "*int"),
+// and the index (if any) must be the kind of index Zoom would use for a field
+// of that type with the `zoom:"index"` struct tag. Fields which are stored in
+// their own key (i.e. with a Storage) and computed fields are not supported,
+// and neither are options.UseRediSearch, options.NewModel,
+// options.ReleaseModel, options.Collations, options.Eviction, and
+// options.Layout (or Schema.Layout). The name must not already be registered with the pool.
+func (p *Pool) NewDynamicCollection(schema Schema, options CollectionOptions) (*DynamicCollection, error) {
+	switch {
+	case schema.Name == "":
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: Schema.Name cannot be empty")
+	case strings.Contains(schema.Name, ":"):
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: Schema.Name cannot contain a colon. Got: %s", schema.Name)
+	case options.UseRediSearch:
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: CollectionOptions.UseRediSearch is not supported")
+	case options.NewModel != nil || options.ReleaseModel != nil:
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: CollectionOptions.NewModel and ReleaseModel are not supported")
+	case len(options.Collations) > 0:
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: CollectionOptions.Collations is not supported")
+	case options.Eviction.enabled():
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: CollectionOptions.Eviction is not supported")
+	case options.Layout != HashLayout || schema.Layout != "":
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: CollectionOptions.Layout and Schema.Layout are not supported")
+	case options.AuditMaxLen < 0:
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: CollectionOptions.AuditMaxLen cannot be negative. Got: %d", options.AuditMaxLen)
+	case options.OutboxMaxLen < 0:
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: CollectionOptions.OutboxMaxLen cannot be negative. Got: %d", options.OutboxMaxLen)
+	}
+	if options.AuditMaxLen == 0 {
+		options.AuditMaxLen = DefaultAuditMaxLen
+	}
+	if options.OutboxMaxLen == 0 {
+		options.OutboxMaxLen = DefaultOutboxMaxLen
+	}
+	spec, err := compileDynamicModelSpec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: %w", err)
+	}
+	spec.fallback = options.FallbackMarshalerUnmarshaler
+	spec.indexNamespace = options.IndexNamespace
+	defaultOrder, err := spec.parseDefaultOrder(options.DefaultOrderField)
+	if err != nil {
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: %w", err)
+	}
+	collection := &Collection{
+		spec:         spec,
+		pool:         p,
+		index:        options.Index,
+		redisTime:    options.UseRedisTime,
+		audit:        options.Audit,
+		auditMaxLen:  options.AuditMaxLen,
+		outbox:       options.Outbox,
+		outboxMaxLen: options.OutboxMaxLen,
+		defaultOrder: defaultOrder,
+		sortedIndex:  options.Index && options.SortedIndex,
+	}
+
+	// Dynamic collections are only added to modelNameToSpec. They do not have
+	// a model type and cannot be used where a Collection is expected.
+	p.registryMut.Lock()
+	if p.nameIsRegisteredLocked(spec.name) {
+		p.registryMut.Unlock()
+		return nil, fmt.Errorf("zoom: Error in NewDynamicCollection: The name %s has already been registered", spec.name)
+	}
+	p.modelNameToSpec[spec.name] = spec
+	p.registryMut.Unlock()
+	// See NewCollectionWithOptions
+	_ = p.storeSchema(spec, false)
+	return &DynamicCollection{collection: collection}, nil
+}
+
+// compileDynamicModelSpec returns a modelSpec for the fields described by
+// schema.
+func compileDynamicModelSpec(schema Schema) (*modelSpec, error) {
+	ms := &modelSpec{
+		typ:          reflect.TypeOf(map[string]interface{}{}),
+		name:         schema.Name,
+		fieldsByName: map[string]*fieldSpec{},
+	}
+	for _, field := range schema.Fields {
+		if field.Name == "" || field.Name == DynamicIDKey {
+			return nil, fmt.Errorf("invalid field name %q", field.Name)
+		}
+		if field.Storage != "" {
+			return nil, fmt.Errorf("storage option %s is not supported (on field %s)", field.Storage, field.Name)
+		}
+		if field.Computed {
+			return nil, fmt.Errorf("computed fields are not supported (on field %s)", field.Name)
+		}
+		typ, found := dynamicFieldTypes[field.Type]
+		if !found {
+			return nil, fmt.Errorf("unsupported type %q for field %s", field.Type, field.Name)
+		}
+		fs := &fieldSpec{
+			kind:      primativeField,
+			name:      field.Name,
+			redisName: field.RedisName,
+			typ:       typ,
+		}
+		if fs.redisName == "" {
+			fs.redisName = field.Name
+		}
+		if typ.Kind() == reflect.Ptr {
+			fs.kind = pointerField
+			typ = typ.Elem()
+		}
+		if field.Index == "enum" {
+			if fs.kind != primativeField || typ.Kind() != reflect.String || len(field.Enum) == 0 {
+				return nil, fmt.Errorf("invalid enum field %s (enum fields must be strings with at least one value)", field.Name)
+			}
+			fs.enum = field.Enum
+			fs.indexKind = enumIndex
+		} else if field.Index != "" {
+			if err := setIndexKind(fs, typ); err != nil {
+				return nil, err
+			}
+			if fs.indexKind.String() != field.Index {
+				return nil, fmt.Errorf("invalid index %q for field %s (fields of type %s have a %s index)", field.Index, field.Name, field.Type, fs.indexKind)
+			}
+		}
+		if err := ms.addField(fs); err != nil {
+			return nil, err
+		}
+	}
+	ms.compileEncodings()
+	return ms, nil
+}
+
+// Name returns the name of the collection.
+func (dc *DynamicCollection) Name() string {
+	return dc.collection.Name()
+}
+
+// Schema returns the schema of the collection.
+func (dc *DynamicCollection) Schema() Schema {
+	return dc.collection.spec.schema()
+}
+
+// IndexKey is like Collection.IndexKey.
+func (dc *DynamicCollection) IndexKey() string {
+	return dc.collection.IndexKey()
+}
+
+// ModelKey is like Collection.ModelKey.
+func (dc *DynamicCollection) ModelKey(id string) string {
+	return dc.collection.ModelKey(id)
+}
+
+// Save writes model to the database. The id of the model is model[DynamicIDKey]
+// (which must be a string). If it is missing or empty, a pseudo-random id is
+// generated and model[DynamicIDKey] is set to it. All the other keys must be the
+// names of fields in the schema, and the values must have the same type as the
+// field, except that the underlying type may be used for pointer fields and
+// numbers of another type may be used if they can be converted without losing
+// information (e.g. float64 values decoded from JSON for int fields). Fields
+// which are missing from model are saved with their zero value (or nil for
+// pointer fields).
+func (dc *DynamicCollection) Save(model map[string]interface{}) error {
+	id := ""
+	if value, found := model[DynamicIDKey]; found {
+		var ok bool
+		if id, ok = value.(string); !ok {
+			return fmt.Errorf("zoom: Error in DynamicCollection.Save: %s must be a string but got %T", DynamicIDKey, value)
+		}
+	}
+	mr := dc.collection.newValuesModelRef(id)
+	for fieldName, value := range model {
+		if fieldName == DynamicIDKey {
+			continue
+		}
+		fs, found := dc.collection.spec.fieldsByName[fieldName]
+		if !found {
+			return newKindError(ErrFieldNotFound, "zoom: Error in DynamicCollection.Save: Collection %s does not have field named %s", dc.Name(), fieldName)
+		}
+		fieldVal, err := dynamicFieldValue(fs, value)
+		if err != nil {
+			return fmt.Errorf("zoom: Error in DynamicCollection.Save: %w", err)
+		}
+		mr.values[fieldName].Set(fieldVal)
+	}
+	model[DynamicIDKey] = mr.model.ModelID()
+	t := dc.collection.pool.NewTransaction()
+	t.saveModelRef(mr)
+	return t.Exec()
+}
+
+// dynamicFieldValue is like fieldValueOf, but also converts numbers of another
+// type to the type of the field described by fs, as long as the conversion does
+// not change the value.
+func dynamicFieldValue(fs *fieldSpec, value interface{}) (reflect.Value, error) {
+	fieldVal, err := fieldValueOf(fs, value)
+	if err == nil || value == nil {
+		return fieldVal, err
+	}
+	typ := fs.typ
+	if fs.kind == pointerField {
+		typ = typ.Elem()
+	}
+	val := reflect.ValueOf(value)
+	if !typeIsNumeric(val.Type()) || !typeIsNumeric(typ) {
+		return reflect.Value{}, err
+	}
+	converted := val.Convert(typ)
+	if converted.Convert(val.Type()).Interface() != val.Interface() {
+		return reflect.Value{}, fmt.Errorf("zoom: invalid value for %s: %v cannot be converted to %s without losing information", fs.name, value, typ.String())
+	}
+	return fieldValueOf(fs, converted.Interface())
+}
+
+// document returns the model behind mr as a map, including the id and the
+// given fields. Nil pointer fields are represented by nil, and all other
+// pointer fields by the value they point to.
+func (mr *modelRef) document(fieldNames []string) map[string]interface{} {
+	doc := map[string]interface{}{
+		DynamicIDKey: mr.model.ModelID(),
+	}
+	for _, fieldName := range fieldNames {
+		fieldVal, found := mr.values[fieldName]
+		if !found {
+			continue
+		}
+		if fieldVal.Kind() == reflect.Ptr {
+			if fieldVal.IsNil() {
+				doc[fieldName] = nil
+				continue
+			}
+			fieldVal = fieldVal.Elem()
+		}
+		doc[fieldName] = fieldVal.Interface()
+	}
+	return doc
+}
+
+// Find is like Collection.Find but returns the model as a map. See
+// DynamicCollection.Save for a description of the map.
+func (dc *DynamicCollection) Find(id string) (map[string]interface{}, error) {
+	return dc.FindFields(id, dc.collection.spec.fieldNames())
+}
+
+// FindFields is like Find but only includes the given fields in the returned
+// map. It returns an error if any of the fieldNames are not in the schema.
+func (dc *DynamicCollection) FindFields(id string, fieldNames []string) (map[string]interface{}, error) {
+	redisNames, err := dc.collection.spec.redisNamesForFieldNames(fieldNames)
+	if err != nil {
+		return nil, fmt.Errorf("zoom: Error in DynamicCollection.FindFields: %w", err)
+	}
+	mr := dc.collection.newValuesModelRef(id)
+	t := dc.collection.pool.NewTransaction()
+	t.Command("EXISTS", redis.Args{mr.key()}, newModelExistsHandler(dc.collection, id))
+	if len(redisNames) > 0 {
+		args := redis.Args{mr.key()}.AddFlat(redisNames)
+		t.Command("HMGET", args, newScanModelRefHandler(fieldNames, mr))
+	}
+	if err := t.Exec(); err != nil {
+		return nil, err
+	}
+	return mr.document(fieldNames), nil
+}
+
+// Exists is like Collection.Exists.
+func (dc *DynamicCollection) Exists(id string) (bool, error) {
+	return dc.collection.Exists(id)
+}
+
+// ExistsMany is like Collection.ExistsMany.
+func (dc *DynamicCollection) ExistsMany(ids []string) (map[string]bool, error) {
+	return dc.collection.ExistsMany(ids)
+}
+
+// Count is like Collection.Count.
+func (dc *DynamicCollection) Count() (int, error) {
+	return dc.collection.Count()
+}
+
+// Delete is like Collection.Delete.
+func (dc *DynamicCollection) Delete(id string) (bool, error) {
+	return dc.collection.Delete(id)
+}
+
+// DeleteAll is like Collection.DeleteAll.
+func (dc *DynamicCollection) DeleteAll() (int, error) {
+	return dc.collection.DeleteAll()
+}
+
+// NewQuery is like Collection.NewQuery but returns a DynamicQuery.
+func (dc *DynamicCollection) NewQuery() *DynamicQuery {
+	return &DynamicQuery{
+		query: dc.collection.NewQuery(),
+		dc:    dc,
+	}
+}
+
+// DynamicQuery is a query for the models in a DynamicCollection. The modifier
+// methods work exactly like the corresponding methods of Query, with field
+// names from the schema of the collection.
+type DynamicQuery struct {
+	query *Query
+	dc    *DynamicCollection
+}
+
+// String returns a string representation of the query.
+func (q *DynamicQuery) String() string {
+	return q.query.String()
+}
+
+// Order is like Query.Order.
+func (q *DynamicQuery) Order(fieldName string) *DynamicQuery {
+	q.query.Order(fieldName)
+	return q
+}
+
+// Limit is like Query.Limit.
+func (q *DynamicQuery) Limit(amount uint) *DynamicQuery {
+	q.query.Limit(amount)
+	return q
+}
+
+// Offset is like Query.Offset.
+func (q *DynamicQuery) Offset(amount uint) *DynamicQuery {
+	q.query.Offset(amount)
+	return q
+}
+
+// Last is like Query.Last.
+func (q *DynamicQuery) Last(n uint) *DynamicQuery {
+	q.query.Last(n)
+	return q
+}
+
+// Timeout is like Query.Timeout.
+func (q *DynamicQuery) Timeout(d time.Duration) *DynamicQuery {
+	q.query.Timeout(d)
+	return q
+}
+
+// Include is like Query.Include.
+func (q *DynamicQuery) Include(fields ...string) *DynamicQuery {
+	q.query.Include(fields...)
+	return q
+}
+
+// Exclude is like Query.Exclude.
+func (q *DynamicQuery) Exclude(fields ...string) *DynamicQuery {
+	q.query.Exclude(fields...)
+	return q
+}
+
+// Filter is like Query.Filter.
+func (q *DynamicQuery) Filter(filterString string, value interface{}) *DynamicQuery {
+	q.query.Filter(filterString, value)
+	return q
+}
+
+// FilterRange is like Query.FilterRange.
+func (q *DynamicQuery) FilterRange(fieldName string, min interface{}, max interface{}, inclusive bool) *DynamicQuery {
+	q.query.FilterRange(fieldName, min, max, inclusive)
+	return q
+}
+
+// Run is like Query.Run but returns the models as maps. See
+// DynamicCollection.Save for a description of the maps.
+func (q *DynamicQuery) Run() ([]map[string]interface{}, error) {
+	return q.run(int(q.query.limit))
+}
+
+// RunOne is like Query.RunOne but returns the model as a map. If no model fits
+// the criteria, RunOne returns a ModelNotFoundError.
+func (q *DynamicQuery) RunOne() (map[string]interface{}, error) {
+	docs, err := q.run(1)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		msg := fmt.Sprintf("Could not find a model with the given query criteria: %s", q)
+		return nil, ModelNotFoundError{Msg: msg}
+	}
+	return docs[0], nil
+}
+
+// run runs the query and returns at most limit models, or all of the models
+// which match the query if limit is 0.
+func (q *DynamicQuery) run(limit int) ([]map[string]interface{}, error) {
+	if q.query.hasError() {
+		return nil, q.query.err
+	}
+	tx := q.query.newTransaction()
+	idsKey, tmpKeys, err := generateIDsSet(q.query.query, tx)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		// In our query syntax, a limit of 0 means unlimited
+		// But in redis, -1 means unlimited
+		limit = -1
+	}
+	spec := q.dc.collection.spec
+	sortArgs := spec.sortArgs(idsKey, q.query.redisFieldNames(), limit, q.query.offset, q.query.order.kind == descendingOrder)
+	docs := []map[string]interface{}{}
+	tx.Command("SORT", sortArgs, newScanDynamicModelsHandler(q.dc, append(q.query.fieldNames(), "-"), &docs))
+	if len(tmpKeys) > 0 {
+		tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
+	}
+	if err := tx.Exec(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// newScanDynamicModelsHandler is like newScanModelsHandler, but scans the
+// reply into maps for the models in dc and sets docs to them.
+func newScanDynamicModelsHandler(dc *DynamicCollection, fieldNames []string, docs *[]map[string]interface{}) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			if err == redis.ErrNil {
+				(*docs) = []map[string]interface{}{}
+				return nil
+			}
+			return err
+		}
+		numFields := len(fieldNames)
+		result := make([]map[string]interface{}, 0, len(values)/numFields)
+		for start := 0; start+numFields <= len(values); start += numFields {
+			mr := dc.collection.newValuesModelRef("")
+			if err := scanModel(fieldNames, values[start:start+numFields], mr); err != nil {
+				return err
+			}
+			result = append(result, mr.document(fieldNames))
+		}
+		(*docs) = result
+		return nil
+	}
+}
+
+// Count is like Query.Count.
+func (q *DynamicQuery) Count() (int, error) {
+	return q.query.Count()
+}
+
+// IDs is like Query.IDs.
+func (q *DynamicQuery) IDs() ([]string, error) {
+	return q.query.IDs()
+}
+
+// Delete is like Query.Delete.
+func (q *DynamicQuery) Delete() (int, error) {
+	return q.query.Delete()
+}
+
+// Update is like Query.Update, except that numbers of another type are
+// converted to the type of the field (see DynamicCollection.Save).
+func (q *DynamicQuery) Update(fieldValues map[string]interface{}) (int, error) {
+	converted := map[string]interface{}{}
+	for fieldName, value := range fieldValues {
+		converted[fieldName] = value
+		if fs, found := q.dc.collection.spec.fieldsByName[fieldName]; found {
+			if fieldVal, err := dynamicFieldValue(fs, value); err == nil {
+				converted[fieldName] = fieldVal.Interface()
+			}
+		}
+	}
+	return q.query.Update(converted)
+}
@@ -0,0 +1,216 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File dynamic_test.go tests the code in dynamic.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var dynamicTestSchema = Schema{
+	Name: "dynamicTestModel",
+	Fields: []SchemaField{
+		{Name: "Name", Type: "string", Index: "string"},
+		{Name: "Age", Type: "int", Index: "numeric"},
+		{Name: "Score", RedisName: "score", Type: "*float64"},
+		{Name: "Active", Type: "bool", Index: "boolean"},
+	},
+}
+
+// dynamicTestModel has the same fields as dynamicTestSchema.
+type dynamicTestModel struct {
+	Name   string   `zoom:"index"`
+	Age    int      `zoom:"index"`
+	Score  *float64 `redis:"score"`
+	Active bool     `zoom:"index"`
+	RandomID
+}
+
+func newDynamicTestCollection(t *testing.T) (*DynamicCollection, *Pool) {
+	pool := NewPoolWithOptions(testPool.options)
+	dc, err := pool.NewDynamicCollection(dynamicTestSchema, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	return dc, pool
+}
+
+func TestDynamicCollection(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	dc, pool := newDynamicTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	score := 9.5
+	bob := map[string]interface{}{"Name": "Bob", "Age": float64(25), "Score": score, "Active": true}
+	require.NoError(t, dc.Save(bob))
+	require.IsType(t, "", bob[DynamicIDKey])
+	require.NotEmpty(t, bob[DynamicIDKey])
+	alice := map[string]interface{}{"ID": "alice", "Name": "Alice", "Age": 31}
+	require.NoError(t, dc.Save(alice))
+
+	got, err := dc.Find(bob[DynamicIDKey].(string))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"ID": bob[DynamicIDKey], "Name": "Bob", "Age": 25, "Score": 9.5, "Active": true}, got)
+	got, err = dc.FindFields("alice", []string{"Age", "Score"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"ID": "alice", "Age": 31, "Score": nil}, got)
+	_, err = dc.Find("carol")
+	assert.IsType(t, ModelNotFoundError{}, err)
+	count, err := dc.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// The models should be readable as structs with the same fields
+	structPool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = structPool.Close()
+	}()
+	col, err := structPool.NewCollectionWithOptions(&dynamicTestModel{}, DefaultCollectionOptions.WithIndex(true).WithName(dc.Name()))
+	require.NoError(t, err)
+	model := &dynamicTestModel{}
+	require.NoError(t, col.Find(bob[DynamicIDKey].(string), model))
+	assert.Equal(t, "Bob", model.Name)
+	assert.Equal(t, 25, model.Age)
+	require.NotNil(t, model.Score)
+	assert.Equal(t, 9.5, *model.Score)
+	ids, err := col.NewQuery().Filter("Age >", 30).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, ids)
+
+	// Saving again should update the indexes
+	alice["Age"] = 20
+	require.NoError(t, dc.Save(alice))
+	ids, err = dc.NewQuery().Order("Age").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", bob[DynamicIDKey].(string)}, ids)
+	ids, err = dc.NewQuery().Filter("Name =", "Alice").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, ids)
+
+	deleted, err := dc.Delete("alice")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	exists, err := dc.Exists("alice")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	ids, err = dc.NewQuery().Filter("Name =", "Alice").IDs()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestDynamicCollectionSaveErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	dc, pool := newDynamicTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	for _, model := range []map[string]interface{}{
+		{"ID": 42},
+		{"Nickname": "Bob"},
+		{"Age": "25"},
+		{"Age": 25.5},
+		{"Active": nil},
+	} {
+		assert.Error(t, dc.Save(model), "model: %v", model)
+	}
+	count, err := dc.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestNewDynamicCollectionErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	for _, schema := range []Schema{
+		{},
+		{Name: "a:b"},
+		{Name: "invalid", Fields: []SchemaField{{Name: "ID", Type: "string"}}},
+		{Name: "invalid", Fields: []SchemaField{{Name: "Tags", Type: "[]string"}}},
+		{Name: "invalid", Fields: []SchemaField{{Name: "Tags", Type: "string", Storage: "set"}}},
+		{Name: "invalid", Fields: []SchemaField{{Name: "Age", Type: "int", Index: "string"}}},
+		{Name: "invalid", Fields: []SchemaField{{Name: "Age", Type: "int"}, {Name: "Age", Type: "int"}}},
+	} {
+		_, err := pool.NewDynamicCollection(schema, DefaultCollectionOptions)
+		assert.Error(t, err, "schema: %v", schema)
+	}
+	_, err := pool.NewDynamicCollection(dynamicTestSchema, DefaultCollectionOptions.WithIndex(true).WithUseRediSearch(true))
+	assert.Error(t, err)
+
+	// The name must not already be registered
+	_, err = pool.NewDynamicCollection(dynamicTestSchema, DefaultCollectionOptions)
+	require.NoError(t, err)
+	_, err = pool.NewDynamicCollection(dynamicTestSchema, DefaultCollectionOptions)
+	assert.Error(t, err)
+	_, err = pool.NewCollectionWithOptions(&dynamicTestModel{}, DefaultCollectionOptions.WithName(dynamicTestSchema.Name))
+	assert.Error(t, err)
+	_, found := pool.Collection(dynamicTestSchema.Name)
+	assert.False(t, found)
+}
+
+func TestDynamicQuery(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	dc, pool := newDynamicTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	for i, name := range []string{"a", "b", "c", "d"} {
+		model := map[string]interface{}{"ID": name, "Name": name, "Age": i, "Active": i%2 == 0}
+		require.NoError(t, dc.Save(model))
+	}
+
+	docs, err := dc.NewQuery().Filter("Active =", true).Order("-Age").Run()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"ID": "c", "Name": "c", "Age": 2, "Score": nil, "Active": true},
+		{"ID": "a", "Name": "a", "Age": 0, "Score": nil, "Active": true},
+	}, docs)
+	docs, err = dc.NewQuery().FilterRange("Age", 1, 2, true).Order("Age").Include("Name").Run()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"ID": "b", "Name": "b"},
+		{"ID": "c", "Name": "c"},
+	}, docs)
+	docs, err = dc.NewQuery().Filter("Age >", 10).Run()
+	require.NoError(t, err)
+	assert.Empty(t, docs)
+
+	doc, err := dc.NewQuery().Order("-Age").Offset(1).RunOne()
+	require.NoError(t, err)
+	assert.Equal(t, "c", doc[DynamicIDKey])
+	_, err = dc.NewQuery().Filter("Name =", "z").RunOne()
+	assert.IsType(t, ModelNotFoundError{}, err)
+	_, err = dc.NewQuery().Filter("Nickname =", "z").Run()
+	assert.Error(t, err)
+
+	count, err := dc.NewQuery().Filter("Age >=", 2).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	updated, err := dc.NewQuery().Filter("Age >=", 2).Update(map[string]interface{}{"Age": float64(10)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated)
+	ids, err := dc.NewQuery().Filter("Age =", 10).Order("Name").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, ids)
+	deleted, err := dc.NewQuery().Filter("Active =", false).Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	count, err = dc.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File enum.go contains code for enum fields, which may only have one of a
+// fixed list of values and are stored and indexed by the position of the
+// value in the list.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// parseEnumValues parses the values of the enum option of the zoom struct tag
+// (e.g. "active|inactive|banned").
+func parseEnumValues(fieldName string, option string) ([]string, error) {
+	values := strings.Split(option, "|")
+	seen := map[string]bool{}
+	for _, value := range values {
+		if value == "" {
+			return nil, fmt.Errorf("zoom: enum option for field %s contains an empty value", fieldName)
+		}
+		if seen[value] {
+			return nil, fmt.Errorf("zoom: enum option for field %s contains duplicate value %s", fieldName, value)
+		}
+		seen[value] = true
+	}
+	return values, nil
+}
+
+// enumOrdinal returns the position of value in the list of values for the
+// enum field fs, which is the value stored in the main hash and the index. It
+// returns an error if value is not one of the allowed values.
+func (fs *fieldSpec) enumOrdinal(value string) (int, error) {
+	for i, allowed := range fs.enum {
+		if allowed == value {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("zoom: invalid value %q for enum field %s (should be one of %s)", value, fs.name, strings.Join(fs.enum, ", "))
+}
+
+// scanEnumVal converts the position of an enum value as it is stored in the
+// main hash to the value and sets dest (a string) to it.
+func (fs *fieldSpec) scanEnumVal(src []byte, dest reflect.Value) error {
+	ordinal, err := strconv.Atoi(string(src))
+	if err != nil || ordinal < 0 || ordinal >= len(fs.enum) {
+		return fmt.Errorf("zoom: could not convert %s to a value of enum field %s", string(src), fs.name)
+	}
+	dest.SetString(fs.enum[ordinal])
+	return nil
+}
+
+// enumIndexKey returns the key for the set which contains the ids of all the
+// models for which the enum field fs has the value with the given position.
+func (ms *modelSpec) enumIndexKey(fs *fieldSpec, ordinal int) string {
+	return ms.indexKeyForField(fs) + ":enum:" + strconv.Itoa(ordinal)
+}
+
+// saveEnumIndex adds commands to the transaction for saving an enum index on
+// the given field. This includes removing the model from the set for the old
+// value (if any).
+func (t *Transaction) saveEnumIndex(mr *modelRef, fs *fieldSpec) {
+	ordinal, err := fs.enumOrdinal(mr.fieldValue(fs.name).String())
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	t.updateEnumIndex(mr.spec, fs, mr.model.ModelID(), ordinal)
+}
+
+// updateEnumIndex is a small function wrapper around a Lua script. The script
+// will atomically update the enum index for the given field of the model with
+// the given id. If ordinal is negative, the model is removed from the index.
+func (t *Transaction) updateEnumIndex(spec *modelSpec, fs *fieldSpec, id string, ordinal int) {
+	value := ""
+	if ordinal >= 0 {
+		value = strconv.Itoa(ordinal)
+	}
+	// NOTE: this invokes a lua script which is defined in scripts/update_enum_index.lua
+	t.modelScript(id, updateEnumIndexScript, redis.Args{spec.name, fs.redisName, id, value, spec.indexKeyPrefix()}, nil)
+}
+
+// enumFilterOrdinal returns the position of the value of an enum filter in the
+// list of allowed values.
+func enumFilterOrdinal(filter filter) (int, error) {
+	value := filter.value
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	return filter.fieldSpec.enumOrdinal(value.String())
+}
+
+// checkEnumValues returns an error if the operator of filter (on an enum field)
+// is not supported for enum fields or if any of its values are not one of the
+// allowed values for the field.
+func (f filter) checkEnumValues() error {
+	switch f.op {
+	case equalOp, notEqualOp:
+		_, err := enumFilterOrdinal(f)
+		return err
+	case inOp:
+		for i := 0; i < f.value.Len(); i++ {
+			valueFilter := f
+			valueFilter.value = f.value.Index(i)
+			if _, err := enumFilterOrdinal(valueFilter); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("zoom: the %s filter operator cannot be used on enum fields such as %s (only =, !=, and IN are supported)", f.op, f.fieldSpec.name)
+}
+
+// intersectEnumFilter adds commands to the query transaction which, when run,
+// will intersect origKey with the ids of models which match the given equal or
+// not equal filter on an enum field, using the set of ids for the value, and
+// store the result in destKey.
+func intersectEnumFilter(q *query, tx *Transaction, filter filter, origKey string, destKey string) error {
+	ordinal, err := enumFilterOrdinal(filter)
+	if err != nil {
+		return err
+	}
+	if filter.op == notEqualOp {
+		// The ids of models with any other value are the ones with a different
+		// score in the sorted set.
+		fieldIndexKey, err := q.collection.spec.fieldIndexKey(filter.fieldSpec.name)
+		if err != nil {
+			return err
+		}
+		exclusive := fmt.Sprintf("(%d", ordinal)
+		filterKey := tx.newTmpKey("tmp:filter:" + fieldIndexKey)
+		tx.ExtractIDsFromFieldIndex(fieldIndexKey, filterKey, exclusive, "+inf")
+		tx.ExtractIDsFromFieldIndex(fieldIndexKey, filterKey, "-inf", exclusive)
+		tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, filterKey, "WEIGHTS", 1, 0}, nil)
+		tx.Command("DEL", redis.Args{filterKey}, nil)
+		return nil
+	}
+	valueKey := q.collection.spec.enumIndexKey(filter.fieldSpec, ordinal)
+	tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, valueKey, "WEIGHTS", 1, 0}, nil)
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File enum_test.go tests the code in enum.go
+
+package zoom
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type enumTestModel struct {
+	Status string `zoom:"enum=active|inactive|banned"`
+	Int    int    `zoom:"index"`
+	RandomID
+}
+
+func newEnumTestCollection(t *testing.T) (*Pool, *Collection) {
+	pool := NewPoolWithOptions(testPool.options)
+	col, err := pool.NewCollectionWithOptions(&enumTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	return pool, col
+}
+
+// enumTestIDs returns the sorted ids of the models with one of the given
+// statuses.
+func enumTestIDs(models []*enumTestModel, statuses ...string) []string {
+	ids := []string{}
+	for _, m := range models {
+		if stringSliceContains(statuses, m.Status) {
+			ids = append(ids, m.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestEnumField(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newEnumTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	statuses := []string{"active", "inactive", "banned"}
+	models := []*enumTestModel{}
+	for i := 0; i < 12; i++ {
+		model := &enumTestModel{Status: statuses[i%3], Int: i}
+		require.NoError(t, col.Save(model))
+		models = append(models, model)
+	}
+
+	// The values should be stored as their position in the list
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	stored, err := redis.String(conn.Do("HGET", col.ModelKey(models[2].ID), "Status"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", stored)
+	found := &enumTestModel{}
+	require.NoError(t, col.Find(models[2].ID, found))
+	assert.Equal(t, "banned", found.Status)
+
+	// Invalid values should not be saved
+	assert.Error(t, col.Save(&enumTestModel{Status: "deleted"}))
+	assert.Error(t, col.Save(&enumTestModel{}))
+	count, err := col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, len(models), count)
+
+	checkQuery := func(query *Query, statuses ...string) {
+		ids, err := query.IDs()
+		require.NoError(t, err)
+		sort.Strings(ids)
+		assert.Equal(t, enumTestIDs(models, statuses...), ids, query.String())
+		count, err := query.Count()
+		require.NoError(t, err)
+		assert.Equal(t, len(ids), count, query.String())
+	}
+	checkQuery(col.NewQuery().Filter("Status =", "inactive"), "inactive")
+	checkQuery(col.NewQuery().Filter("Status !=", "inactive"), "active", "banned")
+	checkQuery(col.NewQuery().Filter("Status IN", []string{"active", "banned"}), "active", "banned")
+
+	// Models should be ordered by the position of the value
+	ids, err := col.NewQuery().Order("Status").Filter("Int <", 3).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[0].ID, models[1].ID, models[2].ID}, ids)
+
+	// Only equality filters with valid values are supported
+	_, err = col.NewQuery().Filter("Status =", "deleted").IDs()
+	assert.Error(t, err)
+	_, err = col.NewQuery().Filter("Status >", "active").IDs()
+	assert.Error(t, err)
+	_, err = col.NewQuery().FilterRange("Status", "active", "banned", true).IDs()
+	assert.Error(t, err)
+
+	// Updating and deleting models should update the index
+	models[0].Status = "banned"
+	require.NoError(t, col.Save(models[0]))
+	_, err = col.Delete(models[1].ID)
+	require.NoError(t, err)
+	models = append(models[:1], models[2:]...)
+	checkQuery(col.NewQuery().Filter("Status =", "banned"), "banned")
+	checkQuery(col.NewQuery().Filter("Status =", "inactive"), "inactive")
+	updated, err := col.NewQuery().Filter("Status =", "inactive").Update(map[string]interface{}{"Status": "active"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, updated)
+	for _, m := range models {
+		if m.Status == "inactive" {
+			m.Status = "active"
+		}
+	}
+	checkQuery(col.NewQuery().Filter("Status =", "inactive"), "inactive")
+	checkQuery(col.NewQuery().Filter("Status =", "active"), "active")
+	_, err = col.NewQuery().Update(map[string]interface{}{"Status": "deleted"})
+	assert.Error(t, err)
+}
+
+func TestEnumFieldInvalidTags(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	type intEnum struct {
+		Status int `zoom:"enum=1|2"`
+		RandomID
+	}
+	_, err := pool.NewCollection(&intEnum{})
+	assert.Error(t, err)
+	type emptyValue struct {
+		Status string `zoom:"enum=a||b"`
+		RandomID
+	}
+	_, err = pool.NewCollection(&emptyValue{})
+	assert.Error(t, err)
+	type duplicateValue struct {
+		Status string `zoom:"enum=a|b|a"`
+		RandomID
+	}
+	_, err = pool.NewCollection(&duplicateValue{})
+	assert.Error(t, err)
+}
+
+func TestEnumSchemaDrift(t *testing.T) {
+	stored := Schema{Name: "Enum", Fields: []SchemaField{{Name: "Status", RedisName: "Status", Type: "string", Index: "enum", Enum: []string{"a", "b"}}}}
+	appended := Schema{Name: "Enum", Fields: []SchemaField{{Name: "Status", RedisName: "Status", Type: "string", Index: "enum", Enum: []string{"a", "b", "c"}}}}
+	reordered := Schema{Name: "Enum", Fields: []SchemaField{{Name: "Status", RedisName: "Status", Type: "string", Index: "enum", Enum: []string{"b", "a"}}}}
+	assert.Empty(t, compareSchemas(stored, appended))
+	drifts := compareSchemas(stored, reordered)
+	require.Len(t, drifts, 1)
+	assert.Contains(t, drifts[0].Message, "enum values changed")
+}
@@ -7,7 +7,106 @@
 
 package zoom
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// The following errors describe common failure modes. Zoom never returns them
+// directly, but wraps them in an error with a more detailed message, so use
+// errors.Is to check for them. For example:
+//
+//	if errors.Is(err, zoom.ErrFieldNotFound) {
+//		// handle a misspelled field name
+//	}
+var (
+	// ErrWrongModelType is returned when a model (or slice of models) passed to a
+	// method does not have the type that was registered for the collection.
+	ErrWrongModelType = errors.New("zoom: wrong model type")
+	// ErrFieldNotFound is returned when a method is given the name of a field
+	// which does not exist in the collection (or does not have the struct tag the
+	// method requires).
+	ErrFieldNotFound = errors.New("zoom: field not found")
+	// ErrUnindexedField is returned when a method which requires an index (e.g.
+	// Query.Filter or Query.Join) is given a field which does not have the kind
+	// of index it requires.
+	ErrUnindexedField = errors.New("zoom: field is not indexed")
+	// ErrFieldNotWritable is returned when a method tries to change the value of
+	// a field with the writeonce option, or to update a field with the readonly
+	// or writeonce option with Query.Update or GetSet.
+	ErrFieldNotWritable = errors.New("zoom: field is not writable")
+	// ErrLocked is returned by Collection.Lock when the model is already locked
+	// by another client.
+	ErrLocked = errors.New("zoom: model is locked")
+	// ErrLockNotHeld is returned by Lock.Unlock and Lock.Extend when the lock
+	// has expired (and possibly been acquired by another client).
+	ErrLockNotHeld = errors.New("zoom: lock is no longer held")
+	// ErrInvalidQuery is returned when the parameters given to ParseQuery do not
+	// describe a valid query, e.g. because a filter has an invalid value or uses
+	// a field which is not allowed.
+	ErrInvalidQuery = errors.New("zoom: invalid query parameters")
+	// ErrPoolExhausted is returned by every command sent on a connection from a
+	// pool which already has PoolOptions.MaxActive active connections if
+	// PoolOptions.Wait is false. It lets applications under load shed work (or
+	// retry later, see IsRetryableError) instead of opening more connections to
+	// Redis. For compatibility, errors.Is also reports a match for
+	// redis.ErrPoolExhausted.
+	ErrPoolExhausted = errors.New("zoom: connection pool exhausted")
+	// ErrNotReplicated is returned by Pool.WaitForReplication and by Exec for
+	// transactions with RequireAck if fewer replicas than required acknowledged
+	// the writes before the timeout. The writes have still been committed on
+	// the primary.
+	ErrNotReplicated = errors.New("zoom: writes were not acknowledged by enough replicas")
+	// ErrDuplicatePrimaryKey is returned by Save and SaveFields when a new model
+	// with a primary field (see NaturalID) has the same primary key as a model
+	// which already exists.
+	ErrDuplicatePrimaryKey = errors.New("zoom: duplicate primary key")
+	// ErrReferenced is returned by Delete when a model is referenced by a field
+	// with the ref option and ondelete=restrict (see OnDelete).
+	ErrReferenced = errors.New("zoom: model is referenced")
+)
+
+// kindError is an error with a detailed message which wraps one of the
+// sentinel errors above, so that it can be detected with errors.Is.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+// newKindError returns an error which wraps kind and whose message is
+// formatted according to format and args.
+func newKindError(kind error, format string, args ...interface{}) error {
+	return kindError{
+		kind: kind,
+		msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (e kindError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the sentinel error wrapped by e.
+func (e kindError) Unwrap() error {
+	return e.kind
+}
+
+// poolExhaustedError is the error for ErrPoolExhausted, which includes the
+// size of the pool in its message.
+type poolExhaustedError struct {
+	maxActive int
+}
+
+func (e poolExhaustedError) Error() string {
+	return fmt.Sprintf("zoom: connection pool exhausted: all %d connections are in use and PoolOptions.Wait is false", e.maxActive)
+}
+
+// Is returns true iff target is ErrPoolExhausted or redis.ErrPoolExhausted.
+func (e poolExhaustedError) Is(target error) bool {
+	return target == ErrPoolExhausted || target == redis.ErrPoolExhausted
+}
 
 // ModelNotFoundError is returned from Find and Query methods if a model
 // that fits the given criteria is not found.
@@ -33,13 +132,169 @@ func newModelNotFoundError(mr *modelRef) error {
 	}
 }
 
-// WatchError is returned whenever a watched key is modified before a
+// MultipleModelsFoundError is returned from Query.RunExactlyOne if more than
+// one model fits the given criteria.
+type MultipleModelsFoundError struct {
+	Collection *Collection
+	Msg        string
+}
+
+func (e MultipleModelsFoundError) Error() string {
+	return "zoom: MultipleModelsFoundError: " + e.Msg
+}
+
+// StrictScanError is returned when a collection with the StrictScan option
+// reads models whose main hash does not have exactly the fields of the model
+// type. The models are still scanned, so the error can be treated as a warning.
+type StrictScanError struct {
+	Collection *Collection
+	// Mismatches describes each model whose fields did not match, in the order
+	// the models were read.
+	Mismatches []FieldMismatch
+}
+
+// FieldMismatch describes the differences between the fields in the main hash
+// of a single model and the fields of the model type.
+type FieldMismatch struct {
+	ModelID string
+	// UnknownFields are the Redis names of the fields in the hash which the
+	// model type does not have.
+	UnknownFields []string
+	// MissingFields are the Redis names of the fields of the model type which
+	// are not in the hash.
+	MissingFields []string
+}
+
+func (e StrictScanError) Error() string {
+	first := e.Mismatches[0]
+	return fmt.Sprintf("zoom: StrictScanError: the fields of %d %s model(s) do not match the model type (e.g. %s has unknown fields %v and missing fields %v)", len(e.Mismatches), e.Collection.Name(), first.ModelID, first.UnknownFields, first.MissingFields)
+}
+
+// CommandError describes a single command (or script) in a transaction which
+// failed.
+type CommandError struct {
+	// Index is the position of the command in the transaction, starting at 0.
+	// It is the same as the index of the command in the result of DryRun.
+	Index int
+	// Name is the name of the command, e.g. "HMSET". Lua scripts are described
+	// as "EVALSHA".
+	Name string
+	// ModelID is the id of the model which the command affects (e.g. the model
+	// being saved), or an empty string if it is not known.
+	ModelID string
+	// Group is the name of the CommandGroup the command was added with (see
+	// AddGroup), or an empty string if it was added directly to the
+	// transaction.
+	Group string
+	// Err is the error returned by Redis.
+	Err error
+}
+
+func newCommandError(index int, a *Action, err error) CommandError {
+	return CommandError{
+		Index:   index,
+		Name:    a.commandName(),
+		ModelID: a.modelID,
+		Group:   a.group,
+		Err:     err,
+	}
+}
+
+func (e CommandError) Error() string {
+	desc := e.Name
+	if e.ModelID != "" {
+		desc += " for model " + e.ModelID
+	}
+	if e.Group != "" {
+		desc += " in group " + e.Group
+	}
+	return fmt.Sprintf("command %d (%s): %s", e.Index, desc, e.Err.Error())
+}
+
+// Unwrap returns the error returned by Redis.
+func (e CommandError) Unwrap() error {
+	return e.Err
+}
+
+// TransactionError is returned by Exec if Redis returns an error for one or
+// more of the commands in a transaction. Redis still executes the other
+// commands in the same MULTI/EXEC block, so Errors contains every command in
+// the block which failed. The handlers for the commands after the first
+// failure are not called. If the transaction is split into batches (see
+// ExecInBatches), the batches after the one which failed are not sent, i.e.
+// only the first Executed commands were executed. Unwrap returns the error
+// for the first failed command, so errors.Is and errors.As can be used to
+// check for specific Redis errors.
+type TransactionError struct {
+	// Errors are the errors for each command that failed, in order.
+	Errors []CommandError
+	// Executed is the number of commands, starting from the first one, that
+	// were sent to Redis.
+	Executed int
+}
+
+func newTransactionError(errs []CommandError, executed int) TransactionError {
+	return TransactionError{
+		Errors:   errs,
+		Executed: executed,
+	}
+}
+
+func (e TransactionError) Error() string {
+	if len(e.Errors) == 1 {
+		return "zoom: a command in the transaction failed: " + e.Errors[0].Error()
+	}
+	return fmt.Sprintf("zoom: %d commands in the transaction failed. The first was: %s", len(e.Errors), e.Errors[0].Error())
+}
+
+// Unwrap returns the error for the first failed command.
+func (e TransactionError) Unwrap() error {
+	return e.Errors[0].Err
+}
+
+// FailedModelIDs returns the ids of the models affected by the commands which
+// failed (without duplicates), e.g. so that only the models which could not
+// be saved are saved again.
+func (e TransactionError) FailedModelIDs() []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, commandErr := range e.Errors {
+		if commandErr.ModelID != "" && !seen[commandErr.ModelID] {
+			seen[commandErr.ModelID] = true
+			ids = append(ids, commandErr.ModelID)
+		}
+	}
+	return ids
+}
+
+// FailedGroups returns the names of the command groups (see AddGroup) which
+// contain at least one of the commands which failed, without duplicates.
+func (e TransactionError) FailedGroups() []string {
+	groups := []string{}
+	seen := map[string]bool{}
+	for _, commandErr := range e.Errors {
+		if commandErr.Group != "" && !seen[commandErr.Group] {
+			seen[commandErr.Group] = true
+			groups = append(groups, commandErr.Group)
+		}
+	}
+	return groups
+}
+
+// WatchConflictError is returned whenever a watched key is modified before a
 // transaction can execute. It is part of the implementation of optimistic
 // locking in Zoom. You can watch a key with the Transaction.WatchKey method.
-type WatchError struct {
-	keys []string
+type WatchConflictError struct {
+	// Keys are all the keys the transaction was watching. At least one of them
+	// was modified.
+	Keys []string
 }
 
-func (e WatchError) Error() string {
-	return fmt.Sprintf("zoom: watch error: at least one of the following keys has changed: %v", e.keys)
+func (e WatchConflictError) Error() string {
+	return fmt.Sprintf("zoom: watch error: at least one of the following keys has changed: %v", e.Keys)
 }
+
+// WatchError is the old name for WatchConflictError.
+//
+// Deprecated: Use WatchConflictError instead.
+type WatchError = WatchConflictError
@@ -0,0 +1,140 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File errors_test.go tests the code in errors.go
+
+package zoom
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorKinds(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Wrong model types
+	err := testModels.Save(&indexedTestModel{})
+	assert.True(t, errors.Is(err, ErrWrongModelType), "unexpected error: %v", err)
+	err = testModels.Find("id", &indexedTestModel{})
+	assert.True(t, errors.Is(err, ErrWrongModelType), "unexpected error: %v", err)
+	err = testModels.FindAll(&[]*indexedTestModel{})
+	assert.True(t, errors.Is(err, ErrWrongModelType), "unexpected error: %v", err)
+	err = testModels.NewQuery().Run(&[]*indexedTestModel{})
+	assert.True(t, errors.Is(err, ErrWrongModelType), "unexpected error: %v", err)
+	assert.False(t, errors.Is(err, ErrFieldNotFound))
+
+	// Fields which do not exist
+	err = testModels.SaveFields([]string{"Missing"}, &testModel{})
+	assert.True(t, errors.Is(err, ErrFieldNotFound), "unexpected error: %v", err)
+	err = testModels.FindFields("id", []string{"Missing"}, &testModel{})
+	assert.True(t, errors.Is(err, ErrFieldNotFound), "unexpected error: %v", err)
+	_, err = indexedTestModels.NewQuery().Filter("Missing =", 1).IDs()
+	assert.True(t, errors.Is(err, ErrFieldNotFound), "unexpected error: %v", err)
+	_, err = indexedTestModels.NewQuery().Order("Missing").IDs()
+	assert.True(t, errors.Is(err, ErrFieldNotFound), "unexpected error: %v", err)
+	_, err = indexedTestModels.FieldIndexKey("Missing")
+	assert.True(t, errors.Is(err, ErrFieldNotFound), "unexpected error: %v", err)
+	assert.False(t, errors.Is(err, ErrUnindexedField))
+
+	// Fields which are not indexed
+	_, err = testModels.NewQuery().Filter("Int =", 1).IDs()
+	assert.True(t, errors.Is(err, ErrUnindexedField), "unexpected error: %v", err)
+	_, err = testModels.FieldIndexKey("Int")
+	assert.True(t, errors.Is(err, ErrUnindexedField), "unexpected error: %v", err)
+	_, err = testModels.BuildIndex("Int")
+	assert.True(t, errors.Is(err, ErrUnindexedField), "unexpected error: %v", err)
+
+	// The messages should still describe the problem in detail
+	assert.Contains(t, err.Error(), "Int")
+}
+
+func TestWatchConflictError(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	tx := testPool.NewTransaction()
+	require.NoError(t, tx.WatchKey("watched"))
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("SET", "watched", "changed")
+	require.NoError(t, err)
+	tx.Command("SET", redis.Args{"watched", "tx"}, nil)
+	err = fmt.Errorf("wrapped: %w", tx.Exec())
+	watchErr := WatchConflictError{}
+	require.True(t, errors.As(err, &watchErr), "unexpected error: %v", err)
+	assert.Equal(t, []string{"watched"}, watchErr.Keys)
+
+	// Wrapped errors should still be retryable
+	assert.True(t, IsRetryableError(err))
+	assert.True(t, IsRetryableError(fmt.Errorf("wrapped: %w", io.EOF)))
+	assert.False(t, IsRetryableError(fmt.Errorf("wrapped: %w", ErrFieldNotFound)))
+}
+
+func TestTransactionError(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("SET", "notAList", "value")
+	require.NoError(t, err)
+	models := createTestModels(2)
+	newTx := func() *Transaction {
+		tx := testPool.NewTransaction()
+		tx.Save(testModels, models[0])
+		tx.Command("LPUSH", redis.Args{"notAList", "a"}, nil)
+		tx.Save(testModels, models[1])
+		return tx
+	}
+	descriptions, err := newTx().DryRun()
+	require.NoError(t, err)
+	err = fmt.Errorf("wrapped: %w", newTx().Exec())
+	txErr := TransactionError{}
+	require.True(t, errors.As(err, &txErr), "unexpected error: %v", err)
+	require.Len(t, txErr.Errors, 1)
+	commandErr := txErr.Errors[0]
+	assert.Equal(t, "LPUSH", commandErr.Name)
+	assert.Equal(t, "", commandErr.ModelID)
+	assert.Equal(t, "LPUSH", descriptions[commandErr.Index].Name)
+	assert.Equal(t, len(descriptions), txErr.Executed)
+	assert.Contains(t, err.Error(), "WRONGTYPE")
+	assert.Empty(t, txErr.FailedModelIDs())
+	var redisErr redis.Error
+	assert.True(t, errors.As(err, &redisErr))
+
+	// Commands for a model should include the model id
+	_, err = conn.Do("SET", testModels.ModelKey(models[1].ModelID()), "value")
+	require.NoError(t, err)
+	models[0].Int++
+	models[1].Int++
+	tx := testPool.NewTransaction()
+	tx.Save(testModels, models[0])
+	tx.Save(testModels, models[1])
+	err = tx.Exec()
+	require.True(t, errors.As(err, &txErr), "unexpected error: %v", err)
+	require.NotEmpty(t, txErr.Errors)
+	for _, commandErr := range txErr.Errors {
+		assert.Equal(t, models[1].ModelID(), commandErr.ModelID)
+	}
+	assert.Equal(t, []string{models[1].ModelID()}, txErr.FailedModelIDs())
+	assert.Contains(t, err.Error(), models[1].ModelID())
+	assert.False(t, IsRetryableError(err))
+
+	// The commands which did not fail should still have been executed
+	found := &testModel{}
+	require.NoError(t, testModels.Find(models[0].ModelID(), found))
+	assert.Equal(t, models[0].Int, found.Int)
+}
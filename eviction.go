@@ -0,0 +1,193 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File eviction.go contains code for evicting models from collections which
+// are used as bounded caches.
+
+package zoom
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// EvictionPolicy determines which models are evicted first from a collection
+// with an eviction policy (see EvictionOptions).
+type EvictionPolicy int
+
+const (
+	// EvictionLRU evicts the least recently used models first. A model is used
+	// when it is saved, updated with GetSet, found with Find, FindWith,
+	// FindWithPresence, or FindFields, or passed to Collection.Touch.
+	EvictionLRU EvictionPolicy = iota
+	// EvictionFIFO evicts the models which were first saved the longest time
+	// ago first, regardless of how often they are used.
+	EvictionFIFO
+)
+
+// String returns the name of the policy.
+func (policy EvictionPolicy) String() string {
+	switch policy {
+	case EvictionLRU:
+		return "LRU"
+	case EvictionFIFO:
+		return "FIFO"
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(policy))
+}
+
+// EvictionOptions bounds the size of a collection (see
+// CollectionOptions.Eviction). Zoom keeps the id of each model in a sorted set
+// (see Collection.EvictionKey) scored by the time the model was last used
+// (EvictionLRU) or first saved (EvictionFIFO). Each time a model is saved, the
+// models which exceed the bounds are evicted in the same transaction, i.e.
+// deleted along with their field indexes, as if by Query.Delete. Like
+// Query.Delete, eviction does not record changes in the audit log or append
+// events to the outbox. Models which were saved before the eviction policy was
+// set are not tracked until they are saved again.
+type EvictionOptions struct {
+	// MaxModels is the maximum number of models kept in the collection. If it
+	// is 0, the number of models is not bounded.
+	MaxModels int
+	// MaxAge, if not 0, is how long a model is kept after it was last used
+	// (EvictionLRU) or first saved (EvictionFIFO). Since models are only
+	// evicted when a model is saved or Collection.Evict is called, models may
+	// be kept longer if the collection is rarely written to.
+	MaxAge time.Duration
+	// Policy determines which models are evicted first.
+	Policy EvictionPolicy
+	// OnEvict, if not nil, is called with the ids of the models which were
+	// evicted after the transaction which evicted them is executed. It may be
+	// called concurrently from different goroutines.
+	OnEvict func(ids []string)
+}
+
+// enabled returns true iff the options bound the size of the collection.
+func (options EvictionOptions) enabled() bool {
+	return options.MaxModels > 0 || options.MaxAge > 0
+}
+
+// validate returns an error if the options are not valid.
+func (options EvictionOptions) validate() error {
+	switch {
+	case options.MaxModels < 0:
+		return fmt.Errorf("CollectionOptions.Eviction.MaxModels cannot be negative. Got: %d", options.MaxModels)
+	case options.MaxAge < 0:
+		return fmt.Errorf("CollectionOptions.Eviction.MaxAge cannot be negative. Got: %s", options.MaxAge)
+	case options.Policy != EvictionLRU && options.Policy != EvictionFIFO:
+		return fmt.Errorf("CollectionOptions.Eviction.Policy is not valid. Got: %s", options.Policy)
+	}
+	return nil
+}
+
+// EvictionKey returns the key of the sorted set which Zoom uses to decide
+// which models to evict from the collection. The score of each id is the
+// number of milliseconds since the Unix epoch at which the model was last used
+// or first saved, depending on the eviction policy.
+func (c *Collection) EvictionKey() string {
+	return c.Name() + ":eviction"
+}
+
+// trackEviction adds commands to the transaction which record that the model
+// with the given id was saved and evict the models which exceed the bounds of
+// the collection. It does nothing if c does not have an eviction policy.
+func (t *Transaction) trackEviction(c *Collection, id string) {
+	if !c.eviction.enabled() {
+		return
+	}
+	args := redis.Args{c.EvictionKey()}
+	if c.eviction.Policy == EvictionFIFO {
+		// Only the first save counts.
+		args = args.Add("NX")
+	}
+	t.modelCommand(id, "ZADD", args.Add(indexScore(time.Now()), id), nil)
+	t.evict(c, nil)
+}
+
+// touchEviction adds a command to the transaction which records that the
+// model with the given id was used. It does nothing unless c has an LRU
+// eviction policy, and does not add models which are not tracked.
+func (t *Transaction) touchEviction(c *Collection, id string) {
+	if !c.eviction.enabled() || c.eviction.Policy != EvictionLRU {
+		return
+	}
+	t.modelCommand(id, "ZADD", redis.Args{c.EvictionKey(), "XX", indexScore(time.Now()), id}, nil)
+}
+
+// untrackEviction adds a command to the transaction which removes the model
+// with the given id from the eviction set of c. It does nothing if c does not
+// have an eviction policy.
+func (t *Transaction) untrackEviction(c *Collection, id string) {
+	if !c.eviction.enabled() {
+		return
+	}
+	t.modelCommand(id, "ZREM", redis.Args{c.EvictionKey(), id}, nil)
+}
+
+// evict adds commands to the transaction which delete the models which exceed
+// the bounds of c. If count is not nil, it is set to the number of models
+// which were evicted when the transaction is executed.
+func (t *Transaction) evict(c *Collection, count *int) {
+	minScore := "-inf"
+	if c.eviction.MaxAge > 0 {
+		minScore = fmt.Sprint(indexScore(time.Now().Add(-c.eviction.MaxAge)))
+	}
+	listKey := t.newTmpKey("tmp:evict:" + c.Name())
+	onEvict := c.eviction.OnEvict
+	t.Script(evictModelsScript, redis.Args{c.EvictionKey(), listKey, c.eviction.MaxModels, minScore}, func(reply interface{}) error {
+		ids, err := redis.Strings(reply, nil)
+		if err != nil {
+			return err
+		}
+		if count != nil {
+			*count = len(ids)
+		}
+		if len(ids) > 0 && onEvict != nil {
+			onEvict(ids)
+		}
+		return nil
+	})
+	t.deleteModelsByListIDs(listKey, c.spec, nil)
+	t.Command("DEL", redis.Args{listKey}, nil)
+}
+
+// Evict deletes the models which exceed the bounds of the collection and
+// returns the number of models which were evicted. Since models are only
+// evicted automatically when a model is saved, Evict can be called
+// periodically to evict models which are older than EvictionOptions.MaxAge
+// from collections which are rarely written to. It returns an error if the
+// collection does not have an eviction policy.
+func (c *Collection) Evict() (int, error) {
+	if c == nil {
+		return 0, newNilCollectionError("Evict")
+	}
+	if !c.eviction.enabled() {
+		return 0, fmt.Errorf("zoom: Error in Evict: Collection %s does not have an eviction policy", c.Name())
+	}
+	count := 0
+	t := c.pool.NewTransaction()
+	t.evict(c, &count)
+	if err := t.Exec(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Touch records that the models with the given ids were used, so that they
+// are evicted later by an EvictionLRU policy. It is useful for reads which
+// do not count as a use, e.g. queries and reads from the in-process model
+// cache. Ids of models which do not exist or are not tracked are ignored. It
+// does nothing unless the collection has an EvictionLRU policy.
+func (c *Collection) Touch(ids ...string) error {
+	if c == nil {
+		return newNilCollectionError("Touch")
+	}
+	t := c.pool.NewTransaction()
+	for _, id := range ids {
+		t.touchEviction(c, id)
+	}
+	return t.Exec()
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File eviction_test.go tests the code in eviction.go
+
+package zoom
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type evictedTestModel struct {
+	Name string `zoom:"index"`
+	RandomID
+}
+
+func TestEviction(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	mut := sync.Mutex{}
+	evicted := []string{}
+	onEvict := func(ids []string) {
+		mut.Lock()
+		defer mut.Unlock()
+		evicted = append(evicted, ids...)
+	}
+	save := func(col *Collection, names ...string) []*evictedTestModel {
+		models := []*evictedTestModel{}
+		for _, name := range names {
+			model := &evictedTestModel{Name: name}
+			require.NoError(t, col.Save(model))
+			models = append(models, model)
+			// Make sure each model has a different score
+			time.Sleep(2 * time.Millisecond)
+		}
+		return models
+	}
+	names := func(col *Collection) []string {
+		models := []*evictedTestModel{}
+		require.NoError(t, col.NewQuery().Order("Name").Run(&models))
+		result := []string{}
+		for _, model := range models {
+			result = append(result, model.Name)
+		}
+		return result
+	}
+	tracked := func(col *Collection) int {
+		conn := pool.NewConn()
+		defer func() {
+			_ = conn.Close()
+		}()
+		card, err := redis.Int(conn.Do("ZCARD", col.EvictionKey()))
+		require.NoError(t, err)
+		return card
+	}
+
+	// With an LRU policy, models which were recently found should be kept
+	lru, err := pool.NewCollectionWithOptions(&evictedTestModel{}, DefaultCollectionOptions.WithIndex(true).WithEviction(EvictionOptions{
+		MaxModels: 3,
+		OnEvict:   onEvict,
+	}))
+	require.NoError(t, err)
+	models := save(lru, "a", "b", "c")
+	require.NoError(t, lru.Find(models[0].ID, &evictedTestModel{}))
+	time.Sleep(2 * time.Millisecond)
+	models = append(models, save(lru, "d")...)
+	assert.Equal(t, []string{"a", "c", "d"}, names(lru))
+	assert.Equal(t, []string{models[1].ID}, evicted)
+	require.NoError(t, lru.Touch(models[2].ID))
+	time.Sleep(2 * time.Millisecond)
+	save(lru, "e")
+	assert.Equal(t, []string{"c", "d", "e"}, names(lru))
+	count, err := lru.NewQuery().Filter("Name =", "a").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "expected the field index of the evicted model to be updated")
+
+	// Deleted models should no longer be tracked
+	_, err = lru.Delete(models[2].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, tracked(lru))
+	_, err = lru.NewQuery().Filter("Name =", "d").Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 1, tracked(lru))
+
+	// With a FIFO policy, models should be evicted in the order they were
+	// first saved
+	evicted = []string{}
+	fifo, err := pool.NewCollectionWithOptions(&evictedTestModel{}, DefaultCollectionOptions.WithName("fifoEvictedTestModel").WithIndex(true).WithEviction(EvictionOptions{
+		MaxModels: 2,
+		Policy:    EvictionFIFO,
+		OnEvict:   onEvict,
+	}))
+	require.NoError(t, err)
+	models = save(fifo, "a", "b")
+	require.NoError(t, fifo.Find(models[0].ID, &evictedTestModel{}))
+	models[0].Name = "aa"
+	require.NoError(t, fifo.Save(models[0]))
+	models = append(models, save(fifo, "c")...)
+	assert.Equal(t, []string{"b", "c"}, names(fifo))
+	assert.Equal(t, []string{models[0].ID}, evicted)
+
+	// Models older than MaxAge should be evicted by Evict
+	evicted = []string{}
+	aged, err := pool.NewCollectionWithOptions(&evictedTestModel{}, DefaultCollectionOptions.WithName("agedEvictedTestModel").WithIndex(true).WithEviction(EvictionOptions{
+		MaxAge:  50 * time.Millisecond,
+		OnEvict: onEvict,
+	}))
+	require.NoError(t, err)
+	models = save(aged, "a", "b")
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, aged.Touch(models[1].ID))
+	count, err = aged.Evict()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []string{"b"}, names(aged))
+	assert.Equal(t, []string{models[0].ID}, evicted)
+
+	// Invalid options should be rejected
+	_, err = pool.NewCollectionWithOptions(&evictedTestModel{}, DefaultCollectionOptions.WithName("badEvictedTestModel").WithEviction(EvictionOptions{MaxModels: -1}))
+	assert.Error(t, err)
+	_, err = pool.NewCollectionWithOptions(&evictedTestModel{}, DefaultCollectionOptions.WithName("badEvictedTestModel").WithEviction(EvictionOptions{MaxModels: 1, Policy: EvictionPolicy(5)}))
+	assert.Error(t, err)
+	_, err = pool.NewDynamicCollection(Schema{Name: "dynamicEvictedTestModel"}, DefaultCollectionOptions.WithEviction(EvictionOptions{MaxModels: 1}))
+	assert.Error(t, err)
+	unbounded, err := pool.NewCollectionWithOptions(&evictedTestModel{}, DefaultCollectionOptions.WithName("unboundedEvictedTestModel"))
+	require.NoError(t, err)
+	_, err = unbounded.Evict()
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File field_access.go contains code for enforcing the readonly and writeonce
+// options of the zoom struct tag.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// checkProtectedFields enforces the readonly and writeonce options for the
+// fields of mr which have one of them and appear in fieldNames. It reads the
+// stored values of those fields immediately (not when the transaction is
+// executed). If a readonly field has already been saved, the field of the
+// model is set to the stored value, so that saving the model does not change
+// it. If a writeonce field has already been saved with a different value,
+// checkProtectedFields returns an error which wraps ErrFieldNotWritable. Once a
+// field has been saved, the stored value can no longer change through Zoom, so
+// those values cannot become out of date before the transaction is executed.
+// If any of the fields has not been saved yet, another client could save it
+// first, so the key of the model is watched (and the fields are read again),
+// and the transaction fails with a WatchConflictError if the model changes
+// before it is executed.
+func (t *Transaction) checkProtectedFields(mr *modelRef, fieldNames []string) error {
+	protected := []*fieldSpec{}
+	redisNames := []string{}
+	for _, fs := range mr.spec.fields {
+		if fs.isProtected() && stringSliceContains(fieldNames, fs.name) {
+			protected = append(protected, fs)
+			redisNames = append(redisNames, fs.redisName)
+		}
+	}
+	if len(protected) == 0 {
+		return nil
+	}
+	t.mut.Lock()
+	reply, err := t.readProtectedFields(mr, redisNames)
+	unsaved := false
+	for _, value := range reply {
+		unsaved = unsaved || value == nil
+	}
+	// If the key is already watched (e.g. for a primary field), watching it
+	// again would forget any change made since it was first watched.
+	if err == nil && unsaved && !stringSliceContains(t.watching, mr.key()) {
+		if t.done {
+			err = errTransactionDone
+		} else if _, err = t.conn.Do("WATCH", mr.key()); err == nil {
+			t.watching = append(t.watching, mr.key())
+			reply, err = t.readProtectedFields(mr, redisNames)
+		}
+	}
+	t.mut.Unlock()
+	if err != nil {
+		return fmt.Errorf("zoom: could not read readonly and writeonce fields: %w", err)
+	}
+	for i, fs := range protected {
+		if reply[i] == nil {
+			// The field has not been saved yet, so it can be written.
+			continue
+		}
+		stored, err := redis.Bytes(reply[i], nil)
+		if err != nil {
+			return err
+		}
+		fieldVal := mr.fieldValue(fs.name)
+		if fs.readonly {
+			fieldVal.Set(reflect.Zero(fs.typ))
+			if err := mr.spec.scanFieldVal(fs, stored, fieldVal); err != nil {
+				return err
+			}
+			continue
+		}
+		storedVal := reflect.New(fs.typ).Elem()
+		if err := mr.spec.scanFieldVal(fs, stored, storedVal); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(storedVal.Interface(), fieldVal.Interface()) {
+			return newKindError(ErrFieldNotWritable, "zoom: cannot change writeonce field %s of %s with id = %s", fs.name, mr.spec.name, mr.model.ModelID())
+		}
+	}
+	return nil
+}
+
+// readProtectedFields reads the stored values of the fields of mr with the
+// given redis names immediately, using the connection of the transaction, and
+// returns them in the same format as the reply to an HMGET command. The caller
+// must hold t.mut.
+func (t *Transaction) readProtectedFields(mr *modelRef, redisNames []string) ([]interface{}, error) {
+	if !mr.spec.document {
+		return redis.Values(t.conn.Do("HMGET", redis.Args{mr.key()}.AddFlat(redisNames)...))
+	}
+	command, args := mr.spec.documentCommand(mr.key())
+	document, err := t.conn.Do(command, args...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument(document, redisNames)
+}
@@ -0,0 +1,180 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File field_access_test.go tests the code in field_access.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fieldAccessTestModel struct {
+	OwnerID   string `zoom:"index,writeonce"`
+	CreatedBy string `zoom:"index,readonly"`
+	Parent    *int   `zoom:"writeonce"`
+	Title     string
+	RandomID
+}
+
+func newFieldAccessTestCollection(t *testing.T) (*Pool, *Collection) {
+	pool := NewPoolWithOptions(testPool.options)
+	col, err := pool.NewCollectionWithOptions(&fieldAccessTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	return pool, col
+}
+
+func TestReadonlyField(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newFieldAccessTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	model := &fieldAccessTestModel{OwnerID: "alice", CreatedBy: "alice", Title: "first"}
+	require.NoError(t, col.Save(model))
+
+	// Changes to a readonly field should be discarded by Save and SaveFields
+	model.CreatedBy = "bob"
+	model.Title = "second"
+	require.NoError(t, col.Save(model))
+	assert.Equal(t, "alice", model.CreatedBy)
+	model.CreatedBy = "carol"
+	require.NoError(t, col.SaveFields([]string{"CreatedBy", "Title"}, model))
+	assert.Equal(t, "alice", model.CreatedBy)
+
+	found := &fieldAccessTestModel{}
+	require.NoError(t, col.Find(model.ID, found))
+	assert.Equal(t, "alice", found.CreatedBy)
+	assert.Equal(t, "second", found.Title)
+
+	// The index should not have changed either
+	count, err := col.NewQuery().Filter("CreatedBy =", "alice").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = col.NewQuery().Filter("CreatedBy =", "bob").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// A readonly field which has not been saved yet can be written
+	other := &fieldAccessTestModel{OwnerID: "dave", Title: "other"}
+	require.NoError(t, col.SaveFields([]string{"OwnerID", "Title"}, other))
+	other.CreatedBy = "dave"
+	require.NoError(t, col.Save(other))
+	require.NoError(t, col.Find(other.ID, found))
+	assert.Equal(t, "dave", found.CreatedBy)
+}
+
+func TestWriteonceField(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newFieldAccessTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	model := &fieldAccessTestModel{OwnerID: "alice", Title: "first"}
+	require.NoError(t, col.Save(model))
+
+	// Saving the same value again is allowed
+	model.Title = "second"
+	require.NoError(t, col.Save(model))
+
+	// Changing the value is not, and nothing should be saved
+	model.OwnerID = "bob"
+	model.Title = "third"
+	err := col.Save(model)
+	assert.True(t, errors.Is(err, ErrFieldNotWritable), "err: %v", err)
+	err = col.SaveFields([]string{"OwnerID"}, model)
+	assert.True(t, errors.Is(err, ErrFieldNotWritable), "err: %v", err)
+	found := &fieldAccessTestModel{}
+	require.NoError(t, col.Find(model.ID, found))
+	assert.Equal(t, "alice", found.OwnerID)
+	assert.Equal(t, "second", found.Title)
+
+	// Other fields can still be saved with SaveFields
+	require.NoError(t, col.SaveFields([]string{"Title"}, model))
+
+	// A nil pointer counts as a value
+	parent := 1
+	model.OwnerID = "alice"
+	model.Parent = &parent
+	err = col.Save(model)
+	assert.True(t, errors.Is(err, ErrFieldNotWritable), "err: %v", err)
+}
+
+func TestWriteonceFieldConcurrentFirstWrites(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newFieldAccessTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	// Both transactions check the field before either of them is executed, so
+	// both see that it has not been saved yet.
+	first := &fieldAccessTestModel{OwnerID: "alice"}
+	first.SetModelID("shared")
+	second := &fieldAccessTestModel{OwnerID: "bob"}
+	second.SetModelID("shared")
+	tx1 := pool.NewTransaction()
+	tx1.Save(col, first)
+	tx2 := pool.NewTransaction()
+	tx2.Save(col, second)
+	require.NoError(t, tx1.Exec())
+	err := tx2.Exec()
+	assert.True(t, errors.As(err, &WatchConflictError{}), "err: %v", err)
+
+	found := &fieldAccessTestModel{}
+	require.NoError(t, col.Find("shared", found))
+	assert.Equal(t, "alice", found.OwnerID)
+}
+
+func TestProtectedFieldUpdate(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool, col := newFieldAccessTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	model := &fieldAccessTestModel{OwnerID: "alice", CreatedBy: "alice"}
+	require.NoError(t, col.Save(model))
+
+	for _, fieldName := range []string{"OwnerID", "CreatedBy"} {
+		_, err := col.NewQuery().Update(map[string]interface{}{fieldName: "bob"})
+		assert.True(t, errors.Is(err, ErrFieldNotWritable), "err: %v", err)
+		err = col.GetSet(model.ID, map[string]interface{}{fieldName: "bob"}, &fieldAccessTestModel{})
+		assert.True(t, errors.Is(err, ErrFieldNotWritable), "err: %v", err)
+	}
+	_, err := col.NewQuery().Update(map[string]interface{}{"Title": "updated"})
+	assert.NoError(t, err)
+}
+
+func TestProtectedFieldOptionErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type readonlyAndWriteonce struct {
+		Attr string `zoom:"readonly,writeonce"`
+		RandomID
+	}
+	type readonlySet struct {
+		Attrs []string `zoom:"set,readonly"`
+		RandomID
+	}
+	type writeonceHash struct {
+		Attrs map[string]string `zoom:"hash,writeonce"`
+		RandomID
+	}
+	for _, model := range []Model{&readonlyAndWriteonce{}, &readonlySet{}, &writeonceHash{}} {
+		_, err := testPool.NewCollection(model)
+		assert.Error(t, err, "model: %T", model)
+	}
+}
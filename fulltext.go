@@ -0,0 +1,124 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File fulltext.go contains code related to full-text indexes, including
+// tokenizing, stemming, and maintaining the inverted index for each field
+// with the `zoom:"fulltext"` struct tag.
+
+package zoom
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fullTextOptions contains the options for a field with a full-text index.
+type fullTextOptions struct {
+	stem      bool
+	stopWords bool
+}
+
+// stopWords is the set of common English words which are ignored when a
+// full-text index has the "stopwords" option.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// tokenize splits text into a list of unique, lowercase terms according to the
+// given options. Terms are separated by any character which is not a letter or
+// a digit.
+func (opts *fullTextOptions) tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := []string{}
+	seen := map[string]bool{}
+	for _, word := range words {
+		if opts.stopWords && stopWords[word] {
+			continue
+		}
+		if opts.stem {
+			word = stem(word)
+		}
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// stem reduces word to its stem using a few simple suffix-stripping rules for
+// English. It is not as thorough as e.g. the Porter stemmer, but it handles the
+// most common plural and verb forms. word should already be lowercase.
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		word = word[:len(word)-1]
+	}
+	for _, suffix := range []string{"ing", "ed", "ly"} {
+		if len(word) > len(suffix)+2 && strings.HasSuffix(word, suffix) {
+			return word[:len(word)-len(suffix)]
+		}
+	}
+	return word
+}
+
+// fullTextTermKey returns the key for the set of ids of models whose field
+// described by fs contains the given term.
+func (ms *modelSpec) fullTextTermKey(fs *fieldSpec, term string) string {
+	return ms.indexKeyForField(fs) + ":fulltext:" + term
+}
+
+// saveFullTextIndexes adds commands to the transaction for saving the
+// full-text indexes for all the fields in fieldNames which have one.
+func (t *Transaction) saveFullTextIndexes(fieldNames []string, mr *modelRef) {
+	for _, fs := range mr.spec.fields {
+		if fs.fullText == nil || !stringSliceContains(fieldNames, fs.name) {
+			continue
+		}
+		fieldVal := mr.fieldValue(fs.name)
+		text := ""
+		if fs.kind == pointerField {
+			if !fieldVal.IsNil() {
+				text = fieldVal.Elem().String()
+			}
+		} else {
+			text = fieldVal.String()
+		}
+		t.updateFullTextIndex(mr.spec, mr.model.ModelID(), fs.redisName, fs.fullText.tokenize(text))
+	}
+}
+
+// deleteFullTextIndexes adds commands to the transaction for removing the model
+// with the given id from the full-text indexes for all fields which have one.
+func (t *Transaction) deleteFullTextIndexes(c *Collection, id string) {
+	for _, fs := range c.spec.fields {
+		if fs.fullText != nil {
+			t.updateFullTextIndex(c.spec, id, fs.redisName, nil)
+		}
+	}
+}
+
+// updateFullTextIndex is a small function wrapper around a Lua script. The
+// script will atomically remove the model with the given id from the full-text
+// index for the given field and then add it back for each of the given terms.
+func (t *Transaction) updateFullTextIndex(spec *modelSpec, modelID, redisName string, terms []string) {
+	args := redis.Args{spec.name, modelID, redisName, spec.indexKeyPrefix()}
+	for _, term := range terms {
+		args = args.Add(term)
+	}
+	t.modelScript(modelID, updateFulltextIndexScript, args, nil)
+}
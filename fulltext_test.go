@@ -0,0 +1,153 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File fulltext_test.go tests the code in fulltext.go and the Search query
+// modifier.
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	testCases := []struct {
+		opts     fullTextOptions
+		text     string
+		expected []string
+	}{
+		{
+			opts:     fullTextOptions{},
+			text:     "Redis is a key-value store. Redis!",
+			expected: []string{"redis", "is", "a", "key", "value", "store"},
+		},
+		{
+			opts:     fullTextOptions{stopWords: true},
+			text:     "Redis is a key-value store",
+			expected: []string{"redis", "key", "value", "store"},
+		},
+		{
+			opts:     fullTextOptions{stem: true, stopWords: true},
+			text:     "The stores are running queries quickly",
+			expected: []string{"store", "runn", "query", "quick"},
+		},
+		{
+			opts:     fullTextOptions{},
+			text:     "  ...  ",
+			expected: []string{},
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.opts.tokenize(tc.text), "text: %q", tc.text)
+	}
+}
+
+func TestStem(t *testing.T) {
+	testCases := map[string]string{
+		"stores":  "store",
+		"classes": "class",
+		"class":   "class",
+		"status":  "status",
+		"queries": "query",
+		"walked":  "walk",
+		"walking": "walk",
+		"quickly": "quick",
+		"red":     "red",
+		"go":      "go",
+	}
+	for word, expected := range testCases {
+		assert.Equal(t, expected, stem(word), "word: %s", word)
+	}
+}
+
+func TestFullTextIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	body := "Fast Storage"
+	model := &fullTextModel{
+		Title: "The Redis Datastore",
+		Body:  &body,
+	}
+	require.NoError(t, fullTextModels.Save(model))
+	spec := fullTextModels.spec
+	title := spec.fieldsByName["Title"]
+	bodyField := spec.fieldsByName["Body"]
+	// Title uses the stem option, so "redis" is stored as "redi"
+	expectSetContains(t, spec.fullTextTermKey(title, "redi"), model.ID)
+	expectSetContains(t, spec.fullTextTermKey(title, "datastore"), model.ID)
+	expectKeyDoesNotExist(t, spec.fullTextTermKey(title, "the"))
+	expectSetContains(t, spec.fullTextTermKey(bodyField, "storage"), model.ID)
+
+	// Updating the field should remove the old terms
+	model.Title = "Redis Database"
+	model.Body = nil
+	require.NoError(t, fullTextModels.Save(model))
+	expectSetContains(t, spec.fullTextTermKey(title, "redi"), model.ID)
+	expectSetContains(t, spec.fullTextTermKey(title, "database"), model.ID)
+	expectKeyDoesNotExist(t, spec.fullTextTermKey(title, "datastore"))
+	expectKeyDoesNotExist(t, spec.fullTextTermKey(bodyField, "storage"))
+
+	// Deleting the model should remove all the terms
+	_, err := fullTextModels.Delete(model.ID)
+	require.NoError(t, err)
+	expectKeyDoesNotExist(t, spec.fullTextTermKey(title, "redi"))
+	expectKeyDoesNotExist(t, spec.fullTextTermKey(title, "database"))
+	expectKeyDoesNotExist(t, fullTextModels.ModelKey(model.ID)+":Title:fulltext")
+}
+
+func TestQuerySearch(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	titles := []string{
+		"Redis is a datastore",
+		"Using Redis as a cache",
+		"Postgres is a datastore",
+		"Caching datastores with Redis",
+	}
+	models := []*fullTextModel{}
+	for i, title := range titles {
+		model := &fullTextModel{Title: title, Rank: i}
+		require.NoError(t, fullTextModels.Save(model))
+		models = append(models, model)
+	}
+
+	gots := []*fullTextModel{}
+	require.NoError(t, fullTextModels.NewQuery().Search("Title", "redis datastore").Order("Rank").Run(&gots))
+	require.Len(t, gots, 2)
+	assert.Equal(t, models[0].ID, gots[0].ID)
+	assert.Equal(t, models[3].ID, gots[1].ID)
+
+	// Search should intersect with other filters
+	ids, err := fullTextModels.NewQuery().Search("Title", "Redis").Filter("Rank >", 0).Order("-Rank").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[3].ID, models[1].ID}, ids)
+
+	count, err := fullTextModels.NewQuery().Search("Title", "datastores").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Stop words only should have no effect
+	count, err = fullTextModels.NewQuery().Search("Title", "the").Count()
+	require.NoError(t, err)
+	assert.Equal(t, len(models), count)
+
+	// Deleting models with a query should remove them from the full-text index
+	deleted, err := fullTextModels.NewQuery().Search("Title", "postgres").Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	expectKeyDoesNotExist(t, fullTextModels.spec.fullTextTermKey(fullTextModels.spec.fieldsByName["Title"], "postgre"))
+
+	// Invalid searches should return an error
+	_, err = fullTextModels.NewQuery().Search("Rank", "1").Count()
+	assert.Error(t, err)
+	_, err = fullTextModels.NewQuery().Search("Invalid", "foo").Count()
+	assert.Error(t, err)
+	_, err = fullTextModels.NewQuery().Update(map[string]interface{}{"Title": "foo"})
+	assert.Error(t, err)
+}
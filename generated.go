@@ -0,0 +1,54 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File generated.go contains GeneratedFields, which lets Zoom read and write
+// the fields of a model without reflection using code generated by the
+// generate command of the zoom tool.
+
+package zoom
+
+import "reflect"
+
+// GeneratedFields is implemented by model types with code generated by the
+// generate command of the zoom tool (see cmd/zoom), e.g. with:
+//
+//	//go:generate zoom generate . Person
+//
+// When a model implements it, Zoom uses it to convert the fields which are
+// stored in the main hash as primitives (or pointers to primitives) instead of
+// reflection, which makes Save and Find faster. Fields of all other kinds
+// (e.g. enum fields, inlined fields, and fields which are encoded with a
+// MarshalerUnmarshaler) and any fields for which the methods return false
+// still use reflection. The generated code must be regenerated whenever the
+// fields of the model type change. You should not need to implement it by
+// hand.
+type GeneratedFields interface {
+	// ZoomHashValue returns the value which is stored in the main hash for the
+	// field with the given name, or false if the field is not handled by the
+	// generated code.
+	ZoomHashValue(fieldName string) (interface{}, bool)
+	// ZoomScanHashField converts src, the value in the main hash, and sets the
+	// field with the given name to it. It returns false if the field is not
+	// handled by the generated code.
+	ZoomScanHashField(fieldName string, src []byte) (bool, error)
+}
+
+var generatedFieldsType = reflect.TypeOf((*GeneratedFields)(nil)).Elem()
+
+// usesGeneratedCode returns true iff the field described by fs is read and
+// written by the generated code of model types which implement
+// GeneratedFields.
+func (fs *fieldSpec) usesGeneratedCode() bool {
+	return (fs.kind == primativeField || fs.kind == pointerField) && fs.enum == nil && fs.method == ""
+}
+
+// generatedFields returns the model behind mr as a GeneratedFields, or nil if
+// its type does not implement GeneratedFields.
+func (mr *modelRef) generatedFields() GeneratedFields {
+	if !mr.spec.generated || mr.values != nil {
+		return nil
+	}
+	generated, _ := mr.model.(GeneratedFields)
+	return generated
+}
@@ -0,0 +1,243 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File generated_test.go tests the code in generated.go
+
+package zoom
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type generatedTestStatus string
+
+type generatedTestModel struct {
+	Int       int
+	Uint8     uint8
+	Float     float32
+	Bool      bool
+	String    string
+	Bytes     []byte
+	Status    generatedTestStatus
+	Duration  time.Duration
+	IntPtr    *int
+	StringPtr *string
+	Time      time.Time
+	Color     string `zoom:"enum=red|green"`
+	Ignored   string `redis:"-"`
+	private   int
+	RandomID
+}
+
+// reflectionTestModel has the same fields as generatedTestModel but not the
+// generated methods, so it is converted with reflection.
+type reflectionTestModel generatedTestModel
+
+func TestGeneratedFields(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	generatedCol, err := pool.NewCollection(&generatedTestModel{})
+	require.NoError(t, err)
+	reflectionCol, err := pool.NewCollection(&reflectionTestModel{})
+	require.NoError(t, err)
+	require.True(t, generatedCol.spec.generated)
+	require.False(t, reflectionCol.spec.generated)
+
+	i, s := 42, "pointer"
+	models := []*generatedTestModel{
+		{Color: "red"},
+		{
+			Int:       -7,
+			Uint8:     200,
+			Float:     1.5,
+			Bool:      true,
+			String:    "string",
+			Bytes:     []byte("bytes"),
+			Status:    "active",
+			Duration:  3 * time.Second,
+			IntPtr:    &i,
+			StringPtr: &s,
+			Time:      time.Unix(1500000000, 0).UTC(),
+			Color:     "green",
+		},
+	}
+	for _, model := range models {
+		// The generated code must produce the same values as reflection.
+		model.SetModelID("id")
+		generated := &modelRef{collection: generatedCol, model: model, spec: generatedCol.spec}
+		require.NotNil(t, generated.generatedFields())
+		generatedArgs, err := generated.mainHashArgs()
+		require.NoError(t, err)
+		reflected := (*reflectionTestModel)(model)
+		reflection := &modelRef{collection: reflectionCol, model: reflected, spec: reflectionCol.spec}
+		require.Nil(t, reflection.generatedFields())
+		reflectionArgs, err := reflection.mainHashArgs()
+		require.NoError(t, err)
+		assert.Equal(t, reflectionArgs[1:], generatedArgs[1:])
+
+		// Models saved with the generated code can be found with it.
+		model.SetModelID("")
+		require.NoError(t, generatedCol.Save(model))
+		found := &generatedTestModel{}
+		require.NoError(t, generatedCol.Find(model.ModelID(), found))
+		assert.Equal(t, model, found)
+	}
+
+	// Values which cannot be parsed are reported like with reflection.
+	conn := pool.NewConn()
+	_, err = conn.Do("HSET", generatedCol.ModelKey(models[0].ModelID()), "Int", "not an int")
+	_ = conn.Close()
+	require.NoError(t, err)
+	err = generatedCol.Find(models[0].ModelID(), &generatedTestModel{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not convert not an int to int")
+}
+
+// The methods below were generated by running zoom generate on a copy of
+// generatedTestModel.
+
+// ZoomHashValue implements zoom.GeneratedFields.
+func (m *generatedTestModel) ZoomHashValue(fieldName string) (interface{}, bool) {
+	switch fieldName {
+	case "Int":
+		return m.Int, true
+	case "Uint8":
+		return m.Uint8, true
+	case "Float":
+		return m.Float, true
+	case "Bool":
+		return m.Bool, true
+	case "String":
+		return m.String, true
+	case "Bytes":
+		return m.Bytes, true
+	case "Status":
+		return m.Status, true
+	case "Duration":
+		return int64(m.Duration), true
+	case "IntPtr":
+		if m.IntPtr == nil {
+			return "NULL", true
+		}
+		return *m.IntPtr, true
+	case "StringPtr":
+		if m.StringPtr == nil {
+			return "NULL", true
+		}
+		return *m.StringPtr, true
+	}
+	return nil, false
+}
+
+// ZoomScanHashField implements zoom.GeneratedFields.
+func (m *generatedTestModel) ZoomScanHashField(fieldName string, src []byte) (bool, error) {
+	switch fieldName {
+	case "Int":
+		if len(src) == 0 {
+			return true, nil
+		}
+		parsed, err := strconv.ParseInt(string(src), 10, 0)
+		if err != nil {
+			return true, fmt.Errorf("zoom: could not convert %s to int", string(src))
+		}
+		m.Int = int(parsed)
+		return true, nil
+	case "Uint8":
+		if len(src) == 0 {
+			return true, nil
+		}
+		parsed, err := strconv.ParseUint(string(src), 10, 0)
+		if err != nil {
+			return true, fmt.Errorf("zoom: could not convert %s to uint", string(src))
+		}
+		m.Uint8 = uint8(parsed)
+		return true, nil
+	case "Float":
+		if len(src) == 0 {
+			return true, nil
+		}
+		parsed, err := strconv.ParseFloat(string(src), 64)
+		if err != nil {
+			return true, fmt.Errorf("zoom: could not convert %s to float", string(src))
+		}
+		m.Float = float32(parsed)
+		return true, nil
+	case "Bool":
+		if len(src) == 0 {
+			return true, nil
+		}
+		parsed, err := strconv.ParseBool(string(src))
+		if err != nil {
+			return true, fmt.Errorf("zoom: could not convert %s to bool", string(src))
+		}
+		m.Bool = bool(parsed)
+		return true, nil
+	case "String":
+		if len(src) == 0 {
+			return true, nil
+		}
+		m.String = string(src)
+		return true, nil
+	case "Bytes":
+		if len(src) == 0 {
+			return true, nil
+		}
+		m.Bytes = []byte(src)
+		return true, nil
+	case "Status":
+		if len(src) == 0 {
+			return true, nil
+		}
+		m.Status = generatedTestStatus(src)
+		return true, nil
+	case "Duration":
+		if len(src) == 0 {
+			return true, nil
+		}
+		parsed, err := strconv.ParseInt(string(src), 10, 0)
+		if err != nil {
+			return true, fmt.Errorf("zoom: could not convert %s to int", string(src))
+		}
+		m.Duration = time.Duration(parsed)
+		return true, nil
+	case "IntPtr":
+		if string(src) == "NULL" {
+			return true, nil
+		}
+		v := new(int)
+		m.IntPtr = v
+		if len(src) == 0 {
+			return true, nil
+		}
+		parsed, err := strconv.ParseInt(string(src), 10, 0)
+		if err != nil {
+			return true, fmt.Errorf("zoom: could not convert %s to int", string(src))
+		}
+		*v = int(parsed)
+		return true, nil
+	case "StringPtr":
+		if string(src) == "NULL" {
+			return true, nil
+		}
+		v := new(string)
+		m.StringPtr = v
+		if len(src) == 0 {
+			return true, nil
+		}
+		*v = string(src)
+		return true, nil
+	}
+	return false, nil
+}
@@ -3,6 +3,7 @@ package zoom
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -129,6 +130,87 @@ func newScanModelRefHandler(fieldNames []string, mr *modelRef) ReplyHandler {
 	}
 }
 
+// newPresenceHandler returns a ReplyHandler which records whether each of the
+// given fields is present in the reply from an HMGET command (i.e. whether its
+// value is not nil) in present, and then calls handler.
+func newPresenceHandler(fieldNames []string, present map[string]bool, handler ReplyHandler) ReplyHandler {
+	return func(reply interface{}) error {
+		fieldValues, err := redis.Values(reply, nil)
+		if err == nil {
+			for i, fieldName := range fieldNames {
+				present[fieldName] = i < len(fieldValues) && fieldValues[i] != nil
+			}
+		}
+		return handler(reply)
+	}
+}
+
+// newScanHashFieldHandler returns a ReplyHandler which will scan the reply
+// into the map field of mr described by fs. It expects a reply that looks like
+// the output of an HGETALL command. The map is replaced with a new map
+// containing only the keys and values in the reply. If the reply is empty, the
+// map is set to nil.
+func newScanHashFieldHandler(mr *modelRef, fs *fieldSpec) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+		fieldVal := mr.fieldValue(fs.name)
+		if len(values) == 0 {
+			fieldVal.Set(reflect.Zero(fs.typ))
+			return mr.recordLoadedFields([]string{fs.name})
+		}
+		mapVal := reflect.MakeMap(fs.typ)
+		for i := 0; i+1 < len(values); i += 2 {
+			key, err := redis.String(values[i], nil)
+			if err != nil {
+				return err
+			}
+			src, err := redis.Bytes(values[i+1], nil)
+			if err != nil {
+				return err
+			}
+			elemVal, err := scanKeyFieldElem(mr.spec, fs, src)
+			if err != nil {
+				return err
+			}
+			mapVal.SetMapIndex(reflect.ValueOf(key).Convert(fs.typ.Key()), elemVal)
+		}
+		fieldVal.Set(mapVal)
+		return mr.recordLoadedFields([]string{fs.name})
+	}
+}
+
+// newScanSliceFieldHandler returns a ReplyHandler which will scan the reply
+// into the slice field of mr described by fs. It expects a reply that looks like
+// the output of an LRANGE or SMEMBERS command. The slice is replaced with a new
+// slice containing only the values in the reply. If the reply is empty, the
+// slice is set to nil.
+func newScanSliceFieldHandler(mr *modelRef, fs *fieldSpec) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.ByteSlices(reply, nil)
+		if err != nil {
+			return err
+		}
+		fieldVal := mr.fieldValue(fs.name)
+		if len(values) == 0 {
+			fieldVal.Set(reflect.Zero(fs.typ))
+			return mr.recordLoadedFields([]string{fs.name})
+		}
+		sliceVal := reflect.MakeSlice(fs.typ, 0, len(values))
+		for _, src := range values {
+			elemVal, err := scanKeyFieldElem(mr.spec, fs, src)
+			if err != nil {
+				return err
+			}
+			sliceVal = reflect.Append(sliceVal, elemVal)
+		}
+		fieldVal.Set(sliceVal)
+		return mr.recordLoadedFields([]string{fs.name})
+	}
+}
+
 // NewScanModelHandler returns a ReplyHandler which will scan all the values in
 // the reply into the fields of model. It expects a reply that looks like the
 // output of an HMGET command, without the field names included. The order of
@@ -192,12 +274,19 @@ func newScanModelsHandler(spec *modelSpec, fieldNames []string, models interface
 				modelVal = modelsVal.Index(i)
 				if modelVal.IsNil() {
 					// If the value is nil, allocate space for it
-					modelsVal.Index(i).Set(reflect.New(spec.typ.Elem()))
+					newVal, err := spec.allocModel()
+					if err != nil {
+						return err
+					}
+					modelsVal.Index(i).Set(newVal)
 				}
 			} else {
 				// Index i is out of range of the existing slice. Create a
 				// new modelVal and append it to modelsVal
-				modelVal = reflect.New(spec.typ.Elem())
+				modelVal, err = spec.allocModel()
+				if err != nil {
+					return err
+				}
 				modelsVal.Set(reflect.Append(modelsVal, modelVal))
 			}
 			mr := &modelRef{
@@ -290,3 +379,55 @@ func newScanOneModelHandler(q *query, spec *modelSpec, fieldNames []string, mode
 		return nil
 	}
 }
+
+// newScanExactlyOneModelHandler is like newScanOneModelHandler, but it expects
+// a reply with the fields for up to two models and returns a
+// MultipleModelsFoundError if there are fields for two models. This makes it
+// possible to distinguish between no models and more than one model matching
+// certain query criteria (e.g. for Query.RunExactlyOne).
+func newScanExactlyOneModelHandler(q *query, spec *modelSpec, fieldNames []string, model Model) ReplyHandler {
+	return func(reply interface{}) error {
+		// Scan into a slice which contains the given model followed by a new
+		// model of the same type, which holds the second model (if any).
+		modelsVal := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+		extra := reflect.New(reflect.TypeOf(model).Elem())
+		modelsVal.Elem().Set(reflect.Append(modelsVal.Elem(), reflect.ValueOf(model), extra))
+		if err := newScanModelsHandler(spec, fieldNames, modelsVal.Interface())(reply); err != nil {
+			return err
+		}
+		switch modelsVal.Elem().Len() {
+		case 0:
+			msg := fmt.Sprintf("Could not find a model with the given query criteria: %s", q)
+			return ModelNotFoundError{Collection: q.collection, Msg: msg}
+		case 1:
+			return nil
+		}
+		msg := fmt.Sprintf("Found more than one model with the given query criteria: %s", q)
+		return MultipleModelsFoundError{Collection: q.collection, Msg: msg}
+	}
+}
+
+// newScanIDScoresHandler returns a ReplyHandler which scans the reply from a
+// ZRANGE or ZREVRANGE command with the WITHSCORES option into results. If
+// transform is not nil, it is applied to the scanned ids and scores first.
+func newScanIDScoresHandler(results *[]IDScore, transform func([]IDScore) []IDScore) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.Strings(reply, nil)
+		if err != nil {
+			return err
+		}
+		idScores := []IDScore{}
+		for i := 0; i+1 < len(values); i += 2 {
+			score, err := strconv.ParseFloat(values[i+1], 64)
+			if err != nil {
+				return err
+			}
+			idScores = append(idScores, IDScore{ID: values[i], Score: score})
+		}
+		if transform != nil {
+			idScores = transform(idScores)
+		}
+		(*results) = idScores
+		return nil
+	}
+}
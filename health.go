@@ -0,0 +1,111 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File health.go contains HealthCheck, which can be used for readiness probes.
+
+package zoom
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// HealthCheck returns nil iff the pool is ready to be used. It checks that
+//
+//   - Redis can be reached (with a PING command),
+//   - the Lua scripts which Zoom uses are loaded (SCRIPT EXISTS), loading any
+//     which are missing (e.g. after Redis was restarted) so that it also
+//     detects when scripting is disabled, and
+//   - the schema of every collection registered with the pool is stored in
+//     Redis (see StoredSchema), which is not the case if the database was
+//     flushed after the collections were registered.
+//
+// It is intended for readiness probes, e.g. a Kubernetes readinessProbe on an
+// HTTP handler which calls HealthCheck. If ctx has a deadline, it is used as
+// the timeout for each command (when the connection supports it), and
+// HealthCheck returns ctx.Err() if ctx is done before all the checks are
+// finished.
+func (p *Pool) HealthCheck(ctx context.Context) error {
+	conn := p.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	do := func(cmd string, args ...interface{}) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			if _, ok := conn.(redis.ConnWithTimeout); ok {
+				return redis.DoWithTimeout(conn, time.Until(deadline), cmd, args...)
+			}
+		}
+		return conn.Do(cmd, args...)
+	}
+	if _, err := do("PING"); err != nil {
+		return fmt.Errorf("zoom: health check failed: could not reach Redis: %w", err)
+	}
+	if err := checkScripts(ctx, conn, do); err != nil {
+		return fmt.Errorf("zoom: health check failed: %w", err)
+	}
+	if err := p.checkSchemas(do); err != nil {
+		return fmt.Errorf("zoom: health check failed: %w", err)
+	}
+	return nil
+}
+
+// checkScripts loads any of the scripts used by Zoom which are not loaded on
+// conn, using do to send commands with the deadline of ctx.
+func checkScripts(ctx context.Context, conn redis.Conn, do func(cmd string, args ...interface{}) (interface{}, error)) error {
+	hashes := redis.Args{"EXISTS"}
+	for _, script := range allScripts {
+		hashes = hashes.Add(script.Hash())
+	}
+	exists, err := redis.Ints(do("SCRIPT", hashes...))
+	if err != nil {
+		return fmt.Errorf("could not check scripts: %w", err)
+	}
+	for i, script := range allScripts {
+		if exists[i] == 1 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := script.Load(conn); err != nil {
+			return fmt.Errorf("could not load script %s: %w", script.Hash(), err)
+		}
+	}
+	return nil
+}
+
+// checkSchemas returns an error if the schema of any of the collections
+// registered with the pool is not stored in Redis.
+func (p *Pool) checkSchemas(do func(cmd string, args ...interface{}) (interface{}, error)) error {
+	p.registryMut.RLock()
+	names := make([]string, 0, len(p.modelNameToSpec))
+	for name := range p.modelNameToSpec {
+		names = append(names, name)
+	}
+	p.registryMut.RUnlock()
+	sort.Strings(names)
+	missing := []string{}
+	for _, name := range names {
+		exists, err := redis.Bool(do("EXISTS", schemaKey(name)))
+		if err != nil {
+			return fmt.Errorf("could not check the schema of %s: %w", name, err)
+		}
+		if !exists {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the schemas of the following collections are not stored in Redis: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
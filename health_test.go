@@ -0,0 +1,64 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File health_test.go tests the code in health.go
+
+package zoom
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheck(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollection(&testModel{})
+	require.NoError(t, err)
+
+	// Missing scripts are loaded.
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("SCRIPT", "FLUSH")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, pool.HealthCheck(ctx))
+	for _, script := range allScripts {
+		exists, err := conn.Do("SCRIPT", "EXISTS", script.Hash())
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{int64(1)}, exists)
+	}
+
+	// Missing schemas are reported.
+	_, err = conn.Do("DEL", schemaKey(col.Name()))
+	require.NoError(t, err)
+	err = pool.HealthCheck(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), col.Name())
+	require.NoError(t, pool.storeSchema(col.spec, true))
+	assert.NoError(t, pool.HealthCheck(context.Background()))
+
+	// Done contexts and unreachable servers are reported.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, errors.Is(pool.HealthCheck(canceled), context.Canceled))
+	unreachable := NewPoolWithOptions(testPool.options.WithAddress("localhost:1"))
+	defer func() {
+		_ = unreachable.Close()
+	}()
+	assert.Error(t, unreachable.HealthCheck(context.Background()))
+}
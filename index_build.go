@@ -0,0 +1,301 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_build.go contains code for building the index on a field for all
+// the models which were saved before the field was indexed.
+
+package zoom
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// indexBuildBatchSize is the COUNT argument for the SCAN (or SSCAN) command
+// which is used to iterate over the models in an index build, and thus roughly
+// the number of models in each batch.
+const indexBuildBatchSize = 500
+
+// IndexBuild is a job which builds the index on a single field for all the
+// existing models in a collection in the background. Use Collection.BuildIndex
+// to start one.
+type IndexBuild struct {
+	collection *Collection
+	fs         *fieldSpec
+	mut        sync.Mutex
+	progress   IndexBuildProgress
+	err        error
+	stop       chan struct{}
+	stopOnce   sync.Once
+	done       chan struct{}
+}
+
+// IndexBuildProgress describes the progress of an IndexBuild.
+type IndexBuildProgress struct {
+	// Processed is the number of models which have been indexed so far.
+	Processed int
+	// Total is the number of models in the collection when the build started,
+	// or -1 if it is not known because the collection is not indexed. Models
+	// which are saved or deleted during the build can cause Processed to be
+	// slightly different from Total when the build is done.
+	Total int
+}
+
+// BuildIndex starts building the index on the field with the given name for
+// all the existing models in the collection, e.g. after adding the
+// `zoom:"index"` struct tag or a computed index to a collection which already
+// has data. Until then, the models which were saved before the field was
+// indexed do not match any queries on the field. BuildIndex returns
+// immediately and builds the index in a separate goroutine, processing the
+// models in small batches (using SSCAN on the set of all ids if the collection
+// is indexed, or SCAN otherwise) so that Redis is never blocked for long. Use
+// the returned IndexBuild to check the progress or wait for the build to
+// finish. Models which are saved during the build are indexed as usual, so
+// it is safe to keep using the collection. The values of computed fields are
+// computed from the fields stored in the main hash, so computed indexes which
+// depend on fields stored in their own key are built with the zero values for
+// those fields.
+func (c *Collection) BuildIndex(fieldName string) (*IndexBuild, error) {
+	if err := c.checkHashLayout("BuildIndex"); err != nil {
+		return nil, err
+	}
+	fs, found := c.spec.fieldsByName[fieldName]
+	if !found {
+		return nil, newKindError(ErrFieldNotFound, "zoom: Error in BuildIndex: Collection %s does not have a field named %s", c.Name(), fieldName)
+	}
+	if fs.indexKind == noIndex {
+		return nil, newKindError(ErrUnindexedField, "zoom: Error in BuildIndex: field %s of Collection %s is not indexed", fieldName, c.Name())
+	}
+	b := &IndexBuild{
+		collection: c,
+		fs:         fs,
+		progress:   IndexBuildProgress{Total: -1},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if c.index {
+		count, err := c.Count()
+		if err != nil {
+			return nil, err
+		}
+		b.progress.Total = count
+	}
+	go b.run()
+	return b, nil
+}
+
+// run builds the index one batch at a time until all the models have been
+// processed, an error occurs, or the build is stopped.
+func (b *IndexBuild) run() {
+	defer close(b.done)
+	conn := b.collection.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	cursor := 0
+	for {
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		var ids []string
+		var err error
+		cursor, ids, err = b.scan(conn, cursor)
+		if err != nil {
+			b.setError(err)
+			return
+		}
+		if len(ids) > 0 {
+			if err := b.buildBatch(ids); err != nil {
+				b.setError(err)
+				return
+			}
+			b.mut.Lock()
+			b.progress.Processed += len(ids)
+			b.mut.Unlock()
+		}
+		if cursor == 0 {
+			return
+		}
+	}
+}
+
+// scan returns the next cursor and the ids of the next batch of models.
+func (b *IndexBuild) scan(conn redis.Conn, cursor int) (int, []string, error) {
+	c := b.collection
+	var values []interface{}
+	var err error
+	sorted := false
+	if c.index {
+		// The index may be a set or a sorted set (see MigrateToSortedIndex).
+		var typ string
+		if typ, err = redis.String(conn.Do("TYPE", c.IndexKey())); err != nil {
+			return 0, nil, err
+		}
+		sorted = typ == "zset"
+		command := "SSCAN"
+		if sorted {
+			command = "ZSCAN"
+		}
+		values, err = redis.Values(conn.Do(command, c.IndexKey(), cursor, "COUNT", indexBuildBatchSize))
+	} else {
+		// Only consider hashes, which excludes the field indexes and other keys
+		// with the same prefix.
+		values, err = redis.Values(conn.Do("SCAN", cursor, "MATCH", c.Name()+":*", "COUNT", indexBuildBatchSize, "TYPE", "hash"))
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	next, err := redis.Int(values[0], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	members, err := redis.Strings(values[1], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.index {
+		if sorted {
+			// ZSCAN returns each id followed by its score.
+			ids := make([]string, 0, len(members)/2)
+			for i := 0; i < len(members); i += 2 {
+				ids = append(ids, members[i])
+			}
+			return next, ids, nil
+		}
+		return next, members, nil
+	}
+	ids := []string{}
+	for _, key := range members {
+		id := strings.TrimPrefix(key, c.Name()+":")
+		// Fields which are stored in their own hash have keys of the form
+		// name:id:field.
+		if !strings.Contains(id, ":") {
+			ids = append(ids, id)
+		}
+	}
+	return next, ids, nil
+}
+
+// buildBatch builds the index for the models with the given ids.
+func (b *IndexBuild) buildBatch(ids []string) error {
+	c := b.collection
+	if b.fs.method != "" {
+		return b.buildComputedBatch(ids)
+	}
+	index := FieldIndex{
+		RedisName: b.fs.redisName,
+		Kind:      b.fs.indexKindName(),
+		Pointer:   b.fs.kind == pointerField,
+	}
+	t := c.pool.NewTransaction()
+	for _, id := range ids {
+		t.SyncModelIndexes(c.Name(), id, c.index, []FieldIndex{index})
+		t.updateBitmapIndexes(c.spec, id, "sync", []*fieldSpec{b.fs})
+	}
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	if b.fs.collate != nil {
+		return c.syncCollatedIndexes(ids, []*fieldSpec{b.fs})
+	}
+	return nil
+}
+
+// buildComputedBatch builds the index for a computed field for the models with
+// the given ids. Since the value of the field is not stored in the main hash
+// for models which were saved before the field existed, the models are read
+// first so that the value can be computed and stored.
+func (b *IndexBuild) buildComputedBatch(ids []string) error {
+	c := b.collection
+	t := c.pool.NewTransaction()
+	mrs := make([]*modelRef, len(ids))
+	exists := make([]bool, len(ids))
+	fieldNames := c.spec.fieldNames()
+	for i, id := range ids {
+		model := reflect.New(c.spec.typ.Elem()).Interface().(Model)
+		model.SetModelID(id)
+		mrs[i] = &modelRef{
+			collection: c,
+			model:      model,
+			spec:       c.spec,
+		}
+		t.Command("EXISTS", redis.Args{mrs[i].key()}, NewScanBoolHandler(&exists[i]))
+		if len(fieldNames) > 0 {
+			args := redis.Args{mrs[i].key()}.AddFlat(c.spec.fieldRedisNames())
+			t.Command("HMGET", args, newScanModelRefHandler(fieldNames, mrs[i]))
+		}
+	}
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	t = c.pool.NewTransaction()
+	computedNames := []string{b.fs.name}
+	for i, mr := range mrs {
+		// Skip models which were deleted since the ids were read.
+		if !exists[i] {
+			continue
+		}
+		t.saveFieldIndexesForFields(computedNames, mr)
+		hashArgs, err := mr.mainHashArgsForFields(computedNames)
+		if err != nil {
+			return err
+		}
+		t.Command("HMSET", hashArgs, nil)
+		t.invalidateCachedModel(c, mr.model.ModelID())
+	}
+	return t.Exec()
+}
+
+// setError sets the error for the build iff it was not already set.
+func (b *IndexBuild) setError(err error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Progress returns the current progress of the build.
+func (b *IndexBuild) Progress() IndexBuildProgress {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.progress
+}
+
+// Err returns the error (if any) which stopped the build.
+func (b *IndexBuild) Err() error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.err
+}
+
+// Done returns a channel which is closed when the build has finished, either
+// because all the models have been processed, an error occurred, or Stop was
+// called.
+func (b *IndexBuild) Done() <-chan struct{} {
+	return b.done
+}
+
+// Wait blocks until the build has finished and returns the error (if any)
+// which stopped it.
+func (b *IndexBuild) Wait() error {
+	<-b.done
+	return b.Err()
+}
+
+// Stop stops the build after the current batch and blocks until it has
+// finished. The index is left partially built, and a later build has to start
+// from the beginning. It returns the error (if any) which stopped the build
+// before Stop was called.
+func (b *IndexBuild) Stop() error {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+	return b.Wait()
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_build_test.go tests the code in index_build.go
+
+package zoom
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildOldModel is the version of buildNewModel from before its fields were
+// indexed.
+type buildOldModel struct {
+	Int    int
+	String string
+	Tags   []string `zoom:"list"`
+	RandomID
+}
+
+// buildNewModel is the same as buildOldModel, but with an indexed field and a
+// computed index.
+type buildNewModel struct {
+	Int    int `zoom:"index"`
+	String string
+	Tags   []string `zoom:"list"`
+	RandomID
+}
+
+func (m *buildNewModel) ZoomIndex_Upper() string {
+	return strings.ToUpper(m.String)
+}
+
+func testBuildIndex(t *testing.T, indexed bool) {
+	testingSetUp()
+	defer testingTearDown()
+
+	options := DefaultCollectionOptions.WithName("buildModel").WithIndex(indexed)
+	oldPool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = oldPool.Close()
+	}()
+	oldCol, err := oldPool.NewCollectionWithOptions(&buildOldModel{}, options)
+	require.NoError(t, err)
+	newPool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = newPool.Close()
+	}()
+	newCol, err := newPool.NewCollectionWithOptions(&buildNewModel{}, options)
+	require.NoError(t, err)
+
+	// Save more models than fit in a single batch, so that the build takes
+	// multiple iterations.
+	numModels := indexBuildBatchSize*2 + 10
+	tx := oldPool.NewTransaction()
+	for i := 0; i < numModels; i++ {
+		model := &buildOldModel{Int: i, String: "s" + strconv.Itoa(i%3), Tags: []string{"a"}}
+		tx.Save(oldCol, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	// Before the build, the new indexes are empty.
+	assert.Equal(t, 0, indexSize(t, newCol, "Int"))
+
+	build, err := newCol.BuildIndex("Int")
+	require.NoError(t, err)
+	require.NoError(t, build.Wait())
+	progress := build.Progress()
+	assert.True(t, progress.Processed >= numModels)
+	if indexed {
+		assert.Equal(t, numModels, progress.Total)
+	} else {
+		assert.Equal(t, -1, progress.Total)
+	}
+	assert.Equal(t, numModels, indexSize(t, newCol, "Int"))
+
+	// Computed indexes are computed from the stored fields.
+	build, err = newCol.BuildIndex("Upper")
+	require.NoError(t, err)
+	require.NoError(t, build.Wait())
+	assert.Equal(t, numModels, indexSize(t, newCol, "Upper"))
+	if !indexed {
+		return
+	}
+
+	// The old models should now match queries on the new indexes.
+	models := []*buildNewModel{}
+	require.NoError(t, newCol.NewQuery().Filter("Int <", 2).Order("Int").Run(&models))
+	require.Len(t, models, 2)
+	assert.Equal(t, []int{0, 1}, []int{models[0].Int, models[1].Int})
+	count, err := newCol.NewQuery().Filter("Upper =", "S1").Count()
+	require.NoError(t, err)
+	assert.Equal(t, (numModels+1)/3, count)
+
+	// The build should not change the other fields or create new models.
+	found := &buildNewModel{}
+	require.NoError(t, newCol.Find(models[1].ID, found))
+	assert.Equal(t, "s1", found.String)
+	assert.Equal(t, []string{"a"}, found.Tags)
+	count, err = newCol.Count()
+	require.NoError(t, err)
+	assert.Equal(t, numModels, count)
+}
+
+// indexSize returns the number of models in the index on the given field.
+func indexSize(t *testing.T, col *Collection, fieldName string) int {
+	key, err := col.FieldIndexKey(fieldName)
+	require.NoError(t, err)
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	size, err := redis.Int(conn.Do("ZCARD", key))
+	require.NoError(t, err)
+	return size
+}
+
+func TestBuildIndex(t *testing.T) {
+	testBuildIndex(t, true)
+}
+
+func TestBuildIndexWithoutCollectionIndex(t *testing.T) {
+	testBuildIndex(t, false)
+}
+
+func TestBuildIndexErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&buildNewModel{}, DefaultCollectionOptions.WithName("buildModel"))
+	require.NoError(t, err)
+	_, err = col.BuildIndex("Missing")
+	assert.Error(t, err)
+	_, err = col.BuildIndex("String")
+	assert.Error(t, err)
+
+	// Stopping a build that already finished should be a no-op.
+	build, err := col.BuildIndex("Int")
+	require.NoError(t, err)
+	<-build.Done()
+	assert.NoError(t, build.Stop())
+	assert.NoError(t, build.Stop())
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_drop.go contains code for dropping field indexes and finding
+// indexes which are no longer used.
+
+package zoom
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DropIndex deletes the index on the field with the given name and stops
+// maintaining it, i.e. saving and deleting models no longer updates the index
+// and queries can no longer filter or order by the field. The field itself is
+// still saved as usual. DropIndex only affects this Collection, so unless the
+// `zoom:"index"` struct tag (or the ZoomIndex_ method for a computed index) is
+// also removed, the index will be maintained again the next time the
+// collection is registered, and BuildIndex must be used to rebuild it. Since
+// it changes how the collection saves models, DropIndex should not be called
+// while the collection is being used by other goroutines. It does not affect
+// the RediSearch index (if any).
+func (c *Collection) DropIndex(fieldName string) error {
+	fs, found := c.spec.fieldsByName[fieldName]
+	if !found {
+		return newKindError(ErrFieldNotFound, "zoom: Error in DropIndex: Collection %s does not have a field named %s", c.Name(), fieldName)
+	}
+	if fs.indexKind == noIndex {
+		return newKindError(ErrUnindexedField, "zoom: Error in DropIndex: field %s of Collection %s is not indexed", fieldName, c.Name())
+	}
+	keys := redis.Args{c.spec.indexKeyForField(fs)}
+	if fs.hasNullIndex() {
+		keys = keys.Add(c.spec.nullIndexKey(fs))
+	}
+	if fs.indexKind == stringIndex && fs.collate != nil {
+		keys = keys.Add(c.spec.collatedMembersKey(fs))
+	} else if fs.indexKind == stringIndex || fs.indexKind == integerIndex {
+		keys = keys.Add(c.spec.stringMembersKey(fs))
+	}
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	for i := range fs.enum {
+		keys = keys.Add(c.spec.enumIndexKey(fs, i))
+	}
+	if fs.hasBitmapIndex() {
+		values, err := redis.Strings(conn.Do("SMEMBERS", c.spec.bitmapValuesKey(fs)))
+		if err != nil {
+			return fmt.Errorf("zoom: Error in DropIndex: %w", err)
+		}
+		keys = keys.Add(c.spec.bitmapValuesKey(fs))
+		for _, value := range values {
+			keys = keys.Add(c.spec.bitmapKey(fs, value))
+		}
+	}
+	fs.indexKind = noIndex
+	if _, err := conn.Do("DEL", keys...); err != nil {
+		return fmt.Errorf("zoom: Error in DropIndex: %w", err)
+	}
+	return nil
+}
+
+// StaleIndexes returns the keys of the field indexes (and null indexes for
+// pointer fields) which exist in the database for the collection but do not
+// correspond to any indexed field, e.g. because the field was renamed or
+// removed or is no longer indexed. Zoom never reads or updates these keys, so
+// they can be safely deleted to reclaim the memory they use. The keys are
+// found with SCAN and returned in sorted order.
+func (c *Collection) StaleIndexes() ([]string, error) {
+	indexed := map[string]bool{}
+	nullIndexed := map[string]bool{}
+	for _, fs := range c.spec.fieldsWithComputed() {
+		if fs.indexKind != noIndex {
+			indexed[fs.redisName] = true
+		}
+		if fs.hasNullIndex() {
+			nullIndexed[fs.redisName] = true
+		}
+	}
+	// Sets stored in a key field named null look just like null indexes.
+	checkNull := true
+	for _, fs := range c.spec.keyFields {
+		if fs.redisName == "null" {
+			checkNull = false
+		}
+	}
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	prefix := c.spec.indexKeyPrefix()
+	stale := []string{}
+	zsets, err := c.scanKeys(conn, "zset")
+	if err != nil {
+		return nil, fmt.Errorf("zoom: Error in StaleIndexes: %w", err)
+	}
+	for _, key := range zsets {
+		// The index of all models is a sorted set if the collection has a
+		// sorted index.
+		if key == c.IndexKey() || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		redisName := strings.TrimPrefix(key, prefix)
+		if !strings.Contains(redisName, ":") && !indexed[redisName] {
+			stale = append(stale, key)
+		}
+	}
+	if checkNull {
+		sets, err := c.scanKeys(conn, "set")
+		if err != nil {
+			return nil, fmt.Errorf("zoom: Error in StaleIndexes: %w", err)
+		}
+		for _, key := range sets {
+			if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, ":null") {
+				continue
+			}
+			redisName := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ":null")
+			if !strings.Contains(redisName, ":") && !nullIndexed[redisName] {
+				stale = append(stale, key)
+			}
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// scanKeys returns all the keys which start with the collection name followed
+// by a colon. If keyType is not empty, only keys of that type are returned.
+func (c *Collection) scanKeys(conn redis.Conn, keyType string) ([]string, error) {
+	return scanKeysMatching(conn, c.Name()+":*", keyType)
+}
+
+// scanKeysMatching returns all the keys which match the given pattern. If
+// keyType is not empty, only keys of that type are returned.
+func scanKeysMatching(conn redis.Conn, pattern string, keyType string) ([]string, error) {
+	args := redis.Args{0, "MATCH", pattern, "COUNT", 100}
+	if keyType != "" {
+		args = args.Add("TYPE", keyType)
+	}
+	keys := []string{}
+	seen := map[string]bool{}
+	for {
+		values, err := redis.Values(conn.Do("SCAN", args...))
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := redis.Int(values[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		page, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		// SCAN can return the same key more than once.
+		for _, key := range page {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if cursor == 0 {
+			return keys, nil
+		}
+		args[0] = cursor
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_drop_test.go tests the code in index_drop.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dropOldModel is the version of dropNewModel from before some of its indexes
+// were removed.
+type dropOldModel struct {
+	Int    int      `zoom:"index"`
+	Ptr    *string  `zoom:"index"`
+	Old    string   `zoom:"index"`
+	String string   `zoom:"index"`
+	Tags   []string `zoom:"set"`
+	RandomID
+}
+
+// dropNewModel has the same fields as dropOldModel, except that Old was
+// removed and Ptr is no longer indexed.
+type dropNewModel struct {
+	Int    int `zoom:"index"`
+	Ptr    *string
+	String string   `zoom:"index"`
+	Tags   []string `zoom:"set"`
+	RandomID
+}
+
+func keyExists(t *testing.T, key string) bool {
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	require.NoError(t, err)
+	return exists
+}
+
+func TestDropIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&dropOldModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	require.NoError(t, col.Save(&dropOldModel{Int: 1, String: "a"}))
+	require.True(t, keyExists(t, "dropOldModel:Int"))
+	require.True(t, keyExists(t, "dropOldModel:Ptr:null"))
+
+	assert.Error(t, col.DropIndex("Missing"))
+	require.NoError(t, col.DropIndex("Int"))
+	require.NoError(t, col.DropIndex("Ptr"))
+	assert.Error(t, col.DropIndex("Int"))
+	assert.False(t, keyExists(t, "dropOldModel:Int"))
+	assert.False(t, keyExists(t, "dropOldModel:Ptr:null"))
+
+	// The indexes should no longer be maintained or queryable, but the other
+	// indexes should still work.
+	model := &dropOldModel{Int: 2, String: "b"}
+	require.NoError(t, col.Save(model))
+	assert.False(t, keyExists(t, "dropOldModel:Int"))
+	assert.False(t, keyExists(t, "dropOldModel:Ptr:null"))
+	_, err = col.NewQuery().Filter("Int =", 2).IDs()
+	assert.Error(t, err)
+	ids, err := col.NewQuery().Filter("String =", "b").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.ID}, ids)
+	found := &dropOldModel{}
+	require.NoError(t, col.Find(model.ID, found))
+	assert.Equal(t, 2, found.Int)
+}
+
+func TestStaleIndexes(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	options := DefaultCollectionOptions.WithName("dropModel").WithIndex(true)
+	oldPool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = oldPool.Close()
+	}()
+	oldCol, err := oldPool.NewCollectionWithOptions(&dropOldModel{}, options)
+	require.NoError(t, err)
+	newPool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = newPool.Close()
+	}()
+	newCol, err := newPool.NewCollectionWithOptions(&dropNewModel{}, options)
+	require.NoError(t, err)
+
+	stale, err := newCol.StaleIndexes()
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+
+	require.NoError(t, oldCol.Save(&dropOldModel{Int: 1, Old: "old", Tags: []string{"null"}}))
+	stale, err = newCol.StaleIndexes()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dropModel:Old", "dropModel:Ptr:null"}, stale)
+	stale, err = oldCol.StaleIndexes()
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_encoding.go contains code for migrating the members of string
+// indexes to the escaped encoding of their values.
+
+package zoom
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// MigrateStringIndexEncoding fixes the members of the string indexes of the
+// collection which were written by a version of Zoom that did not escape the
+// values of string fields (see encodeStringIndexValue), and returns the number
+// of members which were fixed. Only values which contain a NULL or 0x01 byte
+// are escaped, so the indexes of most collections do not need to be migrated,
+// and only the members which might belong to such values are checked, which is
+// much faster than rebuilding the indexes with BuildIndex. Each member which
+// does not match the current value of its model is replaced atomically, so it
+// is safe to keep using the collection during the migration, and to call
+// MigrateStringIndexEncoding more than once.
+func (c *Collection) MigrateStringIndexEncoding() (int, error) {
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	count := 0
+	if c.spec.document {
+		// Collections with the document layout were added after the encoding
+		// was changed, so their indexes never need to be migrated.
+		return count, nil
+	}
+	for _, fs := range c.spec.fieldsWithComputed() {
+		if fs.indexKind != stringIndex || fs.collate != nil {
+			continue
+		}
+		indexKey := c.spec.indexKeyForField(fs)
+		// An old member for a value which needs to be escaped contains a 0x01
+		// byte or more than one NULL byte, and so does the new member.
+		members := map[string]map[string]bool{}
+		for _, pattern := range []string{"*\x01*", "*\x00*\x00*"} {
+			if err := scanIndexMembers(conn, indexKey, pattern, func(member string) {
+				id := member[strings.LastIndex(member, nullString)+1:]
+				if members[id] == nil {
+					members[id] = map[string]bool{}
+				}
+				members[id][member] = true
+			}); err != nil {
+				return count, fmt.Errorf("zoom: Error in MigrateStringIndexEncoding: %w", err)
+			}
+		}
+		index := FieldIndex{
+			RedisName: fs.redisName,
+			Key:       indexKey,
+			Kind:      stringIndex.String(),
+			Pointer:   fs.kind == pointerField,
+		}
+		t := c.pool.NewTransaction()
+		fixed := 0
+		for id, idMembers := range members {
+			value, err := redis.String(conn.Do("HGET", c.ModelKey(id), fs.redisName))
+			if err != nil && err != redis.ErrNil {
+				return count, fmt.Errorf("zoom: Error in MigrateStringIndexEncoding: %w", err)
+			}
+			if err == nil && len(idMembers) == 1 && idMembers[encodeStringIndexValue(value)+nullString+id] {
+				continue
+			}
+			// The old members are not recorded in the hash of members, so
+			// SyncModelIndexes would not remove them.
+			for member := range idMembers {
+				t.Command("ZREM", redis.Args{indexKey, member}, nil)
+			}
+			t.SyncModelIndexes(c.Name(), id, false, []FieldIndex{index})
+			fixed++
+		}
+		if err := t.Exec(); err != nil {
+			return count, fmt.Errorf("zoom: Error in MigrateStringIndexEncoding: %w", err)
+		}
+		count += fixed
+	}
+	return count, nil
+}
+
+// scanIndexMembers calls fn with each member of the sorted set identified by
+// key which matches pattern. Since it uses ZSCAN, fn may be called more than
+// once with the same member.
+func scanIndexMembers(conn redis.Conn, key string, pattern string, fn func(member string)) error {
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("ZSCAN", key, cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return err
+		}
+		entries, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return err
+		}
+		// ZSCAN returns each member followed by its score.
+		for i := 0; i < len(entries); i += 2 {
+			fn(entries[i])
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
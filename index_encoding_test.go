@@ -0,0 +1,104 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_encoding_test.go tests the code in index_encoding.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type encodedIndexModel struct {
+	Name string `zoom:"index"`
+	RandomID
+}
+
+func TestStringIndexEncoding(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&encodedIndexModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	names := func(q *Query) []string {
+		models := []*encodedIndexModel{}
+		require.NoError(t, q.Run(&models))
+		result := []string{}
+		for _, model := range models {
+			result = append(result, model.Name)
+		}
+		return result
+	}
+	models := []*encodedIndexModel{
+		{Name: "b"},
+		{Name: "a\x01"},
+		{Name: "a\x00b"},
+		{Name: "a"},
+		{Name: "a\x7f"},
+	}
+	tx := pool.NewTransaction()
+	for _, model := range models {
+		tx.Save(col, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	// Values with NULL, 0x01, and DEL bytes should be ordered and filtered
+	// correctly
+	assert.Equal(t, []string{"a", "a\x00b", "a\x01", "a\x7f", "b"}, names(col.NewQuery().Order("Name")))
+	assert.Equal(t, []string{"a"}, names(col.NewQuery().Filter("Name =", "a")))
+	assert.Equal(t, []string{"a\x00b"}, names(col.NewQuery().Filter("Name =", "a\x00b")))
+	assert.Equal(t, []string{"a", "a\x00b"}, names(col.NewQuery().Filter("Name <", "a\x01").Order("Name")))
+	assert.Equal(t, []string{"a\x01", "a\x7f", "b"}, names(col.NewQuery().Filter("Name >", "a\x00b").Order("Name")))
+	count, err := col.NewQuery().Filter("Name !=", "a").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	// Updating and deleting models should remove the escaped members
+	indexKey, err := col.FieldIndexKey("Name")
+	require.NoError(t, err)
+	cardinality := func() int {
+		conn := pool.NewConn()
+		defer func() {
+			_ = conn.Close()
+		}()
+		card, err := redis.Int(conn.Do("ZCARD", indexKey))
+		require.NoError(t, err)
+		return card
+	}
+	models[1].Name = "c\x01"
+	require.NoError(t, col.Save(models[1]))
+	_, err = col.Delete(models[2].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, cardinality())
+	assert.Equal(t, []string{"a", "a\x7f", "b", "c\x01"}, names(col.NewQuery().Order("Name")))
+
+	// Members written without escaping should be fixed by the migration
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	models[3].Name = "a\x00c"
+	require.NoError(t, col.Save(models[3]))
+	_, err = conn.Do("ZREM", indexKey, "a\x01\x01c\x00"+models[3].ID, "c\x01\x02\x00"+models[1].ID)
+	require.NoError(t, err)
+	_, err = conn.Do("ZADD", indexKey, 0, "a\x00c\x00"+models[3].ID, 0, "c\x01\x00"+models[1].ID)
+	require.NoError(t, err)
+	fixed, err := col.MigrateStringIndexEncoding()
+	require.NoError(t, err)
+	assert.Equal(t, 2, fixed)
+	assert.Equal(t, 4, cardinality())
+	assert.Equal(t, []string{"a\x00c"}, names(col.NewQuery().Filter("Name =", "a\x00c")))
+	assert.Equal(t, []string{"a\x00c", "a\x7f", "b", "c\x01"}, names(col.NewQuery().Order("Name")))
+	fixed, err = col.MigrateStringIndexEncoding()
+	require.NoError(t, err)
+	assert.Equal(t, 0, fixed)
+}
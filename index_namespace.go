@@ -0,0 +1,119 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_namespace.go contains code for moving the field indexes of a
+// collection to the index namespace.
+
+package zoom
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// MigrateIndexNamespace moves the existing field indexes of the collection
+// (including null, enum, bitmap, and full-text indexes) from keys which start
+// with "<name>:" to keys which start with "<name>:index:" (see
+// CollectionOptions.IndexNamespace) and returns the number of keys that were
+// moved. If a key already exists in the index namespace, the old key is merged
+// into it. Keys which are not sets, sorted sets, or bitmaps are never moved, so
+// the main hash of a model whose id is the name of a field is left alone. The
+// collection uses the new keys once MigrateIndexNamespace returns, and the
+// stored schema is updated accordingly, but CollectionOptions.IndexNamespace
+// should also be set so that the collection uses them the next time it is
+// registered. Like DropIndex, MigrateIndexNamespace should not be called while
+// the collection is being used by other goroutines. Models saved by other
+// processes which still use the old keys are not in the new indexes, so
+// MigrateIndexNamespace can be called again (or BuildIndex can be used) once
+// all processes have been updated. It is safe to call MigrateIndexNamespace
+// more than once.
+func (c *Collection) MigrateIndexNamespace() (int, error) {
+	oldPrefix := c.spec.name + ":"
+	newPrefix := c.spec.name + ":index:"
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	args := redis.Args{}
+	addKey := func(suffix string) {
+		args = args.Add(oldPrefix+suffix, newPrefix+suffix)
+	}
+	for _, fs := range c.spec.fieldsWithComputed() {
+		if fs.indexKind != noIndex {
+			addKey(fs.redisName)
+		}
+		if fs.hasNullIndex() {
+			addKey(fs.redisName + ":null")
+		}
+		if fs.indexKind == stringIndex && fs.collate != nil {
+			addKey(fs.redisName + ":collated")
+		} else if fs.indexKind == stringIndex || fs.indexKind == integerIndex {
+			addKey(fs.redisName + ":members")
+		}
+		for i := range fs.enum {
+			addKey(fs.redisName + ":enum:" + strconv.Itoa(i))
+		}
+		if fs.hasBitmapIndex() {
+			values, err := redis.Strings(conn.Do("SMEMBERS", oldPrefix+fs.redisName+":bitmap"))
+			if err != nil {
+				return 0, fmt.Errorf("zoom: Error in MigrateIndexNamespace: %w", err)
+			}
+			addKey(fs.redisName + ":bitmap")
+			for _, value := range values {
+				addKey(fs.redisName + ":bitmap:" + value)
+			}
+		}
+		if fs.fullText != nil {
+			keys, err := scanKeysMatching(conn, oldPrefix+fs.redisName+":fulltext:*", "set")
+			if err != nil {
+				return 0, fmt.Errorf("zoom: Error in MigrateIndexNamespace: %w", err)
+			}
+			for _, key := range keys {
+				addKey(strings.TrimPrefix(key, oldPrefix))
+			}
+		}
+	}
+	count := 0
+	if len(args) > 0 {
+		t := c.pool.NewTransaction()
+		// NOTE: this invokes a lua script which is defined in scripts/move_index_keys.lua
+		t.Script(moveIndexKeysScript, args, NewScanIntHandler(&count))
+		if err := t.Exec(); err != nil {
+			return 0, fmt.Errorf("zoom: Error in MigrateIndexNamespace: %w", err)
+		}
+	}
+	c.spec.indexNamespace = true
+	if err := c.pool.storeIndexNamespace(c.spec); err != nil {
+		return count, fmt.Errorf("zoom: Error in MigrateIndexNamespace: could not update the stored schema: %w", err)
+	}
+	return count, nil
+}
+
+// storeIndexNamespace records in the stored schema for ms whether the
+// collection uses the index namespace, without changing the rest of the stored
+// schema (so that CheckSchemaDrift still reports any other differences). If
+// there is no stored schema, the schema for ms is stored.
+func (p *Pool) storeIndexNamespace(ms *modelSpec) error {
+	schema, found, err := p.StoredSchema(ms.name)
+	if err != nil {
+		return err
+	} else if !found {
+		return p.storeSchema(ms, false)
+	}
+	schema.IndexNamespace = ms.indexNamespace
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	conn := p.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("SET", schemaKey(ms.name), data)
+	return err
+}
@@ -0,0 +1,166 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File index_namespace_test.go tests the code in index_namespace.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type namespacedModel struct {
+	Age    int     `zoom:"index"`
+	Nick   *string `zoom:"index"`
+	Status string  `zoom:"enum=active|inactive"`
+	Color  string  `zoom:"index,bitmap"`
+	Bio    string  `zoom:"fulltext"`
+	RandomID
+}
+
+// checkNamespacedQueries checks that the indexes of col, which contains the
+// models created by saveNamespacedModels, can be queried.
+func checkNamespacedQueries(t *testing.T, col *Collection, models []*namespacedModel) {
+	ids, err := col.NewQuery().Filter("Age >", 30).Order("Age").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[1].ID, models[2].ID}, ids)
+	ids, err = col.NewQuery().Filter("Nick =", nil).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[1].ID}, ids)
+	ids, err = col.NewQuery().Filter("Status =", "inactive").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[2].ID}, ids)
+	ids, err = col.NewQuery().Filter("Color =", "red").Order("Age").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[0].ID, models[2].ID}, ids)
+	ids, err = col.NewQuery().Search("Bio", "gopher").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[1].ID}, ids)
+}
+
+// saveNamespacedModels saves some models in col. If firstID is not empty, it is
+// used as the id of the first model.
+func saveNamespacedModels(t *testing.T, col *Collection, firstID string) []*namespacedModel {
+	nick := "bob"
+	models := []*namespacedModel{
+		{Age: 25, Nick: &nick, Status: "active", Color: "red", Bio: "likes redis"},
+		{Age: 35, Status: "active", Color: "blue", Bio: "a gopher"},
+		{Age: 45, Nick: &nick, Status: "inactive", Color: "red", Bio: "likes go"},
+	}
+	models[0].ID = firstID
+	tx := col.pool.NewTransaction()
+	for _, model := range models {
+		tx.Save(col, model)
+	}
+	require.NoError(t, tx.Exec())
+	return models
+}
+
+func TestIndexNamespace(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&namespacedModel{}, DefaultCollectionOptions.WithIndex(true).WithIndexNamespace(true))
+	require.NoError(t, err)
+	models := saveNamespacedModels(t, col, "Age")
+
+	// The model whose id is the name of a field should not collide with the
+	// index on the field
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	for key, expected := range map[string]string{
+		"namespacedModel:Age":                "hash",
+		"namespacedModel:index:Age":          "zset",
+		"namespacedModel:index:Nick:null":    "set",
+		"namespacedModel:index:Status":       "zset",
+		"namespacedModel:index:Color:bitmap": "set",
+	} {
+		keyType, err := redis.String(conn.Do("TYPE", key))
+		require.NoError(t, err)
+		assert.Equal(t, expected, keyType, "wrong type for %s", key)
+	}
+	found := &namespacedModel{}
+	require.NoError(t, col.Find("Age", found))
+	assert.Equal(t, models[0], found)
+	checkNamespacedQueries(t, col, models)
+	stale, err := col.StaleIndexes()
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+
+	// Updating and deleting models should update the indexes
+	updated, err := col.NewQuery().Filter("Age <", 30).Update(map[string]interface{}{"Age": 55})
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	ids, err := col.NewQuery().Order("-Age").Limit(1).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Age"}, ids)
+	_, err = col.Delete("Age")
+	require.NoError(t, err)
+	count, err := col.NewQuery().Filter("Color =", "red").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	size, err := redis.Int(conn.Do("ZCARD", "namespacedModel:index:Age"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+}
+
+func TestMigrateIndexNamespace(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&namespacedModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := saveNamespacedModels(t, col, "")
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	keyType, err := redis.String(conn.Do("TYPE", "namespacedModel:Nick:null"))
+	require.NoError(t, err)
+	assert.Equal(t, "set", keyType)
+
+	moved, err := col.MigrateIndexNamespace()
+	require.NoError(t, err)
+	assert.True(t, moved > 0, "expected some keys to be moved")
+	exists, err := redis.Bool(conn.Do("EXISTS", "namespacedModel:Nick:null"))
+	require.NoError(t, err)
+	assert.False(t, exists, "the old null index should have been moved")
+	keys, err := scanKeysMatching(conn, "namespacedModel:Bio:fulltext:*", "")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	stored, found, err := pool.StoredSchema(col.Name())
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.True(t, stored.IndexNamespace)
+
+	// The collection should use the new keys
+	checkNamespacedQueries(t, col, models)
+	other := &namespacedModel{Age: 50, Status: "inactive", Color: "green"}
+	require.NoError(t, col.Save(other))
+	ids, err := col.NewQuery().Filter("Status =", "inactive").IDs()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{models[2].ID, other.ID}, ids)
+	exists, err = redis.Bool(conn.Do("EXISTS", "namespacedModel:Status"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Migrating again should not move anything
+	moved, err = col.MigrateIndexNamespace()
+	require.NoError(t, err)
+	assert.Equal(t, 0, moved)
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File intercept.go contains code related to query interceptors, which see
+// every query run through a Pool before it is executed.
+
+package zoom
+
+import "fmt"
+
+// QueryInterceptor is called with every query run through a pool right before
+// it is executed, along with the name of the query finisher (e.g. "Run",
+// "Count", or "Delete"). It can add modifiers to q (e.g. a mandatory filter on
+// the owner of the models), which only apply to that execution of the query,
+// or reject the query by returning an error, in which case the query is not
+// executed and the finisher returns an error which wraps it. Use
+// Query.Collection to find out which collection is queried and Query.Value to
+// read values attached to the query with WithValue (e.g. the current user).
+type QueryInterceptor func(q *Query, finisher string) error
+
+// InterceptQueries adds one or more query interceptors to the pool. They are
+// called in the order they were added for every query created with
+// Collection.NewQuery or Transaction.Query (including the queries of typed,
+// dynamic, and sharded collections) when it is executed. It is not safe to
+// call InterceptQueries concurrently with other methods of the pool, so it
+// should be called before the pool is used.
+func (p *Pool) InterceptQueries(interceptors ...QueryInterceptor) {
+	p.queryInterceptors = append(p.queryInterceptors, interceptors...)
+}
+
+// Collection returns the collection which the query is for.
+func (q *Query) Collection() *Collection {
+	return q.collection
+}
+
+// WithValue attaches a value to the query with the given key, which can be
+// read by query interceptors (see QueryInterceptor) with Value. Like with
+// context.WithValue, key should be of an unexported type to avoid collisions.
+// Values are not stored by MarshalJSON.
+func (q *Query) WithValue(key, value interface{}) *Query {
+	q.query.setValue(key, value)
+	return q
+}
+
+// Value returns the value attached to the query with the given key by
+// WithValue, or nil if there is none.
+func (q *Query) Value(key interface{}) interface{} {
+	return q.values[key]
+}
+
+// WithValue works exactly like Query.WithValue. See the documentation for
+// Query.WithValue for more information.
+func (q *TransactionQuery) WithValue(key, value interface{}) *TransactionQuery {
+	q.query.setValue(key, value)
+	return q
+}
+
+// setValue attaches value to the query with the given key.
+func (q *query) setValue(key, value interface{}) {
+	if q.values == nil {
+		q.values = map[interface{}]interface{}{}
+	}
+	q.values[key] = value
+}
+
+// intercept returns the query which should be executed by the given finisher.
+// If the pool has any query interceptors, it is a copy of q to which the
+// interceptors have been applied, so that q itself is not changed and can be
+// run again. Otherwise it is q itself. Queries which were already intercepted
+// (e.g. by a finisher which calls other finishers) are not intercepted again.
+func (q *query) intercept(finisher string) *query {
+	if q.intercepted || q.hasError() || len(q.pool.queryInterceptors) == 0 {
+		return q
+	}
+	copied := q.copy()
+	copied.intercepted = true
+	for _, interceptor := range q.pool.queryInterceptors {
+		if err := interceptor(&Query{query: copied}, finisher); err != nil {
+			copied.setError(fmt.Errorf("zoom: error in %s: the query %s was rejected: %w", finisher, q, err))
+			break
+		}
+	}
+	return copied
+}
+
+// intercept is like query.intercept but returns a TransactionQuery for the
+// same transaction.
+func (q *TransactionQuery) intercept(finisher string) *TransactionQuery {
+	intercepted := q.query.intercept(finisher)
+	if intercepted == q.query {
+		return q
+	}
+	return newTransactionQuery(intercepted, q.tx)
+}
+
+// copy returns a copy of q which can be changed without affecting q.
+func (q *query) copy() *query {
+	copied := *q
+	copied.includes = append([]string(nil), q.includes...)
+	copied.excludes = append([]string(nil), q.excludes...)
+	copied.filters = append([]filter(nil), q.filters...)
+	copied.searches = append([]search(nil), q.searches...)
+	copied.idSets = append([]string(nil), q.idSets...)
+	copied.idFilters = append([]idFilter(nil), q.idFilters...)
+	copied.negations = append([]*query(nil), q.negations...)
+	copied.joins = make([]*join, len(q.joins))
+	for i, j := range q.joins {
+		copiedJoin := *j
+		copiedJoin.filters = append([]filter(nil), j.filters...)
+		copied.joins[i] = &copiedJoin
+	}
+	return &copied
+}
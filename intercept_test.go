@@ -0,0 +1,102 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File intercept_test.go tests the code in intercept.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type interceptTestModel struct {
+	Owner string `zoom:"index"`
+	Int   int    `zoom:"index"`
+	RandomID
+}
+
+type interceptTestKey struct{}
+
+func TestInterceptQueries(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&interceptTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := []*interceptTestModel{
+		{Owner: "alice", Int: 1},
+		{Owner: "bob", Int: 2},
+		{Owner: "alice", Int: 3},
+		{Owner: "bob", Int: 4},
+	}
+	tx := pool.NewTransaction()
+	for _, model := range models {
+		tx.Save(col, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	errNoUser := errors.New("no user")
+	finishers := []string{}
+	pool.InterceptQueries(func(q *Query, finisher string) error {
+		finishers = append(finishers, finisher)
+		if q.Collection() != col {
+			return nil
+		}
+		user, ok := q.Value(interceptTestKey{}).(string)
+		if !ok {
+			return errNoUser
+		}
+		q.Filter("Owner =", user)
+		return nil
+	})
+
+	// The mandatory filter is added, and only once even if the query is run
+	// more than once or by a finisher which uses other finishers.
+	query := col.NewQuery().Order("Int").WithValue(interceptTestKey{}, "alice")
+	for i := 0; i < 2; i++ {
+		got := []*interceptTestModel{}
+		require.NoError(t, query.Run(&got))
+		assert.Equal(t, []*interceptTestModel{models[0], models[2]}, got)
+	}
+	assert.Equal(t, []string{"Run", "Run"}, finishers)
+	assert.NotContains(t, query.String(), "Owner")
+	count, err := query.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	got := []*interceptTestModel{}
+	info, err := query.RunPage(&got, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []*interceptTestModel{models[0]}, got)
+	assert.Equal(t, 2, info.Total)
+	last := &interceptTestModel{}
+	require.NoError(t, query.LastOne(last))
+	assert.Equal(t, models[2], last)
+	assert.Equal(t, []string{"Run", "Run", "Count", "RunPage", "LastOne"}, finishers)
+
+	// Parallel queries and queries in transactions are intercepted too.
+	got = []*interceptTestModel{}
+	require.NoError(t, col.NewQuery().Order("Int").Parallel(2).WithValue(interceptTestKey{}, "bob").Run(&got))
+	assert.Equal(t, []*interceptTestModel{models[1], models[3]}, got)
+	tx = pool.NewTransaction()
+	ids := []string{}
+	tx.Query(col).Order("Int").WithValue(interceptTestKey{}, "bob").IDs(&ids)
+	require.NoError(t, tx.Exec())
+	assert.Equal(t, []string{models[1].ModelID(), models[3].ModelID()}, ids)
+
+	// Rejected queries are not executed.
+	deleted, err := col.NewQuery().Delete()
+	assert.True(t, errors.Is(err, errNoUser), "expected errNoUser but got %v", err)
+	assert.Equal(t, 0, deleted)
+	count, err = col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, len(models), count)
+}
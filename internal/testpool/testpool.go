@@ -0,0 +1,41 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// Package testpool creates the pools used by the tests of the subpackages of
+// Zoom (zoombench, zoomfixtures, and zoomtest). The tests of the zoom package
+// use database 9 by default, and the tests of different packages may run at
+// the same time, so each subpackage uses its own database.
+package testpool
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/albrow/zoom"
+)
+
+// The default databases for the tests of each subpackage. Each subpackage has
+// its own flag to override them.
+const (
+	BenchDatabase    = 10
+	FixturesDatabase = 11
+	ZoomtestDatabase = 12
+)
+
+// New returns a pool which connects to the given database of the Redis server
+// given by the address and network flags, which are defined by the zoom
+// package. The database is flushed and the pool is closed when t completes.
+func New(t testing.TB, database int) *zoom.Pool {
+	pool := zoom.NewPoolWithOptions(zoom.DefaultPoolOptions.
+		WithAddress(flag.Lookup("address").Value.String()).
+		WithNetwork(flag.Lookup("network").Value.String()).
+		WithDatabase(database))
+	t.Cleanup(func() {
+		conn := pool.NewConn()
+		_, _ = conn.Do("FLUSHDB")
+		_ = conn.Close()
+		_ = pool.Close()
+	})
+	return pool
+}
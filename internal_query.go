@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -27,8 +28,30 @@ type query struct {
 	order      order
 	limit      uint
 	offset     uint
+	last       uint
+	sample     uint
 	filters    []filter
+	searches   []search
+	idSets     []string
+	idFilters  []idFilter
+	joins      []*join
+	negations  []*query
+	timeout    time.Duration
+	workers    int
 	err        error
+	// scope is the query as printed by String right after the default scope of
+	// the collection was applied, or an empty string if the query is not
+	// scoped. It is used by Unscoped to detect modifiers added after the scope.
+	scope string
+	// values are attached to the query with WithValue for use by query
+	// interceptors.
+	values map[interface{}]interface{}
+	// intercepted is true iff the query interceptors of the pool have already
+	// been applied to the query (see intercept).
+	intercepted bool
+	// defaultOrder is true iff order is the default order of the collection
+	// (see applyDefaultOrder).
+	defaultOrder bool
 }
 
 // newQuery creates and returns a new query with the given collection. It will
@@ -47,22 +70,78 @@ func newQuery(collection *Collection) *query {
 	return q
 }
 
+// applyDefaultScope applies the default scope of the collection (see
+// CollectionOptions.DefaultScope), if any, to the query.
+func (q *query) applyDefaultScope() {
+	if q.collection.defaultScope == nil || q.hasError() {
+		return
+	}
+	q.collection.defaultScope(&Query{query: q})
+	q.scope = q.String()
+}
+
+// Unscoped removes the modifiers which were added to the query by the default
+// scope of the collection. It sets an error on the query if any other
+// modifiers were added before Unscoped was called, since they cannot be told
+// apart from the modifiers of the scope.
+func (q *query) Unscoped() {
+	if q.scope == "" {
+		return
+	}
+	if q.String() != q.scope {
+		q.setError(fmt.Errorf("zoom: error in Query.Unscoped: Unscoped must be called before any other query modifiers"))
+		return
+	}
+	*q = *newQuery(q.collection)
+	q.applyDefaultOrder()
+}
+
 // String satisfies fmt.Stringer and prints out the query in a format that
 // matches the go code used to declare it.
 func (q *query) String() string {
 	result := fmt.Sprintf("%s.NewQuery()", q.collection.Name())
+	for _, key := range q.idSets {
+		result += fmt.Sprintf(`.FromIDSet("%s")`, key)
+	}
+	for _, f := range q.idFilters {
+		result += fmt.Sprintf(".%s", f)
+	}
+	for _, join := range q.joins {
+		result += fmt.Sprintf(".%s", join)
+		for _, filter := range join.filters {
+			result += "." + filter.format(join.alias+"."+filter.fieldSpec.name)
+		}
+	}
 	for _, filter := range q.filters {
 		result += fmt.Sprintf(".%s", filter)
 	}
-	if q.hasOrder() {
+	for _, search := range q.searches {
+		result += fmt.Sprintf(".%s", search)
+	}
+	for _, negation := range q.negations {
+		result += "." + notString(negation)
+	}
+	if q.hasExplicitOrder() {
 		result += fmt.Sprintf(".%s", q.order)
 	}
+	if q.hasLast() {
+		result += fmt.Sprintf(".Last(%d)", q.last)
+	}
+	if q.hasSample() {
+		result += fmt.Sprintf(".Sample(%d)", q.sample)
+	}
 	if q.hasOffset() {
 		result += fmt.Sprintf(".Offset(%d)", q.offset)
 	}
 	if q.hasLimit() {
 		result += fmt.Sprintf(".Limit(%d)", q.limit)
 	}
+	if q.timeout > 0 {
+		result += fmt.Sprintf(".Timeout(%s)", q.timeout)
+	}
+	if q.workers > 1 {
+		result += fmt.Sprintf(".Parallel(%d)", q.workers)
+	}
 	if q.hasIncludes() {
 		result += fmt.Sprintf(`.Include("%s")`, strings.Join(q.includes, `", "`))
 	} else if q.hasExcludes() {
@@ -75,9 +154,15 @@ type order struct {
 	fieldName string
 	redisName string
 	kind      orderKind
+	// weights are the fields combined by OrderByExpr, sorted by field name.
+	// fieldName and redisName are empty if there are any weights.
+	weights []fieldWeight
 }
 
 func (o order) String() string {
+	if o.isExpr() {
+		return o.exprString()
+	}
 	if o.kind == ascendingOrder {
 		return fmt.Sprintf(`Order("%s")`, o.fieldName)
 	}
@@ -101,17 +186,74 @@ func (ok orderKind) String() string {
 	return ""
 }
 
+// filter is a condition on the value of an indexed field. For range filters,
+// value is the lower bound and max is the upper bound.
 type filter struct {
 	fieldSpec *fieldSpec
 	op        filterOp
 	value     reflect.Value
+	max       reflect.Value
+	inclusive bool
 }
 
 func (f filter) String() string {
-	if f.value.Kind() == reflect.String {
-		return fmt.Sprintf(`Filter("%s %s", "%s")`, f.fieldSpec.name, f.op, f.value.String())
+	return f.format(f.fieldSpec.name)
+}
+
+// format returns the filter formatted the same way as it would appear in go
+// code, using name as the name of the field.
+func (f filter) format(name string) string {
+	if f.op == rangeOp {
+		return fmt.Sprintf(`FilterRange("%s", %s, %s, %t)`, name, formatFilterValue(f.value), formatFilterValue(f.max), f.inclusive)
+	}
+	return fmt.Sprintf(`Filter("%s %s", %s)`, name, f.op, f.valueString())
+}
+
+// valueString returns the value of the filter formatted the same way as it
+// would appear in go code.
+func (f filter) valueString() string {
+	return formatFilterValue(f.value)
+}
+
+// formatFilterValue returns val formatted the same way as it would appear in
+// go code.
+func formatFilterValue(val reflect.Value) string {
+	if !val.IsValid() {
+		return "nil"
+	}
+	if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+		return fmt.Sprintf("%#v", val.Interface())
+	}
+	if val.Kind() == reflect.String {
+		return fmt.Sprintf(`"%s"`, val.String())
 	}
-	return fmt.Sprintf(`Filter("%s %s", %v)`, f.fieldSpec.name, f.op, f.value.Interface())
+	return fmt.Sprintf("%v", val.Interface())
+}
+
+// join connects the query to another collection via a field which holds the
+// ids of models in that collection. Filters on the joined collection are
+// stored in the join and are referenced by alias, e.g. "Author.Country =".
+type join struct {
+	fieldSpec *fieldSpec
+	target    *Collection
+	alias     string
+	filters   []filter
+}
+
+func (j join) String() string {
+	return fmt.Sprintf(`Join("%s", %s)`, j.fieldSpec.name, j.target.Name())
+}
+
+// search is a full-text search on a single field. A model matches the search
+// iff the field contains all of the terms.
+type search struct {
+	fieldSpec *fieldSpec
+	text      string
+	terms     []string
+}
+
+func (s search) String() string {
+	return fmt.Sprintf(`Search("%s", "%s")`, s.fieldSpec.name, s.text)
 }
 
 type filterOp int
@@ -123,6 +265,10 @@ const (
 	lessOp
 	greaterOrEqualOp
 	lessOrEqualOp
+	isNullOp
+	isNotNullOp
+	inOp
+	rangeOp
 )
 
 func (fk filterOp) String() string {
@@ -139,6 +285,14 @@ func (fk filterOp) String() string {
 		return ">="
 	case lessOrEqualOp:
 		return "<="
+	case isNullOp:
+		return "IS"
+	case isNotNullOp:
+		return "IS NOT"
+	case inOp:
+		return "IN"
+	case rangeOp:
+		return "RANGE"
 	}
 	return ""
 }
@@ -152,6 +306,16 @@ var filterOps = map[string]filterOp{
 	"<=": lessOrEqualOp,
 }
 
+// specialFilterOps are the filter operators which, unlike the operators in
+// filterOps, do not take a value of the same type as the field. IS and IS NOT
+// compare a pointer field to nil, and IN takes a slice of values.
+var specialFilterOps = map[string]filterOp{
+	"IS":     isNullOp,
+	"IS NOT": isNotNullOp,
+	"IN":     inOp,
+	"in":     inOp,
+}
+
 // setError sets the err property of q only if it has not already been set
 func (q *query) setError(e error) {
 	if !q.hasError() {
@@ -174,7 +338,7 @@ func (q *query) setError(e error) {
 // is executed. When the query is executed the first error that occurred during
 // the lifetime of the query object (if any) will be returned.
 func (q *query) Order(fieldName string) {
-	if q.hasOrder() {
+	if q.hasExplicitOrder() {
 		// TODO: allow secondary sort orders?
 		q.setError(errors.New("zoom: error in Query.Order: previous order already specified (only one order per query is allowed)"))
 		return
@@ -191,7 +355,7 @@ func (q *query) Order(fieldName string) {
 	// Get the redisName for the given fieldName
 	fs, found := q.collection.spec.fieldsByName[fieldName]
 	if !found {
-		err := fmt.Errorf("zoom: error in Query.Order: could not find field %s in type %s", fieldName, q.collection.spec.typ.String())
+		err := newKindError(ErrFieldNotFound, "zoom: error in Query.Order: could not find field %s in type %s", fieldName, q.collection.spec.typ.String())
 		q.setError(err)
 		return
 	}
@@ -200,6 +364,7 @@ func (q *query) Order(fieldName string) {
 		redisName: fs.redisName,
 		kind:      ok,
 	}
+	q.defaultOrder = false
 }
 
 // Limit specifies an upper limit on the number of records to return. If amount
@@ -214,6 +379,28 @@ func (q *query) Offset(amount uint) {
 	q.offset = amount
 }
 
+// Last restricts the query to the last n records in the order specified by
+// Order. Limit and Offset are applied to the remaining records. If n is 0, the
+// Last modifier has no effect.
+func (q *query) Last(n uint) {
+	q.last = n
+}
+
+// setTimeout sets the maximum amount of time to wait for a reply from Redis
+// when the query is executed. A timeout of 0 means no timeout. Unlike the
+// other modifiers, it is unexported so that it is not promoted to
+// TransactionQuery, which uses the timeout of its transaction instead.
+func (q *query) setTimeout(d time.Duration) {
+	q.timeout = d
+}
+
+// setParallel sets the number of connections used to read models when the
+// query is run. Like setTimeout, it is unexported so that it is not promoted
+// to TransactionQuery, which always runs on the connection of its transaction.
+func (q *query) setParallel(workers int) {
+	q.workers = workers
+}
+
 // Include specifies one or more field names which will be read from the
 // database and scanned into the resulting models when the query is run. Field
 // names which are not specified in Include will not be read or scanned. You can
@@ -271,38 +458,301 @@ func (q *query) Filter(filterString string, value interface{}) {
 	// Parse the filter operator
 	fOp, found := filterOps[operator]
 	if !found {
-		q.setError(errors.New("zoom: invalid Filter operator in fieldStr (should be one of =, !=, >, <, >=, or <=)"))
-		return
+		fOp, found = specialFilterOps[operator]
 	}
-	// Get the fieldSpec for the given fieldName
-	fieldSpec, found := q.collection.spec.fieldsByName[fieldName]
 	if !found {
-		err := fmt.Errorf("zoom: error in Query.Order: could not find field %s in type %s", fieldName, q.collection.spec.typ.String())
-		q.setError(err)
+		q.setError(errors.New("zoom: invalid Filter operator in fieldStr (should be one of =, !=, >, <, >=, <=, IS, IS NOT, or IN)"))
 		return
 	}
-	// Make sure the field is an indexed field
-	if fieldSpec.indexKind == noIndex {
-		err := fmt.Errorf("zoom: filters are only allowed on indexed fields and %s.%s is not indexed (try adding the `zoom:\"index\"` struct tag)", q.collection.spec.typ.String(), fieldName)
+	// Comparing to nil with = or != is the same as using IS or IS NOT.
+	if value == nil {
+		switch fOp {
+		case equalOp:
+			fOp = isNullOp
+		case notEqualOp:
+			fOp = isNotNullOp
+		}
+	}
+	fieldSpec, fltrJoin, err := q.filterField("Filter", fieldName)
+	if err != nil {
 		q.setError(err)
 		return
 	}
+	spec := q.collection.spec
+	if fltrJoin != nil {
+		spec = fltrJoin.target.spec
+	}
 	fltr := filter{
 		fieldSpec: fieldSpec,
 		op:        fOp,
 	}
-	// Make sure the given value is the correct type
-	if err := fltr.checkValType(value); err != nil {
+	if fOp == isNullOp || fOp == isNotNullOp {
+		// Only pointer fields can be nil, and the value must be nil
+		if fieldSpec.kind != pointerField {
+			err := fmt.Errorf("zoom: the %s filter operator can only be used on pointer fields and %s.%s is not a pointer", fOp, spec.typ.String(), fieldName)
+			q.setError(err)
+			return
+		}
+		if value != nil {
+			err := fmt.Errorf("zoom: invalid value for Filter on %s: the value for the %s operator must be nil", fieldName, fOp)
+			q.setError(err)
+			return
+		}
+	} else if fOp == inOp {
+		if err := fltr.checkInValues(value); err != nil {
+			q.setError(err)
+			return
+		}
+	} else if err := fltr.checkValType(value); err != nil {
+		// Make sure the given value is the correct type
 		q.setError(err)
 		return
 	}
 	fltr.value = reflect.ValueOf(value)
+	if fieldSpec.indexKind == enumIndex {
+		if err := fltr.checkEnumValues(); err != nil {
+			q.setError(err)
+			return
+		}
+	}
+	q.addFilter(fltr, fltrJoin)
+}
+
+// FilterRange applies a filter to the query which will cause the query to only
+// return models for which the given field is between min and max. If inclusive
+// is true, models for which the field is equal to min or max are included. It
+// is equivalent to applying two filters with the >= and <= (or > and <)
+// operators, but only needs to extract the matching ids from the field index
+// once. Any errors are set on the query and returned when it is executed.
+func (q *query) FilterRange(fieldName string, min interface{}, max interface{}, inclusive bool) {
+	fieldSpec, fltrJoin, err := q.filterField("FilterRange", fieldName)
+	if err != nil {
+		q.setError(err)
+		return
+	}
+	fltr := filter{
+		fieldSpec: fieldSpec,
+		op:        rangeOp,
+		inclusive: inclusive,
+	}
+	for _, bound := range []interface{}{min, max} {
+		if err := fltr.checkValType(bound); err != nil {
+			q.setError(err)
+			return
+		}
+	}
+	if fieldSpec.indexKind == enumIndex {
+		q.setError(fmt.Errorf("zoom: error in Query.FilterRange: enum fields such as %s only support the =, !=, and IN filter operators", fieldName))
+		return
+	}
+	fltr.value = reflect.ValueOf(min)
+	fltr.max = reflect.ValueOf(max)
+	q.addFilter(fltr, fltrJoin)
+}
+
+// filterField returns the fieldSpec for the indexed field with the given name,
+// as well as the join it belongs to if the name is of the form alias.fieldName
+// and is not the name of a nested field.
+// method is the name of the query method, which is used in error messages.
+func (q *query) filterField(method string, fieldName string) (*fieldSpec, *join, error) {
+	// If the field name is of the form alias.fieldName and is not the path of a
+	// nested field (e.g. Address.City), the filter applies to a joined
+	// collection.
+	spec := q.collection.spec
+	var fltrJoin *join
+	if i := strings.Index(fieldName, "."); i != -1 && spec.fieldsByName[fieldName] == nil {
+		alias := fieldName[:i]
+		fieldName = fieldName[i+1:]
+		for _, j := range q.joins {
+			if j.alias == alias {
+				fltrJoin = j
+				break
+			}
+		}
+		if fltrJoin == nil {
+			return nil, nil, fmt.Errorf("zoom: error in Query.%s: could not find a join named %s (did you call Join first?)", method, alias)
+		}
+		spec = fltrJoin.target.spec
+	}
+	// Get the fieldSpec for the given fieldName
+	fieldSpec, found := spec.fieldsByName[fieldName]
+	if !found {
+		return nil, nil, newKindError(ErrFieldNotFound, "zoom: error in Query.%s: could not find field %s in type %s", method, fieldName, spec.typ.String())
+	}
+	// Make sure the field is an indexed field
+	if fieldSpec.indexKind == noIndex {
+		return nil, nil, newKindError(ErrUnindexedField, "zoom: filters are only allowed on indexed fields and %s.%s is not indexed (try adding the `zoom:\"index\"` struct tag)", spec.typ.String(), fieldName)
+	}
+	return fieldSpec, fltrJoin, nil
+}
+
+// addFilter adds fltr to the query, or to fltrJoin if it is not nil.
+func (q *query) addFilter(fltr filter, fltrJoin *join) {
+	if fltrJoin != nil {
+		fltrJoin.filters = append(fltrJoin.filters, fltr)
+		return
+	}
 	q.filters = append(q.filters, fltr)
-	return
+}
+
+// Search applies a full-text search to the query, which will cause the query
+// to only return models for which the given field contains all of the terms in
+// text. text is split into terms using the same options (e.g. stemming) as the
+// full-text index for the field. You can only use Search on fields which have
+// the `zoom:"fulltext"` struct tag. If text does not contain any terms (e.g. if
+// all the words are stop words), Search has no effect. Search will set an error
+// on the query if the field does not exist or does not have a full-text index.
+// The error, same as any other error that occurs during the lifetime of the
+// query, is not returned until the query is executed.
+func (q *query) Search(fieldName string, text string) {
+	fs, found := q.collection.spec.fieldsByName[fieldName]
+	if !found {
+		err := newKindError(ErrFieldNotFound, "zoom: error in Query.Search: could not find field %s in type %s", fieldName, q.collection.spec.typ.String())
+		q.setError(err)
+		return
+	}
+	if fs.fullText == nil {
+		err := newKindError(ErrUnindexedField, "zoom: Search is only allowed on fields with a full-text index and %s.%s does not have one (try adding the `zoom:\"fulltext\"` struct tag)", q.collection.spec.typ.String(), fieldName)
+		q.setError(err)
+		return
+	}
+	terms := fs.fullText.tokenize(text)
+	if len(terms) == 0 {
+		return
+	}
+	q.searches = append(q.searches, search{
+		fieldSpec: fs,
+		text:      text,
+		terms:     terms,
+	})
+}
+
+// FromIDSet restricts the query to models whose ids are in the set, sorted
+// set, or list identified by key. For example, key could be a list created by
+// StoreIDs or a set maintained by another service. If FromIDSet is called more
+// than once, the query will only return models whose ids are in all the given
+// keys. The order of the models is still determined by Order.
+func (q *query) FromIDSet(key string) {
+	q.idSets = append(q.idSets, key)
+}
+
+// idFilter restricts a query to the models whose ids compare to id with op,
+// or, if prefix is true, to the models whose ids start with id.
+type idFilter struct {
+	op     filterOp
+	id     string
+	prefix bool
+}
+
+func (f idFilter) String() string {
+	if f.prefix {
+		return fmt.Sprintf(`FilterIDPrefix("%s")`, f.id)
+	}
+	return fmt.Sprintf(`FilterID("%s", "%s")`, f.op, f.id)
+}
+
+// removeArgs returns the arguments for the ZREMRANGEBYLEX commands which remove
+// the ids that do not match f from the sorted set identified by key, in which
+// every id has the same score.
+func (f idFilter) removeArgs(key string) []redis.Args {
+	if f.prefix {
+		// Ids are base58 encoded, so every id which starts with the prefix is
+		// lexically less than the prefix followed by a 0xff byte.
+		return []redis.Args{
+			{key, "-", "(" + f.id},
+			{key, "[" + f.id + "\xff", "+"},
+		}
+	}
+	switch f.op {
+	case equalOp:
+		return []redis.Args{
+			{key, "-", "(" + f.id},
+			{key, "(" + f.id, "+"},
+		}
+	case lessOp:
+		return []redis.Args{{key, "[" + f.id, "+"}}
+	case lessOrEqualOp:
+		return []redis.Args{{key, "(" + f.id, "+"}}
+	case greaterOp:
+		return []redis.Args{{key, "-", "[" + f.id}}
+	case greaterOrEqualOp:
+		return []redis.Args{{key, "-", "(" + f.id}}
+	}
+	return nil
+}
+
+// FilterID restricts the query to models whose ids compare to id with the
+// given operator, which must be one of =, <, <=, >, or >=. Ids are compared
+// lexically, byte by byte.
+func (q *query) FilterID(operator string, id string) {
+	fOp, found := filterOps[operator]
+	if !found || fOp == notEqualOp {
+		q.setError(fmt.Errorf("zoom: invalid FilterID operator %q (should be one of =, >, <, >=, or <=)", operator))
+		return
+	}
+	q.idFilters = append(q.idFilters, idFilter{op: fOp, id: id})
+}
+
+// FilterIDPrefix restricts the query to models whose ids start with prefix.
+func (q *query) FilterIDPrefix(prefix string) {
+	q.idFilters = append(q.idFilters, idFilter{id: prefix, prefix: true})
+}
+
+// Join connects the query to the target collection via fieldName, which must
+// be a field with a string index that holds the ids of models in target. The
+// joined collection can then be filtered by prefixing field names with the
+// alias for the join, which is fieldName without the "ID" suffix (or fieldName
+// itself if it does not end in "ID"). For example, after Join("AuthorID",
+// Authors), Filter("Author.Country =", "US") restricts the query to models
+// whose author is from the US. Join will set an error on the query if the field
+// does not exist or does not have a string index, if target is nil or not
+// indexed, or if another join already uses the same alias.
+func (q *query) Join(fieldName string, target *Collection) {
+	if target == nil {
+		q.setError(newNilCollectionError("Query.Join"))
+		return
+	}
+	if !target.index {
+		q.setError(fmt.Errorf("zoom: error in Query.Join: Collection %s is not indexed", target.Name()))
+		return
+	}
+	fs, found := q.collection.spec.fieldsByName[fieldName]
+	if !found {
+		err := newKindError(ErrFieldNotFound, "zoom: error in Query.Join: could not find field %s in type %s", fieldName, q.collection.spec.typ.String())
+		q.setError(err)
+		return
+	}
+	if fs.indexKind != stringIndex {
+		err := newKindError(ErrUnindexedField, "zoom: Join is only allowed on fields with a string index and %s.%s does not have one (try adding the `zoom:\"index\"` struct tag)", q.collection.spec.typ.String(), fieldName)
+		q.setError(err)
+		return
+	}
+	if fs.collate != nil {
+		q.setError(fmt.Errorf("zoom: error in Query.Join: %s.%s has a collation, so its index does not contain the ids of the joined models", q.collection.spec.typ.String(), fieldName))
+		return
+	}
+	alias := strings.TrimSuffix(fieldName, "ID")
+	if alias == "" {
+		alias = fieldName
+	}
+	for _, j := range q.joins {
+		if j.alias == alias {
+			q.setError(fmt.Errorf("zoom: error in Query.Join: a join named %s already exists", alias))
+			return
+		}
+	}
+	q.joins = append(q.joins, &join{
+		fieldSpec: fs,
+		target:    target,
+		alias:     alias,
+	})
 }
 
 func splitFilterString(filterString string) (fieldName string, operator string, err error) {
 	tokens := strings.Split(filterString, " ")
+	if len(tokens) == 3 && tokens[1] == "IS" && tokens[2] == "NOT" {
+		return tokens[0], "IS NOT", nil
+	}
 	if len(tokens) != 2 {
 		return "", "", errors.New("zoom: too many spaces in fieldStr argument (should be a field name, a space, and an operator)")
 	}
@@ -335,6 +785,21 @@ func (f filter) checkValType(value interface{}) error {
 	return nil
 }
 
+// checkInValues returns an error if values is not a slice or array of values
+// which each have a type corresponding to filter.fieldSpec.
+func (f filter) checkInValues(values interface{}) error {
+	valuesVal := reflect.ValueOf(values)
+	if values == nil || (valuesVal.Kind() != reflect.Slice && valuesVal.Kind() != reflect.Array) {
+		return fmt.Errorf("zoom: invalid value for Filter on %s: the value for the IN operator must be a slice or array but got %T", f.fieldSpec.name, values)
+	}
+	for i := 0; i < valuesVal.Len(); i++ {
+		if err := f.checkValType(valuesVal.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // generateIDsSet will return the key of a set or sorted set that contains all the ids
 // which match the query criteria. It may also return some temporary keys which were created
 // during the process of creating the set of ids. Note that tmpKeys may contain idsKey itself,
@@ -342,16 +807,19 @@ func (f filter) checkValType(value interface{}) error {
 func generateIDsSet(q *query, tx *Transaction) (idsKey string, tmpKeys []interface{}, err error) {
 	idsKey = q.collection.spec.indexKey()
 	tmpKeys = []interface{}{}
-	if q.hasOrder() {
+	if q.order.isExpr() {
+		idsKey = tx.combineOrderScores(q)
+		tmpKeys = append(tmpKeys, idsKey)
+	} else if q.hasOrder() {
 		fieldIndexKey, err := q.collection.spec.fieldIndexKey(q.order.fieldName)
 		if err != nil {
 			return "", nil, err
 		}
 		fieldSpec := q.collection.spec.fieldsByName[q.order.fieldName]
-		if fieldSpec.indexKind == stringIndex {
-			// If the order is a string field, we need to extract the ids before
+		if fieldSpec.indexKind == stringIndex || fieldSpec.indexKind == integerIndex {
+			// If the order is a string or integer field, we need to extract the ids before
 			// we use ZRANGE. Create a temporary set to store the ordered ids
-			orderedIDsKey := generateRandomKey("tmp:order:" + q.order.fieldName)
+			orderedIDsKey := tx.newTmpKey("tmp:order:" + q.order.fieldName)
 			tmpKeys = append(tmpKeys, orderedIDsKey)
 			idsKey = orderedIDsKey
 			// TODO: as an optimization, if there is a filter on the same field,
@@ -361,10 +829,66 @@ func generateIDsSet(q *query, tx *Transaction) (idsKey string, tmpKeys []interfa
 			idsKey = fieldIndexKey
 		}
 	}
+	if q.hasIDSets() {
+		idSetKey := tx.newTmpKey("tmp:idSet")
+		tmpKeys = append(tmpKeys, idSetKey)
+		for _, key := range q.idSets {
+			tx.intersectIDsWithKey(idsKey, key, idSetKey)
+			idsKey = idSetKey
+		}
+	}
+	if q.hasIDFilters() {
+		// Copy the index of all ids into a temporary sorted set in which every id
+		// has the same score, i.e. the ids are ordered lexically, and remove the
+		// ids which do not match the filters. Then intersect the result with
+		// idsKey, keeping the scores of idsKey only if the query has an order.
+		idFilterKey := tx.newTmpKey("tmp:filter:id")
+		tmpKeys = append(tmpKeys, idFilterKey)
+		tx.Command("ZUNIONSTORE", redis.Args{idFilterKey, 1, q.collection.spec.indexKey(), "WEIGHTS", 0}, nil)
+		for _, f := range q.idFilters {
+			for _, args := range f.removeArgs(idFilterKey) {
+				tx.Command("ZREMRANGEBYLEX", args, nil)
+			}
+		}
+		tx.Command("ZINTERSTORE", redis.Args{idFilterKey, 2, idsKey, idFilterKey, "WEIGHTS", convertBoolToInt(q.hasOrder()), 0}, nil)
+		idsKey = idFilterKey
+	}
+	if q.hasJoins() {
+		joinedIDsKey := tx.newTmpKey("tmp:join:all")
+		tmpKeys = append(tmpKeys, joinedIDsKey)
+		for _, join := range q.joins {
+			if err := intersectJoin(q, tx, join, idsKey, joinedIDsKey); err != nil {
+				return "", tmpKeys, err
+			}
+			idsKey = joinedIDsKey
+		}
+	}
 	if q.hasFilters() {
-		filteredIDsKey := generateRandomKey("tmp:filter:all")
+		filteredIDsKey := tx.newTmpKey("tmp:filter:all")
 		tmpKeys = append(tmpKeys, filteredIDsKey)
-		for i, filter := range q.filters {
+		// Filters which can use a bitmap index are evaluated together first.
+		bitmapFilters := []filter{}
+		otherFilters := []filter{}
+		for _, filter := range q.filters {
+			if isBitmapFilter(filter) {
+				bitmapFilters = append(bitmapFilters, filter)
+			} else {
+				otherFilters = append(otherFilters, filter)
+			}
+		}
+		if len(bitmapFilters) > 0 {
+			// If the bitmap filters are the only criteria, only the ids up to the
+			// limit of the query need to be extracted.
+			maxIDs := 0
+			if q.hasLimit() && len(otherFilters) == 0 && !q.hasSearches() && !q.hasNegations() && !q.hasLast() && !q.hasSample() {
+				maxIDs = int(q.offset + q.limit)
+			}
+			if err := intersectBitmapFilters(q, tx, bitmapFilters, idsKey, filteredIDsKey, maxIDs); err != nil {
+				return "", tmpKeys, err
+			}
+			idsKey = filteredIDsKey
+		}
+		for i, filter := range otherFilters {
 			if i == 0 {
 				// The first time, we should intersect with the ids key from above
 				if err := intersectFilter(q, tx, filter, idsKey, filteredIDsKey); err != nil {
@@ -379,6 +903,36 @@ func generateIDsSet(q *query, tx *Transaction) (idsKey string, tmpKeys []interfa
 		}
 		idsKey = filteredIDsKey
 	}
+	if q.hasSearches() {
+		searchedIDsKey := tx.newTmpKey("tmp:search:all")
+		tmpKeys = append(tmpKeys, searchedIDsKey)
+		for _, search := range q.searches {
+			intersectSearch(q, tx, search, idsKey, searchedIDsKey)
+			idsKey = searchedIDsKey
+		}
+	}
+	if q.hasNegations() {
+		notIDsKey := tx.newTmpKey("tmp:not:all")
+		tmpKeys = append(tmpKeys, notIDsKey)
+		for _, negation := range q.negations {
+			if err := excludeNegation(tx, negation, idsKey, notIDsKey); err != nil {
+				return "", tmpKeys, err
+			}
+			idsKey = notIDsKey
+		}
+	}
+	if q.hasLast() {
+		lastIDsKey := tx.newTmpKey("tmp:last")
+		tmpKeys = append(tmpKeys, lastIDsKey)
+		tx.extractLastIDs(idsKey, lastIDsKey, q.last, q.order.kind == descendingOrder)
+		idsKey = lastIDsKey
+	}
+	if q.hasSample() {
+		sampleIDsKey := tx.newTmpKey("tmp:sample")
+		tmpKeys = append(tmpKeys, sampleIDsKey)
+		tx.sampleIDs(idsKey, sampleIDsKey, q.sample)
+		idsKey = sampleIDsKey
+	}
 	return idsKey, tmpKeys, nil
 }
 
@@ -388,13 +942,21 @@ func generateIDsSet(q *query, tx *Transaction) (idsKey string, tmpKeys []interfa
 // delete any temporary sets created since, in this case, they are guaranteed to not be needed
 // by any other transaction commands.
 func intersectFilter(q *query, tx *Transaction, filter filter, origKey string, destKey string) error {
+	if filter.op == isNullOp || filter.op == isNotNullOp {
+		return intersectNullFilter(q, tx, filter, origKey, destKey)
+	}
+	if filter.op == inOp {
+		return intersectInFilter(q, tx, filter, origKey, destKey)
+	}
 	switch filter.fieldSpec.indexKind {
 	case numericIndex:
 		return intersectNumericFilter(q, tx, filter, origKey, destKey)
 	case booleanIndex:
 		return intersectBoolFilter(q, tx, filter, origKey, destKey)
-	case stringIndex:
+	case stringIndex, integerIndex:
 		return intersectStringFilter(q, tx, filter, origKey, destKey)
+	case enumIndex:
+		return intersectEnumFilter(q, tx, filter, origKey, destKey)
 	}
 	return nil
 }
@@ -410,8 +972,8 @@ func intersectNumericFilter(q *query, tx *Transaction, filter filter, origKey st
 	}
 	if filter.op == notEqualOp {
 		// Special case for not equal. We need to use two separate commands
-		valueExclusive := fmt.Sprintf("(%v", filter.value.Interface())
-		filterKey := generateRandomKey("tmp:filter:" + fieldIndexKey)
+		valueExclusive := fmt.Sprintf("(%v", numericFilterValue(filter.fieldSpec, filter.value))
+		filterKey := tx.newTmpKey("tmp:filter:" + fieldIndexKey)
 		// ZADD all ids greater than filter.value
 		tx.ExtractIDsFromFieldIndex(fieldIndexKey, filterKey, valueExclusive, "+inf")
 		// ZADD all ids less than filter.value
@@ -421,26 +983,9 @@ func intersectNumericFilter(q *query, tx *Transaction, filter filter, origKey st
 		// Delete the temporary key
 		tx.Command("DEL", redis.Args{filterKey}, nil)
 	} else {
-		var min, max interface{}
-		switch filter.op {
-		case equalOp:
-			min, max = filter.value.Interface(), filter.value.Interface()
-		case lessOp:
-			min = "-inf"
-			// use "(" for exclusive
-			max = fmt.Sprintf("(%v", filter.value.Interface())
-		case greaterOp:
-			min = fmt.Sprintf("(%v", filter.value.Interface())
-			max = "+inf"
-		case lessOrEqualOp:
-			min = "-inf"
-			max = filter.value.Interface()
-		case greaterOrEqualOp:
-			min = filter.value.Interface()
-			max = "+inf"
-		}
+		min, max := numericFilterBounds(filter)
 		// Get all the ids that fit the filter criteria and store them in a temporary key caled filterKey
-		filterKey := generateRandomKey("tmp:filter:" + fieldIndexKey)
+		filterKey := tx.newTmpKey("tmp:filter:" + fieldIndexKey)
 		tx.ExtractIDsFromFieldIndex(fieldIndexKey, filterKey, min, max)
 		// Intersect filterKey with origKey and store result in destKey
 		tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, filterKey, "WEIGHTS", 1, 0}, nil)
@@ -450,6 +995,108 @@ func intersectNumericFilter(q *query, tx *Transaction, filter filter, origKey st
 	return nil
 }
 
+// numericFilterBounds returns the min and max arguments for ZRANGEBYSCORE which
+// select the models matching the given numeric filter. It should not be called
+// for filters with the != operator, which match two separate ranges.
+func numericFilterBounds(filter filter) (min interface{}, max interface{}) {
+	value := numericFilterValue(filter.fieldSpec, filter.value)
+	switch filter.op {
+	case equalOp:
+		min, max = value, value
+	case rangeOp:
+		min, max = value, numericFilterValue(filter.fieldSpec, filter.max)
+		if !filter.inclusive {
+			min = fmt.Sprintf("(%v", min)
+			max = fmt.Sprintf("(%v", max)
+		}
+	case lessOp:
+		min = "-inf"
+		// use "(" for exclusive
+		max = fmt.Sprintf("(%v", value)
+	case greaterOp:
+		min = fmt.Sprintf("(%v", value)
+		max = "+inf"
+	case lessOrEqualOp:
+		min = "-inf"
+		max = value
+	case greaterOrEqualOp:
+		min = value
+		max = "+inf"
+	}
+	return min, max
+}
+
+// numericFilterValue returns the value of a numeric filter on the field
+// described by fs which is compared with the scores in the field index. For
+// fields of types which implement Scorer, it is the score of val. Otherwise it
+// is val itself.
+func numericFilterValue(fs *fieldSpec, val reflect.Value) interface{} {
+	if fs.kind == inconvertibleField {
+		return numericScore(val)
+	}
+	return val.Interface()
+}
+
+// intersectInFilter adds commands to the query transaction which, when run, will
+// intersect origKey with the ids of models for which the field of the given IN
+// filter is equal to any of the values, and store the result in destKey. It
+// creates a temporary set for each value, which is the intersection of origKey
+// with the ids of models with that value, and then stores the union of those
+// sets in destKey. The scores from origKey are preserved.
+func intersectInFilter(q *query, tx *Transaction, filter filter, origKey string, destKey string) error {
+	if filter.value.Len() == 0 {
+		// No model can match an empty list of values.
+		tx.Command("DEL", redis.Args{destKey}, nil)
+		return nil
+	}
+	valueKeys := redis.Args{}
+	for i := 0; i < filter.value.Len(); i++ {
+		valueKey := tx.newTmpKey("tmp:filter:in")
+		valueKeys = valueKeys.Add(valueKey)
+		equalFilter := filter
+		equalFilter.op = equalOp
+		equalFilter.value = reflect.ValueOf(filter.value.Index(i).Interface())
+		if err := intersectFilter(q, tx, equalFilter, origKey, valueKey); err != nil {
+			return err
+		}
+	}
+	// Use MAX as the aggregate function so that the scores from origKey are
+	// preserved even if the same value appears more than once.
+	args := redis.Args{destKey, len(valueKeys)}
+	args = append(args, valueKeys...)
+	tx.Command("ZUNIONSTORE", args.Add("AGGREGATE", "MAX"), nil)
+	tx.Command("DEL", valueKeys, nil)
+	return nil
+}
+
+// intersectNullFilter adds commands to the query transaction which, when run, will
+// intersect origKey with the ids of models for which the field of the given IS or
+// IS NOT filter is nil (or not nil) and store the result in destKey. The ids of
+// models with a nil value are stored in the null index for the field, and the ids
+// of models with a non-nil value are the ones in the regular field index.
+func intersectNullFilter(q *query, tx *Transaction, filter filter, origKey string, destKey string) error {
+	spec := filter.fieldSpec
+	if filter.op == isNullOp {
+		tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, q.collection.spec.nullIndexKey(spec), "WEIGHTS", 1, 0}, nil)
+		return nil
+	}
+	fieldIndexKey, err := q.collection.spec.fieldIndexKey(spec.name)
+	if err != nil {
+		return err
+	}
+	if spec.indexKind == stringIndex || spec.indexKind == integerIndex {
+		// The members of string and integer indexes include the value, so we
+		// need to extract the ids first.
+		filterKey := tx.newTmpKey("tmp:filter:" + fieldIndexKey)
+		tx.ExtractIDsFromStringIndex(fieldIndexKey, filterKey, "-", "+")
+		tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, filterKey, "WEIGHTS", 1, 0}, nil)
+		tx.Command("DEL", redis.Args{filterKey}, nil)
+		return nil
+	}
+	tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, fieldIndexKey, "WEIGHTS", 1, 0}, nil)
+	return nil
+}
+
 // intersectBoolFilter adds commands to the query transaction which, when run, will
 // create a temporary set which contains all the ids of models which match the given
 // bool filter criteria, then intersect those ids with origKey and store the result
@@ -459,7 +1106,20 @@ func intersectBoolFilter(q *query, tx *Transaction, filter filter, origKey strin
 	if err != nil {
 		return err
 	}
-	var min, max interface{}
+	min, max := boolFilterBounds(filter)
+	// Get all the ids that fit the filter criteria and store them in a temporary key caled filterKey
+	filterKey := tx.newTmpKey("tmp:filter:" + fieldIndexKey)
+	tx.ExtractIDsFromFieldIndex(fieldIndexKey, filterKey, min, max)
+	// Intersect filterKey with origKey and store result in destKey
+	tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, filterKey, "WEIGHTS", 1, 0}, nil)
+	// Delete the temporary key
+	tx.Command("DEL", redis.Args{filterKey}, nil)
+	return nil
+}
+
+// boolFilterBounds returns the min and max arguments for ZRANGEBYSCORE which
+// select the models matching the given boolean filter.
+func boolFilterBounds(filter filter) (min interface{}, max interface{}) {
 	switch filter.op {
 	case equalOp:
 		if filter.value.Bool() {
@@ -507,32 +1167,33 @@ func intersectBoolFilter(q *query, tx *Transaction, filter filter, origKey strin
 		} else {
 			min, max = 1, 1
 		}
+	case rangeOp:
+		minScore, maxScore := boolScore(filter.value), boolScore(filter.max)
+		if !filter.inclusive {
+			// Scores are always integers, so an exclusive range is the same as
+			// an inclusive range which is narrower by one on each side
+			minScore, maxScore = minScore+1, maxScore-1
+		}
+		min, max = minScore, maxScore
 	}
-	// Get all the ids that fit the filter criteria and store them in a temporary key caled filterKey
-	filterKey := generateRandomKey("tmp:filter:" + fieldIndexKey)
-	tx.ExtractIDsFromFieldIndex(fieldIndexKey, filterKey, min, max)
-	// Intersect filterKey with origKey and store result in destKey
-	tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, filterKey, "WEIGHTS", 1, 0}, nil)
-	// Delete the temporary key
-	tx.Command("DEL", redis.Args{filterKey}, nil)
-	return nil
+	return min, max
 }
 
 // intersectStringFilter adds commands to the query transaction which, when run, will
 // create a temporary set which contains all the ids of models which match the given
-// string filter criteria, then intersect those ids with origKey and store the result
-// in destKey.
+// string (or integer) filter criteria, then intersect those ids with origKey and store
+// the result in destKey.
 func intersectStringFilter(q *query, tx *Transaction, filter filter, origKey string, destKey string) error {
 	fieldIndexKey, err := q.collection.spec.fieldIndexKey(filter.fieldSpec.name)
 	if err != nil {
 		return err
 	}
-	valString := filter.value.String()
+	valString := stringIndexValue(filter.fieldSpec, filter.value)
 	if filter.op == notEqualOp {
 		// Special case for not equal. We need to use two separate commands
-		filterKey := generateRandomKey("tmp:filter:" + fieldIndexKey)
+		filterKey := tx.newTmpKey("tmp:filter:" + fieldIndexKey)
 		// ZADD all ids greater than filter.value
-		min := "(" + valString + nullString + delString
+		min := "(" + valString + nullString + maxByteString
 		tx.ExtractIDsFromStringIndex(fieldIndexKey, filterKey, min, "+")
 		// ZADD all ids less than filter.value
 		max := "(" + valString
@@ -542,26 +1203,9 @@ func intersectStringFilter(q *query, tx *Transaction, filter filter, origKey str
 		// Delete the temporary key
 		tx.Command("DEL", redis.Args{filterKey}, nil)
 	} else {
-		var min, max string
-		switch filter.op {
-		case equalOp:
-			min = "[" + valString
-			max = "(" + valString + nullString + delString
-		case lessOp:
-			min = "-"
-			max = "(" + valString
-		case greaterOp:
-			min = "(" + valString + nullString + delString
-			max = "+"
-		case lessOrEqualOp:
-			min = "-"
-			max = "(" + valString + nullString + delString
-		case greaterOrEqualOp:
-			min = "[" + valString
-			max = "+"
-		}
+		min, max := stringFilterBounds(filter)
 		// Get all the ids that fit the filter criteria and store them in a temporary key caled filterKey
-		filterKey := generateRandomKey("tmp:filter:" + fieldIndexKey)
+		filterKey := tx.newTmpKey("tmp:filter:" + fieldIndexKey)
 		tx.ExtractIDsFromStringIndex(fieldIndexKey, filterKey, min, max)
 		// Intersect filterKey with origKey and store result in destKey
 		tx.Command("ZINTERSTORE", redis.Args{destKey, 2, origKey, filterKey, "WEIGHTS", 1, 0}, nil)
@@ -571,22 +1215,137 @@ func intersectStringFilter(q *query, tx *Transaction, filter filter, origKey str
 	return nil
 }
 
+// stringFilterBounds returns the min and max arguments for ZRANGEBYLEX which
+// select the models matching the given string (or integer) filter. It should
+// not be called for filters with the != operator, which match two separate
+// ranges.
+func stringFilterBounds(filter filter) (min string, max string) {
+	valString := stringIndexValue(filter.fieldSpec, filter.value)
+	switch filter.op {
+	case equalOp:
+		min = "[" + valString
+		max = "(" + valString + nullString + maxByteString
+	case rangeOp:
+		maxString := stringIndexValue(filter.fieldSpec, filter.max)
+		if filter.inclusive {
+			min = "[" + valString
+			max = "(" + maxString + nullString + maxByteString
+		} else {
+			min = "(" + valString + nullString + maxByteString
+			max = "(" + maxString
+		}
+	case lessOp:
+		min = "-"
+		max = "(" + valString
+	case greaterOp:
+		min = "(" + valString + nullString + maxByteString
+		max = "+"
+	case lessOrEqualOp:
+		min = "-"
+		max = "(" + valString + nullString + maxByteString
+	case greaterOrEqualOp:
+		min = "[" + valString
+		max = "+"
+	}
+	return min, max
+}
+
+// stringIndexValue returns the string which represents val in the string (or
+// integer) index for the given field. For fields with a collation, this is the
+// collation key of the value.
+func stringIndexValue(fs *fieldSpec, val reflect.Value) string {
+	if fs.indexKind == integerIndex {
+		return integerIndexValue(val)
+	}
+	for val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if fs.collate != nil {
+		return fs.collationKey(val.String())
+	}
+	return encodeStringIndexValue(val.String())
+}
+
+// intersectJoin adds commands to the query transaction which, when run, will
+// find the ids of the models in the joined collection which match the filters
+// for the join, then find the ids of the models which reference them via the
+// join field, intersect those ids with origKey and store the result in
+// destKey. All of this happens server-side.
+func intersectJoin(q *query, tx *Transaction, join *join, origKey string, destKey string) error {
+	fieldIndexKey, err := q.collection.spec.fieldIndexKey(join.fieldSpec.name)
+	if err != nil {
+		return err
+	}
+	targetQuery := newQuery(join.target)
+	targetQuery.filters = join.filters
+	targetIDsKey, targetTmpKeys, err := generateIDsSet(targetQuery, tx)
+	if err != nil {
+		return err
+	}
+	tx.joinIDs(origKey, fieldIndexKey, targetIDsKey, destKey)
+	if len(targetTmpKeys) > 0 {
+		tx.Command("DEL", (redis.Args{}).Add(targetTmpKeys...), nil)
+	}
+	return nil
+}
+
+// intersectSearch adds a command to the query transaction which, when run, will
+// intersect the sets of ids for each term in the given search with origKey and
+// store the result in destKey. The scores from origKey are preserved, so the
+// order of the ids is not affected.
+func intersectSearch(q *query, tx *Transaction, search search, origKey string, destKey string) {
+	args := redis.Args{destKey, len(search.terms) + 1, origKey}
+	for _, term := range search.terms {
+		args = args.Add(q.collection.spec.fullTextTermKey(search.fieldSpec, term))
+	}
+	args = args.Add("WEIGHTS", 1)
+	for range search.terms {
+		args = args.Add(0)
+	}
+	tx.Command("ZINTERSTORE", args, nil)
+}
+
 // fieldNames parses the includes and excludes properties to return a list of
 // field names which should be included in all find operations. If there are no
-// includes or excludes, it returns all the field names.
+// includes, it returns all the field names except the lazy fields and any
+// excludes. Fields which are stored in their own key are never included (see
+// keyFields).
 func (q *query) fieldNames() []string {
 	switch {
 	case q.hasIncludes():
-		return q.includes
+		results := []string{}
+		for _, name := range q.includes {
+			if _, found := q.collection.spec.keyFieldByName(name); !found {
+				results = append(results, name)
+			}
+		}
+		return results
 	case q.hasExcludes():
-		results := q.collection.spec.fieldNames()
+		results := q.collection.spec.defaultFieldNames()
 		for _, name := range q.excludes {
 			results = removeElementFromStringSlice(results, name)
 		}
 		return results
 	default:
-		return q.collection.spec.fieldNames()
+		return q.collection.spec.defaultFieldNames()
+	}
+}
+
+// keyFields returns the fields which are stored in their own key and should
+// be included in all find operations, i.e. those in the includes if there are
+// any, or else all of them except the excludes.
+func (q *query) keyFields() []*fieldSpec {
+	results := []*fieldSpec{}
+	for _, fs := range q.collection.spec.keyFields {
+		if q.hasIncludes() {
+			if stringSliceContains(q.includes, fs.name) {
+				results = append(results, fs)
+			}
+		} else if !stringSliceContains(q.excludes, fs.name) {
+			results = append(results, fs)
+		}
 	}
+	return results
 }
 
 // redisFieldNames parses the includes and excludes properties to return a list of
@@ -612,12 +1371,43 @@ func (q *query) getStartStop() (start int, stop int) {
 	return start, stop
 }
 
+// limitCount returns the number of models the query would return if count
+// models matched the query criteria, taking into account the limit and offset.
+func (q *query) limitCount(count int) int {
+	if q.hasOffset() {
+		count = count - int(q.offset)
+		if count < 0 {
+			count = 0
+		}
+	}
+	if q.hasLimit() && int(q.limit) < count {
+		count = int(q.limit)
+	}
+	return count
+}
+
 func (q *query) hasFilters() bool {
 	return len(q.filters) > 0
 }
 
+func (q *query) hasSearches() bool {
+	return len(q.searches) > 0
+}
+
+func (q *query) hasIDSets() bool {
+	return len(q.idSets) > 0
+}
+
+func (q *query) hasIDFilters() bool {
+	return len(q.idFilters) > 0
+}
+
+func (q *query) hasJoins() bool {
+	return len(q.joins) > 0
+}
+
 func (q *query) hasOrder() bool {
-	return q.order.fieldName != ""
+	return q.order.fieldName != "" || q.order.isExpr()
 }
 
 func (q *query) hasLimit() bool {
@@ -628,6 +1418,10 @@ func (q *query) hasOffset() bool {
 	return q.offset != 0
 }
 
+func (q *query) hasLast() bool {
+	return q.last != 0
+}
+
 func (q *query) hasIncludes() bool {
 	return len(q.includes) > 0
 }
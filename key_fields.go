@@ -0,0 +1,471 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File key_fields.go contains code related to fields which are stored in their
+// own key (e.g. maps stored in their own hash or slices stored in their own list
+// or set) instead of in the main hash for a model.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// saveKeyFields adds commands to the transaction for saving all the fields of
+// the model which are stored in their own key.
+func (t *Transaction) saveKeyFields(mr *modelRef) {
+	for _, fs := range mr.spec.keyFields {
+		t.saveKeyField(mr, fs)
+	}
+}
+
+// saveKeyFieldsForFields works like saveKeyFields, but only saves the fields
+// whose names are in fieldNames.
+func (t *Transaction) saveKeyFieldsForFields(fieldNames []string, mr *modelRef) {
+	for _, fs := range mr.spec.keyFields {
+		if stringSliceContains(fieldNames, fs.name) {
+			t.saveKeyField(mr, fs)
+		}
+	}
+}
+
+// saveKeyField adds commands to the transaction for saving the field described
+// by fs in its own key. Any existing value is overwritten.
+func (t *Transaction) saveKeyField(mr *modelRef, fs *fieldSpec) {
+	key := mr.spec.fieldKey(mr.model.ModelID(), fs)
+	t.modelCommand(mr.model.ModelID(), "DEL", redis.Args{key}, nil)
+	fieldVal := mr.fieldValue(fs.name)
+	switch fs.kind {
+	case hashField:
+		if fieldVal.Len() == 0 {
+			return
+		}
+		args := redis.Args{key}
+		for _, mapKey := range fieldVal.MapKeys() {
+			value, err := mr.spec.hashValue(fs.elem, fieldVal.MapIndex(mapKey))
+			if err != nil {
+				t.setError(err)
+				return
+			}
+			args = args.Add(mapKey.String(), value)
+		}
+		t.modelCommand(mr.model.ModelID(), "HMSET", args, nil)
+	case listField, setField:
+		if fieldVal.Len() == 0 {
+			return
+		}
+		args, err := mr.spec.keyFieldElemArgs(fs, key, fieldVal)
+		if err != nil {
+			t.setError(err)
+			return
+		}
+		if fs.kind == listField {
+			t.modelCommand(mr.model.ModelID(), "RPUSH", args, nil)
+		} else {
+			t.modelCommand(mr.model.ModelID(), "SADD", args, nil)
+		}
+	}
+}
+
+// keyFieldElemArgs returns args consisting of key followed by the values that
+// should be stored in Redis for each element of slice, which must be the value
+// of the list or set field described by fs.
+func (ms *modelSpec) keyFieldElemArgs(fs *fieldSpec, key string, slice reflect.Value) (redis.Args, error) {
+	args := redis.Args{key}
+	for i := 0; i < slice.Len(); i++ {
+		value, err := ms.hashValue(fs.elem, slice.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		args = args.Add(value)
+	}
+	return args, nil
+}
+
+// findKeyFields adds commands to the transaction for finding all the fields of
+// the model which are stored in their own key.
+func (t *Transaction) findKeyFields(mr *modelRef) {
+	for _, fs := range mr.spec.keyFields {
+		t.findKeyField(mr, fs)
+	}
+}
+
+// findKeyFieldsForFields works like findKeyFields, but only finds the fields
+// whose names are in fieldNames.
+func (t *Transaction) findKeyFieldsForFields(fieldNames []string, mr *modelRef) {
+	for _, fs := range mr.spec.keyFields {
+		if stringSliceContains(fieldNames, fs.name) {
+			t.findKeyField(mr, fs)
+		}
+	}
+}
+
+// findKeyField adds a command to the transaction for finding the field
+// described by fs and scanning its value into the model.
+func (t *Transaction) findKeyField(mr *modelRef, fs *fieldSpec) {
+	key := mr.spec.fieldKey(mr.model.ModelID(), fs)
+	switch fs.kind {
+	case hashField:
+		t.modelCommand(mr.model.ModelID(), "HGETALL", redis.Args{key}, newScanHashFieldHandler(mr, fs))
+	case listField:
+		t.modelCommand(mr.model.ModelID(), "LRANGE", redis.Args{key, 0, -1}, newScanSliceFieldHandler(mr, fs))
+	case setField:
+		t.modelCommand(mr.model.ModelID(), "SMEMBERS", redis.Args{key}, newScanSliceFieldHandler(mr, fs))
+	}
+}
+
+// deleteKeyFields adds commands to the transaction for deleting all the fields
+// of the model with the given id which are stored in their own key.
+func (t *Transaction) deleteKeyFields(c *Collection, id string) {
+	for _, fs := range c.spec.keyFields {
+		t.modelCommand(id, "DEL", redis.Args{c.spec.fieldKey(id, fs)}, nil)
+	}
+}
+
+// keyFieldForMethod returns the spec for the field of the collection with the
+// given name. It returns an error if there is no such field or if it is not of
+// the given kind. methodName is used in the error message.
+func (c *Collection) keyFieldForMethod(methodName string, fieldName string, kind fieldKind) (*fieldSpec, error) {
+	fs, found := c.spec.keyFieldByName(fieldName)
+	if !found || fs.kind != kind {
+		return nil, newKindError(ErrFieldNotFound, "zoom: Error in %s: Collection %s does not have a field named %s with the appropriate struct tag", methodName, c.Name(), fieldName)
+	}
+	return fs, nil
+}
+
+// SetMapField sets the value for a single key of a map field which is stored
+// in its own hash (i.e. a field with the `zoom:"hash"` struct tag) for the
+// model with the given id. It does not affect any other keys in the map or any
+// other fields of the model. value must be the same type as the elements of
+// the map.
+func (c *Collection) SetMapField(id string, fieldName string, key string, value interface{}) error {
+	t := c.pool.NewTransaction()
+	t.SetMapField(c, id, fieldName, key, value)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetMapField sets the value for a single key of a map field which is stored
+// in its own hash (i.e. a field with the `zoom:"hash"` struct tag) for the
+// model with the given id inside an existing transaction. value must be the
+// same type as the elements of the map. Any errors encountered will be added
+// to the transaction and returned as an error when the transaction is
+// executed.
+func (t *Transaction) SetMapField(c *Collection, id string, fieldName string, key string, value interface{}) {
+	if c == nil {
+		t.setError(newNilCollectionError("SetMapField"))
+		return
+	}
+	fs, err := c.keyFieldForMethod("SetMapField", fieldName, hashField)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	elemVal, err := fieldValueOf(fs.elem, value)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	hashValue, err := c.spec.hashValue(fs.elem, elemVal)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	t.modelCommand(id, "HSET", redis.Args{c.spec.fieldKey(id, fs), key, hashValue}, nil)
+}
+
+// DeleteMapField deletes a single key of a map field which is stored in its
+// own hash (i.e. a field with the `zoom:"hash"` struct tag) for the model with
+// the given id. It does not affect any other keys in the map or any other
+// fields of the model.
+func (c *Collection) DeleteMapField(id string, fieldName string, key string) error {
+	t := c.pool.NewTransaction()
+	t.DeleteMapField(c, id, fieldName, key)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteMapField deletes a single key of a map field which is stored in its
+// own hash (i.e. a field with the `zoom:"hash"` struct tag) for the model with
+// the given id inside an existing transaction. Any errors encountered will be
+// added to the transaction and returned as an error when the transaction is
+// executed.
+func (t *Transaction) DeleteMapField(c *Collection, id string, fieldName string, key string) {
+	if c == nil {
+		t.setError(newNilCollectionError("DeleteMapField"))
+		return
+	}
+	fs, err := c.keyFieldForMethod("DeleteMapField", fieldName, hashField)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	t.modelCommand(id, "HDEL", redis.Args{c.spec.fieldKey(id, fs), key}, nil)
+}
+
+// keyFieldValuesArgs returns args consisting of the key for the list or set
+// field with the given name followed by the values that should be stored in
+// Redis for each of the given values. It returns an error if the collection does
+// not have a field of the given kind with the given name or if any of the values
+// are the wrong type. methodName is used in error messages.
+func (c *Collection) keyFieldValuesArgs(methodName string, id string, fieldName string, kind fieldKind, values []interface{}) (redis.Args, error) {
+	fs, err := c.keyFieldForMethod(methodName, fieldName, kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("zoom: Error in %s: at least one value is required", methodName)
+	}
+	slice := reflect.MakeSlice(fs.typ, 0, len(values))
+	for _, value := range values {
+		elemVal, err := fieldValueOf(fs.elem, value)
+		if err != nil {
+			return nil, err
+		}
+		slice = reflect.Append(slice, elemVal)
+	}
+	return c.spec.keyFieldElemArgs(fs, c.spec.fieldKey(id, fs), slice)
+}
+
+// ListPush appends one or more values to the end of a slice field which is
+// stored in its own list (i.e. a field with the `zoom:"list"` struct tag) for
+// the model with the given id. It does not affect any other fields of the
+// model. Each value must be the same type as the elements of the slice.
+func (c *Collection) ListPush(id string, fieldName string, values ...interface{}) error {
+	t := c.pool.NewTransaction()
+	t.ListPush(c, id, fieldName, values...)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListPush appends one or more values to the end of a slice field which is
+// stored in its own list (i.e. a field with the `zoom:"list"` struct tag) for
+// the model with the given id inside an existing transaction. Any errors
+// encountered will be added to the transaction and returned as an error when
+// the transaction is executed.
+func (t *Transaction) ListPush(c *Collection, id string, fieldName string, values ...interface{}) {
+	t.keyFieldValuesCommand(c, "ListPush", "RPUSH", id, fieldName, listField, values)
+}
+
+// ListRemove removes all occurrences of one or more values from a slice field
+// which is stored in its own list (i.e. a field with the `zoom:"list"` struct
+// tag) for the model with the given id. Each value must be the same type as the
+// elements of the slice.
+func (c *Collection) ListRemove(id string, fieldName string, values ...interface{}) error {
+	t := c.pool.NewTransaction()
+	t.ListRemove(c, id, fieldName, values...)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListRemove removes all occurrences of one or more values from a slice field
+// which is stored in its own list (i.e. a field with the `zoom:"list"` struct
+// tag) for the model with the given id inside an existing transaction. Any
+// errors encountered will be added to the transaction and returned as an error
+// when the transaction is executed.
+func (t *Transaction) ListRemove(c *Collection, id string, fieldName string, values ...interface{}) {
+	if c == nil {
+		t.setError(newNilCollectionError("ListRemove"))
+		return
+	}
+	args, err := c.keyFieldValuesArgs("ListRemove", id, fieldName, listField, values)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	for _, value := range args[1:] {
+		t.modelCommand(id, "LREM", redis.Args{args[0], 0, value}, nil)
+	}
+}
+
+// SetAdd adds one or more values to a slice field which is stored in its own
+// set (i.e. a field with the `zoom:"set"` struct tag) for the model with the
+// given id. It does not affect any other fields of the model. Each value must
+// be the same type as the elements of the slice.
+func (c *Collection) SetAdd(id string, fieldName string, values ...interface{}) error {
+	t := c.pool.NewTransaction()
+	t.SetAdd(c, id, fieldName, values...)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetAdd adds one or more values to a slice field which is stored in its own
+// set (i.e. a field with the `zoom:"set"` struct tag) for the model with the
+// given id inside an existing transaction. Any errors encountered will be added
+// to the transaction and returned as an error when the transaction is executed.
+func (t *Transaction) SetAdd(c *Collection, id string, fieldName string, values ...interface{}) {
+	t.keyFieldValuesCommand(c, "SetAdd", "SADD", id, fieldName, setField, values)
+}
+
+// SetRemove removes one or more values from a slice field which is stored in
+// its own set (i.e. a field with the `zoom:"set"` struct tag) for the model
+// with the given id. Each value must be the same type as the elements of the
+// slice.
+func (c *Collection) SetRemove(id string, fieldName string, values ...interface{}) error {
+	t := c.pool.NewTransaction()
+	t.SetRemove(c, id, fieldName, values...)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetRemove removes one or more values from a slice field which is stored in
+// its own set (i.e. a field with the `zoom:"set"` struct tag) for the model
+// with the given id inside an existing transaction. Any errors encountered will
+// be added to the transaction and returned as an error when the transaction is
+// executed.
+func (t *Transaction) SetRemove(c *Collection, id string, fieldName string, values ...interface{}) {
+	t.keyFieldValuesCommand(c, "SetRemove", "SREM", id, fieldName, setField, values)
+}
+
+// keyFieldValuesCommand adds a command to the transaction with the given name
+// and args created by Collection.keyFieldValuesArgs.
+func (t *Transaction) keyFieldValuesCommand(c *Collection, methodName string, commandName string, id string, fieldName string, kind fieldKind, values []interface{}) {
+	if c == nil {
+		t.setError(newNilCollectionError(methodName))
+		return
+	}
+	args, err := c.keyFieldValuesArgs(methodName, id, fieldName, kind, values)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	t.modelCommand(id, commandName, args, nil)
+}
+
+// scanKeyFieldElem converts src to the type of the elements of the field
+// described by fs and returns the result.
+func scanKeyFieldElem(spec *modelSpec, fs *fieldSpec, src []byte) (reflect.Value, error) {
+	elemVal := reflect.New(fs.elem.typ).Elem()
+	switch fs.elem.kind {
+	case primativeField:
+		if err := scanPrimitiveVal(src, elemVal); err != nil {
+			return reflect.Value{}, err
+		}
+	case pointerField:
+		if err := scanPointerVal(src, elemVal); err != nil {
+			return reflect.Value{}, err
+		}
+	default:
+		if err := scanInconvertibleVal(spec.fallback, src, elemVal); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return elemVal, nil
+}
+
+// sortModelsWithKeyFields is like sortModels, but also reads the given fields
+// which are stored in their own key with the sort_models script, in the same
+// command, so the values of the fields belong to the same models as the rest
+// of the reply. After handler has been called, the values are scanned into the
+// models, where model(i) should return the model that handler scanned the i-th
+// model of the reply into, or nil if there is none.
+func (t *Transaction) sortModelsWithKeyFields(spec *modelSpec, sortArgs redis.Args, redisNames []string, keyFields []*fieldSpec, model func(i int) Model, handler ReplyHandler) {
+	getDocuments := spec.json && len(redisNames) > 0
+	if !getDocuments && len(keyFields) == 0 {
+		t.Command("SORT", sortArgs, spec.sortHandler(redisNames, handler))
+		return
+	}
+	// The number of values the SORT command gets for each model (see sortArgs),
+	// which always includes the id.
+	numValues := 1
+	switch {
+	case spec.json:
+	case spec.document && len(redisNames) > 0:
+		numValues++
+	default:
+		numValues += len(redisNames)
+	}
+	documents := ""
+	if getDocuments {
+		documents = "json"
+	}
+	args := redis.Args{spec.name, numValues, documents, len(keyFields)}
+	for _, fs := range keyFields {
+		switch fs.kind {
+		case hashField:
+			args = args.Add(fs.redisName, "hash")
+		case listField:
+			args = args.Add(fs.redisName, "list")
+		case setField:
+			args = args.Add(fs.redisName, "set")
+		}
+	}
+	args = append(args, sortArgs...)
+	handler = spec.sortHandler(redisNames, handler)
+	if len(keyFields) > 0 {
+		if getDocuments {
+			numValues++
+		}
+		handler = newSortKeyFieldsHandler(spec, keyFields, numValues, model, handler)
+	}
+	t.Script(sortModelsScript, args, handler)
+}
+
+// newSortKeyFieldsHandler returns a ReplyHandler which splits the reply from
+// the sort_models script, which has numValues values for each model followed by
+// the value of each of keyFields. It passes the first numValues values for each
+// model to handler, and then scans the values of keyFields into the models
+// returned by model (see sortModelsWithKeyFields).
+func newSortKeyFieldsHandler(spec *modelSpec, keyFields []*fieldSpec, numValues int, model func(i int) Model, handler ReplyHandler) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+		groupSize := numValues + len(keyFields)
+		numModels := len(values) / groupSize
+		sortValues := make([]interface{}, 0, numModels*numValues)
+		for i := 0; i < numModels; i++ {
+			sortValues = append(sortValues, values[i*groupSize:i*groupSize+numValues]...)
+		}
+		if err := handler(sortValues); err != nil {
+			return err
+		}
+		for i := 0; i < numModels; i++ {
+			m := model(i)
+			if m == nil {
+				continue
+			}
+			mr := &modelRef{spec: spec, model: m}
+			for j, fs := range keyFields {
+				scanHandler := newScanSliceFieldHandler(mr, fs)
+				if fs.kind == hashField {
+					scanHandler = newScanHashFieldHandler(mr, fs)
+				}
+				if err := scanHandler(values[i*groupSize+numValues+j]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// modelsIndexer returns a function which returns the i-th model in models,
+// which must be a pointer to a slice of models, or nil if there is none. It
+// can be passed to sortModelsWithKeyFields.
+func modelsIndexer(models interface{}) func(i int) Model {
+	return func(i int) Model {
+		modelsVal := reflect.ValueOf(models).Elem()
+		if i >= modelsVal.Len() || modelsVal.Index(i).IsNil() {
+			return nil
+		}
+		return modelsVal.Index(i).Interface().(Model)
+	}
+}
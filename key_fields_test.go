@@ -0,0 +1,305 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File key_fields_test.go tests the code in key_fields.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashFieldSaveAndFind(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := &hashFieldModel{
+		Name:     "foo",
+		Settings: map[string]string{"theme": "dark", "lang": "en"},
+		Scores:   map[string]int{"a": 1, "b": 2},
+	}
+	require.NoError(t, hashFieldModels.Save(model))
+
+	// Check the database directly
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	settings, err := redis.StringMap(conn.Do("HGETALL", hashFieldModels.ModelKey(model.ID)+":Settings"))
+	require.NoError(t, err)
+	assert.Equal(t, model.Settings, settings)
+	scores, err := redis.IntMap(conn.Do("HGETALL", hashFieldModels.ModelKey(model.ID)+":scores"))
+	require.NoError(t, err)
+	assert.Equal(t, model.Scores, scores)
+	mainFields, err := redis.StringMap(conn.Do("HGETALL", hashFieldModels.ModelKey(model.ID)))
+	require.NoError(t, err)
+	assert.NotContains(t, mainFields, "Settings")
+
+	// Find the model and check the map fields
+	got := &hashFieldModel{}
+	require.NoError(t, hashFieldModels.Find(model.ID, got))
+	assert.Equal(t, model, got)
+
+	// FindFields should only load the given map field
+	got = &hashFieldModel{}
+	require.NoError(t, hashFieldModels.FindFields(model.ID, []string{"Scores"}, got))
+	assert.Equal(t, model.Scores, got.Scores)
+	assert.Nil(t, got.Settings)
+	assert.Equal(t, "", got.Name)
+
+	// Saving again should overwrite the old values
+	model.Settings = map[string]string{"theme": "light"}
+	model.Scores = nil
+	require.NoError(t, hashFieldModels.SaveFields([]string{"Settings", "Scores"}, model))
+	got = &hashFieldModel{}
+	require.NoError(t, hashFieldModels.Find(model.ID, got))
+	assert.Equal(t, model, got)
+}
+
+func TestSetMapField(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := &hashFieldModel{
+		Name:     "foo",
+		Settings: map[string]string{"theme": "dark"},
+	}
+	require.NoError(t, hashFieldModels.Save(model))
+	require.NoError(t, hashFieldModels.SetMapField(model.ID, "Settings", "lang", "en"))
+	require.NoError(t, hashFieldModels.SetMapField(model.ID, "Scores", "a", 42))
+	got := &hashFieldModel{}
+	require.NoError(t, hashFieldModels.Find(model.ID, got))
+	assert.Equal(t, map[string]string{"theme": "dark", "lang": "en"}, got.Settings)
+	assert.Equal(t, map[string]int{"a": 42}, got.Scores)
+
+	require.NoError(t, hashFieldModels.DeleteMapField(model.ID, "Settings", "theme"))
+	got = &hashFieldModel{}
+	require.NoError(t, hashFieldModels.Find(model.ID, got))
+	assert.Equal(t, map[string]string{"lang": "en"}, got.Settings)
+
+	// Invalid field names and values should return an error
+	assert.Error(t, hashFieldModels.SetMapField(model.ID, "Name", "a", "b"))
+	assert.Error(t, hashFieldModels.SetMapField(model.ID, "Scores", "a", "not an int"))
+	assert.Error(t, hashFieldModels.DeleteMapField(model.ID, "Invalid", "a"))
+}
+
+func TestHashFieldDelete(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*hashFieldModel{}
+	for _, name := range []string{"a", "b", "c"} {
+		model := &hashFieldModel{
+			Name:     name,
+			Settings: map[string]string{"name": name},
+		}
+		require.NoError(t, hashFieldModels.Save(model))
+		models = append(models, model)
+	}
+
+	// Collection.Delete
+	_, err := hashFieldModels.Delete(models[0].ID)
+	require.NoError(t, err)
+	expectKeyDoesNotExist(t, hashFieldModels.ModelKey(models[0].ID)+":Settings")
+	expectKeyExists(t, hashFieldModels.ModelKey(models[1].ID)+":Settings")
+
+	// Query.Delete
+	_, err = hashFieldModels.NewQuery().Filter("Name =", "b").Delete()
+	require.NoError(t, err)
+	expectKeyDoesNotExist(t, hashFieldModels.ModelKey(models[1].ID)+":Settings")
+	expectKeyExists(t, hashFieldModels.ModelKey(models[2].ID)+":Settings")
+
+	// Collection.DeleteAll
+	_, err = hashFieldModels.DeleteAll()
+	require.NoError(t, err)
+	expectKeyDoesNotExist(t, hashFieldModels.ModelKey(models[2].ID)+":Settings")
+}
+
+func TestHashFieldInvalidTypes(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type notMap struct {
+		Attr string `zoom:"hash"`
+		RandomID
+	}
+	type nonStringKeys struct {
+		Attr map[int]string `zoom:"hash"`
+		RandomID
+	}
+	type hashAndIndex struct {
+		Attr map[string]string `zoom:"hash,index"`
+		RandomID
+	}
+	for _, model := range []Model{&notMap{}, &nonStringKeys{}, &hashAndIndex{}} {
+		_, err := testPool.NewCollection(model)
+		assert.Error(t, err, "Expected error when registering %T", model)
+	}
+}
+
+func TestSliceFieldSaveAndFind(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := &sliceFieldModel{
+		Name:   "foo",
+		Tags:   []string{"b", "a", "b"},
+		Scores: []int{3, 1, 2},
+	}
+	require.NoError(t, sliceFieldModels.Save(model))
+
+	// Check the database directly
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	tags, err := redis.Strings(conn.Do("LRANGE", sliceFieldModels.ModelKey(model.ID)+":Tags", 0, -1))
+	require.NoError(t, err)
+	assert.Equal(t, model.Tags, tags)
+	scores, err := redis.Ints(conn.Do("SMEMBERS", sliceFieldModels.ModelKey(model.ID)+":scores"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, model.Scores, scores)
+
+	// Find the model and check the slice fields
+	got := &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.Find(model.ID, got))
+	assert.Equal(t, model.Name, got.Name)
+	assert.Equal(t, model.Tags, got.Tags)
+	assert.ElementsMatch(t, model.Scores, got.Scores)
+
+	// Saving again should overwrite the old values
+	model.Tags = []string{"c"}
+	model.Scores = nil
+	require.NoError(t, sliceFieldModels.Save(model))
+	got = &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.Find(model.ID, got))
+	assert.Equal(t, model, got)
+
+	// Deleting the model should delete the list and set
+	_, err = sliceFieldModels.Delete(model.ID)
+	require.NoError(t, err)
+	expectKeyDoesNotExist(t, sliceFieldModels.ModelKey(model.ID)+":Tags")
+}
+
+func TestKeyFieldsFindAllAndQuery(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*sliceFieldModel{
+		{Name: "a", Tags: []string{"x", "y"}, Scores: []int{1}},
+		{Name: "b", Tags: []string{"z"}},
+	}
+	for _, model := range models {
+		require.NoError(t, sliceFieldModels.Save(model))
+	}
+	hashModel := &hashFieldModel{Name: "a", Settings: map[string]string{"theme": "dark"}}
+	require.NoError(t, hashFieldModels.Save(hashModel))
+
+	// FindAll, Run, and Parallel should read the fields stored in their own key
+	// along with the other fields.
+	for _, run := range []func(*[]*sliceFieldModel) error{
+		func(got *[]*sliceFieldModel) error { return sliceFieldModels.FindAll(got) },
+		func(got *[]*sliceFieldModel) error { return sliceFieldModels.NewQuery().Order("Name").Run(got) },
+		func(got *[]*sliceFieldModel) error { return sliceFieldModels.NewQuery().Order("Name").Parallel(2).Run(got) },
+	} {
+		got := []*sliceFieldModel{}
+		require.NoError(t, run(&got))
+		assert.ElementsMatch(t, models, got)
+	}
+	gotHashModels := []*hashFieldModel{}
+	require.NoError(t, hashFieldModels.FindAll(&gotHashModels))
+	require.Len(t, gotHashModels, 1)
+	assert.Equal(t, hashModel, gotHashModels[0])
+
+	// RunOne should read them for the first model only.
+	got := &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.NewQuery().Filter("Name =", "b").RunOne(got))
+	assert.Equal(t, models[1], got)
+
+	// Include and Exclude should apply to the fields stored in their own key.
+	got = &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.NewQuery().Filter("Name =", "a").Include("Tags").RunOne(got))
+	assert.Equal(t, &sliceFieldModel{Tags: models[0].Tags, RandomID: models[0].RandomID}, got)
+	gotModels := []*sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.NewQuery().Order("Name").Exclude("Tags").Run(&gotModels))
+	require.Len(t, gotModels, 2)
+	assert.Nil(t, gotModels[0].Tags)
+	assert.Equal(t, models[0].Scores, gotModels[0].Scores)
+	assert.Equal(t, "a", gotModels[0].Name)
+}
+
+func TestListPushAndRemove(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := &sliceFieldModel{
+		Name: "foo",
+		Tags: []string{"a"},
+	}
+	require.NoError(t, sliceFieldModels.Save(model))
+	require.NoError(t, sliceFieldModels.ListPush(model.ID, "Tags", "b", "c", "b"))
+	got := &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.FindFields(model.ID, []string{"Tags"}, got))
+	assert.Equal(t, []string{"a", "b", "c", "b"}, got.Tags)
+
+	require.NoError(t, sliceFieldModels.ListRemove(model.ID, "Tags", "b", "a"))
+	got = &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.FindFields(model.ID, []string{"Tags"}, got))
+	assert.Equal(t, []string{"c"}, got.Tags)
+
+	// Invalid field names and values should return an error
+	assert.Error(t, sliceFieldModels.ListPush(model.ID, "Scores", 1))
+	assert.Error(t, sliceFieldModels.ListPush(model.ID, "Tags", 1))
+	assert.Error(t, sliceFieldModels.ListPush(model.ID, "Tags"))
+}
+
+func TestSetAddAndRemove(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := &sliceFieldModel{
+		Name:   "foo",
+		Scores: []int{1},
+	}
+	require.NoError(t, sliceFieldModels.Save(model))
+	require.NoError(t, sliceFieldModels.SetAdd(model.ID, "Scores", 2, 3, 1))
+	got := &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.FindFields(model.ID, []string{"Scores"}, got))
+	assert.ElementsMatch(t, []int{1, 2, 3}, got.Scores)
+
+	require.NoError(t, sliceFieldModels.SetRemove(model.ID, "Scores", 1, 3))
+	got = &sliceFieldModel{}
+	require.NoError(t, sliceFieldModels.FindFields(model.ID, []string{"Scores"}, got))
+	assert.Equal(t, []int{2}, got.Scores)
+
+	// Invalid field names and values should return an error
+	assert.Error(t, sliceFieldModels.SetAdd(model.ID, "Tags", "a"))
+	assert.Error(t, sliceFieldModels.SetAdd(model.ID, "Scores", "a"))
+}
+
+func TestSliceFieldInvalidTypes(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type notSlice struct {
+		Attr string `zoom:"list"`
+		RandomID
+	}
+	type byteSlice struct {
+		Attr []byte `zoom:"set"`
+		RandomID
+	}
+	type listAndSet struct {
+		Attr []string `zoom:"list,set"`
+		RandomID
+	}
+	for _, model := range []Model{&notSlice{}, &byteSlice{}, &listAndSet{}} {
+		_, err := testPool.NewCollection(model)
+		assert.Error(t, err, "Expected error when registering %T", model)
+	}
+}
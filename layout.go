@@ -0,0 +1,375 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File layout.go contains code for the document and JSON layouts, in which all
+// the fields of a model are stored as a single JSON document instead of one
+// field per hash field.
+
+package zoom
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Layout determines how the fields of the models in a collection are stored
+// (see CollectionOptions.Layout).
+type Layout int
+
+const (
+	// HashLayout stores each field of a model in its own field of the main
+	// hash for the model. It is the default.
+	HashLayout Layout = iota
+	// DocumentLayout stores all the fields of a model as a single JSON document
+	// in one field of the main hash, which is more compact but means that
+	// fields cannot be updated individually. The document is an object which
+	// maps the name of each field in Redis to its value as it would be stored
+	// in a hash. Values which are not valid UTF-8 (e.g. values encoded with
+	// GobMarshalerUnmarshaler) are stored as an object with a "base64"
+	// property. Field indexes and fields which are stored in their own key are
+	// not affected by the layout. Since individual fields are neither read nor
+	// written by Redis, SaveFields, GetSet, Query.Update, GetRaw, SetRawField,
+	// Query.ClaimOne, SyncModelIndexes, BuildIndex, WatchExternalWrites, and
+	// Query.IDsWithScores for integer fields return an error for collections
+	// with the document layout, and it cannot be combined with UseRediSearch,
+	// StrictScan, bitmap indexes, or fields with ondelete=setnull.
+	DocumentLayout
+	// JSONLayout stores all the fields of a model as a RedisJSON document in
+	// the main key for the model, using the JSON.SET command. It requires the
+	// RedisJSON module (e.g. Redis Stack). The document is just like the one
+	// for DocumentLayout, except that the fields of nested structs (see the
+	// nested option of the zoom struct tag) are stored as nested objects, e.g.
+	// {"Address": {"City": "Oslo"}}. The Lua scripts which maintain the field
+	// indexes read the old value of each field with JSON.GET and its JSONPath
+	// (e.g. $["Address"]["City"]), so Filter and Order can use nested paths
+	// like "Address.City". It has the same restrictions as DocumentLayout, and
+	// in addition the redis name of a field cannot be the path of a nested
+	// struct which contains another field.
+	JSONLayout
+)
+
+// String returns the name of the layout.
+func (layout Layout) String() string {
+	switch layout {
+	case HashLayout:
+		return "hash"
+	case DocumentLayout:
+		return "document"
+	case JSONLayout:
+		return "json"
+	}
+	return fmt.Sprintf("Layout(%d)", int(layout))
+}
+
+// documentField is the field of the main hash in which the document is stored
+// for collections with the document layout.
+const documentField = "_document"
+
+// documentBytes is the JSON representation of a value which is not valid
+// UTF-8 in a document.
+type documentBytes struct {
+	Base64 []byte `json:"base64"`
+}
+
+// setLayout sets the layout of ms. It returns an error if the layout cannot be
+// used with the given options or the fields of ms.
+func (ms *modelSpec) setLayout(options CollectionOptions) error {
+	switch options.Layout {
+	case HashLayout:
+		return nil
+	case DocumentLayout, JSONLayout:
+	default:
+		return fmt.Errorf("CollectionOptions.Layout is not valid. Got: %s", options.Layout)
+	}
+	layout := options.Layout
+	switch {
+	case options.UseRediSearch:
+		return fmt.Errorf("CollectionOptions.UseRediSearch cannot be combined with the %s layout", layout)
+	case options.StrictScan:
+		return fmt.Errorf("CollectionOptions.StrictScan cannot be combined with the %s layout", layout)
+	}
+	redisNames := map[string]bool{}
+	for _, fs := range ms.fieldsWithComputed() {
+		redisNames[fs.redisName] = true
+	}
+	for _, fs := range ms.fieldsWithComputed() {
+		switch {
+		case fs.hasBitmapIndex():
+			return fmt.Errorf("field %s in type %s cannot have a bitmap index because the collection uses the %s layout", fs.name, ms.typ.String(), layout)
+		case fs.redisName == documentField:
+			return fmt.Errorf("field %s in type %s cannot be stored as %s because the collection uses the %s layout", fs.name, ms.typ.String(), documentField, layout)
+		case fs.ttl > 0:
+			return fmt.Errorf("field %s in type %s cannot have a ttl because the collection uses the %s layout", fs.name, ms.typ.String(), layout)
+		case fs.ref != nil && fs.ref.onDelete == SetNull:
+			return fmt.Errorf("field %s in type %s cannot have ondelete=setnull because the collection uses the %s layout", fs.name, ms.typ.String(), layout)
+		}
+		if layout != JSONLayout {
+			continue
+		}
+		// Each part of the redis name is an object in the document, so it
+		// cannot also be the value of another field.
+		for i := range fs.redisName {
+			if fs.redisName[i] == '.' && redisNames[fs.redisName[:i]] {
+				return fmt.Errorf("field %s in type %s cannot be stored as %s because %s is also a field and the collection uses the %s layout", fs.name, ms.typ.String(), fs.redisName, fs.redisName[:i], layout)
+			}
+		}
+	}
+	ms.document = true
+	ms.json = layout == JSONLayout
+	return nil
+}
+
+// layout returns the layout of ms.
+func (ms *modelSpec) layout() Layout {
+	if ms.json {
+		return JSONLayout
+	}
+	if ms.document {
+		return DocumentLayout
+	}
+	return HashLayout
+}
+
+// documentFieldArg returns the field in which documents are stored if ms uses
+// the document layout, "$" (the JSONPath of the whole document) if it uses the
+// JSON layout, or an empty string otherwise. It is passed to the Lua scripts
+// which read the old values of indexed fields.
+func (ms *modelSpec) documentFieldArg() string {
+	if ms.json {
+		return "$"
+	}
+	if ms.document {
+		return documentField
+	}
+	return ""
+}
+
+// checkHashLayout returns an error if c uses the document or JSON layout.
+// method is the name of the method, which is used in the error message.
+func (c *Collection) checkHashLayout(method string) error {
+	if c.spec.document {
+		return fmt.Errorf("zoom: %s is not supported for collection %s because it uses the %s layout", method, c.Name(), c.spec.layout())
+	}
+	return nil
+}
+
+// saveDocument adds a command to the transaction which saves the fields in
+// hashArgs, the arguments for an HMSET command which saves the fields of the
+// model behind mr (see mainHashArgs), as a single document.
+func (t *Transaction) saveDocument(mr *modelRef, hashArgs redis.Args) {
+	document, err := mr.spec.encodeDocument(hashArgs)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	if mr.spec.json {
+		t.modelCommand(mr.model.ModelID(), "JSON.SET", redis.Args{mr.key(), "$", document}, nil)
+		return
+	}
+	t.modelCommand(mr.model.ModelID(), "HSET", redis.Args{mr.key(), documentField, document}, nil)
+}
+
+// encodeDocument converts the arguments for an HMSET command which saves the
+// fields of a model (see mainHashArgs) to a document. For the JSON layout, the
+// parts of the redis name of each field are nested objects.
+func (ms *modelSpec) encodeDocument(hashArgs redis.Args) ([]byte, error) {
+	// The first element of hashArgs is the model key, which is followed by
+	// pairs of redis names and values.
+	document := map[string]interface{}{}
+	for i := 1; i+1 < len(hashArgs); i += 2 {
+		redisName, ok := hashArgs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("zoom: unexpected field name %v", hashArgs[i])
+		}
+		var docValue interface{}
+		value := hashArgBytes(hashArgs[i+1])
+		if utf8.Valid(value) {
+			docValue = string(value)
+		} else {
+			docValue = documentBytes{Base64: value}
+		}
+		object := document
+		if ms.json {
+			parts := strings.Split(redisName, ".")
+			for _, part := range parts[:len(parts)-1] {
+				nested, ok := object[part].(map[string]interface{})
+				if !ok {
+					nested = map[string]interface{}{}
+					object[part] = nested
+				}
+				object = nested
+			}
+			redisName = parts[len(parts)-1]
+		}
+		object[redisName] = docValue
+	}
+	return json.Marshal(document)
+}
+
+// documentCommand returns the name and arguments of the command which reads
+// the document for the model with the given key.
+func (ms *modelSpec) documentCommand(key string) (string, redis.Args) {
+	if ms.json {
+		return "JSON.GET", redis.Args{key}
+	}
+	return "HGET", redis.Args{key, documentField}
+}
+
+// hashArgBytes returns the bytes that are stored in Redis for an argument of
+// a command. It formats arguments the same way as redigo does.
+func hashArgBytes(arg interface{}) []byte {
+	switch arg := arg.(type) {
+	case string:
+		return []byte(arg)
+	case []byte:
+		return arg
+	case int:
+		return []byte(strconv.FormatInt(int64(arg), 10))
+	case int64:
+		return []byte(strconv.FormatInt(arg, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(arg, 'g', -1, 64))
+	case bool:
+		if arg {
+			return []byte("1")
+		}
+		return []byte("0")
+	case nil:
+		return []byte{}
+	case redis.Argument:
+		return hashArgBytes(arg.RedisArg())
+	}
+	return []byte(fmt.Sprint(arg))
+}
+
+// decodeDocument converts a document (or nil if the model does not exist) to
+// the values of the fields with the given redis names, in the same format as
+// the reply to an HMGET command for the fields.
+func decodeDocument(reply interface{}, redisNames []string) ([]interface{}, error) {
+	values := make([]interface{}, len(redisNames))
+	if reply == nil {
+		return values, nil
+	}
+	data, err := redis.Bytes(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	document := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("zoom: could not decode document: %w", err)
+	}
+	for i, redisName := range redisNames {
+		raw, found := document[redisName]
+		if !found {
+			// The fields of nested structs are nested objects in documents
+			// which are stored with the JSON layout.
+			if raw, found = nestedDocumentValue(document, redisName); !found {
+				continue
+			}
+		}
+		if len(raw) > 0 && raw[0] == '{' {
+			value := documentBytes{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, fmt.Errorf("zoom: could not decode field %s of document: %w", redisName, err)
+			}
+			values[i] = value.Base64
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("zoom: could not decode field %s of document: %w", redisName, err)
+		}
+		values[i] = []byte(value)
+	}
+	return values, nil
+}
+
+// nestedDocumentValue returns the value in document at the path given by the
+// parts of redisName (e.g. Address.City) and whether it was found.
+func nestedDocumentValue(document map[string]json.RawMessage, redisName string) (json.RawMessage, bool) {
+	parts := strings.Split(redisName, ".")
+	if len(parts) == 1 {
+		return nil, false
+	}
+	object := document
+	for _, part := range parts[:len(parts)-1] {
+		raw, found := object[part]
+		if !found {
+			return nil, false
+		}
+		object = map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, false
+		}
+	}
+	raw, found := object[parts[len(parts)-1]]
+	return raw, found
+}
+
+// newDocumentHandler returns a ReplyHandler which converts a document to the
+// values of the fields with the given redis names (see decodeDocument) and
+// passes them to handler.
+func newDocumentHandler(redisNames []string, handler ReplyHandler) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := decodeDocument(reply, redisNames)
+		if err != nil {
+			return err
+		}
+		return handler(values)
+	}
+}
+
+// readModelFields adds a command to the transaction which reads the fields of
+// the model behind mr with the given redis names, and calls handler with the
+// values in the same format as the reply to an HMGET command.
+func (t *Transaction) readModelFields(mr *modelRef, redisNames []string, handler ReplyHandler) {
+	if !mr.spec.document {
+		t.modelCommand(mr.model.ModelID(), "HMGET", redis.Args{mr.key()}.AddFlat(redisNames), handler)
+		return
+	}
+	command, args := mr.spec.documentCommand(mr.key())
+	t.modelCommand(mr.model.ModelID(), command, args, newDocumentHandler(redisNames, handler))
+}
+
+// sortModels adds a SORT command with the given arguments (see sortArgs) to
+// the transaction, which reads the fields with the given redis names of the
+// models, and passes the reply to handler like sortHandler. Since SORT cannot
+// read RedisJSON documents, the sort_models script, which gets the documents
+// after sorting the ids, is used instead for the JSON layout.
+func (t *Transaction) sortModels(spec *modelSpec, sortArgs redis.Args, redisNames []string, handler ReplyHandler) {
+	t.sortModelsWithKeyFields(spec, sortArgs, redisNames, nil, nil, handler)
+}
+
+// sortHandler returns a ReplyHandler which passes the reply to a SORT command
+// with the arguments returned by sortArgs for the given redis names to
+// handler. For the document and JSON layouts, the documents in the reply are
+// converted to the values of the fields, so handler can always expect the value
+// of each field followed by the id for each model.
+func (ms *modelSpec) sortHandler(redisNames []string, handler ReplyHandler) ReplyHandler {
+	if !ms.document || len(redisNames) == 0 {
+		return handler
+	}
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+		// sortArgs (or sort_models.lua for the JSON layout) gets the
+		// document followed by the id for each model.
+		expanded := make([]interface{}, 0, len(values)/2*(len(redisNames)+1))
+		for i := 0; i+1 < len(values); i += 2 {
+			fieldValues, err := decodeDocument(values[i], redisNames)
+			if err != nil {
+				return err
+			}
+			expanded = append(append(expanded, fieldValues...), values[i+1])
+		}
+		return handler(expanded)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File layout_test.go tests the code in layout.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type documentTestModel struct {
+	Name  string `zoom:"index"`
+	Count int64  `zoom:"index"`
+	Age   int    `zoom:"index"`
+	Tags  map[string]int
+	Note  string
+	RandomID
+}
+
+func TestDocumentLayout(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&documentTestModel{}, DefaultCollectionOptions.WithIndex(true).WithLayout(DocumentLayout))
+	require.NoError(t, err)
+	assert.Equal(t, DocumentLayout.String(), col.spec.schema().Layout)
+	count := func(field string, value interface{}) int {
+		n, err := col.NewQuery().Filter(field+" =", value).Count()
+		require.NoError(t, err)
+		return n
+	}
+
+	// Models should be stored as a single document and read back exactly,
+	// including values which are not valid UTF-8
+	models := []*documentTestModel{
+		{Name: "a\xff\x00b", Count: -5, Age: 30, Tags: map[string]int{"x": 1}, Note: "first"},
+		{Name: "b", Count: 10, Age: 20, Note: "second"},
+	}
+	for _, model := range models {
+		require.NoError(t, col.Save(model))
+	}
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	fields, err := redis.Strings(conn.Do("HKEYS", col.ModelKey(models[0].ID)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{documentField}, fields)
+	found := &documentTestModel{}
+	require.NoError(t, col.Find(models[0].ID, found))
+	assert.Equal(t, models[0], found)
+	partial := &documentTestModel{}
+	require.NoError(t, col.FindFields(models[1].ID, []string{"Age", "Note"}, partial))
+	assert.Equal(t, &documentTestModel{Age: 20, Note: "second", RandomID: models[1].RandomID}, partial)
+	present, err := col.FindWithPresence(models[1].ID, &documentTestModel{})
+	require.NoError(t, err)
+	assert.True(t, present["Note"])
+	all := []*documentTestModel{}
+	require.NoError(t, col.FindAll(&all))
+	assert.Len(t, all, 2)
+
+	// Queries should use the field indexes and read the documents
+	results := []*documentTestModel{}
+	require.NoError(t, col.NewQuery().Order("Count").Run(&results))
+	assert.Equal(t, models, results)
+	results = []*documentTestModel{}
+	require.NoError(t, col.NewQuery().Filter("Age >", 25).Include("Name", "Tags").Run(&results))
+	require.Len(t, results, 1)
+	assert.Equal(t, &documentTestModel{Name: models[0].Name, Tags: models[0].Tags, RandomID: models[0].RandomID}, results[0])
+	assert.Equal(t, 1, count("Name", models[0].Name))
+
+	// Saving a model should remove the old values from string and integer
+	// indexes
+	oldName := models[0].Name
+	models[0].Name = "c"
+	models[0].Count = 7
+	require.NoError(t, col.Save(models[0]))
+	assert.Equal(t, 0, count("Name", oldName))
+	assert.Equal(t, 0, count("Count", int64(-5)))
+	assert.Equal(t, 1, count("Name", "c"))
+	assert.Equal(t, 1, count("Count", int64(7)))
+
+	// Deleting models should remove them from the indexes
+	_, err = col.Delete(models[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count("Name", "c"))
+	assert.Equal(t, 0, count("Count", int64(7)))
+	deleted, err := col.NewQuery().Filter("Age =", 20).Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, 0, count("Name", "b"))
+	assert.Equal(t, 0, count("Count", int64(10)))
+
+	// Methods which read or write individual fields in Redis should be
+	// rejected
+	model := &documentTestModel{Name: "d"}
+	require.NoError(t, col.Save(model))
+	assert.Error(t, col.SaveFields([]string{"Name"}, model))
+	_, err = col.GetRaw(model.ID)
+	assert.Error(t, err)
+	assert.Error(t, col.SetRawField(model.ID, "Name", "e"))
+	_, err = col.NewQuery().Update(map[string]interface{}{"Name": "e"})
+	assert.Error(t, err)
+	_, err = col.BuildIndex("Name")
+	assert.Error(t, err)
+	_, err = col.NewQuery().Order("Count").IDsWithScores()
+	assert.Error(t, err)
+	_, err = pool.WatchExternalWrites(col)
+	assert.Error(t, err)
+	found = &documentTestModel{}
+	require.NoError(t, col.Find(model.ID, found))
+	assert.Equal(t, "d", found.Name)
+
+	// Invalid options should be rejected
+	type bitmapDocumentTestModel struct {
+		Active bool `zoom:"index,bitmap"`
+		RandomID
+	}
+	_, err = pool.NewCollectionWithOptions(&bitmapDocumentTestModel{}, DefaultCollectionOptions.WithLayout(DocumentLayout))
+	assert.Error(t, err)
+	_, err = pool.NewCollectionWithOptions(&documentTestModel{}, DefaultCollectionOptions.WithName("strictDocumentTestModel").WithStrictScan(true).WithLayout(DocumentLayout))
+	assert.Error(t, err)
+	_, err = pool.NewCollectionWithOptions(&documentTestModel{}, DefaultCollectionOptions.WithName("badDocumentTestModel").WithLayout(Layout(5)))
+	assert.Error(t, err)
+	_, err = pool.NewDynamicCollection(Schema{Name: "dynamicDocumentTestModel"}, DefaultCollectionOptions.WithLayout(DocumentLayout))
+	assert.Error(t, err)
+
+	// Changing the layout should be reported as schema drift
+	drifts := compareSchemas(Schema{Name: col.Name()}, col.spec.schema())
+	require.NotEmpty(t, drifts)
+	assert.Equal(t, "layout changed from hash to document", drifts[0].Message)
+}
+
+type jsonTestAddress struct {
+	City    string `zoom:"index"`
+	Country string
+}
+
+type jsonTestModel struct {
+	Name    string          `zoom:"index"`
+	Age     int             `zoom:"index"`
+	Address jsonTestAddress `zoom:"nested"`
+	RandomID
+}
+
+func TestJSONLayoutDocuments(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&jsonTestModel{}, DefaultCollectionOptions.WithIndex(true).WithLayout(JSONLayout))
+	require.NoError(t, err)
+	assert.Equal(t, JSONLayout.String(), col.spec.schema().Layout)
+
+	// Models should be saved with JSON.SET, with nested structs as nested
+	// objects
+	model := &jsonTestModel{Name: "a\xffb", Age: 30, Address: jsonTestAddress{City: "Oslo", Country: "NO"}}
+	model.SetModelID("json")
+	tx := pool.NewTransaction()
+	tx.Save(col, model)
+	commands, err := tx.DryRun()
+	require.NoError(t, err)
+	var document []byte
+	for _, command := range commands {
+		if command.Name == "JSON.SET" {
+			require.Len(t, command.Args, 3)
+			assert.Equal(t, col.ModelKey("json"), command.Args[0])
+			assert.Equal(t, "$", command.Args[1])
+			document = command.Args[2].([]byte)
+		}
+	}
+	require.NotNil(t, document, "Expected Save to use JSON.SET")
+	assert.JSONEq(t, `{"Name": {"base64": "Yf9i"}, "Age": "30", "Address": {"City": "Oslo", "Country": "NO"}}`, string(document))
+	values, err := decodeDocument(document, []string{"Name", "Age", "Address.City", "Address.Country", "Address.Street"})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{[]byte("a\xffb"), []byte("30"), []byte("Oslo"), []byte("NO"), nil}, values)
+
+	// SORT cannot get the fields of RedisJSON documents, so it should only get
+	// the ids
+	assert.Equal(t, redis.Args{"ids", "BY", "nosort", "GET", "#", "ASC"}, col.spec.sortArgs("ids", []string{"Name"}, 0, 0, false))
+
+	// A field cannot be stored at the path of an object in the document
+	type conflictingJSONTestModel struct {
+		Address string
+		Home    jsonTestAddress `zoom:"nested" redis:"Address"`
+		RandomID
+	}
+	_, err = pool.NewCollectionWithOptions(&conflictingJSONTestModel{}, DefaultCollectionOptions.WithLayout(JSONLayout))
+	assert.Error(t, err)
+	_, err = pool.NewCollectionWithOptions(&conflictingJSONTestModel{}, DefaultCollectionOptions.WithLayout(DocumentLayout))
+	assert.NoError(t, err)
+}
+
+func TestJSONLayout(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("JSON.GET", "jsonLayoutProbe"); err != nil {
+		t.Skipf("Skipping because the RedisJSON module is not available: %s", err)
+	}
+	col, err := pool.NewCollectionWithOptions(&jsonTestModel{}, DefaultCollectionOptions.WithIndex(true).WithLayout(JSONLayout))
+	require.NoError(t, err)
+	count := func(field string, value interface{}) int {
+		n, err := col.NewQuery().Filter(field+" =", value).Count()
+		require.NoError(t, err)
+		return n
+	}
+
+	// Models should be stored as RedisJSON documents and read back exactly
+	models := []*jsonTestModel{
+		{Name: "a\xff\x00b", Age: 30, Address: jsonTestAddress{City: "Oslo", Country: "NO"}},
+		{Name: "b", Age: 20, Address: jsonTestAddress{City: "Paris", Country: "FR"}},
+	}
+	for _, model := range models {
+		require.NoError(t, col.Save(model))
+	}
+	city, err := redis.String(conn.Do("JSON.GET", col.ModelKey(models[0].ID), "$.Address.City"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `["Oslo"]`, city)
+	found := &jsonTestModel{}
+	require.NoError(t, col.Find(models[0].ID, found))
+	assert.Equal(t, models[0], found)
+	partial := &jsonTestModel{}
+	require.NoError(t, col.FindFields(models[1].ID, []string{"Address.Country"}, partial))
+	assert.Equal(t, &jsonTestModel{Address: jsonTestAddress{Country: "FR"}, RandomID: models[1].RandomID}, partial)
+	all := []*jsonTestModel{}
+	require.NoError(t, col.FindAll(&all))
+	assert.Len(t, all, 2)
+
+	// Queries should filter and order by nested paths and read the documents
+	results := []*jsonTestModel{}
+	require.NoError(t, col.NewQuery().Filter("Address.City =", "Oslo").Run(&results))
+	assert.Equal(t, models[:1], results)
+	results = []*jsonTestModel{}
+	require.NoError(t, col.NewQuery().Order("-Address.City").Include("Address.City").Run(&results))
+	require.Len(t, results, 2)
+	assert.Equal(t, "Paris", results[0].Address.City)
+	assert.Equal(t, "", results[0].Name)
+
+	// Saving a model should remove the old values from the string indexes,
+	// which are read with JSON.GET
+	models[0].Address.City = "Bergen"
+	models[0].Name = "c"
+	require.NoError(t, col.Save(models[0]))
+	assert.Equal(t, 0, count("Address.City", "Oslo"))
+	assert.Equal(t, 0, count("Name", "a\xff\x00b"))
+	assert.Equal(t, 1, count("Address.City", "Bergen"))
+
+	// Deleting models should remove them from the indexes
+	_, err = col.Delete(models[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count("Address.City", "Bergen"))
+	deleted, err := col.NewQuery().Filter("Age =", 20).Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, 0, count("Address.City", "Paris"))
+	assert.Equal(t, 0, count("Name", "b"))
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File lock.go contains code for locking individual models, so that workers
+// can coordinate updates to the same model.
+
+package zoom
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/garyburd/redigo/redis"
+)
+
+// Lock is an exclusive lock on a single model, acquired with Collection.Lock.
+// The lock is stored in Redis with a random token and expires automatically
+// after its time to live, so a worker which crashes cannot keep the model
+// locked forever. A Lock is not safe for use by multiple goroutines at once.
+type Lock struct {
+	pool  *Pool
+	key   string
+	token string
+}
+
+// lockKey returns the key which holds the lock for the model with the given
+// id.
+func (ms *modelSpec) lockKey(id string) string {
+	return ms.name + ":lock:" + id
+}
+
+// Lock acquires an exclusive lock on the model with the given id, which
+// expires after ttl unless it is released with Unlock or extended with Extend
+// first. The model does not need to exist. If the model is already locked by
+// another client, Lock returns an error which wraps ErrLocked immediately
+// instead of waiting, so that the caller can decide whether to retry. The lock
+// is only advisory: it does not prevent other clients from saving or deleting
+// the model without calling Lock first. Since the lock is held by a single
+// Redis server, it is not safe against failover to a replica which has not
+// yet received the lock.
+func (c *Collection) Lock(id string, ttl time.Duration) (*Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, fmt.Errorf("zoom: error in Lock: ttl must be at least one millisecond but got %s", ttl)
+	}
+	lock := &Lock{
+		pool:  c.pool,
+		key:   c.spec.lockKey(id),
+		token: uniuri.New(),
+	}
+	acquired := false
+	t := c.pool.NewTransaction()
+	t.Command("SET", redis.Args{lock.key, lock.token, "NX", "PX", ttl.Milliseconds()}, func(reply interface{}) error {
+		acquired = reply != nil
+		return nil
+	})
+	if err := t.Exec(); err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, newKindError(ErrLocked, "zoom: could not lock %s with id = %s because it is already locked", c.Name(), id)
+	}
+	return lock, nil
+}
+
+// Key returns the Redis key which holds the lock.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Unlock releases the lock so that other clients can acquire it. It returns an
+// error which wraps ErrLockNotHeld if the lock has already expired, in which
+// case another client may have modified the model while the caller thought it
+// held the lock. A lock which was acquired by another client after it expired
+// is never released.
+func (l *Lock) Unlock() error {
+	return l.runScript(releaseLockScript, "Unlock", redis.Args{l.key, l.token})
+}
+
+// Extend resets the time to live of the lock to ttl, e.g. so that a worker can
+// keep a model locked for longer than it expected. It returns an error which
+// wraps ErrLockNotHeld if the lock has already expired.
+func (l *Lock) Extend(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return fmt.Errorf("zoom: error in Lock.Extend: ttl must be at least one millisecond but got %s", ttl)
+	}
+	return l.runScript(extendLockScript, "Extend", redis.Args{l.key, l.token, ttl.Milliseconds()})
+}
+
+// runScript runs script, which should return 1 if the lock is still held and 0
+// otherwise. method is the name of the method, which is used in error messages.
+func (l *Lock) runScript(script *redis.Script, method string, args redis.Args) error {
+	held := false
+	t := l.pool.NewTransaction()
+	t.Script(script, args, func(reply interface{}) error {
+		n, err := redis.Int(reply, nil)
+		held = n == 1
+		return err
+	})
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	if !held {
+		return newKindError(ErrLockNotHeld, "zoom: error in Lock.%s: the lock %s is no longer held", method, l.key)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File lock_test.go tests the code in lock.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	lock, err := testModels.Lock("a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "testModel:lock:a", lock.Key())
+
+	// The model should not be locked twice, but other models can be locked
+	_, err = testModels.Lock("a", time.Minute)
+	assert.True(t, errors.Is(err, ErrLocked), "err: %v", err)
+	other, err := testModels.Lock("b", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, other.Unlock())
+
+	// The lock should expire after its ttl
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	pttl, err := redis.Int(conn.Do("PTTL", lock.Key()))
+	require.NoError(t, err)
+	assert.True(t, pttl > 0 && pttl <= 60000, "pttl: %d", pttl)
+	require.NoError(t, lock.Extend(2*time.Minute))
+	pttl, err = redis.Int(conn.Do("PTTL", lock.Key()))
+	require.NoError(t, err)
+	assert.True(t, pttl > 60000 && pttl <= 120000, "pttl: %d", pttl)
+
+	// Once unlocked, the model can be locked again
+	require.NoError(t, lock.Unlock())
+	err = lock.Unlock()
+	assert.True(t, errors.Is(err, ErrLockNotHeld), "err: %v", err)
+	err = lock.Extend(time.Minute)
+	assert.True(t, errors.Is(err, ErrLockNotHeld), "err: %v", err)
+	relock, err := testModels.Lock("a", time.Minute)
+	require.NoError(t, err)
+
+	// A lock which expired (simulated here by deleting it) must not release a
+	// lock acquired by another client
+	_, err = conn.Do("DEL", relock.Key())
+	require.NoError(t, err)
+	newLock, err := testModels.Lock("a", time.Minute)
+	require.NoError(t, err)
+	err = relock.Unlock()
+	assert.True(t, errors.Is(err, ErrLockNotHeld), "err: %v", err)
+	_, err = testModels.Lock("a", time.Minute)
+	assert.True(t, errors.Is(err, ErrLocked), "err: %v", err)
+	require.NoError(t, newLock.Unlock())
+
+	// Invalid ttls should return an error
+	_, err = testModels.Lock("c", 0)
+	assert.Error(t, err)
+}
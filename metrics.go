@@ -0,0 +1,227 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File metrics.go contains code for collecting metrics about a pool, which can
+// be exported to a monitoring system such as Prometheus.
+
+package zoom
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// MetricsLatencyBuckets are the upper bounds of the buckets of the latency
+// histograms in PoolMetrics. They match the default buckets of the Prometheus
+// client library.
+var MetricsLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// PoolMetrics is a snapshot of the metrics of a pool, returned by
+// Pool.Metrics. The counters only ever increase (like Prometheus counters),
+// starting from 0 when the pool is created, while the number of connections
+// is the current value (like a Prometheus gauge).
+type PoolMetrics struct {
+	// Commands is the number of commands sent to Redis through connections
+	// from the pool, including MULTI and EXEC and the commands used to run
+	// scripts.
+	Commands uint64
+	// Transactions is the number of transactions executed, including the
+	// transactions used by Collection and Query methods.
+	Transactions uint64
+	// FailedTransactions is the number of transactions for which Exec (or one
+	// of its variants) returned an error.
+	FailedTransactions uint64
+	// BytesWritten is the number of bytes sent to Redis and BytesRead is the
+	// number of bytes received from Redis.
+	BytesWritten uint64
+	BytesRead    uint64
+	// ActiveConnections is the number of connections in the pool, both in use
+	// and idle, and IdleConnections is the number of idle connections (see
+	// Stats).
+	ActiveConnections int
+	IdleConnections   int
+	// QueryLatencies maps the name of each collection which has been queried
+	// to a histogram of the time it took to execute its queries. Each
+	// transaction sent by a Query finisher (e.g. Run or Count) is one
+	// observation. Queries which are part of a larger transaction (see
+	// Transaction.Query) are not included.
+	QueryLatencies map[string]LatencyHistogram
+}
+
+// LatencyHistogram is a histogram of durations, in the same form as a
+// Prometheus histogram.
+type LatencyHistogram struct {
+	// Buckets are the upper bounds of the buckets, i.e. MetricsLatencyBuckets.
+	Buckets []time.Duration
+	// Counts are the cumulative number of observations in each bucket, i.e.
+	// Counts[i] is the number of observations which took at most Buckets[i].
+	Counts []uint64
+	// Count is the total number of observations, including those greater than
+	// the last bucket, and Sum is the sum of all observations.
+	Count uint64
+	Sum   time.Duration
+}
+
+// observe adds an observation of d to the histogram.
+func (h *LatencyHistogram) observe(d time.Duration) {
+	for i, bound := range h.Buckets {
+		if d <= bound {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += d
+}
+
+// poolMetrics contains the counters for the metrics of a pool.
+type poolMetrics struct {
+	// The counters are accessed atomically and must be first, so that they are
+	// 64-bit aligned on 32-bit platforms.
+	commands           uint64
+	transactions       uint64
+	failedTransactions uint64
+	bytesWritten       uint64
+	bytesRead          uint64
+	// mut protects queryLatencies.
+	mut            sync.Mutex
+	queryLatencies map[string]*LatencyHistogram
+}
+
+func newPoolMetrics() *poolMetrics {
+	return &poolMetrics{
+		queryLatencies: map[string]*LatencyHistogram{},
+	}
+}
+
+// Metrics returns a snapshot of the metrics of the pool: the number of
+// commands and transactions it has executed, the number of bytes it has sent
+// and received, its current connections, and the latency of queries for each
+// collection. It is cheap enough to call each time the metrics are scraped.
+func (p *Pool) Metrics() PoolMetrics {
+	m := p.metrics
+	stats := p.Stats()
+	metrics := PoolMetrics{
+		Commands:           atomic.LoadUint64(&m.commands),
+		Transactions:       atomic.LoadUint64(&m.transactions),
+		FailedTransactions: atomic.LoadUint64(&m.failedTransactions),
+		BytesWritten:       atomic.LoadUint64(&m.bytesWritten),
+		BytesRead:          atomic.LoadUint64(&m.bytesRead),
+		ActiveConnections:  stats.ActiveCount,
+		IdleConnections:    stats.IdleCount,
+		QueryLatencies:     map[string]LatencyHistogram{},
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for name, h := range m.queryLatencies {
+		copied := *h
+		copied.Counts = append([]uint64(nil), h.Counts...)
+		metrics.QueryLatencies[name] = copied
+	}
+	return metrics
+}
+
+// recordExec records that a transaction was executed and took d. If the
+// transaction was sent by a query, collectionName is the name of the
+// collection which was queried and d is recorded as its latency.
+func (m *poolMetrics) recordExec(collectionName string, d time.Duration, err error) {
+	atomic.AddUint64(&m.transactions, 1)
+	if err != nil {
+		atomic.AddUint64(&m.failedTransactions, 1)
+	}
+	if collectionName == "" {
+		return
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	h, found := m.queryLatencies[collectionName]
+	if !found {
+		h = &LatencyHistogram{
+			Buckets: MetricsLatencyBuckets,
+			Counts:  make([]uint64, len(MetricsLatencyBuckets)),
+		}
+		m.queryLatencies[collectionName] = h
+	}
+	h.observe(d)
+}
+
+// dial connects to Redis like net.Dial (with the same keep-alive period as
+// redis.Dial), but counts the bytes sent and received on the connection.
+func (m *poolMetrics) dial(network, address string) (net.Conn, error) {
+	conn, err := (&net.Dialer{KeepAlive: 5 * time.Minute}).Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &countingNetConn{Conn: conn, metrics: m}, nil
+}
+
+// countingNetConn is a network connection which counts the bytes sent and
+// received.
+type countingNetConn struct {
+	net.Conn
+	metrics *poolMetrics
+}
+
+func (c *countingNetConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.metrics.bytesRead, uint64(n))
+	return n, err
+}
+
+func (c *countingNetConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.metrics.bytesWritten, uint64(n))
+	return n, err
+}
+
+// countingConn is a Redis connection which counts the commands sent.
+type countingConn struct {
+	redis.Conn
+	metrics *poolMetrics
+}
+
+// count counts the command with the given name. Do is called with an empty
+// name to flush pipelined commands and read their replies, which does not send
+// a command.
+func (c *countingConn) count(name string) {
+	if name != "" {
+		atomic.AddUint64(&c.metrics.commands, 1)
+	}
+}
+
+func (c *countingConn) Do(name string, args ...interface{}) (interface{}, error) {
+	c.count(name)
+	return c.Conn.Do(name, args...)
+}
+
+func (c *countingConn) Send(name string, args ...interface{}) error {
+	c.count(name)
+	return c.Conn.Send(name, args...)
+}
+
+// DoWithTimeout satisfies redis.ConnWithTimeout.
+func (c *countingConn) DoWithTimeout(timeout time.Duration, name string, args ...interface{}) (interface{}, error) {
+	c.count(name)
+	return redis.DoWithTimeout(c.Conn, timeout, name, args...)
+}
+
+// ReceiveWithTimeout satisfies redis.ConnWithTimeout.
+func (c *countingConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
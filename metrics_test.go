@@ -0,0 +1,77 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File metrics_test.go tests the code in metrics.go
+
+package zoom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolMetrics(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	before := pool.Metrics()
+	assert.Empty(t, before.QueryLatencies)
+
+	require.NoError(t, col.Save(&indexedTestModel{Int: 1}))
+	afterSave := pool.Metrics()
+	assert.Equal(t, before.Transactions+1, afterSave.Transactions)
+	assert.Equal(t, before.FailedTransactions, afterSave.FailedTransactions)
+	assert.True(t, afterSave.Commands > before.Commands, "expected the number of commands to increase")
+	assert.True(t, afterSave.BytesWritten > before.BytesWritten, "expected the number of bytes written to increase")
+	assert.True(t, afterSave.BytesRead > before.BytesRead, "expected the number of bytes read to increase")
+	assert.True(t, afterSave.ActiveConnections >= 1)
+	assert.True(t, afterSave.IdleConnections >= 1)
+
+	// Each query is recorded in the histogram for its collection
+	models := []*indexedTestModel{}
+	require.NoError(t, col.NewQuery().Filter("Int =", 1).Run(&models))
+	_, err = col.NewQuery().Count()
+	require.NoError(t, err)
+	metrics := pool.Metrics()
+	require.Contains(t, metrics.QueryLatencies, col.Name())
+	latencies := metrics.QueryLatencies[col.Name()]
+	assert.Equal(t, MetricsLatencyBuckets, latencies.Buckets)
+	assert.EqualValues(t, 2, latencies.Count)
+	assert.True(t, latencies.Sum > 0)
+	assert.True(t, latencies.Counts[len(latencies.Counts)-1] <= latencies.Count)
+	assert.Equal(t, afterSave.Transactions+2, metrics.Transactions)
+
+	// The snapshot should not change when the pool is used again
+	latencies.Counts[0] = 100
+	tx := pool.NewTransaction()
+	tx.Command("INCR", redis.Args{col.ModelKey(models[0].ID)}, nil)
+	assert.Error(t, tx.Exec())
+	assert.EqualValues(t, 2, latencies.Count)
+	afterFailure := pool.Metrics()
+	assert.Equal(t, metrics.FailedTransactions+1, afterFailure.FailedTransactions)
+	assert.NotEqual(t, uint64(100), afterFailure.QueryLatencies[col.Name()].Counts[0])
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := LatencyHistogram{
+		Buckets: []time.Duration{time.Millisecond, 10 * time.Millisecond},
+		Counts:  make([]uint64, 2),
+	}
+	h.observe(500 * time.Microsecond)
+	h.observe(5 * time.Millisecond)
+	h.observe(time.Second)
+	assert.Equal(t, []uint64{1, 2}, h.Counts)
+	assert.EqualValues(t, 3, h.Count)
+	assert.Equal(t, time.Second+5500*time.Microsecond, h.Sum)
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File middleware.go contains code related to command middleware, which wraps
+// every command sent to Redis through a Pool.
+
+package zoom
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// CommandFunc sends a single command with the given name and args to Redis
+// and returns the reply. It has the same signature as the Do method of
+// redis.Conn.
+type CommandFunc func(name string, args ...interface{}) (interface{}, error)
+
+// Middleware wraps a CommandFunc with additional behavior. A Middleware
+// typically does something before and/or after calling next, e.g. starting a
+// tracing span or measuring how long the command took. It may also return an
+// error or a fake reply without calling next at all, e.g. to inject faults in
+// tests.
+type Middleware func(next CommandFunc) CommandFunc
+
+// Use adds one or more middleware to the pool. Every command sent through a
+// connection from the pool (including the commands sent by transactions,
+// queries, and scripts) is passed through the middleware. Middleware is
+// applied in the order it was added, so the first middleware is the outermost
+// one. Commands which are pipelined, such as the commands in a MULTI/EXEC
+// block, are also passed through the middleware, but for those next only
+// queues the command and returns a nil reply. The replies are returned by the
+// EXEC command instead. Use only affects connections which are taken from the
+// pool after it is called, so it should be called before the pool is used. It
+// is not safe to call Use concurrently with other methods of the pool.
+func (p *Pool) Use(middleware ...Middleware) {
+	p.middleware = append(p.middleware, middleware...)
+}
+
+// middlewareConn is a connection which passes every command through the
+// middleware of a pool.
+type middlewareConn struct {
+	redis.Conn
+	middleware []Middleware
+	do         CommandFunc
+	send       CommandFunc
+}
+
+// newMiddlewareConn wraps conn so that each command is passed through the
+// given middleware.
+func newMiddlewareConn(conn redis.Conn, middleware []Middleware) *middlewareConn {
+	send := func(name string, args ...interface{}) (interface{}, error) {
+		return nil, conn.Send(name, args...)
+	}
+	return &middlewareConn{
+		Conn:       conn,
+		middleware: middleware,
+		do:         applyMiddleware(conn.Do, middleware),
+		send:       applyMiddleware(send, middleware),
+	}
+}
+
+// applyMiddleware wraps f with the given middleware, so that the first
+// middleware is the outermost one.
+func applyMiddleware(f CommandFunc, middleware []Middleware) CommandFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		f = middleware[i](f)
+	}
+	return f
+}
+
+// Do sends a command to Redis through the middleware and returns the reply.
+func (c *middlewareConn) Do(name string, args ...interface{}) (interface{}, error) {
+	return c.do(name, args...)
+}
+
+// Send queues a command through the middleware.
+func (c *middlewareConn) Send(name string, args ...interface{}) error {
+	_, err := c.send(name, args...)
+	return err
+}
+
+// DoWithTimeout satisfies redis.ConnWithTimeout. It is like Do but waits at
+// most timeout for the reply.
+func (c *middlewareConn) DoWithTimeout(timeout time.Duration, name string, args ...interface{}) (interface{}, error) {
+	do := func(name string, args ...interface{}) (interface{}, error) {
+		return redis.DoWithTimeout(c.Conn, timeout, name, args...)
+	}
+	return applyMiddleware(do, c.middleware)(name, args...)
+}
+
+// ReceiveWithTimeout satisfies redis.ConnWithTimeout.
+func (c *middlewareConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File middleware_test.go tests the code in middleware.go
+
+package zoom
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	log := []string{}
+	logger := func(prefix string) Middleware {
+		return func(next CommandFunc) CommandFunc {
+			return func(name string, args ...interface{}) (interface{}, error) {
+				log = append(log, prefix+" before "+name)
+				reply, err := next(name, args...)
+				log = append(log, prefix+" after "+name)
+				return reply, err
+			}
+		}
+	}
+	pool.Use(logger("a"), logger("b"))
+
+	// Commands sent with Do should pass through the middleware in order
+	require.NoError(t, pool.Ping())
+	assert.Equal(t, []string{"a before PING", "b before PING", "b after PING", "a after PING"}, log)
+
+	// So should pipelined commands in transactions
+	log = []string{}
+	tx := pool.NewTransaction()
+	var value string
+	tx.Command("SET", redis.Args{"foo", "bar"}, nil)
+	tx.Command("GET", redis.Args{"foo"}, NewScanStringHandler(&value))
+	require.NoError(t, tx.Exec())
+	assert.Equal(t, "bar", value)
+	names := []string{}
+	for _, entry := range log {
+		if strings.HasPrefix(entry, "a before ") {
+			names = append(names, strings.TrimPrefix(entry, "a before "))
+		}
+	}
+	assert.Equal(t, []string{"MULTI", "SET", "GET", "EXEC"}, names)
+}
+
+func TestMiddlewareFaultInjection(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	expectedErr := errors.New("injected fault")
+	pool.Use(func(next CommandFunc) CommandFunc {
+		return func(name string, args ...interface{}) (interface{}, error) {
+			if name == "SET" {
+				return nil, expectedErr
+			}
+			return next(name, args...)
+		}
+	})
+	tx := pool.NewTransaction()
+	tx.Command("SET", redis.Args{"foo", "bar"}, nil)
+	assert.Equal(t, expectedErr, tx.Exec())
+
+	// The command should not have been sent
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	exists, err := redis.Bool(conn.Do("EXISTS", "foo"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
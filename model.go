@@ -47,13 +47,62 @@ func (r *RandomID) SetModelID(id string) {
 	r.ID = id
 }
 
+// Timestamps can be embedded in any model struct in order to automatically
+// keep track of when the model was created and last updated. CreatedAt is set
+// the first time the model is saved (i.e. whenever it is zero) and UpdatedAt is
+// set every time the model is saved with Save or SaveFields. Both fields hold
+// the number of nanoseconds since the Unix epoch and have a numeric index, so
+// they can be used with Order and Filter. By default the local clock is used.
+// To use the clock of the Redis server instead, set UseRedisTime in
+// CollectionOptions. Timestamps must be embedded by value, not as a pointer.
+type Timestamps struct {
+	CreatedAt int64 `zoom:"index"`
+	UpdatedAt int64 `zoom:"index"`
+}
+
+// timestamped is satisfied by any model which embeds Timestamps.
+type timestamped interface {
+	timestamps() *Timestamps
+}
+
+// timestamps returns ts, satisfying the timestamped interface.
+func (ts *Timestamps) timestamps() *Timestamps {
+	return ts
+}
+
 // modelSpec contains parsed information about a particular type of model.
 type modelSpec struct {
 	typ          reflect.Type
 	name         string
 	fieldsByName map[string]*fieldSpec
 	fields       []*fieldSpec
-	fallback     MarshalerUnmarshaler
+	keyFields    []*fieldSpec
+	// computedFields are the fields whose values are returned by a method of
+	// the model (see ComputedIndexPrefix) instead of read from a struct field.
+	computedFields []*fieldSpec
+	fallback       MarshalerUnmarshaler
+	// useJSONTags is true iff the json struct tag is used for the redis name of
+	// a field which does not have a redis tag (see
+	// CollectionOptions.UseJSONTags).
+	useJSONTags bool
+	// generated is true iff the model type implements GeneratedFields.
+	generated bool
+	// primary is the field with the primary option of the zoom struct tag,
+	// whose value is used as the id of the model (see NaturalID), or nil if
+	// there is none.
+	primary *fieldSpec
+	// indexNamespace is true iff the keys of the field indexes include the
+	// index namespace (see CollectionOptions.IndexNamespace).
+	indexNamespace bool
+	// newModel and releaseModel allocate and recycle the models which are
+	// scanned into slices (see CollectionOptions.NewModel).
+	newModel     func() Model
+	releaseModel func(Model)
+	// document is true iff the fields of the models are stored as a single
+	// document (see DocumentLayout), and json is true iff the document is
+	// stored with RedisJSON (see JSONLayout).
+	document bool
+	json     bool
 }
 
 // fieldSpec contains parsed information about a particular field.
@@ -63,20 +112,92 @@ type fieldSpec struct {
 	redisName string
 	typ       reflect.Type
 	indexKind indexKind
+	elem      *fieldSpec
+	fullText  *fullTextOptions
+	// enum is the list of allowed values for an enum field (see the enum option
+	// of the zoom struct tag), which are stored as their position in the list.
+	enum []string
+	// bitmap is true iff the field also has a bitmap index (see the bitmap
+	// option of the zoom struct tag), which is used for equality filters.
+	bitmap bool
+	// method is the name of the method which returns the value of a computed
+	// field, or an empty string for all other fields.
+	method string
+	// readonly is true iff the field can only be written when it is first saved
+	// (see the readonly option of the zoom struct tag). After that, Save and
+	// SaveFields keep the stored value.
+	readonly bool
+	// writeonce is true iff the field cannot be changed after it is first saved
+	// (see the writeonce option of the zoom struct tag). After that, Save and
+	// SaveFields return an error if the value is different.
+	writeonce bool
+	// lazy is true iff the field is not read by Find, FindAll, or queries unless
+	// it is requested explicitly (see the lazy option of the zoom struct tag).
+	lazy bool
+	// collate is the function which transforms the values of a field with a
+	// string index before they are added to the index (see
+	// CollectionOptions.Collations), or nil if the values are indexed as they
+	// are.
+	collate CollationFunc
+	// ttl is how long the field is kept in the main hash after it is written
+	// (see the ttl option of the zoom struct tag), or 0 if it does not expire.
+	ttl time.Duration
+	// index is the index sequence of the struct field in the model type (see
+	// reflect.Value.FieldByIndex), which is computed once so that reading and
+	// writing the field does not require a lookup by name. It is nil for
+	// computed fields and the fields of dynamic collections.
+	index []int
+	// encoding is the way values of an inconvertible field (or of its
+	// elements) are encoded, which is computed once by compileEncodings so that
+	// saving a model does not need to check which interfaces the type
+	// implements.
+	encoding interfaceEncoding
+	// ref describes the collection whose ids are stored in the field (see the
+	// ref option of the zoom struct tag), or is nil if the field does not
+	// reference another collection.
+	ref *reference
+}
+
+// isProtected returns true iff the field has the readonly or writeonce option.
+func (fs *fieldSpec) isProtected() bool {
+	return fs.readonly || fs.writeonce
 }
 
 // fieldKind is the kind of a particular field, and is either a primitive,
-// a pointer, or an inconvertible.
+// a pointer, an inconvertible, a hash, a list, or a set.
 type fieldKind int
 
 const (
 	primativeField     fieldKind = iota // any primitive type
 	pointerField                        // pointer to any primitive type
 	inconvertibleField                  // all other types
+	hashField                           // map stored in its own hash
+	listField                           // slice stored in its own list
+	setField                            // slice stored in its own set
 )
 
+// isKeyField returns true iff fields of kind fk are stored in their own key
+// instead of in the main hash for the model.
+func (fk fieldKind) isKeyField() bool {
+	return fk == hashField || fk == listField || fk == setField
+}
+
+// fieldKindForType returns the kind of a field (or an element of a field)
+// with the given type. It only ever returns primativeField, pointerField, or
+// inconvertibleField.
+func fieldKindForType(typ reflect.Type) fieldKind {
+	switch {
+	case typeIsPrimative(typ):
+		return primativeField
+	case typ.Kind() == reflect.Ptr && typeIsPrimative(typ.Elem()):
+		return pointerField
+	default:
+		return inconvertibleField
+	}
+}
+
 // indexKind is the kind of an index, and is either noIndex, numericIndex,
-// stringIndex, or booleanIndex.
+// stringIndex, booleanIndex, integerIndex, or enumIndex.
 type indexKind int
 
 const (
@@ -84,19 +205,140 @@ const (
 	numericIndex
 	stringIndex
 	booleanIndex
+	// integerIndex is used for int64 and uint64 fields. It has the same
+	// structure as a string index, but the values are encoded with
+	// integerIndexValue so that they sort in numerical order without losing
+	// precision.
+	integerIndex
+	// enumIndex is used for enum fields. It is a sorted set where the score of
+	// each id is the position of the value in the list of allowed values, along
+	// with a set of ids for each value (see enumIndexKey).
+	enumIndex
 )
 
+func (ik indexKind) String() string {
+	switch ik {
+	case noIndex:
+		return "none"
+	case numericIndex:
+		return "numeric"
+	case stringIndex:
+		return "string"
+	case booleanIndex:
+		return "boolean"
+	case integerIndex:
+		return "integer"
+	case enumIndex:
+		return "enum"
+	}
+	return ""
+}
+
 // compilesModelSpec examines typ using reflection, parses its fields,
 // and returns a modelSpec.
 func compileModelSpec(typ reflect.Type) (*modelSpec, error) {
+	return compileModelSpecWithOptions(typ, DefaultCollectionOptions)
+}
+
+// compileModelSpecWithOptions is like compileModelSpec but takes into account
+// the options of the collection which affect how fields are parsed.
+func compileModelSpecWithOptions(typ reflect.Type, options CollectionOptions) (*modelSpec, error) {
 	ms := &modelSpec{
 		name:         getDefaultModelSpecName(typ),
 		fieldsByName: map[string]*fieldSpec{},
 		typ:          typ,
+		useJSONTags:  options.UseJSONTags,
+		generated:    typ.Implements(generatedFieldsType),
+	}
+	if err := ms.compileFields(typ.Elem(), nil, "", ""); err != nil {
+		return nil, err
+	}
+	if err := ms.compileComputedFields(); err != nil {
+		return nil, err
 	}
+	if err := ms.checkPrimaryField(); err != nil {
+		return nil, err
+	}
+	ms.compileEncodings()
+	return ms, nil
+}
+
+// ComputedIndexPrefix is the prefix for the names of the methods which declare
+// a computed index. A model can have an index on a derived value (e.g. a
+// normalized version of another field) by declaring a method with no arguments
+// which returns a single value of a primitive type or a pointer to a primitive
+// type. The name of the method without the prefix becomes the name of the
+// computed field, which can be used with Order and Filter like any other
+// indexed field. For example:
+//
+//	func (p *Person) ZoomIndex_EmailDomain() string {
+//	  return strings.ToLower(p.Email[strings.LastIndex(p.Email, "@")+1:])
+//	}
+//
+// The method is called each time the model is saved with Save, and also with
+// SaveFields if the name of the computed field is one of the given field
+// names. The value of a computed field is stored in the main hash alongside
+// the other fields, so that the index can be kept in sync when the model is
+// updated or deleted, but it is never read back into the model.
+const ComputedIndexPrefix = "ZoomIndex_"
 
-	// Iterate through fields
-	elem := typ.Elem()
+// compileComputedFields adds a computed field with an index to ms for each
+// method of ms.typ whose name has the ComputedIndexPrefix. It returns an error
+// if the method does not have the right signature or if the name of the
+// computed field collides with another field.
+func (ms *modelSpec) compileComputedFields() error {
+	for i := 0; i < ms.typ.NumMethod(); i++ {
+		method := ms.typ.Method(i)
+		if !strings.HasPrefix(method.Name, ComputedIndexPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(method.Name, ComputedIndexPrefix)
+		// The receiver counts as the first input.
+		if name == "" || method.Type.NumIn() != 1 || method.Type.NumOut() != 1 {
+			return fmt.Errorf("zoom: computed index method %s of type %s must not have any arguments and must return exactly one value", method.Name, ms.typ.String())
+		}
+		outType := method.Type.Out(0)
+		fs := &fieldSpec{
+			kind:      fieldKindForType(outType),
+			name:      name,
+			redisName: name,
+			typ:       outType,
+			method:    method.Name,
+		}
+		if fs.kind == inconvertibleField {
+			return fmt.Errorf("zoom: computed index method %s of type %s must return a primitive type or a pointer to a primitive type", method.Name, ms.typ.String())
+		}
+		indexType := outType
+		if fs.kind == pointerField {
+			indexType = outType.Elem()
+		}
+		if err := setIndexKind(fs, indexType); err != nil {
+			return err
+		}
+		for _, other := range ms.fieldsWithComputed() {
+			if other.name == fs.name || other.redisName == fs.redisName {
+				return fmt.Errorf("zoom: computed field %s in type %s has the same name as another field", fs.name, ms.typ.String())
+			}
+		}
+		for _, other := range ms.keyFields {
+			if other.name == fs.name || other.redisName == fs.redisName {
+				return fmt.Errorf("zoom: computed field %s in type %s has the same name as another field", fs.name, ms.typ.String())
+			}
+		}
+		ms.fieldsByName[fs.name] = fs
+		ms.computedFields = append(ms.computedFields, fs)
+	}
+	return nil
+}
+
+// compileFields parses the fields of the struct type elem and adds them to ms.
+// index is the index sequence of elem within the model struct (empty for the
+// model struct itself), and namePrefix and redisPrefix are prepended to the
+// name and redis name of each field. compileFields calls itself recursively
+// for embedded structs that have the `zoom:"inline"` struct tag and struct
+// fields that have the `zoom:"nested"` struct tag, so that their fields are
+// flattened into the parent model.
+func (ms *modelSpec) compileFields(elem reflect.Type, index []int, namePrefix string, redisPrefix string) error {
 	numFields := elem.NumField()
 	for i := 0; i < numFields; i++ {
 		field := elem.Field(i)
@@ -109,8 +351,13 @@ func compileModelSpec(typ reflect.Type) (*modelSpec, error) {
 			continue
 		}
 
-		// Skip the RandomID field
-		if field.Type == reflect.TypeOf(RandomID{}) {
+		// Skip the RandomID and NaturalID fields
+		if field.Type == reflect.TypeOf(RandomID{}) || field.Type == reflect.TypeOf(NaturalID{}) {
+			continue
+		}
+
+		// Skip the ChangeTracking field, which is not stored in the database
+		if field.Type == reflect.TypeOf(ChangeTracking{}) {
 			continue
 		}
 
@@ -120,56 +367,352 @@ func compileModelSpec(typ reflect.Type) (*modelSpec, error) {
 		if redisTag == "-" {
 			continue // skip field
 		}
-		fs := &fieldSpec{name: field.Name, typ: field.Type}
-		ms.fieldsByName[fs.name] = fs
-		ms.fields = append(ms.fields, fs)
-		if redisTag != "" {
-			fs.redisName = redisTag
-		} else {
-			fs.redisName = fs.name
-		}
 
-		// Parse the "zoom" tag (currently only "index" is supported)
+		// Parse the "zoom" tag
 		zoomTag := tag.Get("zoom")
 		shouldIndex := false
+		shouldInline := false
+		shouldNest := false
+		shouldHash := false
+		shouldList := false
+		shouldSet := false
+		shouldBitmap := false
+		shouldBeReadonly := false
+		shouldBeWriteonce := false
+		shouldBeLazy := false
+		shouldBePrimary := false
+		var enumValues []string
+		var ttl time.Duration
+		var ref *reference
+		var onDelete *OnDelete
+		var fullText *fullTextOptions
+		shouldStem := false
+		shouldRemoveStopWords := false
 		if zoomTag != "" {
 			options := strings.Split(zoomTag, ",")
 			for _, op := range options {
 				switch op {
 				case "index":
 					shouldIndex = true
+				case "inline":
+					shouldInline = true
+				case "nested":
+					shouldNest = true
+				case "hash":
+					shouldHash = true
+				case "list":
+					shouldList = true
+				case "set":
+					shouldSet = true
+				case "bitmap":
+					shouldBitmap = true
+				case "readonly":
+					shouldBeReadonly = true
+				case "writeonce":
+					shouldBeWriteonce = true
+				case "lazy":
+					shouldBeLazy = true
+				case "primary":
+					shouldBePrimary = true
+				case "fulltext":
+					fullText = &fullTextOptions{}
+				case "stem":
+					shouldStem = true
+				case "stopwords":
+					shouldRemoveStopWords = true
 				default:
-					return nil, fmt.Errorf("zoom: unrecognized option specified in struct tag: %s", op)
+					if strings.HasPrefix(op, "enum=") {
+						values, err := parseEnumValues(field.Name, strings.TrimPrefix(op, "enum="))
+						if err != nil {
+							return err
+						}
+						enumValues = values
+						continue
+					}
+					if strings.HasPrefix(op, "ttl=") {
+						fieldTTL, err := parseFieldTTL(field.Name, strings.TrimPrefix(op, "ttl="))
+						if err != nil {
+							return err
+						}
+						ttl = fieldTTL
+						continue
+					}
+					if strings.HasPrefix(op, "ref=") {
+						collectionName := strings.TrimPrefix(op, "ref=")
+						if collectionName == "" {
+							return fmt.Errorf("zoom: ref option for field %s must specify the name of a collection", field.Name)
+						}
+						ref = &reference{collectionName: collectionName}
+						continue
+					}
+					if strings.HasPrefix(op, "ondelete=") {
+						od, err := parseOnDelete(field.Name, strings.TrimPrefix(op, "ondelete="))
+						if err != nil {
+							return err
+						}
+						onDelete = &od
+						continue
+					}
+					return fmt.Errorf("zoom: unrecognized option specified in struct tag: %s", op)
 				}
 			}
 		}
 
+		if fullText != nil {
+			fullText.stem = shouldStem
+			fullText.stopWords = shouldRemoveStopWords
+			if !typeIsFullTextSearchable(field.Type) {
+				return fmt.Errorf("zoom: fulltext option can only be used on string fields but %s is %s", field.Name, field.Type)
+			}
+		} else if shouldStem || shouldRemoveStopWords {
+			return fmt.Errorf("zoom: stem and stopwords options can only be used together with the fulltext option (on field %s)", field.Name)
+		}
+		if onDelete != nil {
+			if ref == nil {
+				return fmt.Errorf("zoom: ondelete option can only be used together with the ref option (on field %s)", field.Name)
+			}
+			ref.onDelete = *onDelete
+		}
+		if ref != nil {
+			if field.Type.Kind() != reflect.String {
+				return fmt.Errorf("zoom: ref option can only be used on string fields but %s is %s", field.Name, field.Type)
+			}
+			if shouldInline || shouldHash || shouldList || shouldSet || shouldBitmap || fullText != nil || enumValues != nil || shouldBePrimary {
+				return fmt.Errorf("zoom: ref option cannot be combined with the inline, hash, list, set, bitmap, fulltext, enum, or primary options (on field %s)", field.Name)
+			}
+			// The models which reference a model are found with the string
+			// index, so ref fields are always indexed.
+			shouldIndex = true
+		}
+
+		if enumValues != nil {
+			if field.Type.Kind() != reflect.String {
+				return fmt.Errorf("zoom: enum option can only be used on string fields but %s is %s", field.Name, field.Type)
+			}
+			if shouldInline || shouldHash || shouldList || shouldSet || fullText != nil {
+				return fmt.Errorf("zoom: enum option cannot be combined with the inline, hash, list, set, or fulltext options (on field %s)", field.Name)
+			}
+			// Enum fields are always indexed.
+			shouldIndex = true
+		}
+		if shouldBePrimary {
+			if field.Type.Kind() != reflect.String {
+				return fmt.Errorf("zoom: primary option can only be used on string fields but %s is %s", field.Name, field.Type)
+			}
+			if shouldInline || shouldHash || shouldList || shouldSet || shouldBeLazy || shouldBeReadonly {
+				return fmt.Errorf("zoom: primary option cannot be combined with the inline, hash, list, set, lazy, or readonly options (on field %s)", field.Name)
+			}
+			if ms.primary != nil {
+				return fmt.Errorf("zoom: only one field can have the primary option but both %s and %s have it", ms.primary.name, field.Name)
+			}
+			// The id of a model cannot change, so neither can its primary field.
+			shouldBeWriteonce = true
+		}
+		if shouldBeReadonly || shouldBeWriteonce {
+			if shouldBeReadonly && shouldBeWriteonce {
+				return fmt.Errorf("zoom: readonly and writeonce options cannot be used together (on field %s)", field.Name)
+			}
+			if shouldInline || shouldHash || shouldList || shouldSet {
+				return fmt.Errorf("zoom: readonly and writeonce options cannot be combined with the inline, hash, list, or set options (on field %s)", field.Name)
+			}
+		}
+		if shouldBeLazy && (shouldInline || shouldHash || shouldList || shouldSet) {
+			return fmt.Errorf("zoom: lazy option cannot be combined with the inline, hash, list, or set options (on field %s)", field.Name)
+		}
+		if ttl > 0 && (shouldIndex || shouldInline || shouldNest || shouldHash || shouldList || shouldSet || fullText != nil || shouldBePrimary || shouldBeReadonly || shouldBeWriteonce) {
+			return fmt.Errorf("zoom: ttl option cannot be combined with the index, enum, inline, nested, hash, list, set, fulltext, primary, readonly, or writeonce options (on field %s)", field.Name)
+		}
+		if shouldBitmap && !shouldIndex {
+			return fmt.Errorf("zoom: bitmap option can only be used together with the index option (on field %s)", field.Name)
+		}
+
+		// An embedded Timestamps struct is always inlined
+		if field.Anonymous && field.Type == reflect.TypeOf(Timestamps{}) {
+			shouldInline = true
+		}
+
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		if shouldNest {
+			// Flatten the fields of the struct into the parent, using the path
+			// to each field (e.g. Address.City) as its name. The redis name of
+			// the struct field is used as the prefix for the redis names.
+			if field.Type.Kind() != reflect.Struct {
+				return fmt.Errorf("zoom: nested option can only be used on struct fields but %s is %s", field.Name, field.Type)
+			}
+			if zoomTag != "nested" {
+				return fmt.Errorf("zoom: nested option cannot be combined with other options (on field %s)", field.Name)
+			}
+			nestedRedisName := field.Name
+			if redisTag != "" {
+				nestedRedisName = redisTag
+			} else if jsonName := jsonTagName(tag); ms.useJSONTags && jsonName != "" {
+				nestedRedisName = jsonName
+			}
+			if err := ms.compileFields(field.Type, fieldIndex, namePrefix+field.Name+".", redisPrefix+nestedRedisName+"."); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if shouldInline {
+			// Flatten the fields of the embedded struct into the parent. If
+			// present, the "redis" tag is used as a prefix for the redis names of
+			// the embedded fields.
+			if !field.Anonymous || field.Type.Kind() != reflect.Struct {
+				return fmt.Errorf("zoom: inline option can only be used on embedded structs but %s is %s", field.Name, field.Type)
+			}
+			if shouldIndex {
+				return fmt.Errorf("zoom: index and inline options cannot be used together (on field %s)", field.Name)
+			}
+			if err := ms.compileFields(field.Type, fieldIndex, namePrefix, redisPrefix+redisTag); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fs := &fieldSpec{
+			name:      namePrefix + field.Name,
+			typ:       field.Type,
+			fullText:  fullText,
+			readonly:  shouldBeReadonly,
+			writeonce: shouldBeWriteonce,
+			lazy:      shouldBeLazy,
+			ttl:       ttl,
+			index:     fieldIndex,
+			ref:       ref,
+		}
+		if shouldBePrimary {
+			ms.primary = fs
+		}
+		if len(index) > 0 && namePrefix == "" {
+			// Fields of inlined structs are accessed as promoted fields (via
+			// FieldByName), so make sure the name is not ambiguous or shadowed.
+			promoted, found := ms.typ.Elem().FieldByName(field.Name)
+			if !found || !reflect.DeepEqual(promoted.Index, fieldIndex) {
+				return fmt.Errorf("zoom: inlined field %s in type %s is ambiguous or shadowed by another field", field.Name, ms.typ.String())
+			}
+		}
+		if redisTag != "" {
+			fs.redisName = redisPrefix + redisTag
+		} else if jsonName := jsonTagName(tag); ms.useJSONTags && jsonName != "" {
+			fs.redisName = redisPrefix + jsonName
+		} else {
+			fs.redisName = redisPrefix + field.Name
+		}
+		if shouldList || shouldSet {
+			// Slice stored in its own list or set
+			if field.Type.Kind() != reflect.Slice || !typeIsSliceOrArray(field.Type) {
+				return fmt.Errorf("zoom: list and set options can only be used on slices but %s is %s", field.Name, field.Type)
+			}
+			if shouldIndex || shouldHash || (shouldList && shouldSet) {
+				return fmt.Errorf("zoom: list and set options cannot be combined with other options (on field %s)", field.Name)
+			}
+			if shouldList {
+				fs.kind = listField
+			} else {
+				fs.kind = setField
+			}
+			fs.elem = &fieldSpec{
+				kind: fieldKindForType(field.Type.Elem()),
+				name: fs.name,
+				typ:  field.Type.Elem(),
+			}
+			if err := ms.addField(fs); err != nil {
+				return err
+			}
+			continue
+		}
+		if shouldHash {
+			// Map stored in its own hash
+			if field.Type.Kind() != reflect.Map || field.Type.Key().Kind() != reflect.String {
+				return fmt.Errorf("zoom: hash option can only be used on maps with string keys but %s is %s", field.Name, field.Type)
+			}
+			if shouldIndex {
+				return fmt.Errorf("zoom: index and hash options cannot be used together (on field %s)", field.Name)
+			}
+			fs.kind = hashField
+			fs.elem = &fieldSpec{
+				kind: fieldKindForType(field.Type.Elem()),
+				name: fs.name,
+				typ:  field.Type.Elem(),
+			}
+			if err := ms.addField(fs); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := ms.addField(fs); err != nil {
+			return err
+		}
+
 		// Detect the kind of the field and (if applicable) the kind of the index
 		if typeIsPrimative(field.Type) {
 			// Primitive
 			fs.kind = primativeField
 			if shouldIndex {
 				if err := setIndexKind(fs, field.Type); err != nil {
-					return nil, err
+					return err
+				}
+			}
+			if enumValues != nil {
+				fs.enum = enumValues
+				fs.indexKind = enumIndex
+			}
+			if shouldBitmap {
+				if !typeIsBitmapIndexable(field.Type) {
+					return fmt.Errorf("zoom: bitmap option can only be used on bool, integer, and string fields but %s is %s", field.Name, field.Type)
 				}
+				fs.bitmap = true
 			}
+		} else if shouldBitmap {
+			return fmt.Errorf("zoom: bitmap option can only be used on bool, integer, and string fields but %s is %s", field.Name, field.Type)
 		} else if field.Type.Kind() == reflect.Ptr && typeIsPrimative(field.Type.Elem()) {
 			// Pointer to a primitive
 			fs.kind = pointerField
 			if shouldIndex {
 				if err := setIndexKind(fs, field.Type.Elem()); err != nil {
-					return nil, err
+					return err
 				}
 			}
 		} else {
-			// All other types are considered inconvertible
+			// All other types are considered inconvertible. They can only be
+			// indexed if they implement Scorer.
 			if shouldIndex {
-				return nil, fmt.Errorf("zoom: Requested index on unsupported type %s", field.Type)
+				if !typeIsScorer(field.Type) {
+					return fmt.Errorf("zoom: Requested index on unsupported type %s", field.Type)
+				}
+				fs.indexKind = numericIndex
 			}
 			fs.kind = inconvertibleField
 		}
 	}
-	return ms, nil
+	return nil
+}
+
+// addField adds fs to ms. Fields which are stored in their own key are added
+// to ms.keyFields and all other fields are added to ms.fields and
+// ms.fieldsByName. It returns an error if ms already has a field with the same
+// name or redis name, which can happen when the fields of an inlined struct
+// collide with other fields.
+func (ms *modelSpec) addField(fs *fieldSpec) error {
+	for _, other := range append(ms.fields, ms.keyFields...) {
+		if other.name == fs.name {
+			return fmt.Errorf("zoom: duplicate field name %s in type %s", fs.name, ms.typ.String())
+		}
+		if other.redisName == fs.redisName {
+			return fmt.Errorf("zoom: duplicate redis name %s in type %s", fs.redisName, ms.typ.String())
+		}
+	}
+	if fs.kind.isKeyField() {
+		ms.keyFields = append(ms.keyFields, fs)
+		return nil
+	}
+	ms.fieldsByName[fs.name] = fs
+	ms.fields = append(ms.fields, fs)
+	return nil
 }
 
 // getDefaultModelSpecName returns the default name for the given type, which is
@@ -188,6 +731,8 @@ func getDefaultModelSpecName(typ reflect.Type) string {
 // setIndexKind sets the indexKind field of fs based on fieldType.
 func setIndexKind(fs *fieldSpec, fieldType reflect.Type) error {
 	switch {
+	case typeIsInteger64(fieldType):
+		fs.indexKind = integerIndex
 	case typeIsNumeric(fieldType):
 		fs.indexKind = numericIndex
 	case typeIsString(fieldType):
@@ -216,6 +761,25 @@ func (ms *modelSpec) modelKey(id string) (string, error) {
 	return ms.name + ":" + id, nil
 }
 
+// keyFieldByName returns the spec for the field with the given name which is
+// stored in its own key (e.g. a map stored in its own hash). It returns false
+// if there is no such field.
+func (ms *modelSpec) keyFieldByName(name string) (*fieldSpec, bool) {
+	for _, fs := range ms.keyFields {
+		if fs.name == name {
+			return fs, true
+		}
+	}
+	return nil, false
+}
+
+// fieldKey returns the key for the field described by fs for the model with
+// the given id. It is only used for fields which are stored in their own key
+// rather than in the main hash.
+func (ms *modelSpec) fieldKey(id string, fs *fieldSpec) string {
+	return ms.name + ":" + id + ":" + fs.redisName
+}
+
 // fieldNames returns all the field names for the given modelSpec
 func (ms modelSpec) fieldNames() []string {
 	names := make([]string, len(ms.fields))
@@ -227,6 +791,57 @@ func (ms modelSpec) fieldNames() []string {
 	return names
 }
 
+// jsonTagName returns the name in the json struct tag of a field, or an empty
+// string if the tag does not specify a name (e.g. `json:",omitempty"`) or the
+// field is ignored by encoding/json (`json:"-"`).
+func jsonTagName(tag reflect.StructTag) string {
+	name := strings.Split(tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// defaultFieldNames returns the names of the fields in the main hash which are
+// read by default, i.e. all of them except the lazy fields.
+func (ms modelSpec) defaultFieldNames() []string {
+	names := []string{}
+	for _, field := range ms.fields {
+		if !field.lazy {
+			names = append(names, field.name)
+		}
+	}
+	return names
+}
+
+// fieldsWithComputed returns the fields which are stored in the main hash,
+// followed by the computed fields.
+func (ms *modelSpec) fieldsWithComputed() []*fieldSpec {
+	fields := make([]*fieldSpec, 0, len(ms.fields)+len(ms.computedFields))
+	fields = append(fields, ms.fields...)
+	return append(fields, ms.computedFields...)
+}
+
+// fieldNamesWithComputed returns the names of the fields which are stored in
+// the main hash, followed by the names of the computed fields.
+func (ms modelSpec) fieldNamesWithComputed() []string {
+	names := ms.fieldNames()
+	for _, fs := range ms.computedFields {
+		names = append(names, fs.name)
+	}
+	return names
+}
+
+// allFieldNames returns the names of all the fields for the given modelSpec,
+// including the fields which are stored in their own key.
+func (ms modelSpec) allFieldNames() []string {
+	names := ms.fieldNames()
+	for _, fs := range ms.keyFields {
+		names = append(names, fs.name)
+	}
+	return names
+}
+
 // fieldRedisNames returns all the redis names (which might be custom names specified via
 // the `redis:"custonName"` struct tag) for each field in the given modelSpec
 func (ms modelSpec) fieldRedisNames() []string {
@@ -244,7 +859,7 @@ func (ms modelSpec) redisNamesForFieldNames(fieldNames []string) ([]string, erro
 	for _, fieldName := range fieldNames {
 		fs, found := ms.fieldsByName[fieldName]
 		if !found {
-			return nil, fmt.Errorf("Type %s has no field named %s", ms.typ.Name(), fieldName)
+			return nil, newKindError(ErrFieldNotFound, "Type %s has no field named %s", ms.typ.Name(), fieldName)
 		}
 		redisNames = append(redisNames, fs.redisName)
 	}
@@ -257,11 +872,50 @@ func (ms modelSpec) redisNamesForFieldNames(fieldNames []string) ([]string, erro
 func (ms *modelSpec) fieldIndexKey(fieldName string) (string, error) {
 	fs, found := ms.fieldsByName[fieldName]
 	if !found {
-		return "", fmt.Errorf("Type %s has no field named %s", ms.typ.Name(), fieldName)
+		return "", newKindError(ErrFieldNotFound, "Type %s has no field named %s", ms.typ.Name(), fieldName)
 	} else if fs.indexKind == noIndex {
-		return "", fmt.Errorf("%s.%s is not an indexed field", ms.typ.Name(), fieldName)
+		return "", newKindError(ErrUnindexedField, "%s.%s is not an indexed field", ms.typ.Name(), fieldName)
 	}
-	return ms.name + ":" + fs.redisName, nil
+	return ms.indexKeyForField(fs), nil
+}
+
+// indexKeyPrefix returns the prefix of the keys of all the field indexes of
+// the collection, which is followed by the name of the field as it is stored in
+// Redis. It is "<name>:index:" if the collection uses the index namespace (see
+// CollectionOptions.IndexNamespace) and "<name>:" otherwise.
+func (ms *modelSpec) indexKeyPrefix() string {
+	if ms.indexNamespace {
+		return ms.name + ":index:"
+	}
+	return ms.name + ":"
+}
+
+// indexKeyForField returns the key for the sorted set used to index fs. The
+// keys of any other sets which belong to the index (e.g. the null index) start
+// with it.
+func (ms *modelSpec) indexKeyForField(fs *fieldSpec) string {
+	return ms.indexKeyPrefix() + fs.redisName
+}
+
+// nullIndexKey returns the key for the set which contains the ids of all the
+// models for which the indexed pointer field fs is nil.
+func (ms *modelSpec) nullIndexKey(fs *fieldSpec) string {
+	return ms.indexKeyForField(fs) + ":null"
+}
+
+// stringMembersKey returns the key for the hash which maps the id of each model
+// to its member in the string (or integer) index on fs. It lets
+// SyncModelIndexes find the old member of a model after its hash was modified
+// outside of Zoom without scanning the whole index. Collated string indexes use
+// collatedMembersKey instead.
+func (ms *modelSpec) stringMembersKey(fs *fieldSpec) string {
+	return ms.indexKeyForField(fs) + ":members"
+}
+
+// hasNullIndex returns true iff fs is an indexed pointer field, i.e. a field for
+// which Zoom maintains a null index.
+func (fs *fieldSpec) hasNullIndex() bool {
+	return fs.kind == pointerField && fs.indexKind != noIndex
 }
 
 // sortArgs returns arguments that can be used to get all the fields in includeFields
@@ -271,11 +925,21 @@ func (ms *modelSpec) fieldIndexKey(fieldName string) (string, error) {
 // option will be added to the arguments with the given limit and offset. setKey must
 // be the key of a set or a sorted set which consists of model ids. The arguments
 // use they "BY nosort" option, so if a specific order is required, the setKey should be
-// a sorted set.
+// a sorted set. For the document layout, the document is retrieved instead of
+// the fields, so the reply should be passed to the handler returned by
+// sortHandler. For the JSON layout, only the ids are retrieved, so the command
+// should be added with sortModels.
 func (ms *modelSpec) sortArgs(idsKey string, redisFieldNames []string, limit int, offset uint, reverse bool) redis.Args {
 	args := redis.Args{idsKey, "BY", "nosort"}
-	for _, fieldName := range redisFieldNames {
-		args = append(args, "GET", ms.name+":*->"+fieldName)
+	switch {
+	case ms.json:
+		// SORT cannot get RedisJSON documents, so they are read by sortModels.
+	case ms.document && len(redisFieldNames) > 0:
+		args = append(args, "GET", ms.name+":*->"+documentField)
+	default:
+		for _, fieldName := range redisFieldNames {
+			args = append(args, "GET", ms.name+":*->"+fieldName)
+		}
 	}
 	// We always want to get the id
 	args = append(args, "GET", "#")
@@ -294,7 +958,7 @@ func (ms *modelSpec) sortArgs(idsKey string, redisFieldNames []string, limit int
 // corresponds to modelSpec.
 func (ms *modelSpec) checkModelType(model Model) error {
 	if reflect.TypeOf(model) != ms.typ {
-		return fmt.Errorf("model was the wrong type. Expected %s but got %T", ms.typ.String(), model)
+		return newKindError(ErrWrongModelType, "model was the wrong type. Expected %s but got %T", ms.typ.String(), model)
 	}
 	return nil
 }
@@ -303,17 +967,17 @@ func (ms *modelSpec) checkModelType(model Model) error {
 // registered type that corresponds to modelSpec.
 func (ms *modelSpec) checkModelsType(models interface{}) error {
 	if reflect.TypeOf(models).Kind() != reflect.Ptr {
-		return fmt.Errorf("models should be a pointer to a slice or array of models")
+		return newKindError(ErrWrongModelType, "models should be a pointer to a slice or array of models")
 	}
 	modelsVal := reflect.ValueOf(models).Elem()
 	elemType := modelsVal.Type().Elem()
 	switch {
 	case !typeIsSliceOrArray(modelsVal.Type()):
-		return fmt.Errorf("models should be a pointer to a slice or array of models")
+		return newKindError(ErrWrongModelType, "models should be a pointer to a slice or array of models")
 	case !typeIsPointerToStruct(elemType):
-		return fmt.Errorf("the elements in models should be pointers to structs")
+		return newKindError(ErrWrongModelType, "the elements in models should be pointers to structs")
 	case elemType != ms.typ:
-		return fmt.Errorf("models were the wrong type. Expected slice or array of %s but got %T", ms.typ.String(), models)
+		return newKindError(ErrWrongModelType, "models were the wrong type. Expected slice or array of %s but got %T", ms.typ.String(), models)
 	}
 	return nil
 }
@@ -325,6 +989,26 @@ type modelRef struct {
 	collection *Collection
 	model      Model
 	spec       *modelSpec
+	// values holds the field values of models which are not backed by a struct
+	// (e.g. in a DynamicCollection). It is nil for all other models. See
+	// newValuesModelRef.
+	values map[string]reflect.Value
+}
+
+// newValuesModelRef returns a modelRef for the model in c with the given id
+// which holds its field values in mr.values instead of a struct, with all of
+// the fields (except those stored in their own key) set to their zero values.
+func (c *Collection) newValuesModelRef(id string) *modelRef {
+	values := map[string]reflect.Value{}
+	for _, fs := range c.spec.fields {
+		values[fs.name] = reflect.New(fs.typ).Elem()
+	}
+	return &modelRef{
+		collection: c,
+		model:      &RandomID{ID: id},
+		spec:       c.spec,
+		values:     values,
+	}
 }
 
 // value is an alias for reflect.ValueOf(mr.model)
@@ -343,11 +1027,29 @@ func (mr *modelRef) elemValue() reflect.Value {
 	return mr.value().Elem()
 }
 
-// fieldValue is an alias for mr.elemValue().FieldByName(name). It panics if
-// the model behind mr does not have a field with the given name or if
-// the model is nil.
+// fieldValue is equivalent to mr.elemValue().FieldByName(name), but uses the
+// index of the field in the spec to avoid looking up the field by name. It
+// panics if the model is nil. For dynamic models, it returns the value from
+// mr.values instead, and for computed fields it returns the result of the
+// method.
 func (mr *modelRef) fieldValue(name string) reflect.Value {
-	return mr.elemValue().FieldByName(name)
+	if mr.values != nil {
+		return mr.values[name]
+	}
+	fs, found := mr.spec.fieldsByName[name]
+	if !found {
+		fs, found = mr.spec.keyFieldByName(name)
+	}
+	switch {
+	case !found:
+		return mr.elemValue().FieldByName(name)
+	case fs.method != "":
+		return mr.value().MethodByName(fs.method).Call(nil)[0]
+	case len(fs.index) == 1:
+		return mr.elemValue().Field(fs.index[0])
+	default:
+		return mr.elemValue().FieldByIndex(fs.index)
+	}
 }
 
 // key returns a key which is used in redis to store the model
@@ -358,53 +1060,185 @@ func (mr *modelRef) key() string {
 // mainHashArgs returns the args for the main hash for this model. Typically
 // these args should part of an HMSET command.
 func (mr *modelRef) mainHashArgs() (redis.Args, error) {
-	return mr.mainHashArgsForFields(mr.spec.fieldNames())
+	if len(mr.spec.computedFields) == 0 {
+		return mr.mainHashArgsForSpecs(mr.spec.fields)
+	}
+	return mr.mainHashArgsForSpecs(mr.spec.fieldsWithComputed())
 }
 
 // mainHashArgsForFields is like mainHashArgs but only returns the hash
 // fields which match the given fieldNames.
 func (mr *modelRef) mainHashArgsForFields(fieldNames []string) (redis.Args, error) {
-	args := redis.Args{mr.key()}
-	ms := mr.spec
-	for _, fs := range ms.fields {
+	fields := []*fieldSpec{}
+	for _, fs := range mr.spec.fieldsWithComputed() {
 		// Skip fields whose names do not appear in fieldNames.
-		if !stringSliceContains(fieldNames, fs.name) {
-			continue
+		if stringSliceContains(fieldNames, fs.name) {
+			fields = append(fields, fs)
 		}
-		fieldVal := mr.fieldValue(fs.name)
-		switch fs.kind {
-		case primativeField:
-			// Add a special case for time.Duration. By default, the redigo driver
-			// will fall back to fmt.Sprintf, but we want to save it as an int64 in
-			// this case.
-			if fs.typ == reflect.TypeOf(time.Duration(0)) {
-				args = args.Add(fs.redisName, int64(fieldVal.Interface().(time.Duration)))
-			} else {
-				args = args.Add(fs.redisName, fieldVal.Interface())
+	}
+	return mr.mainHashArgsForSpecs(fields)
+}
+
+// mainHashArgsForSpecs is like mainHashArgs but only returns the hash fields
+// described by fields.
+func (mr *modelRef) mainHashArgsForSpecs(fields []*fieldSpec) (redis.Args, error) {
+	args := make(redis.Args, 1, 1+2*len(fields))
+	args[0] = mr.key()
+	ms := mr.spec
+	generated := mr.generatedFields()
+	for _, fs := range fields {
+		if generated != nil && fs.usesGeneratedCode() {
+			if value, ok := generated.ZoomHashValue(fs.name); ok {
+				args = append(args, fs.redisName, value)
+				continue
 			}
-		case pointerField:
-			if !fieldVal.IsNil() {
-				args = args.Add(fs.redisName, fieldVal.Elem().Interface())
-			} else {
-				args = args.Add(fs.redisName, "NULL")
-			}
-		case inconvertibleField:
-			switch fieldVal.Type().Kind() {
-			// For nilable types that are nil store NULL
-			case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
-				if fieldVal.IsNil() {
-					args = args.Add(fs.redisName, "NULL")
-					continue
-				}
+		}
+		fieldVal := mr.fieldValue(fs.name)
+		value, err := ms.hashValue(fs, fieldVal)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fs.redisName, value)
+	}
+	return args, nil
+}
+
+// durationType is the type of time.Duration fields, which are stored as an
+// int64.
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// compileEncodings sets the encoding of each inconvertible field of ms and of
+// the inconvertible elements of its key fields.
+func (ms *modelSpec) compileEncodings() {
+	for _, fs := range append(ms.fieldsWithComputed(), ms.keyFields...) {
+		for _, spec := range []*fieldSpec{fs, fs.elem} {
+			if spec != nil && spec.kind == inconvertibleField {
+				spec.encoding = interfaceEncodingForType(spec.typ)
 			}
-			// For inconvertibles, that are not nil, convert the value to bytes
-			// using the gob package.
-			valBytes, err := mr.spec.fallback.Marshal(fieldVal.Interface())
-			if err != nil {
-				return nil, err
+		}
+	}
+}
+
+// hashValue returns the value that should be stored in the main hash for the
+// field described by fs, given the current value of the field. The returned
+// value is suitable for use as an argument to a Redis command.
+func (ms *modelSpec) hashValue(fs *fieldSpec, fieldVal reflect.Value) (interface{}, error) {
+	switch fs.kind {
+	case primativeField:
+		// Add a special case for time.Duration. By default, the redigo driver
+		// will fall back to fmt.Sprintf, but we want to save it as an int64 in
+		// this case.
+		if fs.typ == durationType {
+			return fieldVal.Int(), nil
+		}
+		if fs.enum != nil {
+			return fs.enumOrdinal(fieldVal.String())
+		}
+		return fieldVal.Interface(), nil
+	case pointerField:
+		if fieldVal.IsNil() {
+			return "NULL", nil
+		}
+		return fieldVal.Elem().Interface(), nil
+	case inconvertibleField:
+		switch fieldVal.Type().Kind() {
+		// For nilable types that are nil store NULL
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			if fieldVal.IsNil() {
+				return "NULL", nil
 			}
-			args = args.Add(fs.redisName, valBytes)
 		}
+		// Types which implement the interfaces in the encoding package are
+		// converted with them, so that they stay readable by other tools.
+		if fs.encoding != noInterfaceEncoding {
+			return marshalInterfaceVal(fieldVal, fs.encoding)
+		}
+		// For all other inconvertibles that are not nil, convert the value to
+		// bytes using the fallback MarshalerUnmarshaler.
+		return ms.fallback.Marshal(fieldVal.Interface())
+	}
+	return nil, fmt.Errorf("zoom: unknown kind for field %s", fs.name)
+}
+
+// fieldValueOf converts value to a reflect.Value with the same type as the
+// field described by fs. For pointer fields, value may also be the underlying
+// primitive type, in which case a new pointer is allocated. value may be nil
+// only if the field type is nilable. It returns an error if value is not
+// compatible with the field type.
+func fieldValueOf(fs *fieldSpec, value interface{}) (reflect.Value, error) {
+	if value == nil {
+		switch fs.typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return reflect.Zero(fs.typ), nil
+		}
+		return reflect.Value{}, fmt.Errorf("zoom: invalid value for %s: nil is not a valid %s", fs.name, fs.typ.String())
+	}
+	val := reflect.ValueOf(value)
+	if val.Type() == fs.typ {
+		return val, nil
+	}
+	if fs.kind == pointerField && val.Type() == fs.typ.Elem() {
+		ptr := reflect.New(fs.typ.Elem())
+		ptr.Elem().Set(val)
+		return ptr, nil
+	}
+	return reflect.Value{}, fmt.Errorf("zoom: invalid value for %s: type of value (%T) does not match type of field (%s)", fs.name, value, fs.typ.String())
+}
+
+// updateArgs returns the arguments for the update_models_by_ids_list script
+// which will set the fields in fieldValues (a map of field names to values) to
+// the given values and update the corresponding indexes. It returns an error if
+// any of the field names are not found or any of the values are the wrong
+// type.
+func (ms *modelSpec) updateArgs(fieldValues map[string]interface{}) (redis.Args, error) {
+	for fieldName := range fieldValues {
+		fs, found := ms.fieldsByName[fieldName]
+		if !found {
+			return nil, newKindError(ErrFieldNotFound, "zoom: could not find field %s in type %s", fieldName, ms.typ.String())
+		}
+		if fs.fullText != nil {
+			return nil, fmt.Errorf("zoom: cannot update field %s in type %s because it has a full-text index", fieldName, ms.typ.String())
+		}
+		if fs.method != "" {
+			return nil, fmt.Errorf("zoom: cannot update field %s in type %s because it is a computed field", fieldName, ms.typ.String())
+		}
+		if fs.ttl > 0 {
+			return nil, fmt.Errorf("zoom: cannot update field %s in type %s because it has a ttl", fieldName, ms.typ.String())
+		}
+		if fs.isProtected() {
+			return nil, newKindError(ErrFieldNotWritable, "zoom: cannot update field %s in type %s because it is a readonly or writeonce field", fieldName, ms.typ.String())
+		}
+	}
+	args := redis.Args{}
+	for _, fs := range ms.fields {
+		value, found := fieldValues[fs.name]
+		if !found {
+			continue
+		}
+		fieldVal, err := fieldValueOf(fs, value)
+		if err != nil {
+			return nil, err
+		}
+		hashValue, err := ms.hashValue(fs, fieldVal)
+		if err != nil {
+			return nil, err
+		}
+		args = args.Add(fs.redisName, hashValue, fs.indexKindName())
+		if fs.indexKind == noIndex || (fieldVal.Kind() == reflect.Ptr && fieldVal.IsNil()) {
+			args = args.Add(0, "", convertBoolToInt(fs.hasNullIndex()))
+			continue
+		}
+		switch fs.indexKind {
+		case numericIndex:
+			args = args.Add(1, numericScore(fieldVal))
+		case booleanIndex:
+			args = args.Add(1, boolScore(fieldVal))
+		case stringIndex, integerIndex:
+			args = args.Add(1, stringIndexValue(fs, fieldVal))
+		case enumIndex:
+			args = args.Add(1, hashValue)
+		}
+		args = args.Add(convertBoolToInt(fs.hasNullIndex()))
 	}
 	return args, nil
 }
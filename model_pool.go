@@ -0,0 +1,54 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File model_pool.go contains code for recycling the models which are scanned
+// into slices (see CollectionOptions.NewModel).
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ReleaseModels passes each model in models, which must be a pointer to a slice
+// of models of the type of the collection, to the ReleaseModel function of the
+// collection (see CollectionOptions.ReleaseModel) and then sets the length of
+// the slice to 0, so that the slice itself can be reused as well. Nil models
+// are skipped. If the collection does not have a ReleaseModel function,
+// ReleaseModels only sets the length of the slice to 0. None of the models may
+// be used after they have been released.
+func (c *Collection) ReleaseModels(models interface{}) error {
+	if err := c.checkModelsType(models); err != nil {
+		return fmt.Errorf("zoom: Error in ReleaseModels: %w", err)
+	}
+	modelsVal := reflect.ValueOf(models).Elem()
+	if modelsVal.Kind() != reflect.Slice {
+		return newKindError(ErrWrongModelType, "zoom: Error in ReleaseModels: models should be a pointer to a slice of models")
+	}
+	if c.spec.releaseModel != nil {
+		for i := 0; i < modelsVal.Len(); i++ {
+			if modelVal := modelsVal.Index(i); !modelVal.IsNil() {
+				c.spec.releaseModel(modelVal.Interface().(Model))
+				modelVal.Set(reflect.Zero(modelVal.Type()))
+			}
+		}
+	}
+	modelsVal.SetLen(0)
+	return nil
+}
+
+// allocModel returns a new model of the type described by ms, which is
+// allocated with the NewModel function of the collection if there is one (see
+// CollectionOptions.NewModel).
+func (ms *modelSpec) allocModel() (reflect.Value, error) {
+	if ms.newModel == nil {
+		return reflect.New(ms.typ.Elem()), nil
+	}
+	model := ms.newModel()
+	if reflect.TypeOf(model) != ms.typ || reflect.ValueOf(model).IsNil() {
+		return reflect.Value{}, newKindError(ErrWrongModelType, "zoom: NewModel for %s returned %T instead of %s", ms.name, model, ms.typ.String())
+	}
+	return reflect.ValueOf(model), nil
+}
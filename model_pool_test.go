@@ -0,0 +1,92 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File model_pool_test.go tests the code in model_pool.go
+
+package zoom
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pooledTestModel struct {
+	Int    int    `zoom:"index"`
+	String string `zoom:"index"`
+	RandomID
+}
+
+func TestModelPool(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	mut := sync.Mutex{}
+	allocated, released := 0, 0
+	free := []*pooledTestModel{}
+	options := DefaultCollectionOptions.WithIndex(true).WithNewModel(func() Model {
+		mut.Lock()
+		defer mut.Unlock()
+		allocated++
+		if len(free) > 0 {
+			model := free[len(free)-1]
+			free = free[:len(free)-1]
+			return model
+		}
+		return &pooledTestModel{}
+	}).WithReleaseModel(func(model Model) {
+		mut.Lock()
+		defer mut.Unlock()
+		released++
+		*model.(*pooledTestModel) = pooledTestModel{}
+		free = append(free, model.(*pooledTestModel))
+	})
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&pooledTestModel{}, options)
+	require.NoError(t, err)
+	expected := []*pooledTestModel{}
+	tx := pool.NewTransaction()
+	for i := 0; i < 5; i++ {
+		model := &pooledTestModel{Int: i, String: randomString()}
+		tx.Save(col, model)
+		expected = append(expected, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	// The models should be allocated with NewModel
+	got := []*pooledTestModel{}
+	require.NoError(t, col.NewQuery().Order("Int").Run(&got))
+	assert.Equal(t, expected, got)
+	assert.Equal(t, 5, allocated)
+
+	// Released models should be reused by the next query
+	require.NoError(t, col.ReleaseModels(&got))
+	assert.Empty(t, got)
+	assert.Equal(t, 5, released)
+	require.NoError(t, col.NewQuery().Order("Int").Limit(1).Run(&got))
+	assert.Equal(t, expected[:1], got)
+	assert.Len(t, free, 4, "expected a released model to be reused")
+	assert.Equal(t, 6, allocated)
+	require.NoError(t, col.NewQuery().Order("Int").Parallel(2).Run(&got))
+	assert.Equal(t, expected, got)
+	assert.Equal(t, 10, allocated)
+
+	// NewModel must return a model of the right type
+	wrong, err := pool.NewCollectionWithOptions(&pooledTestModel{}, options.WithName("wrongPooledTestModel").WithNewModel(func() Model {
+		return &testModel{}
+	}))
+	require.NoError(t, err)
+	require.NoError(t, wrong.Save(&pooledTestModel{}))
+	err = wrong.FindAll(&[]*pooledTestModel{})
+	assert.True(t, errors.Is(err, ErrWrongModelType), "expected ErrWrongModelType but got %v", err)
+	assert.Error(t, col.ReleaseModels(&[]*testModel{}))
+	_, err = pool.NewDynamicCollection(Schema{Name: "dynamicPooledTestModel"}, options)
+	assert.Error(t, err)
+}
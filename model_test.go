@@ -9,10 +9,14 @@ package zoom
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCompileModelSpec(t *testing.T) {
@@ -78,6 +82,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Int",
 						typ:       reflect.TypeOf(Primitive{}.Int),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 					"String": &fieldSpec{
 						kind:      primativeField,
@@ -85,6 +90,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "String",
 						typ:       reflect.TypeOf(Primitive{}.String),
 						indexKind: noIndex,
+						index:     []int{1},
 					},
 					"Bool": &fieldSpec{
 						kind:      primativeField,
@@ -92,6 +98,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Bool",
 						typ:       reflect.TypeOf(Primitive{}.Bool),
 						indexKind: noIndex,
+						index:     []int{2},
 					},
 				},
 				fields: []*fieldSpec{
@@ -101,6 +108,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Int",
 						typ:       reflect.TypeOf(Primitive{}.Int),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 					{
 						kind:      primativeField,
@@ -108,6 +116,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "String",
 						typ:       reflect.TypeOf(Primitive{}.String),
 						indexKind: noIndex,
+						index:     []int{1},
 					},
 					{
 						kind:      primativeField,
@@ -115,6 +124,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Bool",
 						typ:       reflect.TypeOf(Primitive{}.Bool),
 						indexKind: noIndex,
+						index:     []int{2},
 					},
 				},
 			},
@@ -139,6 +149,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Int",
 						typ:       reflect.TypeOf(Pointer{}.Int),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 					"String": &fieldSpec{
 						kind:      pointerField,
@@ -146,6 +157,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "String",
 						typ:       reflect.TypeOf(Pointer{}.String),
 						indexKind: noIndex,
+						index:     []int{1},
 					},
 					"Bool": &fieldSpec{
 						kind:      pointerField,
@@ -153,6 +165,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Bool",
 						typ:       reflect.TypeOf(Pointer{}.Bool),
 						indexKind: noIndex,
+						index:     []int{2},
 					},
 				},
 				fields: []*fieldSpec{
@@ -162,6 +175,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Int",
 						typ:       reflect.TypeOf(Pointer{}.Int),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 					{
 						kind:      pointerField,
@@ -169,6 +183,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "String",
 						typ:       reflect.TypeOf(Pointer{}.String),
 						indexKind: noIndex,
+						index:     []int{1},
 					},
 					{
 						kind:      pointerField,
@@ -176,6 +191,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Bool",
 						typ:       reflect.TypeOf(Pointer{}.Bool),
 						indexKind: noIndex,
+						index:     []int{2},
 					},
 				},
 			},
@@ -192,6 +208,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Int",
 						typ:       reflect.TypeOf(Indexed{}.Int),
 						indexKind: numericIndex,
+						index:     []int{0},
 					},
 					"String": &fieldSpec{
 						kind:      primativeField,
@@ -199,6 +216,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "String",
 						typ:       reflect.TypeOf(Indexed{}.String),
 						indexKind: stringIndex,
+						index:     []int{1},
 					},
 					"Bool": &fieldSpec{
 						kind:      primativeField,
@@ -206,6 +224,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Bool",
 						typ:       reflect.TypeOf(Indexed{}.Bool),
 						indexKind: booleanIndex,
+						index:     []int{2},
 					},
 				},
 				fields: []*fieldSpec{
@@ -215,6 +234,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Int",
 						typ:       reflect.TypeOf(Indexed{}.Int),
 						indexKind: numericIndex,
+						index:     []int{0},
 					},
 					{
 						kind:      primativeField,
@@ -222,6 +242,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "String",
 						typ:       reflect.TypeOf(Indexed{}.String),
 						indexKind: stringIndex,
+						index:     []int{1},
 					},
 					{
 						kind:      primativeField,
@@ -229,6 +250,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Bool",
 						typ:       reflect.TypeOf(Indexed{}.Bool),
 						indexKind: booleanIndex,
+						index:     []int{2},
 					},
 				},
 			},
@@ -253,6 +275,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "myInt",
 						typ:       reflect.TypeOf(CustomName{}.Int),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 					"String": &fieldSpec{
 						kind:      primativeField,
@@ -260,6 +283,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "myString",
 						typ:       reflect.TypeOf(CustomName{}.String),
 						indexKind: noIndex,
+						index:     []int{1},
 					},
 					"Bool": &fieldSpec{
 						kind:      primativeField,
@@ -267,6 +291,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "myBool",
 						typ:       reflect.TypeOf(CustomName{}.Bool),
 						indexKind: noIndex,
+						index:     []int{2},
 					},
 				},
 				fields: []*fieldSpec{
@@ -276,6 +301,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "myInt",
 						typ:       reflect.TypeOf(CustomName{}.Int),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 					{
 						kind:      primativeField,
@@ -283,6 +309,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "myString",
 						typ:       reflect.TypeOf(CustomName{}.String),
 						indexKind: noIndex,
+						index:     []int{1},
 					},
 					{
 						kind:      primativeField,
@@ -290,6 +317,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "myBool",
 						typ:       reflect.TypeOf(CustomName{}.Bool),
 						indexKind: noIndex,
+						index:     []int{2},
 					},
 				},
 			},
@@ -306,6 +334,8 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Time",
 						typ:       reflect.TypeOf(Inconvertible{}.Time),
 						indexKind: noIndex,
+						index:     []int{0},
+						encoding:  textInterfaceEncoding,
 					},
 				},
 				fields: []*fieldSpec{
@@ -315,6 +345,8 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Time",
 						typ:       reflect.TypeOf(Inconvertible{}.Time),
 						indexKind: noIndex,
+						index:     []int{0},
+						encoding:  textInterfaceEncoding,
 					},
 				},
 			},
@@ -336,6 +368,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Primitive",
 						typ:       reflect.TypeOf(Primitive{}),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 				},
 				fields: []*fieldSpec{
@@ -345,6 +378,7 @@ func TestCompileModelSpec(t *testing.T) {
 						redisName: "Primitive",
 						typ:       reflect.TypeOf(Primitive{}),
 						indexKind: noIndex,
+						index:     []int{0},
 					},
 				},
 			},
@@ -389,3 +423,135 @@ func TestCompileModelSpec(t *testing.T) {
 		}
 	}
 }
+
+// computedTestModel is a model with computed indexes.
+type computedTestModel struct {
+	Email string
+	Name  string
+	RandomID
+}
+
+func (m *computedTestModel) ZoomIndex_EmailDomain() string {
+	return strings.ToLower(m.Email[strings.LastIndex(m.Email, "@")+1:])
+}
+
+func (m *computedTestModel) ZoomIndex_NameLength() int {
+	return len(m.Name)
+}
+
+type invalidComputedArgsModel struct {
+	RandomID
+}
+
+func (m *invalidComputedArgsModel) ZoomIndex_Invalid(prefix string) string {
+	return prefix
+}
+
+type invalidComputedTypeModel struct {
+	RandomID
+}
+
+func (m *invalidComputedTypeModel) ZoomIndex_Invalid() time.Time {
+	return time.Time{}
+}
+
+type invalidComputedNameModel struct {
+	Invalid string
+	RandomID
+}
+
+func (m *invalidComputedNameModel) ZoomIndex_Invalid() string {
+	return m.Invalid
+}
+
+func TestComputedIndexes(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&computedTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := []*computedTestModel{
+		{Email: "alice@Example.com", Name: "Alice"},
+		{Email: "bob@example.COM", Name: "Bob"},
+		{Email: "carol@other.org", Name: "Carol Ann"},
+	}
+	for _, model := range models {
+		require.NoError(t, col.Save(model))
+	}
+
+	ids, err := col.NewQuery().Filter("EmailDomain =", "example.com").Order("NameLength").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[1].ID, models[0].ID}, ids)
+	ids, err = col.NewQuery().Filter("NameLength >", 5).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[2].ID}, ids)
+
+	// Computed fields should not interfere with reading the model
+	got := &computedTestModel{}
+	require.NoError(t, col.Find(models[0].ID, got))
+	assert.Equal(t, models[0], got)
+	all := []*computedTestModel{}
+	require.NoError(t, col.NewQuery().Order("-NameLength").Include("Name", "EmailDomain").Run(&all))
+	require.Len(t, all, 3)
+	assert.Equal(t, "Carol Ann", all[0].Name)
+
+	// Saving should update the computed indexes
+	models[0].Email = "alice@other.org"
+	require.NoError(t, col.Save(models[0]))
+	ids, err = col.NewQuery().Filter("EmailDomain =", "example.com").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[1].ID}, ids)
+
+	// SaveFields only updates computed indexes which are given explicitly
+	models[1].Email = "bob@other.org"
+	require.NoError(t, col.SaveFields([]string{"Email"}, models[1]))
+	count, err := col.NewQuery().Filter("EmailDomain =", "other.org").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	require.NoError(t, col.SaveFields([]string{"Email", "EmailDomain"}, models[1]))
+	count, err = col.NewQuery().Filter("EmailDomain =", "other.org").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Computed fields cannot be updated directly
+	_, err = col.NewQuery().Update(map[string]interface{}{"EmailDomain": "example.com"})
+	assert.Error(t, err)
+
+	// Deleting should remove the models from the computed indexes
+	deleted, err := col.Delete(models[0].ID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	ids, err = col.NewQuery().Filter("EmailDomain =", "other.org").Order("NameLength").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[1].ID, models[2].ID}, ids)
+	numDeleted, err := col.NewQuery().Filter("NameLength <", 5).Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 1, numDeleted)
+	ids, err = col.NewQuery().Order("NameLength").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[2].ID}, ids)
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	indexKey, err := col.FieldIndexKey("NameLength")
+	require.NoError(t, err)
+	card, err := redis.Int(conn.Do("ZCARD", indexKey))
+	require.NoError(t, err)
+	assert.Equal(t, 1, card)
+}
+
+func TestComputedIndexErrors(t *testing.T) {
+	for _, model := range []Model{
+		&invalidComputedArgsModel{},
+		&invalidComputedTypeModel{},
+		&invalidComputedNameModel{},
+	} {
+		_, err := compileModelSpec(reflect.TypeOf(model))
+		assert.Error(t, err, "model: %T", model)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File order_expr.go contains code for ordering queries by a weighted sum of
+// several numeric fields.
+
+package zoom
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fieldWeight is one of the terms of an order given to OrderByExpr.
+type fieldWeight struct {
+	fieldName string
+	weight    float64
+}
+
+// OrderByExpr is like Order, but orders the models by a combined score, which
+// is the sum of the values of several fields multiplied by the given weights.
+// For example:
+//
+//	Posts.NewQuery().OrderByExpr(map[string]float64{"Popularity": 0.7, "Recency": 0.3})
+//
+// orders the posts by 0.7*Popularity + 0.3*Recency. Unlike Order, the models
+// are in descending order of the score (i.e. the highest score first), as is
+// usual for a ranking. Use negative weights to put the lowest score first. The
+// scores are computed in Redis from the field indexes with ZUNIONSTORE, so
+// each field must have a numeric or boolean index (booleans count as 0 or 1)
+// and cannot be a pointer. 64-bit integer fields cannot be used, since their
+// indexes do not store the values as scores. The scores can be read with
+// IDsWithScores. OrderByExpr will set an error on the query if any of the
+// fields are invalid, if there are no weights, or if another order has
+// already been applied to the query. The error, same as any other error that
+// occurs during the lifetime of the query, is not returned until the query is
+// executed.
+func (q *Query) OrderByExpr(weights map[string]float64) *Query {
+	q.query.OrderByExpr(weights)
+	return q
+}
+
+// OrderByExpr works exactly like Query.OrderByExpr. See the documentation for
+// Query.OrderByExpr for a full description.
+func (q *TransactionQuery) OrderByExpr(weights map[string]float64) *TransactionQuery {
+	q.query.OrderByExpr(weights)
+	return q
+}
+
+// OrderByExpr is like Query.OrderByExpr.
+func (q *TypedQuery[T, PT]) OrderByExpr(weights map[string]float64) *TypedQuery[T, PT] {
+	q.query.OrderByExpr(weights)
+	return q
+}
+
+// OrderByExpr orders the query by the weighted sum of the given fields. See
+// Query.OrderByExpr.
+func (q *query) OrderByExpr(weights map[string]float64) {
+	if q.hasExplicitOrder() {
+		q.setError(errors.New("zoom: error in Query.OrderByExpr: previous order already specified (only one order per query is allowed)"))
+		return
+	}
+	if len(weights) == 0 {
+		q.setError(errors.New("zoom: error in Query.OrderByExpr: at least one field is required"))
+		return
+	}
+	fieldWeights := make([]fieldWeight, 0, len(weights))
+	for fieldName, weight := range weights {
+		fs, found := q.collection.spec.fieldsByName[fieldName]
+		if !found {
+			q.setError(newKindError(ErrFieldNotFound, "zoom: error in Query.OrderByExpr: could not find field %s in type %s", fieldName, q.collection.spec.typ.String()))
+			return
+		}
+		if (fs.indexKind != numericIndex && fs.indexKind != booleanIndex) || fs.kind == pointerField {
+			q.setError(fmt.Errorf("zoom: error in Query.OrderByExpr: field %s in type %s must have a numeric or boolean index and cannot be a pointer", fieldName, q.collection.spec.typ.String()))
+			return
+		}
+		fieldWeights = append(fieldWeights, fieldWeight{
+			fieldName: fs.name,
+			weight:    weight,
+		})
+	}
+	sort.Slice(fieldWeights, func(i, j int) bool {
+		return fieldWeights[i].fieldName < fieldWeights[j].fieldName
+	})
+	q.order = order{
+		kind:    descendingOrder,
+		weights: fieldWeights,
+	}
+	q.defaultOrder = false
+}
+
+// isExpr returns true iff the order was given with OrderByExpr.
+func (o order) isExpr() bool {
+	return len(o.weights) > 0
+}
+
+// exprString returns the call to OrderByExpr which created the order, with the
+// fields sorted by name.
+func (o order) exprString() string {
+	terms := make([]string, len(o.weights))
+	for i, w := range o.weights {
+		terms[i] = fmt.Sprintf(`"%s": %v`, w.fieldName, w.weight)
+	}
+	return fmt.Sprintf("OrderByExpr(map[string]float64{%s})", strings.Join(terms, ", "))
+}
+
+// exprWeights returns the weights of an order given with OrderByExpr as a map
+// from field name to weight.
+func (o order) exprWeights() map[string]float64 {
+	weights := make(map[string]float64, len(o.weights))
+	for _, w := range o.weights {
+		weights[w.fieldName] = w.weight
+	}
+	return weights
+}
+
+// combineOrderScores adds a command to the transaction which stores the
+// combined score of every model in a temporary sorted set, according to the
+// order of q, which must have been given with OrderByExpr. It returns the key
+// of the sorted set, which can then be used in place of a field index.
+func (t *Transaction) combineOrderScores(q *query) string {
+	destKey := t.newTmpKey("tmp:order:expr")
+	args := redis.Args{destKey, len(q.order.weights)}
+	for _, w := range q.order.weights {
+		// The fields were validated by OrderByExpr, so there cannot be an error.
+		indexKey, _ := q.collection.spec.fieldIndexKey(w.fieldName)
+		args = args.Add(indexKey)
+	}
+	args = args.Add("WEIGHTS")
+	for _, w := range q.order.weights {
+		args = args.Add(w.weight)
+	}
+	t.Command("ZUNIONSTORE", args, nil)
+	return destKey
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File order_expr_test.go tests the code in order_expr.go
+
+package zoom
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rankedTestModel struct {
+	Popularity float64 `zoom:"index"`
+	Recency    int     `zoom:"index"`
+	Featured   bool    `zoom:"index"`
+	Title      string  `zoom:"index"`
+	Big        int64   `zoom:"index"`
+	RandomID
+}
+
+func TestOrderByExpr(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&rankedTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := []*rankedTestModel{
+		{Popularity: 10, Recency: 0, Title: "a"},    // score 7
+		{Popularity: 0, Recency: 20, Title: "b"},    // score 6
+		{Popularity: 5, Recency: 10, Title: "a"},    // score 6.5
+		{Popularity: 1, Recency: 1, Featured: true}, // score 11
+	}
+	tx := pool.NewTransaction()
+	for _, model := range models {
+		tx.Save(col, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	weights := map[string]float64{"Popularity": 0.7, "Recency": 0.3, "Featured": 10}
+	got := []*rankedTestModel{}
+	require.NoError(t, col.NewQuery().OrderByExpr(weights).Run(&got))
+	assert.Equal(t, []*rankedTestModel{models[3], models[0], models[2], models[1]}, got)
+
+	// The combined score should work with filters, limits, and Last
+	got = []*rankedTestModel{}
+	require.NoError(t, col.NewQuery().Filter("Title =", "a").OrderByExpr(weights).Limit(1).Run(&got))
+	assert.Equal(t, []*rankedTestModel{models[0]}, got)
+	got = []*rankedTestModel{}
+	require.NoError(t, col.NewQuery().OrderByExpr(weights).Last(2).Run(&got))
+	assert.Equal(t, []*rankedTestModel{models[2], models[1]}, got)
+	count, err := col.NewQuery().OrderByExpr(weights).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	scores, err := col.NewQuery().OrderByExpr(weights).Limit(2).IDsWithScores()
+	require.NoError(t, err)
+	require.Len(t, scores, 2)
+	assert.Equal(t, models[3].ID, scores[0].ID)
+	assert.InDelta(t, 11, scores[0].Score, 1e-9)
+	assert.InDelta(t, 7, scores[1].Score, 1e-9)
+
+	// The order should be included in the string and JSON representations
+	q := col.NewQuery().OrderByExpr(map[string]float64{"Recency": 0.3, "Popularity": 0.7})
+	assert.Equal(t, `rankedTestModel.NewQuery().OrderByExpr(map[string]float64{"Popularity": 0.7, "Recency": 0.3})`, q.String())
+	data, err := json.Marshal(q)
+	require.NoError(t, err)
+	unmarshaled, err := UnmarshalQuery(col, data)
+	require.NoError(t, err)
+	assert.Equal(t, q.String(), unmarshaled.String())
+
+	for _, invalid := range []*Query{
+		col.NewQuery().OrderByExpr(nil),
+		col.NewQuery().OrderByExpr(map[string]float64{"Invalid": 1}),
+		col.NewQuery().OrderByExpr(map[string]float64{"Title": 1}),
+		col.NewQuery().OrderByExpr(map[string]float64{"Big": 1}),
+		col.NewQuery().Order("Recency").OrderByExpr(weights),
+		col.NewQuery().OrderByExpr(weights).Order("Recency"),
+	} {
+		assert.Error(t, invalid.Run(&got), "expected an error for %s", invalid)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File outbox.go contains code related to the outbox, a Redis stream to which
+// Zoom appends an event for each mutation of the models in a collection.
+
+package zoom
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DefaultOutboxMaxLen is the approximate number of events kept in the outbox
+// of a collection if CollectionOptions.Outbox is true and
+// CollectionOptions.OutboxMaxLen is 0.
+const DefaultOutboxMaxLen = 10000
+
+// outboxFieldPrefix is prepended to the name of each field in an outbox
+// event, so that field names cannot collide with the other entries (e.g.
+// "op" and "id").
+const outboxFieldPrefix = "field:"
+
+// OutboxEvent is a single mutation read from the outbox of a collection.
+type OutboxEvent struct {
+	// ID is the id of the entry in the Redis stream. It must be passed to
+	// AckOutbox once the event has been processed.
+	ID string
+	// Op is the kind of mutation.
+	Op ChangeAction
+	// ModelID is the id of the model which was saved or deleted.
+	ModelID string
+	// Fields maps the names of the fields which were saved to their values, as
+	// they are stored in Redis (i.e. encoded with the fallback
+	// MarshalerUnmarshaler if necessary). Fields which are stored in their own
+	// key (e.g. fields with the `zoom:"hash"` struct tag) are not included. It
+	// is empty for deletions.
+	Fields map[string]string
+}
+
+// OutboxKey returns the key of the Redis stream used as the outbox for the
+// collection.
+func (c *Collection) OutboxKey() string {
+	return c.Name() + ":outbox"
+}
+
+// publishEvent adds a command to the transaction which appends an event to the
+// outbox of c. hashArgs should be the arguments for the HMSET command used to
+// save the model (or nil for deletions). It does nothing if c does not have an
+// outbox. Since the event is added to the same transaction as the mutation
+// itself, it is only published if the transaction is committed.
+func (t *Transaction) publishEvent(c *Collection, op ChangeAction, id string, hashArgs redis.Args) {
+	if !c.outbox {
+		return
+	}
+	args := redis.Args{c.OutboxKey(), "MAXLEN", "~", c.outboxMaxLen, "*", "op", string(op), "id", id}
+	// The first element of hashArgs is the key of the main hash, which is
+	// followed by pairs of redis names and values.
+	for i := 1; i+1 < len(hashArgs); i += 2 {
+		redisName, ok := hashArgs[i].(string)
+		if !ok {
+			continue
+		}
+		for _, fs := range c.spec.fieldsWithComputed() {
+			if fs.redisName == redisName {
+				args = args.Add(outboxFieldPrefix+fs.name, hashArgs[i+1])
+				break
+			}
+		}
+	}
+	t.modelCommand(id, "XADD", args, nil)
+}
+
+// ReadOutbox reads up to count new events from the outbox of the collection
+// as the given consumer in the given consumer group, using XREADGROUP. The
+// consumer group is created if it does not exist, starting at the beginning
+// of the outbox. If there are no new events, ReadOutbox blocks for up to block
+// before returning an empty slice. A block of 0 means ReadOutbox does not
+// block. Each event is delivered to only one consumer in the group, and stays
+// pending until it is acknowledged with AckOutbox, so events are not lost if a
+// consumer crashes.
+func (c *Collection) ReadOutbox(group string, consumer string, count int, block time.Duration) ([]OutboxEvent, error) {
+	if c == nil {
+		return nil, newNilCollectionError("ReadOutbox")
+	}
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("XGROUP", "CREATE", c.OutboxKey(), group, "0", "MKSTREAM"); err != nil {
+		if !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+	}
+	args := redis.Args{"GROUP", group, consumer}
+	if count > 0 {
+		args = args.Add("COUNT", count)
+	}
+	if block > 0 {
+		args = args.Add("BLOCK", int64(block/time.Millisecond))
+	}
+	args = args.Add("STREAMS", c.OutboxKey(), ">")
+	reply, err := conn.Do("XREADGROUP", args...)
+	if err != nil {
+		return nil, err
+	}
+	events := []OutboxEvent{}
+	if reply == nil {
+		// XREADGROUP returns nil if there were no new events
+		return events, nil
+	}
+	streams, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range streams {
+		// Each stream is a pair of the stream key and its entries
+		values, err := redis.Values(stream, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			return nil, fmt.Errorf("zoom: unexpected reply from XREADGROUP: %v", values)
+		}
+		entries, err := redis.Values(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			event, err := parseOutboxEvent(entry)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// AckOutbox acknowledges that the events with the given ids have been
+// processed by the given consumer group, using XACK. Acknowledged events will
+// not be delivered to the group again.
+func (c *Collection) AckOutbox(group string, ids ...string) error {
+	if c == nil {
+		return newNilCollectionError("AckOutbox")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	args := redis.Args{c.OutboxKey(), group}.AddFlat(ids)
+	_, err := conn.Do("XACK", args...)
+	return err
+}
+
+// parseOutboxEvent converts a single stream entry in a reply from XREADGROUP
+// to an OutboxEvent.
+func parseOutboxEvent(entry interface{}) (OutboxEvent, error) {
+	values, err := redis.Values(entry, nil)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	if len(values) != 2 {
+		return OutboxEvent{}, fmt.Errorf("zoom: unexpected stream entry in outbox: %v", values)
+	}
+	id, err := redis.String(values[0], nil)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	entries, err := redis.StringMap(values[1], nil)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	event := OutboxEvent{
+		ID:      id,
+		Op:      ChangeAction(entries["op"]),
+		ModelID: entries["id"],
+		Fields:  map[string]string{},
+	}
+	for name, value := range entries {
+		if strings.HasPrefix(name, outboxFieldPrefix) {
+			event.Fields[strings.TrimPrefix(name, outboxFieldPrefix)] = value
+		}
+	}
+	return event, nil
+}
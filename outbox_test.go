@@ -0,0 +1,85 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File outbox_test.go tests the code in outbox.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// outboxModel is a model type that is only used for testing the outbox
+type outboxModel struct {
+	Name string
+	Age  int
+	RandomID
+}
+
+func TestOutbox(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	col, err := testPool.NewCollectionWithOptions(&outboxModel{}, DefaultCollectionOptions.WithOutbox(true))
+	require.NoError(t, err)
+	defer func() {
+		// Effectively unregister the type by removing it from the map
+		delete(testPool.modelNameToSpec, col.Name())
+		delete(testPool.modelTypeToSpec, col.spec.typ)
+	}()
+
+	// Reading before any events have been published should create the group
+	events, err := col.ReadOutbox("indexer", "consumer1", 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	model := &outboxModel{Name: "Alice", Age: 30}
+	require.NoError(t, col.Save(model))
+	model.Age = 31
+	require.NoError(t, col.SaveFields([]string{"Age"}, model))
+	_, err = col.Delete(model.ID)
+	require.NoError(t, err)
+
+	events, err = col.ReadOutbox("indexer", "consumer1", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, ChangeSave, events[0].Op)
+	assert.Equal(t, model.ID, events[0].ModelID)
+	assert.Equal(t, map[string]string{"Name": "Alice", "Age": "30"}, events[0].Fields)
+	assert.Equal(t, ChangeUpdate, events[1].Op)
+	assert.Equal(t, map[string]string{"Age": "31"}, events[1].Fields)
+	assert.Equal(t, ChangeDelete, events[2].Op)
+	assert.Equal(t, model.ID, events[2].ModelID)
+	assert.Empty(t, events[2].Fields)
+
+	// The events should not be delivered to the same group again
+	events, err = col.ReadOutbox("indexer", "consumer2", 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// But they should be delivered to a different group, and count should limit
+	// the number of events returned
+	events, err = col.ReadOutbox("cache", "consumer1", 2, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.NoError(t, col.AckOutbox("cache", events[0].ID, events[1].ID))
+
+	// Mutations which are not committed should not publish events
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	tx := testPool.NewTransaction()
+	require.NoError(t, tx.WatchKey("watched"))
+	_, err = conn.Do("SET", "watched", "foo")
+	require.NoError(t, err)
+	tx.Save(col, &outboxModel{Name: "Bob"})
+	assert.IsType(t, WatchError{}, tx.Exec())
+	events, err = col.ReadOutbox("later", "consumer1", 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, events, 3)
+}
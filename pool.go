@@ -9,7 +9,10 @@
 package zoom
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -23,10 +26,30 @@ type Pool struct {
 	options PoolOptions
 	// redisPool is a redis.Pool
 	redisPool *redis.Pool
-	// modelTypeToSpec maps a registered model type to a modelSpec
+	// registryMut protects modelTypeToSpec, modelNameToSpec, and
+	// modelNameToCollection, so that collections can be registered and looked
+	// up concurrently.
+	registryMut sync.RWMutex
+	// modelTypeToSpec maps a registered model type to a modelSpec. If the type
+	// was registered under more than one name, it maps to the first.
 	modelTypeToSpec map[reflect.Type]*modelSpec
 	// modelNameToSpec maps a registered model name to a modelSpec
 	modelNameToSpec map[string]*modelSpec
+	// modelNameToCollection maps a registered model name to a Collection
+	modelNameToCollection map[string]*Collection
+	// cache is the in-process model cache. It is nil if the cache is disabled.
+	cache *modelCache
+	// cacheSubscriber listens for cache invalidations from other processes. It
+	// is nil if options.CacheInvalidationChannel is empty.
+	cacheSubscriber *cacheSubscriber
+	// middleware wraps every command sent through a connection from the pool
+	// (see Use).
+	middleware []Middleware
+	// queryInterceptors are called with every query before it is executed (see
+	// InterceptQueries).
+	queryInterceptors []QueryInterceptor
+	// metrics are the counters returned by Metrics.
+	metrics *poolMetrics
 }
 
 // DefaultPoolOptions is the default set of options for a Pool.
@@ -38,6 +61,7 @@ var DefaultPoolOptions = PoolOptions{
 	MaxIdle:     1000,
 	Network:     "tcp",
 	Password:    "",
+	TempKeyTTL:  10 * time.Minute,
 	Wait:        true,
 }
 
@@ -45,16 +69,49 @@ var DefaultPoolOptions = PoolOptions{
 type PoolOptions struct {
 	// Address to use when connecting to Redis.
 	Address string
+	// BatchSize is the maximum number of commands to send in a single
+	// MULTI/EXEC block when executing a transaction which was marked with
+	// Transaction.Batched. If such a transaction has more commands, they are
+	// split into multiple blocks (see Transaction.ExecInBatches). A value of 0
+	// (the default) means transactions are never split. Other transactions,
+	// including the ones used internally by methods such as Save and Delete,
+	// and transactions which are watching keys are never split.
+	BatchSize int
+	// CacheSize is the maximum number of models to keep in an in-process LRU
+	// cache in front of Find and FindFields. A value of 0 (the default) disables
+	// the cache. Models are removed from the cache whenever they are saved or
+	// deleted through the same Pool. Writes made by other processes (or directly
+	// to Redis) are not seen unless CacheInvalidationChannel is set, so you
+	// should also set CacheTTL if that is a concern. Models with fields stored
+	// in their own key (e.g. fields tagged with zoom:"hash") or fields with the
+	// ttl option are never cached.
+	CacheSize int
+	// CacheTTL is the amount of time after which a cached model expires. A value
+	// of 0 means cached models never expire (but may still be evicted).
+	CacheTTL time.Duration
+	// CacheInvalidationChannel is the name of a Redis pub/sub channel used to
+	// share cache invalidations between processes. If not empty, each
+	// transaction which saves or deletes models will publish a message on the
+	// channel, and the Pool will subscribe to the channel on its own connection
+	// to remove models saved or deleted by other processes from its cache. It
+	// has no effect if CacheSize is 0.
+	CacheInvalidationChannel string
 	// Database id to use (using SELECT).
 	Database int
 	// IdleTimeout is the amount of time to wait before timing out (closing) idle
-	// connections.
+	// connections. A value of 0 means idle connections are never closed. The
+	// default is 240 seconds.
 	IdleTimeout time.Duration
-	// MaxActive is the maximum number of active connections the pool will keep.
-	// A value of 0 means unlimited.
+	// MaxActive is the maximum number of active connections the pool will keep,
+	// i.e. the maximum number of connections to Redis at the same time. A value
+	// of 0 means unlimited. The default is 1000. See also Wait.
 	MaxActive int
+	// MaxConnLifetime is the maximum amount of time a connection may be reused.
+	// Connections older than MaxConnLifetime are closed instead of being taken
+	// from the pool. A value of 0 means connections are reused forever.
+	MaxConnLifetime time.Duration
 	// MaxIdle is the maximum number of idle connections the pool will keep. A
-	// value of 0 means unlimited.
+	// value of 0 means unlimited. The default is 1000.
 	MaxIdle int
 	// Network to use.
 	Network string
@@ -62,10 +119,30 @@ type PoolOptions struct {
 	// every connection will use the AUTH command during initialization
 	// to authenticate with the database.
 	Password string
+	// ReconnectPolicy controls how new connections are retried if Redis cannot
+	// be reached (e.g. because it is restarting). Only network errors and the
+	// LOADING and READONLY error replies are retried. The zero value means new
+	// connections are never retried.
+	ReconnectPolicy RetryPolicy
+	// TempKeyTTL is the time to live for the temporary keys which are created
+	// while executing queries. Temporary keys are normally deleted in the same
+	// transaction which creates them, but they can be left behind if a
+	// transaction is split into batches and one of the batches fails. A value
+	// of 0 means temporary keys never expire. See also CleanupTempKeys.
+	TempKeyTTL time.Duration
+	// TestOnBorrow is an optional function for checking the health of an idle
+	// connection before it is taken from the pool. lastUsed is the time the
+	// connection was returned to the pool. If TestOnBorrow returns an error,
+	// the connection is closed and another one is used. See PingOnBorrow for an
+	// example.
+	TestOnBorrow func(c redis.Conn, lastUsed time.Time) error
 	// Wait indicates whether or not the pool should wait for a free connection
 	// if the MaxActive limit has been reached. If Wait is false and the
-	// MaxActive limit is reached, Zoom will return an error indicating that the
-	// pool is exhausted.
+	// MaxActive limit is reached, every command sent on the connection returned
+	// by NewConn (and so every method which needs a new connection) returns an
+	// error which wraps ErrPoolExhausted, which gives applications under load
+	// back-pressure instead of a queue of goroutines waiting for a connection.
+	// The default is true.
 	Wait bool
 }
 
@@ -76,6 +153,35 @@ func (options PoolOptions) WithAddress(address string) PoolOptions {
 	return options
 }
 
+// WithBatchSize returns a new copy of the options with the BatchSize property
+// set to the given value. It does not mutate the original options.
+func (options PoolOptions) WithBatchSize(batchSize int) PoolOptions {
+	options.BatchSize = batchSize
+	return options
+}
+
+// WithCacheSize returns a new copy of the options with the CacheSize property
+// set to the given value. It does not mutate the original options.
+func (options PoolOptions) WithCacheSize(size int) PoolOptions {
+	options.CacheSize = size
+	return options
+}
+
+// WithCacheTTL returns a new copy of the options with the CacheTTL property set
+// to the given value. It does not mutate the original options.
+func (options PoolOptions) WithCacheTTL(ttl time.Duration) PoolOptions {
+	options.CacheTTL = ttl
+	return options
+}
+
+// WithCacheInvalidationChannel returns a new copy of the options with the
+// CacheInvalidationChannel property set to the given value. It does not mutate
+// the original options.
+func (options PoolOptions) WithCacheInvalidationChannel(channel string) PoolOptions {
+	options.CacheInvalidationChannel = channel
+	return options
+}
+
 // WithDatabase returns a new copy of the options with the Database property set
 // to the given value. It does not mutate the original options.
 func (options PoolOptions) WithDatabase(database int) PoolOptions {
@@ -104,6 +210,14 @@ func (options PoolOptions) WithMaxIdle(maxIdle int) PoolOptions {
 	return options
 }
 
+// WithMaxConnLifetime returns a new copy of the options with the
+// MaxConnLifetime property set to the given value. It does not mutate the
+// original options.
+func (options PoolOptions) WithMaxConnLifetime(lifetime time.Duration) PoolOptions {
+	options.MaxConnLifetime = lifetime
+	return options
+}
+
 // WithNetwork returns a new copy of the options with the Network property set
 // to the given value. It does not mutate the original options.
 func (options PoolOptions) WithNetwork(network string) PoolOptions {
@@ -118,6 +232,28 @@ func (options PoolOptions) WithPassword(password string) PoolOptions {
 	return options
 }
 
+// WithReconnectPolicy returns a new copy of the options with the
+// ReconnectPolicy property set to the given value. It does not mutate the
+// original options.
+func (options PoolOptions) WithReconnectPolicy(policy RetryPolicy) PoolOptions {
+	options.ReconnectPolicy = policy
+	return options
+}
+
+// WithTempKeyTTL returns a new copy of the options with the TempKeyTTL
+// property set to the given value. It does not mutate the original options.
+func (options PoolOptions) WithTempKeyTTL(ttl time.Duration) PoolOptions {
+	options.TempKeyTTL = ttl
+	return options
+}
+
+// WithTestOnBorrow returns a new copy of the options with the TestOnBorrow
+// property set to the given value. It does not mutate the original options.
+func (options PoolOptions) WithTestOnBorrow(testOnBorrow func(c redis.Conn, lastUsed time.Time) error) PoolOptions {
+	options.TestOnBorrow = testOnBorrow
+	return options
+}
+
 // WithWait returns a new copy of the options with the Wait property set to the
 // given value. It does not mutate the original options.
 func (options PoolOptions) WithWait(wait bool) PoolOptions {
@@ -137,48 +273,227 @@ func NewPool(address string) *Pool {
 // methods of DefaultOptions to change the options you want to change.
 func NewPoolWithOptions(options PoolOptions) *Pool {
 	pool := &Pool{
-		options:         options,
-		modelTypeToSpec: map[reflect.Type]*modelSpec{},
-		modelNameToSpec: map[string]*modelSpec{},
+		options:               options,
+		modelTypeToSpec:       map[reflect.Type]*modelSpec{},
+		modelNameToSpec:       map[string]*modelSpec{},
+		modelNameToCollection: map[string]*Collection{},
+		metrics:               newPoolMetrics(),
 	}
 	pool.redisPool = &redis.Pool{
 		MaxIdle:     options.MaxIdle,
 		MaxActive:   options.MaxActive,
 		IdleTimeout: options.IdleTimeout,
 		Wait:        options.Wait,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial(options.Network, options.Address)
-			if err != nil {
-				return nil, err
-			}
-			// If a options.Password was provided, use the AUTH command to authenticate
-			if options.Password != "" {
-				if _, err := c.Do("AUTH", options.Password); err != nil {
-					return nil, err
-				}
-			}
-			// Select the database number provided by options.Database
-			if _, err := c.Do("Select", options.Database); err != nil {
-				_ = c.Close()
-				return nil, err
-			}
-			return c, err
-		},
+		Dial:        pool.dialWithRetry,
+	}
+	if options.TestOnBorrow != nil || options.MaxConnLifetime > 0 {
+		pool.redisPool.TestOnBorrow = pool.testOnBorrow
+	}
+	if options.CacheSize > 0 {
+		pool.cache = newModelCache(options.CacheSize, options.CacheTTL)
+		if options.CacheInvalidationChannel != "" {
+			pool.startCacheSubscriber()
+		}
 	}
 	return pool
 }
 
+// dial creates a new connection to Redis using the options for the pool.
+func (p *Pool) dial() (redis.Conn, error) {
+	options := p.options
+	c, err := redis.Dial(options.Network, options.Address, redis.DialNetDial(p.metrics.dial))
+	if err != nil {
+		return nil, err
+	}
+	// If a options.Password was provided, use the AUTH command to authenticate
+	if options.Password != "" {
+		if _, err := c.Do("AUTH", options.Password); err != nil {
+			return nil, err
+		}
+	}
+	// Select the database number provided by options.Database
+	if _, err := c.Do("Select", options.Database); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return &timedConn{Conn: &countingConn{Conn: c, metrics: p.metrics}, created: time.Now()}, err
+}
+
+// dialWithRetry is like dial but retries according to the ReconnectPolicy
+// option if there is a transient error, e.g. because Redis is restarting.
+func (p *Pool) dialWithRetry() (redis.Conn, error) {
+	policy := p.options.ReconnectPolicy
+	var c redis.Conn
+	var err error
+	for attempt := 1; ; attempt++ {
+		c, err = p.dial()
+		if err == nil || attempt >= policy.MaxAttempts || !IsRetryableError(err) {
+			return c, err
+		}
+		time.Sleep(policy.backoff(attempt))
+	}
+}
+
+// timedConn is a connection which remembers when it was created.
+type timedConn struct {
+	redis.Conn
+	created time.Time
+}
+
+// DoWithTimeout satisfies redis.ConnWithTimeout.
+func (tc *timedConn) DoWithTimeout(timeout time.Duration, name string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(tc.Conn, timeout, name, args...)
+}
+
+// ReceiveWithTimeout satisfies redis.ConnWithTimeout.
+func (tc *timedConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(tc.Conn, timeout)
+}
+
+// testOnBorrow checks the health of an idle connection before it is taken
+// from the pool, according to the MaxConnLifetime and TestOnBorrow options.
+func (p *Pool) testOnBorrow(c redis.Conn, lastUsed time.Time) error {
+	if tc, ok := c.(*timedConn); ok && p.options.MaxConnLifetime > 0 {
+		if time.Since(tc.created) > p.options.MaxConnLifetime {
+			return fmt.Errorf("zoom: connection exceeded MaxConnLifetime")
+		}
+	}
+	if p.options.TestOnBorrow != nil {
+		return p.options.TestOnBorrow(c, lastUsed)
+	}
+	return nil
+}
+
+// PingOnBorrow is a function which can be used for the TestOnBorrow pool
+// option. It sends a PING command on connections which have been idle for more
+// than a minute, so that connections which were closed by the server (e.g.
+// after a restart or failover) are discarded instead of being used.
+func PingOnBorrow(c redis.Conn, lastUsed time.Time) error {
+	if time.Since(lastUsed) < time.Minute {
+		return nil
+	}
+	_, err := c.Do("PING")
+	return err
+}
+
+// Ping sends a PING command to Redis using a connection from the pool. It
+// returns an error if Redis could not be reached. It is useful for health
+// checks.
+func (p *Pool) Ping() error {
+	conn := p.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("PING"); err != nil {
+		return fmt.Errorf("zoom: error in Ping: %w", err)
+	}
+	return nil
+}
+
 // NewConn gets a connection from the pool and returns it.
 // It can be used for directly interacting with the database. See
 // http://godoc.org/github.com/garyburd/redigo/redis for full documentation
 // on the redis.Conn type. You must call Close on any connections after you are
 // done using them. Failure to call Close can cause a resource leak.
 func (p *Pool) NewConn() redis.Conn {
-	return p.redisPool.Get()
+	conn := p.redisPool.Get()
+	if errors.Is(conn.Err(), redis.ErrPoolExhausted) {
+		_ = conn.Close()
+		conn = errorConn{err: poolExhaustedError{maxActive: p.options.MaxActive}}
+	}
+	if len(p.middleware) > 0 {
+		return newMiddlewareConn(conn, p.middleware)
+	}
+	return conn
+}
+
+// errorConn is a connection which returns err for every command. It is returned
+// by NewConn instead of a real connection when the pool is exhausted.
+type errorConn struct {
+	err error
+}
+
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
+
+// PoolStats describes the connections of a pool at a point in time. It can be
+// used to monitor how close the pool is to its MaxActive limit.
+type PoolStats struct {
+	// ActiveCount is the number of connections in the pool, both in use and
+	// idle.
+	ActiveCount int
+	// IdleCount is the number of idle connections in the pool.
+	IdleCount int
+}
+
+// Stats returns the current number of active and idle connections in the
+// pool.
+func (p *Pool) Stats() PoolStats {
+	stats := p.redisPool.Stats()
+	return PoolStats{
+		ActiveCount: stats.ActiveCount,
+		IdleCount:   stats.IdleCount,
+	}
 }
 
 // Close closes the pool. It should be run whenever the pool is no longer
 // needed. It is often used in conjunction with defer.
 func (p *Pool) Close() error {
+	if p.cacheSubscriber != nil {
+		p.cacheSubscriber.close()
+	}
 	return p.redisPool.Close()
 }
+
+// CleanupTempKeys deletes the temporary keys created by queries (i.e. keys
+// which start with "tmp:") which have not been accessed for at least
+// olderThan. Temporary keys are normally deleted as soon as the query which
+// created them is done, and they expire after PoolOptions.TempKeyTTL, so this
+// is only needed to remove keys left behind by older versions of Zoom or by
+// pools with a TempKeyTTL of 0. olderThan should be much longer than the
+// longest running query. It returns the number of keys deleted.
+func (p *Pool) CleanupTempKeys(olderThan time.Duration) (int, error) {
+	conn := p.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	deleted := 0
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", "tmp:*", "COUNT", 100))
+		if err != nil {
+			return deleted, fmt.Errorf("zoom: error in CleanupTempKeys: %w", err)
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return deleted, fmt.Errorf("zoom: error in CleanupTempKeys: %w", err)
+		}
+		keys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return deleted, fmt.Errorf("zoom: error in CleanupTempKeys: %w", err)
+		}
+		for _, key := range keys {
+			idleSeconds, err := redis.Int64(conn.Do("OBJECT", "IDLETIME", key))
+			if err == redis.ErrNil {
+				// The key was deleted since it was scanned.
+				continue
+			} else if err != nil {
+				return deleted, fmt.Errorf("zoom: error in CleanupTempKeys: %w", err)
+			}
+			if time.Duration(idleSeconds)*time.Second < olderThan {
+				continue
+			}
+			n, err := redis.Int(conn.Do("DEL", key))
+			if err != nil {
+				return deleted, fmt.Errorf("zoom: error in CleanupTempKeys: %w", err)
+			}
+			deleted += n
+		}
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
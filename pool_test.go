@@ -0,0 +1,171 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File pool_test.go tests the code in pool.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	assert.NoError(t, testPool.Ping())
+	pool := NewPoolWithOptions(testPool.options.WithAddress("localhost:1"))
+	defer func() {
+		_ = pool.Close()
+	}()
+	assert.Error(t, pool.Ping())
+}
+
+func TestPoolExhausted(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options.WithMaxActive(1).WithWait(false))
+	defer func() {
+		_ = pool.Close()
+	}()
+	conn := pool.NewConn()
+	_, err := conn.Do("PING")
+	require.NoError(t, err)
+	assert.Equal(t, PoolStats{ActiveCount: 1, IdleCount: 0}, pool.Stats())
+
+	// While the only connection is in use, commands fail immediately.
+	col, err := pool.NewCollection(&testModel{})
+	require.NoError(t, err)
+	err = col.Save(&testModel{})
+	assert.True(t, errors.Is(err, ErrPoolExhausted), "expected ErrPoolExhausted but got %v", err)
+	assert.True(t, errors.Is(err, redis.ErrPoolExhausted))
+	assert.True(t, IsRetryableError(err))
+
+	_ = conn.Close()
+	assert.Equal(t, PoolStats{ActiveCount: 1, IdleCount: 1}, pool.Stats())
+	assert.NoError(t, col.Save(&testModel{}))
+}
+
+func TestTestOnBorrow(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	calls := 0
+	pool := NewPoolWithOptions(testPool.options.WithTestOnBorrow(func(c redis.Conn, lastUsed time.Time) error {
+		calls++
+		return PingOnBorrow(c, lastUsed)
+	}))
+	defer func() {
+		_ = pool.Close()
+	}()
+	require.NoError(t, pool.Ping())
+	assert.Equal(t, 0, calls, "TestOnBorrow should not be called for new connections")
+	// The second call should reuse the idle connection from the first.
+	require.NoError(t, pool.Ping())
+	assert.Equal(t, 1, calls)
+}
+
+func TestMaxConnLifetime(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options.WithMaxConnLifetime(time.Minute))
+	defer func() {
+		_ = pool.Close()
+	}()
+	conn, err := pool.dial()
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	assert.NoError(t, pool.testOnBorrow(conn, time.Now()))
+	conn.(*timedConn).created = time.Now().Add(-2 * time.Minute)
+	assert.Error(t, pool.testOnBorrow(conn, time.Now()))
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 20 * time.Millisecond,
+		Multiplier:     1,
+	}
+	pool := NewPoolWithOptions(DefaultPoolOptions.WithAddress("localhost:1").WithReconnectPolicy(policy))
+	defer func() {
+		_ = pool.Close()
+	}()
+	start := time.Now()
+	assert.Error(t, pool.Ping())
+	// There should be two backoffs between the three attempts.
+	assert.True(t, time.Since(start) >= 40*time.Millisecond, "Expected dial to be retried")
+}
+
+func TestTempKeyTTL(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// A temporary key which is not deleted by the transaction should expire,
+	// even if the transaction is split into batches
+	tx := testPool.NewTransaction()
+	key := tx.newTmpKey("tmp:test")
+	tx.Command("SADD", redis.Args{key, "a"}, nil)
+	tx.Command("SADD", redis.Args{key, "b"}, nil)
+	require.NoError(t, tx.ExecInBatches(1))
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	ttl, err := redis.Int64(conn.Do("PTTL", key))
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= int64(testPool.options.TempKeyTTL/time.Millisecond), "Wrong ttl for temporary key: %d", ttl)
+
+	// Temporary keys should not expire if TempKeyTTL is 0
+	pool := NewPoolWithOptions(testPool.options.WithTempKeyTTL(0))
+	defer func() {
+		_ = pool.Close()
+	}()
+	tx = pool.NewTransaction()
+	key = tx.newTmpKey("tmp:test")
+	tx.Command("SADD", redis.Args{key, "a"}, nil)
+	tx.Command("SADD", redis.Args{key, "b"}, nil)
+	require.NoError(t, tx.Exec())
+	ttl, err = redis.Int64(conn.Do("PTTL", key))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), ttl)
+}
+
+func TestCleanupTempKeys(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("SADD", "tmp:filter:orphan", "a")
+	require.NoError(t, err)
+	_, err = conn.Do("SET", "notTmp", "a")
+	require.NoError(t, err)
+
+	// Keys which were accessed recently should not be deleted
+	deleted, err := testPool.CleanupTempKeys(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = testPool.CleanupTempKeys(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	exists, err := redis.Bool(conn.Do("EXISTS", "tmp:filter:orphan"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = redis.Bool(conn.Do("EXISTS", "notTmp"))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
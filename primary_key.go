@@ -0,0 +1,189 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File primary_key.go contains code for models which use the value of one of
+// their fields (a natural key) as their id.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// NaturalID can be embedded in a model struct instead of RandomID in order to
+// use the value of one of its fields as the id, e.g. an email address or a
+// username. The field must be a string and have the `zoom:"primary"` struct
+// tag:
+//
+//	type User struct {
+//		Email string `zoom:"primary"`
+//		Name  string
+//		zoom.NaturalID
+//	}
+//
+// Since the id is the value of the field, a model can be found by the field
+// with Find instead of a query, which saves the round trip to a field index.
+// When a model with a NaturalID is saved, ID is set to the value of the
+// primary field. Save (and SaveFields) returns an error if the primary field
+// is empty, or an error which wraps ErrDuplicatePrimaryKey if ID was empty and
+// a model with the same primary key already exists, so that a new model can
+// never overwrite an existing one. To overwrite a model on purpose, set ID to
+// the primary key first. The check is atomic: Save watches the key of a new
+// model, so if another client saves a model with the same primary key before
+// the transaction is executed, Exec returns an error which wraps
+// ErrDuplicatePrimaryKey and nothing is written. A transaction which saves a
+// new model with a NaturalID therefore cannot be executed with ExecStreaming,
+// and Exec does not split it into batches. The primary field is implicitly
+// writeonce, so it cannot be changed after the model is saved. To change it,
+// delete the model and save a new one. Since the primary key is part of the
+// key of the model, it must be valid UTF-8 and cannot contain a colon, the name
+// of a field, or a name which is reserved for the keys of the collection (see
+// reservedPrimaryValues).
+type NaturalID struct {
+	ID string
+}
+
+// ModelID returns the id of the model, satisfying the Model interface. Unlike
+// RandomID, it does not generate an id if ID is empty.
+func (n *NaturalID) ModelID() string {
+	return n.ID
+}
+
+// SetModelID sets the id of the model, satisfying the Model interface.
+func (n *NaturalID) SetModelID(id string) {
+	n.ID = id
+}
+
+// checkPrimaryField returns an error if the model type has a primary field but
+// embeds RandomID, which would generate a random id for new models.
+func (ms *modelSpec) checkPrimaryField() error {
+	if ms.primary == nil {
+		return nil
+	}
+	if field, found := ms.typ.Elem().FieldByName("RandomID"); found && field.Type == reflect.TypeOf(RandomID{}) {
+		return fmt.Errorf("zoom: type %s has a primary field (%s) and must embed NaturalID instead of RandomID", ms.typ.String(), ms.primary.name)
+	}
+	return nil
+}
+
+// reservedPrimaryValues are the suffixes of the keys which belong to a
+// collection itself rather than to one of its models (e.g. the set of all ids,
+// which is stored at "<name>:all"), so they cannot be used as primary keys.
+var reservedPrimaryValues = []string{"all", "bitmap", "eviction", "idx", "index", "outbox"}
+
+// checkPrimaryValue returns an error if value cannot be used as the primary key
+// (and hence the id) of a new model, because the key of the model would collide
+// with one of the keys of the collection. Primary keys must be valid UTF-8, so
+// that they sort before the byte 0xFF in string indexes, and cannot contain a
+// colon, which separates the id from the names of fields stored in their own
+// key. They also cannot be one of reservedPrimaryValues or the name of a field,
+// which is used as the key of its index.
+func (ms *modelSpec) checkPrimaryValue(value string) error {
+	reserved := stringSliceContains(reservedPrimaryValues, value)
+	for _, fs := range ms.fields {
+		if fs.redisName == value {
+			reserved = true
+		}
+	}
+	switch {
+	case !utf8.ValidString(value):
+		return fmt.Errorf("zoom: primary field %s of %s must be valid UTF-8", ms.primary.name, ms.name)
+	case strings.Contains(value, ":"):
+		return fmt.Errorf("zoom: primary field %s of %s cannot contain a colon (got %q)", ms.primary.name, ms.name, value)
+	case reserved:
+		return fmt.Errorf("zoom: %q is reserved and cannot be used as the primary field %s of %s", value, ms.primary.name, ms.name)
+	}
+	return nil
+}
+
+// idForModel returns the id of model, or the id it will have once it is saved
+// if it has a primary field but no id yet.
+func (ms *modelSpec) idForModel(model Model) string {
+	if id := model.ModelID(); id != "" || ms.primary == nil || ms.checkModelType(model) != nil {
+		return id
+	}
+	mr := &modelRef{model: model, spec: ms}
+	return mr.fieldValue(ms.primary.name).String()
+}
+
+// setPrimaryKey sets the id of the model behind mr to the value of its primary
+// field, if the model type has one. If the model has an id but the primary
+// field is empty (e.g. because it was not read by FindFields), the field is
+// set to the id instead. For a new model (i.e. one without an id),
+// setPrimaryKey checks that no other model has the same primary key and
+// watches its key, so that the transaction fails if another client saves a
+// model with the same primary key before it is executed. Like
+// checkProtectedFields, it reads from the database immediately, using the
+// connection of the transaction.
+func (t *Transaction) setPrimaryKey(mr *modelRef) error {
+	fs := mr.spec.primary
+	if fs == nil {
+		return nil
+	}
+	fieldVal := mr.fieldValue(fs.name)
+	id := mr.model.ModelID()
+	value := fieldVal.String()
+	switch {
+	case value == "" && id == "":
+		return fmt.Errorf("zoom: primary field %s of %s cannot be empty", fs.name, mr.spec.name)
+	case value == "":
+		fieldVal.SetString(id)
+		return nil
+	case id == value:
+		return nil
+	case id != "":
+		return newKindError(ErrFieldNotWritable, "zoom: cannot change primary field %s of %s with id = %s to %s", fs.name, mr.spec.name, id, value)
+	}
+	if err := mr.spec.checkPrimaryValue(value); err != nil {
+		return err
+	}
+	exists, err := t.watchPrimaryKey(mr.spec.name + ":" + value)
+	if err != nil {
+		return fmt.Errorf("zoom: could not check primary key: %w", err)
+	}
+	if exists {
+		return newKindError(ErrDuplicatePrimaryKey, "zoom: a %s with %s = %s already exists", mr.spec.name, fs.name, value)
+	}
+	mr.model.SetModelID(value)
+	return nil
+}
+
+// watchPrimaryKey watches the key of a new model with a primary field and
+// returns true iff a model with the key already exists. The key is added to
+// primaryKeys, so that a conflict on it is reported as a duplicate primary key
+// (see watchConflictError).
+func (t *Transaction) watchPrimaryKey(key string) (bool, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.done {
+		return false, errTransactionDone
+	}
+	if _, err := t.conn.Do("WATCH", key); err != nil {
+		return false, err
+	}
+	if !stringSliceContains(t.watching, key) {
+		t.watching = append(t.watching, key)
+	}
+	t.primaryKeys = append(t.primaryKeys, key)
+	return redis.Bool(t.conn.Do("EXISTS", key))
+}
+
+// watchConflictError returns the error for a transaction which was not
+// executed because one of the keys it was watching changed. If another client
+// saved a model with one of the primary keys in primaryKeys in the meantime,
+// the error wraps ErrDuplicatePrimaryKey, since executing the transaction again
+// would overwrite that model. Otherwise it is a WatchConflictError.
+func (t *Transaction) watchConflictError() error {
+	for _, key := range t.primaryKeys {
+		if exists, err := redis.Bool(t.conn.Do("EXISTS", key)); err == nil && exists {
+			return newKindError(ErrDuplicatePrimaryKey, "zoom: a model with key %s was saved by another client before the transaction was executed", key)
+		}
+	}
+	return WatchConflictError{Keys: t.watching}
+}
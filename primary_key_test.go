@@ -0,0 +1,166 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File primary_key_test.go tests the code in primary_key.go
+
+package zoom
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type naturalKeyModel struct {
+	Email string `zoom:"primary"`
+	Name  string `zoom:"index"`
+	NaturalID
+}
+
+func TestPrimaryField(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	users, err := pool.NewCollectionWithOptions(&naturalKeyModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+
+	// The primary field should be used as the id
+	user := &naturalKeyModel{Email: "alice@example.com", Name: "Alice"}
+	require.NoError(t, users.Save(user))
+	assert.Equal(t, "alice@example.com", user.ID)
+	found := &naturalKeyModel{}
+	require.NoError(t, users.Find("alice@example.com", found))
+	assert.Equal(t, user, found)
+
+	// Saving a loaded model again should update it
+	found.Name = "Alice Smith"
+	require.NoError(t, users.Save(found))
+	partial := &naturalKeyModel{}
+	require.NoError(t, users.FindFields("alice@example.com", []string{"Name"}, partial))
+	assert.Equal(t, "alice@example.com", partial.ID)
+	require.NoError(t, users.SaveFields([]string{"Name"}, partial))
+	assert.Equal(t, "alice@example.com", partial.Email, "the primary field should be set from the id")
+
+	// A new model with the same primary key should not overwrite the existing one
+	err = users.Save(&naturalKeyModel{Email: "alice@example.com", Name: "Impostor"})
+	assert.True(t, errors.Is(err, ErrDuplicatePrimaryKey), "expected ErrDuplicatePrimaryKey but got %v", err)
+	require.NoError(t, users.Find("alice@example.com", found))
+	assert.Equal(t, "Alice Smith", found.Name)
+
+	// The primary field cannot be empty or changed
+	assert.Error(t, users.Save(&naturalKeyModel{Name: "Nobody"}))
+	found.Email = "bob@example.com"
+	err = users.Save(found)
+	assert.True(t, errors.Is(err, ErrFieldNotWritable), "expected ErrFieldNotWritable but got %v", err)
+	count, err := users.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestPrimaryFieldConcurrentSaves(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	users, err := pool.NewCollectionWithOptions(&naturalKeyModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+
+	// Both transactions check the primary key before either is executed, so
+	// only the second one can detect the duplicate.
+	first, second := pool.NewTransaction(), pool.NewTransaction()
+	first.Save(users, &naturalKeyModel{Email: "alice@example.com", Name: "Alice"})
+	second.Save(users, &naturalKeyModel{Email: "alice@example.com", Name: "Impostor"})
+	require.NoError(t, first.Exec())
+	err = second.Exec()
+	assert.True(t, errors.Is(err, ErrDuplicatePrimaryKey), "expected ErrDuplicatePrimaryKey but got %v", err)
+	found := &naturalKeyModel{}
+	require.NoError(t, users.Find("alice@example.com", found))
+	assert.Equal(t, "Alice", found.Name)
+
+	// When many goroutines save a model with the same new primary key, exactly
+	// one of them should succeed.
+	const numSaves = 10
+	errs := make(chan error, numSaves)
+	for i := 0; i < numSaves; i++ {
+		go func(i int) {
+			errs <- users.Save(&naturalKeyModel{Email: "bob@example.com", Name: fmt.Sprint("Bob ", i)})
+		}(i)
+	}
+	succeeded := 0
+	for i := 0; i < numSaves; i++ {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else {
+			assert.True(t, errors.Is(err, ErrDuplicatePrimaryKey), "expected ErrDuplicatePrimaryKey but got %v", err)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+	count, err := users.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestPrimaryFieldValues(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	users, err := pool.NewCollectionWithOptions(&naturalKeyModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+
+	// Values which would collide with the keys of the collection should be
+	// rejected
+	for _, value := range []string{"all", "eviction", "bitmap", "Name", "bitmap:ids", "alice:Name", "\xff"} {
+		err := users.Save(&naturalKeyModel{Email: value})
+		assert.Error(t, err, "expected an error for primary key %q", value)
+	}
+	count, err := users.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Non-ASCII primary keys sort after the ASCII DEL character, so they
+	// should still be found by string filters
+	for _, email := range []string{"émilie@example.com", "zoë@example.com", "bob@example.com"} {
+		require.NoError(t, users.Save(&naturalKeyModel{Email: email, Name: "Same"}))
+	}
+	var found []*naturalKeyModel
+	require.NoError(t, users.NewQuery().Filter("Name =", "Same").Run(&found))
+	assert.Len(t, found, 3)
+	require.NoError(t, users.NewQuery().Filter("Name >=", "Same").Filter("Name <=", "Same").Run(&found))
+	assert.Len(t, found, 3)
+}
+
+func TestPrimaryFieldErrors(t *testing.T) {
+	type notString struct {
+		Number int `zoom:"primary"`
+		NaturalID
+	}
+	type twoPrimaries struct {
+		Email    string `zoom:"primary"`
+		Username string `zoom:"primary"`
+		NaturalID
+	}
+	type randomID struct {
+		Email string `zoom:"primary"`
+		RandomID
+	}
+	for _, model := range []Model{&notString{}, &twoPrimaries{}, &randomID{}} {
+		_, err := compileModelSpec(reflect.TypeOf(model))
+		assert.Error(t, err, "expected an error for %T", model)
+	}
+}
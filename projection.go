@@ -0,0 +1,181 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File projection.go contains code for scanning the results of a query into
+// struct types other than the registered model type.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// projection describes how the fields of a model are scanned into a struct
+// type which is not the registered model type (e.g. a DTO).
+type projection struct {
+	// typ is the struct type of the destination.
+	typ reflect.Type
+	// fields are the model fields which are read, and indexes are the indexes
+	// of the corresponding fields in typ.
+	fields  []*fieldSpec
+	indexes [][]int
+	// idIndex is the index of the field in typ which receives the model id, or
+	// nil if there is none.
+	idIndex []int
+}
+
+// newProjection returns a projection from the models described by ms to typ,
+// which must be a struct type. Each exported field of typ is matched to the
+// field of the model with the same redis name, which is the value of the
+// "redis" struct tag or else the name of the field. A string field named ID
+// which does not match a field of the model receives the model id. Exported
+// embedded structs which do not match a field of the model are flattened.
+func newProjection(ms *modelSpec, typ reflect.Type) (*projection, error) {
+	p := &projection{typ: typ}
+	if err := p.compileFields(ms, typ, nil); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *projection) compileFields(ms *modelSpec, elem reflect.Type, index []int) error {
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Field(i)
+		if strings.ToLower(field.Name[0:1]) == field.Name[0:1] {
+			continue
+		}
+		redisTag := field.Tag.Get("redis")
+		if redisTag == "-" {
+			continue
+		}
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+		redisName := redisTag
+		if redisName == "" {
+			redisName = field.Name
+		}
+		fs, found := ms.fieldByRedisName(redisName)
+		if !found && field.Anonymous && redisTag == "" && field.Type.Kind() == reflect.Struct {
+			if err := p.compileFields(ms, field.Type, fieldIndex); err != nil {
+				return err
+			}
+			continue
+		}
+		if !found {
+			for _, keyField := range ms.keyFields {
+				if keyField.redisName == redisName {
+					return fmt.Errorf("zoom: error in RunInto: %s.%s is stored in its own key and cannot be scanned into %s", ms.typ.String(), keyField.name, p.typ.String())
+				}
+			}
+			if field.Name == "ID" && field.Type.Kind() == reflect.String {
+				p.idIndex = fieldIndex
+				continue
+			}
+			return newKindError(ErrFieldNotFound, "zoom: error in RunInto: %s.%s does not match any field in type %s", p.typ.String(), field.Name, ms.typ.String())
+		}
+		if field.Type != fs.typ {
+			return fmt.Errorf("zoom: error in RunInto: type of %s.%s (%s) does not match type of %s.%s (%s)", p.typ.String(), field.Name, field.Type.String(), ms.typ.String(), fs.name, fs.typ.String())
+		}
+		p.fields = append(p.fields, fs)
+		p.indexes = append(p.indexes, fieldIndex)
+	}
+	return nil
+}
+
+// fieldByRedisName returns the fieldSpec for the field (or computed field)
+// with the given redis name which is stored in the main hash, or false if
+// there is no such field.
+func (ms *modelSpec) fieldByRedisName(redisName string) (*fieldSpec, bool) {
+	for _, fs := range ms.fieldsWithComputed() {
+		if fs.redisName == redisName {
+			return fs, true
+		}
+	}
+	return nil, false
+}
+
+// redisNames returns the redis names of the fields read by p.
+func (p *projection) redisNames() []string {
+	names := make([]string, len(p.fields))
+	for i, fs := range p.fields {
+		names[i] = fs.redisName
+	}
+	return names
+}
+
+// scan converts the values from the hash of a single model (followed by the
+// model id) and scans them into dest, which must be a struct of type p.typ.
+func (p *projection) scan(ms *modelSpec, values []interface{}, dest reflect.Value) error {
+	for i, fs := range p.fields {
+		if values[i] == nil {
+			continue
+		}
+		replyBytes, err := redis.Bytes(values[i], nil)
+		if err != nil {
+			return err
+		}
+		if err := ms.scanFieldVal(fs, replyBytes, dest.FieldByIndex(p.indexes[i])); err != nil {
+			return err
+		}
+	}
+	if p.idIndex != nil {
+		id, err := redis.String(values[len(p.fields)], nil)
+		if err != nil {
+			return err
+		}
+		dest.FieldByIndex(p.idIndex).SetString(id)
+	}
+	return nil
+}
+
+// projectionDestType returns the struct type of the elements of dest, which
+// must be a pointer to a slice of structs or pointers to structs.
+func projectionDestType(dest interface{}) (reflect.Type, error) {
+	typ := reflect.TypeOf(dest)
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("zoom: error in RunInto: dest should be a pointer to a slice of structs but got %T", dest)
+	}
+	elemType := typ.Elem().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("zoom: error in RunInto: dest should be a pointer to a slice of structs but got %T", dest)
+	}
+	return elemType, nil
+}
+
+// newScanProjectionHandler returns a ReplyHandler which scans the reply from
+// a SORT command created with p.redisNames into dest, which must be a pointer
+// to a slice of structs or pointers to structs of type p.typ. The slice is
+// replaced, not reused.
+func newScanProjectionHandler(ms *modelSpec, p *projection, dest interface{}) ReplyHandler {
+	return func(reply interface{}) error {
+		destVal := reflect.ValueOf(dest).Elem()
+		values, err := redis.Values(reply, nil)
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+		numFields := len(p.fields) + 1
+		numModels := len(values) / numFields
+		results := reflect.MakeSlice(destVal.Type(), numModels, numModels)
+		for i := 0; i < numModels; i++ {
+			elem := results.Index(i)
+			if elem.Kind() == reflect.Ptr {
+				elem.Set(reflect.New(p.typ))
+				elem = elem.Elem()
+			}
+			if err := p.scan(ms, values[i*numFields:(i+1)*numFields], elem); err != nil {
+				return err
+			}
+		}
+		destVal.Set(results)
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File projection_test.go tests the code in projection.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type projectionTestModel struct {
+	FullName string `redis:"name" zoom:"index"`
+	Status   string `zoom:"enum=active|inactive"`
+	Age      *int
+	Tags     []string `zoom:"set"`
+	RandomID
+}
+
+// projectionTestDTO only contains some of the fields of projectionTestModel,
+// and gives them different names.
+type projectionTestDTO struct {
+	Name   string `redis:"name"`
+	Status string
+	EmbeddedProjectionTestDTO
+	ID string
+}
+
+// EmbeddedProjectionTestDTO is exported because, as for models, unexported
+// embedded structs are skipped.
+type EmbeddedProjectionTestDTO struct {
+	Age *int
+}
+
+func TestRunInto(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&projectionTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	age := 30
+	models := []*projectionTestModel{
+		{FullName: "Alice", Status: "active", Age: &age, Tags: []string{"a"}},
+		{FullName: "Bob", Status: "inactive"},
+		{FullName: "Carol", Status: "active"},
+	}
+	for _, model := range models {
+		require.NoError(t, col.Save(model))
+	}
+
+	dtos := []projectionTestDTO{}
+	require.NoError(t, col.NewQuery().Order("FullName").RunInto(&dtos))
+	require.Len(t, dtos, 3)
+	for i, model := range models {
+		assert.Equal(t, model.FullName, dtos[i].Name)
+		assert.Equal(t, model.Status, dtos[i].Status)
+		assert.Equal(t, model.Age, dtos[i].Age)
+		assert.Equal(t, model.ID, dtos[i].ID)
+	}
+
+	// Modifiers should be applied and slices of pointers should be supported
+	dtoPtrs := []*projectionTestDTO{{Name: "stale"}, {Name: "stale"}, {Name: "stale"}}
+	require.NoError(t, col.NewQuery().Filter("Status =", "active").Order("-FullName").Limit(1).RunInto(&dtoPtrs))
+	require.Len(t, dtoPtrs, 1)
+	assert.Equal(t, "Carol", dtoPtrs[0].Name)
+	assert.Equal(t, models[2].ID, dtoPtrs[0].ID)
+
+	// No matches should result in an empty slice
+	require.NoError(t, col.NewQuery().Filter("FullName =", "Dave").RunInto(&dtos))
+	assert.Len(t, dtos, 0)
+}
+
+func TestRunIntoErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	var unknownField []struct {
+		Int     int
+		Missing string
+	}
+	err := indexedTestModels.NewQuery().RunInto(&unknownField)
+	assert.True(t, errors.Is(err, ErrFieldNotFound), "err: %v", err)
+
+	var wrongType []struct {
+		Int string
+	}
+	assert.Error(t, indexedTestModels.NewQuery().RunInto(&wrongType))
+
+	var notStructs []string
+	assert.Error(t, indexedTestModels.NewQuery().RunInto(&notStructs))
+	assert.Error(t, indexedTestModels.NewQuery().RunInto(notStructs))
+	assert.Error(t, indexedTestModels.NewQuery().RunInto(nil))
+
+	var keyField []struct {
+		Tags []string
+	}
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&projectionTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	assert.Error(t, col.NewQuery().RunInto(&keyField))
+
+	// Errors set on the query should be returned
+	var valid []struct{ Int int }
+	assert.Error(t, indexedTestModels.NewQuery().Order("Missing").RunInto(&valid))
+}
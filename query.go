@@ -1,5 +1,13 @@
 package zoom
 
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
 // Query represents a query which will retrieve some models from
 // the database. A Query may consist of one or more query modifiers
 // (e.g. Filter or Order) and may be executed with a query finisher
@@ -12,12 +20,29 @@ type Query struct {
 // together with one or more query modifiers (e.g. Filter or Order), and then
 // executed using the Run, RunOne, Count, or IDs methods. If no query modifiers
 // are used, running the query will return all models of the given type in
-// unspecified order. Queries use delayed execution, so nothing touches the
-// database until you execute them.
+// unspecified order, or ordered by the default order field of the collection
+// if it has one (see CollectionOptions.DefaultOrderField). Queries use delayed
+// execution, so nothing touches the database until you execute them. If the
+// collection has a default scope (see CollectionOptions.DefaultScope), it is
+// applied to the query before it is returned.
 func (collection *Collection) NewQuery() *Query {
-	return &Query{
+	q := &Query{
 		query: newQuery(collection),
 	}
+	q.applyDefaultOrder()
+	q.applyDefaultScope()
+	return q
+}
+
+// Unscoped removes the modifiers which were added to the query by the default
+// scope of the collection (see CollectionOptions.DefaultScope), e.g. so that
+// an admin page can see soft-deleted models. It must be called before any
+// other query modifiers, and sets an error on the query otherwise. The error,
+// same as any other error that occurs during the lifetime of the query, is not
+// returned until the query is executed.
+func (q *Query) Unscoped() *Query {
+	q.query.Unscoped()
+	return q
 }
 
 // Order specifies a field by which to sort the models. fieldName should be a
@@ -53,6 +78,57 @@ func (q *Query) Offset(amount uint) *Query {
 	return q
 }
 
+// Last restricts the query to the last n models in the order specified by
+// Order. For example, Order("CreatedAt").Last(10) would return the 10 most
+// recently created models in ascending order. Zoom uses ZREVRANGE under the
+// hood, so there is no need to count the models and compute an offset. Limit
+// and Offset, if any, are applied to the last n models. If n is 0, Last has no
+// effect. If the query has no Order, the models returned are unspecified,
+// unless the collection has a sorted index (see CollectionOptions.SortedIndex)
+// and the query has no filters, in which case Last returns the n most recently
+// saved models.
+func (q *Query) Last(n uint) *Query {
+	q.query.Last(n)
+	return q
+}
+
+// Timeout sets the maximum amount of time to wait for a reply from Redis when
+// the query is executed. If Redis does not reply in time (e.g. because the
+// query has to intersect sets with millions of members), the query returns a
+// timeout error instead of blocking indefinitely. Note that Redis may still
+// finish executing the commands after the timeout. A timeout of 0 (the
+// default) means the query waits indefinitely. Queries which are run inside a
+// transaction with Transaction.Query use the timeout of the transaction
+// instead (see Transaction.Timeout).
+func (q *Query) Timeout(d time.Duration) *Query {
+	q.query.setTimeout(d)
+	return q
+}
+
+// Parallel causes Run to read the models on up to workers connections from the
+// pool concurrently, which can greatly reduce the latency of queries which
+// return a large number of models (e.g. 10,000 or more). When the query is run
+// with Parallel, Zoom first reads the ids of the matching models in a single
+// transaction, then splits them into workers chunks and reads the fields of
+// each chunk in a separate transaction. The models are returned in the same
+// order as they would be without Parallel. Since the models are not read in a
+// single transaction, a model which is deleted after the ids are read is left
+// out of the results. A workers value of 0 or 1 (the default) disables
+// Parallel. It only affects Run.
+func (q *Query) Parallel(workers int) *Query {
+	q.query.setParallel(workers)
+	return q
+}
+
+// newTransaction returns a new transaction which is used to execute the query
+// and which has the same timeout as the query. Its latency is recorded in the
+// metrics of the pool.
+func (q *Query) newTransaction() *Transaction {
+	tx := q.pool.NewTransaction().Timeout(q.timeout)
+	tx.queriedCollection = q.collection.Name()
+	return tx
+}
+
 // Include specifies one or more field names which will be read from the
 // database and scanned into the resulting models when the query is run. Field
 // names which are not specified in Include will not be read or scanned. You can
@@ -85,7 +161,12 @@ func (q *Query) Exclude(fields ...string) *Query {
 // ">", "<", ">=", or "<=". You can only use Filter on fields which are indexed,
 // i.e. those which have the `zoom:"index"` struct tag. If multiple filters are
 // applied to the same query, the query will only return models which have
-// matches for *all* of the filters. Filter will set an error on the query if
+// matches for *all* of the filters. For indexed pointer fields, you can also use
+// the "IS" and "IS NOT" operators with a nil value to find models for which the
+// field is (or is not) nil, e.g. Filter("Age IS", nil). Filter("Age =", nil) and
+// Filter("Age !=", nil) are equivalent. The "IN" operator takes a slice of
+// values and returns models for which the field is equal to any of them, e.g.
+// Filter("Status IN", []string{"active", "trial"}). Filter will set an error on the query if
 // the arguments are improperly formated, if the field you are attempting to
 // filter is not indexed, or if the type of value does not match the type of the
 // field. The error, same as any other error that occurs during the lifetime of
@@ -95,31 +176,311 @@ func (q *Query) Filter(filterString string, value interface{}) *Query {
 	return q
 }
 
+// FilterRange applies a filter to the query, which will cause the query to only
+// return models for which the value of the given field is between min and max.
+// If inclusive is true, models with a value equal to min or max are included.
+// For example: FilterRange("Price", 10, 20, true) is equivalent to
+// Filter("Price >=", 10).Filter("Price <=", 20), but is faster because Zoom
+// only needs to extract the matching ids from the field index once. Same as
+// with Filter, the field must be indexed and the types of min and max must
+// match the type of the field. Any errors are not returned until the query is
+// executed.
+func (q *Query) FilterRange(fieldName string, min interface{}, max interface{}, inclusive bool) *Query {
+	q.query.FilterRange(fieldName, min, max, inclusive)
+	return q
+}
+
+// Search applies a full-text search to the query, which will cause the query
+// to only return models for which the given field contains all the terms in
+// text. For example, Search("Title", "redis datastore") matches models whose
+// Title contains both "redis" and "datastore". Search can only be used on fields
+// which have the `zoom:"fulltext"` struct tag, and text is split into terms
+// using the same options (e.g. stemming) as the field. Search can be combined
+// with Filter, Order, and any other modifiers. If text does not contain any
+// terms (e.g. if all the words are stop words), Search has no effect. Search
+// will set an error on the query if the field does not exist or does not have
+// a full-text index. The error, same as any other error that occurs during the
+// lifetime of the query, is not returned until the query is executed.
+func (q *Query) Search(fieldName string, text string) *Query {
+	q.query.Search(fieldName, text)
+	return q
+}
+
+// FromIDSet restricts the query to models whose ids are in the set, sorted
+// set, or list identified by key. This allows you to compose queries with ids
+// computed elsewhere, e.g. a list created by StoreIDs, a set created by a Lua
+// script, or a set maintained by another service. FromIDSet can be combined
+// with Filter, Search, Order, and any other modifiers. If FromIDSet is called
+// more than once, the query will only return models whose ids are in all the
+// given keys. If key does not exist, the query will not return any models.
+func (q *Query) FromIDSet(key string) *Query {
+	q.query.FromIDSet(key)
+	return q
+}
+
+// FilterID restricts the query to models whose ids compare to id with the
+// given operator, which must be one of =, >, <, >=, or <=. Ids are compared
+// lexically, byte by byte. Since the ids generated by RandomID start with the
+// time they were generated (see IDForTime), FilterID can be used to find the
+// models which were created in a certain time window, e.g.
+//
+//	q.FilterID(">=", zoom.IDForTime(start)).FilterID("<", zoom.IDForTime(end))
+//
+// FilterID can be combined with any other modifiers. Unlike Filter, it does not
+// use an index, but reads the set of all ids of the collection, so it is as
+// expensive as a query with an Order on an unfiltered collection. If the query
+// has no Order, the models are returned in the order of their ids.
+func (q *Query) FilterID(operator string, id string) *Query {
+	q.query.FilterID(operator, id)
+	return q
+}
+
+// FilterIDPrefix restricts the query to models whose ids start with prefix.
+// See FilterID for more information.
+func (q *Query) FilterIDPrefix(prefix string) *Query {
+	q.query.FilterIDPrefix(prefix)
+	return q
+}
+
+// Join connects the query to the target collection via fieldName, which must
+// be a field with a string index that holds the ids of models in target (e.g.
+// an AuthorID field which holds the id of a model in an Authors collection).
+// After Join, you can filter on fields of the joined collection by prefixing
+// them with the name of the join, which is fieldName without the "ID" suffix.
+// For example:
+//
+//	Posts.NewQuery().Join("AuthorID", Authors).Filter("Author.Country =", "US")
+//
+// would return all the posts whose author is from the US. The joined ids are
+// resolved server-side with set operations, so the models in target are never
+// read. Join will set an error on the query if the field does not exist or
+// does not have a string index, if target is not indexed, or if another join
+// already has the same name. The error, same as any other error that occurs
+// during the lifetime of the query, is not returned until the query is
+// executed.
+func (q *Query) Join(fieldName string, target *Collection) *Query {
+	q.query.Join(fieldName, target)
+	return q
+}
+
 // Run executes the query and scans the results into models. The type of models
 // should be a pointer to a slice of Models. If no models fit the criteria, Run
 // will set the length of models to 0 but will *not* return an error. Run will
 // return the first error that occurred during the lifetime of the query (if
 // any), or if models is the wrong type.
 func (q *Query) Run(models interface{}) error {
-	tx := q.pool.NewTransaction()
+	if q.workers > 1 {
+		return q.runParallel(models)
+	}
+	tx := q.newTransaction()
 	newTransactionQuery(q.query, tx).Run(models)
 	return tx.Exec()
 }
 
+// RunInto runs the query and scans the fields of the models which match the
+// query criteria into dest, which should be a pointer to a slice of structs
+// (or pointers to structs) of any type, e.g. a lightweight projection of the
+// model used by an API. Only the fields of the struct are read. Each exported
+// field is matched to the field of the model with the same Redis name (the
+// value of the "redis" struct tag, or else the name of the field) and must have
+// the same type. A string field named ID which does not match a field of the
+// model is set to the id of the model. Include and Exclude have no effect.
+// RunInto returns an error if a field of the struct does not match any field
+// of the model, and like Run it returns the first error that occurred during
+// the lifetime of the query (if any).
+func (q *Query) RunInto(dest interface{}) error {
+	tx := q.newTransaction()
+	newTransactionQuery(q.query, tx).RunInto(dest)
+	return tx.Exec()
+}
+
+// runParallel is used by Run if the query has a Parallel modifier. It reads the
+// ids of the matching models, then reads the fields of the models in chunks on
+// up to q.workers connections concurrently. Models which are deleted after the
+// ids are read (i.e. whose keys no longer exist) are left out of the results,
+// just as if the serial query had run after they were deleted.
+func (q *Query) runParallel(models interface{}) error {
+	q = &Query{query: q.query.intercept("Run")}
+	if q.hasError() {
+		return q.err
+	}
+	if err := q.collection.spec.checkModelsType(models); err != nil {
+		return err
+	}
+	ids, err := q.IDs()
+	if err != nil {
+		return err
+	}
+	// Allocate all the models up front, reusing any existing models, so that
+	// each worker only needs to scan into its own chunk of the slice.
+	modelsVal := reflect.ValueOf(models).Elem()
+	results := reflect.MakeSlice(modelsVal.Type(), len(ids), len(ids))
+	for i, id := range ids {
+		if i < modelsVal.Len() && !modelsVal.Index(i).IsNil() {
+			results.Index(i).Set(modelsVal.Index(i))
+		} else {
+			modelVal, err := q.collection.spec.allocModel()
+			if err != nil {
+				return err
+			}
+			results.Index(i).Set(modelVal)
+		}
+		results.Index(i).Interface().(Model).SetModelID(id)
+	}
+	fieldNames := q.fieldNames()
+	keyFields := q.keyFields()
+	found := make([]bool, len(ids))
+	chunkSize := (len(ids) + q.workers - 1) / q.workers
+	errs := make(chan error, q.workers)
+	wg := sync.WaitGroup{}
+	for start := 0; start < len(ids); start += chunkSize {
+		stop := start + chunkSize
+		if stop > len(ids) {
+			stop = len(ids)
+		}
+		wg.Add(1)
+		go func(start, stop int) {
+			defer wg.Done()
+			if err := q.readModels(results.Slice(start, stop), fieldNames, keyFields, found[start:stop]); err != nil {
+				errs <- err
+			}
+		}(start, stop)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	existing := results.Slice(0, 0)
+	for i := range ids {
+		if found[i] {
+			existing = reflect.Append(existing, results.Index(i))
+		}
+	}
+	results = existing
+	modelsVal.Set(results)
+	return nil
+}
+
+// readModels reads the given fields and the given fields stored in their own
+// key of each model in models (a slice of models with their ids already set) in
+// a single transaction. It sets found[i] to true iff the key of models[i]
+// exists, i.e. the model has not been deleted. Whether any of the given fields
+// are set does not matter, since they may all be unset (or stored elsewhere,
+// like ttl and key fields) for a model which exists.
+func (q *Query) readModels(models reflect.Value, fieldNames []string, keyFields []*fieldSpec, found []bool) error {
+	tx := q.newTransaction()
+	redisNames := q.redisFieldNames()
+	ids := make([]string, models.Len())
+	for i := 0; i < models.Len(); i++ {
+		mr := &modelRef{
+			collection: q.collection,
+			model:      models.Index(i).Interface().(Model),
+			spec:       q.collection.spec,
+		}
+		ids[i] = mr.model.ModelID()
+		tx.modelCommand(ids[i], "EXISTS", redis.Args{mr.key()}, NewScanBoolHandler(&found[i]))
+		for _, fs := range keyFields {
+			tx.findKeyField(mr, fs)
+		}
+		if len(fieldNames) == 0 {
+			continue
+		}
+		scanHandler := newScanModelRefHandler(fieldNames, mr)
+		i := i
+		tx.readModelFields(mr, redisNames, func(reply interface{}) error {
+			if !found[i] {
+				return nil
+			}
+			fieldValues, err := redis.Values(reply, nil)
+			if err != nil {
+				return err
+			}
+			return scanHandler(fieldValues)
+		})
+	}
+	if q.collection.strictScan {
+		tx.checkHashFieldsForIDs(q.collection, ids...)
+	}
+	return tx.Exec()
+}
+
 // RunOne is exactly like Run but finds only the first model that fits the query
 // criteria and scans the values into model. If no model fits the criteria,
 // RunOne *will* return a ModelNotFoundError.
 func (q *Query) RunOne(model Model) error {
-	tx := q.pool.NewTransaction()
+	tx := q.newTransaction()
 	newTransactionQuery(q.query, tx).RunOne(model)
 	return tx.Exec()
 }
 
+// First is exactly like RunOne. It finds the first model that fits the query
+// criteria, according to Order and Offset, and scans the values into model. If
+// no model fits the criteria, First returns a ModelNotFoundError. If more than
+// one model fits the criteria, the others are ignored.
+func (q *Query) First(model Model) error {
+	tx := q.newTransaction()
+	newTransactionQuery(q.query, tx).First(model)
+	return tx.Exec()
+}
+
+// LastOne is like First but finds the last model that fits the query criteria
+// according to Order, i.e. the first model when the order is reversed. Offset
+// counts from the end, so Order("Age").Offset(1).LastOne(model) finds the
+// model with the second highest Age. The query must have an Order and cannot
+// be combined with Last. If no model fits the criteria, LastOne returns a
+// ModelNotFoundError.
+func (q *Query) LastOne(model Model) error {
+	tx := q.newTransaction()
+	newTransactionQuery(q.query, tx).LastOne(model)
+	return tx.Exec()
+}
+
+// RunExactlyOne is like RunOne but expects exactly one model to fit the query
+// criteria. If no model fits the criteria, RunExactlyOne returns a
+// ModelNotFoundError, and if more than one model fits the criteria, it returns
+// a MultipleModelsFoundError. In either case the values of model are
+// unspecified. Limit is ignored, but Offset is not.
+func (q *Query) RunExactlyOne(model Model) error {
+	tx := q.newTransaction()
+	newTransactionQuery(q.query, tx).RunExactlyOne(model)
+	return tx.Exec()
+}
+
+// PageInfo describes the page of models returned by RunPage.
+type PageInfo struct {
+	// Total is the number of models which fit the query criteria on all pages.
+	Total int
+	// Pages is the number of pages needed to hold Total models.
+	Pages int
+	// HasNext is true if there is at least one page after the one returned.
+	HasNext bool
+}
+
+// RunPage runs the query and scans the models on the given page into models,
+// which should be a pointer to a slice of models, in the same way as Run. Pages
+// start at 1 and hold pageSize models each, so page and pageSize replace any
+// Limit and Offset of the query. RunPage also counts the total number of models
+// which fit the query criteria in the same transaction, so the returned
+// PageInfo is always consistent with the models on the page. If page is past
+// the last page, models will be empty.
+func (q *Query) RunPage(models interface{}, page, pageSize int) (*PageInfo, error) {
+	tx := q.newTransaction()
+	info := &PageInfo{}
+	newTransactionQuery(q.query, tx).RunPage(models, page, pageSize, info)
+	if err := tx.Exec(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
 // Count counts the number of models that would be returned by the query without
-// actually retrieving the models themselves. Count will also return the first
+// actually retrieving the models themselves. The ids are counted on the server
+// without being copied into a list, and queries with a single filter are
+// counted directly from the field index. Count will also return the first
 // error that occurred during the lifetime of the query (if any).
 func (q *Query) Count() (int, error) {
-	tx := q.pool.NewTransaction()
+	tx := q.newTransaction()
 	var count int
 	newTransactionQuery(q.query, tx).Count(&count)
 	if err := tx.Exec(); err != nil {
@@ -128,11 +489,35 @@ func (q *Query) Count() (int, error) {
 	return count, nil
 }
 
+// EstimateCount returns an approximate count of the models that would be
+// returned by the query. Instead of intersecting the sets of ids which match
+// each filter, it counts the models which match each filter on its own
+// directly from the field indexes (e.g. with ZCOUNT or ZCARD). Then it samples
+// 1,000 of the ids which match the most selective filter, at random positions
+// in its index ranges, and checks whether each of them matches the other
+// filters, which takes O(log(N)) time per id. The count of the most selective
+// filter is scaled by the fraction of the sampled ids which match. This makes
+// it much faster than Count for very large collections, e.g. for dashboards,
+// and it accounts for correlated filters, but the estimate can be off if only
+// a few of the sampled ids match. If every filter is a != filter, the filters
+// are assumed to be independent. Queries whose most selective filter matches
+// no more than 1,000 models are counted exactly. Queries which use Search,
+// FromIDSet, Join, or RediSearch are counted exactly with Count.
+func (q *Query) EstimateCount() (int, error) {
+	tx := q.newTransaction()
+	var count int
+	newTransactionQuery(q.query, tx).EstimateCount(&count)
+	if err := tx.Exec(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 // IDs returns only the ids of the models without actually retrieving the
 // models themselves. IDs will return the first error that occurred during the
 // lifetime of the query (if any).
 func (q *Query) IDs() ([]string, error) {
-	tx := q.pool.NewTransaction()
+	tx := q.newTransaction()
 	ids := []string{}
 	newTransactionQuery(q.query, tx).IDs(&ids)
 	if err := tx.Exec(); err != nil {
@@ -141,13 +526,79 @@ func (q *Query) IDs() ([]string, error) {
 	return ids, nil
 }
 
+// IDScore is a model id together with the score used to order it, i.e. the
+// value of the field passed to Order. It is returned by IDsWithScores.
+type IDScore struct {
+	ID    string
+	Score float64
+}
+
+// IDsWithScores is like IDs but also returns the value of the order field for
+// each model as a float64 (boolean values are 0 or 1). This is useful for
+// ranking applications which need the sort key along with the ids. The query
+// must have an Order modifier on a numeric or boolean field. IDsWithScores
+// will return the first error that occurred during the lifetime of the query
+// (if any).
+func (q *Query) IDsWithScores() ([]IDScore, error) {
+	tx := q.newTransaction()
+	results := []IDScore{}
+	newTransactionQuery(q.query, tx).IDsWithScores(&results)
+	if err := tx.Exec(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 // StoreIDs executes the query and stores the model ids matching the query
 // criteria in a list identified by destKey. The list will be completely
 // overwritten, and the model ids stored there will be in the correct order if
 // the query includes an Order modifier. StoreIDs will return the first error
 // that occurred during the lifetime of the query (if any).
 func (q *Query) StoreIDs(destKey string) error {
-	tx := q.pool.NewTransaction()
+	tx := q.newTransaction()
 	newTransactionQuery(q.query, tx).StoreIDs(destKey)
 	return tx.Exec()
 }
+
+// Delete deletes all the models that match the query criteria, including their
+// field indexes, and returns the number of models that were deleted. Order,
+// Limit, Offset, and Last are taken into account when determining which models
+// to delete. The ids of the models are stored in a temporary key (just like
+// StoreIDs) and then the models are deleted by a Lua script, all in a single
+// MULTI/EXEC transaction, so no other client can change which models match
+// between the two steps. Delete will also return the first error that occurred
+// during the lifetime of the query (if any). It returns an error if the models
+// are referenced by a field with the ref option, which only Collection.Delete
+// enforces.
+func (q *Query) Delete() (int, error) {
+	tx := q.newTransaction()
+	var count int
+	newTransactionQuery(q.query, tx).Delete(&count)
+	if err := tx.Exec(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Update sets the given fields for all the models that match the query
+// criteria and returns the number of models that were updated. fieldValues
+// should be a map of field names (as they appear in the struct definition) to
+// new values. Each value must have the same type as the corresponding field,
+// except that the underlying type may be used for pointer fields. Any field
+// indexes are updated accordingly. The ids of the models are stored in a
+// temporary key (just like StoreIDs) and then the models are updated by a Lua
+// script, all in a single MULTI/EXEC transaction, so, unlike Run followed by
+// SaveFields, there is no window in which another client could observe a
+// partial update. If the models embed Timestamps, UpdatedAt is also set to the
+// current time unless it is included in fieldValues. Update will return an
+// error if any of the field names or values are invalid, or the first error
+// that occurred during the lifetime of the query (if any).
+func (q *Query) Update(fieldValues map[string]interface{}) (int, error) {
+	tx := q.newTransaction()
+	var count int
+	newTransactionQuery(q.query, tx).Update(fieldValues, &count)
+	if err := tx.Exec(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_csv.go contains code for exporting the results of a query as
+// CSV.
+
+package zoom
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// csvBatchSize is the number of models read in a single transaction by
+// RunCSV.
+const csvBatchSize = 1000
+
+// RunCSV runs the query and writes the models which match the query criteria
+// to w as CSV, without scanning them into models. The first row is a header
+// with "id" followed by the redis names of the given fields (which are the
+// names of the fields in the Go type), and each following row contains the id
+// and field values of a model. If no fields are given, the fields that Run
+// would read are written (see Include and Exclude). Values are written in the
+// same format as fmt.Sprint for the type of the field, except that nil
+// pointers are written as empty strings and fields which implement
+// encoding.TextMarshaler are written in their text form. The models are read
+// and written in batches, so RunCSV can export a large number of models
+// without holding them all in memory. RunCSV returns an error if any of the
+// fields are stored in their own key or are encoded with a
+// MarshalerUnmarshaler, and like Run it returns the first error that occurred
+// during the lifetime of the query (if any). If an error occurs while models
+// are being read, some rows may already have been written to w.
+func (q *Query) RunCSV(w io.Writer, fields ...string) error {
+	q = &Query{query: q.query.intercept("RunCSV")}
+	if q.hasError() {
+		return q.err
+	}
+	if len(fields) == 0 {
+		fields = q.fieldNames()
+	}
+	spec := q.collection.spec
+	fieldSpecs := make([]*fieldSpec, len(fields))
+	header := []string{"id"}
+	redisNames := make([]string, len(fields))
+	for i, fieldName := range fields {
+		fs, err := spec.csvField(fieldName)
+		if err != nil {
+			return err
+		}
+		fieldSpecs[i] = fs
+		redisNames[i] = fs.redisName
+		header = append(header, fs.redisName)
+	}
+	ids, err := q.IDs()
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for start := 0; start < len(ids); start += csvBatchSize {
+		stop := start + csvBatchSize
+		if stop > len(ids) {
+			stop = len(ids)
+		}
+		rows := make([][]string, stop-start)
+		tx := q.newTransaction()
+		for i, id := range ids[start:stop] {
+			id, row := id, &rows[i]
+			mr := &modelRef{
+				collection: q.collection,
+				model:      &RandomID{ID: id},
+				spec:       spec,
+			}
+			tx.readModelFields(mr, redisNames, func(reply interface{}) error {
+				values, err := redis.Values(reply, nil)
+				if err != nil {
+					return err
+				}
+				*row, err = spec.csvRow(id, fieldSpecs, values)
+				return err
+			})
+		}
+		if err := tx.Exec(); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			// Models which were deleted after the ids were read are skipped.
+			if row != nil {
+				if err := writer.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvField returns the fieldSpec for the field with the given name, or an
+// error if the field cannot be written by RunCSV.
+func (ms *modelSpec) csvField(fieldName string) (*fieldSpec, error) {
+	fs, found := ms.fieldsByName[fieldName]
+	if !found {
+		if _, found := ms.keyFieldByName(fieldName); found {
+			return nil, fmt.Errorf("zoom: error in Query.RunCSV: field %s in type %s is stored in its own key", fieldName, ms.typ.String())
+		}
+		return nil, newKindError(ErrFieldNotFound, "zoom: error in Query.RunCSV: could not find field %s in type %s", fieldName, ms.typ.String())
+	}
+	if fs.kind == inconvertibleField {
+		typ := fs.typ
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if interfaceEncodingForType(typ) != textInterfaceEncoding {
+			return nil, fmt.Errorf("zoom: error in Query.RunCSV: field %s in type %s is not stored as text", fieldName, ms.typ.String())
+		}
+	}
+	return fs, nil
+}
+
+// csvRow converts the values of the given fields of the model with the given
+// id, as they are stored in the main hash, to a row for RunCSV. It returns a
+// nil row if the model does not exist, i.e. if all the values are nil.
+func (ms *modelSpec) csvRow(id string, fieldSpecs []*fieldSpec, values []interface{}) ([]string, error) {
+	row := make([]string, len(fieldSpecs)+1)
+	row[0] = id
+	exists := len(values) == 0
+	for i, fs := range fieldSpecs {
+		if values[i] == nil {
+			continue
+		}
+		exists = true
+		src, err := redis.Bytes(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		if string(src) == "NULL" && fs.kind != primativeField {
+			continue
+		}
+		if fs.kind == inconvertibleField {
+			// Only fields which are stored as text are allowed (see csvField).
+			row[i+1] = string(src)
+			continue
+		}
+		fieldVal := reflect.New(fs.typ).Elem()
+		if err := ms.scanFieldVal(fs, src, fieldVal); err != nil {
+			return nil, err
+		}
+		if fs.kind == pointerField {
+			fieldVal = fieldVal.Elem()
+		}
+		if typeIsString(fieldVal.Type()) && fieldVal.Kind() != reflect.String {
+			// Byte slices and arrays are written as strings.
+			row[i+1] = string(fieldVal.Slice(0, fieldVal.Len()).Bytes())
+			continue
+		}
+		row[i+1] = fmt.Sprint(fieldVal.Interface())
+	}
+	if !exists {
+		return nil, nil
+	}
+	return row, nil
+}
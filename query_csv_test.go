@@ -0,0 +1,78 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_csv_test.go tests the code in query_csv.go
+
+package zoom
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type csvTestModel struct {
+	Name     string `zoom:"index" redis:"name"`
+	Age      int    `zoom:"index"`
+	Nickname *string
+	Admin    bool
+	Data     []byte
+	Status   string `zoom:"enum=active|inactive"`
+	Joined   time.Time
+	Tags     []string
+	Items    []string `zoom:"list"`
+	RandomID
+}
+
+func TestQueryRunCSV(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&csvTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	nickname := "bobby, \"the builder\""
+	joined := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	models := []*csvTestModel{
+		{Name: "alice", Age: 30, Admin: true, Data: []byte("x"), Status: "active", Joined: joined},
+		{Name: "bob", Age: 20, Nickname: &nickname, Status: "inactive", Joined: joined},
+		{Name: "carol", Age: 40, Status: "active"},
+	}
+	for _, model := range models {
+		require.NoError(t, col.Save(model))
+	}
+	readCSV := func(q *Query, fields ...string) [][]string {
+		buf := &bytes.Buffer{}
+		require.NoError(t, q.RunCSV(buf, fields...))
+		records, err := csv.NewReader(buf).ReadAll()
+		require.NoError(t, err)
+		return records
+	}
+
+	// The given fields should be written with redis names as headers
+	records := readCSV(col.NewQuery().Filter("Age <=", 30).Order("Age"), "Name", "Nickname", "Admin", "Data", "Status", "Joined")
+	assert.Equal(t, [][]string{
+		{"id", "name", "Nickname", "Admin", "Data", "Status", "Joined"},
+		{models[1].ID, "bob", nickname, "false", "", "inactive", "2020-01-02T03:04:05Z"},
+		{models[0].ID, "alice", "", "true", "x", "active", "2020-01-02T03:04:05Z"},
+	}, records)
+
+	// Without fields, the fields read by Run should be written
+	records = readCSV(col.NewQuery().Order("-Age").Limit(1).Include("Name", "Age"))
+	assert.Equal(t, [][]string{{"id", "name", "Age"}, {models[2].ID, "carol", "40"}}, records)
+	records = readCSV(col.NewQuery().Filter("Age >", 100), "Name")
+	assert.Equal(t, [][]string{{"id", "name"}}, records)
+
+	// Fields which cannot be written as text should be rejected
+	for _, field := range []string{"Tags", "Items", "Missing"} {
+		assert.Error(t, col.NewQuery().RunCSV(&bytes.Buffer{}, field), "expected an error for field %s", field)
+	}
+}
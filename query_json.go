@@ -0,0 +1,302 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_json.go contains code for converting queries to and from JSON.
+
+package zoom
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// queryJSON is the JSON representation of a query. Filters on joined
+// collections are stored with the other filters and use the alias of the join,
+// e.g. "Author.Country", the same as they would be passed to Filter.
+type queryJSON struct {
+	Collection string         `json:"collection"`
+	FromIDSets []string       `json:"fromIDSets,omitempty"`
+	IDFilters  []idFilterJSON `json:"idFilters,omitempty"`
+	Joins      []joinJSON     `json:"joins,omitempty"`
+	Filters    []filterJSON   `json:"filters,omitempty"`
+	Searches   []searchJSON   `json:"searches,omitempty"`
+	Order      string         `json:"order,omitempty"`
+	Last       uint           `json:"last,omitempty"`
+	Offset     uint           `json:"offset,omitempty"`
+	Limit      uint           `json:"limit,omitempty"`
+	Timeout    string         `json:"timeout,omitempty"`
+	Parallel   int            `json:"parallel,omitempty"`
+	Include    []string       `json:"include,omitempty"`
+	Exclude    []string       `json:"exclude,omitempty"`
+
+	// OrderByExpr maps field names to weights if the query was ordered with
+	// OrderByExpr instead of Order.
+	OrderByExpr map[string]float64 `json:"orderByExpr,omitempty"`
+	// Not contains the JSON representations of the sub-queries given to Not.
+	Not []json.RawMessage `json:"not,omitempty"`
+	// Sample is the number of models given to Sample.
+	Sample uint `json:"sample,omitempty"`
+}
+
+type joinJSON struct {
+	Field      string `json:"field"`
+	Collection string `json:"collection"`
+}
+
+// filterJSON is the JSON representation of a filter. For range filters, Op is
+// "RANGE", Value is the lower bound and Max is the upper bound. For the IS and
+// IS NOT operators, Value is omitted.
+type filterJSON struct {
+	Field     string          `json:"field"`
+	Op        string          `json:"op"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Max       json.RawMessage `json:"max,omitempty"`
+	Inclusive bool            `json:"inclusive,omitempty"`
+}
+
+// idFilterJSON is the JSON representation of an idFilter. For FilterIDPrefix,
+// Op is "PREFIX".
+type idFilterJSON struct {
+	Op string `json:"op"`
+	ID string `json:"id"`
+}
+
+type searchJSON struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+}
+
+// MarshalJSON satisfies json.Marshaler. It encodes all the modifiers of the
+// query (including the name of its collection, but not the collection itself)
+// so that the query can be stored, e.g. as a saved search, and rebuilt later
+// with UnmarshalQuery. Filter values are encoded with encoding/json. It returns
+// an error if an error was set on the query by one of its modifiers.
+func (q *Query) MarshalJSON() ([]byte, error) {
+	if q.hasError() {
+		return nil, fmt.Errorf("zoom: error in Query.MarshalJSON: the query has an error: %w", q.err)
+	}
+	qj := queryJSON{
+		Collection: q.collection.Name(),
+		FromIDSets: q.idSets,
+		Last:       q.last,
+		Offset:     q.offset,
+		Limit:      q.limit,
+		Parallel:   q.workers,
+		Include:    q.includes,
+		Exclude:    q.excludes,
+		Sample:     q.sample,
+	}
+	for _, f := range q.idFilters {
+		fj := idFilterJSON{Op: f.op.String(), ID: f.id}
+		if f.prefix {
+			fj.Op = "PREFIX"
+		}
+		qj.IDFilters = append(qj.IDFilters, fj)
+	}
+	for _, j := range q.joins {
+		qj.Joins = append(qj.Joins, joinJSON{
+			Field:      j.fieldSpec.name,
+			Collection: j.target.Name(),
+		})
+		for _, fltr := range j.filters {
+			fj, err := fltr.toJSON(j.alias + "." + fltr.fieldSpec.name)
+			if err != nil {
+				return nil, err
+			}
+			qj.Filters = append(qj.Filters, fj)
+		}
+	}
+	for _, fltr := range q.filters {
+		fj, err := fltr.toJSON(fltr.fieldSpec.name)
+		if err != nil {
+			return nil, err
+		}
+		qj.Filters = append(qj.Filters, fj)
+	}
+	for _, s := range q.searches {
+		qj.Searches = append(qj.Searches, searchJSON{
+			Field: s.fieldSpec.name,
+			Text:  s.text,
+		})
+	}
+	for _, negation := range q.negations {
+		data, err := (&Query{query: negation}).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		qj.Not = append(qj.Not, data)
+	}
+	if q.hasExplicitOrder() && q.order.isExpr() {
+		qj.OrderByExpr = q.order.exprWeights()
+	} else if q.hasExplicitOrder() {
+		qj.Order = q.order.fieldName
+		if q.order.kind == descendingOrder {
+			qj.Order = "-" + qj.Order
+		}
+	}
+	if q.timeout > 0 {
+		qj.Timeout = q.timeout.String()
+	}
+	return json.Marshal(qj)
+}
+
+// toJSON returns the JSON representation of f, using name as the name of the
+// field.
+func (f filter) toJSON(name string) (filterJSON, error) {
+	fj := filterJSON{
+		Field:     name,
+		Op:        f.op.String(),
+		Inclusive: f.inclusive,
+	}
+	if f.op == isNullOp || f.op == isNotNullOp {
+		return fj, nil
+	}
+	var err error
+	if fj.Value, err = json.Marshal(f.value.Interface()); err != nil {
+		return fj, fmt.Errorf("zoom: error in Query.MarshalJSON: could not encode value for filter on %s: %w", name, err)
+	}
+	if f.op == rangeOp {
+		if fj.Max, err = json.Marshal(f.max.Interface()); err != nil {
+			return fj, fmt.Errorf("zoom: error in Query.MarshalJSON: could not encode value for filter on %s: %w", name, err)
+		}
+	}
+	return fj, nil
+}
+
+// UnmarshalQuery returns a new query for collection built from data, which
+// should be the result of Query.MarshalJSON. It returns an error if data was
+// produced by a query for a different collection, or if any of the modifiers
+// are invalid, e.g. because a field was removed from the model since the query
+// was encoded. The collections used by any joins are looked up by name in the
+// pool of collection.
+func UnmarshalQuery(collection *Collection, data []byte) (*Query, error) {
+	if collection == nil {
+		return nil, newNilCollectionError("UnmarshalQuery")
+	}
+	qj := queryJSON{}
+	if err := json.Unmarshal(data, &qj); err != nil {
+		return nil, fmt.Errorf("zoom: error in UnmarshalQuery: %w", err)
+	}
+	if qj.Collection != collection.Name() {
+		return nil, fmt.Errorf("zoom: error in UnmarshalQuery: the query is for collection %s but the given collection is %s", qj.Collection, collection.Name())
+	}
+	// The encoded query already includes the modifiers of the default scope of
+	// the collection (if any), so it must not be applied again.
+	q := &Query{query: newQuery(collection)}
+	q.applyDefaultOrder()
+	for _, key := range qj.FromIDSets {
+		q.FromIDSet(key)
+	}
+	for _, fj := range qj.IDFilters {
+		if fj.Op == "PREFIX" {
+			q.FilterIDPrefix(fj.ID)
+		} else {
+			q.FilterID(fj.Op, fj.ID)
+		}
+	}
+	for _, jj := range qj.Joins {
+		target, found := collection.pool.Collection(jj.Collection)
+		if !found {
+			return nil, fmt.Errorf("zoom: error in UnmarshalQuery: could not find a collection named %s for the join on %s", jj.Collection, jj.Field)
+		}
+		q.Join(jj.Field, target)
+	}
+	for _, fj := range qj.Filters {
+		if err := q.unmarshalFilter(fj); err != nil {
+			return nil, err
+		}
+	}
+	for _, sj := range qj.Searches {
+		q.Search(sj.Field, sj.Text)
+	}
+	for _, data := range qj.Not {
+		negation, err := UnmarshalQuery(collection, data)
+		if err != nil {
+			return nil, err
+		}
+		q.addNegation(negation.query)
+	}
+	if qj.Order != "" {
+		q.Order(qj.Order)
+	}
+	if len(qj.OrderByExpr) > 0 {
+		q.OrderByExpr(qj.OrderByExpr)
+	}
+	q.Last(qj.Last)
+	q.Sample(qj.Sample)
+	q.Offset(qj.Offset)
+	q.Limit(qj.Limit)
+	if qj.Timeout != "" {
+		timeout, err := time.ParseDuration(qj.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("zoom: error in UnmarshalQuery: invalid timeout: %w", err)
+		}
+		q.Timeout(timeout)
+	}
+	if qj.Parallel > 0 {
+		q.Parallel(qj.Parallel)
+	}
+	if len(qj.Include) > 0 {
+		q.Include(qj.Include...)
+	}
+	if len(qj.Exclude) > 0 {
+		q.Exclude(qj.Exclude...)
+	}
+	if q.hasError() {
+		return nil, q.err
+	}
+	return q, nil
+}
+
+// unmarshalFilter decodes the value of fj into the type of the field and then
+// applies the filter to q.
+func (q *Query) unmarshalFilter(fj filterJSON) error {
+	if q.hasError() {
+		return q.err
+	}
+	fs, _, err := q.filterField("UnmarshalQuery", fj.Field)
+	if err != nil {
+		return err
+	}
+	typ := fs.typ
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	decode := func(raw json.RawMessage, typ reflect.Type) (interface{}, error) {
+		val := reflect.New(typ)
+		if err := json.Unmarshal(raw, val.Interface()); err != nil {
+			return nil, fmt.Errorf("zoom: error in UnmarshalQuery: invalid value for filter on %s: %w", fj.Field, err)
+		}
+		return val.Elem().Interface(), nil
+	}
+	switch fj.Op {
+	case isNullOp.String(), isNotNullOp.String():
+		q.Filter(fj.Field+" "+fj.Op, nil)
+	case rangeOp.String():
+		min, err := decode(fj.Value, typ)
+		if err != nil {
+			return err
+		}
+		max, err := decode(fj.Max, typ)
+		if err != nil {
+			return err
+		}
+		q.FilterRange(fj.Field, min, max, fj.Inclusive)
+	case inOp.String():
+		values, err := decode(fj.Value, reflect.SliceOf(typ))
+		if err != nil {
+			return err
+		}
+		q.Filter(fj.Field+" "+fj.Op, values)
+	default:
+		value, err := decode(fj.Value, typ)
+		if err != nil {
+			return err
+		}
+		q.Filter(fj.Field+" "+fj.Op, value)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_json_test.go tests the code in query_json.go
+
+package zoom
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryJSONRoundTrip(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	testQueries := []*Query{
+		indexedTestModels.NewQuery(),
+		indexedTestModels.NewQuery().Filter("Int >=", 18).Order("-Int").Limit(20).Offset(5),
+		indexedTestModels.NewQuery().Filter("String =", "foo").Filter("Bool !=", true).Include("Int", "String"),
+		indexedTestModels.NewQuery().Filter("Int IN", []int{1, 2, 3}).FilterRange("String", "a", "m", true).Exclude("Bool"),
+		indexedTestModels.NewQuery().FromIDSet("some:ids").Order("String").Last(3).Timeout(2 * time.Second).Parallel(4),
+		indexedTestModels.NewQuery().FilterID(">=", "abc").FilterIDPrefix("ab").Filter("Int <", 5),
+		indexedPointersModels.NewQuery().Filter("Int IS", nil).Filter("String IS NOT", nil).Filter("Float64 >", 1.5),
+		fullTextModels.NewQuery().Search("Title", "running dogs").Filter("Rank <", 10),
+		joinPosts.NewQuery().Join("AuthorID", joinAuthors).Filter("Author.Country =", "US").Filter("Likes >", 5),
+	}
+	for _, q := range testQueries {
+		data, err := json.Marshal(q)
+		require.NoError(t, err, "query: %s", q)
+		got, err := UnmarshalQuery(q.collection, data)
+		require.NoError(t, err, "query: %s, json: %s", q, data)
+		assert.Equal(t, q.String(), got.String(), "json: %s", data)
+	}
+}
+
+func TestQueryJSONRun(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(10)
+	require.NoError(t, err)
+	q := indexedTestModels.NewQuery().Filter("Int >", models[0].Int).Order("Int")
+	data, err := json.Marshal(q)
+	require.NoError(t, err)
+	got, err := UnmarshalQuery(indexedTestModels, data)
+	require.NoError(t, err)
+	expectedIDs, err := q.IDs()
+	require.NoError(t, err)
+	gotIDs, err := got.IDs()
+	require.NoError(t, err)
+	assert.Equal(t, expectedIDs, gotIDs)
+}
+
+func TestQueryJSONErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Queries with an error cannot be marshaled
+	_, err := json.Marshal(indexedTestModels.NewQuery().Filter("Missing =", 1))
+	assert.True(t, errors.Is(err, ErrFieldNotFound), "err: %v", err)
+
+	data, err := json.Marshal(indexedTestModels.NewQuery().Filter("Int =", 1))
+	require.NoError(t, err)
+
+	// The collection must match
+	_, err = UnmarshalQuery(testModels, data)
+	assert.Error(t, err)
+	_, err = UnmarshalQuery(nil, data)
+	assert.Error(t, err)
+
+	testCases := []struct {
+		collection *Collection
+		data       string
+	}{
+		{indexedTestModels, `not json`},
+		{indexedTestModels, `{"collection": "indexedTestModel", "filters": [{"field": "Missing", "op": "=", "value": 1}]}`},
+		{indexedTestModels, `{"collection": "indexedTestModel", "filters": [{"field": "Int", "op": "=", "value": "foo"}]}`},
+		{indexedTestModels, `{"collection": "indexedTestModel", "filters": [{"field": "Int", "op": "~", "value": 1}]}`},
+		{indexedTestModels, `{"collection": "indexedTestModel", "order": "Missing"}`},
+		{indexedTestModels, `{"collection": "indexedTestModel", "timeout": "soon"}`},
+		{joinPosts, `{"collection": "joinPost", "joins": [{"field": "AuthorID", "collection": "Missing"}]}`},
+	}
+	for _, tc := range testCases {
+		_, err := UnmarshalQuery(tc.collection, []byte(tc.data))
+		assert.Error(t, err, "json: %s", tc.data)
+	}
+}
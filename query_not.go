@@ -0,0 +1,129 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_not.go contains code for excluding the models which match a
+// sub-query from the results of a query.
+
+package zoom
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Not excludes the models which match a sub-query from the results of the
+// query. fn is called with a new query for the same collection, to which it
+// can apply any of the modifiers which restrict the models (Filter,
+// FilterRange, Search, FromIDSet, FilterID, FilterIDPrefix, Join, and Not
+// itself). For example:
+//
+//	Users.NewQuery().Not(func(q *zoom.Query) {
+//		q.Filter("Country =", "US").Filter("Age <", 18)
+//	})
+//
+// returns all the users except the minors from the US. The difference is
+// computed server-side with set operations, so the excluded models are never
+// read. If Not is called more than once, the models which match any of the
+// sub-queries are excluded. The default scope of the collection is not applied
+// to the sub-query. Not will set an error on the query if fn does not apply
+// any modifiers, if it applies a modifier which does not restrict the models
+// (e.g. Order or Limit), or if any of the modifiers set an error on the
+// sub-query. The error, same as any other error that occurs during the
+// lifetime of the query, is not returned until the query is executed.
+func (q *Query) Not(fn func(q *Query)) *Query {
+	q.query.Not(fn)
+	return q
+}
+
+// Not excludes the models which match a sub-query from the results of the
+// query. It returns the query so you can chain multiple modifiers together.
+// See the documentation for Query.Not for more information.
+func (q *TransactionQuery) Not(fn func(q *Query)) *TransactionQuery {
+	q.query.Not(fn)
+	return q
+}
+
+// Not is like Query.Not.
+func (q *TypedQuery[T, PT]) Not(fn func(q *Query)) *TypedQuery[T, PT] {
+	q.query.Not(fn)
+	return q
+}
+
+// Not is like Query.Not. fn is called once for each shard.
+func (sq *ShardedQuery) Not(fn func(q *Query)) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Not(fn)
+	}
+	return sq
+}
+
+// Not adds the sub-query built by fn to the negations of the query. See
+// Query.Not.
+func (q *query) Not(fn func(q *Query)) {
+	if fn == nil {
+		q.setError(fmt.Errorf("zoom: error in Query.Not: fn cannot be nil"))
+		return
+	}
+	sub := newQuery(q.collection)
+	fn(&Query{query: sub})
+	q.addNegation(sub)
+}
+
+// addNegation adds sub to the negations of the query after checking that it
+// only has modifiers which restrict the models.
+func (q *query) addNegation(sub *query) {
+	switch {
+	case sub.hasError():
+		q.setError(fmt.Errorf("zoom: error in Query.Not: %w", sub.err))
+		return
+	case sub.hasExplicitOrder() || sub.hasLimit() || sub.hasOffset() || sub.hasLast() || sub.hasIncludes() || sub.hasExcludes() || sub.timeout > 0 || sub.workers > 1:
+		q.setError(fmt.Errorf("zoom: error in Query.Not: the sub-query can only filter the models but got %s", sub))
+		return
+	case !sub.hasFilters() && !sub.hasSearches() && !sub.hasIDSets() && !sub.hasIDFilters() && !sub.hasJoins() && !sub.hasNegations():
+		q.setError(fmt.Errorf("zoom: error in Query.Not: the sub-query must have at least one modifier (otherwise it would exclude every model)"))
+		return
+	}
+	// The order of the sub-query does not matter, so there is no need to
+	// extract the ids from the index of the default order.
+	sub.order = order{}
+	sub.defaultOrder = false
+	q.negations = append(q.negations, sub)
+}
+
+// hasNegations returns true iff Not was applied to the query.
+func (q *query) hasNegations() bool {
+	return len(q.negations) > 0
+}
+
+// notString returns the call to Not which added sub to the negations of a
+// query.
+func notString(sub *query) string {
+	prefix := fmt.Sprintf("%s.NewQuery()", sub.collection.Name())
+	return fmt.Sprintf("Not(func(q *Query) { q%s })", strings.TrimPrefix(sub.String(), prefix))
+}
+
+// excludeNegation adds commands to the query transaction which, when run, will
+// remove the ids of the models which match the sub-query negation from origKey
+// and store the result in destKey.
+func excludeNegation(tx *Transaction, negation *query, origKey string, destKey string) error {
+	negatedIDsKey, negatedTmpKeys, err := generateIDsSet(negation, tx)
+	if err != nil {
+		return err
+	}
+	tx.diffIDsWithKey(origKey, negatedIDsKey, destKey)
+	if len(negatedTmpKeys) > 0 {
+		tx.Command("DEL", (redis.Args{}).Add(negatedTmpKeys...), nil)
+	}
+	return nil
+}
+
+// diffIDsWithKey is a small function wrapper around a Lua script. The script
+// will store the ids in origKey which are not in the set or sorted set
+// identified by idsKey in a sorted set identified by destKey, preserving the
+// scores from origKey.
+func (t *Transaction) diffIDsWithKey(origKey, idsKey, destKey string) {
+	t.Script(diffIdsWithKeyScript, redis.Args{origKey, idsKey, destKey}, nil)
+}
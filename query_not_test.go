@@ -0,0 +1,128 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_not_test.go tests the code in query_not.go
+
+package zoom
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryNot(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := make([]*indexedTestModel, 10)
+	tx := testPool.NewTransaction()
+	for i := range models {
+		models[i] = &indexedTestModel{Int: i, String: randomString(), Bool: i%2 == 0}
+		tx.Save(indexedTestModels, models[i])
+	}
+	require.NoError(t, tx.Exec())
+	idsOf := func(models ...*indexedTestModel) []string {
+		ids := []string{}
+		for _, model := range models {
+			ids = append(ids, model.ID)
+		}
+		return ids
+	}
+
+	testCases := []struct {
+		q        *Query
+		expected []string
+	}{
+		{
+			q: indexedTestModels.NewQuery().Order("Int").Not(func(q *Query) {
+				q.Filter("Int <", 3)
+			}),
+			expected: idsOf(models[3:]...),
+		},
+		{
+			q: indexedTestModels.NewQuery().Filter("Bool =", true).Order("-Int").Not(func(q *Query) {
+				q.Filter("Int >=", 8)
+			}),
+			expected: idsOf(models[6], models[4], models[2], models[0]),
+		},
+		{
+			// The models which match any of the sub-queries should be excluded
+			q: indexedTestModels.NewQuery().Order("Int").Not(func(q *Query) {
+				q.Filter("Int <", 4)
+			}).Not(func(q *Query) {
+				q.Filter("Bool =", false)
+			}),
+			expected: idsOf(models[4], models[6], models[8]),
+		},
+		{
+			// Sub-queries can be nested
+			q: indexedTestModels.NewQuery().Order("Int").Not(func(q *Query) {
+				q.Filter("Int <", 5).Not(func(q *Query) {
+					q.Filter("Int =", 2)
+				})
+			}),
+			expected: idsOf(models[2], models[5], models[6], models[7], models[8], models[9]),
+		},
+		{
+			q: indexedTestModels.NewQuery().Order("Int").Not(func(q *Query) {
+				q.FilterID("=", models[1].ID)
+			}).Limit(2),
+			expected: idsOf(models[0], models[2]),
+		},
+		{
+			q: indexedTestModels.NewQuery().Order("Int").Last(2).Not(func(q *Query) {
+				q.Filter("Int >", 7)
+			}),
+			expected: idsOf(models[6], models[7]),
+		},
+	}
+	for _, tc := range testCases {
+		ids, err := tc.q.IDs()
+		require.NoError(t, err, "error in %s", tc.q)
+		assert.Equal(t, tc.expected, ids, "wrong ids for %s", tc.q)
+		count, err := tc.q.Count()
+		require.NoError(t, err)
+		assert.Equal(t, len(tc.expected), count, "wrong count for %s", tc.q)
+	}
+
+	// Without an order, the models should still be excluded
+	ids, err := indexedTestModels.NewQuery().Not(func(q *Query) {
+		q.Filter("Bool =", true)
+	}).IDs()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, idsOf(models[1], models[3], models[5], models[7], models[9]), ids)
+
+	// The sub-queries should be included in the string and JSON representations
+	q := indexedTestModels.NewQuery().Not(func(q *Query) {
+		q.Filter("Int <", 5).Not(func(q *Query) {
+			q.Filter("Int =", 2)
+		})
+	})
+	assert.Equal(t, `indexedTestModel.NewQuery().Not(func(q *Query) { q.Filter("Int <", 5).Not(func(q *Query) { q.Filter("Int =", 2) }) })`, q.String())
+	data, err := json.Marshal(q)
+	require.NoError(t, err)
+	unmarshaled, err := UnmarshalQuery(indexedTestModels, data)
+	require.NoError(t, err)
+	assert.Equal(t, q.String(), unmarshaled.String())
+
+	for _, invalid := range []*Query{
+		indexedTestModels.NewQuery().Not(nil),
+		indexedTestModels.NewQuery().Not(func(q *Query) {}),
+		indexedTestModels.NewQuery().Not(func(q *Query) {
+			q.Filter("Int <", 5).Order("Int")
+		}),
+		indexedTestModels.NewQuery().Not(func(q *Query) {
+			q.Filter("Int <", 5).Limit(1)
+		}),
+		indexedTestModels.NewQuery().Not(func(q *Query) {
+			q.Filter("Invalid =", 5)
+		}),
+	} {
+		_, err := invalid.IDs()
+		assert.Error(t, err, "expected an error for %s", invalid)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_parse.go contains code for building queries from URL query
+// parameters.
+
+package zoom
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ParseQueryOptions controls which queries can be built by
+// ParseQueryWithOptions.
+type ParseQueryOptions struct {
+	// Fields are the names of the fields which may be used to filter and order
+	// the models. If empty, all indexed fields are allowed.
+	Fields []string
+	// MaxLimit is the maximum number of models the query may return. If it is
+	// greater than 0, it is also used as the limit when the parameters do not
+	// include one. If it is 0, any limit is allowed.
+	MaxLimit uint
+}
+
+// DefaultParseQueryOptions is the default set of options for ParseQuery, which
+// allows all indexed fields and any limit.
+var DefaultParseQueryOptions = ParseQueryOptions{}
+
+// WithFields returns a new copy of the options with the Fields property set to
+// the given value. It does not mutate the original options.
+func (options ParseQueryOptions) WithFields(fields ...string) ParseQueryOptions {
+	options.Fields = fields
+	return options
+}
+
+// WithMaxLimit returns a new copy of the options with the MaxLimit property set
+// to the given value. It does not mutate the original options.
+func (options ParseQueryOptions) WithMaxLimit(maxLimit uint) ParseQueryOptions {
+	options.MaxLimit = maxLimit
+	return options
+}
+
+// parseQueryOperators are the filter operators supported by ParseQuery. Two
+// character operators must come first so that e.g. ">=" is not parsed as ">".
+var parseQueryOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// ParseQuery is like ParseQueryWithOptions but uses DefaultParseQueryOptions.
+func (c *Collection) ParseQuery(values url.Values) (*Query, error) {
+	return c.ParseQueryWithOptions(values, DefaultParseQueryOptions)
+}
+
+// ParseQueryWithOptions builds a query from URL query parameters, e.g. the
+// result of (*http.Request).URL.Query(), so that HTTP APIs can let clients
+// filter and order models. The following parameters are supported:
+//
+//	filter   a filter consisting of a field name, an operator (=, !=, <, >,
+//	         <=, or >=), and a value, e.g. Age>=18. It can be repeated, and
+//	         the value is parsed according to the type of the field. For
+//	         pointer fields, the value null matches nil.
+//	order    the name of a field to order by, optionally prefixed with - for
+//	         descending order, e.g. -CreatedAt
+//	limit    the maximum number of models to return
+//	offset   the number of models to skip
+//	fields   a comma-separated list of the fields to include in the models
+//
+// For example, the parameters filter=Age>=18&order=-CreatedAt&limit=20 are
+// equivalent to:
+//
+//	c.NewQuery().Filter("Age >=", 18).Order("-CreatedAt").Limit(20)
+//
+// Other parameters are ignored. Only the fields allowed by options can be used
+// in filter and order, and the limit cannot exceed options.MaxLimit. If the
+// parameters are invalid, ParseQueryWithOptions returns an error which wraps
+// ErrInvalidQuery, which usually means the client should receive a 400 Bad
+// Request response.
+func (c *Collection) ParseQueryWithOptions(values url.Values, options ParseQueryOptions) (*Query, error) {
+	q := c.NewQuery()
+	for _, filter := range values["filter"] {
+		fieldName, op, rawValue, err := splitQueryFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		fs, err := c.parseQueryField(fieldName, options)
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseQueryValue(fs, rawValue)
+		if err != nil {
+			return nil, err
+		}
+		q.Filter(fieldName+" "+op, value)
+	}
+	if orders := values["order"]; len(orders) > 1 {
+		return nil, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: only one order may be specified")
+	} else if len(orders) == 1 {
+		if _, err := c.parseQueryField(strings.TrimPrefix(orders[0], "-"), options); err != nil {
+			return nil, err
+		}
+		q.Order(orders[0])
+	}
+	limit, err := parseQueryUint(values, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if options.MaxLimit > 0 {
+		if limit > options.MaxLimit {
+			return nil, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: limit %d exceeds the maximum of %d", limit, options.MaxLimit)
+		} else if limit == 0 {
+			limit = options.MaxLimit
+		}
+	}
+	q.Limit(limit)
+	offset, err := parseQueryUint(values, "offset")
+	if err != nil {
+		return nil, err
+	}
+	q.Offset(offset)
+	if fields := values.Get("fields"); fields != "" {
+		fieldNames := strings.Split(fields, ",")
+		for _, fieldName := range fieldNames {
+			if _, found := c.spec.fieldsByName[fieldName]; !found {
+				return nil, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: unknown field %s", fieldName)
+			}
+		}
+		q.Include(fieldNames...)
+	}
+	if q.query.hasError() {
+		return nil, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: %s", q.query.err.Error())
+	}
+	return q, nil
+}
+
+// splitQueryFilter splits the value of a filter parameter into the field name,
+// the operator and the value.
+func splitQueryFilter(filter string) (fieldName string, op string, value string, err error) {
+	i := strings.IndexAny(filter, "=!<>")
+	if i > 0 {
+		for _, op := range parseQueryOperators {
+			if strings.HasPrefix(filter[i:], op) {
+				return filter[:i], op, filter[i+len(op):], nil
+			}
+		}
+	}
+	return "", "", "", newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: invalid filter %q (should be a field name, an operator, and a value, e.g. Age>=18)", filter)
+}
+
+// parseQueryField returns the fieldSpec for the field with the given name, or
+// an error if the field does not exist, is not indexed, or is not allowed by
+// options.
+func (c *Collection) parseQueryField(fieldName string, options ParseQueryOptions) (*fieldSpec, error) {
+	fs, found := c.spec.fieldsByName[fieldName]
+	if !found || fs.indexKind == noIndex {
+		return nil, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: unknown or unindexed field %s", fieldName)
+	}
+	if len(options.Fields) > 0 && !stringSliceContains(options.Fields, fieldName) {
+		return nil, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: field %s is not allowed", fieldName)
+	}
+	return fs, nil
+}
+
+// parseQueryValue converts the value of a filter parameter to the type of the
+// field described by fs.
+func parseQueryValue(fs *fieldSpec, rawValue string) (interface{}, error) {
+	typ := fs.typ
+	if fs.kind == pointerField {
+		if rawValue == "null" {
+			return nil, nil
+		}
+		typ = typ.Elem()
+	}
+	value := reflect.New(typ).Elem()
+	if err := scanPrimitiveVal([]byte(rawValue), value); err != nil {
+		return nil, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: invalid value %q for field %s of type %s", rawValue, fs.name, typ.String())
+	}
+	return value.Interface(), nil
+}
+
+// parseQueryUint returns the value of the parameter with the given name as a
+// uint, or 0 if it is not present.
+func parseQueryUint(values url.Values, name string) (uint, error) {
+	rawValue := values.Get(name)
+	if rawValue == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(rawValue, 10, 0)
+	if err != nil {
+		return 0, newKindError(ErrInvalidQuery, "zoom: error in ParseQuery: invalid %s %q (should be a non-negative integer)", name, rawValue)
+	}
+	return uint(value), nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File query_parse_test.go tests the code in query_parse.go
+
+package zoom
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	testCases := []struct {
+		params   string
+		options  ParseQueryOptions
+		expected *Query
+	}{
+		{
+			params:   "",
+			expected: indexedTestModels.NewQuery(),
+		},
+		{
+			params:   "filter=Int>=18&order=-Int&limit=20",
+			expected: indexedTestModels.NewQuery().Filter("Int >=", 18).Order("-Int").Limit(20),
+		},
+		{
+			params:   "filter=Int<5&filter=Int!=2&filter=Bool=true&offset=3&ignored=foo",
+			expected: indexedTestModels.NewQuery().Filter("Int <", 5).Filter("Int !=", 2).Filter("Bool =", true).Offset(3),
+		},
+		{
+			params:   "filter=String=a=b&order=String&fields=Int,String",
+			expected: indexedTestModels.NewQuery().Filter("String =", "a=b").Order("String").Include("Int", "String"),
+		},
+		{
+			params:   "filter=Int>1",
+			options:  DefaultParseQueryOptions.WithFields("Int").WithMaxLimit(10),
+			expected: indexedTestModels.NewQuery().Filter("Int >", 1).Limit(10),
+		},
+	}
+	for _, tc := range testCases {
+		values, err := url.ParseQuery(tc.params)
+		require.NoError(t, err)
+		q, err := indexedTestModels.ParseQueryWithOptions(values, tc.options)
+		require.NoError(t, err, "params: %s", tc.params)
+		assert.Equal(t, tc.expected.query.String(), q.query.String(), "params: %s", tc.params)
+	}
+
+	// Pointer fields should accept null
+	q, err := indexedPointersModels.ParseQuery(url.Values{"filter": {"Int!=null"}})
+	require.NoError(t, err)
+	assert.Equal(t, indexedPointersModels.NewQuery().Filter("Int !=", nil).query.String(), q.query.String())
+
+	// The parsed query should run
+	models, err := createAndSaveIndexedTestModels(10)
+	require.NoError(t, err)
+	q, err = indexedTestModels.ParseQuery(url.Values{"filter": {"Int>=0"}, "order": {"Int"}})
+	require.NoError(t, err)
+	count, err := q.Count()
+	require.NoError(t, err)
+	expectedCount := 0
+	for _, model := range models {
+		if model.Int >= 0 {
+			expectedCount++
+		}
+	}
+	assert.Equal(t, expectedCount, count)
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	options := DefaultParseQueryOptions.WithFields("Int", "Bool").WithMaxLimit(50)
+	for _, params := range []string{
+		"filter=Int",
+		"filter==5",
+		"filter=Int=>5",
+		"filter=Int>=abc",
+		"filter=Bool=maybe",
+		"filter=Missing=1",
+		"filter=String=foo",
+		"order=String",
+		"order=Int&order=Bool",
+		"limit=51",
+		"limit=-1",
+		"offset=abc",
+		"fields=Int,Missing",
+	} {
+		values, err := url.ParseQuery(params)
+		require.NoError(t, err)
+		_, err = indexedTestModels.ParseQueryWithOptions(values, options)
+		if assert.Error(t, err, "params: %s", params) {
+			assert.True(t, errors.Is(err, ErrInvalidQuery), "params: %s, err: %v", params, err)
+		}
+	}
+
+	// Unindexed fields are never allowed
+	_, err := testModels.ParseQuery(url.Values{"filter": {"Int=1"}})
+	assert.True(t, errors.Is(err, ErrInvalidQuery))
+}
@@ -7,11 +7,16 @@
 package zoom
 
 import (
+	"errors"
+	"math"
 	"math/rand"
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -215,6 +220,883 @@ func TestQueryCombos(t *testing.T) {
 	}
 }
 
+func TestQueryLast(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lasts := []uint{1, 3, 10, 11}
+	for _, fieldName := range []string{"Int", "String", "Bool"} {
+		for _, orderPrefix := range []string{"", "-"} {
+			for _, last := range lasts {
+				q := indexedTestModels.NewQuery().Order(orderPrefix + fieldName).Last(last)
+				testQuery(t, q, models)
+				q = indexedTestModels.NewQuery().Order(orderPrefix+fieldName).Filter("Int >", models[0].Int).Last(last).Limit(2).Offset(1)
+				testQuery(t, q, models)
+			}
+		}
+	}
+	// Without an order, Last should still limit the number of models returned.
+	count, err := indexedTestModels.NewQuery().Last(3).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected count to be 3 but got %d", count)
+	}
+	ids, err := indexedTestModels.NewQuery().Last(3).IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 {
+		t.Errorf("Expected 3 ids but got %d", len(ids))
+	}
+	checkForLeakedTmpKeys(t, indexedTestModels.NewQuery().Last(3).query)
+}
+
+func TestQueryIntegerIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// These values cannot all be represented exactly as float64 scores
+	int64s := []int64{math.MinInt64, -1 << 53, -1, 0, 1 << 53, 1<<53 + 1, math.MaxInt64 - 1, math.MaxInt64}
+	uint64s := []uint64{0, 1 << 53, 1<<53 + 1, 1 << 63, 1<<63 + 1, math.MaxUint64 - 1, math.MaxUint64, 1}
+	models := []*indexedPrimativesModel{}
+	tx := testPool.NewTransaction()
+	for i := range int64s {
+		model := &indexedPrimativesModel{Int64: int64s[i], Uint64: uint64s[i]}
+		models = append(models, model)
+		tx.Save(indexedPrimativesModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range models {
+		expectIndexExists(t, indexedPrimativesModels, model, "Int64")
+		expectIndexExists(t, indexedPrimativesModels, model, "Uint64")
+	}
+
+	expectIDs := func(q *Query, expected ...*indexedPrimativesModel) {
+		got, err := q.IDs()
+		if err != nil {
+			t.Errorf("Unexpected error in IDs for query %s: %s", q, err.Error())
+			return
+		}
+		expectedIDs := []string{}
+		for _, model := range expected {
+			expectedIDs = append(expectedIDs, model.ID)
+		}
+		if !reflect.DeepEqual(expectedIDs, got) {
+			t.Errorf("Wrong ids for query %s.\nExpected: %v\nGot:  %v", q, expectedIDs, got)
+		}
+	}
+	expectIDs(indexedPrimativesModels.NewQuery().Order("Int64"), models...)
+	expectIDs(indexedPrimativesModels.NewQuery().Order("-Int64").Limit(2), models[7], models[6])
+	expectIDs(indexedPrimativesModels.NewQuery().Order("Uint64"), models[0], models[7], models[1], models[2], models[3], models[4], models[5], models[6])
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(1<<53+1)), models[5])
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(math.MaxInt64-1)), models[6])
+	expectIDs(indexedPrimativesModels.NewQuery().Order("Int64").Filter("Int64 <", int64(0)), models[0], models[1], models[2])
+	expectIDs(indexedPrimativesModels.NewQuery().Order("Int64").Filter("Int64 >=", int64(1<<53+1)), models[5], models[6], models[7])
+	expectIDs(indexedPrimativesModels.NewQuery().Order("Int64").Filter("Int64 !=", int64(1<<53)), models[0], models[1], models[2], models[3], models[5], models[6], models[7])
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Uint64 >", uint64(math.MaxUint64-1)), models[6])
+	expectIDs(indexedPrimativesModels.NewQuery().Order("Int64").Last(2), models[6], models[7])
+
+	// IDsWithScores should read the values from the model hashes
+	scores, err := indexedPrimativesModels.NewQuery().Order("-Int64").Limit(2).IDsWithScores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedScores := []IDScore{{ID: models[7].ID, Score: float64(int64s[7])}, {ID: models[6].ID, Score: float64(int64s[6])}}
+	if !reflect.DeepEqual(expectedScores, scores) {
+		t.Errorf("Wrong scores.\nExpected: %v\nGot:  %v", expectedScores, scores)
+	}
+
+	// ExtractIDsByNumericRange should translate the range for integer indexes
+	tx = testPool.NewTransaction()
+	tx.ExtractIDsByNumericRange(indexedPrimativesModels, "Int64", "extracted", "(-1", 1<<53)
+	ids := []string{}
+	tx.Command("ZRANGE", redis.Args{"extracted", 0, -1}, NewScanStringsHandler(&ids))
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{models[3].ID, models[4].ID}; !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Wrong ids for ExtractIDsByNumericRange.\nExpected: %v\nGot:  %v", expected, ids)
+	}
+
+	// The old index values should be removed by Save, Update, and Delete
+	models[0].Int64 = math.MinInt64 + 1
+	if err := indexedPrimativesModels.Save(models[0]); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(math.MinInt64)))
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(math.MinInt64+1)), models[0])
+	if _, err := indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(-1)).Update(map[string]interface{}{"Int64": int64(-2)}); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 <=", int64(-1)).Filter("Int64 >", int64(-1<<53)), models[2])
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(-1)))
+	if _, err := indexedPrimativesModels.NewQuery().Filter("Int64 <", int64(0)).Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexedPrimativesModels.Delete(models[7].ID); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(indexedPrimativesModels.NewQuery().Order("Int64"), models[3], models[4], models[5], models[6])
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if count, err := redis.Int(conn.Do("ZCARD", "indexedPrimativesModel:Int64")); err != nil {
+		t.Fatal(err)
+	} else if count != 4 {
+		t.Errorf("Expected 4 members in the integer index but got %d", count)
+	}
+
+	// Syncing the indexes should use the same encoding
+	if _, err := conn.Do("HSET", indexedPrimativesModels.ModelKey(models[3].ID), "Int64", "-7"); err != nil {
+		t.Fatal(err)
+	}
+	tx = testPool.NewTransaction()
+	tx.syncModelIndexes(indexedPrimativesModels, models[3].ID)
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(-7)), models[3])
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 =", int64(0)))
+}
+
+func TestQueryNullFilter(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	five, a, b := 5, "a", "b"
+	models := []*indexedPointersModel{
+		{Int: &five, String: &a},
+		{String: &b},
+		{},
+	}
+	tx := testPool.NewTransaction()
+	for _, model := range models {
+		tx.Save(indexedPointersModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectIDs := func(q *Query, expected ...*indexedPointersModel) {
+		got, err := q.IDs()
+		if err != nil {
+			t.Errorf("Unexpected error in IDs for query %s: %s", q, err.Error())
+			return
+		}
+		expectedIDs := []string{}
+		for _, model := range expected {
+			expectedIDs = append(expectedIDs, model.ID)
+		}
+		sort.Strings(expectedIDs)
+		sort.Strings(got)
+		if !reflect.DeepEqual(expectedIDs, got) {
+			t.Errorf("Wrong ids for query %s.\nExpected: %v\nGot:  %v", q, expectedIDs, got)
+		}
+	}
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int IS", nil), models[1], models[2])
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int =", nil), models[1], models[2])
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int IS NOT", nil), models[0])
+	expectIDs(indexedPointersModels.NewQuery().Order("Int").Filter("Int IS NOT", nil), models[0])
+	expectIDs(indexedPointersModels.NewQuery().Filter("String !=", nil), models[0], models[1])
+	expectIDs(indexedPointersModels.NewQuery().Filter("String IS", nil).Filter("Int IS", nil), models[2])
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int64 IS", nil), models...)
+	expectIDs(indexedPointersModels.NewQuery().Filter("Bool IS NOT", nil))
+	if q, expected := indexedPointersModels.NewQuery().Filter("Int IS NOT", nil), `indexedPointersModel.NewQuery().Filter("Int IS NOT", nil)`; q.String() != expected {
+		t.Errorf("Expected query string %s but got %s", expected, q.String())
+	}
+
+	// Setting a field to nil should move the model to the null index
+	models[0].Int = nil
+	if err := indexedPointersModels.Save(models[0]); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int IS", nil), models...)
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int >", 0))
+	if _, err := indexedPointersModels.NewQuery().Filter("String =", "b").Update(map[string]interface{}{"Int": 7, "String": nil}); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int IS NOT", nil), models[1])
+	expectIDs(indexedPointersModels.NewQuery().Filter("String IS", nil), models[1], models[2])
+
+	// Syncing the indexes should update the null index
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("HSET", indexedPointersModels.ModelKey(models[1].ID), "Int", "NULL"); err != nil {
+		t.Fatal(err)
+	}
+	tx = testPool.NewTransaction()
+	tx.syncModelIndexes(indexedPointersModels, models[1].ID)
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int IS", nil), models...)
+
+	// Deleting models should remove them from the null index
+	nullKey := indexedPointersModels.spec.nullIndexKey(indexedPointersModels.spec.fieldsByName["Int"])
+	if _, err := indexedPointersModels.Delete(models[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexedPointersModels.NewQuery().Filter("String IS NOT", nil).Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if members, err := redis.Strings(conn.Do("SMEMBERS", nullKey)); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual([]string{models[1].ID}, members) {
+		t.Errorf("Expected null index to contain only %s but got %v", models[1].ID, members)
+	}
+	if _, err := indexedPointersModels.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := redis.Int(conn.Do("SCARD", nullKey)); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Errorf("Expected null index to be empty but it had %d members", count)
+	}
+
+	// IS and IS NOT should only be allowed on pointer fields with a nil value
+	if _, err := indexedPointersModels.NewQuery().Filter("Int IS", 5).IDs(); err == nil {
+		t.Error("Expected an error for IS with a non-nil value but got none")
+	}
+	if _, err := indexedTestModels.NewQuery().Filter("Int IS NOT", nil).IDs(); err == nil {
+		t.Error("Expected an error for IS NOT on a non-pointer field but got none")
+	}
+}
+
+func TestQueryInFilter(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*indexedTestModel{
+		{Int: 1, String: "active"},
+		{Int: 2, String: "trial"},
+		{Int: 3, String: "cancelled"},
+		{Int: 4, String: "active"},
+	}
+	tx := testPool.NewTransaction()
+	for _, model := range models {
+		tx.Save(indexedTestModels, model)
+	}
+	primatives := []*indexedPrimativesModel{{Int64: -1 << 62}, {Int64: 7}, {Int64: 1 << 62}}
+	for _, model := range primatives {
+		tx.Save(indexedPrimativesModels, model)
+	}
+	five, seven := 5, 7
+	pointers := []*indexedPointersModel{{Int: &five}, {Int: &seven}, {}}
+	for _, model := range pointers {
+		tx.Save(indexedPointersModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectIDs := func(q *Query, expected ...string) {
+		got, err := q.IDs()
+		if err != nil {
+			t.Errorf("Unexpected error in IDs for query %s: %s", q, err.Error())
+			return
+		}
+		sort.Strings(expected)
+		sort.Strings(got)
+		if len(expected) == 0 && len(got) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Wrong ids for query %s.\nExpected: %v\nGot:  %v", q, expected, got)
+		}
+	}
+	expectIDs(indexedTestModels.NewQuery().Filter("String in", []string{"active", "trial"}), models[0].ID, models[1].ID, models[3].ID)
+	expectIDs(indexedTestModels.NewQuery().Filter("String IN", []string{"trial", "trial"}), models[1].ID)
+	expectIDs(indexedTestModels.NewQuery().Filter("Int IN", []int{1, 3, 100}), models[0].ID, models[2].ID)
+	expectIDs(indexedTestModels.NewQuery().Filter("Int IN", [2]int{2, 4}).Filter("String =", "active"), models[3].ID)
+	expectIDs(indexedTestModels.NewQuery().Filter("Int IN", []int{}))
+	expectIDs(indexedPrimativesModels.NewQuery().Filter("Int64 IN", []int64{-1 << 62, 1 << 62}), primatives[0].ID, primatives[2].ID)
+	expectIDs(indexedPointersModels.NewQuery().Filter("Int IN", []int{5, 7}), pointers[0].ID, pointers[1].ID)
+
+	// The order of the query should be preserved
+	got := []*indexedTestModel{}
+	if err := indexedTestModels.NewQuery().Order("-Int").Filter("String IN", []string{"active", "trial"}).Run(&got); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []*indexedTestModel{models[3], models[1], models[0]}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("Wrong models.\nExpected: %v\nGot:  %v", expected, got)
+	}
+
+	if q, expected := indexedTestModels.NewQuery().Filter("Int IN", []int{1, 2}), `indexedTestModel.NewQuery().Filter("Int IN", []int{1, 2})`; q.String() != expected {
+		t.Errorf("Expected query string %s but got %s", expected, q.String())
+	}
+
+	// Values which are not a slice, or which have the wrong type, should
+	// cause an error
+	for _, value := range []interface{}{1, nil, []string{"a"}, []interface{}{1, "a"}} {
+		if _, err := indexedTestModels.NewQuery().Filter("Int IN", value).IDs(); err == nil {
+			t.Errorf("Expected an error for IN filter with value %#v but got none", value)
+		}
+	}
+}
+
+func TestQueryFilterRange(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := make([]*indexedPrimativesModel, 10)
+	tx := testPool.NewTransaction()
+	for i := range models {
+		models[i] = &indexedPrimativesModel{
+			Int:     i,
+			Int64:   int64(i-5) << 56,
+			Float64: float64(i) / 2,
+			String:  string(rune('a' + i)),
+			Bool:    i%2 == 0,
+		}
+		tx.Save(indexedPrimativesModels, models[i])
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each range filter should return the same models as the equivalent pair
+	// of filters, in the same order
+	testCases := []struct {
+		rangeQuery *Query
+		pairQuery  *Query
+	}{
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().FilterRange("Int", 2, 6, true),
+			pairQuery:  indexedPrimativesModels.NewQuery().Filter("Int >=", 2).Filter("Int <=", 6),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().Order("-Int").FilterRange("Int", 2, 6, false),
+			pairQuery:  indexedPrimativesModels.NewQuery().Order("-Int").Filter("Int >", 2).Filter("Int <", 6),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().FilterRange("Float64", 0.5, 2.5, false),
+			pairQuery:  indexedPrimativesModels.NewQuery().Filter("Float64 >", 0.5).Filter("Float64 <", 2.5),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().Order("String").FilterRange("String", "b", "e", true),
+			pairQuery:  indexedPrimativesModels.NewQuery().Order("String").Filter("String >=", "b").Filter("String <=", "e"),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().FilterRange("String", "b", "e", false),
+			pairQuery:  indexedPrimativesModels.NewQuery().Filter("String >", "b").Filter("String <", "e"),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().Order("Int64").FilterRange("Int64", int64(-3)<<56, int64(2)<<56, true),
+			pairQuery:  indexedPrimativesModels.NewQuery().Order("Int64").Filter("Int64 >=", int64(-3)<<56).Filter("Int64 <=", int64(2)<<56),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().FilterRange("Int64", int64(-3)<<56, int64(2)<<56, false),
+			pairQuery:  indexedPrimativesModels.NewQuery().Filter("Int64 >", int64(-3)<<56).Filter("Int64 <", int64(2)<<56),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().FilterRange("Bool", false, true, true),
+			pairQuery:  indexedPrimativesModels.NewQuery().Filter("Bool >=", false).Filter("Bool <=", true),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().FilterRange("Bool", false, true, false),
+			pairQuery:  indexedPrimativesModels.NewQuery().Filter("Bool >", false).Filter("Bool <", true),
+		},
+		{
+			rangeQuery: indexedPrimativesModels.NewQuery().FilterRange("Int", 6, 2, true),
+			pairQuery:  indexedPrimativesModels.NewQuery().Filter("Int >=", 6).Filter("Int <=", 2),
+		},
+	}
+	for _, tc := range testCases {
+		expected, err := tc.pairQuery.IDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tc.rangeQuery.IDs()
+		if err != nil {
+			t.Errorf("Unexpected error in IDs for query %s: %s", tc.rangeQuery, err.Error())
+			continue
+		}
+		if !tc.rangeQuery.hasOrder() {
+			sort.Strings(expected)
+			sort.Strings(got)
+		}
+		if len(expected) == 0 && len(got) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Wrong ids for query %s.\nExpected: %v\nGot:  %v", tc.rangeQuery, expected, got)
+		}
+	}
+	if count, err := indexedPrimativesModels.NewQuery().FilterRange("Int", 2, 6, true).Count(); err != nil {
+		t.Fatal(err)
+	} else if count != 5 {
+		t.Errorf("Expected count to be 5 but got %d", count)
+	}
+
+	if q, expected := indexedPrimativesModels.NewQuery().FilterRange("Int", 2, 6, false), `indexedPrimativesModel.NewQuery().FilterRange("Int", 2, 6, false)`; q.String() != expected {
+		t.Errorf("Expected query string %s but got %s", expected, q.String())
+	}
+
+	// Invalid fields or values should cause an error
+	for _, q := range []*Query{
+		indexedPrimativesModels.NewQuery().FilterRange("Int", 2, "6", true),
+		indexedPrimativesModels.NewQuery().FilterRange("Bogus", 2, 6, true),
+		testModels.NewQuery().FilterRange("Int", 2, 6, true),
+	} {
+		if _, err := q.IDs(); err == nil {
+			t.Errorf("Expected an error for query %s but got none", q)
+		}
+	}
+}
+
+func TestQueryIDsWithScores(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelsByID := map[string]*indexedTestModel{}
+	for _, model := range models {
+		modelsByID[model.ID] = model
+	}
+	for _, fieldName := range []string{"Int", "Bool"} {
+		for _, orderPrefix := range []string{"", "-"} {
+			queries := []*Query{
+				indexedTestModels.NewQuery().Order(orderPrefix + fieldName),
+				indexedTestModels.NewQuery().Order(orderPrefix+fieldName).Filter("Int >", models[0].Int).Limit(3).Offset(1),
+				indexedTestModels.NewQuery().Order(orderPrefix + fieldName).Last(4),
+				indexedTestModels.NewQuery().Order(orderPrefix + fieldName).Last(4).Limit(2).Offset(1),
+			}
+			for _, q := range queries {
+				// The ids should be in the same order as the ids returned by IDs
+				expectedIDs, err := q.IDs()
+				if err != nil {
+					t.Fatal(err)
+				}
+				results, err := q.IDsWithScores()
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotIDs := []string{}
+				for _, result := range results {
+					gotIDs = append(gotIDs, result.ID)
+					model := modelsByID[result.ID]
+					expectedScore := float64(model.Int)
+					if fieldName == "Bool" {
+						expectedScore = float64(convertBoolToInt(model.Bool))
+					}
+					if result.Score != expectedScore {
+						t.Errorf("Expected score for %s to be %v but got %v", result.ID, expectedScore, result.Score)
+					}
+				}
+				if !reflect.DeepEqual(expectedIDs, gotIDs) {
+					t.Errorf("Wrong ids for query: %s\nExpected: %v\nGot:      %v", q, expectedIDs, gotIDs)
+				}
+				checkForLeakedTmpKeys(t, q.query)
+			}
+		}
+	}
+
+	// Queries without a numeric or boolean order should return an error
+	if _, err := indexedTestModels.NewQuery().IDsWithScores(); err == nil {
+		t.Error("Expected error for query without an order")
+	}
+	if _, err := indexedTestModels.NewQuery().Order("String").IDsWithScores(); err == nil {
+		t.Error("Expected error for query ordered by a string field")
+	}
+}
+
+func TestQueryFromIDSet(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	// Store the ids of the first 6 models in a set, a sorted set, and a list
+	members := models[:6]
+	for _, model := range members {
+		if _, err := conn.Do("SADD", "idSet", model.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Do("ZADD", "idSortedSet", 0, model.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Do("RPUSH", "idList", model.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"idSet", "idSortedSet", "idList"} {
+		testQuery(t, indexedTestModels.NewQuery().FromIDSet(key), members)
+		testQuery(t, indexedTestModels.NewQuery().FromIDSet(key).Order("-Int"), members)
+		testQuery(t, indexedTestModels.NewQuery().FromIDSet(key).Order("String").Filter("Bool =", true).Limit(2), members)
+		testQuery(t, indexedTestModels.NewQuery().FromIDSet(key).Order("Int").Last(3), members)
+	}
+
+	// Multiple keys should be intersected
+	if _, err := conn.Do("SADD", "otherIDSet", models[0].ID, models[1].ID, models[9].ID); err != nil {
+		t.Fatal(err)
+	}
+	testQuery(t, indexedTestModels.NewQuery().FromIDSet("idList").FromIDSet("otherIDSet").Order("Int"), models[:2])
+
+	// If the key does not exist, there should be no results
+	testQuery(t, indexedTestModels.NewQuery().FromIDSet("doesNotExist"), []*indexedTestModel{})
+}
+
+func TestQueryFilterID(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := createIndexedTestModels(6)
+	ids := []string{"a1", "a2", "a3", "b1", "b2", "c"}
+	tx := testPool.NewTransaction()
+	for i, model := range models {
+		model.SetModelID(ids[i])
+		tx.Save(indexedTestModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	testQuery(t, indexedTestModels.NewQuery().FilterID(">=", "a3"), models[2:])
+	testQuery(t, indexedTestModels.NewQuery().FilterID(">", "a3"), models[3:])
+	testQuery(t, indexedTestModels.NewQuery().FilterID("<", "b"), models[:3])
+	testQuery(t, indexedTestModels.NewQuery().FilterID("<=", "b1"), models[:4])
+	testQuery(t, indexedTestModels.NewQuery().FilterID("=", "b1"), models[3:4])
+	testQuery(t, indexedTestModels.NewQuery().FilterID(">", "a1").FilterID("<", "c"), models[1:5])
+	testQuery(t, indexedTestModels.NewQuery().FilterIDPrefix("a"), models[:3])
+	testQuery(t, indexedTestModels.NewQuery().FilterIDPrefix("b").Order("-Int"), models[3:5])
+	testQuery(t, indexedTestModels.NewQuery().FilterIDPrefix("d"), []*indexedTestModel{})
+	testQuery(t, indexedTestModels.NewQuery().FilterID(">=", "a2").Filter("Bool =", true).Order("String"), models[1:])
+	testQuery(t, indexedTestModels.NewQuery().FilterID(">=", "a2").Order("Int").Last(2), models[1:])
+	testQuery(t, indexedTestModels.NewQuery().FilterID(">=", "a2").Limit(2).Offset(1), models[1:])
+
+	// Without an order, the models should be returned in the order of their ids
+	got := []*indexedTestModel{}
+	if err := indexedTestModels.FindIDRange("a2", "b2", &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectModelsToBeEqual(models[1:5], got, true); err != nil {
+		t.Error(err)
+	}
+	if err := indexedTestModels.FindIDRange("", "", &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectModelsToBeEqual(models, got, true); err != nil {
+		t.Error(err)
+	}
+	gotIDs, err := indexedTestModels.NewQuery().FilterID(">", "a").Last(2).IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"b2", "c"}; !reflect.DeepEqual(expected, gotIDs) {
+		t.Errorf("Expected %v but got %v", expected, gotIDs)
+	}
+
+	if _, err := indexedTestModels.NewQuery().FilterID("!=", "a1").IDs(); err == nil {
+		t.Error("Expected error for invalid FilterID operator")
+	}
+}
+
+func TestIDForTime(t *testing.T) {
+	start := time.Now()
+	id := generateRandomID()
+	if min := IDForTime(start.Add(-time.Second)); id < min {
+		t.Errorf("Expected id %s generated at %s to be greater than or equal to %s", id, start, min)
+	}
+	if max := IDForTime(start.Add(2 * time.Second)); id >= max {
+		t.Errorf("Expected id %s generated at %s to be less than %s", id, start, max)
+	}
+}
+
+func TestQueryJoin(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	authors := []*joinAuthor{
+		{Name: "alice", Country: "US", Age: 30},
+		{Name: "bob", Country: "UK", Age: 40},
+		{Name: "carol", Country: "US", Age: 50},
+		{Name: "dave", Country: "FR", Age: 60},
+	}
+	tx := testPool.NewTransaction()
+	for _, author := range authors {
+		tx.Save(joinAuthors, author)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	// Each author except dave has two posts, and one post references an author
+	// which does not exist.
+	posts := []*joinPost{}
+	for i, author := range authors[:3] {
+		for j := 0; j < 2; j++ {
+			posts = append(posts, &joinPost{
+				Title:    author.Name + strconv.Itoa(j),
+				AuthorID: author.ID,
+				Likes:    i*2 + j,
+			})
+		}
+	}
+	posts = append(posts, &joinPost{Title: "orphan", AuthorID: "doesNotExist", Likes: 100})
+	tx = testPool.NewTransaction()
+	for _, post := range posts {
+		tx.Save(joinPosts, post)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		query    *Query
+		expected []string
+	}{
+		{
+			query:    joinPosts.NewQuery().Join("AuthorID", joinAuthors).Filter("Author.Country =", "US").Order("Likes"),
+			expected: []string{posts[0].ID, posts[1].ID, posts[4].ID, posts[5].ID},
+		},
+		{
+			query:    joinPosts.NewQuery().Join("AuthorID", joinAuthors).Filter("Author.Country =", "US").Filter("Author.Age >", 30).Order("-Likes"),
+			expected: []string{posts[5].ID, posts[4].ID},
+		},
+		{
+			query:    joinPosts.NewQuery().Join("AuthorID", joinAuthors).Filter("Author.Country !=", "US").Filter("Likes <", 3),
+			expected: []string{posts[2].ID},
+		},
+		{
+			query:    joinPosts.NewQuery().Join("AuthorID", joinAuthors).Filter("Author.Country =", "FR"),
+			expected: []string{},
+		},
+		{
+			// A join without any filters should only return models which
+			// reference an existing model.
+			query:    joinPosts.NewQuery().Join("AuthorID", joinAuthors).Order("Likes").Last(2),
+			expected: []string{posts[4].ID, posts[5].ID},
+		},
+	}
+	for _, tc := range testCases {
+		gotIDs, err := tc.query.IDs()
+		if err != nil {
+			t.Fatalf("Unexpected error in query %s: %s", tc.query, err)
+		}
+		if tc.query.hasOrder() {
+			if !reflect.DeepEqual(tc.expected, gotIDs) {
+				t.Errorf("Wrong ids for query %s.\nExpected: %v\nGot:      %v", tc.query, tc.expected, gotIDs)
+			}
+		} else {
+			sort.Strings(tc.expected)
+			sort.Strings(gotIDs)
+			if !reflect.DeepEqual(tc.expected, gotIDs) {
+				t.Errorf("Wrong ids for query %s.\nExpected: %v\nGot:      %v", tc.query, tc.expected, gotIDs)
+			}
+		}
+		count, err := tc.query.Count()
+		if err != nil {
+			t.Fatalf("Unexpected error in query %s: %s", tc.query, err)
+		}
+		if count != len(tc.expected) {
+			t.Errorf("Wrong count for query %s. Expected %d but got %d", tc.query, len(tc.expected), count)
+		}
+	}
+
+	// Invalid joins and filters should result in an error
+	errorQueries := []*Query{
+		joinPosts.NewQuery().Join("Likes", joinAuthors),
+		joinPosts.NewQuery().Join("Foo", joinAuthors),
+		joinPosts.NewQuery().Join("AuthorID", nil),
+		joinPosts.NewQuery().Join("AuthorID", joinAuthors).Join("AuthorID", joinAuthors),
+		joinPosts.NewQuery().Filter("Author.Country =", "US"),
+		joinPosts.NewQuery().Join("AuthorID", joinAuthors).Filter("Author.Foo =", "US"),
+		joinPosts.NewQuery().Join("AuthorID", joinAuthors).Filter("Author.Country =", 42),
+	}
+	for _, q := range errorQueries {
+		if _, err := q.IDs(); err == nil {
+			t.Errorf("Expected an error for query %s but got none", q)
+		}
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := indexedTestModels.NewQuery().Order("Int").Timeout(time.Second)
+	if tx := q.newTransaction(); tx.timeout != time.Second {
+		t.Errorf("Expected the transaction for the query to have a timeout of 1s but got %s", tx.timeout)
+	}
+	testQuery(t, q, models)
+
+	// The timeout should be enforced by the connection
+	q = indexedTestModels.NewQuery().Timeout(time.Nanosecond)
+	if _, err := q.IDs(); err == nil {
+		t.Errorf("Expected an error for query %s with a timeout of 1ns but got none", q)
+	}
+}
+
+func TestQueryParallel(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []*Query{
+		indexedTestModels.NewQuery().Parallel(4),
+		indexedTestModels.NewQuery().Order("-Int").Parallel(3),
+		indexedTestModels.NewQuery().Order("String").Filter("Int >", 0).Limit(7).Parallel(8),
+		indexedTestModels.NewQuery().Order("Int").Include("Int").Parallel(2),
+		indexedTestModels.NewQuery().Filter("Bool =", true).Exclude("String").Parallel(100),
+	}
+	for _, q := range queries {
+		testQuery(t, q, models)
+	}
+
+	// Existing models in the slice should be reused
+	got := make([]*indexedTestModel, 1, 1)
+	got[0] = &indexedTestModel{}
+	existing := got[0]
+	if err := indexedTestModels.NewQuery().Order("Int").Parallel(4).Run(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(models) {
+		t.Fatalf("Expected %d models but got %d", len(models), len(got))
+	}
+	if got[0] != existing {
+		t.Errorf("Expected the first model in the slice to be reused")
+	}
+}
+
+func TestQueryParallelDeletedModel(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*indexedTestModel{}
+	tx := testPool.NewTransaction()
+	for i := 0; i < 5; i++ {
+		model := &indexedTestModel{Int: i}
+		models = append(models, model)
+		tx.Save(indexedTestModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	// Delete a model after its id has been read but before its fields are. The
+	// ids are read on the first connection borrowed after the pool is armed,
+	// and the fields are read by two workers on the next two connections. The
+	// mutex makes sure that neither worker reads any fields before the model
+	// is deleted.
+	mut := sync.Mutex{}
+	armed := false
+	borrows := 0
+	pool := NewPoolWithOptions(testPool.options.WithTestOnBorrow(func(c redis.Conn, lastUsed time.Time) error {
+		mut.Lock()
+		defer mut.Unlock()
+		if !armed {
+			return nil
+		}
+		borrows++
+		if borrows == 2 {
+			if _, err := indexedTestModels.Delete(models[2].ID); err != nil {
+				t.Error(err)
+			}
+		}
+		return nil
+	}))
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make sure there are two idle connections.
+	conns := []redis.Conn{pool.NewConn(), pool.NewConn()}
+	for _, conn := range conns {
+		if _, err := conn.Do("PING"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	mut.Lock()
+	armed = true
+	mut.Unlock()
+	got := []*indexedTestModel{}
+	if err := col.NewQuery().Order("Int").Parallel(2).Run(&got); err != nil {
+		t.Fatal(err)
+	}
+	if borrows < 2 {
+		t.Fatalf("Expected the model to be deleted while the query was running (borrows: %d)", borrows)
+	}
+	expected := []*indexedTestModel{models[0], models[1], models[3], models[4]}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Deleted model was not dropped from the results.\nExpected: %v\n     Got: %v", expected, got)
+	}
+}
+
+func TestQueryParallelUnsetFields(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&ttlTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := []*ttlTestModel{{Name: "a", Summary: "short"}, {Name: "b", Summary: "long"}}
+	for _, model := range models {
+		if err := col.Save(model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A model whose included fields have all expired still exists, so it
+	// should not be dropped from the results.
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("HDEL", col.ModelKey(models[0].ID), "Summary"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*ttlTestModel{{RandomID: models[0].RandomID}, {Summary: "long", RandomID: models[1].RandomID}}
+	sort.Slice(expected, func(i, j int) bool { return expected[i].ID < expected[j].ID })
+	for i, q := range []*Query{
+		col.NewQuery().Include("Summary").Parallel(2),
+		col.NewQuery().Include("Summary"),
+	} {
+		got := []*ttlTestModel{}
+		if err := q.Run(&got); err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Wrong results for query %d.\nExpected: %v\n     Got: %v", i, expected, got)
+		}
+	}
+}
+
 func TestQueryRunOne(t *testing.T) {
 	testingSetUp()
 	defer testingTearDown()
@@ -274,6 +1156,462 @@ func TestQueryRunOne(t *testing.T) {
 	}
 }
 
+func TestQueryFirstAndLastOne(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*indexedTestModel{}
+	tx := testPool.NewTransaction()
+	for i := 0; i < 5; i++ {
+		model := &indexedTestModel{
+			Int:    i,
+			String: strconv.Itoa(i),
+		}
+		models = append(models, model)
+		tx.Save(indexedTestModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name          string
+		run           func(model Model) error
+		expectedModel *indexedTestModel
+	}{
+		{
+			name:          "First",
+			run:           indexedTestModels.NewQuery().Order("Int").First,
+			expectedModel: models[0],
+		},
+		{
+			name:          "First with Offset",
+			run:           indexedTestModels.NewQuery().Order("-Int").Offset(1).First,
+			expectedModel: models[3],
+		},
+		{
+			name:          "LastOne",
+			run:           indexedTestModels.NewQuery().Order("Int").LastOne,
+			expectedModel: models[4],
+		},
+		{
+			name:          "LastOne descending with Offset",
+			run:           indexedTestModels.NewQuery().Order("-Int").Offset(1).LastOne,
+			expectedModel: models[1],
+		},
+		{
+			name:          "LastOne with Filter",
+			run:           indexedTestModels.NewQuery().Order("String").Filter("Int <", 3).LastOne,
+			expectedModel: models[2],
+		},
+	}
+	for _, tc := range testCases {
+		gotModel := &indexedTestModel{}
+		if err := tc.run(gotModel); err != nil {
+			t.Errorf("Unexpected error in test case %s: %s", tc.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(gotModel, tc.expectedModel) {
+			t.Errorf("Error in test case %s: model was incorrect.\nExpected: %v\n     Got: %v", tc.name, tc.expectedModel, gotModel)
+		}
+	}
+
+	if err := indexedTestModels.NewQuery().Filter("Int >", 10).First(&indexedTestModel{}); err == nil {
+		t.Error("Expected an error from First when no models match but got none")
+	} else if _, ok := err.(ModelNotFoundError); !ok {
+		t.Errorf("Expected a ModelNotFoundError but got: %T: %s", err, err.Error())
+	}
+	if err := indexedTestModels.NewQuery().LastOne(&indexedTestModel{}); err == nil {
+		t.Error("Expected an error from LastOne without an Order but got none")
+	}
+	if err := indexedTestModels.NewQuery().Order("Int").Last(2).LastOne(&indexedTestModel{}); err == nil {
+		t.Error("Expected an error from LastOne with Last but got none")
+	}
+}
+
+func TestQueryRunExactlyOne(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*indexedTestModel{}
+	tx := testPool.NewTransaction()
+	for i := 0; i < 5; i++ {
+		model := &indexedTestModel{Int: i}
+		models = append(models, model)
+		tx.Save(indexedTestModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	gotModel := &indexedTestModel{}
+	if err := indexedTestModels.NewQuery().Filter("Int =", models[2].Int).RunExactlyOne(gotModel); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotModel, models[2]) {
+		t.Errorf("Model was incorrect.\nExpected: %v\n     Got: %v", models[2], gotModel)
+	}
+	err := indexedTestModels.NewQuery().Filter("Int =", -1).RunExactlyOne(&indexedTestModel{})
+	if _, ok := err.(ModelNotFoundError); !ok {
+		t.Errorf("Expected a ModelNotFoundError but got: %v", err)
+	}
+	err = indexedTestModels.NewQuery().RunExactlyOne(&indexedTestModel{})
+	if _, ok := err.(MultipleModelsFoundError); !ok {
+		t.Errorf("Expected a MultipleModelsFoundError but got: %v", err)
+	}
+	// The Offset should be applied before checking for more than one model
+	if err := indexedTestModels.NewQuery().Order("Int").Offset(4).RunExactlyOne(gotModel); err != nil {
+		t.Errorf("Unexpected error with Offset: %s", err.Error())
+	}
+}
+
+func TestQueryRunPage(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := []*indexedTestModel{}
+	tx := testPool.NewTransaction()
+	for i := 0; i < 7; i++ {
+		model := &indexedTestModel{Int: i, Bool: i < 5}
+		models = append(models, model)
+		tx.Save(indexedTestModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		page     int
+		expected []*indexedTestModel
+		info     PageInfo
+	}{
+		{1, models[0:2], PageInfo{Total: 5, Pages: 3, HasNext: true}},
+		{2, models[2:4], PageInfo{Total: 5, Pages: 3, HasNext: true}},
+		{3, models[4:5], PageInfo{Total: 5, Pages: 3, HasNext: false}},
+		{4, []*indexedTestModel{}, PageInfo{Total: 5, Pages: 3, HasNext: false}},
+	}
+	for _, tc := range testCases {
+		// The Limit and Offset of the query should be replaced by the page
+		query := indexedTestModels.NewQuery().Filter("Bool =", true).Order("Int").Limit(1).Offset(3)
+		got := []*indexedTestModel{}
+		info, err := query.RunPage(&got, tc.page, 2)
+		if err != nil {
+			t.Errorf("Unexpected error for page %d: %s", tc.page, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Models for page %d were incorrect.\nExpected: %v\n     Got: %v", tc.page, tc.expected, got)
+		}
+		if *info != tc.info {
+			t.Errorf("PageInfo for page %d was incorrect.\nExpected: %+v\n     Got: %+v", tc.page, tc.info, *info)
+		}
+	}
+	if _, err := indexedTestModels.NewQuery().RunPage(&[]*indexedTestModel{}, 0, 2); err == nil {
+		t.Error("Expected an error for page 0 but got none")
+	}
+}
+
+func TestDefaultScope(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	options := DefaultCollectionOptions.WithIndex(true).WithDefaultScope(func(q *Query) {
+		q.Filter("Bool =", false)
+	})
+	col, err := pool.NewCollectionWithOptions(&indexedTestModel{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := []*indexedTestModel{}
+	tx := pool.NewTransaction()
+	for i := 0; i < 4; i++ {
+		model := &indexedTestModel{Int: i, Bool: i%2 == 1}
+		models = append(models, model)
+		tx.Save(col, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The scope should be applied to every query, along with other modifiers
+	got := []*indexedTestModel{}
+	if err := col.NewQuery().Order("-Int").Run(&got); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*indexedTestModel{models[2], models[0]}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Models for scoped query were incorrect.\nExpected: %v\n     Got: %v", expected, got)
+	}
+	var count int
+	tx = pool.NewTransaction()
+	tx.Query(col).Filter("Int >", 0).Count(&count)
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected count of 1 for scoped transaction query but got %d", count)
+	}
+
+	// Unscoped should remove the scope
+	count, err = col.NewQuery().Unscoped().Filter("Int >", 0).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected count of 3 for unscoped query but got %d", count)
+	}
+
+	// Unscoped cannot be used after other modifiers, since they would be lost
+	if _, err := col.NewQuery().Filter("Int >", 0).Unscoped().Count(); err == nil {
+		t.Error("Expected an error when calling Unscoped after Filter but got none")
+	}
+
+	// Queries stored as JSON should not apply the scope twice
+	data, err := col.NewQuery().Order("Int").MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := UnmarshalQuery(col, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `indexedTestModel.NewQuery().Filter("Bool =", false).Order("Int")`; q.String() != expected {
+		t.Errorf("Unmarshaled query was incorrect.\nExpected: %s\n     Got: %s", expected, q.String())
+	}
+}
+
+func TestDefaultOrderField(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	byInt, err := pool.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true).WithDefaultOrderField("-Int"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byString, err := pool.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true).WithName("byString").WithDefaultOrderField("String"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := []*indexedTestModel{}
+	tx := pool.NewTransaction()
+	for i, s := range []string{"c", "a", "d", "b"} {
+		model := &indexedTestModel{Int: i, String: s}
+		models = append(models, model)
+		tx.Save(byInt, model)
+		tx.Save(byString, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// FindAll, ForEachBatch, and queries without an order should use the
+	// default order
+	descendingInts := []*indexedTestModel{models[3], models[2], models[1], models[0]}
+	got := []*indexedTestModel{}
+	if err := byInt.FindAll(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(descendingInts, got) {
+		t.Errorf("Models for FindAll were incorrect.\nExpected: %v\n     Got: %v", descendingInts, got)
+	}
+	got = []*indexedTestModel{}
+	batch := []*indexedTestModel{}
+	if err := byInt.ForEachBatch(&batch, 3, func() error {
+		got = append(got, batch...)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(descendingInts, got) {
+		t.Errorf("Models for ForEachBatch were incorrect.\nExpected: %v\n     Got: %v", descendingInts, got)
+	}
+	got = []*indexedTestModel{}
+	if err := byInt.NewQuery().Filter("Int >", 0).Limit(2).Run(&got); err != nil {
+		t.Fatal(err)
+	}
+	if expected := descendingInts[:2]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("Models for query without an order were incorrect.\nExpected: %v\n     Got: %v", expected, got)
+	}
+	byStringModels := []*indexedTestModel{}
+	if err := byString.FindAll(&byStringModels); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []*indexedTestModel{models[1], models[3], models[0], models[2]}; !reflect.DeepEqual(expected, byStringModels) {
+		t.Errorf("Models for FindAll ordered by a string field were incorrect.\nExpected: %v\n     Got: %v", expected, byStringModels)
+	}
+
+	// Order should replace the default order, which is not part of the query
+	// string
+	q := byInt.NewQuery().Order("Int")
+	got = []*indexedTestModel{}
+	if err := q.Run(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(models, got) {
+		t.Errorf("Models for query with an order were incorrect.\nExpected: %v\n     Got: %v", models, got)
+	}
+	if expected := `indexedTestModel.NewQuery().Order("Int")`; q.String() != expected {
+		t.Errorf("Query string was incorrect.\nExpected: %s\n     Got: %s", expected, q.String())
+	}
+	if expected := `indexedTestModel.NewQuery()`; byInt.NewQuery().String() != expected {
+		t.Errorf("Query string was incorrect.\nExpected: %s\n     Got: %s", expected, byInt.NewQuery().String())
+	}
+	if _, err := byInt.NewQuery().Order("Int").Order("String").Count(); err == nil {
+		t.Error("Expected an error for a query with two orders but got none")
+	}
+
+	// The default order field must exist, be indexed, and not be a pointer
+	if _, err := pool.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true).WithName("missingOrder").WithDefaultOrderField("Missing")); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("Expected ErrFieldNotFound for a missing default order field but got %v", err)
+	}
+	if _, err := pool.NewCollectionWithOptions(&testModel{}, DefaultCollectionOptions.WithIndex(true).WithName("unindexedOrder").WithDefaultOrderField("-Int")); !errors.Is(err, ErrUnindexedField) {
+		t.Errorf("Expected ErrUnindexedField for an unindexed default order field but got %v", err)
+	}
+}
+
+func TestQueryEstimateCount(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Int and Bool are independent, and no filter matches more models than
+	// are sampled, so the estimates should be exact.
+	tx := testPool.NewTransaction()
+	for i := 0; i < 100; i++ {
+		model := &indexedTestModel{
+			Int:    i % 10,
+			String: strconv.Itoa(i % 4),
+			Bool:   (i/10)%2 == 0,
+		}
+		tx.Save(indexedTestModels, model)
+	}
+	if err := tx.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		query    *Query
+		expected int
+	}{
+		{indexedTestModels.NewQuery(), 100},
+		{indexedTestModels.NewQuery().Filter("Int =", 3), 10},
+		{indexedTestModels.NewQuery().Filter("Int !=", 3), 90},
+		{indexedTestModels.NewQuery().Filter("Int IN", []int{1, 2, 3}), 30},
+		{indexedTestModels.NewQuery().Filter("String =", "1"), 25},
+		{indexedTestModels.NewQuery().Filter("Int <", 5).Filter("Bool =", true), 25},
+		// Filters on the same field are correlated, which the sample accounts
+		// for (assuming independence would give 9)
+		{indexedTestModels.NewQuery().Filter("Int >=", 8).Filter("Bool =", false).Filter("Int <", 9), 5},
+		// Int and String are correlated (assuming independence would give 3
+		// and 23)
+		{indexedTestModels.NewQuery().Filter("Int =", 2).Filter("String =", "2"), 5},
+		{indexedTestModels.NewQuery().Filter("Int !=", 3).Filter("String =", "1"), 20},
+		{indexedTestModels.NewQuery().Filter("Int IN", []int{1, 3}).Filter("String =", "0").Filter("Bool =", true), 0},
+		{indexedTestModels.NewQuery().Filter("Int <", 5).Filter("Bool =", true).Limit(10), 10},
+		{indexedTestModels.NewQuery().Filter("Int <", 5).Filter("Bool =", true).Offset(20), 5},
+		{indexedTestModels.NewQuery().Filter("Int >", 100).Filter("Bool =", true), 0},
+	}
+	for i, tc := range testCases {
+		got, err := tc.query.EstimateCount()
+		if err != nil {
+			t.Errorf("Unexpected error in test case %d: %s", i, err.Error())
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("Error in test case %d (%s): expected estimate of %d but got %d", i, tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestQueryDelete(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := indexedTestModels.NewQuery().Filter("Int >", models[0].Int)
+	expectedDeleted := expectedResultsForQuery(q.query, models)
+	count, err := q.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(expectedDeleted) {
+		t.Errorf("Expected %d models to be deleted but got %d", len(expectedDeleted), count)
+	}
+	for _, model := range models {
+		if stringSliceContains(modelIDs(Models(expectedDeleted)), model.ModelID()) {
+			expectModelDoesNotExist(t, indexedTestModels, model)
+			for _, fieldName := range []string{"Int", "String", "Bool"} {
+				expectIndexDoesNotExist(t, indexedTestModels, model, fieldName)
+			}
+		} else {
+			expectModelExists(t, indexedTestModels, model)
+			for _, fieldName := range []string{"Int", "String", "Bool"} {
+				expectIndexExists(t, indexedTestModels, model, fieldName)
+			}
+		}
+	}
+	checkForLeakedTmpKeys(t, q.query)
+}
+
+func TestQueryUpdate(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := indexedTestModels.NewQuery().Filter("Int >", models[0].Int)
+	expectedUpdated := expectedResultsForQuery(q.query, models)
+	count, err := q.Update(map[string]interface{}{
+		"String": "updated",
+		"Int":    -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(expectedUpdated) {
+		t.Errorf("Expected %d models to be updated but got %d", len(expectedUpdated), count)
+	}
+	for _, model := range expectedUpdated {
+		model.String = "updated"
+		model.Int = -1
+	}
+	// The models and indexes should reflect the new values.
+	for _, model := range models {
+		got := &indexedTestModel{}
+		if err := indexedTestModels.Find(model.ModelID(), got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(model, got) {
+			t.Errorf("Model was incorrect.\nExpected: %v\nGot:      %v", model, got)
+		}
+		for _, fieldName := range []string{"Int", "String", "Bool"} {
+			expectIndexExists(t, indexedTestModels, model, fieldName)
+		}
+	}
+	testQuery(t, indexedTestModels.NewQuery().Filter("String =", "updated"), models)
+	testQuery(t, indexedTestModels.NewQuery().Order("Int"), models)
+	checkForLeakedTmpKeys(t, q.query)
+
+	// Invalid field names and values should cause an error.
+	if _, err := indexedTestModels.NewQuery().Update(map[string]interface{}{"Foo": 1}); err == nil {
+		t.Error("Expected an error for an invalid field name but got none")
+	}
+	if _, err := indexedTestModels.NewQuery().Update(map[string]interface{}{"Int": "foo"}); err == nil {
+		t.Error("Expected an error for an invalid value but got none")
+	}
+}
+
 // There's a huge amount of test cases to cover above. Below is some code that
 // makes it easier, but needs to be tested itself. Testing for correctness using
 // a brute force approach (obviously slow compared to what Zoom is actually
@@ -398,11 +1736,21 @@ func expectedResultsForQuery(q *query, models []*indexedTestModel) []*indexedTes
 		expected = orderedIntersectModels(applyFilter(expected, filter), expected)
 	}
 
+	// apply id filters, which also order the models by id
+	if q.hasIDFilters() {
+		expected = applyIDFilters(expected, q.idFilters)
+	}
+
 	// apply order (if applicable)
 	if q.hasOrder() {
 		expected = applyOrder(expected, q.order)
 	}
 
+	// apply last (if applicable)
+	if q.hasLast() && int(q.last) < len(expected) {
+		expected = expected[len(expected)-int(q.last):]
+	}
+
 	// apply limit/offset
 	expected = applyLimitAndOffset(expected, q.limit, q.offset)
 
@@ -416,6 +1764,38 @@ func expectedResultsForQuery(q *query, models []*indexedTestModel) []*indexedTes
 	return expected
 }
 
+// applyIDFilters returns only the models whose ids pass all the filters, in the
+// order of their ids.
+func applyIDFilters(models []*indexedTestModel, filters []idFilter) []*indexedTestModel {
+	results := []*indexedTestModel{}
+	for _, m := range models {
+		passes := true
+		for _, f := range filters {
+			switch {
+			case f.prefix:
+				passes = passes && strings.HasPrefix(m.ID, f.id)
+			case f.op == equalOp:
+				passes = passes && m.ID == f.id
+			case f.op == greaterOp:
+				passes = passes && m.ID > f.id
+			case f.op == lessOp:
+				passes = passes && m.ID < f.id
+			case f.op == greaterOrEqualOp:
+				passes = passes && m.ID >= f.id
+			case f.op == lessOrEqualOp:
+				passes = passes && m.ID <= f.id
+			}
+		}
+		if passes {
+			results = append(results, m)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
+
 // applyFilter returns only the models which pass the filter criteria.
 func applyFilter(models []*indexedTestModel, filter filter) []*indexedTestModel {
 	var filterFunc func(m *indexedTestModel) bool
@@ -0,0 +1,116 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File raw.go contains code for reading and writing the main hash of a model
+// directly, e.g. for interoperability with services which are not written in
+// Go but read or write the same keys.
+
+package zoom
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// GetRaw returns the fields of the model with the given id exactly as they are
+// stored in the main hash, as a map of redis names to values. Fields which are
+// stored in their own key are not included. It returns a ModelNotFoundError if
+// the model does not exist.
+func (c *Collection) GetRaw(id string) (map[string]string, error) {
+	t := c.pool.NewTransaction()
+	raw := map[string]string{}
+	t.GetRaw(c, id, &raw)
+	if err := t.Exec(); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// GetRaw is like Collection.GetRaw but sets the value of raw when the
+// transaction is executed. Any errors encountered will be added to the
+// transaction and returned as an error when the transaction is executed.
+func (t *Transaction) GetRaw(c *Collection, id string, raw *map[string]string) {
+	if c == nil {
+		t.setError(newNilCollectionError("GetRaw"))
+		return
+	}
+	if err := c.checkHashLayout("GetRaw"); err != nil {
+		t.setError(err)
+		return
+	}
+	key := c.ModelKey(id)
+	t.modelCommand(id, "EXISTS", redis.Args{key}, newModelExistsHandler(c, id))
+	t.modelCommand(id, "HGETALL", redis.Args{key}, func(reply interface{}) error {
+		values, err := redis.StringMap(reply, nil)
+		if err != nil {
+			return err
+		}
+		(*raw) = values
+		return nil
+	})
+}
+
+// SetRawField sets the field identified by redisField (the name of the field
+// as it is stored in Redis) in the main hash of the model with the given id to
+// the given raw value, and updates the indexes on the field accordingly. value
+// must be in the format Zoom uses for the type of the field, e.g. "42" for an
+// int or "NULL" for a nil pointer. Unlike HSET, SetRawField returns an error if
+// value cannot be read into the field, so that the hash and indexes cannot get
+// out of sync. Like SaveFields, it does not require the model to already
+// exist, and it is recorded as an update in the audit log and outbox (if
+// enabled), but it does not set UpdatedAt.
+func (c *Collection) SetRawField(id string, redisField string, value string) error {
+	t := c.pool.NewTransaction()
+	t.SetRawField(c, id, redisField, value)
+	return t.Exec()
+}
+
+// SetRawField is like Collection.SetRawField but runs inside an existing
+// transaction. Any errors encountered will be added to the transaction and
+// returned as an error when the transaction is executed.
+func (t *Transaction) SetRawField(c *Collection, id string, redisField string, value string) {
+	if c == nil {
+		t.setError(newNilCollectionError("SetRawField"))
+		return
+	}
+	if err := c.checkHashLayout("SetRawField"); err != nil {
+		t.setError(err)
+		return
+	}
+	if id == "" {
+		t.setError(fmt.Errorf("zoom: Error in SetRawField: id cannot be empty"))
+		return
+	}
+	var fs *fieldSpec
+	for _, field := range c.spec.fields {
+		if field.redisName == redisField {
+			fs = field
+			break
+		}
+	}
+	if fs == nil {
+		t.setError(newKindError(ErrFieldNotFound, "zoom: Error in SetRawField: Collection %s does not have a field stored in the main hash as %s", c.Name(), redisField))
+		return
+	}
+	// Read the value into a new modelRef to make sure it is valid, and so that
+	// the indexes can be updated the same way SaveFields updates them.
+	mr := c.newValuesModelRef(id)
+	fieldNames := []string{fs.name}
+	if err := scanModel(fieldNames, []interface{}{[]byte(value)}, mr); err != nil {
+		t.setError(fmt.Errorf("zoom: Error in SetRawField: invalid value for %s: %w", fs.name, err))
+		return
+	}
+	t.saveFieldIndexesForFields(fieldNames, mr)
+	t.saveFullTextIndexes(fieldNames, mr)
+	hashArgs := redis.Args{c.ModelKey(id), redisField, value}
+	t.modelCommand(id, "HSET", hashArgs, nil)
+	t.expireFields(c.spec, id, hashArgs)
+	if c.index {
+		t.addToIndex(c, id)
+	}
+	t.recordChange(c, id, ChangeUpdate, fieldNames)
+	t.publishEvent(c, ChangeUpdate, id, hashArgs)
+	t.invalidateCachedModel(c, id)
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File raw_test.go tests the code in raw.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRaw(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := &indexedTestModel{Int: 42, String: "foo", Bool: true}
+	require.NoError(t, indexedTestModels.Save(model))
+	raw, err := indexedTestModels.GetRaw(model.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Int": "42", "String": "foo", "Bool": "1"}, raw)
+
+	_, err = indexedTestModels.GetRaw("invalid")
+	assert.IsType(t, ModelNotFoundError{}, err)
+}
+
+func TestSetRawField(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(2)
+	require.NoError(t, err)
+	model := models[0]
+	require.NoError(t, indexedTestModels.SetRawField(model.ID, "Int", "-7"))
+	require.NoError(t, indexedTestModels.SetRawField(model.ID, "String", "updated"))
+	require.NoError(t, indexedTestModels.SetRawField(model.ID, "Bool", "true"))
+	got := &indexedTestModel{}
+	require.NoError(t, indexedTestModels.Find(model.ID, got))
+	assert.Equal(t, -7, got.Int)
+	assert.Equal(t, "updated", got.String)
+	assert.True(t, got.Bool)
+
+	// The indexes should be consistent with the new values
+	ids, err := indexedTestModels.NewQuery().Filter("Int =", -7).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.ID}, ids)
+	ids, err = indexedTestModels.NewQuery().Filter("String =", "updated").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.ID}, ids)
+	ids, err = indexedTestModels.NewQuery().Filter("String =", model.String).IDs()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	count, err := indexedTestModels.NewQuery().Filter("Bool =", true).Filter("Int =", -7).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Setting a field of a new model should add it to the collection index
+	require.NoError(t, indexedTestModels.SetRawField("new", "Int", "3"))
+	exists, err := indexedTestModels.Exists("new")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	ids, err = indexedTestModels.NewQuery().Filter("Int =", 3).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, ids)
+
+	// Invalid values and unknown fields should not change anything
+	assert.Error(t, indexedTestModels.SetRawField(model.ID, "Int", "abc"))
+	assert.Error(t, indexedTestModels.SetRawField(model.ID, "Bool", "maybe"))
+	assert.Error(t, indexedTestModels.SetRawField(model.ID, "Invalid", "1"))
+	assert.Error(t, indexedTestModels.SetRawField("", "Int", "1"))
+	raw, err := indexedTestModels.GetRaw(model.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "-7", raw["Int"])
+	assert.Equal(t, "true", raw["Bool"])
+}
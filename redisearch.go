@@ -0,0 +1,439 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File redisearch.go contains code related to the optional RediSearch query
+// backend, including creating the search index for a collection and
+// translating queries into FT.SEARCH commands.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// rediSearchMaxResults is the maximum number of results (including the offset)
+// which FT.SEARCH returns. It matches the default value of the MAXSEARCHRESULTS
+// configuration option in RediSearch. Queries which could return more results
+// (e.g. queries without a Limit) use the sorted set indexes instead.
+const rediSearchMaxResults = 10000
+
+// rediSearchTextSuffix is appended to the name of a field with a full-text
+// index to form the name of the corresponding TEXT attribute in the search
+// index. This allows a field to have both a full-text index and a regular
+// index.
+const rediSearchTextSuffix = "__text"
+
+// searchIndexName returns the name of the RediSearch index for the collection.
+func (ms *modelSpec) searchIndexName() string {
+	return ms.name + ":idx"
+}
+
+// rediSearchFieldType returns the type of the attribute (e.g. NUMERIC or TAG)
+// that should be used for fs in the search index. It returns false if fs
+// should not be included in the search index as a regular attribute. Pointer
+// fields are not included, because the "NULL" value that Zoom uses for nil
+// pointers cannot be indexed correctly. Neither are int64 and uint64 fields,
+// because RediSearch stores numbers as doubles, which would lose precision, or
+// string fields with a collation, because RediSearch would compare the values
+// byte by byte.
+func rediSearchFieldType(fs *fieldSpec) (string, bool) {
+	if fs.kind != primativeField {
+		return "", false
+	}
+	switch fs.indexKind {
+	case numericIndex, booleanIndex:
+		return "NUMERIC", true
+	case stringIndex:
+		if fs.collate != nil {
+			return "", false
+		}
+		return "TAG", true
+	}
+	return "", false
+}
+
+// searchIndexSchemaArgs returns the arguments for the FT.CREATE command which
+// will create the search index for the collection. It returns an error if the
+// model type cannot be used with RediSearch.
+func (ms *modelSpec) searchIndexSchemaArgs() (redis.Args, error) {
+	if len(ms.keyFields) > 0 {
+		// The keys for fields stored in their own hash would share the same
+		// prefix as the main hashes and would be indexed as separate documents.
+		return nil, fmt.Errorf("zoom: UseRediSearch cannot be used with model type %s because it has fields stored in their own key", ms.typ.String())
+	}
+	args := redis.Args{ms.searchIndexName(), "ON", "HASH", "PREFIX", 1, ms.name + ":", "SCHEMA"}
+	numAttributes := 0
+	for _, fs := range ms.fieldsWithComputed() {
+		if fieldType, ok := rediSearchFieldType(fs); ok {
+			args = args.Add(fs.redisName, fieldType)
+			if fieldType == "TAG" {
+				// Use a separator which is unlikely to appear in field values,
+				// since Zoom indexes the entire string as a single value.
+				args = args.Add("SEPARATOR", "\x1f", "CASESENSITIVE")
+			}
+			args = args.Add("SORTABLE")
+			numAttributes++
+		}
+		if fs.fullText != nil && fs.kind == primativeField {
+			args = args.Add(fs.redisName, "AS", fs.redisName+rediSearchTextSuffix, "TEXT")
+			if !fs.fullText.stem {
+				args = args.Add("NOSTEM")
+			}
+			numAttributes++
+		}
+	}
+	if numAttributes == 0 {
+		return nil, fmt.Errorf("zoom: UseRediSearch cannot be used with model type %s because it does not have any indexed fields", ms.typ.String())
+	}
+	return args, nil
+}
+
+// EnsureSearchIndex creates the RediSearch index for the collection if it does
+// not already exist. It is called automatically by NewCollectionWithOptions if
+// the UseRediSearch option is true, but you may need to call it again if the
+// index is dropped (e.g. after FLUSHDB). The schema of an existing index is
+// not changed. If you change the indexed fields of the model type, drop the old
+// index with DropSearchIndex first.
+func (c *Collection) EnsureSearchIndex() error {
+	args, err := c.spec.searchIndexSchemaArgs()
+	if err != nil {
+		return err
+	}
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("FT.CREATE", args...); err != nil {
+		if strings.Contains(err.Error(), "Index already exists") {
+			return nil
+		}
+		return fmt.Errorf("zoom: error in EnsureSearchIndex: %w", err)
+	}
+	return nil
+}
+
+// DropSearchIndex drops the RediSearch index for the collection. The models
+// themselves are not deleted.
+func (c *Collection) DropSearchIndex() error {
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Do("FT.DROPINDEX", c.spec.searchIndexName()); err != nil {
+		return fmt.Errorf("zoom: error in DropSearchIndex: %w", err)
+	}
+	return nil
+}
+
+// escapeRediSearchTag escapes all the characters in value which have a special
+// meaning in the RediSearch query syntax.
+func escapeRediSearchTag(value string) string {
+	escaped := ""
+	for _, r := range value {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			escaped += `\`
+		}
+		escaped += string(r)
+	}
+	return escaped
+}
+
+// rediSearchNumber formats the numeric (or boolean) value for use in a
+// RediSearch numeric range.
+func rediSearchNumber(val reflect.Value) string {
+	for val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() == reflect.Bool {
+		return strconv.Itoa(boolScore(val))
+	}
+	return strconv.FormatFloat(numericScore(val), 'g', -1, 64)
+}
+
+// rediSearchInClause returns the clause of a RediSearch query which matches
+// documents for which the attribute with the given name and type is equal to
+// any of the given values. It returns false if values is empty.
+func rediSearchInClause(name string, fieldType string, values reflect.Value) (string, bool) {
+	if values.Len() == 0 {
+		return "", false
+	}
+	alternatives := []string{}
+	for i := 0; i < values.Len(); i++ {
+		val := values.Index(i)
+		for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+			val = val.Elem()
+		}
+		if fieldType == "TAG" {
+			alternatives = append(alternatives, escapeRediSearchTag(val.String()))
+		} else {
+			value := rediSearchNumber(val)
+			alternatives = append(alternatives, fmt.Sprintf("%s:[%s %s]", name, value, value))
+		}
+	}
+	if fieldType == "TAG" {
+		return fmt.Sprintf("%s:{%s}", name, strings.Join(alternatives, " | ")), true
+	}
+	return "(" + strings.Join(alternatives, " | ") + ")", true
+}
+
+// rediSearchQuery returns the query string for FT.SEARCH which is equivalent to
+// the filters and searches of q. It returns false if any of the filters or
+// searches cannot be expressed in the RediSearch query syntax.
+func (q *query) rediSearchQuery() (string, bool) {
+	clauses := []string{}
+	for _, filter := range q.filters {
+		fieldType, ok := rediSearchFieldType(filter.fieldSpec)
+		if !ok {
+			return "", false
+		}
+		name := "@" + filter.fieldSpec.redisName
+		if filter.op == inOp {
+			clause, ok := rediSearchInClause(name, fieldType, filter.value)
+			if !ok {
+				return "", false
+			}
+			clauses = append(clauses, clause)
+			continue
+		}
+		if fieldType == "TAG" {
+			// TAG attributes only support equality.
+			val := filter.value
+			for val.Kind() == reflect.Ptr {
+				val = val.Elem()
+			}
+			clause := fmt.Sprintf("%s:{%s}", name, escapeRediSearchTag(val.String()))
+			switch filter.op {
+			case equalOp:
+				clauses = append(clauses, clause)
+			case notEqualOp:
+				clauses = append(clauses, "-"+clause)
+			default:
+				return "", false
+			}
+			continue
+		}
+		value := rediSearchNumber(filter.value)
+		switch filter.op {
+		case equalOp:
+			clauses = append(clauses, fmt.Sprintf("%s:[%s %s]", name, value, value))
+		case notEqualOp:
+			clauses = append(clauses, fmt.Sprintf("-%s:[%s %s]", name, value, value))
+		case greaterOp:
+			clauses = append(clauses, fmt.Sprintf("%s:[(%s +inf]", name, value))
+		case lessOp:
+			clauses = append(clauses, fmt.Sprintf("%s:[-inf (%s]", name, value))
+		case greaterOrEqualOp:
+			clauses = append(clauses, fmt.Sprintf("%s:[%s +inf]", name, value))
+		case lessOrEqualOp:
+			clauses = append(clauses, fmt.Sprintf("%s:[-inf %s]", name, value))
+		case rangeOp:
+			max := rediSearchNumber(filter.max)
+			if !filter.inclusive {
+				value, max = "("+value, "("+max
+			}
+			clauses = append(clauses, fmt.Sprintf("%s:[%s %s]", name, value, max))
+		default:
+			return "", false
+		}
+	}
+	for _, search := range q.searches {
+		if search.fieldSpec.kind != primativeField {
+			return "", false
+		}
+		// RediSearch applies its own stemming and stop words, so we pass in the
+		// original words instead of the terms from our own tokenizer.
+		words := (&fullTextOptions{}).tokenize(search.text)
+		for i, word := range words {
+			words[i] = escapeRediSearchTag(word)
+		}
+		clauses = append(clauses, fmt.Sprintf("@%s%s:(%s)", search.fieldSpec.redisName, rediSearchTextSuffix, strings.Join(words, " ")))
+	}
+	if len(clauses) == 0 {
+		return "*", true
+	}
+	return strings.Join(clauses, " "), true
+}
+
+// rediSearchArgs returns the arguments for an FT.SEARCH command which is
+// equivalent to q, not including the LIMIT or RETURN options. It returns false
+// if q cannot be executed with RediSearch, in which case the default sorted set
+// indexes should be used instead.
+func (q *query) rediSearchArgs() (redis.Args, bool) {
+	if !q.collection.rediSearch || q.hasLast() || q.hasSample() || q.hasIDSets() || q.hasIDFilters() || q.hasJoins() || q.hasNegations() || q.order.isExpr() {
+		return nil, false
+	}
+	queryString, ok := q.rediSearchQuery()
+	if !ok {
+		return nil, false
+	}
+	args := redis.Args{q.collection.spec.searchIndexName(), queryString}
+	if q.hasOrder() {
+		fs := q.collection.spec.fieldsByName[q.order.fieldName]
+		if _, ok := rediSearchFieldType(fs); !ok {
+			return nil, false
+		}
+		direction := "ASC"
+		if q.order.kind == descendingOrder {
+			direction = "DESC"
+		}
+		args = args.Add("SORTBY", fs.redisName, direction)
+	}
+	return args, true
+}
+
+// rediSearchWithinMaxResults returns true iff FT.SEARCH can return all of the
+// (at most limit) models which match q, i.e. if limit is not 0 (which means
+// unlimited) and the offset of q plus limit is not more than
+// rediSearchMaxResults.
+func (q *query) rediSearchWithinMaxResults(limit int) bool {
+	return limit > 0 && int(q.offset)+limit <= rediSearchMaxResults
+}
+
+// rediSearchRunArgs returns the arguments for an FT.SEARCH command which will
+// return the fields needed to run q, finding at most limit models.
+func (q *query) rediSearchRunArgs(baseArgs redis.Args, limit int) redis.Args {
+	args := append(redis.Args{}, baseArgs...)
+	args = args.Add("LIMIT", q.offset, limit)
+	redisNames := q.redisFieldNames()
+	if len(redisNames) == 0 {
+		return args.Add("NOCONTENT")
+	}
+	args = args.Add("RETURN", len(redisNames))
+	for _, redisName := range redisNames {
+		args = args.Add(redisName)
+	}
+	return args
+}
+
+// checkRediSearchResults returns an error if the reply from an FT.SEARCH command
+// for q with the given limit, which contains numResults results, is missing
+// some of the results, e.g. because the MAXSEARCHRESULTS option of the server
+// is lower than rediSearchMaxResults. The first value of the reply is the
+// total number of results.
+func checkRediSearchResults(q *query, limit int, values []interface{}, numResults int) error {
+	if len(values) == 0 {
+		return fmt.Errorf("zoom: unexpected empty reply from FT.SEARCH")
+	}
+	total, err := redis.Int(values[0], nil)
+	if err != nil {
+		return err
+	}
+	expected := total - int(q.offset)
+	if limit < expected {
+		expected = limit
+	}
+	if numResults < expected {
+		return fmt.Errorf("zoom: FT.SEARCH returned %d results but %d were expected (the MAXSEARCHRESULTS option of RediSearch may be too low)", numResults, expected)
+	}
+	return nil
+}
+
+// newRediSearchModelsHandler returns a ReplyHandler which converts the reply
+// from an FT.SEARCH command for q with the given limit into the format expected
+// by handler, i.e. a flat array of the values for each field in redisNames
+// followed by the model id, and then calls handler. It returns an error if the
+// reply is missing some of the results.
+func newRediSearchModelsHandler(q *query, limit int, redisNames []string, handler ReplyHandler) ReplyHandler {
+	spec := q.collection.spec
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+		flat := []interface{}{}
+		numResults := 0
+		prefix := spec.name + ":"
+		hasContent := len(redisNames) > 0
+		for i := 1; i < len(values); i++ {
+			key, err := redis.String(values[i], nil)
+			if err != nil {
+				return err
+			}
+			fieldValues := map[string]interface{}{}
+			if hasContent && i+1 < len(values) {
+				i++
+				pairs, err := redis.Values(values[i], nil)
+				if err != nil {
+					return err
+				}
+				for j := 0; j+1 < len(pairs); j += 2 {
+					name, err := redis.String(pairs[j], nil)
+					if err != nil {
+						return err
+					}
+					fieldValues[name] = pairs[j+1]
+				}
+			}
+			for _, redisName := range redisNames {
+				flat = append(flat, fieldValues[redisName])
+			}
+			flat = append(flat, []byte(strings.TrimPrefix(key, prefix)))
+			numResults++
+		}
+		if err := checkRediSearchResults(q, limit, values, numResults); err != nil {
+			return err
+		}
+		return handler(flat)
+	}
+}
+
+// newRediSearchIDsHandler returns a ReplyHandler which scans the ids from the
+// reply from an FT.SEARCH command for q with the NOCONTENT option into ids. It
+// returns an error if the reply is missing some of the results.
+func newRediSearchIDsHandler(q *query, ids *[]string) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+		(*ids) = []string{}
+		if err := checkRediSearchResults(q, int(q.limit), values, len(values)-1); err != nil {
+			return err
+		}
+		// The first value is the total number of results.
+		keys, err := redis.Strings(values[1:], nil)
+		if err != nil {
+			return err
+		}
+		prefix := q.collection.spec.name + ":"
+		for _, key := range keys {
+			(*ids) = append(*ids, strings.TrimPrefix(key, prefix))
+		}
+		return nil
+	}
+}
+
+// newRediSearchCountHandler returns a ReplyHandler which scans the total number
+// of results from the reply from an FT.SEARCH command into count, taking into
+// account the offset and limit of q.
+func newRediSearchCountHandler(q *query, count *int) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("zoom: unexpected empty reply from FT.SEARCH")
+		}
+		gotCount, err := redis.Int(values[0], nil)
+		if err != nil {
+			return err
+		}
+		gotCount -= int(q.offset)
+		if gotCount < 0 {
+			gotCount = 0
+		}
+		if q.hasLimit() && int(q.limit) < gotCount {
+			gotCount = int(q.limit)
+		}
+		(*count) = gotCount
+		return nil
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File redisearch_test.go tests the code in redisearch.go. Since the test
+// database does not necessarily have the RediSearch module loaded, these tests
+// only cover the commands Zoom generates and how it parses the replies.
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rediSearchCollection returns a copy of c which uses the RediSearch backend
+// without creating the search index.
+func rediSearchCollection(c *Collection) *Collection {
+	copy := *c
+	copy.rediSearch = true
+	return &copy
+}
+
+func TestSearchIndexSchemaArgs(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	args, err := fullTextModels.spec.searchIndexSchemaArgs()
+	require.NoError(t, err)
+	expected := redis.Args{"fullTextModel:idx", "ON", "HASH", "PREFIX", 1, "fullTextModel:", "SCHEMA",
+		"Title", "AS", "Title__text", "TEXT",
+		"Rank", "NUMERIC", "SORTABLE",
+	}
+	assert.Equal(t, expected, args)
+
+	args, err = indexedTestModels.spec.searchIndexSchemaArgs()
+	require.NoError(t, err)
+	expected = redis.Args{"indexedTestModel:idx", "ON", "HASH", "PREFIX", 1, "indexedTestModel:", "SCHEMA",
+		"Int", "NUMERIC", "SORTABLE",
+		"String", "TAG", "SEPARATOR", "\x1f", "CASESENSITIVE", "SORTABLE",
+		"Bool", "NUMERIC", "SORTABLE",
+	}
+	assert.Equal(t, expected, args)
+
+	// Models with fields stored in their own key or without any indexed fields
+	// cannot use RediSearch.
+	_, err = hashFieldModels.spec.searchIndexSchemaArgs()
+	assert.Error(t, err)
+	_, err = testModels.spec.searchIndexSchemaArgs()
+	assert.Error(t, err)
+}
+
+func TestRediSearchArgs(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := rediSearchCollection(indexedTestModels)
+	testCases := []struct {
+		query    *Query
+		expected redis.Args
+	}{
+		{
+			query:    models.NewQuery(),
+			expected: redis.Args{"indexedTestModel:idx", "*"},
+		},
+		{
+			query:    models.NewQuery().Filter("Int >", 3).Filter("Int <=", 10),
+			expected: redis.Args{"indexedTestModel:idx", "@Int:[(3 +inf] @Int:[-inf 10]"},
+		},
+		{
+			query:    models.NewQuery().Filter("Bool =", true).Order("-Int"),
+			expected: redis.Args{"indexedTestModel:idx", "@Bool:[1 1]", "SORTBY", "Int", "DESC"},
+		},
+		{
+			query:    models.NewQuery().Filter("String !=", "foo bar").Order("String"),
+			expected: redis.Args{"indexedTestModel:idx", `-@String:{foo\ bar}`, "SORTBY", "String", "ASC"},
+		},
+		{
+			query:    models.NewQuery().FilterRange("Int", 3, 10, false),
+			expected: redis.Args{"indexedTestModel:idx", "@Int:[(3 (10]"},
+		},
+	}
+	for _, tc := range testCases {
+		require.NoError(t, tc.query.err)
+		args, ok := tc.query.rediSearchArgs()
+		if assert.True(t, ok, "Expected query to be translatable: %s", tc.query) {
+			assert.Equal(t, tc.expected, args, "Wrong args for query: %s", tc.query)
+		}
+	}
+
+	// Queries which cannot be translated should fall back to the default
+	// indexes.
+	for _, query := range []*Query{
+		models.NewQuery().Filter("String >", "foo"),
+		models.NewQuery().FilterRange("String", "a", "b", true),
+		models.NewQuery().Last(3),
+		indexedTestModels.NewQuery(),
+	} {
+		_, ok := query.rediSearchArgs()
+		assert.False(t, ok, "Expected query to not be translatable: %s", query)
+	}
+
+	// Searches use the TEXT attribute.
+	fullText := rediSearchCollection(fullTextModels)
+	args, ok := fullText.NewQuery().Search("Title", "Quick, brown-fox").rediSearchArgs()
+	require.True(t, ok)
+	assert.Equal(t, redis.Args{"fullTextModel:idx", "@Title__text:(quick brown fox)"}, args)
+	_, ok = fullText.NewQuery().Search("Body", "fox").rediSearchArgs()
+	assert.False(t, ok, "Expected search on a pointer field to not be translatable")
+}
+
+func TestRediSearchMaxResults(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Queries which could return more than rediSearchMaxResults models should
+	// use the sorted set indexes, since FT.SEARCH would silently truncate the
+	// results.
+	models := rediSearchCollection(indexedTestModels)
+	for _, tc := range []struct {
+		query    *Query
+		expected bool
+	}{
+		{query: models.NewQuery(), expected: false},
+		{query: models.NewQuery().Limit(10), expected: true},
+		{query: models.NewQuery().Offset(rediSearchMaxResults - 10).Limit(10), expected: true},
+		{query: models.NewQuery().Offset(rediSearchMaxResults - 10).Limit(11), expected: false},
+		{query: models.NewQuery().Limit(rediSearchMaxResults + 1), expected: false},
+	} {
+		assert.Equal(t, tc.expected, tc.query.rediSearchWithinMaxResults(int(tc.query.limit)), "Wrong result for query: %s", tc.query)
+		tx := testPool.NewTransaction()
+		newTransactionQuery(tc.query.query, tx).IDs(&[]string{})
+		commands, err := tx.DryRun()
+		require.NoError(t, err)
+		usedRediSearch := len(commands) > 0 && commands[0].Name == "FT.SEARCH"
+		assert.Equal(t, tc.expected, usedRediSearch, "Wrong backend for query: %s", tc.query)
+	}
+}
+
+func TestRediSearchHandlers(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	spec := indexedTestModels.spec
+	reply := []interface{}{
+		int64(5),
+		[]byte("indexedTestModel:a"),
+		[]interface{}{[]byte("Int"), []byte("1"), []byte("String"), []byte("foo")},
+		[]byte("indexedTestModel:b"),
+		[]interface{}{[]byte("String"), []byte("bar"), []byte("Int"), []byte("2")},
+	}
+	redisNames := []string{"Int", "String"}
+	models := []*indexedTestModel{}
+	handler := newScanModelsHandler(spec, []string{"Int", "String", "-"}, &models)
+	q := indexedTestModels.NewQuery().Offset(3).Limit(2)
+	require.NoError(t, newRediSearchModelsHandler(q.query, 2, redisNames, handler)(reply))
+	require.Len(t, models, 2)
+	assert.Equal(t, "a", models[0].ID)
+	assert.Equal(t, 1, models[0].Int)
+	assert.Equal(t, "foo", models[0].String)
+	assert.Equal(t, "b", models[1].ID)
+	assert.Equal(t, 2, models[1].Int)
+	assert.Equal(t, "bar", models[1].String)
+
+	ids := []string{}
+	idsReply := []interface{}{int64(5), []byte("indexedTestModel:a"), []byte("indexedTestModel:b")}
+	require.NoError(t, newRediSearchIDsHandler(q.query, &ids)(idsReply))
+	assert.Equal(t, []string{"a", "b"}, ids)
+
+	// A reply which is missing some of the results (e.g. because the server
+	// limits the number of results) should be an error.
+	q = indexedTestModels.NewQuery().Limit(3)
+	assert.Error(t, newRediSearchModelsHandler(q.query, 3, redisNames, handler)(reply))
+	assert.Error(t, newRediSearchIDsHandler(q.query, &ids)(idsReply))
+
+	count := 0
+	q = indexedTestModels.NewQuery().Offset(1).Limit(3)
+	require.NoError(t, newRediSearchCountHandler(q.query, &count)([]interface{}{int64(5)}))
+	assert.Equal(t, 3, count)
+	q = indexedTestModels.NewQuery().Offset(4)
+	require.NoError(t, newRediSearchCountHandler(q.query, &count)([]interface{}{int64(5)}))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File references.go contains code for the ref option of the zoom struct tag,
+// which keeps the ids stored in a field consistent with the models in another
+// collection when those models are deleted.
+
+package zoom
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// OnDelete determines what happens to the models which reference a model when
+// it is deleted with Delete. It is set with the ondelete option of the zoom
+// struct tag for a field with the ref option.
+type OnDelete int
+
+const (
+	// Restrict prevents a model from being deleted while any model references
+	// it. It is the default.
+	Restrict OnDelete = iota
+	// Cascade deletes the models which reference a model together with it.
+	Cascade
+	// SetNull sets the field of the models which reference a model to an
+	// empty string when it is deleted.
+	SetNull
+)
+
+// String returns the value of the ondelete option for od.
+func (od OnDelete) String() string {
+	switch od {
+	case Restrict:
+		return "restrict"
+	case Cascade:
+		return "cascade"
+	case SetNull:
+		return "setnull"
+	}
+	return fmt.Sprintf("OnDelete(%d)", int(od))
+}
+
+// parseOnDelete parses the value of the ondelete option of the zoom struct tag
+// for the field with the given name.
+func parseOnDelete(fieldName string, option string) (OnDelete, error) {
+	for _, od := range []OnDelete{Restrict, Cascade, SetNull} {
+		if option == od.String() {
+			return od, nil
+		}
+	}
+	return 0, fmt.Errorf("zoom: ondelete option for field %s must be restrict, cascade, or setnull. Got: %s", fieldName, option)
+}
+
+// reference describes the ref option of a field, which holds the id of a model
+// in another collection.
+type reference struct {
+	// collectionName is the name of the referenced collection.
+	collectionName string
+	onDelete       OnDelete
+}
+
+// referencingField is a field of a registered collection which references the
+// models of another collection.
+type referencingField struct {
+	collection *Collection
+	fs         *fieldSpec
+}
+
+// referencingFields returns the fields of the collections registered with the
+// pool of c which reference the models in c, sorted by the name of the
+// collection and the field.
+func (c *Collection) referencingFields() []referencingField {
+	if c.pool == nil {
+		return nil
+	}
+	c.pool.registryMut.RLock()
+	defer c.pool.registryMut.RUnlock()
+	refs := []referencingField{}
+	for _, other := range c.pool.modelNameToCollection {
+		for _, fs := range other.spec.fields {
+			if fs.ref != nil && fs.ref.collectionName == c.Name() {
+				refs = append(refs, referencingField{collection: other, fs: fs})
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].collection.Name() != refs[j].collection.Name() {
+			return refs[i].collection.Name() < refs[j].collection.Name()
+		}
+		return refs[i].fs.name < refs[j].fs.name
+	})
+	return refs
+}
+
+// checkUnreferenced returns an error if any field of a registered collection
+// references the models in c. It is used by the methods which delete models in
+// bulk with a Lua script and therefore cannot enforce the ref option. method
+// is the name of the method, which is used in the error message.
+func (c *Collection) checkUnreferenced(method string) error {
+	if refs := c.referencingFields(); len(refs) > 0 {
+		return fmt.Errorf("zoom: %s is not supported for collection %s because it is referenced by %s.%s. Use Delete instead", method, c.Name(), refs[0].collection.Name(), refs[0].fs.name)
+	}
+	return nil
+}
+
+// deleteReferences enforces the ref option of all the fields which reference
+// the model in c with the given id, which is about to be deleted by the
+// transaction. It returns an error which wraps ErrReferenced if a field with
+// ondelete=restrict references the model, and otherwise adds commands to the
+// transaction which delete the referencing models (for ondelete=cascade, which
+// enforces the references to them in turn) or clear their fields (for
+// ondelete=setnull). The referencing models are found immediately using the
+// connection of the transaction, and the field indexes that were read are
+// watched, so that Exec returns a WatchConflictError if another client adds or
+// removes a reference before the transaction is executed. deleted contains
+// the keys of the models which are already being deleted, which ends cycles
+// of cascading deletes.
+func (t *Transaction) deleteReferences(c *Collection, id string, deleted map[string]bool) error {
+	for _, ref := range c.referencingFields() {
+		indexKey, err := ref.collection.spec.fieldIndexKey(ref.fs.name)
+		if err != nil {
+			return err
+		}
+		ids, err := t.findReferences(indexKey, id)
+		if err != nil {
+			return fmt.Errorf("zoom: could not find the models which reference %s with id = %s: %w", c.Name(), id, err)
+		}
+		for _, refID := range ids {
+			switch ref.fs.ref.onDelete {
+			case Restrict:
+				return newKindError(ErrReferenced, "zoom: cannot delete %s with id = %s because it is referenced by %s.%s of the model with id = %s", c.Name(), id, ref.collection.Name(), ref.fs.name, refID)
+			case Cascade:
+				if err := t.deleteModel(ref.collection, refID, nil, deleted); err != nil {
+					return err
+				}
+			case SetNull:
+				t.setNullReference(ref, indexKey, id, refID)
+			}
+		}
+	}
+	return nil
+}
+
+// findReferences watches the string index identified by indexKey and returns
+// the ids of the models whose value in the index is the given id. Like
+// setPrimaryKey, it reads from the database immediately.
+func (t *Transaction) findReferences(indexKey string, id string) ([]string, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.done {
+		return nil, errTransactionDone
+	}
+	if _, err := t.conn.Do("WATCH", indexKey); err != nil {
+		return nil, err
+	}
+	if !stringSliceContains(t.watching, indexKey) {
+		t.watching = append(t.watching, indexKey)
+	}
+	return redis.Strings(findReferencesScript.Do(t.conn, indexKey, id))
+}
+
+// setNullReference adds a script to the transaction which sets the field ref
+// of the model with the given refID to an empty string if it still holds the
+// given id, and updates the string index identified by indexKey accordingly.
+func (t *Transaction) setNullReference(ref referencingField, indexKey string, id string, refID string) {
+	key := ref.collection.ModelKey(refID)
+	t.modelScript(refID, setNullReferenceScript, redis.Args{key, ref.fs.redisName, indexKey, id, refID}, nil)
+	t.recordChange(ref.collection, refID, ChangeUpdate, []string{ref.fs.name})
+	t.publishEvent(ref.collection, ChangeUpdate, refID, redis.Args{key, ref.fs.redisName, ""})
+	t.invalidateCachedModel(ref.collection, refID)
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File references_test.go tests the code in references.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type refAuthor struct {
+	Name string
+	RandomID
+}
+
+type refPost struct {
+	Title    string
+	AuthorID string `zoom:"ref=refAuthor,ondelete=cascade"`
+	RandomID
+}
+
+type refComment struct {
+	Body     string
+	PostID   string `zoom:"ref=refPost,ondelete=setnull"`
+	ParentID string `zoom:"ref=refComment,ondelete=cascade"`
+	RandomID
+}
+
+type refBan struct {
+	AuthorID string `zoom:"ref=refAuthor"`
+	RandomID
+}
+
+func TestReferences(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	options := DefaultCollectionOptions.WithIndex(true)
+	authors, err := pool.NewCollectionWithOptions(&refAuthor{}, options)
+	require.NoError(t, err)
+	posts, err := pool.NewCollectionWithOptions(&refPost{}, options)
+	require.NoError(t, err)
+	comments, err := pool.NewCollectionWithOptions(&refComment{}, options)
+	require.NoError(t, err)
+	bans, err := pool.NewCollectionWithOptions(&refBan{}, options)
+	require.NoError(t, err)
+	exists := func(c *Collection, id string) bool {
+		found, err := c.Exists(id)
+		require.NoError(t, err)
+		return found
+	}
+
+	author := &refAuthor{Name: "alice"}
+	require.NoError(t, authors.Save(author))
+	post := &refPost{Title: "hello", AuthorID: author.ID}
+	require.NoError(t, posts.Save(post))
+	parent := &refComment{Body: "first", PostID: post.ID}
+	require.NoError(t, comments.Save(parent))
+	child := &refComment{Body: "reply", PostID: post.ID, ParentID: parent.ID}
+	require.NoError(t, comments.Save(child))
+	ban := &refBan{AuthorID: author.ID}
+	require.NoError(t, bans.Save(ban))
+
+	// Restrict should prevent the model from being deleted
+	deleted, err := authors.Delete(author.ID)
+	assert.True(t, errors.Is(err, ErrReferenced), "expected ErrReferenced but got %v", err)
+	assert.False(t, deleted)
+	assert.True(t, exists(authors, author.ID))
+	assert.True(t, exists(posts, post.ID))
+
+	// Bulk deletes cannot enforce references
+	_, err = authors.DeleteAll()
+	assert.Error(t, err)
+	_, err = authors.NewQuery().Delete()
+	assert.Error(t, err)
+	_, err = authors.DeleteWhere()
+	assert.Error(t, err)
+	assert.True(t, exists(authors, author.ID))
+
+	// Cascade should delete the referencing models, and SetNull should clear
+	// the field of the referencing models and update the index
+	_, err = bans.Delete(ban.ID)
+	require.NoError(t, err)
+	deleted, err = authors.Delete(author.ID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	assert.False(t, exists(authors, author.ID))
+	assert.False(t, exists(posts, post.ID))
+	found := &refComment{}
+	require.NoError(t, comments.Find(child.ID, found))
+	assert.Equal(t, "", found.PostID)
+	assert.Equal(t, parent.ID, found.ParentID)
+	count, err := comments.NewQuery().Filter("PostID =", "").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = comments.NewQuery().Filter("PostID =", post.ID).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Cascades should follow references to the referencing models and stop at
+	// cycles
+	grandchild := &refComment{Body: "reply to reply", ParentID: child.ID}
+	require.NoError(t, comments.Save(grandchild))
+	parent.ParentID = grandchild.ID
+	require.NoError(t, comments.Save(parent))
+	_, err = comments.Delete(child.ID)
+	require.NoError(t, err)
+	for _, id := range []string{parent.ID, child.ID, grandchild.ID} {
+		assert.False(t, exists(comments, id))
+	}
+	count, err = comments.NewQuery().Filter("ParentID >", "").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Adding a reference after it was checked should cause a conflict
+	author = &refAuthor{Name: "bob"}
+	require.NoError(t, authors.Save(author))
+	tx := pool.NewTransaction()
+	tx.Delete(authors, author.ID, nil)
+	require.NoError(t, bans.Save(&refBan{AuthorID: author.ID}))
+	err = tx.Exec()
+	assert.True(t, errors.As(err, &WatchConflictError{}), "expected WatchConflictError but got %v", err)
+	assert.True(t, exists(authors, author.ID))
+
+	// Invalid options should be rejected
+	type noRefModel struct {
+		AuthorID string `zoom:"ondelete=cascade"`
+		RandomID
+	}
+	type intRefModel struct {
+		AuthorID int `zoom:"ref=refAuthor"`
+		RandomID
+	}
+	type badOnDeleteModel struct {
+		AuthorID string `zoom:"ref=refAuthor,ondelete=ignore"`
+		RandomID
+	}
+	type emptyRefModel struct {
+		AuthorID string `zoom:"ref="`
+		RandomID
+	}
+	for _, model := range []Model{&noRefModel{}, &intRefModel{}, &badOnDeleteModel{}, &emptyRefModel{}} {
+		_, err := pool.NewCollection(model)
+		assert.Error(t, err, "expected an error when registering %T", model)
+	}
+	_, err = pool.NewCollectionWithOptions(&refComment{}, options.WithName("documentRefComment").WithLayout(DocumentLayout))
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File replication.go contains code for waiting until writes have been
+// acknowledged by replicas, using the WAIT command.
+
+package zoom
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// replicationChannel is the pub/sub channel used by WaitForReplication. PUBLISH
+// is replicated like a write, but does not change any keys.
+const replicationChannel = "zoom:replication"
+
+// WaitForReplication blocks until at least minReplicas replicas have
+// acknowledged every write the Redis server accepted before it was called
+// (including writes from other connections and processes), or until the
+// timeout expires, and returns the number of replicas which acknowledged the
+// writes. It is useful before reading from a replica something which was just
+// written, e.g. after a critical save. It returns an error which wraps
+// ErrNotReplicated if fewer than minReplicas replicas acknowledged the writes
+// in time. A timeout of 0 means WaitForReplication waits indefinitely. Use
+// Transaction.RequireAck instead to wait for the writes of a single
+// transaction.
+func (p *Pool) WaitForReplication(minReplicas int, timeout time.Duration) (int, error) {
+	if minReplicas < 0 {
+		return 0, fmt.Errorf("zoom: Error in WaitForReplication: minReplicas cannot be negative. Got: %d", minReplicas)
+	}
+	conn := p.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	// WAIT only waits for the writes made on the same connection. Since the
+	// replication stream is ordered, publishing a message first makes it wait
+	// for every earlier write as well.
+	if _, err := conn.Do("PUBLISH", replicationChannel, ""); err != nil {
+		return 0, fmt.Errorf("zoom: Error in WaitForReplication: %w", err)
+	}
+	return waitForReplicas(conn, minReplicas, timeout)
+}
+
+// RequireAck makes Exec wait until at least replicas replicas have
+// acknowledged the writes in the transaction, or until the timeout expires,
+// and returns the transaction. If fewer replicas acknowledged the writes in
+// time, Exec returns an error which wraps ErrNotReplicated. The writes have
+// still been committed on the primary in that case, so the error means that a
+// read from a replica (or a failover) might not see them yet, not that they
+// failed. A timeout of 0 means Exec waits indefinitely. Exec does not wait if
+// the transaction fails.
+func (t *Transaction) RequireAck(replicas int, timeout time.Duration) *Transaction {
+	if replicas < 0 {
+		t.setError(fmt.Errorf("zoom: Error in RequireAck: replicas cannot be negative. Got: %d", replicas))
+		return t
+	}
+	t.ackReplicas = replicas
+	t.ackTimeout = timeout
+	return t
+}
+
+// waitForAck waits until the writes in the transaction have been acknowledged
+// by the number of replicas given to RequireAck, if any. It uses the
+// connection of the transaction, which must still be open, since WAIT only
+// waits for the writes made on the same connection.
+func (t *Transaction) waitForAck() error {
+	if t.ackReplicas == 0 {
+		return nil
+	}
+	conn := t.conn
+	if tc, ok := conn.(*timeoutConn); ok {
+		// The reply to WAIT takes as long as ackTimeout, which is not limited
+		// by the timeout of the transaction.
+		conn = tc.Conn
+	}
+	_, err := waitForReplicas(conn, t.ackReplicas, t.ackTimeout)
+	return err
+}
+
+// waitForReplicas sends WAIT on conn and returns the number of replicas which
+// acknowledged the writes made on conn. It returns an error if there are fewer
+// than minReplicas.
+func waitForReplicas(conn redis.Conn, minReplicas int, timeout time.Duration) (int, error) {
+	acknowledged, err := redis.Int(conn.Do("WAIT", minReplicas, int64(timeout/time.Millisecond)))
+	if err != nil {
+		return 0, fmt.Errorf("zoom: error in WAIT: %w", err)
+	}
+	if acknowledged < minReplicas {
+		return acknowledged, newKindError(ErrNotReplicated, "zoom: only %d of %d replicas acknowledged the writes within %s", acknowledged, minReplicas, timeout)
+	}
+	return acknowledged, nil
+}
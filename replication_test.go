@@ -0,0 +1,70 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File replication_test.go tests the code in replication.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test server has no replicas, so WAIT always reports that 0 replicas
+// acknowledged the writes.
+
+func TestWaitForReplication(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	acknowledged, err := testPool.WaitForReplication(0, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 0, acknowledged)
+
+	acknowledged, err = testPool.WaitForReplication(1, 10*time.Millisecond)
+	assert.True(t, errors.Is(err, ErrNotReplicated), "expected ErrNotReplicated but got %v", err)
+	assert.Equal(t, 0, acknowledged)
+
+	_, err = testPool.WaitForReplication(-1, 0)
+	assert.Error(t, err)
+}
+
+func TestTransactionRequireAck(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := createTestModels(1)[0]
+	tx := testPool.NewTransaction().RequireAck(0, 10*time.Millisecond)
+	tx.Save(testModels, model)
+	require.NoError(t, tx.Exec())
+	expectModelExists(t, testModels, model)
+
+	// The writes are committed even if they were not acknowledged in time
+	other := createTestModels(1)[0]
+	tx = testPool.NewTransaction().Timeout(time.Second).RequireAck(1, 10*time.Millisecond)
+	clone := tx.Clone()
+	tx.Save(testModels, other)
+	err := tx.Exec()
+	assert.True(t, errors.Is(err, ErrNotReplicated), "expected ErrNotReplicated but got %v", err)
+	expectModelExists(t, testModels, other)
+
+	// Clone should keep the requirement
+	clone.Command("PING", nil, nil)
+	assert.True(t, errors.Is(clone.Exec(), ErrNotReplicated))
+
+	// Exec should not wait if the transaction fails
+	tx = testPool.NewTransaction().RequireAck(1, 10*time.Millisecond)
+	tx.Command("INCR", []interface{}{testModels.ModelKey(model.ID)}, nil)
+	err = tx.Exec()
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotReplicated))
+
+	tx = testPool.NewTransaction().RequireAck(-1, 0)
+	tx.Command("PING", nil, nil)
+	assert.Error(t, tx.Exec())
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File retry.go contains code related to retrying transactions which fail
+// because of transient errors.
+
+package zoom
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RetryPolicy controls how many times and how often Pool.WithRetry retries a
+// transaction.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the transaction will be
+	// executed, including the first attempt. A value less than 1 is treated as 1.
+	MaxAttempts int
+	// InitialBackoff is the amount of time to wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum amount of time to wait between attempts. A value
+	// of 0 means there is no maximum.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the backoff grows after each retry. A
+	// value less than 1 is treated as 1.
+	Multiplier float64
+	// Jitter is the fraction of the backoff which is randomized, between 0 and 1.
+	// For example, a Jitter of 0.2 means each backoff is randomly chosen within
+	// 20% of its nominal value. Jitter prevents many clients from retrying at
+	// exactly the same time.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the default policy for Pool.WithRetry.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// backoff returns the amount of time to wait after the given (1-based)
+// attempt has failed.
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(policy.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 && backoff > float64(policy.MaxBackoff) {
+		backoff = float64(policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 {
+		jitter := math.Min(policy.Jitter, 1)
+		backoff += backoff * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// WithRetry builds a new transaction by calling fn and then executes it. If
+// either fn or Exec returns a transient error (see IsRetryableError), WithRetry
+// waits according to policy and then tries again with a new transaction, up to
+// policy.MaxAttempts times. fn is called once for each attempt, so it should
+// not have any side effects other than adding actions to tx. If fn calls Watch
+// or WatchKey, a WatchConflictError causes the whole transaction, including any reads
+// done in fn, to be retried. This makes WithRetry a convenient way to
+// implement optimistic locking.
+//
+// If fn returns an error which is not retryable, WithRetry returns it
+// immediately without executing the transaction. Otherwise WithRetry returns
+// the error from the last attempt, if any. Note that a network error may occur
+// after Redis has executed the transaction, in which case the transaction will
+// be executed more than once. Only use WithRetry for transactions which are
+// safe to repeat.
+func (p *Pool) WithRetry(policy RetryPolicy, fn func(tx *Transaction) error) error {
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(policy.backoff(attempt - 1))
+		}
+		tx := p.NewTransaction()
+		if err = fn(tx); err != nil {
+			// Return the connection to the pool since Exec will not be called.
+			_ = tx.conn.Close()
+		} else {
+			err = tx.Exec()
+		}
+		if err == nil || !IsRetryableError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// retryableErrorPrefixes is the list of prefixes of error replies from Redis
+// which indicate a transient condition.
+var retryableErrorPrefixes = []string{
+	// Redis is loading the dataset into memory
+	"LOADING",
+	// The server is a read-only replica, e.g. during a failover
+	"READONLY",
+}
+
+// IsRetryableError returns true iff err is likely to be caused by a transient
+// condition, so that the same operation might succeed if it is tried again.
+// This includes WatchConflictErrors, network errors, an exhausted connection
+// pool, and the LOADING and READONLY error replies from Redis, even if they are
+// wrapped in another error.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var watchErr WatchConflictError
+	var netErr net.Error
+	var redisErr redis.Error
+	switch {
+	case errors.As(err, &watchErr), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &redisErr):
+		for _, prefix := range retryableErrorPrefixes {
+			if strings.HasPrefix(string(redisErr), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, redis.ErrPoolExhausted)
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File retry_test.go tests the code in retry.go
+
+package zoom
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	Multiplier:     2,
+}
+
+func TestWithRetryWatchError(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	attempts := 0
+	err := testPool.WithRetry(testRetryPolicy, func(tx *Transaction) error {
+		attempts++
+		if err := tx.WatchKey("counter"); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// Modify the watched key from a different connection to cause a
+			// WatchError.
+			if _, err := conn.Do("SET", "counter", 100); err != nil {
+				return err
+			}
+		}
+		tx.Command("INCR", redis.Args{"counter"}, nil)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	counter, err := redis.Int(conn.Do("GET", "counter"))
+	require.NoError(t, err)
+	assert.Equal(t, 101, counter)
+}
+
+func TestWithRetryErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Errors which are not retryable should be returned immediately
+	attempts := 0
+	expectedErr := errors.New("not retryable")
+	err := testPool.WithRetry(testRetryPolicy, func(tx *Transaction) error {
+		attempts++
+		return expectedErr
+	})
+	assert.Equal(t, expectedErr, err)
+	assert.Equal(t, 1, attempts)
+
+	// Retryable errors should be retried up to MaxAttempts times
+	attempts = 0
+	err = testPool.WithRetry(testRetryPolicy, func(tx *Transaction) error {
+		attempts++
+		return redis.Error("LOADING Redis is loading the dataset in memory")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, testRetryPolicy.MaxAttempts, attempts)
+}
+
+func TestIsRetryableError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{WatchError{}, true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, true},
+		{io.EOF, true},
+		{redis.ErrPoolExhausted, true},
+		{redis.Error("READONLY You can't write against a read only replica."), true},
+		{redis.Error("ERR value is not an integer or out of range"), false},
+		{ModelNotFoundError{}, false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, IsRetryableError(tc.err), "Wrong result for error: %v", tc.err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		Multiplier:     2,
+	}
+	assert.Equal(t, 10*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(4))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		backoff := policy.backoff(1)
+		assert.True(t, backoff >= 5*time.Millisecond && backoff <= 15*time.Millisecond, "Backoff out of range: %s", backoff)
+	}
+}
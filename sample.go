@@ -0,0 +1,114 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File sample.go contains code for reading random samples of models, e.g. for
+// sampling, A/B assignment, or warming a cache.
+
+package zoom
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Random finds n models of the collection at random and scans their values
+// into models, which must be a pointer to a slice of models with a type
+// corresponding to the Collection. The models are distinct, so if there are
+// fewer than n models in the collection, all of them are returned. The ids are
+// picked from the index of all ids with SRANDMEMBER (or at random ranks if the
+// collection has a sorted index), and the models are read in the same
+// transaction, so Random only needs a single round trip. The default scope of
+// the collection is not applied. To pick random models which match some
+// criteria, use Query.Sample. Random only works for indexed collections.
+func (c *Collection) Random(n int, models interface{}) error {
+	t := c.pool.NewTransaction()
+	t.Random(c, n, models)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Random finds n models of the collection at random and scans their values
+// into models in an existing transaction. See Collection.Random for more
+// information. Any errors encountered will be added to the transaction and
+// returned as an error when the transaction is executed.
+func (t *Transaction) Random(c *Collection, n int, models interface{}) {
+	if c == nil {
+		t.setError(newNilCollectionError("Random"))
+		return
+	}
+	if !c.index {
+		t.setError(newUnindexedCollectionError("Random"))
+		return
+	}
+	if n < 0 {
+		t.setError(fmt.Errorf("zoom: Error in Random or Transaction.Random: n cannot be negative but got %d", n))
+		return
+	}
+	if err := c.checkModelsType(models); err != nil {
+		t.setError(fmt.Errorf("zoom: Error in Random or Transaction.Random: %w", err))
+		return
+	}
+	fieldNames := c.spec.defaultFieldNames()
+	redisNames := make([]string, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		redisNames[i] = c.spec.fieldsByName[fieldName].redisName
+	}
+	sampleKey := t.newTmpKey("tmp:random")
+	t.sampleIDs(c.spec.indexKey(), sampleKey, uint(n))
+	sortArgs := c.spec.sortArgs(sampleKey, redisNames, 0, 0, false)
+	fieldNames = append(fieldNames, "-")
+	t.sortModelsWithKeyFields(c.spec, sortArgs, redisNames, c.spec.keyFields, modelsIndexer(models), newScanModelsHandler(c.spec, fieldNames, models))
+	if c.strictScan {
+		t.checkHashFieldsForSort(c, sampleKey, 0, 0, false)
+	}
+	t.Command("DEL", redis.Args{sampleKey}, nil)
+}
+
+// Sample restricts the query to n of the models which match it, picked at
+// random. For example, Filter("Plan =", "free").Sample(100) would return 100
+// random models on the free plan. The models are distinct, so if fewer than n
+// models match the query, all of them are returned. The sample is taken
+// server-side after every other modifier which restricts the models (including
+// Last), and Limit and Offset, if any, are applied to the sample. The sampled
+// models keep the order of the query, so add an Order if the order matters;
+// otherwise it is unspecified. If n is 0, Sample has no effect.
+func (q *Query) Sample(n uint) *Query {
+	q.query.Sample(n)
+	return q
+}
+
+// Sample works exactly like Query.Sample. See the documentation for
+// Query.Sample for more information.
+func (q *TransactionQuery) Sample(n uint) *TransactionQuery {
+	q.query.Sample(n)
+	return q
+}
+
+// Sample is like Query.Sample.
+func (q *TypedQuery[T, PT]) Sample(n uint) *TypedQuery[T, PT] {
+	q.query.Sample(n)
+	return q
+}
+
+// Sample sets the number of models to pick at random. See Query.Sample.
+func (q *query) Sample(n uint) {
+	q.sample = n
+}
+
+// hasSample returns true iff Sample was applied to the query.
+func (q *query) hasSample() bool {
+	return q.sample != 0
+}
+
+// sampleIDs is a small function wrapper around a Lua script. The script will
+// pick n distinct ids at random from the set or sorted set identified by setKey
+// and store them in a sorted set identified by destKey, preserving their scores
+// if setKey is a sorted set.
+func (t *Transaction) sampleIDs(setKey, destKey string, n uint) {
+	t.Script(sampleIdsScript, redis.Args{setKey, destKey, n, rand.Int31()}, nil)
+}
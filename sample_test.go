@@ -0,0 +1,104 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File sample_test.go tests the code in sample.go
+
+package zoom
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandom(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(10)
+	require.NoError(t, err)
+	byID := map[string]*indexedTestModel{}
+	for _, model := range models {
+		byID[model.ID] = model
+	}
+
+	// The models should be distinct and fully loaded
+	got := []*indexedTestModel{}
+	require.NoError(t, indexedTestModels.Random(4, &got))
+	require.Len(t, got, 4)
+	seen := map[string]bool{}
+	for _, model := range got {
+		assert.False(t, seen[model.ID], "model %s was returned twice", model.ID)
+		seen[model.ID] = true
+		assert.Equal(t, byID[model.ID], model)
+	}
+
+	// If n is greater than the number of models, all of them are returned
+	require.NoError(t, indexedTestModels.Random(20, &got))
+	assert.ElementsMatch(t, models, got)
+	require.NoError(t, indexedTestModels.Random(0, &got))
+	assert.Empty(t, got)
+	assert.Error(t, indexedTestModels.Random(-1, &got))
+	assert.Error(t, indexedTestModels.Random(1, &[]*testModel{}))
+
+	// The same should be true for a sorted index
+	col := newSortedIndexCollection(t, "sampleSortedIndexModel", DefaultCollectionOptions.WithSortedIndex(true))
+	sorted := saveSortedIndexModels(t, col, 5)
+	gotSorted := []*sortedIndexModel{}
+	require.NoError(t, col.Random(3, &gotSorted))
+	require.Len(t, gotSorted, 3)
+	assert.Subset(t, sorted, gotSorted)
+	require.NoError(t, col.Random(5, &gotSorted))
+	assert.ElementsMatch(t, sorted, gotSorted)
+}
+
+func TestQuerySample(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models := createIndexedTestModels(20)
+	tx := testPool.NewTransaction()
+	for i, model := range models {
+		model.Int = i
+		tx.Save(indexedTestModels, model)
+	}
+	require.NoError(t, tx.Exec())
+	matching := models[10:]
+
+	// The sampled models should match the query and keep its order
+	got := []*indexedTestModel{}
+	require.NoError(t, indexedTestModels.NewQuery().Filter("Int >=", 10).Order("Int").Sample(3).Run(&got))
+	require.Len(t, got, 3)
+	assert.Subset(t, matching, got)
+	for i := 1; i < len(got); i++ {
+		assert.True(t, got[i-1].Int <= got[i].Int, "expected the models to be ordered by Int")
+	}
+
+	// Count, Limit, and Last should work with Sample
+	count, err := indexedTestModels.NewQuery().Sample(5).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	count, err = indexedTestModels.NewQuery().Filter("Int >=", 10).Sample(100).Count()
+	require.NoError(t, err)
+	assert.Equal(t, len(matching), count)
+	require.NoError(t, indexedTestModels.NewQuery().Sample(5).Limit(2).Run(&got))
+	assert.Len(t, got, 2)
+	ids, err := indexedTestModels.NewQuery().Order("Int").Last(4).Sample(2).IDs()
+	require.NoError(t, err)
+	lastIDs, err := indexedTestModels.NewQuery().Order("Int").Last(4).IDs()
+	require.NoError(t, err)
+	assert.Len(t, ids, 2)
+	assert.Subset(t, lastIDs, ids)
+
+	// The sample should be included in the string and JSON representations
+	q := indexedTestModels.NewQuery().Filter("Int >=", 10).Sample(3)
+	assert.Equal(t, `indexedTestModel.NewQuery().Filter("Int >=", 10).Sample(3)`, q.String())
+	data, err := json.Marshal(q)
+	require.NoError(t, err)
+	unmarshaled, err := UnmarshalQuery(indexedTestModels, data)
+	require.NoError(t, err)
+	assert.Equal(t, q.String(), unmarshaled.String())
+}
@@ -0,0 +1,340 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File schema.go contains code related to the schema registry, which stores
+// the fields of each collection in Redis so that changes to the model types
+// between deployments can be detected.
+
+package zoom
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Schema describes the fields of a collection, as compiled from the model type
+// when the collection was registered.
+type Schema struct {
+	// Name is the name of the collection.
+	Name string `json:"name"`
+	// Type is the name of the model type, e.g. "*models.Person".
+	Type string `json:"type"`
+	// Fields are the fields of the model type, including the fields which are
+	// stored in their own key.
+	Fields []SchemaField `json:"fields"`
+	// IndexNamespace is true if the keys of the field indexes include the index
+	// namespace (see CollectionOptions.IndexNamespace).
+	IndexNamespace bool `json:"indexNamespace,omitempty"`
+	// Layout is "document" if the models are stored as a single document (see
+	// CollectionOptions.Layout), or an empty string for the hash layout.
+	Layout string `json:"layout,omitempty"`
+}
+
+// IndexKey returns the key of the index on the field with the given name in
+// Redis. Other keys which belong to the index (e.g. the null index of a
+// pointer field) start with the same key.
+func (s Schema) IndexKey(redisName string) string {
+	if s.IndexNamespace {
+		return s.Name + ":index:" + redisName
+	}
+	return s.Name + ":" + redisName
+}
+
+// SchemaField describes a single field of a collection.
+type SchemaField struct {
+	// Name is the name of the field in the Go type.
+	Name string `json:"name"`
+	// RedisName is the name of the field as it is stored in Redis.
+	RedisName string `json:"redisName"`
+	// Type is the Go type of the field, e.g. "int" or "*string".
+	Type string `json:"type"`
+	// Index is the kind of index on the field ("numeric", "boolean", "string",
+	// "integer", "collated", or "enum"), or an empty string if the field is not
+	// indexed. "collated" is a string index with a collation (see
+	// CollectionOptions.Collations).
+	Index string `json:"index,omitempty"`
+	// Enum is the list of allowed values for an enum field, in the order which
+	// determines how they are stored.
+	Enum []string `json:"enum,omitempty"`
+	// Storage is "hash", "list", or "set" for fields which are stored in their
+	// own key, or an empty string for fields stored in the main hash.
+	Storage string `json:"storage,omitempty"`
+	// Computed is true if the value of the field is returned by a method of the
+	// model type (see ComputedIndexPrefix) instead of stored in a struct field.
+	Computed bool `json:"computed,omitempty"`
+}
+
+// SchemaDrift describes a single difference between the schema of a collection
+// stored in Redis by a previous registration and the current model type.
+type SchemaDrift struct {
+	// Collection is the name of the collection.
+	Collection string
+	// Field is the name of the field as it is stored in Redis, or an empty
+	// string if the difference concerns the whole collection.
+	Field string
+	// Message describes the difference, e.g. "field was removed".
+	Message string
+}
+
+// String returns a description of the drift suitable for logging.
+func (d SchemaDrift) String() string {
+	if d.Field == "" {
+		return fmt.Sprintf("zoom: schema drift in %s: %s", d.Collection, d.Message)
+	}
+	return fmt.Sprintf("zoom: schema drift in %s.%s: %s", d.Collection, d.Field, d.Message)
+}
+
+// schemaKey returns the key where the schema for the collection with the
+// given name is stored.
+func schemaKey(name string) string {
+	return "zoom:schema:" + name
+}
+
+// schema returns the Schema for ms.
+func (ms *modelSpec) schema() Schema {
+	schema := Schema{
+		Name:           ms.name,
+		Type:           ms.typ.String(),
+		Fields:         []SchemaField{},
+		IndexNamespace: ms.indexNamespace,
+	}
+	if ms.document {
+		schema.Layout = ms.layout().String()
+	}
+	for _, fs := range append(ms.fieldsWithComputed(), ms.keyFields...) {
+		field := SchemaField{
+			Name:      fs.name,
+			RedisName: fs.redisName,
+			Type:      fs.typ.String(),
+			Computed:  fs.method != "",
+		}
+		if fs.indexKind != noIndex {
+			field.Index = fs.indexKindName()
+		}
+		field.Enum = fs.enum
+		switch fs.kind {
+		case hashField:
+			field.Storage = "hash"
+		case listField:
+			field.Storage = "list"
+		case setField:
+			field.Storage = "set"
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+	return schema
+}
+
+// storeSchema stores the schema for ms in Redis. If overwrite is false, the
+// schema is only stored if there is not already a schema for the collection.
+func (p *Pool) storeSchema(ms *modelSpec, overwrite bool) error {
+	data, err := json.Marshal(ms.schema())
+	if err != nil {
+		return err
+	}
+	conn := p.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	args := redis.Args{schemaKey(ms.name), data}
+	if !overwrite {
+		args = args.Add("NX")
+	}
+	_, err = conn.Do("SET", args...)
+	return err
+}
+
+// StoredSchema returns the schema for the collection with the given name which
+// is stored in Redis, and false if there is none.
+func (p *Pool) StoredSchema(name string) (Schema, bool, error) {
+	conn := p.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	data, err := redis.Bytes(conn.Do("GET", schemaKey(name)))
+	if err == redis.ErrNil {
+		return Schema{}, false, nil
+	} else if err != nil {
+		return Schema{}, false, fmt.Errorf("zoom: error in StoredSchema: %w", err)
+	}
+	schema := Schema{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return Schema{}, false, fmt.Errorf("zoom: error in StoredSchema: invalid schema for %s: %w", name, err)
+	}
+	return schema, true, nil
+}
+
+// CheckSchemaDrift compares the model type of each collection registered with
+// the pool to the schema stored in Redis by the first registration of the
+// collection (usually by a previous deployment) and returns the differences.
+// Removed fields, added fields, and changes to the type, index, or storage of a
+// field are reported. A renamed field is reported as a removed field and an
+// added field, which is usually a sign that existing data can no longer be
+// read. Collections which do not have a stored schema yet (e.g. because Redis
+// could not be reached when they were registered) have their schema stored
+// instead. Once you have migrated your data, call SaveSchemas to replace the
+// stored schemas with the current ones.
+func (p *Pool) CheckSchemaDrift() ([]SchemaDrift, error) {
+	drifts := []SchemaDrift{}
+	for _, ms := range p.registeredSpecs() {
+		stored, found, err := p.StoredSchema(ms.name)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if err := p.storeSchema(ms, false); err != nil {
+				return nil, fmt.Errorf("zoom: error in CheckSchemaDrift: %w", err)
+			}
+			continue
+		}
+		drifts = append(drifts, compareSchemas(stored, ms.schema())...)
+	}
+	return drifts, nil
+}
+
+// SaveSchemas stores the schema of each collection registered with the pool in
+// Redis, replacing any existing schemas. It should be called after you have
+// intentionally changed a model type and migrated the existing data, so that
+// CheckSchemaDrift no longer reports the changes.
+func (p *Pool) SaveSchemas() error {
+	for _, ms := range p.registeredSpecs() {
+		if err := p.storeSchema(ms, true); err != nil {
+			return fmt.Errorf("zoom: error in SaveSchemas: %w", err)
+		}
+	}
+	return nil
+}
+
+// registeredSpecs returns the specs of the collections registered with p,
+// sorted by name.
+func (p *Pool) registeredSpecs() []*modelSpec {
+	p.registryMut.RLock()
+	defer p.registryMut.RUnlock()
+	names := []string{}
+	for name := range p.modelNameToSpec {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	specs := make([]*modelSpec, len(names))
+	for i, name := range names {
+		specs[i] = p.modelNameToSpec[name]
+	}
+	return specs
+}
+
+// compareSchemas returns the differences between the stored and current
+// schemas for a collection. Fields are matched by their name in Redis, since
+// that is what determines whether existing data can be read.
+func compareSchemas(stored Schema, current Schema) []SchemaDrift {
+	drifts := []SchemaDrift{}
+	if current.IndexNamespace != stored.IndexNamespace {
+		drifts = append(drifts, SchemaDrift{
+			Collection: current.Name,
+			Message:    fmt.Sprintf("index namespace changed from %t to %t (see Collection.MigrateIndexNamespace)", stored.IndexNamespace, current.IndexNamespace),
+		})
+	}
+	if current.Layout != stored.Layout {
+		drifts = append(drifts, SchemaDrift{
+			Collection: current.Name,
+			Message:    fmt.Sprintf("layout changed from %s to %s", describeSchemaLayout(stored.Layout), describeSchemaLayout(current.Layout)),
+		})
+	}
+	currentFields := map[string]SchemaField{}
+	for _, field := range current.Fields {
+		currentFields[field.RedisName] = field
+	}
+	storedFields := map[string]SchemaField{}
+	for _, old := range stored.Fields {
+		storedFields[old.RedisName] = old
+		field, found := currentFields[old.RedisName]
+		if !found {
+			drifts = append(drifts, SchemaDrift{
+				Collection: current.Name,
+				Field:      old.RedisName,
+				Message:    fmt.Sprintf("field was removed (it had type %s)", old.Type),
+			})
+			continue
+		}
+		if field.Type != old.Type {
+			drifts = append(drifts, SchemaDrift{
+				Collection: current.Name,
+				Field:      old.RedisName,
+				Message:    fmt.Sprintf("type changed from %s to %s", old.Type, field.Type),
+			})
+		}
+		if field.Index != old.Index {
+			drifts = append(drifts, SchemaDrift{
+				Collection: current.Name,
+				Field:      old.RedisName,
+				Message:    fmt.Sprintf("index changed from %s to %s", describeSchemaValue(old.Index), describeSchemaValue(field.Index)),
+			})
+		}
+		if !enumValuesCompatible(old.Enum, field.Enum) {
+			drifts = append(drifts, SchemaDrift{
+				Collection: current.Name,
+				Field:      old.RedisName,
+				Message:    fmt.Sprintf("enum values changed from %s to %s", describeSchemaValue(strings.Join(old.Enum, "|")), describeSchemaValue(strings.Join(field.Enum, "|"))),
+			})
+		}
+		if field.Computed != old.Computed {
+			drifts = append(drifts, SchemaDrift{
+				Collection: current.Name,
+				Field:      old.RedisName,
+				Message:    fmt.Sprintf("computed changed from %t to %t", old.Computed, field.Computed),
+			})
+		}
+		if field.Storage != old.Storage {
+			drifts = append(drifts, SchemaDrift{
+				Collection: current.Name,
+				Field:      old.RedisName,
+				Message:    fmt.Sprintf("storage changed from %s to %s", describeSchemaValue(old.Storage), describeSchemaValue(field.Storage)),
+			})
+		}
+	}
+	for _, field := range current.Fields {
+		if _, found := storedFields[field.RedisName]; !found {
+			drifts = append(drifts, SchemaDrift{
+				Collection: current.Name,
+				Field:      field.RedisName,
+				Message:    fmt.Sprintf("field was added (it has type %s)", field.Type),
+			})
+		}
+	}
+	return drifts
+}
+
+// describeSchemaLayout returns layout, or "hash" if it is empty.
+func describeSchemaLayout(layout string) string {
+	if layout == "" {
+		return HashLayout.String()
+	}
+	return layout
+}
+
+// describeSchemaValue returns value, or "none" if it is empty.
+func describeSchemaValue(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// enumValuesCompatible returns true iff models saved with the enum values old
+// can be read with the enum values current. Since enum values are stored as
+// their position in the list, values can only be added to the end.
+func enumValuesCompatible(old []string, current []string) bool {
+	if len(current) < len(old) {
+		return false
+	}
+	for i, value := range old {
+		if current[i] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File schema_test.go tests the code in schema.go
+
+package zoom
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSchemaDrift(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// The first check should store the schemas which are missing (the test
+	// database is flushed after the collections are registered) and report no
+	// drift.
+	drifts, err := testPool.CheckSchemaDrift()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+	stored, found, err := testPool.StoredSchema(indexedTestModels.Name())
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, indexedTestModels.spec.schema(), stored)
+
+	// Simulate a previous deployment with a different model type
+	previous := Schema{
+		Name: indexedTestModels.Name(),
+		Type: stored.Type,
+		Fields: []SchemaField{
+			{Name: "Count", RedisName: "Count", Type: "int", Index: "numeric"},
+			{Name: "String", RedisName: "String", Type: "int", Index: "numeric"},
+			{Name: "Bool", RedisName: "Bool", Type: "bool"},
+		},
+	}
+	data, err := json.Marshal(previous)
+	require.NoError(t, err)
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("SET", schemaKey(indexedTestModels.Name()), data)
+	require.NoError(t, err)
+	drifts, err = testPool.CheckSchemaDrift()
+	require.NoError(t, err)
+	expected := []SchemaDrift{
+		{Collection: "indexedTestModel", Field: "Count", Message: "field was removed (it had type int)"},
+		{Collection: "indexedTestModel", Field: "String", Message: "type changed from int to string"},
+		{Collection: "indexedTestModel", Field: "String", Message: "index changed from numeric to string"},
+		{Collection: "indexedTestModel", Field: "Bool", Message: "index changed from none to boolean"},
+		{Collection: "indexedTestModel", Field: "Int", Message: "field was added (it has type int)"},
+	}
+	assert.Equal(t, expected, drifts)
+	assert.Equal(t, "zoom: schema drift in indexedTestModel.Count: field was removed (it had type int)", drifts[0].String())
+
+	// After saving the schemas, there should be no more drift
+	require.NoError(t, testPool.SaveSchemas())
+	drifts, err = testPool.CheckSchemaDrift()
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestSchemaStoredOnRegistration(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type schemaModel struct {
+		Name  string   `zoom:"index"`
+		Tags  []string `zoom:"set"`
+		Count *int
+		RandomID
+	}
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	collection, err := pool.NewCollection(&schemaModel{})
+	require.NoError(t, err)
+	stored, found, err := pool.StoredSchema(collection.Name())
+	require.NoError(t, err)
+	require.True(t, found)
+	expected := Schema{
+		Name: "schemaModel",
+		Type: "*zoom.schemaModel",
+		Fields: []SchemaField{
+			{Name: "Name", RedisName: "Name", Type: "string", Index: "string"},
+			{Name: "Count", RedisName: "Count", Type: "*int"},
+			{Name: "Tags", RedisName: "Tags", Type: "[]string", Storage: "set"},
+		},
+	}
+	assert.Equal(t, expected, stored)
+
+	// Registering the collection again (e.g. in a new deployment) should not
+	// replace the stored schema.
+	type otherSchemaModel struct {
+		Name int
+		RandomID
+	}
+	otherPool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = otherPool.Close()
+	}()
+	_, err = otherPool.NewCollectionWithOptions(&otherSchemaModel{}, DefaultCollectionOptions.WithName("schemaModel"))
+	require.NoError(t, err)
+	stored, _, err = otherPool.StoredSchema("schemaModel")
+	require.NoError(t, err)
+	assert.Equal(t, expected, stored)
+	drifts, err := otherPool.CheckSchemaDrift()
+	require.NoError(t, err)
+	assert.Len(t, drifts, 4)
+}
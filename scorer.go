@@ -0,0 +1,49 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File scorer.go contains code for indexing fields of custom types which
+// implement the Scorer interface.
+
+package zoom
+
+import "reflect"
+
+// Scorer is implemented by types which are not primitives but can still be
+// indexed and ordered by a number, e.g. money amounts, semantic versions, or
+// time-like types. A field of a type which implements Scorer (with a value or
+// pointer receiver), or of a pointer to such a type, can have the
+// `zoom:"index"` struct tag. The field is stored in the main hash in the same
+// way as any other field which is not a primitive, and the value returned by
+// IndexScore is stored in a numeric index, so the field can be used with
+// Filter, FilterRange, and Order. The values given to Filter must have the
+// same type as the field and are compared by their scores. IndexScore must
+// return the same score for values which should be considered equal, and must
+// preserve the order of the values.
+type Scorer interface {
+	IndexScore() float64
+}
+
+var scorerType = reflect.TypeOf((*Scorer)(nil)).Elem()
+
+// typeIsScorer returns true iff typ (or the type it points to) implements
+// Scorer with a value or pointer receiver.
+func typeIsScorer(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return reflect.PtrTo(typ).Implements(scorerType)
+}
+
+// scorerScore returns the score of val, which must not be a pointer, if its
+// type implements Scorer. ok is false otherwise.
+func scorerScore(val reflect.Value) (score float64, ok bool) {
+	if !typeIsScorer(val.Type()) {
+		return 0, false
+	}
+	// Copy the value so that IndexScore can be called even if it has a pointer
+	// receiver and val is not addressable.
+	ptr := reflect.New(val.Type())
+	ptr.Elem().Set(val)
+	return ptr.Interface().(Scorer).IndexScore(), true
+}
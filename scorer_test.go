@@ -0,0 +1,96 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File scorer_test.go tests the code in scorer.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scorerTestVersion is a semantic version which implements Scorer with a
+// pointer receiver.
+type scorerTestVersion struct {
+	Major, Minor, Patch int
+}
+
+func (v *scorerTestVersion) IndexScore() float64 {
+	return float64(v.Major*1000000 + v.Minor*1000 + v.Patch)
+}
+
+type scorerTestModel struct {
+	Version scorerTestVersion  `zoom:"index"`
+	Minimum *scorerTestVersion `zoom:"index"`
+	RandomID
+}
+
+func TestScorerIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&scorerTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+
+	models := []*scorerTestModel{
+		{Version: scorerTestVersion{1, 10, 0}, Minimum: &scorerTestVersion{1, 0, 0}},
+		{Version: scorerTestVersion{1, 2, 3}},
+		{Version: scorerTestVersion{0, 9, 9}, Minimum: &scorerTestVersion{0, 1, 0}},
+		{Version: scorerTestVersion{2, 0, 0}},
+	}
+	tx := pool.NewTransaction()
+	for _, model := range models {
+		tx.Save(col, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	// Order should use the scores rather than the encoded values
+	got := []*scorerTestModel{}
+	require.NoError(t, col.NewQuery().Order("Version").Run(&got))
+	assert.Equal(t, []*scorerTestModel{models[2], models[1], models[0], models[3]}, got)
+
+	testCases := []struct {
+		query    *Query
+		expected []*scorerTestModel
+	}{
+		{col.NewQuery().Filter("Version >", scorerTestVersion{1, 2, 3}), []*scorerTestModel{models[0], models[3]}},
+		{col.NewQuery().Filter("Version >=", scorerTestVersion{1, 2, 3}), []*scorerTestModel{models[1], models[0], models[3]}},
+		{col.NewQuery().Filter("Version =", scorerTestVersion{0, 9, 9}), []*scorerTestModel{models[2]}},
+		{col.NewQuery().Filter("Version !=", scorerTestVersion{0, 9, 9}), []*scorerTestModel{models[1], models[0], models[3]}},
+		{col.NewQuery().FilterRange("Version", scorerTestVersion{1, 0, 0}, scorerTestVersion{2, 0, 0}, false), []*scorerTestModel{models[1], models[0]}},
+		// Models for which the pointer field is nil are not in the index
+		{col.NewQuery().Filter("Minimum >=", scorerTestVersion{0, 0, 0}), []*scorerTestModel{models[2], models[0]}},
+	}
+	for i, tc := range testCases {
+		got := []*scorerTestModel{}
+		require.NoError(t, tc.query.Order("Version").Run(&got), "test case %d", i)
+		assert.Equal(t, tc.expected, got, "test case %d (%s)", i, tc.query)
+		count, err := tc.query.Count()
+		require.NoError(t, err)
+		assert.Equal(t, len(tc.expected), count, "test case %d (%s)", i, tc.query)
+	}
+
+	// The index should be updated when the value changes
+	models[3].Version = scorerTestVersion{0, 0, 1}
+	require.NoError(t, col.Save(models[3]))
+	first := &scorerTestModel{}
+	require.NoError(t, col.NewQuery().Order("Version").First(first))
+	assert.Equal(t, models[3], first)
+
+	// Types which are not primitives and do not implement Scorer still cannot
+	// be indexed
+	type unscoredModel struct {
+		Version struct{ Major int } `zoom:"index"`
+		RandomID
+	}
+	_, err = pool.NewCollection(&unscoredModel{})
+	assert.Error(t, err)
+}
@@ -16,24 +16,358 @@ import (
 
 var (
 	
+	addToIndexScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- add_to_index is a lua script that takes the following arguments:
+-- 	1) The key of the index of all models in a collection
+-- 	2) The id of a model
+-- 	3) The score of the model in a sorted index, i.e. the current time
+-- 	4) "1" if the index should be created as a sorted set if it does not exist
+--			yet, and "0" if it should be created as a set
+-- The script adds the id to the index with SADD if the index is a set, or with
+-- ZADD NX (so that the score of a model which was already saved is not changed)
+-- if it is a sorted set. Since the type of the index is checked every time, the
+-- index can be migrated to a sorted set at any time (see
+-- Collection.MigrateToSortedIndex).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local indexKey = ARGV[1]
+local id = ARGV[2]
+local score = ARGV[3]
+local sortedIndex = ARGV[4] == '1'
+local indexType = redis.call('TYPE', indexKey)['ok']
+if indexType == 'zset' or (indexType == 'none' and sortedIndex) then
+	return redis.call('ZADD', indexKey, 'NX', score, id)
+end
+return redis.call('SADD', indexKey, id)
+`)
+	claimOneScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- claim_one is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+-- 	2) The key of a list of candidate model ids, in order
+-- 	3) The token which identifies the new lease
+-- 	4) The duration of the lease in milliseconds
+-- 	5+) The Redis names of the fields to read
+-- The script finds the first id in the list for which the model still exists
+-- and which is not already leased, and leases it by setting its lock key to
+-- the token with the given time to live. It returns the values of the fields
+-- followed by the id of the model, or an empty array if no model could be
+-- claimed.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local modelName = ARGV[1]
+local listKey = ARGV[2]
+local token = ARGV[3]
+local leaseMillis = ARGV[4]
+local fields = {}
+for i = 5, #ARGV do
+	table.insert(fields, ARGV[i])
+end
+local ids = redis.call('LRANGE', listKey, 0, -1)
+for _, id in ipairs(ids) do
+	local modelKey = modelName .. ':' .. id
+	if redis.call('EXISTS', modelKey) == 1 then
+		local lockKey = modelName .. ':lock:' .. id
+		if redis.call('SET', lockKey, token, 'NX', 'PX', leaseMillis) then
+			local result = {}
+			if #fields > 0 then
+				result = redis.call('HMGET', modelKey, unpack(fields))
+			end
+			table.insert(result, id)
+			return result
+		end
+	end
+end
+return {}
+`)
+	countIndexScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- count_index is a lua script that takes the following arguments:
+-- 	1) The key of the index of all models in a collection
+-- The script returns the number of ids in the index with SCARD or ZCARD,
+-- depending on whether the index is a set or a sorted set (see
+-- add_to_index.lua).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local indexKey = ARGV[1]
+if redis.call('TYPE', indexKey)['ok'] == 'zset' then
+	return redis.call('ZCARD', indexKey)
+end
+return redis.call('SCARD', indexKey)
+`)
+	deleteModelsByIdsListScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- delete_models_by_ids_list is a lua script that takes the following arguments:
+-- 	1) The key of a list, set, or sorted set of model ids
+--		2) The name of a registered model
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+--		4) The field of the model hash in which the document is stored if the
+--			collection uses the document layout, "$" if it uses the JSON layout, or
+--			an empty string
+-- 	5) Zero or more pairs of arguments, one pair for each indexed field, field
+--			stored in its own key, or field with a full-text index, where the first
+--			argument is the name of the field as it is stored in Redis and the second
+--			is the kind of index ("numeric", "boolean", "string", "integer",
+--			"collated", "enum", "fulltext", "bitmap", or "null" for the null index
+--			of a pointer field) or "key" for fields stored in their own key. Fields
+--			with a bitmap index have a second pair with the kind "bitmap".
+-- The script then deletes all the models corresponding to the ids in the given
+-- list (or set), including their field indexes, the keys for any fields stored
+-- in their own key, their bits and offsets in the bitmap indexes, and their
+-- entries in the set of all ids and the eviction set of the collection (if
+-- any). It returns the number of models that were deleted. It does not delete
+-- the given list (or set).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local listKey = ARGV[1]
+local collectionName = ARGV[2]
+local indexPrefix = ARGV[3]
+local documentField = ARGV[4]
+local allKey = collectionName .. ':all'
+-- The hashes which map the models to their offsets in the bitmap indexes and
+-- back. See update_bitmap_index.lua.
+local bitmapOffsetsKey = collectionName .. ':bitmap:offsets'
+local bitmapIDsKey = collectionName .. ':bitmap:ids'
+local allIsSorted = redis.call('TYPE', allKey)['ok'] == 'zset'
+-- The sorted set used by the eviction policy of the collection, if any
+local evictionKey = collectionName .. ':eviction'
+local evictable = redis.call('TYPE', evictionKey)['ok'] == 'zset'
+
+-- base64Chars are the characters used by the standard base64 encoding, in order.
+local base64Chars = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/'
+
+-- decodeBase64 decodes a value which is stored in a document as base64 because
+-- it is not valid UTF-8. See encodeDocument in layout.go.
+local function decodeBase64(data)
+	data = string.gsub(data, '[^%w%+/]', '')
+	local bytes = {}
+	local bits, count = 0, 0
+	for i = 1, #data do
+		bits = bits * 64 + string.find(base64Chars, string.sub(data, i, i), 1, true) - 1
+		count = count + 6
+		if count >= 8 then
+			count = count - 8
+			local byte = math.floor(bits / 2 ^ count)
+			bits = bits - byte * 2 ^ count
+			table.insert(bytes, string.char(byte))
+		end
+	end
+	return table.concat(bytes)
+end
+
+-- getField returns the value of a field of the model with the given key as it
+-- is stored in the model hash, or false if there is none. If documentField is
+-- not empty, the model uses the document layout and the value is read from the
+-- document stored in that field instead, or if it is "$", the model uses the
+-- JSON layout and the value is read from the RedisJSON document.
+local function getField(key, fieldName)
+	if documentField == '' then
+		return redis.call('HGET', key, fieldName)
+	end
+	local value = nil
+	if documentField == '$' then
+		-- The model is a RedisJSON document, in which the fields of nested structs
+		-- are nested objects, so the value is read with the JSONPath of the field
+		local path = '$'
+		for part in string.gmatch(fieldName, '[^.]+') do
+			path = path .. '["' .. (string.gsub(part, '(["\\])', '\\%1')) .. '"]'
+		end
+		local values = redis.call('JSON.GET', key, path)
+		if values == false then
+			return false
+		end
+		value = cjson.decode(values)[1]
+	else
+		local document = redis.call('HGET', key, documentField)
+		if document == false then
+			return false
+		end
+		value = cjson.decode(document)[fieldName]
+	end
+	if value == nil then
+		return false
+	elseif type(value) == 'table' then
+		return decodeBase64(value['base64'])
+	end
+	return value
+end
+
+-- Get all the ids from the list (or set)
+local ids = {}
+local listType = redis.call('TYPE', listKey)['ok']
+if listType == 'zset' then
+	ids = redis.call('ZRANGE', listKey, 0, -1)
+elseif listType == 'set' then
+	ids = redis.call('SMEMBERS', listKey)
+else
+	ids = redis.call('LRANGE', listKey, 0, -1)
+end
+local count = 0
+for i, id in ipairs(ids) do
+	local key = collectionName .. ':' .. id
+	-- The offset of the model in the bitmap indexes, which is read when the
+	-- first field with a bitmap index is removed.
+	local bitmapOffset = false
+	-- Remove the model from each field index. This must happen before the main
+	-- hash is deleted, because string indexes rely on the old field values.
+	for j = 5, #ARGV, 2 do
+		local fieldName = ARGV[j]
+		local indexKind = ARGV[j+1]
+		local indexKey = indexPrefix .. fieldName
+		if indexKind == 'key' then
+			redis.call('DEL', key .. ':' .. fieldName)
+		elseif indexKind == 'fulltext' then
+			local termsKey = key .. ':' .. fieldName .. ':fulltext'
+			for k, term in ipairs(redis.call('SMEMBERS', termsKey)) do
+				redis.call('SREM', indexKey .. ':fulltext:' .. term, id)
+			end
+			redis.call('DEL', termsKey)
+		elseif indexKind == 'null' then
+			redis.call('SREM', indexKey .. ':null', id)
+		elseif indexKind == 'bitmap' then
+			-- Clear the bit of the model in the bitmap for every value of the field
+			if bitmapOffset == false then
+				bitmapOffset = redis.call('HGET', bitmapOffsetsKey, id)
+			end
+			if bitmapOffset ~= false then
+				for k, value in ipairs(redis.call('SMEMBERS', indexKey .. ':bitmap')) do
+					redis.call('SETBIT', indexKey .. ':bitmap:' .. value, bitmapOffset, 0)
+				end
+			end
+		elseif indexKind == 'string' or indexKind == 'integer' then
+			local oldValue = getField(key, fieldName)
+			if oldValue ~= false then
+				if indexKind == 'integer' then
+					oldValue = encodeInteger(oldValue)
+				else
+					oldValue = encodeString(oldValue)
+				end
+				redis.call('ZREM', indexKey, oldValue .. '\0' .. id)
+			end
+			-- See delete_string_index.lua
+			local oldMember = redis.call('HGET', indexKey .. ':members', id)
+			if oldMember ~= false then
+				redis.call('ZREM', indexKey, oldMember)
+				redis.call('HDEL', indexKey .. ':members', id)
+			end
+		elseif indexKind == 'collated' then
+			-- The member of each model in a collated string index is stored in a
+			-- separate hash, since the collation key cannot be computed here
+			local membersKey = indexKey .. ':collated'
+			local oldMember = redis.call('HGET', membersKey, id)
+			if oldMember ~= false then
+				redis.call('ZREM', indexKey, oldMember)
+				redis.call('HDEL', membersKey, id)
+			end
+		elseif indexKind == 'enum' then
+			-- Enum indexes also have a set of ids for each value. The value is the
+			-- score in the sorted set.
+			local oldValue = redis.call('ZSCORE', indexKey, id)
+			if oldValue ~= false then
+				redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+			end
+			redis.call('ZREM', indexKey, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	end
+	if bitmapOffset ~= false then
+		redis.call('HDEL', bitmapOffsetsKey, id)
+		redis.call('HDEL', bitmapIDsKey, bitmapOffset)
+	end
+	-- Delete the main hash and remove the id from the set of all ids
+	count = count + redis.call('DEL', key)
+	if allIsSorted then
+		redis.call('ZREM', allKey, id)
+	else
+		redis.call('SREM', allKey, id)
+	end
+	if evictable then
+		redis.call('ZREM', evictionKey, id)
+	end
+end
+return count
+`)
 	deleteModelsBySetIdsScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
 -- Use of this source code is governed by the MIT
 -- license, which can be found in the LICENSE file.
 
 -- delete_models_by_set_ids is a lua script that takes the following arguments:
--- 	1) The key of a set of model ids
+-- 	1) The key of a set or sorted set of model ids
 --		2) The name of a registered model
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	4) Zero or more pairs of arguments, one pair for each field which is stored
+--			in its own key, has a full-text index, has a null index, or has a bitmap
+--			index, where the first argument is the name of the field as it is stored
+--			in Redis and the second is either "key", "fulltext", "null", or "bitmap"
 -- The script then deletes all the models corresponding to the ids in the given
--- set. It returns the number of models that were deleted. It does not delete the
--- given set.
+-- set, including the keys for any fields stored in their own key, any
+-- full-text indexes, any null indexes, their bits and offsets in the bitmap
+-- indexes, and their entries in the set of all ids and the eviction set of the
+-- collection (if any). It returns the number of models that were deleted. It
+-- does not delete the given set.
 
 -- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
 
 -- Assign keys to variables for easy access
 local setKey = ARGV[1]
 local collectionName = ARGV[2]
+local indexPrefix = ARGV[3]
+-- The hashes which map the models to their offsets in the bitmap indexes and
+-- back. See update_bitmap_index.lua.
+local bitmapOffsetsKey = collectionName .. ':bitmap:offsets'
+local bitmapIDsKey = collectionName .. ':bitmap:ids'
+local allKey = collectionName .. ':all'
+local allIsSorted = redis.call('TYPE', allKey)['ok'] == 'zset'
+-- The sorted set used by the eviction policy of the collection, if any
+local evictionKey = collectionName .. ':eviction'
+local evictable = redis.call('TYPE', evictionKey)['ok'] == 'zset'
 -- Get all the ids from the set name
-local ids = redis.call('SMEMBERS', setKey)
+local ids = {}
+if redis.call('TYPE', setKey)['ok'] == 'zset' then
+	ids = redis.call('ZRANGE', setKey, 0, -1)
+else
+	ids = redis.call('SMEMBERS', setKey)
+end
 local count = 0
 if #ids > 0 then
 	-- Iterate over the ids
@@ -41,11 +375,49 @@ if #ids > 0 then
 		-- Delete the main hash for each model
 		local key = collectionName .. ':' .. id
 		count = count + redis.call('DEL', key)
+		-- Delete the keys for any fields stored in their own key and remove the
+		-- model from any full-text, null, and bitmap indexes
+		local bitmapOffset = false
+		for j = 4, #ARGV, 2 do
+			local fieldName = ARGV[j]
+			if ARGV[j+1] == 'null' then
+				redis.call('SREM', indexPrefix .. fieldName .. ':null', id)
+			elseif ARGV[j+1] == 'fulltext' then
+				local termsKey = key .. ':' .. fieldName .. ':fulltext'
+				for k, term in ipairs(redis.call('SMEMBERS', termsKey)) do
+					redis.call('SREM', indexPrefix .. fieldName .. ':fulltext:' .. term, id)
+				end
+				redis.call('DEL', termsKey)
+			elseif ARGV[j+1] == 'bitmap' then
+				-- Clear the bit of the model in the bitmap for every value of the field
+				if bitmapOffset == false then
+					bitmapOffset = redis.call('HGET', bitmapOffsetsKey, id)
+				end
+				if bitmapOffset ~= false then
+					local valuesKey = indexPrefix .. fieldName .. ':bitmap'
+					for k, value in ipairs(redis.call('SMEMBERS', valuesKey)) do
+						redis.call('SETBIT', valuesKey .. ':' .. value, bitmapOffset, 0)
+					end
+				end
+			else
+				redis.call('DEL', key .. ':' .. fieldName)
+			end
+		end
+		if bitmapOffset ~= false then
+			redis.call('HDEL', bitmapOffsetsKey, id)
+			redis.call('HDEL', bitmapIDsKey, bitmapOffset)
+		end
 		-- Remove the model id from the set of all ids
 		-- NOTE: this is not necessarily the same as the
 		-- setName we were given
-		local setKey = collectionName .. ':all'
-		redis.call('SREM', setKey, id)
+		if allIsSorted then
+			redis.call('ZREM', allKey, id)
+		else
+			redis.call('SREM', allKey, id)
+		end
+		if evictable then
+			redis.call('ZREM', evictionKey, id)
+		end
 	end
 end
 return count
@@ -58,8 +430,14 @@ return count
 -- 	1) The name of a registered model
 --		2) The id of the model to be deleted from the index
 --		3) The name of the indexed string field
+--		4) The kind of index ("string", "integer", or "collated")
+--		5) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+--		6) The field of the model hash in which the document is stored if the
+--			collection uses the document layout, "$" if it uses the JSON layout, or
+--			an empty string
 -- The script then checks if there is a value for the given field name stored in the
--- model hash, and if there is, removes the model from the index on the given field.
+-- model hash (or document), and if there is, removes the model from the index on the given field.
 -- NOTE: This script *must* be called before the main hash for the model is updated/deleted.
 
 -- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
@@ -68,14 +446,514 @@ return count
 local collectionName = ARGV[1]
 local modelID = ARGV[2]
 local fieldName = ARGV[3]
+local indexKind = ARGV[4]
+local indexPrefix = ARGV[5]
+local documentField = ARGV[6]
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- base64Chars are the characters used by the standard base64 encoding, in order.
+local base64Chars = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/'
+
+-- decodeBase64 decodes a value which is stored in a document as base64 because
+-- it is not valid UTF-8. See encodeDocument in layout.go.
+local function decodeBase64(data)
+	data = string.gsub(data, '[^%w%+/]', '')
+	local bytes = {}
+	local bits, count = 0, 0
+	for i = 1, #data do
+		bits = bits * 64 + string.find(base64Chars, string.sub(data, i, i), 1, true) - 1
+		count = count + 6
+		if count >= 8 then
+			count = count - 8
+			local byte = math.floor(bits / 2 ^ count)
+			bits = bits - byte * 2 ^ count
+			table.insert(bytes, string.char(byte))
+		end
+	end
+	return table.concat(bytes)
+end
+
+-- getField returns the value of a field of the model with the given key as it
+-- is stored in the model hash, or false if there is none. If documentField is
+-- not empty, the model uses the document layout and the value is read from the
+-- document stored in that field instead, or if it is "$", the model uses the
+-- JSON layout and the value is read from the RedisJSON document.
+local function getField(key, fieldName)
+	if documentField == '' then
+		return redis.call('HGET', key, fieldName)
+	end
+	local value = nil
+	if documentField == '$' then
+		-- The model is a RedisJSON document, in which the fields of nested structs
+		-- are nested objects, so the value is read with the JSONPath of the field
+		local path = '$'
+		for part in string.gmatch(fieldName, '[^.]+') do
+			path = path .. '["' .. (string.gsub(part, '(["\\])', '\\%1')) .. '"]'
+		end
+		local values = redis.call('JSON.GET', key, path)
+		if values == false then
+			return false
+		end
+		value = cjson.decode(values)[1]
+	else
+		local document = redis.call('HGET', key, documentField)
+		if document == false then
+			return false
+		end
+		value = cjson.decode(document)[fieldName]
+	end
+	if value == nil then
+		return false
+	elseif type(value) == 'table' then
+		return decodeBase64(value['base64'])
+	end
+	return value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
 -- Get the old value from the existing model hash (if any)
 local modelKey = collectionName .. ":" .. modelID
-local oldValue = redis.call("HGET", modelKey, fieldName)
-local indexKey = collectionName .. ":" .. fieldName
-if oldValue ~= false then
-	-- Remove the model from the field index
-	local oldMember = oldValue .. "\0" .. modelID
-	redis.call("ZREM", indexKey, oldMember)
+local oldValue = getField(modelKey, fieldName)
+local indexKey = indexPrefix .. fieldName
+if indexKind == 'collated' then
+	-- The collation keys cannot be computed here, so the member of each model
+	-- in a collated index is stored in a separate hash
+	local membersKey = indexKey .. ':collated'
+	local oldMember = redis.call("HGET", membersKey, modelID)
+	if oldMember ~= false then
+		redis.call("ZREM", indexKey, oldMember)
+		redis.call("HDEL", membersKey, modelID)
+	end
+else
+	if oldValue ~= false then
+		if indexKind == 'integer' then
+			oldValue = encodeInteger(oldValue)
+		else
+			oldValue = encodeString(oldValue)
+		end
+		-- Remove the model from the field index
+		local oldMember = oldValue .. "\0" .. modelID
+		redis.call("ZREM", indexKey, oldMember)
+	end
+	-- The member of each model is also recorded in a separate hash, so that
+	-- sync_model_indexes can find it without scanning the index. It differs
+	-- from oldMember if the hash was modified outside of Zoom.
+	local membersKey = indexKey .. ":members"
+	local recordedMember = redis.call("HGET", membersKey, modelID)
+	if recordedMember ~= false then
+		redis.call("ZREM", indexKey, recordedMember)
+		redis.call("HDEL", membersKey, modelID)
+	end
+end
+`)
+	diffIdsWithKeyScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- diff_ids_with_key is a lua script that takes the following arguments:
+-- 	1) origKey: The key of a set or sorted set of model ids
+--		2) idsKey: The key of a set or sorted set of model ids to exclude
+-- 	3) destKey: The key of a sorted set where the resulting ids will be stored
+-- The script stores the ids in origKey which are not in idsKey in destKey, like
+-- ZDIFFSTORE (which is not available in older versions of Redis). The scores
+-- from origKey are preserved, so the order of the ids is not affected. origKey
+-- and destKey may be the same key.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local origKey = ARGV[1]
+local idsKey = ARGV[2]
+local destKey = ARGV[3]
+redis.call('ZUNIONSTORE', destKey, 1, origKey)
+local keyType = redis.call('TYPE', idsKey)['ok']
+local ids = {}
+if keyType == 'set' then
+	ids = redis.call('SMEMBERS', idsKey)
+elseif keyType == 'zset' then
+	ids = redis.call('ZRANGE', idsKey, 0, -1)
+end
+for i, id in ipairs(ids) do
+	redis.call('ZREM', destKey, id)
+end
+`)
+	estimateFilterIntersectionsScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- estimate_filter_intersections is a lua script that takes the following
+-- arguments:
+-- 	1) sampleSize: The maximum number of ids to sample
+-- 	2) n: The number of filters
+-- 	3) Five arguments which count the total number of models, as described
+--			below, with the command "CARD", which uses ZCARD or SCARD depending on
+--			whether the index of all models is a sorted set or a set
+-- 	4) For each filter, "1" if the filter matches the models which are not in
+--			its index ranges (e.g. for !=) and "0" otherwise, followed by the
+--			number of index ranges k which the filter matches, followed by k
+--			groups of five arguments, each consisting of:
+-- 			a) The name of the command to count with ("ZCOUNT", "ZLEXCOUNT",
+--				"ZCARD", or "SCARD")
+-- 			b) The key of the index to count
+-- 			c) The min argument for ZCOUNT or ZLEXCOUNT (ignored otherwise)
+-- 			d) The max argument for ZCOUNT or ZLEXCOUNT (ignored otherwise)
+-- 			e) For ZLEXCOUNT, the key of the hash which maps each id to its member
+--				in the index (ignored otherwise)
+-- The script counts the models which match each filter without intersecting
+-- any sets (the count is the sum of the counts for its index ranges). Then it
+-- picks the filter which matches the fewest models (other than the filters
+-- which match the models not in their index ranges), reads up to sampleSize
+-- ids which match it, at random positions in its index ranges, and checks
+-- whether each of them matches all the other filters. Each id is read and
+-- checked in O(log(N)) time, so the script never reads more than sampleSize
+-- ids from any index. It returns a flat list consisting of the total number of
+-- models, the count for each filter, the (1-based) index of the picked filter
+-- (or 0 if no filter was picked), the number of sampled ids, and the number of
+-- sampled ids which match all the filters.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- count runs the command for the group of five arguments starting at i
+local function count(i)
+	local command = ARGV[i]
+	if command == 'CARD' then
+		command = 'SCARD'
+		if redis.call('TYPE', ARGV[i+1])['ok'] == 'zset' then
+			command = 'ZCARD'
+		end
+	end
+	if command == 'ZCOUNT' or command == 'ZLEXCOUNT' then
+		return redis.call(command, ARGV[i+1], ARGV[i+2], ARGV[i+3])
+	end
+	return redis.call(command, ARGV[i+1])
+end
+
+-- sample returns the ids for the group of five arguments starting at i at the
+-- given (0-based) offsets within its index range. For a set, which cannot be
+-- read by offset, it returns as many distinct random members instead.
+local function sample(i, offsets)
+	local command = ARGV[i]
+	local key = ARGV[i+1]
+	if command == 'SCARD' then
+		return redis.call('SRANDMEMBER', key, #offsets)
+	end
+	-- The rank of the first member in the range, i.e. the number of members
+	-- before it
+	local start = 0
+	if command == 'ZCOUNT' then
+		start = redis.call('ZCARD', key) - redis.call('ZCOUNT', key, ARGV[i+2], '+inf')
+	elseif command == 'ZLEXCOUNT' then
+		start = redis.call('ZCARD', key) - redis.call('ZLEXCOUNT', key, ARGV[i+2], '+')
+	end
+	local ids = {}
+	for _, offset in ipairs(offsets) do
+		local member = redis.call('ZRANGE', key, start + offset, start + offset)[1]
+		if member then
+			-- Members of string indexes are of the form value + NULL + id, so
+			-- the id is everything after the last NULL.
+			if command == 'ZLEXCOUNT' then
+				local idStart = string.find(member, '%z[^%z]*$')
+				if idStart then
+					member = string.sub(member, idStart+1)
+				end
+			end
+			table.insert(ids, member)
+		end
+	end
+	return ids
+end
+
+-- contains returns true iff the model with the given id is in the index range
+-- for the group of five arguments starting at i. Since the member of the model
+-- is in the index, it is in the range iff the ranges from min to the member and
+-- from the member to max are not empty.
+local function contains(i, id)
+	local command = ARGV[i]
+	local key = ARGV[i+1]
+	if command == 'ZCOUNT' then
+		local score = redis.call('ZSCORE', key, id)
+		return score ~= false and redis.call('ZCOUNT', key, ARGV[i+2], score) > 0 and redis.call('ZCOUNT', key, score, ARGV[i+3]) > 0
+	elseif command == 'ZLEXCOUNT' then
+		local member = redis.call('HGET', ARGV[i+4], id)
+		return member ~= false and redis.call('ZLEXCOUNT', key, ARGV[i+2], '[' .. member) > 0 and redis.call('ZLEXCOUNT', key, '[' .. member, ARGV[i+3]) > 0
+	elseif command == 'ZCARD' then
+		return redis.call('ZSCORE', key, id) ~= false
+	end
+	return redis.call('SISMEMBER', key, id) == 1
+end
+
+local sampleSize = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local result = {count(3)}
+
+-- Count the models which match each filter and remember where the arguments
+-- for its index ranges start.
+local filters = {}
+local pos = 8
+for i = 1, n do
+	local k = tonumber(ARGV[pos+1])
+	local filter = {index = i, complement = ARGV[pos] == '1', start = pos + 2, ranges = k, counts = {}, count = 0}
+	for j = 0, k - 1 do
+		local rangeCount = count(filter.start + j*5)
+		filter.counts[j] = rangeCount
+		filter.count = filter.count + rangeCount
+	end
+	table.insert(filters, filter)
+	table.insert(result, filter.count)
+	pos = pos + 2 + k*5
+end
+
+-- Pick the most selective filter.
+local picked = nil
+for _, filter in ipairs(filters) do
+	if not filter.complement and (picked == nil or filter.count < picked.count) then
+		picked = filter
+	end
+end
+if picked == nil then
+	table.insert(result, 0)
+	table.insert(result, 0)
+	table.insert(result, 0)
+	return result
+end
+table.insert(result, picked.index)
+
+-- Sample ids which match the picked filter at random positions across its
+-- index ranges, or read all of them if there are no more than sampleSize. The
+-- positions are random rather than evenly spaced, since ids which are close
+-- together (e.g. ids generated by RandomID, which are ordered by time) can
+-- follow a pattern which evenly spaced positions would line up with.
+local positions = {}
+if picked.count <= sampleSize then
+	for position = 0, picked.count - 1 do
+		table.insert(positions, position)
+	end
+else
+	for s = 1, sampleSize do
+		table.insert(positions, math.random(0, picked.count - 1))
+	end
+	table.sort(positions)
+end
+local offsets = {}
+for j = 0, picked.ranges - 1 do
+	offsets[j] = {}
+end
+local j, rangeStart = 0, 0
+for _, position in ipairs(positions) do
+	while position >= rangeStart + picked.counts[j] do
+		rangeStart = rangeStart + picked.counts[j]
+		j = j + 1
+	end
+	table.insert(offsets[j], position - rangeStart)
+end
+local ids = {}
+for j = 0, picked.ranges - 1 do
+	if #offsets[j] > 0 then
+		for _, id in ipairs(sample(picked.start + j*5, offsets[j])) do
+			table.insert(ids, id)
+		end
+	end
+end
+
+-- Count the sampled ids which match all the other filters.
+local matches = 0
+for _, id in ipairs(ids) do
+	local matchesAll = true
+	for _, filter in ipairs(filters) do
+		if filter ~= picked then
+			local found = false
+			for j = 0, filter.ranges - 1 do
+				if contains(filter.start + j*5, id) then
+					found = true
+					break
+				end
+			end
+			if found == filter.complement then
+				matchesAll = false
+				break
+			end
+		end
+	end
+	if matchesAll then
+		matches = matches + 1
+	end
+end
+table.insert(result, #ids)
+table.insert(result, matches)
+return result
+`)
+	evictModelsScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- evict_models is a lua script that takes the following arguments:
+-- 	1) evictionKey: The key of a sorted set of model ids, scored by the time
+--			each model was last used or first saved
+-- 	2) listKey: The key of a list where the evicted ids will be stored
+--		3) maxModels: The maximum number of ids kept in evictionKey, or 0 if the
+--			number of ids is not bounded
+-- 	4) minScore: The lowest score of the ids which are kept, or "-inf" if ids
+--			are kept regardless of their score
+-- The script then removes the ids with a score lower than minScore from
+-- evictionKey, followed by the ids with the lowest scores until there are at
+-- most maxModels ids left, and stores the removed ids in listKey so that the
+-- models can be deleted with delete_models_by_ids_list. It returns the removed
+-- ids.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local evictionKey = ARGV[1]
+local listKey = ARGV[2]
+local maxModels = tonumber(ARGV[3])
+local minScore = ARGV[4]
+local ids = {}
+if minScore ~= '-inf' then
+	ids = redis.call('ZRANGEBYSCORE', evictionKey, '-inf', '(' .. minScore)
+end
+if maxModels > 0 then
+	-- The ids with a score lower than minScore are the first ones in the sorted
+	-- set, so the next ones are the oldest ids which are left.
+	local excess = redis.call('ZCARD', evictionKey) - #ids - maxModels
+	if excess > 0 then
+		for i, id in ipairs(redis.call('ZRANGE', evictionKey, #ids, #ids + excess - 1)) do
+			table.insert(ids, id)
+		end
+	end
+end
+redis.call('DEL', listKey)
+for i, id in ipairs(ids) do
+	redis.call('ZREM', evictionKey, id)
+	redis.call('RPUSH', listKey, id)
+end
+return ids
+`)
+	extendLockScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- extend_lock is a lua script that takes the following arguments:
+-- 	1) The key of a lock
+-- 	2) The token of the client which acquired the lock
+-- 	3) The new time to live of the lock in milliseconds
+-- The script then resets the time to live of the lock iff it is still held
+-- with the given token. It returns 1 if the lock was extended and 0 otherwise.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local lockKey = ARGV[1]
+local token = ARGV[2]
+local ttl = ARGV[3]
+if redis.call('GET', lockKey) == token then
+	return redis.call('PEXPIRE', lockKey, ttl)
+end
+return 0
+`)
+	extractIdsFromBitmapScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- extract_ids_from_bitmap is a lua script that takes the following arguments:
+-- 	1) idsKey: The key of a hash which maps bitmap offsets to model ids
+--		2) bitmapKey: The key of a bitmap (i.e. a string) in which the bit at the
+--			offset of each model is set iff the model should be extracted
+-- 	3) destKey: The key of a sorted set where the resulting ids will be stored
+--		4) maxIDs: The maximum number of ids to extract, or 0 to extract all of them
+-- The script finds the offsets of the bits which are set in the bitmap, in
+-- order, converts them to model ids and stores the ids in destKey, each with
+-- its offset as the score. Offsets which do not correspond to a model id are
+-- ignored. It stops as soon as maxIDs ids have been extracted, so that a query
+-- with a limit does not need to convert the entire bitmap. Any existing value at
+-- destKey is replaced.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local idsKey = ARGV[1]
+local bitmapKey = ARGV[2]
+local destKey = ARGV[3]
+local maxIDs = tonumber(ARGV[4])
+redis.call('DEL', destKey)
+local bitmap = redis.call('GET', bitmapKey)
+if bitmap == false then
+	return
+end
+
+-- count is the number of ids which have been extracted so far
+local count = 0
+local function done()
+	return maxIDs > 0 and count >= maxIDs
+end
+
+-- addIDs adds the ids for the given offsets to destKey, until maxIDs ids have
+-- been extracted
+local function addIDs(offsets)
+	local ids = redis.call('HMGET', idsKey, unpack(offsets))
+	local args = {}
+	for i, id in ipairs(ids) do
+		if id ~= false and not done() then
+			table.insert(args, offsets[i])
+			table.insert(args, id)
+			count = count + 1
+		end
+	end
+	if #args > 0 then
+		redis.call('ZADD', destKey, unpack(args))
+	end
+end
+
+-- Find the offsets of the bits which are set, in batches which are no larger
+-- than the number of ids that are still needed. Bit 0 is the most significant
+-- bit of the first byte.
+local function batchSize()
+	if maxIDs > 0 and maxIDs - count < 1000 then
+		return maxIDs - count
+	end
+	return 1000
+end
+local offsets = {}
+local i = 1
+while i <= #bitmap and not done() do
+	local byte = string.byte(bitmap, i)
+	if byte ~= 0 then
+		for bit = 7, 0, -1 do
+			local value = 2 ^ bit
+			if byte >= value then
+				byte = byte - value
+				table.insert(offsets, (i - 1) * 8 + (7 - bit))
+				if #offsets >= batchSize() then
+					addIDs(offsets)
+					offsets = {}
+					if done() then
+						break
+					end
+				end
+			end
+		end
+	end
+	i = i + 1
+end
+if #offsets > 0 and not done() then
+	addIDs(offsets)
 end
 `)
 	extractIdsFromFieldIndexScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
@@ -140,4 +1018,1146 @@ if #members > 0 then
 	end
 end
 `)
-)
\ No newline at end of file
+	extractLastIdsScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- extract_last_ids is a lua script that takes the following arguments:
+-- 	1) setKey: The key of a sorted set of model ids, or of the index of all
+--			models in a collection, which may be a set
+-- 	2) destKey: The key of a sorted set where the resulting ids will be stored
+--		3) n: The number of ids to extract
+-- 	4) reverse: "1" if the ids are being read in descending order and "0" otherwise
+-- The script then extracts the last n ids from setKey (according to the direction
+-- given by reverse) using ZREVRANGE or ZRANGE and stores them in destKey. The scores
+-- in destKey are replaced with sequential numbers so that the ids keep the same
+-- relative order they had in setKey. If setKey is a set, the ids are ordered by
+-- id, as if they were in a sorted set with equal scores.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local setKey = ARGV[1]
+local destKey = ARGV[2]
+local n = tonumber(ARGV[3])
+local reverse = ARGV[4]
+if n <= 0 then
+	return
+end
+local isSet = redis.call('TYPE', setKey)['ok'] == 'set'
+if reverse == '1' then
+	-- The ids are read in descending order, so the last ids are the ones with
+	-- the lowest scores.
+	local ids = {}
+	if isSet then
+		ids = redis.call('SORT', setKey, 'ALPHA', 'LIMIT', 0, n)
+	else
+		ids = redis.call('ZRANGE', setKey, 0, n-1)
+	end
+	for i, id in ipairs(ids) do
+		redis.call('ZADD', destKey, i, id)
+	end
+else
+	-- The ids are read in ascending order, so the last ids are the ones with
+	-- the highest scores.
+	local ids = {}
+	if isSet then
+		ids = redis.call('SORT', setKey, 'ALPHA', 'DESC', 'LIMIT', 0, n)
+	else
+		ids = redis.call('ZREVRANGE', setKey, 0, n-1)
+	end
+	for i, id in ipairs(ids) do
+		redis.call('ZADD', destKey, -i, id)
+	end
+end
+`)
+	findHashFieldMismatchesScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- find_hash_field_mismatches is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+-- 	2) The key of a list of model ids, or an empty string if the ids are given
+--			directly
+-- 	3) n: The number of fields the model type has
+-- 	4) n arguments, one for each field of the model type, where each argument is
+--			the name of the field as it is stored in Redis
+-- 	5) Zero or more model ids (only if the second argument is empty)
+-- The script then compares the fields of the main hash of each model which
+-- exists with the fields of the model type. For each model whose hash has
+-- fields the model type does not have (unknown fields), or lacks fields the
+-- model type has (missing fields), it returns the id, the number of unknown
+-- fields, the unknown fields, the number of missing fields, and the missing
+-- fields, all in a single flat array.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local listKey = ARGV[2]
+local numFields = tonumber(ARGV[3])
+local fields = {}
+for i = 4, 3 + numFields do
+	fields[ARGV[i]] = true
+end
+local ids = {}
+if listKey ~= '' then
+	ids = redis.call('LRANGE', listKey, 0, -1)
+else
+	for i = 4 + numFields, #ARGV do
+		table.insert(ids, ARGV[i])
+	end
+end
+local result = {}
+for _, id in ipairs(ids) do
+	local keys = redis.call('HKEYS', collectionName .. ':' .. id)
+	if #keys > 0 then
+		local present = {}
+		local unknown = {}
+		for _, key in ipairs(keys) do
+			present[key] = true
+			if not fields[key] then
+				table.insert(unknown, key)
+			end
+		end
+		local missing = {}
+		for i = 4, 3 + numFields do
+			if not present[ARGV[i]] then
+				table.insert(missing, ARGV[i])
+			end
+		end
+		if #unknown > 0 or #missing > 0 then
+			table.insert(result, id)
+			table.insert(result, #unknown)
+			for _, key in ipairs(unknown) do
+				table.insert(result, key)
+			end
+			table.insert(result, #missing)
+			for _, key in ipairs(missing) do
+				table.insert(result, key)
+			end
+		end
+	end
+end
+return result
+`)
+	findReferencesScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- find_references is a lua script that takes the following arguments:
+-- 	1) fieldIndexKey: The key of a sorted set for a string index on a field which
+--			holds the ids of models in another collection. Each member is of the form:
+--			value + NULL + id, where NULL is the ASCII NULL character and the value is
+--			escaped like the values of all string indexes.
+-- 	2) targetID: The id of a model in the other collection
+-- The script returns the ids of all the models whose value for the field is
+-- targetID, i.e. the models which reference the target model.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeString escapes the NULL and \1 characters in a string to get the format
+-- used in string indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local fieldIndexKey = ARGV[1]
+local targetID = ARGV[2]
+local value = encodeString(targetID)
+local members = redis.call('ZRANGEBYLEX', fieldIndexKey, '[' .. value .. '\0', '(' .. value .. '\0\255')
+local ids = {}
+for i, member in ipairs(members) do
+	local idStart = string.find(member, '%z[^%z]*$')
+	table.insert(ids, string.sub(member, idStart+1))
+end
+return ids
+`)
+	getSetModelScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- get_set_model is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of the model to update
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	4) One or more groups of six arguments, one group for each field to be
+--			updated, exactly like the arguments for update_models_by_ids_list
+-- The script sets the given fields of the model and updates their field
+-- indexes accordingly, just like update_models_by_ids_list. It returns a list
+-- of the values that the given fields had before they were updated, in the same
+-- order as the groups of arguments, where a missing field is returned as nil.
+-- If the model does not exist, it returns nil and does not change anything.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local id = ARGV[2]
+local indexPrefix = ARGV[3]
+local key = collectionName .. ':' .. id
+if redis.call('EXISTS', key) == 0 then
+	return nil
+end
+local oldValues = {}
+for j = 4, #ARGV, 6 do
+	local fieldName = ARGV[j]
+	local value = ARGV[j+1]
+	local indexKind = ARGV[j+2]
+	local shouldIndex = ARGV[j+3] == '1'
+	local indexValue = ARGV[j+4]
+	local hasNullIndex = ARGV[j+5] == '1'
+	local indexKey = indexPrefix .. fieldName
+	local oldValue = redis.call('HGET', key, fieldName)
+	table.insert(oldValues, oldValue)
+	if indexKind == 'string' or indexKind == 'integer' then
+		-- Remove the old index (if any) before the hash is updated
+		if oldValue ~= false then
+			if indexKind == 'integer' then
+				oldValue = encodeInteger(oldValue)
+			else
+				oldValue = encodeString(oldValue)
+			end
+			redis.call('ZREM', indexKey, oldValue .. '\0' .. id)
+		end
+		-- The member of each model is also recorded in a separate hash, so that
+		-- sync_model_indexes can find it without scanning the index. It differs from
+		-- the one for the old value if the hash was modified outside of Zoom.
+		local membersKey = indexKey .. ':members'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if oldMember ~= false then
+			redis.call('ZREM', indexKey, oldMember)
+		end
+		if shouldIndex then
+			local member = indexValue .. '\0' .. id
+			redis.call('ZADD', indexKey, 0, member)
+			redis.call('HSET', membersKey, id, member)
+		else
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'collated' then
+		-- The member of each model in a collated string index is stored in a
+		-- separate hash, since the collation key cannot be computed here. The
+		-- index value is the collation key of the new value.
+		local membersKey = indexKey .. ':collated'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if oldMember ~= false then
+			redis.call('ZREM', indexKey, oldMember)
+		end
+		if shouldIndex then
+			local member = indexValue .. '\0' .. id
+			redis.call('ZADD', indexKey, 0, member)
+			redis.call('HSET', membersKey, id, member)
+		else
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'enum' then
+		-- Enum indexes also have a set of ids for each value. The old value is the
+		-- score in the sorted set.
+		local oldValue = redis.call('ZSCORE', indexKey, id)
+		if oldValue ~= false then
+			redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+		end
+		redis.call('ZADD', indexKey, indexValue, id)
+		redis.call('SADD', indexKey .. ':enum:' .. indexValue, id)
+	elseif indexKind ~= 'none' then
+		if shouldIndex then
+			redis.call('ZADD', indexKey, indexValue, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	end
+	if hasNullIndex then
+		-- The model is in the null index iff it is not in the regular index
+		if shouldIndex then
+			redis.call('SREM', indexKey .. ':null', id)
+		else
+			redis.call('SADD', indexKey .. ':null', id)
+		end
+	end
+	redis.call('HSET', key, fieldName, value)
+end
+return oldValues
+`)
+	intersectIdsWithKeyScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- intersect_ids_with_key is a lua script that takes the following arguments:
+-- 	1) origKey: The key of a set or sorted set of model ids
+--		2) idsKey: The key of an existing set, sorted set, or list of model ids
+-- 	3) destKey: The key of a sorted set where the resulting ids will be stored
+-- The script intersects the ids in origKey with the ids in idsKey and stores
+-- the result in destKey. The scores from origKey are preserved, so the order of
+-- the ids is not affected. If idsKey does not exist or is not a set, sorted
+-- set, or list, the result is empty.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local origKey = ARGV[1]
+local idsKey = ARGV[2]
+local destKey = ARGV[3]
+local keyType = redis.call('TYPE', idsKey)['ok']
+if keyType == 'set' or keyType == 'zset' then
+	redis.call('ZINTERSTORE', destKey, 2, origKey, idsKey, 'WEIGHTS', 1, 0)
+elseif keyType == 'list' then
+	-- ZINTERSTORE does not accept lists, so copy the ids into a temporary set
+	local tmpKey = destKey .. ':list'
+	redis.call('DEL', tmpKey)
+	local ids = redis.call('LRANGE', idsKey, 0, -1)
+	for i, id in ipairs(ids) do
+		redis.call('SADD', tmpKey, id)
+	end
+	redis.call('ZINTERSTORE', destKey, 2, origKey, tmpKey, 'WEIGHTS', 1, 0)
+	redis.call('DEL', tmpKey)
+else
+	redis.call('DEL', destKey)
+end
+`)
+	joinIdsScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- join_ids is a lua script that takes the following arguments:
+-- 	1) origKey: The key of a set or sorted set of model ids
+--		2) fieldIndexKey: The key of a sorted set for a string index on a field which
+--			holds the ids of models in another collection. Each member is of the form:
+--			value + NULL + id, where NULL is the ASCII NULL character and the value is
+--			escaped like the values of all string indexes.
+-- 	3) targetIDsKey: The key of a set or sorted set of ids of models in the other
+--			collection
+-- 	4) destKey: The key of a sorted set where the resulting ids will be stored
+-- The script finds the ids of all the models whose value for the field is one of
+-- the ids in targetIDsKey, intersects them with the ids in origKey and stores the
+-- result in destKey. The scores from origKey are preserved, so the order of the
+-- ids is not affected.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeString escapes the NULL and \1 characters in a string to get the format
+-- used in string indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local origKey = ARGV[1]
+local fieldIndexKey = ARGV[2]
+local targetIDsKey = ARGV[3]
+local destKey = ARGV[4]
+local targetIDs = {}
+local keyType = redis.call('TYPE', targetIDsKey)['ok']
+if keyType == 'set' then
+	targetIDs = redis.call('SMEMBERS', targetIDsKey)
+elseif keyType == 'zset' then
+	targetIDs = redis.call('ZRANGE', targetIDsKey, 0, -1)
+end
+-- Collect the ids of the models which reference one of the target ids in a
+-- temporary set
+local tmpKey = destKey .. ':join'
+redis.call('DEL', tmpKey)
+for i, targetID in ipairs(targetIDs) do
+	local value = encodeString(targetID)
+	local members = redis.call('ZRANGEBYLEX', fieldIndexKey, '[' .. value .. '\0', '(' .. value .. '\0\255')
+	for j, member in ipairs(members) do
+		local idStart = string.find(member, '%z[^%z]*$')
+		redis.call('SADD', tmpKey, string.sub(member, idStart+1))
+	end
+end
+redis.call('ZINTERSTORE', destKey, 2, origKey, tmpKey, 'WEIGHTS', 1, 0)
+redis.call('DEL', tmpKey)
+`)
+	moveIndexKeysScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- move_index_keys is a lua script that takes the following arguments:
+-- 	1) Zero or more pairs of arguments, where the first argument is the key of
+--			a set, sorted set, or bitmap which belongs to a field index (or the hash
+--			of members of a string index, whose key ends in ":collated" or
+--			":members") and the second is the key it should be moved to
+-- The script then moves each key which exists to its new key. If the new key
+-- already exists (e.g. because a model was saved with the new keys while the
+-- indexes were being moved), the old key is merged into it instead. Keys of any
+-- other type (in particular the main hash of a model whose id happens to be
+-- the same as the name of a field) are left alone. It returns the number of
+-- keys that were moved.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+local count = 0
+for i = 1, #ARGV, 2 do
+	local oldKey = ARGV[i]
+	local newKey = ARGV[i+1]
+	local keyType = redis.call('TYPE', oldKey)['ok']
+	local isMembersHash = keyType == 'hash' and
+		(string.sub(oldKey, -9) == ':collated' or string.sub(oldKey, -8) == ':members')
+	if keyType == 'zset' or keyType == 'set' or keyType == 'string' or isMembersHash then
+		local newType = redis.call('TYPE', newKey)['ok']
+		if newType == 'none' then
+			redis.call('RENAME', oldKey, newKey)
+			count = count + 1
+		elseif newType == keyType then
+			if keyType == 'zset' then
+				-- The scores in the new key are more recent, so they are kept.
+				local entries = redis.call('ZRANGE', oldKey, 0, -1, 'WITHSCORES')
+				for j = 1, #entries, 2 do
+					redis.call('ZADD', newKey, 'NX', entries[j+1], entries[j])
+				end
+			elseif keyType == 'set' then
+				redis.call('SUNIONSTORE', newKey, newKey, oldKey)
+			elseif keyType == 'hash' then
+				-- The members in the new key are more recent, so they are kept.
+				local entries = redis.call('HGETALL', oldKey)
+				for j = 1, #entries, 2 do
+					redis.call('HSETNX', newKey, entries[j], entries[j+1])
+				end
+			else
+				redis.call('BITOP', 'OR', newKey, newKey, oldKey)
+			end
+			redis.call('DEL', oldKey)
+			count = count + 1
+		end
+	end
+end
+return count
+`)
+	releaseLockScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- release_lock is a lua script that takes the following arguments:
+-- 	1) The key of a lock
+-- 	2) The token of the client which acquired the lock
+-- The script then deletes the lock iff it is still held with the given token,
+-- so that a client never releases a lock which has expired and been acquired
+-- by another client. It returns 1 if the lock was released and 0 otherwise.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local lockKey = ARGV[1]
+local token = ARGV[2]
+if redis.call('GET', lockKey) == token then
+	return redis.call('DEL', lockKey)
+end
+return 0
+`)
+	removeFromIndexScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- remove_from_index is a lua script that takes the following arguments:
+-- 	1) The key of the index of all models in a collection
+-- 	2) The id of a model
+-- The script removes the id from the index with SREM or ZREM, depending on
+-- whether the index is a set or a sorted set (see add_to_index.lua).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local indexKey = ARGV[1]
+local id = ARGV[2]
+if redis.call('TYPE', indexKey)['ok'] == 'zset' then
+	return redis.call('ZREM', indexKey, id)
+end
+return redis.call('SREM', indexKey, id)
+`)
+	sampleIdsScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- sample_ids is a lua script that takes the following arguments:
+-- 	1) setKey: The key of a set or sorted set of model ids
+-- 	2) destKey: The key of a sorted set where the resulting ids will be stored
+--		3) n: The number of ids to sample
+-- 	4) seed: A random number used to seed the random number generator
+-- The script picks n distinct ids from setKey at random (or all of them if
+-- there are fewer than n) and stores them in destKey. If setKey is a sorted
+-- set, the scores are preserved, so the sampled ids keep the same relative
+-- order they had in setKey. Otherwise every id has a score of 0.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local setKey = ARGV[1]
+local destKey = ARGV[2]
+local n = tonumber(ARGV[3])
+local seed = tonumber(ARGV[4])
+if n <= 0 then
+	return
+end
+local keyType = redis.call('TYPE', setKey)['ok']
+if keyType == 'set' then
+	local ids = redis.call('SRANDMEMBER', setKey, n)
+	for i, id in ipairs(ids) do
+		redis.call('ZADD', destKey, 0, id)
+	end
+elseif keyType == 'zset' then
+	local card = redis.call('ZCARD', setKey)
+	if n >= card then
+		redis.call('ZUNIONSTORE', destKey, 1, setKey)
+		return
+	end
+	-- Pick n distinct ranks with Floyd's algorithm, which only needs n random
+	-- numbers. Lua scripts are seeded with the same value every time, so we
+	-- need to seed the generator ourselves.
+	math.randomseed(seed)
+	local chosen = {}
+	for j = card - n + 1, card do
+		local rank = math.random(1, j)
+		if chosen[rank] then
+			rank = j
+		end
+		chosen[rank] = true
+		local idAndScore = redis.call('ZRANGE', setKey, rank - 1, rank - 1, 'WITHSCORES')
+		redis.call('ZADD', destKey, idAndScore[2], idAndScore[1])
+	end
+end
+`)
+	setNullReferenceScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- set_null_reference is a lua script that takes the following arguments:
+-- 	1) key: The key of the main hash of a model which references a model in
+--			another collection
+-- 	2) fieldName: The name of the field which holds the id of the referenced
+--			model, as it is stored in Redis
+-- 	3) fieldIndexKey: The key of the sorted set for the string index on the field
+-- 	4) targetID: The id of the referenced model, which is being deleted
+-- 	5) id: The id of the model which references it
+-- If the field of the model still holds targetID, the script sets it to an
+-- empty string and updates the string index accordingly. It returns 1 if the
+-- field was changed and 0 otherwise.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeString escapes the NULL and \1 characters in a string to get the format
+-- used in string indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local key = ARGV[1]
+local fieldName = ARGV[2]
+local fieldIndexKey = ARGV[3]
+local targetID = ARGV[4]
+local id = ARGV[5]
+if redis.call('HGET', key, fieldName) ~= targetID then
+	return 0
+end
+redis.call('HSET', key, fieldName, '')
+redis.call('ZREM', fieldIndexKey, encodeString(targetID) .. '\0' .. id)
+redis.call('ZADD', fieldIndexKey, 0, '\0' .. id)
+redis.call('HSET', fieldIndexKey .. ':members', id, '\0' .. id)
+return 1
+`)
+	sortModelsScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- sort_models is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+-- 	2) The number of values the SORT command gets for each model, including
+--			the id (i.e. the number of GET options)
+-- 	3) "json" if the RedisJSON document of each model should be read, or else
+--			an empty string
+-- 	4) The number of fields stored in their own key which should be read
+-- 	5) For each of those fields, a pair of arguments, where the first argument
+--			is the name of the field as it is stored in Redis and the second is
+--			either "hash", "list", or "set"
+-- 	6) The arguments for a SORT command which gets the values of the models,
+--			e.g. as returned by sortArgs
+-- The script runs the SORT command and returns its reply, with two additions
+-- for each model. If the third argument is "json", the document of the model
+-- (read with JSON.GET, since SORT cannot get the fields of documents) is
+-- inserted before the id, just like in the reply to the SORT command which gets
+-- the documents of the models in the document layout. Then the contents of
+-- each of the fields which are stored in their own key are appended, in the
+-- same format as the reply to HGETALL (for hashes) or LRANGE and SMEMBERS (for
+-- lists and sets).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local numValues = tonumber(ARGV[2])
+local getDocuments = ARGV[3] == 'json'
+local numKeyFields = tonumber(ARGV[4])
+local sortArgs = {}
+for i = 5 + 2 * numKeyFields, #ARGV do
+	table.insert(sortArgs, ARGV[i])
+end
+local values = redis.call('SORT', unpack(sortArgs))
+local result = {}
+for i = 1, #values, numValues do
+	for j = i, i + numValues - 2 do
+		table.insert(result, values[j])
+	end
+	local id = values[i + numValues - 1]
+	local key = collectionName .. ':' .. id
+	if getDocuments then
+		table.insert(result, redis.call('JSON.GET', key))
+	end
+	table.insert(result, id)
+	for j = 5, 4 + 2 * numKeyFields, 2 do
+		local fieldKey = key .. ':' .. ARGV[j]
+		if ARGV[j + 1] == 'hash' then
+			table.insert(result, redis.call('HGETALL', fieldKey))
+		elseif ARGV[j + 1] == 'list' then
+			table.insert(result, redis.call('LRANGE', fieldKey, 0, -1))
+		else
+			table.insert(result, redis.call('SMEMBERS', fieldKey))
+		end
+	end
+end
+return result
+`)
+	syncModelIndexesScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- sync_model_indexes is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of the model whose indexes should be synced
+-- 	3) "0" if the collection is not indexed, "1" if it is indexed (i.e. has a
+--			set of all ids), or "2" if it is indexed and a new set of all ids should
+--			be a sorted set. An existing set of all ids keeps its type.
+-- 	4) The score to use if the id is added to a sorted set of all ids, i.e. the
+--			time in milliseconds
+-- 	5) Zero or more groups of four arguments, one group for each indexed
+--			field, consisting of:
+--			a) The name of the field as it is stored in Redis
+--			b) The key of the index on the field
+--			c) The kind of index ("numeric", "boolean", "string", "integer",
+--				"collated", or "enum")
+--			d) "1" if the field is a pointer (i.e. "NULL" means nil and the field
+--				has a null index) and "0" otherwise
+-- The script reads the current field values from the model hash and makes the
+-- field indexes (and the set of all ids) consistent with them. If the hash no
+-- longer exists, the model is removed from all indexes. It is intended to be
+-- used to repair indexes after a model hash was modified outside of Zoom.
+-- Since the collation keys cannot be computed here, the model is only removed
+-- from a collated string index if the field has no value. Otherwise the caller
+-- is responsible for updating the index. The old member of the model in a string
+-- (or integer) index is found in the hash of members which Zoom keeps next to
+-- the index (see stringMembersKey in model.go), so a member which is not
+-- recorded there is not removed.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local id = ARGV[2]
+local indexAll = ARGV[3] ~= '0'
+local sortedIndex = ARGV[3] == '2'
+local score = ARGV[4]
+local key = collectionName .. ':' .. id
+local exists = redis.call('EXISTS', key) == 1
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+for j = 5, #ARGV, 4 do
+	local fieldName = ARGV[j]
+	local indexKey = ARGV[j+1]
+	local indexKind = ARGV[j+2]
+	local nullable = ARGV[j+3] == '1'
+	local value = false
+	local isNull = false
+	if exists then
+		value = redis.call('HGET', key, fieldName)
+		if nullable and (value == 'NULL' or value == false) then
+			value = false
+			isNull = true
+		end
+	end
+	if nullable then
+		-- Pointer fields have a null index which contains the ids of the models
+		-- for which the field is nil
+		if isNull then
+			redis.call('SADD', indexKey .. ':null', id)
+		else
+			redis.call('SREM', indexKey .. ':null', id)
+		end
+	end
+	if indexKind == 'string' or indexKind == 'integer' then
+		local member = false
+		if value ~= false then
+			if indexKind == 'integer' then
+				value = encodeInteger(value)
+			else
+				value = encodeString(value)
+			end
+			member = value .. '\0' .. id
+		end
+		-- The current member of the model (if any) is recorded in a separate hash,
+		-- so there is no need to scan the index for it
+		local membersKey = indexKey .. ':members'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if oldMember ~= false and oldMember ~= member then
+			redis.call('ZREM', indexKey, oldMember)
+		end
+		if member ~= false then
+			redis.call('ZADD', indexKey, 0, member)
+			redis.call('HSET', membersKey, id, member)
+		else
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'collated' then
+		-- The member of each model in a collated string index is stored in a
+		-- separate hash
+		local membersKey = indexKey .. ':collated'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if value == false and oldMember ~= false then
+			redis.call('ZREM', indexKey, oldMember)
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'enum' then
+		-- Enum indexes also have a set of ids for each value. The old value is the
+		-- score in the sorted set.
+		local oldValue = redis.call('ZSCORE', indexKey, id)
+		if oldValue ~= false then
+			redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+		end
+		if value ~= false then
+			redis.call('ZADD', indexKey, value, id)
+			redis.call('SADD', indexKey .. ':enum:' .. value, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	else
+		local score = nil
+		if value ~= false then
+			if indexKind == 'boolean' then
+				if value == '1' or value == 'true' then
+					score = 1
+				elseif value == '0' or value == 'false' then
+					score = 0
+				end
+			else
+				score = tonumber(value)
+			end
+		end
+		if score ~= nil then
+			redis.call('ZADD', indexKey, score, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	end
+end
+
+if indexAll then
+	local allKey = collectionName .. ':all'
+	local allType = redis.call('TYPE', allKey)['ok']
+	if allType == 'zset' or (allType == 'none' and sortedIndex) then
+		if exists then
+			redis.call('ZADD', allKey, 'NX', score, id)
+		else
+			redis.call('ZREM', allKey, id)
+		end
+	elseif exists then
+		redis.call('SADD', allKey, id)
+	else
+		redis.call('SREM', allKey, id)
+	end
+end
+`)
+	updateBitmapIndexScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_bitmap_index is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of the model, or the key of a list of model ids if the mode is
+--			"synclist"
+--		3) The mode, which is one of:
+--			"save": the remaining arguments are pairs of the name of a field with a
+--				bitmap index and the new value of the field
+--			"sync": the remaining arguments are the names of fields with bitmap
+--				indexes, and the values are read from the model hash
+--			"delete": the remaining arguments are the names of all the fields with
+--				bitmap indexes, and the model is removed from the bitmaps and its
+--				offset is released
+--			"synclist": like "sync" but for each model in the list
+--		4) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- Each model is assigned a unique offset (i.e. bit position) the first time it
+-- is added to a bitmap index. The offsets are stored in the hash
+-- <collection>:bitmap:offsets and the reverse mapping is stored in the hash
+-- <collection>:bitmap:ids. The bitmap for a particular value of a field is stored
+-- in the key <prefix><field>:bitmap:<value>, and the set of all values is
+-- stored in <prefix><field>:bitmap. Since bitmap indexes are only used for
+-- fields with a small number of distinct values, the script simply clears the bit
+-- for the model in the bitmap for every value before setting the new one.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local mode = ARGV[3]
+local indexPrefix = ARGV[4]
+local offsetsKey = collectionName .. ':bitmap:offsets'
+local idsKey = collectionName .. ':bitmap:ids'
+
+-- getOffset returns the offset for the model, assigning a new one if needed.
+-- If the model does not have an offset and create is false, it returns false.
+local function getOffset(modelID, create)
+	local offset = redis.call('HGET', offsetsKey, modelID)
+	if offset == false and create then
+		offset = redis.call('INCR', collectionName .. ':bitmap:next') - 1
+		redis.call('HSET', offsetsKey, modelID, offset)
+		redis.call('HSET', idsKey, offset, modelID)
+	end
+	return offset
+end
+
+-- clearBit removes the model from the bitmaps for every value of the field.
+local function clearBit(valuesKey, offset)
+	local values = redis.call('SMEMBERS', valuesKey)
+	for i, value in ipairs(values) do
+		redis.call('SETBIT', valuesKey .. ':' .. value, offset, 0)
+	end
+end
+
+-- setBit adds the model to the bitmap for the given value of the field.
+local function setBit(valuesKey, value, offset)
+	redis.call('SADD', valuesKey, value)
+	redis.call('SETBIT', valuesKey .. ':' .. value, offset, 1)
+end
+
+-- sync updates the bitmaps for the model based on the values in the model hash.
+local function sync(modelID)
+	local offset = getOffset(modelID, true)
+	local modelKey = collectionName .. ':' .. modelID
+	for i = 5, #ARGV do
+		local valuesKey = indexPrefix .. ARGV[i] .. ':bitmap'
+		clearBit(valuesKey, offset)
+		local value = redis.call('HGET', modelKey, ARGV[i])
+		if value ~= false then
+			setBit(valuesKey, value, offset)
+		end
+	end
+end
+
+if mode == 'save' then
+	local offset = getOffset(ARGV[2], true)
+	for i = 5, #ARGV, 2 do
+		local valuesKey = indexPrefix .. ARGV[i] .. ':bitmap'
+		clearBit(valuesKey, offset)
+		setBit(valuesKey, ARGV[i + 1], offset)
+	end
+elseif mode == 'sync' then
+	sync(ARGV[2])
+elseif mode == 'synclist' then
+	local modelIDs = redis.call('LRANGE', ARGV[2], 0, -1)
+	for i, modelID in ipairs(modelIDs) do
+		sync(modelID)
+	end
+else
+	local offset = getOffset(ARGV[2], false)
+	if offset == false then
+		-- The model was never added to a bitmap index
+		return
+	end
+	for i = 5, #ARGV do
+		clearBit(indexPrefix .. ARGV[i] .. ':bitmap', offset)
+	end
+	redis.call('HDEL', offsetsKey, ARGV[2])
+	redis.call('HDEL', idsKey, offset)
+end
+`)
+	updateEnumIndexScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_enum_index is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The name of the enum field as it is stored in Redis
+--		3) The id of the model
+--		4) The new value of the field (i.e. the position of the value in the list
+--			of allowed values), or an empty string if the model should be removed
+--			from the index
+--		5) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- An enum index consists of a sorted set of ids, where the score of each id is
+-- the value of the field, and a set of ids for each value. The script removes
+-- the model from the set for its old value (which is read from the sorted set)
+-- and adds it to the sorted set and the set for its new value.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local fieldName = ARGV[2]
+local id = ARGV[3]
+local value = ARGV[4]
+local indexKey = ARGV[5] .. fieldName
+local oldValue = redis.call('ZSCORE', indexKey, id)
+if oldValue ~= false then
+	redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+end
+if value == '' then
+	redis.call('ZREM', indexKey, id)
+else
+	redis.call('ZADD', indexKey, value, id)
+	redis.call('SADD', indexKey .. ':enum:' .. value, id)
+end
+`)
+	updateFulltextIndexScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_fulltext_index is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of a model
+-- 	3) The name of a field with a full-text index, as it is stored in Redis
+--		4) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	5) Zero or more terms which should be indexed for the field
+-- The script first removes the model id from the sets for each term that was
+-- previously indexed for the field (which are kept in a set with the key
+-- <name>:<id>:<field>:fulltext). Then it adds the model id to the set for each
+-- of the given terms and records the terms so they can be removed later.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local id = ARGV[2]
+local fieldName = ARGV[3]
+local termsKey = collectionName .. ':' .. id .. ':' .. fieldName .. ':fulltext'
+local termKeyPrefix = ARGV[4] .. fieldName .. ':fulltext:'
+-- Remove the old terms (if any)
+local oldTerms = redis.call('SMEMBERS', termsKey)
+for i, term in ipairs(oldTerms) do
+	redis.call('SREM', termKeyPrefix .. term, id)
+end
+redis.call('DEL', termsKey)
+-- Add the new terms
+for i = 5, #ARGV do
+	local term = ARGV[i]
+	redis.call('SADD', termKeyPrefix .. term, id)
+	redis.call('SADD', termsKey, term)
+end
+`)
+	updateModelsByIdsListScript = redis.NewScript(0, `-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_models_by_ids_list is a lua script that takes the following arguments:
+-- 	1) The key of a list of model ids
+--		2) The name of a registered model
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	4) One or more groups of six arguments, one group for each field to be
+--			updated, consisting of:
+--			a) The name of the field as it is stored in Redis
+--			b) The new value for the field as it should be stored in the hash
+--			c) The kind of index on the field ("none", "numeric", "boolean",
+--				"string", "integer", "collated", or "enum")
+--			d) "1" if the model should be added to the index and "0" if it should
+--				only be removed from the index (e.g. for nil pointers)
+--			e) The score (for numeric and boolean indexes) or the string value (for
+--				string, integer, and collated indexes) to store in the index
+--			f) "1" if the field has a null index (i.e. it is an indexed pointer
+--				field) and "0" otherwise
+-- The script then sets the given fields for all the models corresponding to the
+-- ids in the given list and updates their field indexes accordingly. Ids which
+-- do not correspond to an existing model are skipped. It returns the number of
+-- models that were updated. It does not delete the given list.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local listKey = ARGV[1]
+local collectionName = ARGV[2]
+local indexPrefix = ARGV[3]
+-- Get all the ids from the list
+local ids = redis.call('LRANGE', listKey, 0, -1)
+local count = 0
+for i, id in ipairs(ids) do
+	local key = collectionName .. ':' .. id
+	if redis.call('EXISTS', key) == 1 then
+		for j = 4, #ARGV, 6 do
+			local fieldName = ARGV[j]
+			local value = ARGV[j+1]
+			local indexKind = ARGV[j+2]
+			local shouldIndex = ARGV[j+3] == '1'
+			local indexValue = ARGV[j+4]
+			local hasNullIndex = ARGV[j+5] == '1'
+			local indexKey = indexPrefix .. fieldName
+			if indexKind == 'string' or indexKind == 'integer' then
+				-- Remove the old index (if any) before the hash is updated
+				local oldValue = redis.call('HGET', key, fieldName)
+				if oldValue ~= false then
+					if indexKind == 'integer' then
+						oldValue = encodeInteger(oldValue)
+					else
+						oldValue = encodeString(oldValue)
+					end
+					redis.call('ZREM', indexKey, oldValue .. '\0' .. id)
+				end
+				-- The member of each model is also recorded in a separate hash, so that
+				-- sync_model_indexes can find it without scanning the index. It differs from
+				-- the one for the old value if the hash was modified outside of Zoom.
+				local membersKey = indexKey .. ':members'
+				local oldMember = redis.call('HGET', membersKey, id)
+				if oldMember ~= false then
+					redis.call('ZREM', indexKey, oldMember)
+				end
+				if shouldIndex then
+					local member = indexValue .. '\0' .. id
+					redis.call('ZADD', indexKey, 0, member)
+					redis.call('HSET', membersKey, id, member)
+				else
+					redis.call('HDEL', membersKey, id)
+				end
+			elseif indexKind == 'collated' then
+				-- The member of each model in a collated string index is stored in a
+				-- separate hash, since the collation key cannot be computed here. The
+				-- index value is the collation key of the new value.
+				local membersKey = indexKey .. ':collated'
+				local oldMember = redis.call('HGET', membersKey, id)
+				if oldMember ~= false then
+					redis.call('ZREM', indexKey, oldMember)
+				end
+				if shouldIndex then
+					local member = indexValue .. '\0' .. id
+					redis.call('ZADD', indexKey, 0, member)
+					redis.call('HSET', membersKey, id, member)
+				else
+					redis.call('HDEL', membersKey, id)
+				end
+			elseif indexKind == 'enum' then
+				-- Enum indexes also have a set of ids for each value. The old value is the
+				-- score in the sorted set.
+				local oldValue = redis.call('ZSCORE', indexKey, id)
+				if oldValue ~= false then
+					redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+				end
+				redis.call('ZADD', indexKey, indexValue, id)
+				redis.call('SADD', indexKey .. ':enum:' .. indexValue, id)
+			elseif indexKind ~= 'none' then
+				if shouldIndex then
+					redis.call('ZADD', indexKey, indexValue, id)
+				else
+					redis.call('ZREM', indexKey, id)
+				end
+			end
+			if hasNullIndex then
+				-- The model is in the null index iff it is not in the regular index
+				if shouldIndex then
+					redis.call('SREM', indexKey .. ':null', id)
+				else
+					redis.call('SADD', indexKey .. ':null', id)
+				end
+			end
+			redis.call('HSET', key, fieldName, value)
+		end
+		count = count + 1
+	end
+end
+return count
+`)
+)
+
+// allScripts contains all of the scripts above, e.g. so that HealthCheck can
+// make sure they are available.
+var allScripts = []*redis.Script{
+	addToIndexScript,
+	claimOneScript,
+	countIndexScript,
+	deleteModelsByIdsListScript,
+	deleteModelsBySetIdsScript,
+	deleteStringIndexScript,
+	diffIdsWithKeyScript,
+	estimateFilterIntersectionsScript,
+	evictModelsScript,
+	extendLockScript,
+	extractIdsFromBitmapScript,
+	extractIdsFromFieldIndexScript,
+	extractIdsFromStringIndexScript,
+	extractLastIdsScript,
+	findHashFieldMismatchesScript,
+	findReferencesScript,
+	getSetModelScript,
+	intersectIdsWithKeyScript,
+	joinIdsScript,
+	moveIndexKeysScript,
+	releaseLockScript,
+	removeFromIndexScript,
+	sampleIdsScript,
+	setNullReferenceScript,
+	sortModelsScript,
+	syncModelIndexesScript,
+	updateBitmapIndexScript,
+	updateEnumIndexScript,
+	updateFulltextIndexScript,
+	updateModelsByIdsListScript,
+}
@@ -0,0 +1,28 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- add_to_index is a lua script that takes the following arguments:
+-- 	1) The key of the index of all models in a collection
+-- 	2) The id of a model
+-- 	3) The score of the model in a sorted index, i.e. the current time
+-- 	4) "1" if the index should be created as a sorted set if it does not exist
+--			yet, and "0" if it should be created as a set
+-- The script adds the id to the index with SADD if the index is a set, or with
+-- ZADD NX (so that the score of a model which was already saved is not changed)
+-- if it is a sorted set. Since the type of the index is checked every time, the
+-- index can be migrated to a sorted set at any time (see
+-- Collection.MigrateToSortedIndex).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local indexKey = ARGV[1]
+local id = ARGV[2]
+local score = ARGV[3]
+local sortedIndex = ARGV[4] == '1'
+local indexType = redis.call('TYPE', indexKey)['ok']
+if indexType == 'zset' or (indexType == 'none' and sortedIndex) then
+	return redis.call('ZADD', indexKey, 'NX', score, id)
+end
+return redis.call('SADD', indexKey, id)
@@ -0,0 +1,43 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- claim_one is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+-- 	2) The key of a list of candidate model ids, in order
+-- 	3) The token which identifies the new lease
+-- 	4) The duration of the lease in milliseconds
+-- 	5+) The Redis names of the fields to read
+-- The script finds the first id in the list for which the model still exists
+-- and which is not already leased, and leases it by setting its lock key to
+-- the token with the given time to live. It returns the values of the fields
+-- followed by the id of the model, or an empty array if no model could be
+-- claimed.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local modelName = ARGV[1]
+local listKey = ARGV[2]
+local token = ARGV[3]
+local leaseMillis = ARGV[4]
+local fields = {}
+for i = 5, #ARGV do
+	table.insert(fields, ARGV[i])
+end
+local ids = redis.call('LRANGE', listKey, 0, -1)
+for _, id in ipairs(ids) do
+	local modelKey = modelName .. ':' .. id
+	if redis.call('EXISTS', modelKey) == 1 then
+		local lockKey = modelName .. ':lock:' .. id
+		if redis.call('SET', lockKey, token, 'NX', 'PX', leaseMillis) then
+			local result = {}
+			if #fields > 0 then
+				result = redis.call('HMGET', modelKey, unpack(fields))
+			end
+			table.insert(result, id)
+			return result
+		end
+	end
+end
+return {}
@@ -0,0 +1,18 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- count_index is a lua script that takes the following arguments:
+-- 	1) The key of the index of all models in a collection
+-- The script returns the number of ids in the index with SCARD or ZCARD,
+-- depending on whether the index is a set or a sorted set (see
+-- add_to_index.lua).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local indexKey = ARGV[1]
+if redis.call('TYPE', indexKey)['ok'] == 'zset' then
+	return redis.call('ZCARD', indexKey)
+end
+return redis.call('SCARD', indexKey)
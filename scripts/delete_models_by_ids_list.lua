@@ -0,0 +1,217 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- delete_models_by_ids_list is a lua script that takes the following arguments:
+-- 	1) The key of a list, set, or sorted set of model ids
+--		2) The name of a registered model
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+--		4) The field of the model hash in which the document is stored if the
+--			collection uses the document layout, "$" if it uses the JSON layout, or
+--			an empty string
+-- 	5) Zero or more pairs of arguments, one pair for each indexed field, field
+--			stored in its own key, or field with a full-text index, where the first
+--			argument is the name of the field as it is stored in Redis and the second
+--			is the kind of index ("numeric", "boolean", "string", "integer",
+--			"collated", "enum", "fulltext", "bitmap", or "null" for the null index
+--			of a pointer field) or "key" for fields stored in their own key. Fields
+--			with a bitmap index have a second pair with the kind "bitmap".
+-- The script then deletes all the models corresponding to the ids in the given
+-- list (or set), including their field indexes, the keys for any fields stored
+-- in their own key, their bits and offsets in the bitmap indexes, and their
+-- entries in the set of all ids and the eviction set of the collection (if
+-- any). It returns the number of models that were deleted. It does not delete
+-- the given list (or set).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local listKey = ARGV[1]
+local collectionName = ARGV[2]
+local indexPrefix = ARGV[3]
+local documentField = ARGV[4]
+local allKey = collectionName .. ':all'
+-- The hashes which map the models to their offsets in the bitmap indexes and
+-- back. See update_bitmap_index.lua.
+local bitmapOffsetsKey = collectionName .. ':bitmap:offsets'
+local bitmapIDsKey = collectionName .. ':bitmap:ids'
+local allIsSorted = redis.call('TYPE', allKey)['ok'] == 'zset'
+-- The sorted set used by the eviction policy of the collection, if any
+local evictionKey = collectionName .. ':eviction'
+local evictable = redis.call('TYPE', evictionKey)['ok'] == 'zset'
+
+-- base64Chars are the characters used by the standard base64 encoding, in order.
+local base64Chars = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/'
+
+-- decodeBase64 decodes a value which is stored in a document as base64 because
+-- it is not valid UTF-8. See encodeDocument in layout.go.
+local function decodeBase64(data)
+	data = string.gsub(data, '[^%w%+/]', '')
+	local bytes = {}
+	local bits, count = 0, 0
+	for i = 1, #data do
+		bits = bits * 64 + string.find(base64Chars, string.sub(data, i, i), 1, true) - 1
+		count = count + 6
+		if count >= 8 then
+			count = count - 8
+			local byte = math.floor(bits / 2 ^ count)
+			bits = bits - byte * 2 ^ count
+			table.insert(bytes, string.char(byte))
+		end
+	end
+	return table.concat(bytes)
+end
+
+-- getField returns the value of a field of the model with the given key as it
+-- is stored in the model hash, or false if there is none. If documentField is
+-- not empty, the model uses the document layout and the value is read from the
+-- document stored in that field instead, or if it is "$", the model uses the
+-- JSON layout and the value is read from the RedisJSON document.
+local function getField(key, fieldName)
+	if documentField == '' then
+		return redis.call('HGET', key, fieldName)
+	end
+	local value = nil
+	if documentField == '$' then
+		-- The model is a RedisJSON document, in which the fields of nested structs
+		-- are nested objects, so the value is read with the JSONPath of the field
+		local path = '$'
+		for part in string.gmatch(fieldName, '[^.]+') do
+			path = path .. '["' .. (string.gsub(part, '(["\\])', '\\%1')) .. '"]'
+		end
+		local values = redis.call('JSON.GET', key, path)
+		if values == false then
+			return false
+		end
+		value = cjson.decode(values)[1]
+	else
+		local document = redis.call('HGET', key, documentField)
+		if document == false then
+			return false
+		end
+		value = cjson.decode(document)[fieldName]
+	end
+	if value == nil then
+		return false
+	elseif type(value) == 'table' then
+		return decodeBase64(value['base64'])
+	end
+	return value
+end
+
+-- Get all the ids from the list (or set)
+local ids = {}
+local listType = redis.call('TYPE', listKey)['ok']
+if listType == 'zset' then
+	ids = redis.call('ZRANGE', listKey, 0, -1)
+elseif listType == 'set' then
+	ids = redis.call('SMEMBERS', listKey)
+else
+	ids = redis.call('LRANGE', listKey, 0, -1)
+end
+local count = 0
+for i, id in ipairs(ids) do
+	local key = collectionName .. ':' .. id
+	-- The offset of the model in the bitmap indexes, which is read when the
+	-- first field with a bitmap index is removed.
+	local bitmapOffset = false
+	-- Remove the model from each field index. This must happen before the main
+	-- hash is deleted, because string indexes rely on the old field values.
+	for j = 5, #ARGV, 2 do
+		local fieldName = ARGV[j]
+		local indexKind = ARGV[j+1]
+		local indexKey = indexPrefix .. fieldName
+		if indexKind == 'key' then
+			redis.call('DEL', key .. ':' .. fieldName)
+		elseif indexKind == 'fulltext' then
+			local termsKey = key .. ':' .. fieldName .. ':fulltext'
+			for k, term in ipairs(redis.call('SMEMBERS', termsKey)) do
+				redis.call('SREM', indexKey .. ':fulltext:' .. term, id)
+			end
+			redis.call('DEL', termsKey)
+		elseif indexKind == 'null' then
+			redis.call('SREM', indexKey .. ':null', id)
+		elseif indexKind == 'bitmap' then
+			-- Clear the bit of the model in the bitmap for every value of the field
+			if bitmapOffset == false then
+				bitmapOffset = redis.call('HGET', bitmapOffsetsKey, id)
+			end
+			if bitmapOffset ~= false then
+				for k, value in ipairs(redis.call('SMEMBERS', indexKey .. ':bitmap')) do
+					redis.call('SETBIT', indexKey .. ':bitmap:' .. value, bitmapOffset, 0)
+				end
+			end
+		elseif indexKind == 'string' or indexKind == 'integer' then
+			local oldValue = getField(key, fieldName)
+			if oldValue ~= false then
+				if indexKind == 'integer' then
+					oldValue = encodeInteger(oldValue)
+				else
+					oldValue = encodeString(oldValue)
+				end
+				redis.call('ZREM', indexKey, oldValue .. '\0' .. id)
+			end
+			-- See delete_string_index.lua
+			local oldMember = redis.call('HGET', indexKey .. ':members', id)
+			if oldMember ~= false then
+				redis.call('ZREM', indexKey, oldMember)
+				redis.call('HDEL', indexKey .. ':members', id)
+			end
+		elseif indexKind == 'collated' then
+			-- The member of each model in a collated string index is stored in a
+			-- separate hash, since the collation key cannot be computed here
+			local membersKey = indexKey .. ':collated'
+			local oldMember = redis.call('HGET', membersKey, id)
+			if oldMember ~= false then
+				redis.call('ZREM', indexKey, oldMember)
+				redis.call('HDEL', membersKey, id)
+			end
+		elseif indexKind == 'enum' then
+			-- Enum indexes also have a set of ids for each value. The value is the
+			-- score in the sorted set.
+			local oldValue = redis.call('ZSCORE', indexKey, id)
+			if oldValue ~= false then
+				redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+			end
+			redis.call('ZREM', indexKey, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	end
+	if bitmapOffset ~= false then
+		redis.call('HDEL', bitmapOffsetsKey, id)
+		redis.call('HDEL', bitmapIDsKey, bitmapOffset)
+	end
+	-- Delete the main hash and remove the id from the set of all ids
+	count = count + redis.call('DEL', key)
+	if allIsSorted then
+		redis.call('ZREM', allKey, id)
+	else
+		redis.call('SREM', allKey, id)
+	end
+	if evictable then
+		redis.call('ZREM', evictionKey, id)
+	end
+end
+return count
@@ -3,19 +3,43 @@
 -- license, which can be found in the LICENSE file.
 
 -- delete_models_by_set_ids is a lua script that takes the following arguments:
--- 	1) The key of a set of model ids
+-- 	1) The key of a set or sorted set of model ids
 --		2) The name of a registered model
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	4) Zero or more pairs of arguments, one pair for each field which is stored
+--			in its own key, has a full-text index, has a null index, or has a bitmap
+--			index, where the first argument is the name of the field as it is stored
+--			in Redis and the second is either "key", "fulltext", "null", or "bitmap"
 -- The script then deletes all the models corresponding to the ids in the given
--- set. It returns the number of models that were deleted. It does not delete the
--- given set.
+-- set, including the keys for any fields stored in their own key, any
+-- full-text indexes, any null indexes, their bits and offsets in the bitmap
+-- indexes, and their entries in the set of all ids and the eviction set of the
+-- collection (if any). It returns the number of models that were deleted. It
+-- does not delete the given set.
 
 -- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
 
 -- Assign keys to variables for easy access
 local setKey = ARGV[1]
 local collectionName = ARGV[2]
+local indexPrefix = ARGV[3]
+-- The hashes which map the models to their offsets in the bitmap indexes and
+-- back. See update_bitmap_index.lua.
+local bitmapOffsetsKey = collectionName .. ':bitmap:offsets'
+local bitmapIDsKey = collectionName .. ':bitmap:ids'
+local allKey = collectionName .. ':all'
+local allIsSorted = redis.call('TYPE', allKey)['ok'] == 'zset'
+-- The sorted set used by the eviction policy of the collection, if any
+local evictionKey = collectionName .. ':eviction'
+local evictable = redis.call('TYPE', evictionKey)['ok'] == 'zset'
 -- Get all the ids from the set name
-local ids = redis.call('SMEMBERS', setKey)
+local ids = {}
+if redis.call('TYPE', setKey)['ok'] == 'zset' then
+	ids = redis.call('ZRANGE', setKey, 0, -1)
+else
+	ids = redis.call('SMEMBERS', setKey)
+end
 local count = 0
 if #ids > 0 then
 	-- Iterate over the ids
@@ -23,11 +47,49 @@ if #ids > 0 then
 		-- Delete the main hash for each model
 		local key = collectionName .. ':' .. id
 		count = count + redis.call('DEL', key)
+		-- Delete the keys for any fields stored in their own key and remove the
+		-- model from any full-text, null, and bitmap indexes
+		local bitmapOffset = false
+		for j = 4, #ARGV, 2 do
+			local fieldName = ARGV[j]
+			if ARGV[j+1] == 'null' then
+				redis.call('SREM', indexPrefix .. fieldName .. ':null', id)
+			elseif ARGV[j+1] == 'fulltext' then
+				local termsKey = key .. ':' .. fieldName .. ':fulltext'
+				for k, term in ipairs(redis.call('SMEMBERS', termsKey)) do
+					redis.call('SREM', indexPrefix .. fieldName .. ':fulltext:' .. term, id)
+				end
+				redis.call('DEL', termsKey)
+			elseif ARGV[j+1] == 'bitmap' then
+				-- Clear the bit of the model in the bitmap for every value of the field
+				if bitmapOffset == false then
+					bitmapOffset = redis.call('HGET', bitmapOffsetsKey, id)
+				end
+				if bitmapOffset ~= false then
+					local valuesKey = indexPrefix .. fieldName .. ':bitmap'
+					for k, value in ipairs(redis.call('SMEMBERS', valuesKey)) do
+						redis.call('SETBIT', valuesKey .. ':' .. value, bitmapOffset, 0)
+					end
+				end
+			else
+				redis.call('DEL', key .. ':' .. fieldName)
+			end
+		end
+		if bitmapOffset ~= false then
+			redis.call('HDEL', bitmapOffsetsKey, id)
+			redis.call('HDEL', bitmapIDsKey, bitmapOffset)
+		end
 		-- Remove the model id from the set of all ids
 		-- NOTE: this is not necessarily the same as the
 		-- setName we were given
-		local setKey = collectionName .. ':all'
-		redis.call('SREM', setKey, id)
+		if allIsSorted then
+			redis.call('ZREM', allKey, id)
+		else
+			redis.call('SREM', allKey, id)
+		end
+		if evictable then
+			redis.call('ZREM', evictionKey, id)
+		end
 	end
 end
 return count
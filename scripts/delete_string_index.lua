@@ -6,8 +6,14 @@
 -- 	1) The name of a registered model
 --		2) The id of the model to be deleted from the index
 --		3) The name of the indexed string field
+--		4) The kind of index ("string", "integer", or "collated")
+--		5) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+--		6) The field of the model hash in which the document is stored if the
+--			collection uses the document layout, "$" if it uses the JSON layout, or
+--			an empty string
 -- The script then checks if there is a value for the given field name stored in the
--- model hash, and if there is, removes the model from the index on the given field.
+-- model hash (or document), and if there is, removes the model from the index on the given field.
 -- NOTE: This script *must* be called before the main hash for the model is updated/deleted.
 
 -- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
@@ -16,12 +22,120 @@
 local collectionName = ARGV[1]
 local modelID = ARGV[2]
 local fieldName = ARGV[3]
+local indexKind = ARGV[4]
+local indexPrefix = ARGV[5]
+local documentField = ARGV[6]
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- base64Chars are the characters used by the standard base64 encoding, in order.
+local base64Chars = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/'
+
+-- decodeBase64 decodes a value which is stored in a document as base64 because
+-- it is not valid UTF-8. See encodeDocument in layout.go.
+local function decodeBase64(data)
+	data = string.gsub(data, '[^%w%+/]', '')
+	local bytes = {}
+	local bits, count = 0, 0
+	for i = 1, #data do
+		bits = bits * 64 + string.find(base64Chars, string.sub(data, i, i), 1, true) - 1
+		count = count + 6
+		if count >= 8 then
+			count = count - 8
+			local byte = math.floor(bits / 2 ^ count)
+			bits = bits - byte * 2 ^ count
+			table.insert(bytes, string.char(byte))
+		end
+	end
+	return table.concat(bytes)
+end
+
+-- getField returns the value of a field of the model with the given key as it
+-- is stored in the model hash, or false if there is none. If documentField is
+-- not empty, the model uses the document layout and the value is read from the
+-- document stored in that field instead, or if it is "$", the model uses the
+-- JSON layout and the value is read from the RedisJSON document.
+local function getField(key, fieldName)
+	if documentField == '' then
+		return redis.call('HGET', key, fieldName)
+	end
+	local value = nil
+	if documentField == '$' then
+		-- The model is a RedisJSON document, in which the fields of nested structs
+		-- are nested objects, so the value is read with the JSONPath of the field
+		local path = '$'
+		for part in string.gmatch(fieldName, '[^.]+') do
+			path = path .. '["' .. (string.gsub(part, '(["\\])', '\\%1')) .. '"]'
+		end
+		local values = redis.call('JSON.GET', key, path)
+		if values == false then
+			return false
+		end
+		value = cjson.decode(values)[1]
+	else
+		local document = redis.call('HGET', key, documentField)
+		if document == false then
+			return false
+		end
+		value = cjson.decode(document)[fieldName]
+	end
+	if value == nil then
+		return false
+	elseif type(value) == 'table' then
+		return decodeBase64(value['base64'])
+	end
+	return value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
 -- Get the old value from the existing model hash (if any)
 local modelKey = collectionName .. ":" .. modelID
-local oldValue = redis.call("HGET", modelKey, fieldName)
-local indexKey = collectionName .. ":" .. fieldName
-if oldValue ~= false then
-	-- Remove the model from the field index
-	local oldMember = oldValue .. "\0" .. modelID
-	redis.call("ZREM", indexKey, oldMember)
+local oldValue = getField(modelKey, fieldName)
+local indexKey = indexPrefix .. fieldName
+if indexKind == 'collated' then
+	-- The collation keys cannot be computed here, so the member of each model
+	-- in a collated index is stored in a separate hash
+	local membersKey = indexKey .. ':collated'
+	local oldMember = redis.call("HGET", membersKey, modelID)
+	if oldMember ~= false then
+		redis.call("ZREM", indexKey, oldMember)
+		redis.call("HDEL", membersKey, modelID)
+	end
+else
+	if oldValue ~= false then
+		if indexKind == 'integer' then
+			oldValue = encodeInteger(oldValue)
+		else
+			oldValue = encodeString(oldValue)
+		end
+		-- Remove the model from the field index
+		local oldMember = oldValue .. "\0" .. modelID
+		redis.call("ZREM", indexKey, oldMember)
+	end
+	-- The member of each model is also recorded in a separate hash, so that
+	-- sync_model_indexes can find it without scanning the index. It differs
+	-- from oldMember if the hash was modified outside of Zoom.
+	local membersKey = indexKey .. ":members"
+	local recordedMember = redis.call("HGET", membersKey, modelID)
+	if recordedMember ~= false then
+		redis.call("ZREM", indexKey, recordedMember)
+		redis.call("HDEL", membersKey, modelID)
+	end
 end
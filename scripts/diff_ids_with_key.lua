@@ -0,0 +1,30 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- diff_ids_with_key is a lua script that takes the following arguments:
+-- 	1) origKey: The key of a set or sorted set of model ids
+--		2) idsKey: The key of a set or sorted set of model ids to exclude
+-- 	3) destKey: The key of a sorted set where the resulting ids will be stored
+-- The script stores the ids in origKey which are not in idsKey in destKey, like
+-- ZDIFFSTORE (which is not available in older versions of Redis). The scores
+-- from origKey are preserved, so the order of the ids is not affected. origKey
+-- and destKey may be the same key.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local origKey = ARGV[1]
+local idsKey = ARGV[2]
+local destKey = ARGV[3]
+redis.call('ZUNIONSTORE', destKey, 1, origKey)
+local keyType = redis.call('TYPE', idsKey)['ok']
+local ids = {}
+if keyType == 'set' then
+	ids = redis.call('SMEMBERS', idsKey)
+elseif keyType == 'zset' then
+	ids = redis.call('ZRANGE', idsKey, 0, -1)
+end
+for i, id in ipairs(ids) do
+	redis.call('ZREM', destKey, id)
+end
@@ -0,0 +1,204 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- estimate_filter_intersections is a lua script that takes the following
+-- arguments:
+-- 	1) sampleSize: The maximum number of ids to sample
+-- 	2) n: The number of filters
+-- 	3) Five arguments which count the total number of models, as described
+--			below, with the command "CARD", which uses ZCARD or SCARD depending on
+--			whether the index of all models is a sorted set or a set
+-- 	4) For each filter, "1" if the filter matches the models which are not in
+--			its index ranges (e.g. for !=) and "0" otherwise, followed by the
+--			number of index ranges k which the filter matches, followed by k
+--			groups of five arguments, each consisting of:
+-- 			a) The name of the command to count with ("ZCOUNT", "ZLEXCOUNT",
+--				"ZCARD", or "SCARD")
+-- 			b) The key of the index to count
+-- 			c) The min argument for ZCOUNT or ZLEXCOUNT (ignored otherwise)
+-- 			d) The max argument for ZCOUNT or ZLEXCOUNT (ignored otherwise)
+-- 			e) For ZLEXCOUNT, the key of the hash which maps each id to its member
+--				in the index (ignored otherwise)
+-- The script counts the models which match each filter without intersecting
+-- any sets (the count is the sum of the counts for its index ranges). Then it
+-- picks the filter which matches the fewest models (other than the filters
+-- which match the models not in their index ranges), reads up to sampleSize
+-- ids which match it, at random positions in its index ranges, and checks
+-- whether each of them matches all the other filters. Each id is read and
+-- checked in O(log(N)) time, so the script never reads more than sampleSize
+-- ids from any index. It returns a flat list consisting of the total number of
+-- models, the count for each filter, the (1-based) index of the picked filter
+-- (or 0 if no filter was picked), the number of sampled ids, and the number of
+-- sampled ids which match all the filters.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- count runs the command for the group of five arguments starting at i
+local function count(i)
+	local command = ARGV[i]
+	if command == 'CARD' then
+		command = 'SCARD'
+		if redis.call('TYPE', ARGV[i+1])['ok'] == 'zset' then
+			command = 'ZCARD'
+		end
+	end
+	if command == 'ZCOUNT' or command == 'ZLEXCOUNT' then
+		return redis.call(command, ARGV[i+1], ARGV[i+2], ARGV[i+3])
+	end
+	return redis.call(command, ARGV[i+1])
+end
+
+-- sample returns the ids for the group of five arguments starting at i at the
+-- given (0-based) offsets within its index range. For a set, which cannot be
+-- read by offset, it returns as many distinct random members instead.
+local function sample(i, offsets)
+	local command = ARGV[i]
+	local key = ARGV[i+1]
+	if command == 'SCARD' then
+		return redis.call('SRANDMEMBER', key, #offsets)
+	end
+	-- The rank of the first member in the range, i.e. the number of members
+	-- before it
+	local start = 0
+	if command == 'ZCOUNT' then
+		start = redis.call('ZCARD', key) - redis.call('ZCOUNT', key, ARGV[i+2], '+inf')
+	elseif command == 'ZLEXCOUNT' then
+		start = redis.call('ZCARD', key) - redis.call('ZLEXCOUNT', key, ARGV[i+2], '+')
+	end
+	local ids = {}
+	for _, offset in ipairs(offsets) do
+		local member = redis.call('ZRANGE', key, start + offset, start + offset)[1]
+		if member then
+			-- Members of string indexes are of the form value + NULL + id, so
+			-- the id is everything after the last NULL.
+			if command == 'ZLEXCOUNT' then
+				local idStart = string.find(member, '%z[^%z]*$')
+				if idStart then
+					member = string.sub(member, idStart+1)
+				end
+			end
+			table.insert(ids, member)
+		end
+	end
+	return ids
+end
+
+-- contains returns true iff the model with the given id is in the index range
+-- for the group of five arguments starting at i. Since the member of the model
+-- is in the index, it is in the range iff the ranges from min to the member and
+-- from the member to max are not empty.
+local function contains(i, id)
+	local command = ARGV[i]
+	local key = ARGV[i+1]
+	if command == 'ZCOUNT' then
+		local score = redis.call('ZSCORE', key, id)
+		return score ~= false and redis.call('ZCOUNT', key, ARGV[i+2], score) > 0 and redis.call('ZCOUNT', key, score, ARGV[i+3]) > 0
+	elseif command == 'ZLEXCOUNT' then
+		local member = redis.call('HGET', ARGV[i+4], id)
+		return member ~= false and redis.call('ZLEXCOUNT', key, ARGV[i+2], '[' .. member) > 0 and redis.call('ZLEXCOUNT', key, '[' .. member, ARGV[i+3]) > 0
+	elseif command == 'ZCARD' then
+		return redis.call('ZSCORE', key, id) ~= false
+	end
+	return redis.call('SISMEMBER', key, id) == 1
+end
+
+local sampleSize = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local result = {count(3)}
+
+-- Count the models which match each filter and remember where the arguments
+-- for its index ranges start.
+local filters = {}
+local pos = 8
+for i = 1, n do
+	local k = tonumber(ARGV[pos+1])
+	local filter = {index = i, complement = ARGV[pos] == '1', start = pos + 2, ranges = k, counts = {}, count = 0}
+	for j = 0, k - 1 do
+		local rangeCount = count(filter.start + j*5)
+		filter.counts[j] = rangeCount
+		filter.count = filter.count + rangeCount
+	end
+	table.insert(filters, filter)
+	table.insert(result, filter.count)
+	pos = pos + 2 + k*5
+end
+
+-- Pick the most selective filter.
+local picked = nil
+for _, filter in ipairs(filters) do
+	if not filter.complement and (picked == nil or filter.count < picked.count) then
+		picked = filter
+	end
+end
+if picked == nil then
+	table.insert(result, 0)
+	table.insert(result, 0)
+	table.insert(result, 0)
+	return result
+end
+table.insert(result, picked.index)
+
+-- Sample ids which match the picked filter at random positions across its
+-- index ranges, or read all of them if there are no more than sampleSize. The
+-- positions are random rather than evenly spaced, since ids which are close
+-- together (e.g. ids generated by RandomID, which are ordered by time) can
+-- follow a pattern which evenly spaced positions would line up with.
+local positions = {}
+if picked.count <= sampleSize then
+	for position = 0, picked.count - 1 do
+		table.insert(positions, position)
+	end
+else
+	for s = 1, sampleSize do
+		table.insert(positions, math.random(0, picked.count - 1))
+	end
+	table.sort(positions)
+end
+local offsets = {}
+for j = 0, picked.ranges - 1 do
+	offsets[j] = {}
+end
+local j, rangeStart = 0, 0
+for _, position in ipairs(positions) do
+	while position >= rangeStart + picked.counts[j] do
+		rangeStart = rangeStart + picked.counts[j]
+		j = j + 1
+	end
+	table.insert(offsets[j], position - rangeStart)
+end
+local ids = {}
+for j = 0, picked.ranges - 1 do
+	if #offsets[j] > 0 then
+		for _, id in ipairs(sample(picked.start + j*5, offsets[j])) do
+			table.insert(ids, id)
+		end
+	end
+end
+
+-- Count the sampled ids which match all the other filters.
+local matches = 0
+for _, id in ipairs(ids) do
+	local matchesAll = true
+	for _, filter in ipairs(filters) do
+		if filter ~= picked then
+			local found = false
+			for j = 0, filter.ranges - 1 do
+				if contains(filter.start + j*5, id) then
+					found = true
+					break
+				end
+			end
+			if found == filter.complement then
+				matchesAll = false
+				break
+			end
+		end
+	end
+	if matchesAll then
+		matches = matches + 1
+	end
+end
+table.insert(result, #ids)
+table.insert(result, matches)
+return result
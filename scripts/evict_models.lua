@@ -0,0 +1,45 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- evict_models is a lua script that takes the following arguments:
+-- 	1) evictionKey: The key of a sorted set of model ids, scored by the time
+--			each model was last used or first saved
+-- 	2) listKey: The key of a list where the evicted ids will be stored
+--		3) maxModels: The maximum number of ids kept in evictionKey, or 0 if the
+--			number of ids is not bounded
+-- 	4) minScore: The lowest score of the ids which are kept, or "-inf" if ids
+--			are kept regardless of their score
+-- The script then removes the ids with a score lower than minScore from
+-- evictionKey, followed by the ids with the lowest scores until there are at
+-- most maxModels ids left, and stores the removed ids in listKey so that the
+-- models can be deleted with delete_models_by_ids_list. It returns the removed
+-- ids.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local evictionKey = ARGV[1]
+local listKey = ARGV[2]
+local maxModels = tonumber(ARGV[3])
+local minScore = ARGV[4]
+local ids = {}
+if minScore ~= '-inf' then
+	ids = redis.call('ZRANGEBYSCORE', evictionKey, '-inf', '(' .. minScore)
+end
+if maxModels > 0 then
+	-- The ids with a score lower than minScore are the first ones in the sorted
+	-- set, so the next ones are the oldest ids which are left.
+	local excess = redis.call('ZCARD', evictionKey) - #ids - maxModels
+	if excess > 0 then
+		for i, id in ipairs(redis.call('ZRANGE', evictionKey, #ids, #ids + excess - 1)) do
+			table.insert(ids, id)
+		end
+	end
+end
+redis.call('DEL', listKey)
+for i, id in ipairs(ids) do
+	redis.call('ZREM', evictionKey, id)
+	redis.call('RPUSH', listKey, id)
+end
+return ids
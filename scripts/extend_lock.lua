@@ -0,0 +1,21 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- extend_lock is a lua script that takes the following arguments:
+-- 	1) The key of a lock
+-- 	2) The token of the client which acquired the lock
+-- 	3) The new time to live of the lock in milliseconds
+-- The script then resets the time to live of the lock iff it is still held
+-- with the given token. It returns 1 if the lock was extended and 0 otherwise.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local lockKey = ARGV[1]
+local token = ARGV[2]
+local ttl = ARGV[3]
+if redis.call('GET', lockKey) == token then
+	return redis.call('PEXPIRE', lockKey, ttl)
+end
+return 0
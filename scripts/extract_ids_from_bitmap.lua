@@ -0,0 +1,87 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- extract_ids_from_bitmap is a lua script that takes the following arguments:
+-- 	1) idsKey: The key of a hash which maps bitmap offsets to model ids
+--		2) bitmapKey: The key of a bitmap (i.e. a string) in which the bit at the
+--			offset of each model is set iff the model should be extracted
+-- 	3) destKey: The key of a sorted set where the resulting ids will be stored
+--		4) maxIDs: The maximum number of ids to extract, or 0 to extract all of them
+-- The script finds the offsets of the bits which are set in the bitmap, in
+-- order, converts them to model ids and stores the ids in destKey, each with
+-- its offset as the score. Offsets which do not correspond to a model id are
+-- ignored. It stops as soon as maxIDs ids have been extracted, so that a query
+-- with a limit does not need to convert the entire bitmap. Any existing value at
+-- destKey is replaced.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local idsKey = ARGV[1]
+local bitmapKey = ARGV[2]
+local destKey = ARGV[3]
+local maxIDs = tonumber(ARGV[4])
+redis.call('DEL', destKey)
+local bitmap = redis.call('GET', bitmapKey)
+if bitmap == false then
+	return
+end
+
+-- count is the number of ids which have been extracted so far
+local count = 0
+local function done()
+	return maxIDs > 0 and count >= maxIDs
+end
+
+-- addIDs adds the ids for the given offsets to destKey, until maxIDs ids have
+-- been extracted
+local function addIDs(offsets)
+	local ids = redis.call('HMGET', idsKey, unpack(offsets))
+	local args = {}
+	for i, id in ipairs(ids) do
+		if id ~= false and not done() then
+			table.insert(args, offsets[i])
+			table.insert(args, id)
+			count = count + 1
+		end
+	end
+	if #args > 0 then
+		redis.call('ZADD', destKey, unpack(args))
+	end
+end
+
+-- Find the offsets of the bits which are set, in batches which are no larger
+-- than the number of ids that are still needed. Bit 0 is the most significant
+-- bit of the first byte.
+local function batchSize()
+	if maxIDs > 0 and maxIDs - count < 1000 then
+		return maxIDs - count
+	end
+	return 1000
+end
+local offsets = {}
+local i = 1
+while i <= #bitmap and not done() do
+	local byte = string.byte(bitmap, i)
+	if byte ~= 0 then
+		for bit = 7, 0, -1 do
+			local value = 2 ^ bit
+			if byte >= value then
+				byte = byte - value
+				table.insert(offsets, (i - 1) * 8 + (7 - bit))
+				if #offsets >= batchSize() then
+					addIDs(offsets)
+					offsets = {}
+					if done() then
+						break
+					end
+				end
+			end
+		end
+	end
+	i = i + 1
+end
+if #offsets > 0 and not done() then
+	addIDs(offsets)
+end
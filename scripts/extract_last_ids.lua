@@ -0,0 +1,52 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- extract_last_ids is a lua script that takes the following arguments:
+-- 	1) setKey: The key of a sorted set of model ids, or of the index of all
+--			models in a collection, which may be a set
+-- 	2) destKey: The key of a sorted set where the resulting ids will be stored
+--		3) n: The number of ids to extract
+-- 	4) reverse: "1" if the ids are being read in descending order and "0" otherwise
+-- The script then extracts the last n ids from setKey (according to the direction
+-- given by reverse) using ZREVRANGE or ZRANGE and stores them in destKey. The scores
+-- in destKey are replaced with sequential numbers so that the ids keep the same
+-- relative order they had in setKey. If setKey is a set, the ids are ordered by
+-- id, as if they were in a sorted set with equal scores.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local setKey = ARGV[1]
+local destKey = ARGV[2]
+local n = tonumber(ARGV[3])
+local reverse = ARGV[4]
+if n <= 0 then
+	return
+end
+local isSet = redis.call('TYPE', setKey)['ok'] == 'set'
+if reverse == '1' then
+	-- The ids are read in descending order, so the last ids are the ones with
+	-- the lowest scores.
+	local ids = {}
+	if isSet then
+		ids = redis.call('SORT', setKey, 'ALPHA', 'LIMIT', 0, n)
+	else
+		ids = redis.call('ZRANGE', setKey, 0, n-1)
+	end
+	for i, id in ipairs(ids) do
+		redis.call('ZADD', destKey, i, id)
+	end
+else
+	-- The ids are read in ascending order, so the last ids are the ones with
+	-- the highest scores.
+	local ids = {}
+	if isSet then
+		ids = redis.call('SORT', setKey, 'ALPHA', 'DESC', 'LIMIT', 0, n)
+	else
+		ids = redis.call('ZREVRANGE', setKey, 0, n-1)
+	end
+	for i, id in ipairs(ids) do
+		redis.call('ZADD', destKey, -i, id)
+	end
+end
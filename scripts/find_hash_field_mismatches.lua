@@ -0,0 +1,69 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- find_hash_field_mismatches is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+-- 	2) The key of a list of model ids, or an empty string if the ids are given
+--			directly
+-- 	3) n: The number of fields the model type has
+-- 	4) n arguments, one for each field of the model type, where each argument is
+--			the name of the field as it is stored in Redis
+-- 	5) Zero or more model ids (only if the second argument is empty)
+-- The script then compares the fields of the main hash of each model which
+-- exists with the fields of the model type. For each model whose hash has
+-- fields the model type does not have (unknown fields), or lacks fields the
+-- model type has (missing fields), it returns the id, the number of unknown
+-- fields, the unknown fields, the number of missing fields, and the missing
+-- fields, all in a single flat array.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local listKey = ARGV[2]
+local numFields = tonumber(ARGV[3])
+local fields = {}
+for i = 4, 3 + numFields do
+	fields[ARGV[i]] = true
+end
+local ids = {}
+if listKey ~= '' then
+	ids = redis.call('LRANGE', listKey, 0, -1)
+else
+	for i = 4 + numFields, #ARGV do
+		table.insert(ids, ARGV[i])
+	end
+end
+local result = {}
+for _, id in ipairs(ids) do
+	local keys = redis.call('HKEYS', collectionName .. ':' .. id)
+	if #keys > 0 then
+		local present = {}
+		local unknown = {}
+		for _, key in ipairs(keys) do
+			present[key] = true
+			if not fields[key] then
+				table.insert(unknown, key)
+			end
+		end
+		local missing = {}
+		for i = 4, 3 + numFields do
+			if not present[ARGV[i]] then
+				table.insert(missing, ARGV[i])
+			end
+		end
+		if #unknown > 0 or #missing > 0 then
+			table.insert(result, id)
+			table.insert(result, #unknown)
+			for _, key in ipairs(unknown) do
+				table.insert(result, key)
+			end
+			table.insert(result, #missing)
+			for _, key in ipairs(missing) do
+				table.insert(result, key)
+			end
+		end
+	end
+end
+return result
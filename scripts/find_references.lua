@@ -0,0 +1,33 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- find_references is a lua script that takes the following arguments:
+-- 	1) fieldIndexKey: The key of a sorted set for a string index on a field which
+--			holds the ids of models in another collection. Each member is of the form:
+--			value + NULL + id, where NULL is the ASCII NULL character and the value is
+--			escaped like the values of all string indexes.
+-- 	2) targetID: The id of a model in the other collection
+-- The script returns the ids of all the models whose value for the field is
+-- targetID, i.e. the models which reference the target model.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeString escapes the NULL and \1 characters in a string to get the format
+-- used in string indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local fieldIndexKey = ARGV[1]
+local targetID = ARGV[2]
+local value = encodeString(targetID)
+local members = redis.call('ZRANGEBYLEX', fieldIndexKey, '[' .. value .. '\0', '(' .. value .. '\0\255')
+local ids = {}
+for i, member in ipairs(members) do
+	local idStart = string.find(member, '%z[^%z]*$')
+	table.insert(ids, string.sub(member, idStart+1))
+end
+return ids
@@ -0,0 +1,126 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- get_set_model is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of the model to update
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	4) One or more groups of six arguments, one group for each field to be
+--			updated, exactly like the arguments for update_models_by_ids_list
+-- The script sets the given fields of the model and updates their field
+-- indexes accordingly, just like update_models_by_ids_list. It returns a list
+-- of the values that the given fields had before they were updated, in the same
+-- order as the groups of arguments, where a missing field is returned as nil.
+-- If the model does not exist, it returns nil and does not change anything.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local id = ARGV[2]
+local indexPrefix = ARGV[3]
+local key = collectionName .. ':' .. id
+if redis.call('EXISTS', key) == 0 then
+	return nil
+end
+local oldValues = {}
+for j = 4, #ARGV, 6 do
+	local fieldName = ARGV[j]
+	local value = ARGV[j+1]
+	local indexKind = ARGV[j+2]
+	local shouldIndex = ARGV[j+3] == '1'
+	local indexValue = ARGV[j+4]
+	local hasNullIndex = ARGV[j+5] == '1'
+	local indexKey = indexPrefix .. fieldName
+	local oldValue = redis.call('HGET', key, fieldName)
+	table.insert(oldValues, oldValue)
+	if indexKind == 'string' or indexKind == 'integer' then
+		-- Remove the old index (if any) before the hash is updated
+		if oldValue ~= false then
+			if indexKind == 'integer' then
+				oldValue = encodeInteger(oldValue)
+			else
+				oldValue = encodeString(oldValue)
+			end
+			redis.call('ZREM', indexKey, oldValue .. '\0' .. id)
+		end
+		-- The member of each model is also recorded in a separate hash, so that
+		-- sync_model_indexes can find it without scanning the index. It differs from
+		-- the one for the old value if the hash was modified outside of Zoom.
+		local membersKey = indexKey .. ':members'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if oldMember ~= false then
+			redis.call('ZREM', indexKey, oldMember)
+		end
+		if shouldIndex then
+			local member = indexValue .. '\0' .. id
+			redis.call('ZADD', indexKey, 0, member)
+			redis.call('HSET', membersKey, id, member)
+		else
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'collated' then
+		-- The member of each model in a collated string index is stored in a
+		-- separate hash, since the collation key cannot be computed here. The
+		-- index value is the collation key of the new value.
+		local membersKey = indexKey .. ':collated'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if oldMember ~= false then
+			redis.call('ZREM', indexKey, oldMember)
+		end
+		if shouldIndex then
+			local member = indexValue .. '\0' .. id
+			redis.call('ZADD', indexKey, 0, member)
+			redis.call('HSET', membersKey, id, member)
+		else
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'enum' then
+		-- Enum indexes also have a set of ids for each value. The old value is the
+		-- score in the sorted set.
+		local oldValue = redis.call('ZSCORE', indexKey, id)
+		if oldValue ~= false then
+			redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+		end
+		redis.call('ZADD', indexKey, indexValue, id)
+		redis.call('SADD', indexKey .. ':enum:' .. indexValue, id)
+	elseif indexKind ~= 'none' then
+		if shouldIndex then
+			redis.call('ZADD', indexKey, indexValue, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	end
+	if hasNullIndex then
+		-- The model is in the null index iff it is not in the regular index
+		if shouldIndex then
+			redis.call('SREM', indexKey .. ':null', id)
+		else
+			redis.call('SADD', indexKey .. ':null', id)
+		end
+	end
+	redis.call('HSET', key, fieldName, value)
+end
+return oldValues
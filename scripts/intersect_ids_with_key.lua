@@ -0,0 +1,35 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- intersect_ids_with_key is a lua script that takes the following arguments:
+-- 	1) origKey: The key of a set or sorted set of model ids
+--		2) idsKey: The key of an existing set, sorted set, or list of model ids
+-- 	3) destKey: The key of a sorted set where the resulting ids will be stored
+-- The script intersects the ids in origKey with the ids in idsKey and stores
+-- the result in destKey. The scores from origKey are preserved, so the order of
+-- the ids is not affected. If idsKey does not exist or is not a set, sorted
+-- set, or list, the result is empty.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local origKey = ARGV[1]
+local idsKey = ARGV[2]
+local destKey = ARGV[3]
+local keyType = redis.call('TYPE', idsKey)['ok']
+if keyType == 'set' or keyType == 'zset' then
+	redis.call('ZINTERSTORE', destKey, 2, origKey, idsKey, 'WEIGHTS', 1, 0)
+elseif keyType == 'list' then
+	-- ZINTERSTORE does not accept lists, so copy the ids into a temporary set
+	local tmpKey = destKey .. ':list'
+	redis.call('DEL', tmpKey)
+	local ids = redis.call('LRANGE', idsKey, 0, -1)
+	for i, id in ipairs(ids) do
+		redis.call('SADD', tmpKey, id)
+	end
+	redis.call('ZINTERSTORE', destKey, 2, origKey, tmpKey, 'WEIGHTS', 1, 0)
+	redis.call('DEL', tmpKey)
+else
+	redis.call('DEL', destKey)
+end
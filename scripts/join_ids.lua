@@ -0,0 +1,53 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- join_ids is a lua script that takes the following arguments:
+-- 	1) origKey: The key of a set or sorted set of model ids
+--		2) fieldIndexKey: The key of a sorted set for a string index on a field which
+--			holds the ids of models in another collection. Each member is of the form:
+--			value + NULL + id, where NULL is the ASCII NULL character and the value is
+--			escaped like the values of all string indexes.
+-- 	3) targetIDsKey: The key of a set or sorted set of ids of models in the other
+--			collection
+-- 	4) destKey: The key of a sorted set where the resulting ids will be stored
+-- The script finds the ids of all the models whose value for the field is one of
+-- the ids in targetIDsKey, intersects them with the ids in origKey and stores the
+-- result in destKey. The scores from origKey are preserved, so the order of the
+-- ids is not affected.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeString escapes the NULL and \1 characters in a string to get the format
+-- used in string indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local origKey = ARGV[1]
+local fieldIndexKey = ARGV[2]
+local targetIDsKey = ARGV[3]
+local destKey = ARGV[4]
+local targetIDs = {}
+local keyType = redis.call('TYPE', targetIDsKey)['ok']
+if keyType == 'set' then
+	targetIDs = redis.call('SMEMBERS', targetIDsKey)
+elseif keyType == 'zset' then
+	targetIDs = redis.call('ZRANGE', targetIDsKey, 0, -1)
+end
+-- Collect the ids of the models which reference one of the target ids in a
+-- temporary set
+local tmpKey = destKey .. ':join'
+redis.call('DEL', tmpKey)
+for i, targetID in ipairs(targetIDs) do
+	local value = encodeString(targetID)
+	local members = redis.call('ZRANGEBYLEX', fieldIndexKey, '[' .. value .. '\0', '(' .. value .. '\0\255')
+	for j, member in ipairs(members) do
+		local idStart = string.find(member, '%z[^%z]*$')
+		redis.call('SADD', tmpKey, string.sub(member, idStart+1))
+	end
+end
+redis.call('ZINTERSTORE', destKey, 2, origKey, tmpKey, 'WEIGHTS', 1, 0)
+redis.call('DEL', tmpKey)
@@ -0,0 +1,54 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- move_index_keys is a lua script that takes the following arguments:
+-- 	1) Zero or more pairs of arguments, where the first argument is the key of
+--			a set, sorted set, or bitmap which belongs to a field index (or the hash
+--			of members of a string index, whose key ends in ":collated" or
+--			":members") and the second is the key it should be moved to
+-- The script then moves each key which exists to its new key. If the new key
+-- already exists (e.g. because a model was saved with the new keys while the
+-- indexes were being moved), the old key is merged into it instead. Keys of any
+-- other type (in particular the main hash of a model whose id happens to be
+-- the same as the name of a field) are left alone. It returns the number of
+-- keys that were moved.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+local count = 0
+for i = 1, #ARGV, 2 do
+	local oldKey = ARGV[i]
+	local newKey = ARGV[i+1]
+	local keyType = redis.call('TYPE', oldKey)['ok']
+	local isMembersHash = keyType == 'hash' and
+		(string.sub(oldKey, -9) == ':collated' or string.sub(oldKey, -8) == ':members')
+	if keyType == 'zset' or keyType == 'set' or keyType == 'string' or isMembersHash then
+		local newType = redis.call('TYPE', newKey)['ok']
+		if newType == 'none' then
+			redis.call('RENAME', oldKey, newKey)
+			count = count + 1
+		elseif newType == keyType then
+			if keyType == 'zset' then
+				-- The scores in the new key are more recent, so they are kept.
+				local entries = redis.call('ZRANGE', oldKey, 0, -1, 'WITHSCORES')
+				for j = 1, #entries, 2 do
+					redis.call('ZADD', newKey, 'NX', entries[j+1], entries[j])
+				end
+			elseif keyType == 'set' then
+				redis.call('SUNIONSTORE', newKey, newKey, oldKey)
+			elseif keyType == 'hash' then
+				-- The members in the new key are more recent, so they are kept.
+				local entries = redis.call('HGETALL', oldKey)
+				for j = 1, #entries, 2 do
+					redis.call('HSETNX', newKey, entries[j], entries[j+1])
+				end
+			else
+				redis.call('BITOP', 'OR', newKey, newKey, oldKey)
+			end
+			redis.call('DEL', oldKey)
+			count = count + 1
+		end
+	end
+end
+return count
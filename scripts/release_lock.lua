@@ -0,0 +1,20 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- release_lock is a lua script that takes the following arguments:
+-- 	1) The key of a lock
+-- 	2) The token of the client which acquired the lock
+-- The script then deletes the lock iff it is still held with the given token,
+-- so that a client never releases a lock which has expired and been acquired
+-- by another client. It returns 1 if the lock was released and 0 otherwise.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local lockKey = ARGV[1]
+local token = ARGV[2]
+if redis.call('GET', lockKey) == token then
+	return redis.call('DEL', lockKey)
+end
+return 0
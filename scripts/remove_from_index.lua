@@ -0,0 +1,19 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- remove_from_index is a lua script that takes the following arguments:
+-- 	1) The key of the index of all models in a collection
+-- 	2) The id of a model
+-- The script removes the id from the index with SREM or ZREM, depending on
+-- whether the index is a set or a sorted set (see add_to_index.lua).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local indexKey = ARGV[1]
+local id = ARGV[2]
+if redis.call('TYPE', indexKey)['ok'] == 'zset' then
+	return redis.call('ZREM', indexKey, id)
+end
+return redis.call('SREM', indexKey, id)
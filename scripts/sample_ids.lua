@@ -0,0 +1,51 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- sample_ids is a lua script that takes the following arguments:
+-- 	1) setKey: The key of a set or sorted set of model ids
+-- 	2) destKey: The key of a sorted set where the resulting ids will be stored
+--		3) n: The number of ids to sample
+-- 	4) seed: A random number used to seed the random number generator
+-- The script picks n distinct ids from setKey at random (or all of them if
+-- there are fewer than n) and stores them in destKey. If setKey is a sorted
+-- set, the scores are preserved, so the sampled ids keep the same relative
+-- order they had in setKey. Otherwise every id has a score of 0.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local setKey = ARGV[1]
+local destKey = ARGV[2]
+local n = tonumber(ARGV[3])
+local seed = tonumber(ARGV[4])
+if n <= 0 then
+	return
+end
+local keyType = redis.call('TYPE', setKey)['ok']
+if keyType == 'set' then
+	local ids = redis.call('SRANDMEMBER', setKey, n)
+	for i, id in ipairs(ids) do
+		redis.call('ZADD', destKey, 0, id)
+	end
+elseif keyType == 'zset' then
+	local card = redis.call('ZCARD', setKey)
+	if n >= card then
+		redis.call('ZUNIONSTORE', destKey, 1, setKey)
+		return
+	end
+	-- Pick n distinct ranks with Floyd's algorithm, which only needs n random
+	-- numbers. Lua scripts are seeded with the same value every time, so we
+	-- need to seed the generator ourselves.
+	math.randomseed(seed)
+	local chosen = {}
+	for j = card - n + 1, card do
+		local rank = math.random(1, j)
+		if chosen[rank] then
+			rank = j
+		end
+		chosen[rank] = true
+		local idAndScore = redis.call('ZRANGE', setKey, rank - 1, rank - 1, 'WITHSCORES')
+		redis.call('ZADD', destKey, idAndScore[2], idAndScore[1])
+	end
+end
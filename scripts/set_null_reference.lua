@@ -0,0 +1,39 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- set_null_reference is a lua script that takes the following arguments:
+-- 	1) key: The key of the main hash of a model which references a model in
+--			another collection
+-- 	2) fieldName: The name of the field which holds the id of the referenced
+--			model, as it is stored in Redis
+-- 	3) fieldIndexKey: The key of the sorted set for the string index on the field
+-- 	4) targetID: The id of the referenced model, which is being deleted
+-- 	5) id: The id of the model which references it
+-- If the field of the model still holds targetID, the script sets it to an
+-- empty string and updates the string index accordingly. It returns 1 if the
+-- field was changed and 0 otherwise.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeString escapes the NULL and \1 characters in a string to get the format
+-- used in string indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local key = ARGV[1]
+local fieldName = ARGV[2]
+local fieldIndexKey = ARGV[3]
+local targetID = ARGV[4]
+local id = ARGV[5]
+if redis.call('HGET', key, fieldName) ~= targetID then
+	return 0
+end
+redis.call('HSET', key, fieldName, '')
+redis.call('ZREM', fieldIndexKey, encodeString(targetID) .. '\0' .. id)
+redis.call('ZADD', fieldIndexKey, 0, '\0' .. id)
+redis.call('HSET', fieldIndexKey .. ':members', id, '\0' .. id)
+return 1
@@ -0,0 +1,60 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- sort_models is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+-- 	2) The number of values the SORT command gets for each model, including
+--			the id (i.e. the number of GET options)
+-- 	3) "json" if the RedisJSON document of each model should be read, or else
+--			an empty string
+-- 	4) The number of fields stored in their own key which should be read
+-- 	5) For each of those fields, a pair of arguments, where the first argument
+--			is the name of the field as it is stored in Redis and the second is
+--			either "hash", "list", or "set"
+-- 	6) The arguments for a SORT command which gets the values of the models,
+--			e.g. as returned by sortArgs
+-- The script runs the SORT command and returns its reply, with two additions
+-- for each model. If the third argument is "json", the document of the model
+-- (read with JSON.GET, since SORT cannot get the fields of documents) is
+-- inserted before the id, just like in the reply to the SORT command which gets
+-- the documents of the models in the document layout. Then the contents of
+-- each of the fields which are stored in their own key are appended, in the
+-- same format as the reply to HGETALL (for hashes) or LRANGE and SMEMBERS (for
+-- lists and sets).
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local numValues = tonumber(ARGV[2])
+local getDocuments = ARGV[3] == 'json'
+local numKeyFields = tonumber(ARGV[4])
+local sortArgs = {}
+for i = 5 + 2 * numKeyFields, #ARGV do
+	table.insert(sortArgs, ARGV[i])
+end
+local values = redis.call('SORT', unpack(sortArgs))
+local result = {}
+for i = 1, #values, numValues do
+	for j = i, i + numValues - 2 do
+		table.insert(result, values[j])
+	end
+	local id = values[i + numValues - 1]
+	local key = collectionName .. ':' .. id
+	if getDocuments then
+		table.insert(result, redis.call('JSON.GET', key))
+	end
+	table.insert(result, id)
+	for j = 5, 4 + 2 * numKeyFields, 2 do
+		local fieldKey = key .. ':' .. ARGV[j]
+		if ARGV[j + 1] == 'hash' then
+			table.insert(result, redis.call('HGETALL', fieldKey))
+		elseif ARGV[j + 1] == 'list' then
+			table.insert(result, redis.call('LRANGE', fieldKey, 0, -1))
+		else
+			table.insert(result, redis.call('SMEMBERS', fieldKey))
+		end
+	end
+end
+return result
@@ -0,0 +1,166 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- sync_model_indexes is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of the model whose indexes should be synced
+-- 	3) "0" if the collection is not indexed, "1" if it is indexed (i.e. has a
+--			set of all ids), or "2" if it is indexed and a new set of all ids should
+--			be a sorted set. An existing set of all ids keeps its type.
+-- 	4) The score to use if the id is added to a sorted set of all ids, i.e. the
+--			time in milliseconds
+-- 	5) Zero or more groups of four arguments, one group for each indexed
+--			field, consisting of:
+--			a) The name of the field as it is stored in Redis
+--			b) The key of the index on the field
+--			c) The kind of index ("numeric", "boolean", "string", "integer",
+--				"collated", or "enum")
+--			d) "1" if the field is a pointer (i.e. "NULL" means nil and the field
+--				has a null index) and "0" otherwise
+-- The script reads the current field values from the model hash and makes the
+-- field indexes (and the set of all ids) consistent with them. If the hash no
+-- longer exists, the model is removed from all indexes. It is intended to be
+-- used to repair indexes after a model hash was modified outside of Zoom.
+-- Since the collation keys cannot be computed here, the model is only removed
+-- from a collated string index if the field has no value. Otherwise the caller
+-- is responsible for updating the index. The old member of the model in a string
+-- (or integer) index is found in the hash of members which Zoom keeps next to
+-- the index (see stringMembersKey in model.go), so a member which is not
+-- recorded there is not removed.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local id = ARGV[2]
+local indexAll = ARGV[3] ~= '0'
+local sortedIndex = ARGV[3] == '2'
+local score = ARGV[4]
+local key = collectionName .. ':' .. id
+local exists = redis.call('EXISTS', key) == 1
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+for j = 5, #ARGV, 4 do
+	local fieldName = ARGV[j]
+	local indexKey = ARGV[j+1]
+	local indexKind = ARGV[j+2]
+	local nullable = ARGV[j+3] == '1'
+	local value = false
+	local isNull = false
+	if exists then
+		value = redis.call('HGET', key, fieldName)
+		if nullable and (value == 'NULL' or value == false) then
+			value = false
+			isNull = true
+		end
+	end
+	if nullable then
+		-- Pointer fields have a null index which contains the ids of the models
+		-- for which the field is nil
+		if isNull then
+			redis.call('SADD', indexKey .. ':null', id)
+		else
+			redis.call('SREM', indexKey .. ':null', id)
+		end
+	end
+	if indexKind == 'string' or indexKind == 'integer' then
+		local member = false
+		if value ~= false then
+			if indexKind == 'integer' then
+				value = encodeInteger(value)
+			else
+				value = encodeString(value)
+			end
+			member = value .. '\0' .. id
+		end
+		-- The current member of the model (if any) is recorded in a separate hash,
+		-- so there is no need to scan the index for it
+		local membersKey = indexKey .. ':members'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if oldMember ~= false and oldMember ~= member then
+			redis.call('ZREM', indexKey, oldMember)
+		end
+		if member ~= false then
+			redis.call('ZADD', indexKey, 0, member)
+			redis.call('HSET', membersKey, id, member)
+		else
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'collated' then
+		-- The member of each model in a collated string index is stored in a
+		-- separate hash
+		local membersKey = indexKey .. ':collated'
+		local oldMember = redis.call('HGET', membersKey, id)
+		if value == false and oldMember ~= false then
+			redis.call('ZREM', indexKey, oldMember)
+			redis.call('HDEL', membersKey, id)
+		end
+	elseif indexKind == 'enum' then
+		-- Enum indexes also have a set of ids for each value. The old value is the
+		-- score in the sorted set.
+		local oldValue = redis.call('ZSCORE', indexKey, id)
+		if oldValue ~= false then
+			redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+		end
+		if value ~= false then
+			redis.call('ZADD', indexKey, value, id)
+			redis.call('SADD', indexKey .. ':enum:' .. value, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	else
+		local score = nil
+		if value ~= false then
+			if indexKind == 'boolean' then
+				if value == '1' or value == 'true' then
+					score = 1
+				elseif value == '0' or value == 'false' then
+					score = 0
+				end
+			else
+				score = tonumber(value)
+			end
+		end
+		if score ~= nil then
+			redis.call('ZADD', indexKey, score, id)
+		else
+			redis.call('ZREM', indexKey, id)
+		end
+	end
+end
+
+if indexAll then
+	local allKey = collectionName .. ':all'
+	local allType = redis.call('TYPE', allKey)['ok']
+	if allType == 'zset' or (allType == 'none' and sortedIndex) then
+		if exists then
+			redis.call('ZADD', allKey, 'NX', score, id)
+		else
+			redis.call('ZREM', allKey, id)
+		end
+	elseif exists then
+		redis.call('SADD', allKey, id)
+	else
+		redis.call('SREM', allKey, id)
+	end
+end
@@ -0,0 +1,103 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_bitmap_index is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of the model, or the key of a list of model ids if the mode is
+--			"synclist"
+--		3) The mode, which is one of:
+--			"save": the remaining arguments are pairs of the name of a field with a
+--				bitmap index and the new value of the field
+--			"sync": the remaining arguments are the names of fields with bitmap
+--				indexes, and the values are read from the model hash
+--			"delete": the remaining arguments are the names of all the fields with
+--				bitmap indexes, and the model is removed from the bitmaps and its
+--				offset is released
+--			"synclist": like "sync" but for each model in the list
+--		4) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- Each model is assigned a unique offset (i.e. bit position) the first time it
+-- is added to a bitmap index. The offsets are stored in the hash
+-- <collection>:bitmap:offsets and the reverse mapping is stored in the hash
+-- <collection>:bitmap:ids. The bitmap for a particular value of a field is stored
+-- in the key <prefix><field>:bitmap:<value>, and the set of all values is
+-- stored in <prefix><field>:bitmap. Since bitmap indexes are only used for
+-- fields with a small number of distinct values, the script simply clears the bit
+-- for the model in the bitmap for every value before setting the new one.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local mode = ARGV[3]
+local indexPrefix = ARGV[4]
+local offsetsKey = collectionName .. ':bitmap:offsets'
+local idsKey = collectionName .. ':bitmap:ids'
+
+-- getOffset returns the offset for the model, assigning a new one if needed.
+-- If the model does not have an offset and create is false, it returns false.
+local function getOffset(modelID, create)
+	local offset = redis.call('HGET', offsetsKey, modelID)
+	if offset == false and create then
+		offset = redis.call('INCR', collectionName .. ':bitmap:next') - 1
+		redis.call('HSET', offsetsKey, modelID, offset)
+		redis.call('HSET', idsKey, offset, modelID)
+	end
+	return offset
+end
+
+-- clearBit removes the model from the bitmaps for every value of the field.
+local function clearBit(valuesKey, offset)
+	local values = redis.call('SMEMBERS', valuesKey)
+	for i, value in ipairs(values) do
+		redis.call('SETBIT', valuesKey .. ':' .. value, offset, 0)
+	end
+end
+
+-- setBit adds the model to the bitmap for the given value of the field.
+local function setBit(valuesKey, value, offset)
+	redis.call('SADD', valuesKey, value)
+	redis.call('SETBIT', valuesKey .. ':' .. value, offset, 1)
+end
+
+-- sync updates the bitmaps for the model based on the values in the model hash.
+local function sync(modelID)
+	local offset = getOffset(modelID, true)
+	local modelKey = collectionName .. ':' .. modelID
+	for i = 5, #ARGV do
+		local valuesKey = indexPrefix .. ARGV[i] .. ':bitmap'
+		clearBit(valuesKey, offset)
+		local value = redis.call('HGET', modelKey, ARGV[i])
+		if value ~= false then
+			setBit(valuesKey, value, offset)
+		end
+	end
+end
+
+if mode == 'save' then
+	local offset = getOffset(ARGV[2], true)
+	for i = 5, #ARGV, 2 do
+		local valuesKey = indexPrefix .. ARGV[i] .. ':bitmap'
+		clearBit(valuesKey, offset)
+		setBit(valuesKey, ARGV[i + 1], offset)
+	end
+elseif mode == 'sync' then
+	sync(ARGV[2])
+elseif mode == 'synclist' then
+	local modelIDs = redis.call('LRANGE', ARGV[2], 0, -1)
+	for i, modelID in ipairs(modelIDs) do
+		sync(modelID)
+	end
+else
+	local offset = getOffset(ARGV[2], false)
+	if offset == false then
+		-- The model was never added to a bitmap index
+		return
+	end
+	for i = 5, #ARGV do
+		clearBit(indexPrefix .. ARGV[i] .. ':bitmap', offset)
+	end
+	redis.call('HDEL', offsetsKey, ARGV[2])
+	redis.call('HDEL', idsKey, offset)
+end
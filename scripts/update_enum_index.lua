@@ -0,0 +1,36 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_enum_index is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The name of the enum field as it is stored in Redis
+--		3) The id of the model
+--		4) The new value of the field (i.e. the position of the value in the list
+--			of allowed values), or an empty string if the model should be removed
+--			from the index
+--		5) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- An enum index consists of a sorted set of ids, where the score of each id is
+-- the value of the field, and a set of ids for each value. The script removes
+-- the model from the set for its old value (which is read from the sorted set)
+-- and adds it to the sorted set and the set for its new value.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local fieldName = ARGV[2]
+local id = ARGV[3]
+local value = ARGV[4]
+local indexKey = ARGV[5] .. fieldName
+local oldValue = redis.call('ZSCORE', indexKey, id)
+if oldValue ~= false then
+	redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+end
+if value == '' then
+	redis.call('ZREM', indexKey, id)
+else
+	redis.call('ZADD', indexKey, value, id)
+	redis.call('SADD', indexKey .. ':enum:' .. value, id)
+end
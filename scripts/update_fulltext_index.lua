@@ -0,0 +1,36 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_fulltext_index is a lua script that takes the following arguments:
+-- 	1) The name of a registered model
+--		2) The id of a model
+-- 	3) The name of a field with a full-text index, as it is stored in Redis
+--		4) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	5) Zero or more terms which should be indexed for the field
+-- The script first removes the model id from the sets for each term that was
+-- previously indexed for the field (which are kept in a set with the key
+-- <name>:<id>:<field>:fulltext). Then it adds the model id to the set for each
+-- of the given terms and records the terms so they can be removed later.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- Assign keys to variables for easy access
+local collectionName = ARGV[1]
+local id = ARGV[2]
+local fieldName = ARGV[3]
+local termsKey = collectionName .. ':' .. id .. ':' .. fieldName .. ':fulltext'
+local termKeyPrefix = ARGV[4] .. fieldName .. ':fulltext:'
+-- Remove the old terms (if any)
+local oldTerms = redis.call('SMEMBERS', termsKey)
+for i, term in ipairs(oldTerms) do
+	redis.call('SREM', termKeyPrefix .. term, id)
+end
+redis.call('DEL', termsKey)
+-- Add the new terms
+for i = 5, #ARGV do
+	local term = ARGV[i]
+	redis.call('SADD', termKeyPrefix .. term, id)
+	redis.call('SADD', termsKey, term)
+end
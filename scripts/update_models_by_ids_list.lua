@@ -0,0 +1,138 @@
+-- Copyright 2015 Alex Browne.  All rights reserved.
+-- Use of this source code is governed by the MIT
+-- license, which can be found in the LICENSE file.
+
+-- update_models_by_ids_list is a lua script that takes the following arguments:
+-- 	1) The key of a list of model ids
+--		2) The name of a registered model
+--		3) The prefix of the keys of the field indexes, i.e. the name of the model
+--			followed by a colon (and "index:" if it uses the index namespace)
+-- 	4) One or more groups of six arguments, one group for each field to be
+--			updated, consisting of:
+--			a) The name of the field as it is stored in Redis
+--			b) The new value for the field as it should be stored in the hash
+--			c) The kind of index on the field ("none", "numeric", "boolean",
+--				"string", "integer", "collated", or "enum")
+--			d) "1" if the model should be added to the index and "0" if it should
+--				only be removed from the index (e.g. for nil pointers)
+--			e) The score (for numeric and boolean indexes) or the string value (for
+--				string, integer, and collated indexes) to store in the index
+--			f) "1" if the field has a null index (i.e. it is an indexed pointer
+--				field) and "0" otherwise
+-- The script then sets the given fields for all the models corresponding to the
+-- ids in the given list and updates their field indexes accordingly. Ids which
+-- do not correspond to an existing model are skipped. It returns the number of
+-- models that were updated. It does not delete the given list.
+
+-- IMPORTANT: If you edit this file, you must run go generate . to rewrite ../scripts.go
+
+-- encodeInteger converts the value of an int64 or uint64 field, as it is stored
+-- in the model hash, to the format used in integer indexes. See
+-- integerIndexValue in util.go.
+local function encodeInteger(value)
+	if string.sub(value, 1, 1) == '-' then
+		local digits = string.sub(value, 2)
+		digits = string.rep('0', 20 - #digits) .. digits
+		return '0' .. (string.gsub(digits, '%d', function(d) return tostring(9 - tonumber(d)) end))
+	end
+	return '1' .. string.rep('0', 20 - #value) .. value
+end
+
+-- encodeString escapes the NULL and \1 characters in the value of a string
+-- field, as it is stored in the model hash, to get the format used in string
+-- indexes. See encodeStringIndexValue in util.go.
+local function encodeString(value)
+	value = string.gsub(value, '\1', '\1\2')
+	return (string.gsub(value, '%z', '\1\1'))
+end
+
+-- Assign keys to variables for easy access
+local listKey = ARGV[1]
+local collectionName = ARGV[2]
+local indexPrefix = ARGV[3]
+-- Get all the ids from the list
+local ids = redis.call('LRANGE', listKey, 0, -1)
+local count = 0
+for i, id in ipairs(ids) do
+	local key = collectionName .. ':' .. id
+	if redis.call('EXISTS', key) == 1 then
+		for j = 4, #ARGV, 6 do
+			local fieldName = ARGV[j]
+			local value = ARGV[j+1]
+			local indexKind = ARGV[j+2]
+			local shouldIndex = ARGV[j+3] == '1'
+			local indexValue = ARGV[j+4]
+			local hasNullIndex = ARGV[j+5] == '1'
+			local indexKey = indexPrefix .. fieldName
+			if indexKind == 'string' or indexKind == 'integer' then
+				-- Remove the old index (if any) before the hash is updated
+				local oldValue = redis.call('HGET', key, fieldName)
+				if oldValue ~= false then
+					if indexKind == 'integer' then
+						oldValue = encodeInteger(oldValue)
+					else
+						oldValue = encodeString(oldValue)
+					end
+					redis.call('ZREM', indexKey, oldValue .. '\0' .. id)
+				end
+				-- The member of each model is also recorded in a separate hash, so that
+				-- sync_model_indexes can find it without scanning the index. It differs from
+				-- the one for the old value if the hash was modified outside of Zoom.
+				local membersKey = indexKey .. ':members'
+				local oldMember = redis.call('HGET', membersKey, id)
+				if oldMember ~= false then
+					redis.call('ZREM', indexKey, oldMember)
+				end
+				if shouldIndex then
+					local member = indexValue .. '\0' .. id
+					redis.call('ZADD', indexKey, 0, member)
+					redis.call('HSET', membersKey, id, member)
+				else
+					redis.call('HDEL', membersKey, id)
+				end
+			elseif indexKind == 'collated' then
+				-- The member of each model in a collated string index is stored in a
+				-- separate hash, since the collation key cannot be computed here. The
+				-- index value is the collation key of the new value.
+				local membersKey = indexKey .. ':collated'
+				local oldMember = redis.call('HGET', membersKey, id)
+				if oldMember ~= false then
+					redis.call('ZREM', indexKey, oldMember)
+				end
+				if shouldIndex then
+					local member = indexValue .. '\0' .. id
+					redis.call('ZADD', indexKey, 0, member)
+					redis.call('HSET', membersKey, id, member)
+				else
+					redis.call('HDEL', membersKey, id)
+				end
+			elseif indexKind == 'enum' then
+				-- Enum indexes also have a set of ids for each value. The old value is the
+				-- score in the sorted set.
+				local oldValue = redis.call('ZSCORE', indexKey, id)
+				if oldValue ~= false then
+					redis.call('SREM', indexKey .. ':enum:' .. oldValue, id)
+				end
+				redis.call('ZADD', indexKey, indexValue, id)
+				redis.call('SADD', indexKey .. ':enum:' .. indexValue, id)
+			elseif indexKind ~= 'none' then
+				if shouldIndex then
+					redis.call('ZADD', indexKey, indexValue, id)
+				else
+					redis.call('ZREM', indexKey, id)
+				end
+			end
+			if hasNullIndex then
+				-- The model is in the null index iff it is not in the regular index
+				if shouldIndex then
+					redis.call('SREM', indexKey .. ':null', id)
+				else
+					redis.call('SADD', indexKey .. ':null', id)
+				end
+			end
+			redis.call('HSET', key, fieldName, value)
+		end
+		count = count + 1
+	end
+end
+return count
@@ -91,7 +91,7 @@ func TestDeleteStringIndexScript(t *testing.T) {
 
 	// Run the script before saving the hash, to make sure it does not cause an error
 	tx := testPool.NewTransaction()
-	tx.deleteStringIndex(stringIndexModels.Name(), model.ModelID(), "String")
+	tx.deleteStringIndex(stringIndexModels.spec, model.ModelID(), stringIndexModels.spec.fieldsByName["String"])
 	if err := tx.Exec(); err != nil {
 		t.Fatalf("Unexected error in tx.Exec: %s", err.Error())
 	}
@@ -118,7 +118,7 @@ func TestDeleteStringIndexScript(t *testing.T) {
 
 	// Run the script again. This time we expect the index to be removed
 	tx = testPool.NewTransaction()
-	tx.deleteStringIndex(stringIndexModels.Name(), model.ModelID(), "String")
+	tx.deleteStringIndex(stringIndexModels.spec, model.ModelID(), stringIndexModels.spec.fieldsByName["String"])
 	if err := tx.Exec(); err != nil {
 		t.Fatalf("Unexected error in tx.Exec: %s", err.Error())
 	}
@@ -214,7 +214,7 @@ func TestExtractIDsFromStringIndexScript(t *testing.T) {
 			expectedIDs: modelIDs(Models(models[2:])),
 		},
 		{
-			min:         "(2" + delString,
+			min:         "(2" + maxByteString,
 			max:         "+",
 			expectedIDs: modelIDs(Models(models[3:])),
 		},
@@ -0,0 +1,664 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File sharded_pool.go contains code related to ShardedPool, which spreads
+// models across several independent Redis instances.
+
+package zoom
+
+import (
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// shardVirtualNodes is the number of points each shard has on the consistent
+// hashing ring. More points result in a more even distribution of models.
+const shardVirtualNodes = 160
+
+// ShardedPool is a set of Pools, each of which connects to a different Redis
+// instance (a shard). It can be used to scale horizontally if you cannot run
+// Redis Cluster. Models are assigned to shards by consistently hashing their
+// ids, so each model, along with its index entries, lives on exactly one shard.
+// Operations on a single model only touch the shard that owns it, while
+// queries, counts, and other operations on the whole collection are fanned out
+// to all the shards and the results are merged. Since transactions cannot span
+// more than one Redis instance, operations which involve more than one shard
+// are not atomic. If you need a transaction, use the Pool for a specific shard
+// (see ShardedPool.Shard).
+type ShardedPool struct {
+	pools []*Pool
+	// ring contains the points of all the shards on the consistent hashing
+	// ring, sorted by hash.
+	ring []shardRingPoint
+}
+
+// shardRingPoint is a single point on the consistent hashing ring.
+type shardRingPoint struct {
+	hash  uint32
+	shard int
+}
+
+// NewShardedPool creates and returns a new ShardedPool with one shard for each
+// of the given addresses. All the other options will be set to their default
+// values, which can be found in DefaultPoolOptions.
+func NewShardedPool(addresses ...string) (*ShardedPool, error) {
+	options := make([]PoolOptions, len(addresses))
+	for i, address := range addresses {
+		options[i] = DefaultPoolOptions.WithAddress(address)
+	}
+	return NewShardedPoolWithOptions(options...)
+}
+
+// NewShardedPoolWithOptions creates and returns a new ShardedPool with one
+// shard for each of the given options. The position of each shard on the
+// consistent hashing ring is determined by its network, address, and
+// database, so the same models are assigned to the same shards even if the
+// options are given in a different order. Adding a shard only moves about 1/N
+// of the models to the new shard, but Zoom does not move them for you. It
+// returns an error if no options are given or if two options identify the
+// same shard.
+func NewShardedPoolWithOptions(options ...PoolOptions) (*ShardedPool, error) {
+	if len(options) == 0 {
+		return nil, fmt.Errorf("zoom: Error in NewShardedPool: at least one shard is required")
+	}
+	sp := &ShardedPool{}
+	names := map[string]bool{}
+	for i, opts := range options {
+		name := opts.Network + "://" + opts.Address + "/" + strconv.Itoa(opts.Database)
+		if names[name] {
+			return nil, fmt.Errorf("zoom: Error in NewShardedPool: more than one shard uses %s", name)
+		}
+		names[name] = true
+		for v := 0; v < shardVirtualNodes; v++ {
+			sp.ring = append(sp.ring, shardRingPoint{
+				hash:  crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(v))),
+				shard: i,
+			})
+		}
+	}
+	sort.Slice(sp.ring, func(i, j int) bool {
+		return sp.ring[i].hash < sp.ring[j].hash
+	})
+	for _, opts := range options {
+		sp.pools = append(sp.pools, NewPoolWithOptions(opts))
+	}
+	return sp, nil
+}
+
+// Pools returns the Pools for all the shards, in the order in which they were
+// given to NewShardedPool.
+func (sp *ShardedPool) Pools() []*Pool {
+	return sp.pools
+}
+
+// Shard returns the Pool for the shard which owns the model with the given id.
+// Use it to create a transaction which only involves models on that shard.
+func (sp *ShardedPool) Shard(id string) *Pool {
+	return sp.pools[sp.shardIndex(id)]
+}
+
+// shardIndex returns the index of the shard which owns the model with the
+// given id.
+func (sp *ShardedPool) shardIndex(id string) int {
+	hash := crc32.ChecksumIEEE([]byte(id))
+	i := sort.Search(len(sp.ring), func(i int) bool {
+		return sp.ring[i].hash >= hash
+	})
+	if i == len(sp.ring) {
+		i = 0
+	}
+	return sp.ring[i].shard
+}
+
+// Close closes the Pools for all the shards and returns the first error (if
+// any).
+func (sp *ShardedPool) Close() error {
+	var firstErr error
+	for _, pool := range sp.pools {
+		if err := pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ShardedCollection is a collection of models which are spread across the
+// shards of a ShardedPool. It has the same methods as Collection. Use the
+// NewCollection method of ShardedPool to create one.
+type ShardedCollection struct {
+	pool   *ShardedPool
+	shards []*Collection
+}
+
+// NewCollection registers and returns a new sharded collection of the given
+// model type, using the default options. See Pool.NewCollection.
+func (sp *ShardedPool) NewCollection(model Model) (*ShardedCollection, error) {
+	return sp.NewCollectionWithOptions(model, DefaultCollectionOptions)
+}
+
+// NewCollectionWithOptions registers and returns a new sharded collection of
+// the given model type with the given options. The collection is registered
+// with the Pool for each shard. See Pool.NewCollectionWithOptions.
+func (sp *ShardedPool) NewCollectionWithOptions(model Model, options CollectionOptions) (*ShardedCollection, error) {
+	sc := &ShardedCollection{pool: sp}
+	for _, pool := range sp.pools {
+		c, err := pool.NewCollectionWithOptions(model, options)
+		if err != nil {
+			return nil, err
+		}
+		sc.shards = append(sc.shards, c)
+	}
+	return sc, nil
+}
+
+// Name returns the name of the collection.
+func (sc *ShardedCollection) Name() string {
+	return sc.shards[0].Name()
+}
+
+// ModelKey returns the key that identifies a hash in the database which
+// contains all the fields of the model with the given id. The key is the same
+// on every shard, but only the shard returned by Shard holds the model.
+func (sc *ShardedCollection) ModelKey(id string) string {
+	return sc.shards[0].ModelKey(id)
+}
+
+// Shard returns the Collection for the shard which owns the model with the
+// given id.
+func (sc *ShardedCollection) Shard(id string) *Collection {
+	return sc.shards[sc.pool.shardIndex(id)]
+}
+
+// Shards returns the Collections for all the shards, in the same order as
+// ShardedPool.Pools.
+func (sc *ShardedCollection) Shards() []*Collection {
+	return sc.shards
+}
+
+// Save is like Collection.Save. The model is saved on the shard which owns it.
+func (sc *ShardedCollection) Save(model Model) error {
+	return sc.Shard(sc.shards[0].spec.idForModel(model)).Save(model)
+}
+
+// SaveFields is like Collection.SaveFields. The model is saved on the shard
+// which owns it.
+func (sc *ShardedCollection) SaveFields(fieldNames []string, model Model) error {
+	return sc.Shard(sc.shards[0].spec.idForModel(model)).SaveFields(fieldNames, model)
+}
+
+// SaveChanged is like Collection.SaveChanged. The model is saved on the shard
+// which owns it.
+func (sc *ShardedCollection) SaveChanged(model Model) error {
+	return sc.Shard(sc.shards[0].spec.idForModel(model)).SaveChanged(model)
+}
+
+// GetSet is like Collection.GetSet. The model is updated on the shard which
+// owns it.
+func (sc *ShardedCollection) GetSet(id string, fieldValues map[string]interface{}, old Model) error {
+	return sc.Shard(id).GetSet(id, fieldValues, old)
+}
+
+// Find is like Collection.Find. Only the shard which owns the model is read.
+func (sc *ShardedCollection) Find(id string, model Model) error {
+	return sc.Shard(id).Find(id, model)
+}
+
+// Lock is like Collection.Lock. The lock is stored on the shard which owns the
+// model.
+func (sc *ShardedCollection) Lock(id string, ttl time.Duration) (*Lock, error) {
+	return sc.Shard(id).Lock(id, ttl)
+}
+
+// FindWith is like Collection.FindWith. Only the shard which owns the model is
+// read.
+func (sc *ShardedCollection) FindWith(id string, model Model, fieldNames ...string) error {
+	return sc.Shard(id).FindWith(id, model, fieldNames...)
+}
+
+// FindWithPresence is like Collection.FindWithPresence. Only the shard which
+// owns the model is read.
+func (sc *ShardedCollection) FindWithPresence(id string, model Model) (map[string]bool, error) {
+	return sc.Shard(id).FindWithPresence(id, model)
+}
+
+// FindFields is like Collection.FindFields. Only the shard which owns the
+// model is read.
+func (sc *ShardedCollection) FindFields(id string, fieldNames []string, model Model) error {
+	return sc.Shard(id).FindFields(id, fieldNames, model)
+}
+
+// Exists is like Collection.Exists.
+func (sc *ShardedCollection) Exists(id string) (bool, error) {
+	return sc.Shard(id).Exists(id)
+}
+
+// ExistsMany is like Collection.ExistsMany. The ids are grouped by the shard
+// which owns them, so there is one round trip for each of those shards.
+func (sc *ShardedCollection) ExistsMany(ids []string) (map[string]bool, error) {
+	idsByShard := map[*Collection][]string{}
+	for _, id := range ids {
+		shard := sc.Shard(id)
+		idsByShard[shard] = append(idsByShard[shard], id)
+	}
+	exists := make(map[string]bool, len(ids))
+	for _, shard := range sc.Shards() {
+		if len(idsByShard[shard]) == 0 {
+			continue
+		}
+		shardExists, err := shard.ExistsMany(idsByShard[shard])
+		if err != nil {
+			return nil, err
+		}
+		for id, found := range shardExists {
+			exists[id] = found
+		}
+	}
+	return exists, nil
+}
+
+// Delete is like Collection.Delete.
+func (sc *ShardedCollection) Delete(id string) (bool, error) {
+	return sc.Shard(id).Delete(id)
+}
+
+// FindAll is like Collection.FindAll. It finds the models on each shard and
+// concatenates the results in shard order.
+func (sc *ShardedCollection) FindAll(models interface{}) error {
+	return sc.collectFromShards(models, func(c *Collection, shardModels interface{}) error {
+		return c.FindAll(shardModels)
+	})
+}
+
+// Count is like Collection.Count. It returns the total number of models on all
+// the shards.
+func (sc *ShardedCollection) Count() (int, error) {
+	total := 0
+	for _, c := range sc.shards {
+		count, err := c.Count()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// DeleteAll is like Collection.DeleteAll. It deletes the models on all the
+// shards and returns the total number of models deleted. Each shard is deleted
+// atomically, but the shards are not deleted in a single transaction.
+func (sc *ShardedCollection) DeleteAll() (int, error) {
+	total := 0
+	for _, c := range sc.shards {
+		count, err := c.DeleteAll()
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// collectFromShards calls find for each shard with a new slice of the same
+// type as models and then sets models to the concatenation of the results.
+// models must be a pointer to a slice of models.
+func (sc *ShardedCollection) collectFromShards(models interface{}, find func(c *Collection, shardModels interface{}) error) error {
+	if err := sc.shards[0].spec.checkModelsType(models); err != nil {
+		return err
+	}
+	results := reflect.ValueOf(models).Elem()
+	all := reflect.MakeSlice(results.Type(), 0, 0)
+	for _, c := range sc.shards {
+		shardModels := reflect.New(results.Type())
+		if err := find(c, shardModels.Interface()); err != nil {
+			return err
+		}
+		all = reflect.AppendSlice(all, shardModels.Elem())
+	}
+	results.Set(all)
+	return nil
+}
+
+// NewQuery is like Collection.NewQuery but returns a ShardedQuery, which runs
+// the query on every shard and merges the results.
+func (sc *ShardedCollection) NewQuery() *ShardedQuery {
+	sq := &ShardedQuery{collection: sc}
+	for _, c := range sc.shards {
+		sq.queries = append(sq.queries, c.NewQuery())
+	}
+	return sq
+}
+
+// ShardedQuery is a query which is run on every shard of a ShardedCollection
+// concurrently. The results from the shards are merged according to Order, and
+// then Limit and Offset are applied to the merged results. It supports the same
+// modifiers as Query, except for Last, FromIDSet, and Join.
+type ShardedQuery struct {
+	collection *ShardedCollection
+	// queries contains one query for each shard. limit and offset are not
+	// applied to them until the query is run.
+	queries []*Query
+	limit   uint
+	offset  uint
+}
+
+// String returns a string representation of the query.
+func (sq *ShardedQuery) String() string {
+	q := *sq.queries[0].query
+	q.limit = sq.limit
+	q.offset = sq.offset
+	return q.String()
+}
+
+// Order is like Query.Order.
+func (sq *ShardedQuery) Order(fieldName string) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Order(fieldName)
+	}
+	return sq
+}
+
+// Limit is like Query.Limit.
+func (sq *ShardedQuery) Limit(amount uint) *ShardedQuery {
+	sq.limit = amount
+	return sq
+}
+
+// Offset is like Query.Offset.
+func (sq *ShardedQuery) Offset(amount uint) *ShardedQuery {
+	sq.offset = amount
+	return sq
+}
+
+// Include is like Query.Include.
+func (sq *ShardedQuery) Include(fields ...string) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Include(fields...)
+	}
+	return sq
+}
+
+// Unscoped is like Query.Unscoped.
+func (sq *ShardedQuery) Unscoped() *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Unscoped()
+	}
+	return sq
+}
+
+// WithValue is like Query.WithValue.
+func (sq *ShardedQuery) WithValue(key, value interface{}) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.WithValue(key, value)
+	}
+	return sq
+}
+
+// Exclude is like Query.Exclude.
+func (sq *ShardedQuery) Exclude(fields ...string) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Exclude(fields...)
+	}
+	return sq
+}
+
+// Filter is like Query.Filter.
+func (sq *ShardedQuery) Filter(filterString string, value interface{}) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Filter(filterString, value)
+	}
+	return sq
+}
+
+// FilterRange is like Query.FilterRange.
+func (sq *ShardedQuery) FilterRange(fieldName string, min interface{}, max interface{}, inclusive bool) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.FilterRange(fieldName, min, max, inclusive)
+	}
+	return sq
+}
+
+// Search is like Query.Search.
+func (sq *ShardedQuery) Search(fieldName string, text string) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Search(fieldName, text)
+	}
+	return sq
+}
+
+// Timeout is like Query.Timeout. The timeout applies to each shard.
+func (sq *ShardedQuery) Timeout(d time.Duration) *ShardedQuery {
+	for _, q := range sq.queries {
+		q.Timeout(d)
+	}
+	return sq
+}
+
+// shardQuery returns a copy of the query for the given shard which returns at
+// most offset + limit models (i.e. enough models to apply the limit and offset
+// after the results are merged).
+func (sq *ShardedQuery) shardQuery(shard int) *query {
+	q := *sq.queries[shard].query
+	q.offset = 0
+	q.limit = 0
+	if sq.limit != 0 {
+		q.limit = sq.offset + sq.limit
+	}
+	return &q
+}
+
+// runShards runs each shard query concurrently and sets models to the merged
+// results, sorted according to the order of the query, with the limit and
+// offset applied. models must be a pointer to a slice of models. If any of the
+// shard queries fails, runShards returns the first error after all of them
+// have finished.
+func (sq *ShardedQuery) runShards(models interface{}, modify func(q *query)) error {
+	if err := sq.collection.shards[0].spec.checkModelsType(models); err != nil {
+		return err
+	}
+	results := reflect.ValueOf(models).Elem()
+	shardResults := make([]reflect.Value, len(sq.queries))
+	errs := make(chan error, len(sq.queries))
+	wg := sync.WaitGroup{}
+	for i := range sq.queries {
+		q := sq.shardQuery(i)
+		if modify != nil {
+			modify(q)
+		}
+		shardResults[i] = reflect.New(results.Type())
+		wg.Add(1)
+		go func(q *query, shardModels reflect.Value) {
+			defer wg.Done()
+			tx := q.pool.NewTransaction().Timeout(q.timeout)
+			newTransactionQuery(q, tx).Run(shardModels.Interface())
+			if err := tx.Exec(); err != nil {
+				errs <- err
+			}
+		}(q, shardResults[i])
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	all := reflect.MakeSlice(results.Type(), 0, 0)
+	for _, shardModels := range shardResults {
+		all = reflect.AppendSlice(all, shardModels.Elem())
+	}
+	if order := sq.queries[0].order; order.fieldName != "" {
+		fs := sq.queries[0].collection.spec.fieldsByName[order.fieldName]
+		// Nested fields (e.g. Address.City) can only be accessed by their index.
+		fieldValue := func(model reflect.Value) reflect.Value {
+			if fs != nil && len(fs.index) > 0 {
+				return model.Elem().FieldByIndex(fs.index)
+			}
+			return model.Elem().FieldByName(order.fieldName)
+		}
+		sort.SliceStable(all.Interface(), func(i, j int) bool {
+			a := fieldValue(all.Index(i))
+			b := fieldValue(all.Index(j))
+			if order.kind == descendingOrder {
+				return lessIndexValue(fs, b, a)
+			}
+			return lessIndexValue(fs, a, b)
+		})
+	}
+	start := int(sq.offset)
+	if start > all.Len() {
+		start = all.Len()
+	}
+	end := all.Len()
+	if sq.limit != 0 && start+int(sq.limit) < end {
+		end = start + int(sq.limit)
+	}
+	results.Set(all.Slice(start, end))
+	return nil
+}
+
+// includeOrder makes sure that q reads the order field, which is needed to
+// merge the results from each shard.
+func includeOrder(q *query) {
+	if q.hasOrder() && q.hasIncludes() && !stringSliceContains(q.includes, q.order.fieldName) {
+		q.includes = append(q.includes[:len(q.includes):len(q.includes)], q.order.fieldName)
+	}
+}
+
+// Run is like Query.Run. If the query has an Order and an Include modifier,
+// the order field is always read (and scanned into models), since it is needed
+// to merge the results from each shard.
+func (sq *ShardedQuery) Run(models interface{}) error {
+	return sq.runShards(models, includeOrder)
+}
+
+// RunOne is like Query.RunOne.
+func (sq *ShardedQuery) RunOne(model Model) error {
+	spec := sq.collection.shards[0].spec
+	if err := spec.checkModelType(model); err != nil {
+		return err
+	}
+	limit := sq.limit
+	sq.limit = 1
+	models := reflect.New(reflect.SliceOf(spec.typ))
+	err := sq.runShards(models.Interface(), includeOrder)
+	sq.limit = limit
+	if err != nil {
+		return err
+	}
+	if models.Elem().Len() == 0 {
+		return ModelNotFoundError{
+			Collection: sq.collection.shards[0],
+			Msg:        fmt.Sprintf("Could not find %s with the given criteria", spec.name),
+		}
+	}
+	reflect.ValueOf(model).Elem().Set(models.Elem().Index(0).Elem())
+	return nil
+}
+
+// IDs is like Query.IDs.
+func (sq *ShardedQuery) IDs() ([]string, error) {
+	spec := sq.collection.shards[0].spec
+	models := reflect.New(reflect.SliceOf(spec.typ))
+	// Only read the order field (if any), which is needed to merge the results.
+	err := sq.runShards(models.Interface(), func(q *query) {
+		q.excludes = nil
+		q.includes = []string{}
+		if q.hasOrder() {
+			q.includes = []string{q.order.fieldName}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, models.Elem().Len())
+	for i := range ids {
+		ids[i] = models.Elem().Index(i).Interface().(Model).ModelID()
+	}
+	return ids, nil
+}
+
+// Count is like Query.Count. It returns the total number of models on all the
+// shards which match the query, taking Limit and Offset into account.
+func (sq *ShardedQuery) Count() (int, error) {
+	total := 0
+	for _, q := range sq.queries {
+		count, err := q.Count()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	total -= int(sq.offset)
+	if total < 0 {
+		total = 0
+	}
+	if sq.limit != 0 && int(sq.limit) < total {
+		total = int(sq.limit)
+	}
+	return total, nil
+}
+
+// lessIndexValue returns true iff a is less than b in the index on fs, so
+// that the merged results are in the same order as the results of an
+// unsharded query. Numeric fields (including fields of types which implement
+// Scorer) and boolean fields are compared by their scores, and string and
+// integer fields by their index values, which are the collation keys for
+// fields with a collation. nil pointers are less than any other value. Values
+// of any other fields are compared with lessFieldValue.
+func lessIndexValue(fs *fieldSpec, a, b reflect.Value) bool {
+	if fs == nil {
+		return lessFieldValue(a, b)
+	}
+	if aNil, bNil := isNilValue(a), isNilValue(b); aNil || bNil {
+		return aNil && !bNil
+	}
+	switch fs.indexKind {
+	case numericIndex:
+		return numericScore(a) < numericScore(b)
+	case booleanIndex:
+		return boolScore(a) < boolScore(b)
+	case stringIndex, integerIndex:
+		return stringIndexValue(fs, a) < stringIndexValue(fs, b)
+	}
+	return lessFieldValue(a, b)
+}
+
+// isNilValue returns true iff val is a nil pointer or a pointer to a nil
+// pointer.
+func isNilValue(val reflect.Value) bool {
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return true
+		}
+		val = val.Elem()
+	}
+	return false
+}
+
+// lessFieldValue returns true iff a is less than b. a and b must be values of
+// the same indexed field. nil pointers are less than any other value.
+func lessFieldValue(a, b reflect.Value) bool {
+	for a.Kind() == reflect.Ptr {
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() && !b.IsNil()
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.String:
+		return a.String() < b.String()
+	}
+	return false
+}
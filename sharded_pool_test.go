@@ -0,0 +1,186 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File sharded_pool_test.go tests the code in sharded_pool.go
+
+package zoom
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestShardedPool returns a ShardedPool with two shards which use the
+// databases after the test database, along with a function which flushes
+// them and closes the pool.
+func newTestShardedPool(t *testing.T) (*ShardedPool, func()) {
+	options := testPool.options
+	sp, err := NewShardedPoolWithOptions(
+		options.WithDatabase(options.Database+1),
+		options.WithDatabase(options.Database+2),
+	)
+	require.NoError(t, err)
+	return sp, func() {
+		for _, pool := range sp.Pools() {
+			conn := pool.NewConn()
+			_, _ = conn.Do("FLUSHDB")
+			_ = conn.Close()
+		}
+		_ = sp.Close()
+	}
+}
+
+func TestNewShardedPoolErrors(t *testing.T) {
+	_, err := NewShardedPool()
+	assert.Error(t, err)
+	_, err = NewShardedPool("localhost:6379", "localhost:6379")
+	assert.Error(t, err)
+}
+
+func TestShardedCollection(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+	sp, cleanup := newTestShardedPool(t)
+	defer cleanup()
+
+	col, err := sp.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := createIndexedTestModels(20)
+	for _, model := range models {
+		require.NoError(t, col.Save(model))
+	}
+
+	// Each model should be stored only on the shard which owns it, and both
+	// shards should own some models
+	counts := make([]int, len(col.Shards()))
+	for _, model := range models {
+		for i, shard := range col.Shards() {
+			exists, err := shard.Exists(model.ID)
+			require.NoError(t, err)
+			assert.Equal(t, shard == col.Shard(model.ID), exists)
+			if exists {
+				counts[i]++
+			}
+		}
+	}
+	for i, count := range counts {
+		assert.NotZero(t, count, "shard %d did not own any models", i)
+	}
+
+	// The same id should always map to the same shard
+	assert.Equal(t, col.Shard(models[0].ID), col.Shard(models[0].ID))
+	assert.Equal(t, col.Shard(models[0].ID).pool, sp.Shard(models[0].ID))
+
+	ids := []string{models[0].ID, models[1].ID, "invalidID"}
+	exists, err := col.ExistsMany(ids)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{models[0].ID: true, models[1].ID: true, "invalidID": false}, exists)
+
+	count, err := col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, len(models), count)
+
+	got := &indexedTestModel{}
+	require.NoError(t, col.Find(models[0].ID, got))
+	assert.Equal(t, models[0], got)
+
+	all := []*indexedTestModel{}
+	require.NoError(t, col.FindAll(&all))
+	assert.Len(t, all, len(models))
+
+	deleted, err := col.Delete(models[0].ID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	numDeleted, err := col.DeleteAll()
+	require.NoError(t, err)
+	assert.Equal(t, len(models)-1, numDeleted)
+}
+
+func TestShardedQuery(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+	sp, cleanup := newTestShardedPool(t)
+	defer cleanup()
+
+	col, err := sp.NewCollectionWithOptions(&indexedTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := createIndexedTestModels(20)
+	for i, model := range models {
+		model.Int = i
+		model.Bool = i%2 == 0
+		require.NoError(t, col.Save(model))
+	}
+
+	// Order, Filter, Limit and Offset should be applied to the merged results
+	got := []*indexedTestModel{}
+	q := col.NewQuery().Order("-Int").Filter("Bool =", true).Offset(2).Limit(3)
+	require.NoError(t, q.Run(&got))
+	assert.Equal(t, []*indexedTestModel{models[14], models[12], models[10]}, got)
+
+	count, err := q.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	ids, err := q.IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[14].ID, models[12].ID, models[10].ID}, ids)
+
+	// Without an order, all the matching models should be returned
+	ids, err = col.NewQuery().Filter("Int <", 10).IDs()
+	require.NoError(t, err)
+	expectedIDs := []string{}
+	for _, model := range models[:10] {
+		expectedIDs = append(expectedIDs, model.ID)
+	}
+	sort.Strings(ids)
+	sort.Strings(expectedIDs)
+	assert.Equal(t, expectedIDs, ids)
+
+	one := &indexedTestModel{}
+	require.NoError(t, col.NewQuery().Order("Int").Filter("Int >", 4).RunOne(one))
+	assert.Equal(t, models[5], one)
+	err = col.NewQuery().Filter("Int >", 100).RunOne(one)
+	assert.IsType(t, ModelNotFoundError{}, err)
+}
+
+func TestShardedQueryOrderByIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+	sp, cleanup := newTestShardedPool(t)
+	defer cleanup()
+
+	// Fields of types which implement Scorer are merged by their scores
+	scorers, err := sp.NewCollectionWithOptions(&scorerTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	versions := []*scorerTestModel{}
+	for i := 0; i < 10; i++ {
+		model := &scorerTestModel{Version: scorerTestVersion{Major: 1, Minor: 9 - i}}
+		require.NoError(t, scorers.Save(model))
+		versions = append([]*scorerTestModel{model}, versions...)
+	}
+	gotVersions := []*scorerTestModel{}
+	require.NoError(t, scorers.NewQuery().Order("Version").Run(&gotVersions))
+	assert.Equal(t, versions, gotVersions)
+	gotVersions = []*scorerTestModel{}
+	require.NoError(t, scorers.NewQuery().Order("-Version").Limit(2).Run(&gotVersions))
+	assert.Equal(t, []*scorerTestModel{versions[9], versions[8]}, gotVersions)
+
+	// Fields with a collation are merged by their collation keys
+	options := DefaultCollectionOptions.WithIndex(true).WithCollation("Name", caseInsensitive)
+	collated, err := sp.NewCollectionWithOptions(&collatedTestModel{}, options)
+	require.NoError(t, err)
+	names := []string{"alice", "Bob", "carol", "Dave", "eve", "Frank"}
+	people := []*collatedTestModel{}
+	for _, name := range names {
+		model := &collatedTestModel{Name: name}
+		require.NoError(t, collated.Save(model))
+		people = append(people, model)
+	}
+	gotPeople := []*collatedTestModel{}
+	require.NoError(t, collated.NewQuery().Order("Name").Run(&gotPeople))
+	assert.Equal(t, people, gotPeople)
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File snapshot.go contains code for backing up and restoring all the keys of
+// a collection with DUMP and RESTORE.
+
+package zoom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// snapshotHeader is written at the start of every snapshot so that
+// RestoreSnapshot can detect invalid input and future format changes.
+const snapshotHeader = "zoom snapshot 1\n"
+
+// maxSnapshotValueLen is the maximum length of a key or DUMP payload in a
+// snapshot, which is the maximum size of a Redis string.
+const maxSnapshotValueLen = 512 * 1024 * 1024
+
+// snapshotBatchSize is the number of keys dumped or restored at a time.
+const snapshotBatchSize = 100
+
+// Snapshot writes a binary backup of all the keys which belong to the
+// collection (i.e. all the keys which start with the collection name followed
+// by a colon, which includes the main hashes, fields stored in their own keys,
+// the set of all ids and the indexes) to w. Each key is written in the
+// serialization format of the Redis DUMP command along with its remaining time
+// to live, so the snapshot preserves the exact values and encodings and can
+// be restored quickly with RestoreSnapshot, e.g. to roll back a risky
+// migration. The format depends on the version of Redis, so a snapshot should
+// only be restored into the same (or a newer) version. The keys are found
+// with SCAN and dumped in small batches, so the snapshot is not atomic. For a
+// consistent snapshot, stop writing to the collection while it is taken.
+func (c *Collection) Snapshot(w io.Writer) error {
+	conn := c.pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	keys, err := c.scanKeys(conn, "")
+	if err != nil {
+		return fmt.Errorf("zoom: Error in Snapshot: %w", err)
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(snapshotHeader); err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += snapshotBatchSize {
+		end := start + snapshotBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := dumpKeys(conn, keys[start:end], bw); err != nil {
+			return fmt.Errorf("zoom: Error in Snapshot: %w", err)
+		}
+	}
+	return bw.Flush()
+}
+
+// dumpKeys writes the time to live and DUMP payload of each of the given keys
+// to w. Keys which no longer exist are skipped.
+func dumpKeys(conn redis.Conn, keys []string, w *bufio.Writer) error {
+	for _, key := range keys {
+		if err := conn.Send("PTTL", key); err != nil {
+			return err
+		}
+		if err := conn.Send("DUMP", key); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		ttl, err := redis.Int64(conn.Receive())
+		if err != nil {
+			return err
+		}
+		payload, err := redis.Bytes(conn.Receive())
+		if err == redis.ErrNil {
+			// The key was deleted since it was scanned.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if ttl < 0 {
+			// The key does not have an expiration. A TTL of 0 means the same
+			// thing to RESTORE.
+			ttl = 0
+		}
+		if err := writeSnapshotBytes(w, []byte(key)); err != nil {
+			return err
+		}
+		if err := writeUvarint(w, uint64(ttl)); err != nil {
+			return err
+		}
+		if err := writeSnapshotBytes(w, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeUvarint writes x to w in the varint format used by encoding/binary.
+func writeUvarint(w *bufio.Writer, x uint64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, x)
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+// writeSnapshotBytes writes value to w prefixed by its length.
+func writeSnapshotBytes(w *bufio.Writer, value []byte) error {
+	if err := writeUvarint(w, uint64(len(value))); err != nil {
+		return err
+	}
+	_, err := w.Write(value)
+	return err
+}
+
+// snapshotEntry is a single key read from a snapshot.
+type snapshotEntry struct {
+	key     []byte
+	ttl     uint64
+	payload []byte
+}
+
+// RestoreSnapshot replaces all the keys which belong to the collection with
+// the keys in a snapshot written by Snapshot. Keys which belong to the
+// collection but are not in the snapshot (e.g. models which were saved after
+// the snapshot was taken) are deleted first, so that the collection and its
+// indexes are exactly as they were when the snapshot was taken. The whole
+// snapshot is read into memory before anything is deleted, so if it is
+// invalid the collection is left unchanged. Like Snapshot, RestoreSnapshot is
+// not atomic and the collection should not be used while it is running. If
+// Redis returns an error while the keys are restored, the collection is left
+// partially restored.
+func (c *Collection) RestoreSnapshot(r io.Reader) error {
+	entries, err := readSnapshot(r)
+	if err != nil {
+		return fmt.Errorf("zoom: Error in RestoreSnapshot: %w", err)
+	}
+	conn := c.pool.NewConn()
+	keys, err := c.scanKeys(conn, "")
+	_ = conn.Close()
+	if err != nil {
+		return fmt.Errorf("zoom: Error in RestoreSnapshot: %w", err)
+	}
+	t := c.pool.NewTransaction()
+	for start := 0; start < len(keys); start += snapshotBatchSize {
+		end := start + snapshotBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		t.Command("DEL", redis.Args{}.AddFlat(keys[start:end]), nil)
+	}
+	for _, entry := range entries {
+		t.Command("RESTORE", redis.Args{entry.key, entry.ttl, entry.payload, "REPLACE"}, nil)
+	}
+	t.invalidateCachedCollection(c.Name())
+	return t.ExecInBatches(snapshotBatchSize)
+}
+
+// readSnapshot reads all the entries in a snapshot written by Snapshot.
+func readSnapshot(r io.Reader) ([]snapshotEntry, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotHeader))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != snapshotHeader {
+		return nil, fmt.Errorf("input is not a snapshot written by Snapshot")
+	}
+	entries := []snapshotEntry{}
+	for {
+		key, err := readSnapshotBytes(br)
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		ttl, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", unexpectedEOF(err))
+		}
+		payload, err := readSnapshotBytes(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", unexpectedEOF(err))
+		}
+		entries = append(entries, snapshotEntry{key: key, ttl: ttl, payload: payload})
+	}
+}
+
+// readSnapshotBytes reads a length-prefixed byte slice from r. It returns
+// io.EOF iff there was nothing left to read.
+func readSnapshotBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotValueLen {
+		return nil, fmt.Errorf("value of length %d is too long", n)
+	}
+	value := make([]byte, n)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return value, nil
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for errors that occur
+// in the middle of an entry.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
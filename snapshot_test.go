@@ -0,0 +1,138 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File snapshot_test.go tests the code in snapshot.go
+
+package zoom
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type snapshotTestModel struct {
+	Int   int               `zoom:"index"`
+	Ptr   *string           `zoom:"index"`
+	Tags  []string          `zoom:"list"`
+	Attrs map[string]string `zoom:"hash"`
+	RandomID
+}
+
+func TestSnapshotKeys(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Some test databases only support DUMP for strings, so this test uses
+	// string keys to cover the snapshot format, expirations and deleting keys
+	// which were added after the snapshot.
+	col := testModels
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	for i := 0; i < snapshotBatchSize+1; i++ {
+		_, err := conn.Do("SET", col.Name()+":key"+strconv.Itoa(i), i)
+		require.NoError(t, err)
+	}
+	_, err := conn.Do("SET", col.Name()+":expiring", "value", "EX", 1000)
+	require.NoError(t, err)
+	_, err = conn.Do("SET", "otherCollection:key", "value")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, col.Snapshot(buf))
+
+	_, err = conn.Do("DEL", col.Name()+":key0", col.Name()+":expiring")
+	require.NoError(t, err)
+	_, err = conn.Do("SET", col.Name()+":key1", "changed")
+	require.NoError(t, err)
+	_, err = conn.Do("SET", col.Name()+":new", "value")
+	require.NoError(t, err)
+
+	// An invalid snapshot should not change anything
+	assert.Error(t, col.RestoreSnapshot(bytes.NewBufferString("not a snapshot")))
+	truncated := bytes.NewBuffer(buf.Bytes()[:buf.Len()-1])
+	assert.Error(t, col.RestoreSnapshot(truncated))
+	exists, err := redis.Bool(conn.Do("EXISTS", col.Name()+":new"))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, col.RestoreSnapshot(buf))
+	for i := 0; i < snapshotBatchSize+1; i++ {
+		value, err := redis.Int(conn.Do("GET", col.Name()+":key"+strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+	ttl, err := redis.Int(conn.Do("TTL", col.Name()+":expiring"))
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 1000, "unexpected ttl: %d", ttl)
+	exists, err = redis.Bool(conn.Do("EXISTS", col.Name()+":new"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = redis.Bool(conn.Do("EXISTS", "otherCollection:key"))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestSnapshot(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&snapshotTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := []*snapshotTestModel{}
+	for i := 0; i < snapshotBatchSize; i++ {
+		model := &snapshotTestModel{Int: i, Tags: []string{"a", "b"}, Attrs: map[string]string{"k": "v"}}
+		require.NoError(t, col.Save(model))
+		models = append(models, model)
+	}
+	buf := &bytes.Buffer{}
+	err = col.Snapshot(buf)
+	if err != nil && strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Skip("the test database does not support DUMP for hashes, lists and sorted sets")
+	}
+	require.NoError(t, err)
+
+	// Change the collection in every possible way after the snapshot
+	_, err = col.Delete(models[0].ID)
+	require.NoError(t, err)
+	models[1].Int = -1
+	models[1].Tags = nil
+	require.NoError(t, col.Save(models[1]))
+	require.NoError(t, col.Save(&snapshotTestModel{Int: 1000}))
+
+	// An invalid snapshot should not change anything
+	assert.Error(t, col.RestoreSnapshot(bytes.NewBufferString("not a snapshot")))
+	truncated := bytes.NewBuffer(buf.Bytes()[:buf.Len()-1])
+	assert.Error(t, col.RestoreSnapshot(truncated))
+	count, err := col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, snapshotBatchSize, count)
+
+	require.NoError(t, col.RestoreSnapshot(buf))
+	count, err = col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, snapshotBatchSize, count)
+	for _, id := range []string{models[0].ID, models[1].ID} {
+		found := &snapshotTestModel{}
+		require.NoError(t, col.Find(id, found))
+		assert.Equal(t, []string{"a", "b"}, found.Tags)
+		assert.Equal(t, map[string]string{"k": "v"}, found.Attrs)
+	}
+	ids, err := col.NewQuery().Filter("Int <", 2).Order("Int").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[0].ID, models[1].ID}, ids)
+	count, err = col.NewQuery().Filter("Ptr =", nil).Count()
+	require.NoError(t, err)
+	assert.Equal(t, snapshotBatchSize, count)
+}
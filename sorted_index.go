@@ -0,0 +1,151 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File sorted_index.go contains code for storing the index of all models in a
+// collection as a sorted set, scored by the time each model was first saved.
+
+package zoom
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// SortedIndex returns true iff the index of all models in the collection (see
+// IndexKey) is a sorted set scored by the time each model was first saved,
+// rather than a set. See CollectionOptions.SortedIndex. Since the index of an
+// existing collection may be a set (until MigrateToSortedIndex is called, even
+// by another process), SortedIndex reads the type of the index from Redis. If
+// the index does not exist yet, it returns true iff the option is set. It
+// returns false if the type cannot be read, e.g. because Redis is not
+// reachable.
+func (c *Collection) SortedIndex() bool {
+	if !c.index {
+		return false
+	}
+	conn := c.pool.NewConn()
+	defer conn.Close()
+	typ, err := redis.String(conn.Do("TYPE", c.IndexKey()))
+	if err != nil {
+		return false
+	}
+	if typ == "none" {
+		return c.sortedIndex
+	}
+	return typ == "zset"
+}
+
+// indexScore returns the score of a model first saved at the given time in the
+// sorted index of all models, i.e. the number of milliseconds since the Unix
+// epoch. A score of 0 means the time is not known (see MigrateToSortedIndex).
+func indexScore(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// addToIndex adds a script to the transaction which adds the given id to the
+// index of all models in the collection. The script checks whether the index
+// is a set or a sorted set, so it works even if another process has migrated
+// the index (see MigrateToSortedIndex). For a sorted index, the score of a
+// model which was already saved is not changed.
+func (t *Transaction) addToIndex(c *Collection, id string) {
+	args := redis.Args{c.IndexKey(), id, indexScore(time.Now()), convertBoolToInt(c.sortedIndex)}
+	t.modelScript(id, addToIndexScript, args, nil)
+}
+
+// removeFromIndex adds a script to the transaction which removes the given id
+// from the index of all models in the collection, which may be a set or a
+// sorted set.
+func (t *Transaction) removeFromIndex(c *Collection, id string) {
+	t.modelScript(id, removeFromIndexScript, redis.Args{c.IndexKey(), id}, nil)
+}
+
+// countIndex adds a script to the transaction which gets the number of models
+// in the index of all models in the collection, which may be a set or a sorted
+// set, and passes it to handler.
+func (t *Transaction) countIndex(c *Collection, handler ReplyHandler) {
+	t.Script(countIndexScript, redis.Args{c.IndexKey()}, handler)
+}
+
+// MigrateToSortedIndex converts the index of all models in the collection from
+// a set to a sorted set, so that the collection has the features of
+// CollectionOptions.SortedIndex. Since the time the existing models were first
+// saved is not known, they all have a score of 0 and are ordered by id before
+// any model saved after the migration. For ids generated by RandomID, this is
+// also the order in which they were created. MigrateToSortedIndex does nothing
+// if the index is already a sorted set, and if the index does not exist yet,
+// in which case the next save creates it as a sorted set iff
+// CollectionOptions.SortedIndex is set. Other processes which use the
+// collection do not need to be restarted, since Zoom checks the type of the
+// index whenever it is used.
+func (c *Collection) MigrateToSortedIndex() error {
+	if !c.index {
+		return newUnindexedCollectionError("MigrateToSortedIndex")
+	}
+	conn := c.pool.NewConn()
+	typ, err := redis.String(conn.Do("TYPE", c.IndexKey()))
+	conn.Close()
+	if err != nil {
+		return fmt.Errorf("zoom: could not migrate the index of %s: %w", c.Name(), err)
+	}
+	if typ != "set" {
+		return nil
+	}
+	t := c.pool.NewTransaction()
+	tmpKey := t.newTmpKey("tmp:sortedIndex")
+	t.Command("ZUNIONSTORE", redis.Args{tmpKey, 1, c.IndexKey(), "WEIGHTS", 0}, nil)
+	t.Command("RENAME", redis.Args{tmpKey, c.IndexKey()}, nil)
+	if err := t.Exec(); err != nil {
+		return fmt.Errorf("zoom: could not migrate the index of %s: %w", c.Name(), err)
+	}
+	return nil
+}
+
+// DeleteSavedBetween deletes all the models in the collection which were
+// first saved between start and end (inclusive), including their indexes, and
+// returns the number of models that were deleted. A zero start or end means the
+// range is unbounded on that side, so e.g. DeleteSavedBetween(time.Time{},
+// cutoff) deletes all models saved before the cutoff. Like Query.Delete, it does
+// not record changes in the audit log or outbox. DeleteSavedBetween requires
+// the collection to have a sorted index (see CollectionOptions.SortedIndex)
+// and returns an error if the models are referenced by a field with the ref
+// option. It is not atomic: the ids are read before the models are deleted.
+func (c *Collection) DeleteSavedBetween(start, end time.Time) (int, error) {
+	if !c.index {
+		return 0, newUnindexedCollectionError("DeleteSavedBetween")
+	}
+	if !c.SortedIndex() {
+		return 0, fmt.Errorf("zoom: DeleteSavedBetween only works for collections with a sorted index. See CollectionOptions.SortedIndex and Collection.MigrateToSortedIndex")
+	}
+	if err := c.checkUnreferenced("DeleteSavedBetween"); err != nil {
+		return 0, err
+	}
+	min, max := "-inf", "+inf"
+	if !start.IsZero() {
+		min = fmt.Sprint(indexScore(start))
+	}
+	if !end.IsZero() {
+		max = fmt.Sprint(indexScore(end))
+	}
+	conn := c.pool.NewConn()
+	ids, err := redis.Strings(conn.Do("ZRANGEBYSCORE", c.IndexKey(), min, max))
+	conn.Close()
+	if err != nil {
+		return 0, fmt.Errorf("zoom: could not get the ids of %s models to delete: %w", c.Name(), err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	t := c.pool.NewTransaction()
+	listKey := t.newTmpKey("tmp:deleteIDs")
+	t.Command("RPUSH", redis.Args{listKey}.AddFlat(ids), nil)
+	count := 0
+	t.deleteModelsByListIDs(listKey, c.spec, NewScanIntHandler(&count))
+	t.Command("DEL", redis.Args{listKey}, nil)
+	if err := t.Exec(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,257 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File sorted_index_test.go tests the code in sorted_index.go
+
+package zoom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sortedIndexModel is a model type that is only used for testing sorted
+// indexes
+type sortedIndexModel struct {
+	Int int `zoom:"index"`
+	RandomID
+}
+
+// newSortedIndexCollection registers a new collection of sortedIndexModel
+// with the given name and options and unregisters it when the test finishes.
+func newSortedIndexCollection(t *testing.T, name string, options CollectionOptions) *Collection {
+	col, err := testPool.NewCollectionWithOptions(&sortedIndexModel{}, options.WithIndex(true).WithName(name))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		// Effectively unregister the type by removing it from the maps
+		delete(testPool.modelNameToSpec, col.Name())
+		delete(testPool.modelNameToCollection, col.Name())
+	})
+	return col
+}
+
+// saveSortedIndexModels saves n models in the given collection, one at a time
+// and at least a millisecond apart, so that each has a different score in the
+// sorted index.
+func saveSortedIndexModels(t *testing.T, col *Collection, n int) []*sortedIndexModel {
+	models := make([]*sortedIndexModel, n)
+	for i := range models {
+		// Use descending values so that the order of the values differs from
+		// the order in which the models were saved.
+		models[i] = &sortedIndexModel{Int: n - i}
+		require.NoError(t, col.Save(models[i]))
+		time.Sleep(2 * time.Millisecond)
+	}
+	return models
+}
+
+func indexType(t *testing.T, col *Collection) string {
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	keyType, err := redis.String(conn.Do("TYPE", col.IndexKey()))
+	require.NoError(t, err)
+	return keyType
+}
+
+func TestSortedIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	col := newSortedIndexCollection(t, "sortedIndexModel", DefaultCollectionOptions)
+	require.True(t, col.SortedIndex())
+	models := saveSortedIndexModels(t, col, 5)
+	assert.Equal(t, "zset", indexType(t, col))
+
+	// Saving a model again does not change its position
+	models[0].Int = 100
+	require.NoError(t, col.Save(models[0]))
+
+	// FindAll and queries without an order return the models in the order they
+	// were first saved
+	found := []*sortedIndexModel{}
+	require.NoError(t, col.FindAll(&found))
+	assert.Equal(t, models, found)
+	found = []*sortedIndexModel{}
+	require.NoError(t, col.NewQuery().Offset(1).Limit(2).Run(&found))
+	assert.Equal(t, models[1:3], found)
+
+	// Last returns the most recently saved models
+	found = []*sortedIndexModel{}
+	require.NoError(t, col.NewQuery().Last(2).Run(&found))
+	assert.Equal(t, models[3:], found)
+
+	count, err := col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	count, err = col.NewQuery().Count()
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	// Deleting a model removes it from the index
+	deleted, err := col.Delete(models[1].ModelID())
+	require.NoError(t, err)
+	require.True(t, deleted)
+	expectModelDoesNotExist(t, col, models[1])
+	expectModelExists(t, col, models[2])
+
+	// Deleting by query works with the sorted index
+	queryCount, err := col.NewQuery().Filter("Int =", 3).Delete()
+	require.NoError(t, err)
+	assert.Equal(t, 1, queryCount)
+	expectModelDoesNotExist(t, col, models[2])
+	ids, err := col.NewQuery().IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{models[0].ID, models[3].ID, models[4].ID}, ids)
+
+	_, err = col.DeleteAll()
+	require.NoError(t, err)
+	expectKeyDoesNotExist(t, col.IndexKey())
+}
+
+func TestSortedIndexDisabled(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	col := newSortedIndexCollection(t, "unsortedIndexModel", DefaultCollectionOptions.WithSortedIndex(false))
+	assert.False(t, col.SortedIndex())
+	models := saveSortedIndexModels(t, col, 3)
+	assert.Equal(t, "set", indexType(t, col))
+	expectModelsExist(t, col, []Model{models[0], models[1], models[2]})
+	_, err := col.DeleteSavedBetween(time.Time{}, time.Time{})
+	assert.Error(t, err)
+}
+
+func TestSortedIndexUnreachable(t *testing.T) {
+	// Registering a collection should not require Redis to be reachable.
+	pool := NewPoolWithOptions(testPool.options.WithAddress("localhost:1"))
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&sortedIndexModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	assert.False(t, col.SortedIndex())
+}
+
+func TestMigrateToSortedIndex(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	old := newSortedIndexCollection(t, "migratedIndexModel", DefaultCollectionOptions.WithSortedIndex(false))
+	models := []*sortedIndexModel{}
+	for _, id := range []string{"c", "a", "b"} {
+		model := &sortedIndexModel{Int: 1}
+		model.SetModelID(id)
+		require.NoError(t, old.Save(model))
+		models = append(models, model)
+	}
+	delete(testPool.modelNameToSpec, old.Name())
+	delete(testPool.modelNameToCollection, old.Name())
+
+	// A new collection with the default options keeps the existing set
+	col := newSortedIndexCollection(t, "migratedIndexModel", DefaultCollectionOptions)
+	assert.False(t, col.SortedIndex())
+	assert.Equal(t, "set", indexType(t, col))
+
+	require.NoError(t, col.MigrateToSortedIndex())
+	assert.True(t, col.SortedIndex())
+	assert.Equal(t, "zset", indexType(t, col))
+	// Migrating again does nothing
+	require.NoError(t, col.MigrateToSortedIndex())
+
+	// The migrated models are ordered by id, before any new models
+	newModel := &sortedIndexModel{Int: 2}
+	require.NoError(t, col.Save(newModel))
+	ids, err := col.NewQuery().IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", newModel.ID}, ids)
+	expectModelsExist(t, col, []Model{models[0], models[1], models[2], newModel})
+
+	// The migrated models were saved at an unknown time
+	deleted, err := col.DeleteSavedBetween(time.Time{}, time.Unix(0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	expectModelsDoNotExist(t, col, []Model{models[0], models[1], models[2]})
+	expectModelExists(t, col, newModel)
+
+	unindexed := &Collection{spec: col.spec, pool: testPool}
+	assert.Error(t, unindexed.MigrateToSortedIndex())
+}
+
+func TestMigrateToSortedIndexOtherProcess(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	col := newSortedIndexCollection(t, "otherProcessIndexModel", DefaultCollectionOptions.WithSortedIndex(false))
+	models := saveSortedIndexModels(t, col, 2)
+	assert.False(t, col.SortedIndex())
+
+	// Migrate the index with a collection in another pool, as if by another
+	// process which uses the same collection.
+	otherPool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = otherPool.Close()
+	}()
+	other, err := otherPool.NewCollectionWithOptions(&sortedIndexModel{}, DefaultCollectionOptions.WithIndex(true).WithName(col.Name()))
+	require.NoError(t, err)
+	require.NoError(t, other.MigrateToSortedIndex())
+	assert.Equal(t, "zset", indexType(t, col))
+
+	// The first collection should keep working without being registered again.
+	assert.True(t, col.SortedIndex())
+	newModel := &sortedIndexModel{Int: 0}
+	require.NoError(t, col.Save(newModel))
+	count, err := col.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	found := []*sortedIndexModel{}
+	require.NoError(t, col.NewQuery().Last(1).Run(&found))
+	assert.Equal(t, []*sortedIndexModel{newModel}, found)
+	deleted, err := col.Delete(models[0].ModelID())
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	ids, err := col.NewQuery().IDs()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{models[1].ID, newModel.ID}, ids)
+	assert.Equal(t, "zset", indexType(t, col))
+}
+
+func TestDeleteSavedBetween(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	col := newSortedIndexCollection(t, "deleteSavedBetweenModel", DefaultCollectionOptions)
+	models := saveSortedIndexModels(t, col, 2)
+	start := time.Now()
+	models = append(models, saveSortedIndexModels(t, col, 2)...)
+	end := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	models = append(models, saveSortedIndexModels(t, col, 2)...)
+
+	// Nothing was saved before the epoch
+	deleted, err := col.DeleteSavedBetween(time.Time{}, time.Unix(1, 0))
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = col.DeleteSavedBetween(start, end)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	expectModelsDoNotExist(t, col, []Model{models[2], models[3]})
+	for _, model := range models[2:4] {
+		expectIndexDoesNotExist(t, col, model, "Int")
+	}
+	expectModelsExist(t, col, []Model{models[0], models[1], models[4], models[5]})
+
+	// A zero end means the range is unbounded
+	deleted, err = col.DeleteSavedBetween(end, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	expectModelsDoNotExist(t, col, []Model{models[4], models[5]})
+	expectModelsExist(t, col, []Model{models[0], models[1]})
+}
@@ -0,0 +1,31 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File store.go contains the Store interface, which is implemented by
+// Collection.
+
+package zoom
+
+// Store is an interface encapsulating the methods of a Collection that are
+// used to save, find, delete, and query models. It is implemented by
+// *Collection. Application code can depend on Store instead of *Collection so
+// that a mock implementation (e.g. one generated by mockgen) can be used in
+// tests that should not touch the database.
+type Store interface {
+	Name() string
+	ModelKey(id string) string
+	Save(model Model) error
+	SaveFields(fieldNames []string, model Model) error
+	Find(id string, model Model) error
+	FindFields(id string, fieldNames []string, model Model) error
+	FindAll(models interface{}) error
+	Exists(id string) (bool, error)
+	Count() (int, error)
+	Delete(id string) (bool, error)
+	DeleteAll() (int, error)
+	NewQuery() *Query
+}
+
+// Make sure that *Collection implements Store.
+var _ Store = &Collection{}
@@ -0,0 +1,103 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File strict_scan.go contains code for the StrictScan collection option,
+// which detects models whose fields do not match the model type.
+
+package zoom
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// checkHashFieldsForIDs adds a script to the transaction which compares the
+// fields in the main hash of each of the models with the given ids to the
+// fields of the model type, and sets a StrictScanError if any of them do not
+// match.
+func (t *Transaction) checkHashFieldsForIDs(c *Collection, ids ...string) {
+	args := c.spec.hashFieldMismatchArgs("")
+	for _, id := range ids {
+		args = args.Add(id)
+	}
+	t.Script(findHashFieldMismatchesScript, args, newStrictScanHandler(c))
+}
+
+// checkHashFieldsForSort is like checkHashFieldsForIDs, but checks the models
+// whose ids would be returned by a SORT command created with sortArgs using
+// the given arguments (i.e. the models read by the queries and FindAll). The
+// ids are stored in a temporary list first.
+func (t *Transaction) checkHashFieldsForSort(c *Collection, idsKey string, limit int, offset uint, reverse bool) {
+	listKey := t.newTmpKey("tmp:strict:" + c.Name())
+	sortArgs := c.spec.sortArgs(idsKey, nil, limit, offset, reverse)
+	t.Command("SORT", append(sortArgs, "STORE", listKey), nil)
+	t.Script(findHashFieldMismatchesScript, c.spec.hashFieldMismatchArgs(listKey), newStrictScanHandler(c))
+	t.Command("DEL", redis.Args{listKey}, nil)
+}
+
+// hashFieldMismatchArgs returns the arguments for the
+// find_hash_field_mismatches script, not including any model ids.
+func (ms *modelSpec) hashFieldMismatchArgs(listKey string) redis.Args {
+	fields := ms.fieldsWithComputed()
+	args := redis.Args{ms.name, listKey, len(fields)}
+	for _, fs := range fields {
+		args = args.Add(fs.redisName)
+	}
+	return args
+}
+
+// newStrictScanHandler returns a ReplyHandler which converts the reply from
+// the find_hash_field_mismatches script into a StrictScanError, or returns nil
+// if there are no mismatches.
+func newStrictScanHandler(c *Collection) ReplyHandler {
+	return func(reply interface{}) error {
+		values, err := redis.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+		mismatches := []FieldMismatch{}
+		for i := 0; i < len(values); {
+			mismatch := FieldMismatch{}
+			if mismatch.ModelID, err = redis.String(values[i], nil); err != nil {
+				return err
+			}
+			if mismatch.UnknownFields, i, err = readStrictScanFields(values, i+1); err != nil {
+				return err
+			}
+			if mismatch.MissingFields, i, err = readStrictScanFields(values, i); err != nil {
+				return err
+			}
+			mismatches = append(mismatches, mismatch)
+		}
+		if len(mismatches) == 0 {
+			return nil
+		}
+		return StrictScanError{
+			Collection: c,
+			Mismatches: mismatches,
+		}
+	}
+}
+
+// readStrictScanFields reads a count followed by that many field names from
+// values, starting at index i, and returns the field names and the index after
+// the last one.
+func readStrictScanFields(values []interface{}, i int) ([]string, int, error) {
+	if i >= len(values) {
+		return nil, i, fmt.Errorf("zoom: unexpected reply from find_hash_field_mismatches script: %v", values)
+	}
+	n, err := redis.Int(values[i], nil)
+	if err != nil {
+		return nil, i, err
+	}
+	if n < 0 || i+1+n > len(values) {
+		return nil, i, fmt.Errorf("zoom: unexpected reply from find_hash_field_mismatches script: %v", values)
+	}
+	fields, err := redis.Strings(values[i+1:i+1+n], nil)
+	if err != nil {
+		return nil, i, err
+	}
+	return fields, i + 1 + n, nil
+}
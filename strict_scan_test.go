@@ -0,0 +1,93 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File strict_scan_test.go tests the code in strict_scan.go
+
+package zoom
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type strictScanTestModel struct {
+	Name string `zoom:"index"`
+	Age  int    `redis:"age"`
+	RandomID
+}
+
+func TestStrictScan(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&strictScanTestModel{}, DefaultCollectionOptions.WithIndex(true).WithStrictScan(true))
+	require.NoError(t, err)
+	valid := &strictScanTestModel{Name: "alice", Age: 30}
+	drifted := &strictScanTestModel{Name: "bob", Age: 40}
+	require.NoError(t, col.Save(valid))
+	require.NoError(t, col.Save(drifted))
+
+	// Models with exactly the expected fields should not cause an error
+	found := &strictScanTestModel{}
+	require.NoError(t, col.Find(valid.ID, found))
+	models := []*strictScanTestModel{}
+	require.NoError(t, col.FindAll(&models))
+
+	// Simulate a model written by an older version of the type
+	conn := pool.NewConn()
+	_, err = conn.Do("HSET", col.ModelKey(drifted.ID), "Nickname", "bobby")
+	require.NoError(t, err)
+	_, err = conn.Do("HDEL", col.ModelKey(drifted.ID), "age")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	checkErr := func(err error) {
+		strictErr := StrictScanError{}
+		if assert.True(t, errors.As(err, &strictErr), "err: %v", err) {
+			assert.Equal(t, []FieldMismatch{{
+				ModelID:       drifted.ID,
+				UnknownFields: []string{"Nickname"},
+				MissingFields: []string{"age"},
+			}}, strictErr.Mismatches)
+		}
+	}
+
+	// The model should still be scanned
+	found = &strictScanTestModel{}
+	checkErr(col.Find(drifted.ID, found))
+	assert.Equal(t, "bob", found.Name)
+	found = &strictScanTestModel{}
+	checkErr(col.FindFields(drifted.ID, []string{"Name"}, found))
+	assert.Equal(t, "bob", found.Name)
+	checkErr(col.FindAll(&models))
+	assert.Len(t, models, 2)
+	checkErr(col.NewQuery().Run(&models))
+	checkErr(col.NewQuery().Parallel(2).Run(&models))
+	checkErr(col.NewQuery().Filter("Name =", "bob").RunOne(&strictScanTestModel{}))
+
+	// Queries which do not read the drifted model should not return an error
+	require.NoError(t, col.Find(valid.ID, found))
+	require.NoError(t, col.NewQuery().Filter("Name =", "alice").Run(&models))
+	require.NoError(t, col.NewQuery().Order("Name").Limit(1).Run(&models))
+	assert.Len(t, models, 1)
+	require.NoError(t, col.NewQuery().Order("-Name").Offset(1).RunOne(&strictScanTestModel{}))
+
+	// Collections without StrictScan should ignore the differences
+	lenient, err := pool.NewCollectionWithOptions(&strictScanTestModel{}, DefaultCollectionOptions.WithIndex(true).WithName(col.Name()+"Lenient"))
+	require.NoError(t, err)
+	lenientModel := &strictScanTestModel{Name: "carol"}
+	require.NoError(t, lenient.Save(lenientModel))
+	conn = pool.NewConn()
+	_, err = conn.Do("HSET", lenient.ModelKey(lenientModel.ID), "Nickname", "caz")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	assert.NoError(t, lenient.Find(lenientModel.ID, &strictScanTestModel{}))
+}
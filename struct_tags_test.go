@@ -241,3 +241,223 @@ func TestIndexAndCustomName(t *testing.T) {
 		expectIndexExists(t, customIndexModels, model, field.Name)
 	}
 }
+
+// Test that the fields of an embedded struct with the inline option are
+// flattened into the parent hash and can be indexed and queried.
+func TestInlineOption(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type Timestamps struct {
+		CreatedAt int64 `zoom:"index"`
+		UpdatedAt int64
+	}
+	type Owner struct {
+		Name string `zoom:"index" redis:"name"`
+	}
+	type inlineModel struct {
+		Timestamps `zoom:"inline"`
+		Owner      `zoom:"inline" redis:"owner_"`
+		Attr       string
+		RandomID
+	}
+	inlineModels, err := testPool.NewCollectionWithOptions(&inlineModel{}, DefaultCollectionOptions.WithIndex(true))
+	if err != nil {
+		t.Fatalf("Unexpected error in Register: %s", err.Error())
+	}
+
+	// check the spec
+	expectedRedisNames := map[string]string{
+		"CreatedAt": "CreatedAt",
+		"UpdatedAt": "UpdatedAt",
+		"Name":      "owner_name",
+		"Attr":      "Attr",
+	}
+	if len(inlineModels.spec.fields) != len(expectedRedisNames) {
+		t.Errorf("Expected spec to have %d fields but got %d", len(expectedRedisNames), len(inlineModels.spec.fields))
+	}
+	for name, expectedRedisName := range expectedRedisNames {
+		if fs, found := inlineModels.spec.fieldsByName[name]; !found {
+			t.Errorf("Expected to find %s field in the spec, but got nil", name)
+		} else if fs.redisName != expectedRedisName {
+			t.Errorf("Expected fs.redisName to be %s but got %s", expectedRedisName, fs.redisName)
+		}
+	}
+
+	// save a new model and check redis
+	model := &inlineModel{
+		Timestamps: Timestamps{CreatedAt: 100, UpdatedAt: 200},
+		Owner:      Owner{Name: "alice"},
+		Attr:       "test",
+	}
+	if err := inlineModels.Save(model); err != nil {
+		t.Fatalf("Unexpected error in Save: %s", err.Error())
+	}
+	modelKey := inlineModels.ModelKey(model.ModelID())
+	expectFieldEquals(t, modelKey, "CreatedAt", inlineModels.spec.fallback, int64(100))
+	expectFieldEquals(t, modelKey, "UpdatedAt", inlineModels.spec.fallback, int64(200))
+	expectFieldEquals(t, modelKey, "owner_name", inlineModels.spec.fallback, "alice")
+	expectIndexExists(t, inlineModels, model, "CreatedAt")
+	expectIndexExists(t, inlineModels, model, "Name")
+
+	// find the model and make sure the inlined fields are set
+	got := &inlineModel{}
+	if err := inlineModels.Find(model.ModelID(), got); err != nil {
+		t.Fatalf("Unexpected error in Find: %s", err.Error())
+	}
+	if got.CreatedAt != 100 || got.UpdatedAt != 200 || got.Name != "alice" || got.Attr != "test" {
+		t.Errorf("Found model was incorrect. Expected %+v but got %+v", model, got)
+	}
+
+	// query using a filter on an inlined field
+	gots := []*inlineModel{}
+	if err := inlineModels.NewQuery().Filter("CreatedAt >=", int64(100)).Filter("Name =", "alice").Run(&gots); err != nil {
+		t.Fatalf("Unexpected error in Query.Run: %s", err.Error())
+	}
+	if len(gots) != 1 || gots[0].ModelID() != model.ModelID() {
+		t.Errorf("Expected query to return the saved model but got %+v", gots)
+	}
+}
+
+// Test that invalid uses of the inline option cause an error.
+func TestInlineOptionErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type Inner struct {
+		Attr string
+	}
+	type notEmbedded struct {
+		Inner Inner `zoom:"inline"`
+		RandomID
+	}
+	type duplicateField struct {
+		Inner `zoom:"inline"`
+		Attr  string
+		RandomID
+	}
+	type inlineAndIndex struct {
+		Inner `zoom:"inline,index"`
+		RandomID
+	}
+	for _, model := range []Model{&notEmbedded{}, &duplicateField{}, &inlineAndIndex{}} {
+		if _, err := testPool.NewCollection(model); err == nil {
+			t.Errorf("Expected error when registering %T but got none", model)
+		}
+	}
+}
+
+// Test that the fields of a struct field with the nested option are flattened
+// into the parent hash under their path and can be indexed and queried.
+func TestNestedOption(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type Address struct {
+		City    string `zoom:"index"`
+		Country string `redis:"country"`
+	}
+	type nestedModel struct {
+		Home Address `zoom:"nested"`
+		Work Address `zoom:"nested" redis:"work"`
+		Name string
+		RandomID
+	}
+	for _, layout := range []Layout{HashLayout, DocumentLayout} {
+		nestedModels, err := testPool.NewCollectionWithOptions(&nestedModel{}, DefaultCollectionOptions.WithName("nestedModel"+layout.String()).WithIndex(true).WithLayout(layout))
+		if err != nil {
+			t.Fatalf("Unexpected error in Register: %s", err.Error())
+		}
+
+		// check the spec
+		expectedRedisNames := map[string]string{
+			"Home.City":    "Home.City",
+			"Home.Country": "Home.country",
+			"Work.City":    "work.City",
+			"Work.Country": "work.country",
+			"Name":         "Name",
+		}
+		if len(nestedModels.spec.fields) != len(expectedRedisNames) {
+			t.Errorf("Expected spec to have %d fields but got %d", len(expectedRedisNames), len(nestedModels.spec.fields))
+		}
+		for name, expectedRedisName := range expectedRedisNames {
+			if fs, found := nestedModels.spec.fieldsByName[name]; !found {
+				t.Errorf("Expected to find %s field in the spec, but got nil", name)
+			} else if fs.redisName != expectedRedisName {
+				t.Errorf("Expected fs.redisName to be %s but got %s", expectedRedisName, fs.redisName)
+			}
+		}
+
+		// save some models and find them
+		oslo := &nestedModel{Home: Address{City: "Oslo", Country: "NO"}, Work: Address{City: "Bergen"}, Name: "a"}
+		paris := &nestedModel{Home: Address{City: "Paris", Country: "FR"}, Work: Address{City: "Oslo"}, Name: "b"}
+		for _, model := range []*nestedModel{oslo, paris} {
+			if err := nestedModels.Save(model); err != nil {
+				t.Fatalf("Unexpected error in Save: %s", err.Error())
+			}
+		}
+		got := &nestedModel{}
+		if err := nestedModels.Find(oslo.ModelID(), got); err != nil {
+			t.Fatalf("Unexpected error in Find: %s", err.Error())
+		}
+		if *got != *oslo {
+			t.Errorf("Found model was incorrect. Expected %+v but got %+v", oslo, got)
+		}
+
+		// query using filters and orders on nested fields
+		gots := []*nestedModel{}
+		if err := nestedModels.NewQuery().Filter("Home.City =", "Oslo").Run(&gots); err != nil {
+			t.Fatalf("Unexpected error in Query.Run: %s", err.Error())
+		}
+		if len(gots) != 1 || gots[0].ModelID() != oslo.ModelID() {
+			t.Errorf("Expected query to return the model in Oslo but got %+v", gots)
+		}
+		if err := nestedModels.NewQuery().Order("-Work.City").Run(&gots); err != nil {
+			t.Fatalf("Unexpected error in Query.Run: %s", err.Error())
+		}
+		if len(gots) != 2 || gots[0].ModelID() != paris.ModelID() || gots[1].ModelID() != oslo.ModelID() {
+			t.Errorf("Expected query to return the models ordered by Work.City but got %+v", gots)
+		}
+
+		// the index should be updated when a nested field changes
+		oslo.Home.City = "Trondheim"
+		if err := nestedModels.Save(oslo); err != nil {
+			t.Fatalf("Unexpected error in Save: %s", err.Error())
+		}
+		count, err := nestedModels.NewQuery().Filter("Home.City =", "Oslo").Count()
+		if err != nil {
+			t.Fatalf("Unexpected error in Query.Count: %s", err.Error())
+		}
+		if count != 0 {
+			t.Errorf("Expected no models with Home.City = Oslo but got %d", count)
+		}
+	}
+}
+
+// Test that invalid uses of the nested option cause an error.
+func TestNestedOptionErrors(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	type Inner struct {
+		Attr string
+	}
+	type notStruct struct {
+		Inner *Inner `zoom:"nested"`
+		RandomID
+	}
+	type nestedAndIndex struct {
+		Inner Inner `zoom:"nested,index"`
+		RandomID
+	}
+	type duplicateRedisName struct {
+		Inner     Inner  `zoom:"nested"`
+		InnerAttr string `redis:"Inner.Attr"`
+		RandomID
+	}
+	for _, model := range []Model{&notStruct{}, &nestedAndIndex{}, &duplicateRedisName{}} {
+		if _, err := testPool.NewCollection(model); err == nil {
+			t.Errorf("Expected error when registering %T but got none", model)
+		}
+	}
+}
@@ -234,11 +234,65 @@ func createIndexedPointersModel() *indexedPointersModel {
 	}
 }
 
+// hashFieldModel is a model type with map fields stored in their own hashes.
+type hashFieldModel struct {
+	Name     string            `zoom:"index"`
+	Settings map[string]string `zoom:"hash"`
+	Scores   map[string]int    `zoom:"hash" redis:"scores"`
+	RandomID
+}
+
+// sliceFieldModel is a model type with slice fields stored in their own list
+// and set.
+type sliceFieldModel struct {
+	Name   string   `zoom:"index"`
+	Tags   []string `zoom:"list"`
+	Scores []int    `zoom:"set" redis:"scores"`
+	RandomID
+}
+
+// fullTextModel is a model type with full-text indexed fields.
+type fullTextModel struct {
+	Title string  `zoom:"fulltext,stem,stopwords"`
+	Body  *string `zoom:"fulltext"`
+	Rank  int     `zoom:"index"`
+	RandomID
+}
+
+// joinAuthor and joinPost are model types used for testing joins. Each
+// joinPost references a joinAuthor via its AuthorID field.
+type joinAuthor struct {
+	Name    string `zoom:"index"`
+	Country string `zoom:"index"`
+	Age     int    `zoom:"index"`
+	RandomID
+}
+
+type joinPost struct {
+	Title    string `zoom:"index"`
+	AuthorID string `zoom:"index"`
+	Likes    int    `zoom:"index"`
+	RandomID
+}
+
+// timestampedModel is a model type with automatic timestamps.
+type timestampedModel struct {
+	Name string `zoom:"index"`
+	Timestamps
+	RandomID
+}
+
 var (
 	testModels              *Collection
 	indexedTestModels       *Collection
 	indexedPrimativesModels *Collection
 	indexedPointersModels   *Collection
+	hashFieldModels         *Collection
+	sliceFieldModels        *Collection
+	fullTextModels          *Collection
+	joinAuthors             *Collection
+	joinPosts               *Collection
+	timestampedModels       *Collection
 )
 
 // registerTestingTypes registers the common types used for testing
@@ -268,6 +322,36 @@ func registerTestingTypes() {
 			model:      &indexedPointersModel{},
 			index:      true,
 		},
+		{
+			collection: &hashFieldModels,
+			model:      &hashFieldModel{},
+			index:      true,
+		},
+		{
+			collection: &sliceFieldModels,
+			model:      &sliceFieldModel{},
+			index:      true,
+		},
+		{
+			collection: &fullTextModels,
+			model:      &fullTextModel{},
+			index:      true,
+		},
+		{
+			collection: &joinAuthors,
+			model:      &joinAuthor{},
+			index:      true,
+		},
+		{
+			collection: &joinPosts,
+			model:      &joinPost{},
+			index:      true,
+		},
+		{
+			collection: &timestampedModels,
+			model:      &timestampedModel{},
+			index:      true,
+		},
 	}
 	for _, m := range testModelTypes {
 		options := DefaultCollectionOptions.WithIndex(true)
@@ -309,13 +393,14 @@ func testingTearDown() {
 	}
 }
 
-// expectSetContains sets an error via t.Errorf if member is not in the set
+// expectSetContains sets an error via t.Errorf if member is not in the set (or
+// sorted set)
 func expectSetContains(t *testing.T, setName string, member interface{}) {
 	conn := testPool.NewConn()
 	defer func() {
 		_ = conn.Close()
 	}()
-	contains, err := redis.Bool(conn.Do("SISMEMBER", setName, member))
+	contains, err := setContains(conn, setName, member)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
@@ -324,13 +409,14 @@ func expectSetContains(t *testing.T, setName string, member interface{}) {
 	}
 }
 
-// expectSetDoesNotContain sets an error via t.Errorf if member is in the set
+// expectSetDoesNotContain sets an error via t.Errorf if member is in the set (or
+// sorted set)
 func expectSetDoesNotContain(t *testing.T, setName string, member interface{}) {
 	conn := testPool.NewConn()
 	defer func() {
 		_ = conn.Close()
 	}()
-	contains, err := redis.Bool(conn.Do("SISMEMBER", setName, member))
+	contains, err := setContains(conn, setName, member)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
@@ -339,6 +425,21 @@ func expectSetDoesNotContain(t *testing.T, setName string, member interface{}) {
 	}
 }
 
+// setContains returns true iff member is in the set or sorted set identified
+// by setName. The index of all models is a sorted set for collections with a
+// sorted index.
+func setContains(conn redis.Conn, setName string, member interface{}) (bool, error) {
+	setType, err := redis.String(conn.Do("TYPE", setName))
+	if err != nil {
+		return false, err
+	}
+	if setType == "zset" {
+		score, err := conn.Do("ZSCORE", setName, member)
+		return score != nil, err
+	}
+	return redis.Bool(conn.Do("SISMEMBER", setName, member))
+}
+
 // expectFieldEquals sets an error via t.Errorf if the the field identified by fieldName does
 // not equal expected according to the database.
 func expectFieldEquals(t *testing.T, key string, fieldName string, marshalerUnmarshaler MarshalerUnmarshaler, expected interface{}) {
@@ -461,6 +562,8 @@ func indexExists(collection *Collection, model Model, fieldName string) (bool, e
 		typ = typ.Elem()
 	}
 	switch {
+	case fs.indexKind == integerIndex:
+		return integerIndexExists(collection, model, fieldName)
 	case typeIsNumeric(typ):
 		return numericIndexExists(collection, model, fieldName)
 	case typeIsString(typ):
@@ -529,7 +632,28 @@ func stringIndexExists(collection *Collection, model Model, fieldName string) (b
 	for fieldValue.Kind() == reflect.Ptr {
 		fieldValue = fieldValue.Elem()
 	}
-	memberKey := fieldValue.String() + nullString + model.ModelID()
+	memberKey := encodeStringIndexValue(fieldValue.String()) + nullString + model.ModelID()
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	reply, err := conn.Do("ZRANK", indexKey, memberKey)
+	if err != nil {
+		return false, fmt.Errorf("Error in ZRANK: %s", err.Error())
+	}
+	return reply != nil, nil
+}
+
+// integerIndexExists returns true iff an integer index on the given type and field exists. It
+// reads the current field value from model and if it is a pointer, dereferences it until
+// it reaches the underlying value.
+func integerIndexExists(collection *Collection, model Model, fieldName string) (bool, error) {
+	indexKey, err := collection.FieldIndexKey(fieldName)
+	if err != nil {
+		return false, err
+	}
+	fieldValue := reflect.ValueOf(model).Elem().FieldByName(fieldName)
+	memberKey := integerIndexValue(fieldValue) + nullString + model.ModelID()
 	conn := testPool.NewConn()
 	defer func() {
 		_ = conn.Close()
@@ -0,0 +1,224 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File tracking.go contains code related to change tracking, which allows
+// saving only the fields of a model which have changed since it was loaded.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ChangeTracking can be embedded in any model struct in order to keep track of
+// the values of its fields as of the last time it was loaded from or saved to
+// the database. SaveChanged uses these values to only write the fields which
+// have changed since then. ChangeTracking does not have any exported fields
+// and is not stored in the database. It must be embedded by value, not as a
+// pointer.
+type ChangeTracking struct {
+	// snapshot maps field names to the values returned by fieldSnapshot. It is
+	// never modified in place, so that copies of the model can safely share it.
+	snapshot map[string]interface{}
+}
+
+// changeTracked is satisfied by any model which embeds ChangeTracking.
+type changeTracked interface {
+	changeTracking() *ChangeTracking
+}
+
+// changeTracking returns ct, satisfying the changeTracked interface.
+func (ct *ChangeTracking) changeTracking() *ChangeTracking {
+	return ct
+}
+
+// Loaded returns true if the model has been loaded from or saved to the
+// database, i.e. if SaveChanged will only write the fields which have changed.
+func (ct *ChangeTracking) Loaded() bool {
+	return ct.snapshot != nil
+}
+
+// ResetChanges forgets the values recorded when the model was last loaded or
+// saved, so that the next call to SaveChanged writes all the fields.
+func (ct *ChangeTracking) ResetChanges() {
+	ct.snapshot = nil
+}
+
+// addSnapshot records the given values (a map of field names to the values
+// returned by fieldSnapshot) in addition to the values which were already
+// recorded.
+func (ct *ChangeTracking) addSnapshot(values map[string]interface{}) {
+	snapshot := make(map[string]interface{}, len(ct.snapshot)+len(values))
+	for name, value := range ct.snapshot {
+		snapshot[name] = value
+	}
+	for name, value := range values {
+		snapshot[name] = value
+	}
+	ct.snapshot = snapshot
+}
+
+// fieldSnapshot returns a copy of the value of the field described by fs, in
+// the same format that is used to store it in the database. Two snapshots of
+// the same field are equal (according to reflect.DeepEqual) iff saving the
+// field would not change its value in the database.
+func (mr *modelRef) fieldSnapshot(fs *fieldSpec) (interface{}, error) {
+	fieldVal := mr.fieldValue(fs.name)
+	switch fs.kind {
+	case hashField:
+		if fieldVal.Len() == 0 {
+			return nil, nil
+		}
+		values := map[string]interface{}{}
+		for _, mapKey := range fieldVal.MapKeys() {
+			value, err := mr.spec.hashValue(fs.elem, fieldVal.MapIndex(mapKey))
+			if err != nil {
+				return nil, err
+			}
+			values[mapKey.String()] = value
+		}
+		return values, nil
+	case listField, setField:
+		if fieldVal.Len() == 0 {
+			return nil, nil
+		}
+		return mr.spec.keyFieldElemArgs(fs, "", fieldVal)
+	}
+	value, err := mr.spec.hashValue(fs, fieldVal)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := value.([]byte); ok {
+		// Copy byte slices, which would otherwise share memory with the field.
+		value = append([]byte{}, b...)
+	}
+	return value, nil
+}
+
+// snapshotFields returns the snapshots of the fields with the given names,
+// except for computed fields. It returns nil if the model does not embed
+// ChangeTracking.
+func (mr *modelRef) snapshotFields(fieldNames []string) (map[string]interface{}, error) {
+	if _, ok := mr.model.(changeTracked); !ok {
+		return nil, nil
+	}
+	values := map[string]interface{}{}
+	for _, fieldName := range fieldNames {
+		fs, found := mr.spec.fieldsByName[fieldName]
+		if !found {
+			if fs, found = mr.spec.keyFieldByName(fieldName); !found {
+				continue
+			}
+		}
+		if fs.method != "" {
+			continue
+		}
+		value, err := mr.fieldSnapshot(fs)
+		if err != nil {
+			return nil, err
+		}
+		values[fieldName] = value
+	}
+	return values, nil
+}
+
+// recordLoadedFields records the current values of the fields with the given
+// names if the model embeds ChangeTracking. It should be called after the
+// fields have been scanned from the database.
+func (mr *modelRef) recordLoadedFields(fieldNames []string) error {
+	values, err := mr.snapshotFields(fieldNames)
+	if err != nil || values == nil {
+		return err
+	}
+	mr.model.(changeTracked).changeTracking().addSnapshot(values)
+	return nil
+}
+
+// recordSavedFields adds an action to the transaction which records the
+// current values of the fields with the given names if the model embeds
+// ChangeTracking. The values are read immediately, but only recorded if the
+// transaction is executed successfully.
+func (t *Transaction) recordSavedFields(mr *modelRef, fieldNames []string) {
+	values, err := mr.snapshotFields(fieldNames)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	if values == nil {
+		return
+	}
+	ct := mr.model.(changeTracked).changeTracking()
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.onSuccess = append(t.onSuccess, func() {
+		ct.addSnapshot(values)
+	})
+}
+
+// SaveChanged is like Save, but if the model embeds ChangeTracking and was
+// loaded from or saved to the database before, it only writes the fields
+// (and updates the indexes on the fields) whose values have changed since
+// then, like SaveFields. Fields which were not loaded (e.g. because they were
+// excluded from a query) are always written, as are any computed fields if
+// another field has changed. If nothing has changed, SaveChanged does not send
+// any commands to the database. If the model does not embed ChangeTracking or
+// has not been loaded, or if the collection uses the document layout and any
+// field has changed, SaveChanged works exactly like Save.
+func (c *Collection) SaveChanged(model Model) error {
+	t := c.pool.NewTransaction()
+	t.SaveChanged(c, model)
+	return t.Exec()
+}
+
+// SaveChanged is like Collection.SaveChanged but runs inside an existing
+// transaction. Any errors encountered will be added to the transaction and
+// returned as an error when the transaction is executed. The values of the
+// fields are only recorded as the new values to compare against if the
+// transaction is executed successfully.
+func (t *Transaction) SaveChanged(c *Collection, model Model) {
+	if c == nil {
+		t.setError(newNilCollectionError("SaveChanged"))
+		return
+	}
+	if err := c.checkModelType(model); err != nil {
+		t.setError(fmt.Errorf("zoom: Error in SaveChanged or Transaction.SaveChanged: %w", err))
+		return
+	}
+	tracked, ok := model.(changeTracked)
+	if !ok || !tracked.changeTracking().Loaded() {
+		t.Save(c, model)
+		return
+	}
+	snapshot := tracked.changeTracking().snapshot
+	mr := &modelRef{
+		collection: c,
+		model:      model,
+		spec:       c.spec,
+	}
+	fields := append(append([]*fieldSpec{}, c.spec.fields...), c.spec.keyFields...)
+	changed := []string{}
+	for _, fs := range fields {
+		value, err := mr.fieldSnapshot(fs)
+		if err != nil {
+			t.setError(err)
+			return
+		}
+		if old, found := snapshot[fs.name]; !found || !reflect.DeepEqual(old, value) {
+			changed = append(changed, fs.name)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	if c.spec.document {
+		// Fields cannot be saved individually, so save the whole document.
+		t.Save(c, model)
+		return
+	}
+	for _, fs := range c.spec.computedFields {
+		changed = append(changed, fs.name)
+	}
+	t.SaveFields(c, changed, model)
+}
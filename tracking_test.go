@@ -0,0 +1,120 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File tracking_test.go tests the code in tracking.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// trackedTestModel is a model which embeds ChangeTracking.
+type trackedTestModel struct {
+	Name string `zoom:"index"`
+	Age  int    `zoom:"index"`
+	Bio  string
+	Tags []string `zoom:"list"`
+	ChangeTracking
+	RandomID
+}
+
+func newTrackedTestCollection(t *testing.T) (*Collection, *Pool) {
+	pool := NewPoolWithOptions(testPool.options)
+	col, err := pool.NewCollectionWithOptions(&trackedTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	return col, pool
+}
+
+// savedCommands returns the commands that SaveChanged would send for model.
+func savedCommands(t *testing.T, pool *Pool, col *Collection, model Model) []string {
+	tx := pool.NewTransaction()
+	tx.SaveChanged(col, model)
+	commands, err := tx.DryRun()
+	require.NoError(t, err)
+	names := []string{}
+	for _, command := range commands {
+		names = append(names, command.String())
+	}
+	return names
+}
+
+func TestSaveChanged(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	col, pool := newTrackedTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	model := &trackedTestModel{Name: "Alice", Age: 30, Bio: "Hello", Tags: []string{"a", "b"}}
+	assert.False(t, model.Loaded())
+	// A model which has not been loaded or saved is saved entirely
+	require.NoError(t, col.SaveChanged(model))
+	assert.True(t, model.Loaded())
+	assert.Empty(t, savedCommands(t, pool, col, model))
+
+	// Only changed fields should be written after Find
+	got := &trackedTestModel{}
+	require.NoError(t, col.Find(model.ID, got))
+	assert.True(t, got.Loaded())
+	assert.Empty(t, savedCommands(t, pool, col, got))
+	got.Age = 31
+	commands := savedCommands(t, pool, col, got)
+	assert.Contains(t, commands, "HMSET trackedTestModel:"+model.ID+" Age 31")
+	for _, command := range commands {
+		assert.NotContains(t, command, "Name")
+		assert.NotContains(t, command, "Bio")
+		assert.NotContains(t, command, "Tags")
+	}
+	got.Tags = append(got.Tags, "c")
+	require.NoError(t, col.SaveChanged(got))
+	assert.Empty(t, savedCommands(t, pool, col, got))
+
+	// The changes should be saved and indexed
+	found := &trackedTestModel{}
+	require.NoError(t, col.Find(model.ID, found))
+	assert.Equal(t, 31, found.Age)
+	assert.Equal(t, "Hello", found.Bio)
+	assert.Equal(t, []string{"a", "b", "c"}, found.Tags)
+	ids, err := col.NewQuery().Filter("Age =", 31).IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.ID}, ids)
+
+	// Models loaded by a query are tracked too, but fields which were not
+	// loaded are always written
+	partial := []*trackedTestModel{}
+	require.NoError(t, col.NewQuery().Include("Name").Run(&partial))
+	require.Len(t, partial, 1)
+	partial[0].Name = "Alicia"
+	commands = savedCommands(t, pool, col, partial[0])
+	assert.Contains(t, commands, "HMSET trackedTestModel:"+model.ID+" Name Alicia Age 0 Bio ")
+
+	// ResetChanges should cause all fields to be written
+	found.ResetChanges()
+	assert.False(t, found.Loaded())
+	assert.NotEmpty(t, savedCommands(t, pool, col, found))
+}
+
+func TestSaveChangedTransactionFailure(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	col, pool := newTrackedTestCollection(t)
+	defer func() {
+		_ = pool.Close()
+	}()
+	model := &trackedTestModel{Name: "Bob", Age: 40}
+	require.NoError(t, col.Save(model))
+	model.Age = 41
+	// The new values should not be recorded if the transaction fails
+	tx := pool.NewTransaction()
+	tx.SaveChanged(col, model)
+	tx.Command("INVALIDCOMMAND", nil, nil)
+	assert.Error(t, tx.Exec())
+	assert.Contains(t, savedCommands(t, pool, col, model), "HMSET trackedTestModel:"+model.ID+" Age 41")
+}
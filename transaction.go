@@ -8,7 +8,12 @@
 package zoom
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -17,11 +22,71 @@ import (
 // Transactions consist of a set of actions which are either Redis
 // commands or lua scripts. Transactions feature delayed execution,
 // so nothing touches the database until you call Exec.
+//
+// It is safe to add actions to a transaction (e.g. by calling Save or Command)
+// from multiple goroutines at once. The actions added by each goroutine are
+// sent in the order they were added, but may be interleaved with the actions
+// added by other goroutines. Options such as Atomic, Timeout and WithActor
+// should be set before the transaction is shared between goroutines, and a
+// transaction can only be executed once. ReplyHandlers must not add actions to
+// the transaction which is executing them. To build independent transactions
+// in parallel, e.g. one per goroutine, use Clone.
 type Transaction struct {
+	// mut protects all the fields below it and the connection, which is used
+	// directly by a few methods (e.g. WatchKey) before the transaction is
+	// executed.
+	mut      sync.Mutex
 	conn     redis.Conn
+	pool     *Pool
 	actions  []*Action
 	err      error
 	watching []string
+	// primaryKeys are the keys of the new models with a primary field which were
+	// checked and watched by setPrimaryKey. If one of them is created by another
+	// client before the transaction is executed, Exec returns an error which
+	// wraps ErrDuplicatePrimaryKey instead of a WatchConflictError.
+	primaryKeys []string
+	// invalidations is the list of models and collections which should be
+	// removed from the pool's model cache after the transaction is executed.
+	invalidations []cacheInvalidation
+	// atomic is true iff the transaction must be executed in a single
+	// MULTI/EXEC block.
+	atomic bool
+	// batched is true iff Exec should split the transaction into batches of
+	// the size given by the BatchSize pool option (see Batched).
+	batched bool
+	// actor is recorded in the audit log of audited collections (see
+	// WithActor).
+	actor string
+	// timeout is the maximum amount of time to wait for each reply when the
+	// transaction is executed. 0 means no timeout.
+	timeout time.Duration
+	// ackReplicas is the number of replicas which must acknowledge the writes
+	// in the transaction, and ackTimeout is the maximum amount of time to wait
+	// for them (see RequireAck).
+	ackReplicas int
+	ackTimeout  time.Duration
+	// tmpKeys are the temporary keys created by the transaction (see
+	// newTmpKey). They are set to expire when the transaction is executed.
+	tmpKeys []string
+	// onSuccess are called after the transaction is executed without any
+	// errors, e.g. to record the saved values of change-tracked models (see
+	// ChangeTracking).
+	onSuccess []func()
+	// done is true iff the transaction has been executed (or DryRun has been
+	// called), after which its connection has been returned to the pool.
+	done bool
+	// queriedCollection is the name of the collection if the transaction was
+	// created to execute a Query, so that its latency can be recorded (see
+	// Pool.Metrics).
+	queriedCollection string
+	// coalesce is true iff redundant saves of the same model should be removed
+	// (see CoalesceSaves). saves maps each model and set of saved fields to the
+	// actions of the last save, and savesEnd is the number of actions after the
+	// last save.
+	coalesce bool
+	saves    map[string]actionRange
+	savesEnd int
 }
 
 // Action is a single step in a transaction and must be either a command
@@ -32,6 +97,20 @@ type Action struct {
 	script  *redis.Script
 	args    redis.Args
 	handler ReplyHandler
+	// modelID is the id of the model affected by the action, if known.
+	modelID string
+	// group is the name of the CommandGroup the action was added with, if
+	// any.
+	group string
+}
+
+// commandName returns the name of the command sent for a, which is EVALSHA for
+// scripts.
+func (a *Action) commandName() string {
+	if a.kind == scriptAction {
+		return "EVALSHA"
+	}
+	return a.name
 }
 
 // actionKind is either a command or a script
@@ -46,13 +125,97 @@ const (
 func (p *Pool) NewTransaction() *Transaction {
 	t := &Transaction{
 		conn: p.NewConn(),
+		pool: p,
 	}
 	return t
 }
 
+// Clone returns a new, empty transaction with its own connection and the same
+// options as t, i.e. whether it is atomic (see Atomic) or batched (see
+// Batched), its timeout, its actor (see WithActor), the number of replicas
+// which must acknowledge its writes (see RequireAck), and whether it coalesces
+// saves (see CoalesceSaves). The actions in t, its errors and any keys it is watching are not copied. Clone is
+// useful for fan-out patterns, where a template transaction is configured once
+// and each goroutine builds and executes its own copy independently, which
+// avoids interleaving the actions of different goroutines and allows them to be
+// executed in parallel.
+func (t *Transaction) Clone() *Transaction {
+	clone := t.pool.NewTransaction()
+	clone.atomic = t.atomic
+	clone.batched = t.batched
+	clone.timeout = t.timeout
+	clone.actor = t.actor
+	clone.ackReplicas = t.ackReplicas
+	clone.ackTimeout = t.ackTimeout
+	clone.coalesce = t.coalesce
+	return clone
+}
+
+// errTransactionDone is returned when a transaction is executed more than once.
+var errTransactionDone = fmt.Errorf("zoom: Transaction was already executed. Use a new Transaction (see Clone) instead")
+
+// Atomic marks the transaction as atomic and returns it. Exec always sends
+// transactions with more than one action in a single MULTI/EXEC block unless
+// they are split into batches (see ExecInBatches and Batched).
+// An atomic transaction is never split, so all of its commands and scripts are
+// executed as a single Redis transaction, without any commands from other
+// clients in between. Note that Redis does not roll back a transaction if one
+// of its commands fails at runtime. The remaining commands are still executed,
+// and Exec returns the first error.
+func (t *Transaction) Atomic() *Transaction {
+	t.atomic = true
+	return t
+}
+
+// Batched marks the transaction as batched and returns it. Exec splits a
+// batched transaction into batches of the size given by the BatchSize pool
+// option, just like ExecInBatches. Transactions which are not marked, including
+// the ones which Zoom uses internally (e.g. for Save, Delete, and Query.Update),
+// are never split by Exec, since only each batch would be atomic. Batched has no
+// effect if the transaction is atomic or watching any keys, or if the BatchSize
+// pool option is 0.
+func (t *Transaction) Batched() *Transaction {
+	t.batched = true
+	return t
+}
+
+// Timeout sets the maximum amount of time to wait for a reply from Redis when
+// the transaction is executed and returns the transaction. If Redis does not
+// reply in time (e.g. because a script is processing millions of members), Exec
+// returns a timeout error instead of blocking indefinitely, and the connection
+// is closed. Note that Redis may still execute the commands after the timeout.
+// For transactions sent in a single MULTI/EXEC block, the timeout applies to
+// the transaction as a whole, and for transactions split into batches it
+// applies to each batch. A timeout of 0 (the default) means Exec waits
+// indefinitely.
+func (t *Transaction) Timeout(d time.Duration) *Transaction {
+	t.timeout = d
+	return t
+}
+
+// timeoutConn is a connection which waits at most timeout for each reply.
+type timeoutConn struct {
+	redis.Conn
+	timeout time.Duration
+}
+
+// Do satisfies redis.Conn. It is like the Do method of the underlying
+// connection but waits at most c.timeout for the reply.
+func (c *timeoutConn) Do(name string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, c.timeout, name, args...)
+}
+
+// Receive satisfies redis.Conn. It is like the Receive method of the
+// underlying connection but waits at most c.timeout for the reply.
+func (c *timeoutConn) Receive() (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, c.timeout)
+}
+
 // SetError sets the err property of the transaction iff it was not already
 // set. This will cause exec to fail immediately.
 func (t *Transaction) setError(err error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
 	if t.err == nil {
 		t.err = err
 	}
@@ -60,16 +223,19 @@ func (t *Transaction) setError(err error) {
 
 // Watch issues a Redis WATCH command using the key for the given model. If the
 // model changes before the transaction is executed, Exec will return a
-// WatchError and the commands in the transaction will not be executed. Unlike
-// most other transaction methods, Watch does not use delayed execution. Because
-// of how the WATCH command works, Watch must send a command to Redis
-// immediately. You must call Watch or WatchKey before any other transaction
-// methods.
+// WatchConflictError and the commands in the transaction will not be
+// executed. Unlike most other transaction methods, Watch does not use delayed
+// execution. Because of how the WATCH command works, Watch must send a command
+// to Redis immediately. You must call Watch or WatchKey before any other
+// transaction methods.
 func (t *Transaction) Watch(model Model) error {
-	if len(t.actions) != 0 {
+	t.mut.Lock()
+	numActions := len(t.actions)
+	t.mut.Unlock()
+	if numActions != 0 {
 		return fmt.Errorf("Cannot call Watch after other commands have been added to the transaction")
 	}
-	col, err := getCollectionForModel(model)
+	col, err := t.pool.collectionForModel(model)
 	if err != nil {
 		return err
 	}
@@ -78,12 +244,17 @@ func (t *Transaction) Watch(model Model) error {
 }
 
 // WatchKey issues a Redis WATCH command using the given key. If the key changes
-// before the transaction is executed, Exec will return a WatchError and the
-// commands in the transaction will not be executed. Unlike most other
+// before the transaction is executed, Exec will return a WatchConflictError
+// and the commands in the transaction will not be executed. Unlike most other
 // transaction methods, WatchKey does not use delayed execution. Because of how
 // the WATCH command works, WatchKey must send a command to Redis immediately.
 // You must call Watch or WatchKey before any other transaction methods.
 func (t *Transaction) WatchKey(key string) error {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.done {
+		return errTransactionDone
+	}
 	if len(t.actions) != 0 {
 		return fmt.Errorf("Cannot call WatchKey after other commands have been added to the transaction")
 	}
@@ -98,26 +269,48 @@ func (t *Transaction) WatchKey(key string) error {
 // handler will be called with the reply from this specific command when
 // the transaction is executed.
 func (t *Transaction) Command(name string, args redis.Args, handler ReplyHandler) {
-	t.actions = append(t.actions, &Action{
+	t.modelCommand("", name, args, handler)
+}
+
+// Script adds a script action to the transaction with the given args.
+// handler will be called with the reply from this specific script when
+// the transaction is executed.
+func (t *Transaction) Script(script *redis.Script, args redis.Args, handler ReplyHandler) {
+	t.modelScript("", script, args, handler)
+}
+
+// modelCommand is like Command but records that the command affects the model
+// with the given id, which is reported in a TransactionError if the command
+// fails.
+func (t *Transaction) modelCommand(modelID string, name string, args redis.Args, handler ReplyHandler) {
+	t.addAction(&Action{
 		kind:    commandAction,
 		name:    name,
 		args:    args,
 		handler: handler,
+		modelID: modelID,
 	})
 }
 
-// Script adds a script action to the transaction with the given args.
-// handler will be called with the reply from this specific script when
-// the transaction is executed.
-func (t *Transaction) Script(script *redis.Script, args redis.Args, handler ReplyHandler) {
-	t.actions = append(t.actions, &Action{
+// modelScript is like Script but records that the script affects the model
+// with the given id, which is reported in a TransactionError if the script
+// fails.
+func (t *Transaction) modelScript(modelID string, script *redis.Script, args redis.Args, handler ReplyHandler) {
+	t.addAction(&Action{
 		kind:    scriptAction,
 		script:  script,
 		args:    args,
 		handler: handler,
+		modelID: modelID,
 	})
 }
 
+func (t *Transaction) addAction(a *Action) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.actions = append(t.actions, a)
+}
+
 // sendAction writes a to a connection buffer using conn.Send()
 func (t *Transaction) sendAction(a *Action) error {
 	switch a.kind {
@@ -142,61 +335,421 @@ func (t *Transaction) doAction(a *Action) (interface{}, error) {
 }
 
 // Exec executes the transaction, sequentially sending each action and
-// calling all the action handlers with the corresponding replies.
+// calling all the action handlers with the corresponding replies. If the
+// transaction was marked with Batched, the BatchSize pool option is greater
+// than 0, and the transaction is neither atomic nor watching any keys, Exec
+// works like ExecInBatches with the given batch size.
 func (t *Transaction) Exec() error {
+	t.mut.Lock()
+	batchSize := 0
+	if t.pool != nil && t.batched && len(t.watching) == 0 && !t.atomic {
+		batchSize = t.pool.options.BatchSize
+	}
+	t.mut.Unlock()
+	return t.exec(batchSize)
+}
+
+// ExecInBatches is like Exec but splits the actions in the transaction into
+// batches of at most batchSize actions, each of which is sent in its own
+// MULTI/EXEC block. This keeps very large transactions from exceeding the
+// limits of the Redis query and output buffers. The handlers for the actions in
+// each batch are called before the next batch is sent, and if an error occurs,
+// none of the remaining batches are sent. Note that the transaction as a whole
+// is no longer atomic, only each batch is. Because WATCH only applies to the
+// next MULTI/EXEC block, ExecInBatches returns an error if the transaction is
+// watching any keys (or was marked with Atomic) and has more than batchSize
+// actions.
+func (t *Transaction) ExecInBatches(batchSize int) error {
+	t.mut.Lock()
+	numWatching, numActions := len(t.watching), len(t.actions)
+	t.mut.Unlock()
+	if batchSize <= 0 {
+		t.setError(fmt.Errorf("zoom: error in ExecInBatches: batchSize must be greater than 0 but got %d", batchSize))
+	} else if numWatching > 0 && numActions > batchSize {
+		t.setError(fmt.Errorf("zoom: error in ExecInBatches: cannot split a transaction which is watching keys into more than one batch"))
+	} else if t.atomic && numActions > batchSize {
+		t.setError(fmt.Errorf("zoom: error in ExecInBatches: cannot split an atomic transaction into more than one batch"))
+	}
+	return t.exec(batchSize)
+}
+
+// ExecStreaming is like Exec but calls the handler for each action as soon as
+// its reply arrives, instead of after the replies for all the actions have been
+// read. The actions are pipelined without MULTI/EXEC, and at most window of
+// them are sent before their replies are read and their handlers called. This
+// bounds the number of replies which are buffered at once, which matters when
+// the replies are large (e.g. for transactions which read many models) or the
+// handlers are slow. Like ExecInBatches, the transaction as a whole is not
+// atomic. If a command fails or a handler returns an error, no more actions are
+// sent, the replies for the actions which were already sent are read without
+// calling their handlers, and ExecStreaming returns the error from the handler
+// or a TransactionError. ExecStreaming returns an error if the transaction is
+// watching any keys, or was marked with Atomic and has more than one action.
+func (t *Transaction) ExecStreaming(window int) error {
+	t.checkStreaming("ExecStreaming", window)
+	return t.run(func() error {
+		return t.execStream(window, nil)
+	})
+}
+
+// CommandTiming describes how long a single action in a transaction took. It is
+// returned by ExecWithTimings.
+type CommandTiming struct {
+	// Index is the position of the action in the transaction, starting at 0.
+	Index int
+	// Name is the name of the command, e.g. "HMSET". Lua scripts are described
+	// as "EVALSHA".
+	Name string
+	// ModelID is the id of the model which the command affects, or an empty
+	// string if it is not known.
+	ModelID string
+	// Group is the name of the CommandGroup the command was added with (see
+	// AddGroup), or an empty string if it was added directly to the
+	// transaction.
+	Group string
+	// Duration is the time between sending the command and receiving its reply,
+	// including the round trip to Redis.
+	Duration time.Duration
+	// HandlerDuration is the time spent in the handler for the reply, or 0 if
+	// the action has no handler or the handler was not called.
+	HandlerDuration time.Duration
+}
+
+// ExecWithTimings is like ExecStreaming with a window of 1, i.e. it sends each
+// action and waits for its reply before sending the next one, and returns how
+// long each action and its handler took. This is useful for finding the
+// commands, scripts or handlers which make a transaction slow. Because the
+// actions are not pipelined, the transaction as a whole takes longer than with
+// Exec, so ExecWithTimings is intended for profiling. If an error occurs, the
+// timings for the actions which were executed are returned along with it.
+func (t *Transaction) ExecWithTimings() ([]CommandTiming, error) {
+	t.checkStreaming("ExecWithTimings", 1)
+	timings := []CommandTiming{}
+	err := t.run(func() error {
+		return t.execStream(1, &timings)
+	})
+	return timings, err
+}
+
+// checkStreaming sets the error for the transaction if it cannot be executed
+// by the method with the given name without MULTI/EXEC, using the given
+// window.
+func (t *Transaction) checkStreaming(method string, window int) {
+	t.mut.Lock()
+	numWatching, numActions := len(t.watching), len(t.actions)
+	t.mut.Unlock()
+	if window <= 0 {
+		t.setError(fmt.Errorf("zoom: error in %s: window must be greater than 0 but got %d", method, window))
+	} else if numWatching > 0 {
+		t.setError(fmt.Errorf("zoom: error in %s: cannot execute a transaction which is watching keys without MULTI/EXEC", method))
+	} else if t.atomic && numActions > 1 {
+		t.setError(fmt.Errorf("zoom: error in %s: cannot execute an atomic transaction without MULTI/EXEC", method))
+	}
+}
+
+// CommandDescription describes a single Redis command which would be sent by a
+// transaction. It is returned by DryRun.
+type CommandDescription struct {
+	// Name is the name of the command, e.g. "HMSET". Lua scripts are described
+	// as an "EVALSHA" command.
+	Name string
+	// Args are the arguments for the command. For scripts, the first argument is
+	// the SHA1 hash of the script and the second is the number of keys
+	// (always 0 for scripts used by Zoom), followed by the arguments for the
+	// script.
+	Args []interface{}
+	// Group is the name of the CommandGroup the command was added with (see
+	// AddGroup), or an empty string if it was added directly to the
+	// transaction.
+	Group string
+}
+
+// String returns the command and its arguments separated by spaces, e.g.
+// "HMSET Person:abc Name Alice".
+func (cd CommandDescription) String() string {
+	parts := []string{cd.Name}
+	for _, arg := range cd.Args {
+		if b, ok := arg.([]byte); ok {
+			parts = append(parts, string(b))
+		} else {
+			parts = append(parts, fmt.Sprint(arg))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// DryRun returns a description of each command and script that would be sent
+// by Exec, in order, without sending them. This is useful for asserting on the
+// exact effects of Save, Delete, queries, and other operations in tests and
+// code review tooling. Note that the MULTI and EXEC commands which surround
+// the commands and the PEXPIRE commands for temporary keys are not included,
+// and that a few methods (e.g. WatchKey) send commands immediately instead of
+// adding them to the transaction. Like Exec, DryRun returns the first error
+// that occurred while adding commands to the transaction (if any), and returns
+// the connection to the pool, so the transaction cannot be used afterwards.
+func (t *Transaction) DryRun() ([]CommandDescription, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.done {
+		return nil, errTransactionDone
+	}
+	t.done = true
+	defer func() {
+		_ = t.conn.Close()
+	}()
+	if t.err != nil {
+		return nil, t.err
+	}
+	descriptions := make([]CommandDescription, len(t.actions))
+	for i, a := range t.actions {
+		switch a.kind {
+		case commandAction:
+			descriptions[i] = CommandDescription{
+				Name:  a.commandName(),
+				Args:  append([]interface{}{}, a.args...),
+				Group: a.group,
+			}
+		case scriptAction:
+			descriptions[i] = CommandDescription{
+				Name:  a.commandName(),
+				Args:  append([]interface{}{a.script.Hash(), 0}, a.args...),
+				Group: a.group,
+			}
+		}
+	}
+	return descriptions, nil
+}
+
+// exec executes the transaction. If batchSize is greater than 0, the actions
+// are sent in batches of at most batchSize actions.
+func (t *Transaction) exec(batchSize int) error {
+	return t.run(func() error {
+		if len(t.actions) == 1 && len(t.watching) == 0 {
+			// If there is only one command and no keys being watched, no need to use
+			// MULTI/EXEC
+			a := t.actions[0]
+			reply, err := t.doAction(a)
+			if redisErr, ok := err.(redis.Error); ok {
+				return newTransactionError([]CommandError{newCommandError(0, a, redisErr)}, 1)
+			} else if err != nil {
+				return err
+			}
+			if a.handler != nil {
+				if err := a.handler(reply); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if batchSize <= 0 || len(t.actions) <= batchSize {
+			return t.execBatch(t.actions, 0)
+		}
+		for start := 0; start < len(t.actions); start += batchSize {
+			end := start + batchSize
+			if end > len(t.actions) {
+				end = len(t.actions)
+			}
+			if err := t.execBatch(t.actions[start:end], start); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// run marks the transaction as done and calls send, which sends the actions in
+// the transaction, unless the transaction had an error. After send returns, it
+// invalidates cached models, calls the onSuccess functions if there was no
+// error, and returns the connection to the pool. The lock is held while send
+// is called.
+func (t *Transaction) run(send func() error) (err error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.done {
+		return errTransactionDone
+	}
+	t.done = true
+	start := time.Now()
+	defer func() {
+		t.pool.metrics.recordExec(t.queriedCollection, time.Since(start), err)
+	}()
+	if t.timeout > 0 {
+		t.conn = &timeoutConn{Conn: t.conn, timeout: t.timeout}
+	}
 	// Return the connection to the pool when we are done
 	defer func() {
 		_ = t.conn.Close()
 	}()
+	// Remove any models which were changed from the cache. Even if there was an
+	// error, some of the changes may have been written.
+	defer t.applyCacheInvalidations()
+	defer func() {
+		// The writes were committed even if they were not replicated in time.
+		if err == nil || errors.Is(err, ErrNotReplicated) {
+			for _, f := range t.onSuccess {
+				f()
+			}
+		}
+		t.onSuccess = nil
+	}()
 
 	// If the transaction had an error from a previous command, return it
 	// and don't continue
 	if t.err != nil {
 		return t.err
 	}
+	if err := send(); err != nil {
+		return err
+	}
+	return t.waitForAck()
+}
 
-	if len(t.actions) == 1 && len(t.watching) == 0 {
-		// If there is only one command and no keys being watched, no need to use
-		// MULTI/EXEC
-		a := t.actions[0]
-		reply, err := t.doAction(a)
-		if err != nil {
+// execBatch sends the given actions at once using MULTI/EXEC and then calls
+// the corresponding handlers with the replies. offset is the index of the
+// first action in the transaction, which is used in errors. If any of the
+// commands fail, the handlers for the commands after the first failure are not
+// called and execBatch returns a TransactionError.
+func (t *Transaction) execBatch(actions []*Action, offset int) error {
+	if err := t.conn.Send("MULTI"); err != nil {
+		return err
+	}
+	for _, a := range actions {
+		if err := t.sendAction(a); err != nil {
 			return err
 		}
-		if a.handler != nil {
+	}
+	if err := t.sendTmpKeyExpirations(); err != nil {
+		return err
+	}
+	// Invoke redis driver to execute the transaction
+	replies, err := redis.Values(t.conn.Do("EXEC"))
+	if err != nil {
+		if err == redis.ErrNil && len(t.watching) > 0 {
+			return t.watchConflictError()
+		}
+		return err
+	}
+	// Iterate through the replies, calling the corresponding handler functions.
+	// Any replies after those for the actions are for the expirations and can
+	// be ignored.
+	var commandErrs []CommandError
+	for i, a := range actions {
+		reply := replies[i]
+		if err, ok := reply.(error); ok {
+			commandErrs = append(commandErrs, newCommandError(offset+i, a, err))
+			continue
+		}
+		if a.handler != nil && len(commandErrs) == 0 {
 			if err := a.handler(reply); err != nil {
 				return err
 			}
 		}
-	} else {
-		// Send all the commands and scripts at once using MULTI/EXEC
-		if err := t.conn.Send("MULTI"); err != nil {
-			return err
-		}
-		for _, a := range t.actions {
-			if err := t.sendAction(a); err != nil {
+	}
+	if len(commandErrs) > 0 {
+		return newTransactionError(commandErrs, offset+len(actions))
+	}
+	return nil
+}
+
+// execStream pipelines the actions in the transaction without MULTI/EXEC,
+// sending at most window actions before reading their replies, and calls each
+// handler as soon as the reply for its action is read. If timings is not nil,
+// the timing of each action is appended to it, which is only accurate if window
+// is 1.
+func (t *Transaction) execStream(window int, timings *[]CommandTiming) error {
+	var commandErrs []CommandError
+	var handlerErr error
+	var sentAt time.Time
+	sent, received := 0, 0
+	stopped := false
+	for received < sent || (!stopped && sent < len(t.actions)) {
+		if !stopped && sent < len(t.actions) && sent-received < window {
+			for sent < len(t.actions) && sent-received < window {
+				if err := t.sendAction(t.actions[sent]); err != nil {
+					return err
+				}
+				sent++
+			}
+			sentAt = time.Now()
+			if err := t.conn.Flush(); err != nil {
 				return err
 			}
 		}
-		// Invoke redis driver to execute the transaction
-		replies, err := redis.Values(t.conn.Do("EXEC"))
-		if err != nil {
-			if err == redis.ErrNil && len(t.watching) > 0 {
-				return WatchError{keys: t.watching}
+		i, a := received, t.actions[received]
+		reply, err := t.conn.Receive()
+		received++
+		timing := CommandTiming{
+			Index:    i,
+			Name:     a.commandName(),
+			ModelID:  a.modelID,
+			Group:    a.group,
+			Duration: time.Since(sentAt),
+		}
+		if redisErr, ok := err.(redis.Error); ok {
+			commandErrs = append(commandErrs, newCommandError(i, a, redisErr))
+			stopped = true
+		} else if err != nil {
+			return err
+		} else if a.handler != nil && !stopped {
+			start := time.Now()
+			if err := a.handler(reply); err != nil {
+				handlerErr = err
+				stopped = true
 			}
+			timing.HandlerDuration = time.Since(start)
+		}
+		if timings != nil {
+			*timings = append(*timings, timing)
+		}
+	}
+	// Expire the temporary keys even if there was an error, since the actions
+	// which would have deleted them may not have been sent.
+	if len(t.tmpKeys) > 0 && t.pool != nil && t.pool.options.TempKeyTTL > 0 {
+		if err := t.sendTmpKeyExpirations(); err != nil {
 			return err
 		}
-		// Iterate through the replies, calling the corresponding handler functions
-		for i, reply := range replies {
-			a := t.actions[i]
-			if err, ok := reply.(error); ok {
+		if err := t.conn.Flush(); err != nil {
+			return err
+		}
+		for range t.tmpKeys {
+			if _, err := t.conn.Receive(); err != nil {
 				return err
 			}
-			if a.handler != nil {
-				if err := a.handler(reply); err != nil {
-					return err
-				}
-			}
+		}
+	}
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if len(commandErrs) > 0 {
+		return newTransactionError(commandErrs, sent)
+	}
+	return nil
+}
+
+// newTmpKey returns a new random key with the given prefix, which should start
+// with "tmp:". The key is set to expire after the TempKeyTTL of the pool at the
+// end of each MULTI/EXEC block, so that it is not left behind forever if the
+// transaction is split into batches and one of them fails before the key is
+// deleted.
+func (t *Transaction) newTmpKey(prefix string) string {
+	key := generateRandomKey(prefix)
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.tmpKeys = append(t.tmpKeys, key)
+	return key
+}
+
+// sendTmpKeyExpirations sends a PEXPIRE command for each of the temporary keys
+// created by the transaction. It has no effect on keys which have already been
+// deleted.
+func (t *Transaction) sendTmpKeyExpirations() error {
+	if t.pool == nil || t.pool.options.TempKeyTTL <= 0 {
+		return nil
+	}
+	ttl := int64(t.pool.options.TempKeyTTL / time.Millisecond)
+	for _, key := range t.tmpKeys {
+		if err := t.conn.Send("PEXPIRE", key, ttl); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -211,16 +764,173 @@ func (t *Transaction) Exec() error {
 // the return value of the script. You can use the Name method of a Collection
 // to get the name.
 func (t *Transaction) DeleteModelsBySetIDs(setKey string, collectionName string, handler ReplyHandler) {
-	t.Script(deleteModelsBySetIdsScript, redis.Args{setKey, collectionName}, handler)
+	t.invalidateCachedCollection(collectionName)
+	t.Script(deleteModelsBySetIdsScript, redis.Args{setKey, collectionName, collectionName + ":"}, handler)
+}
+
+// deleteModelsBySetIDs is like DeleteModelsBySetIDs but also deletes the keys
+// for any fields of spec which are stored in their own key and removes the
+// models from any full-text, null, and bitmap indexes.
+func (t *Transaction) deleteModelsBySetIDs(setKey string, spec *modelSpec, handler ReplyHandler) {
+	t.invalidateCachedCollection(spec.name)
+	args := redis.Args{setKey, spec.name, spec.indexKeyPrefix()}
+	for _, fs := range spec.keyFields {
+		args = args.Add(fs.redisName, "key")
+	}
+	for _, fs := range spec.fieldsWithComputed() {
+		if fs.fullText != nil {
+			args = args.Add(fs.redisName, "fulltext")
+		}
+		if fs.hasNullIndex() {
+			args = args.Add(fs.redisName, "null")
+		}
+		if fs.hasBitmapIndex() {
+			args = args.Add(fs.redisName, "bitmap")
+		}
+	}
+	t.Script(deleteModelsBySetIdsScript, args, handler)
+}
+
+// deleteModelsByListIDs is a small function wrapper around a Lua script. The
+// script will atomically delete the models corresponding to the ids in the list
+// (or set, or sorted set) identified by listKey, including any field indexes
+// (and bitmap indexes), full-text indexes, and the keys for any fields stored
+// in their own key, and return the number of models that were deleted. You can
+// pass in a handler (e.g. NewScanIntHandler) to capture the return value of the
+// script.
+func (t *Transaction) deleteModelsByListIDs(listKey string, spec *modelSpec, handler ReplyHandler) {
+	t.invalidateCachedCollection(spec.name)
+	args := redis.Args{listKey, spec.name, spec.indexKeyPrefix(), spec.documentFieldArg()}
+	for _, fs := range spec.fieldsWithComputed() {
+		if fs.indexKind != noIndex {
+			args = args.Add(fs.redisName, fs.indexKindName())
+		}
+	}
+	for _, fs := range spec.keyFields {
+		args = args.Add(fs.redisName, "key")
+	}
+	for _, fs := range spec.fieldsWithComputed() {
+		if fs.fullText != nil {
+			args = args.Add(fs.redisName, "fulltext")
+		}
+		if fs.hasNullIndex() {
+			args = args.Add(fs.redisName, "null")
+		}
+		if fs.hasBitmapIndex() {
+			args = args.Add(fs.redisName, "bitmap")
+		}
+	}
+	t.Script(deleteModelsByIdsListScript, args, handler)
+}
+
+// updateModelsByListIDs is a small function wrapper around a Lua script. The
+// script will atomically update the fields of the models corresponding to the
+// ids in the list identified by listKey and return the number of models that
+// were updated. fieldArgs should be created with modelSpec.updateArgs. You can
+// pass in a handler (e.g. NewScanIntHandler) to capture the return value of the
+// script.
+func (t *Transaction) updateModelsByListIDs(listKey string, spec *modelSpec, fieldArgs redis.Args, handler ReplyHandler) {
+	t.invalidateCachedCollection(spec.name)
+	args := redis.Args{listKey, spec.name, spec.indexKeyPrefix()}
+	args = append(args, fieldArgs...)
+	t.Script(updateModelsByIdsListScript, args, handler)
+}
+
+// syncModelIndexes is a small function wrapper around a Lua script. The script
+// will atomically read the current field values for the model with the given id
+// and update the field indexes (and the set of all ids, if the collection is
+// indexed) to match. If the model no longer exists, it will be removed from all
+// indexes. Collated string indexes must be updated with syncCollatedIndexes
+// afterwards.
+func (t *Transaction) syncModelIndexes(c *Collection, id string) {
+	t.invalidateCachedModel(c, id)
+	indexes := []FieldIndex{}
+	for _, fs := range c.spec.fieldsWithComputed() {
+		if fs.indexKind != noIndex {
+			indexes = append(indexes, FieldIndex{
+				RedisName: fs.redisName,
+				Key:       c.spec.indexKeyForField(fs),
+				Kind:      fs.indexKindName(),
+				Pointer:   fs.kind == pointerField,
+			})
+		}
+	}
+	t.SyncModelIndexes(c.Name(), id, c.index, indexes)
+	t.updateBitmapIndexes(c.spec, id, "sync", c.spec.fields)
+}
+
+// FieldIndex describes the index on a single field of a model. It is used to
+// maintain the indexes of a collection by name, without the Go type of its
+// models (e.g. in the zoom command-line tool).
+type FieldIndex struct {
+	// RedisName is the name of the field as it is stored in Redis.
+	RedisName string
+	// Key is the key of the index. If it is empty, the key is the name of the
+	// collection followed by a colon and RedisName, unless a collection with
+	// the name is registered with the index namespace (see
+	// CollectionOptions.IndexNamespace), in which case "index:" is inserted
+	// after the colon.
+	Key string
+	// Kind is the kind of index, which must be one of "numeric", "boolean",
+	// "string", "integer", "collated", or "enum". Since the collation keys of a
+	// "collated" index (see CollectionOptions.Collations) can only be computed
+	// in Go, SyncModelIndexes only removes the model from such an index if the
+	// field has no value.
+	Kind string
+	// Pointer is true if the field is a pointer, i.e. if the value "NULL" means
+	// nil and the field has a null index.
+	Pointer bool
+}
+
+// SyncModelIndexes is a small function wrapper around a Lua script. The script
+// will atomically read the current field values for the model with the given
+// id in the collection with the given name and update the given field indexes
+// (and the set of all ids, if indexAll is true) to match. If the model no
+// longer exists, it will be removed from all indexes. Unlike the other methods
+// of Transaction, it does not require the Go type of the models to be
+// registered, but Zoom cannot check that indexes matches the collection. If
+// the set of all ids does not exist yet, it is created as a sorted set iff a
+// collection with the given name is registered with a sorted index (see
+// CollectionOptions.SortedIndex). Otherwise the existing set (or sorted set) is
+// updated. The old member of the model in a "string" or "integer" index is
+// looked up in a hash which Zoom keeps next to the index (the key of the index
+// followed by ":members"), instead of scanning the whole index.
+func (t *Transaction) SyncModelIndexes(collectionName string, id string, indexAll bool, indexes []FieldIndex) {
+	indexType := convertBoolToInt(indexAll)
+	indexPrefix := collectionName + ":"
+	if c, found := t.pool.Collection(collectionName); found {
+		if err := c.checkHashLayout("SyncModelIndexes"); err != nil {
+			t.setError(err)
+			return
+		}
+		if indexAll && c.sortedIndex {
+			indexType = 2
+		}
+		indexPrefix = c.spec.indexKeyPrefix()
+	}
+	args := redis.Args{collectionName, id, indexType, indexScore(time.Now())}
+	for _, index := range indexes {
+		switch index.Kind {
+		case "numeric", "boolean", "string", "integer", "collated":
+		default:
+			t.setError(fmt.Errorf("zoom: error in SyncModelIndexes: invalid index kind %q for field %s", index.Kind, index.RedisName))
+			return
+		}
+		key := index.Key
+		if key == "" {
+			key = indexPrefix + index.RedisName
+		}
+		args = args.Add(index.RedisName, key, index.Kind, convertBoolToInt(index.Pointer))
+	}
+	t.modelScript(id, syncModelIndexesScript, args, nil)
 }
 
 // deleteStringIndex is a small function wrapper around a Lua script. The script
-// will atomically remove the existing string index, if any, on the given
-// fieldName for the model with the given modelID. You can use the Name method
-// of a Collection to get its name. fieldName should be the name as it is stored
-// in Redis.
-func (t *Transaction) deleteStringIndex(collectionName, modelID, fieldName string) {
-	t.Script(deleteStringIndexScript, redis.Args{collectionName, modelID, fieldName}, nil)
+// will atomically remove the existing string (or integer) index, if any, on the
+// field fs of spec for the model with the given modelID. fs should have a
+// string or integer index.
+func (t *Transaction) deleteStringIndex(spec *modelSpec, modelID string, fs *fieldSpec) {
+	t.modelScript(modelID, deleteStringIndexScript, redis.Args{spec.name, modelID, fs.redisName, fs.indexKindName(), spec.indexKeyPrefix(), spec.documentFieldArg()}, nil)
 }
 
 // ExtractIDsFromFieldIndex is a small function wrapper around a Lua script. The
@@ -233,16 +943,204 @@ func (t *Transaction) ExtractIDsFromFieldIndex(setKey string, destKey string, mi
 	t.Script(extractIdsFromFieldIndexScript, redis.Args{setKey, destKey, min, max}, nil)
 }
 
+// extractLastIDs is a small function wrapper around a Lua script. The script
+// will extract the last n ids from the sorted set identified by setKey using
+// ZREVRANGE (or ZRANGE if reverse is true) and store them in a sorted set
+// identified by destKey, preserving their relative order.
+func (t *Transaction) extractLastIDs(setKey, destKey string, n uint, reverse bool) {
+	t.Script(extractLastIdsScript, redis.Args{setKey, destKey, n, convertBoolToInt(reverse)}, nil)
+}
+
+// intersectIDsWithKey is a small function wrapper around a Lua script. The
+// script will intersect the ids in origKey with the ids in the set, sorted set,
+// or list identified by idsKey and store the result in a sorted set identified
+// by destKey, preserving the scores from origKey.
+func (t *Transaction) intersectIDsWithKey(origKey, idsKey, destKey string) {
+	t.Script(intersectIdsWithKeyScript, redis.Args{origKey, idsKey, destKey}, nil)
+}
+
+// joinIDs is a small function wrapper around a Lua script. The script will
+// find the ids of the models whose value in the string index identified by
+// fieldIndexKey is one of the ids in targetIDsKey, intersect them with the ids
+// in origKey, and store the result in a sorted set identified by destKey,
+// preserving the scores from origKey.
+func (t *Transaction) joinIDs(origKey, fieldIndexKey, targetIDsKey, destKey string) {
+	t.Script(joinIdsScript, redis.Args{origKey, fieldIndexKey, targetIDsKey, destKey}, nil)
+}
+
 // ExtractIDsFromStringIndex is a small function wrapper around a Lua script.
 // The script will extract the ids from a sorted set identified by setKey using
 // ZRANGEBYLEX with the given min and max, and then store them in a sorted set
 // identified by destKey. All the scores for the sorted set should be 0, and the
 // members should follow the format <value>\x00<id>, where <value> is the string
 // value, \x000 is the NULL ASCII character and <id> is the id of the model
-// with that value. As with all string indexes in Zoom, the value cannot contain
-// the NULL ASCII character or the DEL character (codepoints 0 and 127
-// respectively). Note that the stored ids are sorted in ASCII order according
-// to their corresponding string values.
+// with that value. The value cannot contain the NULL ASCII character, so the
+// string indexes maintained by Zoom escape it (use ExtractIDsByLexRange to
+// filter them by the field values instead). Note that the stored ids are
+// sorted in ASCII order according to their corresponding string values.
 func (t *Transaction) ExtractIDsFromStringIndex(setKey, destKey, min, max string) {
 	t.Script(extractIdsFromStringIndexScript, redis.Args{setKey, destKey, min, max}, nil)
 }
+
+// ExtractIDsByNumericRange adds a command to the transaction which will store
+// the ids of all models in the collection whose value for the given field falls
+// within the range specified by min and max. fieldName must identify a field
+// with a numeric or boolean index. min and max follow the same syntax as the
+// ZRANGEBYSCORE command: they may be numbers (inclusive), numbers prefixed by
+// "(" (exclusive), or the special values "-inf" and "+inf". Boolean values are
+// indexed as 0 (false) and 1 (true).
+//
+// The ids are added to the sorted set identified by destKey, which is not
+// cleared beforehand. The score of each id is its 1-based position in the
+// range, so the ids in destKey are ordered by field value and can be used as
+// the input to other set operations such as ZINTERSTORE, ZUNIONSTORE, or SORT.
+// Any errors (e.g. if the field is not indexed) will be added to the
+// transaction and returned when the transaction is executed.
+func (t *Transaction) ExtractIDsByNumericRange(c *Collection, fieldName string, destKey string, min interface{}, max interface{}) {
+	if c == nil {
+		t.setError(newNilCollectionError("ExtractIDsByNumericRange"))
+		return
+	}
+	fs, found := c.spec.fieldsByName[fieldName]
+	if !found {
+		t.setError(newKindError(ErrFieldNotFound, "zoom: Error in ExtractIDsByNumericRange: Collection %s does not have field named %s", c.Name(), fieldName))
+		return
+	}
+	if fs.indexKind != numericIndex && fs.indexKind != booleanIndex && fs.indexKind != integerIndex {
+		t.setError(fmt.Errorf("zoom: Error in ExtractIDsByNumericRange: %s.%s does not have a numeric or boolean index", c.Name(), fieldName))
+		return
+	}
+	indexKey, err := c.spec.fieldIndexKey(fieldName)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	if fs.indexKind == integerIndex {
+		// Integer indexes are stored like string indexes, so the bounds need to be
+		// translated to the format of their members.
+		indexMin, err := integerIndexBound(min, false)
+		if err != nil {
+			t.setError(fmt.Errorf("zoom: Error in ExtractIDsByNumericRange: %w", err))
+			return
+		}
+		indexMax, err := integerIndexBound(max, true)
+		if err != nil {
+			t.setError(fmt.Errorf("zoom: Error in ExtractIDsByNumericRange: %w", err))
+			return
+		}
+		t.ExtractIDsFromStringIndex(indexKey, destKey, indexMin, indexMax)
+		return
+	}
+	t.ExtractIDsFromFieldIndex(indexKey, destKey, min, max)
+}
+
+// ExtractIDsByLexRange adds a command to the transaction which will store the
+// ids of all models in the collection whose value for the given field falls
+// within the range specified by min and max. fieldName must identify a field
+// with a string index. min and max follow the same syntax as the ZRANGEBYLEX
+// command, except that they refer to the field values themselves: a value
+// prefixed with "[" is inclusive, a value prefixed with "(" is exclusive, and
+// the special values "-" and "+" mean negative and positive infinity. For
+// example, min = "[a" and max = "(b" would match all values that start with
+// "a". ExtractIDsByLexRange takes care of translating the range to the format
+// used internally by string indexes, so callers don't need to know about it.
+//
+// The ids are added to the sorted set identified by destKey, which is not
+// cleared beforehand. The score of each id is its 1-based position in the
+// range, so the ids in destKey are ordered by field value (in ASCII order, or
+// the order of the collation if the field has one, see
+// CollectionOptions.Collations) and can be used as the input to other set
+// operations such as ZINTERSTORE, ZUNIONSTORE, or SORT. Any errors (e.g. if the
+// field is not indexed or the range is invalid) will be added to the
+// transaction and returned when the transaction is executed.
+func (t *Transaction) ExtractIDsByLexRange(c *Collection, fieldName string, destKey string, min string, max string) {
+	if c == nil {
+		t.setError(newNilCollectionError("ExtractIDsByLexRange"))
+		return
+	}
+	fs, found := c.spec.fieldsByName[fieldName]
+	if !found {
+		t.setError(newKindError(ErrFieldNotFound, "zoom: Error in ExtractIDsByLexRange: Collection %s does not have field named %s", c.Name(), fieldName))
+		return
+	}
+	if fs.indexKind != stringIndex {
+		t.setError(fmt.Errorf("zoom: Error in ExtractIDsByLexRange: %s.%s does not have a string index", c.Name(), fieldName))
+		return
+	}
+	indexKey, err := c.spec.fieldIndexKey(fieldName)
+	if err != nil {
+		t.setError(err)
+		return
+	}
+	indexMin, err := stringIndexBound(fs.collateBound(min), false)
+	if err != nil {
+		t.setError(fmt.Errorf("zoom: Error in ExtractIDsByLexRange: %w", err))
+		return
+	}
+	indexMax, err := stringIndexBound(fs.collateBound(max), true)
+	if err != nil {
+		t.setError(fmt.Errorf("zoom: Error in ExtractIDsByLexRange: %w", err))
+		return
+	}
+	t.ExtractIDsFromStringIndex(indexKey, destKey, indexMin, indexMax)
+}
+
+// stringIndexBound converts a ZRANGEBYLEX-style bound on a field value to the
+// corresponding bound on the members of a string index, which have the format
+// <value>\x00<id> where <value> is encoded with encodeStringIndexValue. isMax
+// should be true if bound is the max argument.
+func stringIndexBound(bound string, isMax bool) (string, error) {
+	if bound == "-" || bound == "+" {
+		return bound, nil
+	}
+	if len(bound) == 0 || (bound[0] != '[' && bound[0] != '(') {
+		return "", fmt.Errorf("invalid range bound %q (should start with \"[\" or \"(\" or be one of \"-\" or \"+\")", bound)
+	}
+	value := encodeStringIndexValue(bound[1:])
+	inclusive := bound[0] == '['
+	switch {
+	case inclusive && !isMax:
+		return "[" + value, nil
+	case !inclusive && isMax:
+		return "(" + value, nil
+	default:
+		// An exclusive min or an inclusive max should skip past every member which
+		// starts with value followed by the NULL separator.
+		return "(" + value + nullString + maxByteString, nil
+	}
+}
+
+// integerIndexBound converts a ZRANGEBYSCORE-style bound on the value of an
+// int64 or uint64 field to the corresponding bound on the members of an integer
+// index. bound may be an integer, a string containing an integer optionally
+// prefixed by "(" (exclusive), or one of the special values "-inf" and "+inf".
+// isMax should be true if bound is the max argument.
+func integerIndexBound(bound interface{}, isMax bool) (string, error) {
+	s := fmt.Sprint(bound)
+	switch s {
+	case "-inf":
+		return "-", nil
+	case "+inf", "inf":
+		return "+", nil
+	}
+	prefix := "["
+	if strings.HasPrefix(s, "(") {
+		prefix = "("
+		s = s[1:]
+	}
+	var value string
+	if strings.HasPrefix(s, "-") {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid range bound %q for an integer field", bound)
+		}
+		value = encodeInt64(i)
+	} else {
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid range bound %q for an integer field", bound)
+		}
+		value = encodeUint64(u)
+	}
+	return stringIndexBound(prefix+value, isMax)
+}
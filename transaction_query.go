@@ -1,6 +1,12 @@
 package zoom
 
-import "github.com/garyburd/redigo/redis"
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/garyburd/redigo/redis"
+)
 
 // TransactionQuery represents a query which will be run inside an existing
 // transaction. A TransactionQuery may consist of one or more query modifiers
@@ -37,10 +43,20 @@ func newTransactionQuery(query *query, tx *Transaction) *TransactionQuery {
 // Count, etc) do not return anything. Instead they accept arguments which are
 // then mutated after the transaction is executed.
 func (tx *Transaction) Query(collection *Collection) *TransactionQuery {
-	return &TransactionQuery{
+	q := &TransactionQuery{
 		query: newQuery(collection),
 		tx:    tx,
 	}
+	q.applyDefaultOrder()
+	q.applyDefaultScope()
+	return q
+}
+
+// Unscoped works exactly like Query.Unscoped. See the documentation for
+// Query.Unscoped for more information.
+func (q *TransactionQuery) Unscoped() *TransactionQuery {
+	q.query.Unscoped()
+	return q
 }
 
 // Order works exactly like Query.Order. See the documentation for Query.Order
@@ -64,6 +80,13 @@ func (q *TransactionQuery) Offset(amount uint) *TransactionQuery {
 	return q
 }
 
+// Last works exactly like Query.Last. See the documentation for Query.Last for
+// more information.
+func (q *TransactionQuery) Last(n uint) *TransactionQuery {
+	q.query.Last(n)
+	return q
+}
+
 // Include works exactly like Query.Include. See the documentation for
 // Query.Include for more information.
 func (q *TransactionQuery) Include(fields ...string) *TransactionQuery {
@@ -85,12 +108,60 @@ func (q *TransactionQuery) Filter(filterString string, value interface{}) *Trans
 	return q
 }
 
+// FilterRange works exactly like Query.FilterRange. See the documentation for
+// Query.FilterRange for more information.
+func (q *TransactionQuery) FilterRange(fieldName string, min interface{}, max interface{}, inclusive bool) *TransactionQuery {
+	q.query.FilterRange(fieldName, min, max, inclusive)
+	return q
+}
+
+// Search works exactly like Query.Search. See the documentation for
+// Query.Search for more information.
+func (q *TransactionQuery) Search(fieldName string, text string) *TransactionQuery {
+	q.query.Search(fieldName, text)
+	return q
+}
+
+// FromIDSet restricts the query to models whose ids are in the set, sorted
+// set, or list identified by key. It returns the query so you can chain
+// multiple modifiers together. See the documentation for Query.FromIDSet for
+// more information.
+func (q *TransactionQuery) FromIDSet(key string) *TransactionQuery {
+	q.query.FromIDSet(key)
+	return q
+}
+
+// FilterID restricts the query to models whose ids compare to id with the
+// given operator. It returns the query so you can chain multiple modifiers
+// together. See the documentation for Query.FilterID for more information.
+func (q *TransactionQuery) FilterID(operator string, id string) *TransactionQuery {
+	q.query.FilterID(operator, id)
+	return q
+}
+
+// FilterIDPrefix restricts the query to models whose ids start with prefix.
+// It returns the query so you can chain multiple modifiers together. See the
+// documentation for Query.FilterID for more information.
+func (q *TransactionQuery) FilterIDPrefix(prefix string) *TransactionQuery {
+	q.query.FilterIDPrefix(prefix)
+	return q
+}
+
+// Join connects the query to the target collection via fieldName. It returns
+// the query so you can chain multiple modifiers together. See the
+// documentation for Query.Join for more information.
+func (q *TransactionQuery) Join(fieldName string, target *Collection) *TransactionQuery {
+	q.query.Join(fieldName, target)
+	return q
+}
+
 // Run will run the query and scan the results into models when the Transaction
 // is executed. It works very similarly to Query.Run, so you can check the
 // documentation for Query.Run for more information. The first error encountered
 // will be saved to the corresponding Transaction (if there is not already an
 // error for the Transaction) and returned when you call Transaction.Exec.
 func (q *TransactionQuery) Run(models interface{}) {
+	q = q.intercept("Run")
 	if q.hasError() {
 		q.tx.setError(q.err)
 		return
@@ -99,6 +170,13 @@ func (q *TransactionQuery) Run(models interface{}) {
 		q.tx.setError(err)
 		return
 	}
+	keyFields := q.keyFields()
+	if args, ok := q.rediSearchArgs(); ok && !q.collection.strictScan && len(keyFields) == 0 && q.rediSearchWithinMaxResults(int(q.limit)) {
+		redisNames := q.redisFieldNames()
+		handler := newScanModelsHandler(q.collection.spec, append(q.fieldNames(), "-"), models)
+		q.tx.Command("FT.SEARCH", q.rediSearchRunArgs(args, int(q.limit)), newRediSearchModelsHandler(q.query, int(q.limit), redisNames, handler))
+		return
+	}
 	idsKey, tmpKeys, err := generateIDsSet(q.query, q.tx)
 	if err != nil {
 		q.tx.setError(err)
@@ -110,8 +188,51 @@ func (q *TransactionQuery) Run(models interface{}) {
 		// But in redis, -1 means unlimited
 		limit = -1
 	}
-	sortArgs := q.collection.spec.sortArgs(idsKey, q.redisFieldNames(), limit, q.offset, q.order.kind == descendingOrder)
-	q.tx.Command("SORT", sortArgs, newScanModelsHandler(q.collection.spec, append(q.fieldNames(), "-"), models))
+	redisNames := q.redisFieldNames()
+	sortArgs := q.collection.spec.sortArgs(idsKey, redisNames, limit, q.offset, q.order.kind == descendingOrder)
+	q.tx.sortModelsWithKeyFields(q.collection.spec, sortArgs, redisNames, keyFields, modelsIndexer(models), newScanModelsHandler(q.collection.spec, append(q.fieldNames(), "-"), models))
+	if q.collection.strictScan {
+		q.tx.checkHashFieldsForSort(q.collection, idsKey, limit, q.offset, q.order.kind == descendingOrder)
+	}
+	if len(tmpKeys) > 0 {
+		q.tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
+	}
+}
+
+// RunInto will run the query and scan the fields of the models which match
+// the query criteria into dest, which should be a pointer to a slice of structs
+// (or pointers to structs) of any type. It works very similarly to
+// Query.RunInto, so you can check the documentation for Query.RunInto for more
+// information. The first error encountered will be saved to the corresponding
+// Transaction (if there is not already an error for the Transaction) and
+// returned when you call Transaction.Exec.
+func (q *TransactionQuery) RunInto(dest interface{}) {
+	q = q.intercept("RunInto")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	destType, err := projectionDestType(dest)
+	if err != nil {
+		q.tx.setError(err)
+		return
+	}
+	p, err := newProjection(q.collection.spec, destType)
+	if err != nil {
+		q.tx.setError(err)
+		return
+	}
+	idsKey, tmpKeys, err := generateIDsSet(q.query, q.tx)
+	if err != nil {
+		q.tx.setError(err)
+		return
+	}
+	limit := int(q.limit)
+	if limit == 0 {
+		limit = -1
+	}
+	sortArgs := q.collection.spec.sortArgs(idsKey, p.redisNames(), limit, q.offset, q.order.kind == descendingOrder)
+	q.tx.sortModels(q.collection.spec, sortArgs, p.redisNames(), newScanProjectionHandler(q.collection.spec, p, dest))
 	if len(tmpKeys) > 0 {
 		q.tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
 	}
@@ -125,6 +246,65 @@ func (q *TransactionQuery) Run(models interface{}) {
 // Transaction (if there is not already an error for the Transaction) and
 // returned when you call Transaction.Exec.
 func (q *TransactionQuery) RunOne(model Model) {
+	q = q.intercept("RunOne")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if err := q.collection.spec.checkModelType(model); err != nil {
+		q.tx.setError(err)
+		return
+	}
+	q.runOne(1, model, newScanOneModelHandler(q.query, q.collection.spec, append(q.fieldNames(), "-"), model))
+}
+
+// First is exactly like RunOne. It scans the first model which matches the
+// query criteria, according to Order and Offset, into model. It works very
+// similarly to Query.First, so you can check the documentation for Query.First
+// for more information.
+func (q *TransactionQuery) First(model Model) {
+	q.intercept("First").RunOne(model)
+}
+
+// LastOne will run the query and scan the last model which matches the query
+// criteria, according to Order, into model. It works very similarly to
+// Query.LastOne, so you can check the documentation for Query.LastOne for more
+// information. The first error encountered will be saved to the corresponding
+// Transaction (if there is not already an error for the Transaction) and
+// returned when you call Transaction.Exec.
+func (q *TransactionQuery) LastOne(model Model) {
+	q = q.intercept("LastOne")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if !q.hasOrder() {
+		q.tx.setError(fmt.Errorf("zoom: error in LastOne: the query must have an Order"))
+		return
+	}
+	if q.hasLast() {
+		q.tx.setError(fmt.Errorf("zoom: error in LastOne: cannot be combined with Last"))
+		return
+	}
+	reversed := *q.query
+	if reversed.order.kind == ascendingOrder {
+		reversed.order.kind = descendingOrder
+	} else {
+		reversed.order.kind = ascendingOrder
+	}
+	newTransactionQuery(&reversed, q.tx).RunOne(model)
+}
+
+// RunExactlyOne will run the query and scan the model which matches the query
+// criteria into model. If no model matches the query criteria, it will set a
+// ModelNotFoundError on the Transaction, and if more than one model matches, it
+// will set a MultipleModelsFoundError. It works very similarly to
+// Query.RunExactlyOne, so you can check the documentation for
+// Query.RunExactlyOne for more information. The first error encountered will be
+// saved to the corresponding Transaction (if there is not already an error for
+// the Transaction) and returned when you call Transaction.Exec.
+func (q *TransactionQuery) RunExactlyOne(model Model) {
+	q = q.intercept("RunExactlyOne")
 	if q.hasError() {
 		q.tx.setError(q.err)
 		return
@@ -133,18 +313,75 @@ func (q *TransactionQuery) RunOne(model Model) {
 		q.tx.setError(err)
 		return
 	}
+	q.runOne(2, model, newScanExactlyOneModelHandler(q.query, q.collection.spec, append(q.fieldNames(), "-"), model))
+}
+
+// runOne adds the commands for finding at most limit models which match the
+// query criteria (skipping the first q.offset models) to the transaction.
+// handler is called with a reply that looks like the reply for
+// newScanModelsHandler, and should scan the first model into model, which the
+// fields stored in their own key are then scanned into.
+func (q *TransactionQuery) runOne(limit int, model Model, handler ReplyHandler) {
+	keyFields := q.keyFields()
+	if args, ok := q.rediSearchArgs(); ok && !q.collection.strictScan && len(keyFields) == 0 && q.rediSearchWithinMaxResults(limit) {
+		redisNames := q.redisFieldNames()
+		q.tx.Command("FT.SEARCH", q.rediSearchRunArgs(args, limit), newRediSearchModelsHandler(q.query, limit, redisNames, handler))
+		return
+	}
 	idsKey, tmpKeys, err := generateIDsSet(q.query, q.tx)
 	if err != nil {
 		q.tx.setError(err)
 		return
 	}
-	sortArgs := q.collection.spec.sortArgs(idsKey, q.redisFieldNames(), 1, q.offset, q.order.kind == descendingOrder)
-	q.tx.Command("SORT", sortArgs, newScanOneModelHandler(q.query, q.collection.spec, append(q.fieldNames(), "-"), model))
+	redisNames := q.redisFieldNames()
+	sortArgs := q.collection.spec.sortArgs(idsKey, redisNames, limit, q.offset, q.order.kind == descendingOrder)
+	firstModel := func(i int) Model {
+		if i > 0 {
+			return nil
+		}
+		return model
+	}
+	q.tx.sortModelsWithKeyFields(q.collection.spec, sortArgs, redisNames, keyFields, firstModel, handler)
+	if q.collection.strictScan {
+		q.tx.checkHashFieldsForSort(q.collection, idsKey, limit, q.offset, q.order.kind == descendingOrder)
+	}
 	if len(tmpKeys) > 0 {
 		q.tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
 	}
 }
 
+// RunPage will run the query and scan the models on the given page into
+// models, and set the fields of info to describe the page. It works very
+// similarly to Query.RunPage, so you can check the documentation for
+// Query.RunPage for more information. The first error encountered will be
+// saved to the corresponding Transaction (if there is not already an error for
+// the Transaction) and returned when you call Transaction.Exec.
+func (q *TransactionQuery) RunPage(models interface{}, page, pageSize int, info *PageInfo) {
+	q = q.intercept("RunPage")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if page < 1 || pageSize < 1 {
+		q.tx.setError(fmt.Errorf("zoom: error in RunPage: page and pageSize must be at least 1 but got %d and %d", page, pageSize))
+		return
+	}
+	pageQuery := *q.query
+	pageQuery.limit = uint(pageSize)
+	pageQuery.offset = uint((page - 1) * pageSize)
+	newTransactionQuery(&pageQuery, q.tx).Run(models)
+	countQuery := *q.query
+	countQuery.limit = 0
+	countQuery.offset = 0
+	newTransactionQuery(&countQuery, q.tx).Count(&info.Total)
+	q.tx.mut.Lock()
+	defer q.tx.mut.Unlock()
+	q.tx.onSuccess = append(q.tx.onSuccess, func() {
+		info.Pages = (info.Total + pageSize - 1) / pageSize
+		info.HasNext = page < info.Pages
+	})
+}
+
 // Count will count the number of models that match the query criteria and set
 // the value of count. It works very similarly to Query.Count, so you can check
 // the documentation for Query.Count for more information. The first error
@@ -152,39 +389,265 @@ func (q *TransactionQuery) RunOne(model Model) {
 // already an error for the Transaction) and returned when you call
 // Transaction.Exec.
 func (q *TransactionQuery) Count(count *int) {
+	q = q.intercept("Count")
 	if q.hasError() {
 		q.tx.setError(q.err)
 		return
 	}
-	if !q.hasFilters() {
+	if args, ok := q.rediSearchArgs(); ok {
+		q.tx.Command("FT.SEARCH", args.Add("LIMIT", 0, 0), newRediSearchCountHandler(q.query, count))
+		return
+	}
+	if !q.hasFilters() && !q.hasSearches() && !q.hasIDSets() && !q.hasIDFilters() && !q.hasJoins() && !q.hasNegations() {
 		// Start by getting the number of models in the all index set
-		q.tx.Command("SCARD", redis.Args{q.collection.spec.indexKey()}, func(reply interface{}) error {
+		q.tx.countIndex(q.collection, func(reply interface{}) error {
 			gotCount, err := redis.Int(reply, nil)
 			if err != nil {
 				return err
 			}
-			// Apply math to take into account limit and offset
-			if q.hasOffset() {
-				gotCount = gotCount - int(q.offset)
+			if q.hasLast() && int(q.last) < gotCount {
+				gotCount = int(q.last)
 			}
-			if q.hasLimit() && int(q.limit) < gotCount {
-				gotCount = int(q.limit)
+			if q.hasSample() && int(q.sample) < gotCount {
+				gotCount = int(q.sample)
 			}
-			// Assign the value of count
-			(*count) = gotCount
+			(*count) = q.limitCount(gotCount)
 			return nil
 		})
-	} else {
-		// If the query has filters, it is difficult to do any optimizations.
-		// Instead we'll just count the number of ids that match the query
-		// criteria. To do in a single transaction, we use the StoreIDs method and
-		// then add a LLEN command.
-		destKey := generateRandomKey("tmp:countDestKey")
-		q.StoreIDs(destKey)
-		q.tx.Command("LLEN", redis.Args{destKey}, NewScanIntHandler(count))
-		// Delete the temporary destKey when we're done.
-		q.tx.Command("DEL", redis.Args{destKey}, nil)
+		return
+	}
+	if q.countSingleFilter(count) {
+		return
+	}
+	// Otherwise we need to generate the set of ids which match the query
+	// criteria, but we can count them with ZCARD instead of copying them into
+	// a list. The order does not affect the count unless there is also a
+	// call to Last or the order field is a pointer (models for which it is nil
+	// are not in the field index), so we can usually skip the work of ordering
+	// the ids.
+	countQuery := *q.query
+	if q.hasOrder() && !q.hasLast() && (q.order.isExpr() || q.collection.spec.fieldsByName[q.order.fieldName].kind != pointerField) {
+		countQuery.order = order{}
+	}
+	idsKey, tmpKeys, err := generateIDsSet(&countQuery, q.tx)
+	if err != nil {
+		q.tx.setError(err)
+		return
+	}
+	q.tx.Command("ZCARD", redis.Args{idsKey}, q.newCountHandler(count))
+	if len(tmpKeys) > 0 {
+		q.tx.Command("DEL", tmpKeys, nil)
+	}
+}
+
+// countSingleFilter adds a command to the transaction which counts the models
+// that match the query directly from the field index, without creating any
+// temporary keys. It is only possible when the query consists of a single
+// filter which matches one range of the index, and returns false otherwise.
+func (q *TransactionQuery) countSingleFilter(count *int) bool {
+	if len(q.filters) != 1 || q.hasSearches() || q.hasIDSets() || q.hasIDFilters() || q.hasJoins() || q.hasNegations() || q.hasOrder() || q.hasLast() || q.hasSample() {
+		return false
+	}
+	filter := q.filters[0]
+	switch filter.op {
+	case isNullOp, isNotNullOp, inOp:
+		return false
+	}
+	fieldIndexKey, err := q.collection.spec.fieldIndexKey(filter.fieldSpec.name)
+	if err != nil {
+		q.tx.setError(err)
+		return true
+	}
+	switch filter.fieldSpec.indexKind {
+	case numericIndex:
+		if filter.op == notEqualOp {
+			return false
+		}
+		min, max := numericFilterBounds(filter)
+		q.tx.Command("ZCOUNT", redis.Args{fieldIndexKey, min, max}, q.newCountHandler(count))
+	case booleanIndex:
+		min, max := boolFilterBounds(filter)
+		q.tx.Command("ZCOUNT", redis.Args{fieldIndexKey, min, max}, q.newCountHandler(count))
+	case stringIndex, integerIndex:
+		if filter.op == notEqualOp {
+			return false
+		}
+		min, max := stringFilterBounds(filter)
+		q.tx.Command("ZLEXCOUNT", redis.Args{fieldIndexKey, min, max}, q.newCountHandler(count))
+	case enumIndex:
+		if filter.op == notEqualOp {
+			return false
+		}
+		ordinal, err := enumFilterOrdinal(filter)
+		if err != nil {
+			q.tx.setError(err)
+			return true
+		}
+		q.tx.Command("SCARD", redis.Args{q.collection.spec.enumIndexKey(filter.fieldSpec, ordinal)}, q.newCountHandler(count))
+	default:
+		return false
+	}
+	return true
+}
+
+// newCountHandler returns a ReplyHandler which scans the number of matching
+// models into count, taking into account the limit and offset of the query.
+func (q *TransactionQuery) newCountHandler(count *int) ReplyHandler {
+	return func(reply interface{}) error {
+		gotCount, err := redis.Int(reply, nil)
+		if err != nil {
+			return err
+		}
+		(*count) = q.limitCount(gotCount)
+		return nil
+	}
+}
+
+// estimateSampleSize is the maximum number of ids which EstimateCount samples
+// from the most selective filter to estimate the fraction of them which match
+// the other filters.
+const estimateSampleSize = 1000
+
+// EstimateCount will estimate the number of models that match the query
+// criteria and set the value of count. It works very similarly to
+// Query.EstimateCount, so you can check the documentation for
+// Query.EstimateCount for more information. The first error encountered will
+// be saved to the corresponding Transaction (if there is not already an error
+// for the Transaction) and returned when you call Transaction.Exec.
+func (q *TransactionQuery) EstimateCount(count *int) {
+	q = q.intercept("EstimateCount")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if _, ok := q.rediSearchArgs(); ok || !q.hasFilters() || q.hasSearches() || q.hasIDSets() || q.hasIDFilters() || q.hasJoins() || q.hasNegations() {
+		// These can be counted exactly without intersecting any sets or are not
+		// supported by the estimate.
+		q.Count(count)
+		return
+	}
+	// The arguments for the total number of models are followed by the index
+	// ranges for each filter.
+	args := redis.Args{estimateSampleSize, len(q.filters), "CARD", q.collection.spec.indexKey(), "", "", ""}
+	complements := make([]bool, len(q.filters))
+	for i, filter := range q.filters {
+		filterArgs, complement, err := q.filterCountArgs(filter)
+		if err != nil {
+			q.tx.setError(err)
+			return
+		}
+		args = append(args, convertBoolToInt(complement), len(filterArgs)/5)
+		args = append(args, filterArgs...)
+		complements[i] = complement
+	}
+	q.tx.Script(estimateFilterIntersectionsScript, args, func(reply interface{}) error {
+		values, err := redis.Ints(reply, nil)
+		if err != nil {
+			return err
+		}
+		total := values[0]
+		counts := values[1 : 1+len(q.filters)]
+		picked, samples, matches := values[1+len(q.filters)], values[2+len(q.filters)], values[3+len(q.filters)]
+		estimate := float64(total)
+		if picked > 0 {
+			// The fraction of the sampled models which match all the other
+			// filters is the fraction of the models which match the picked filter
+			// that match the query.
+			estimate = 0
+			if samples > 0 {
+				estimate = float64(counts[picked-1]) * float64(matches) / float64(samples)
+			}
+		} else {
+			// All the filters match the complement of their index ranges, so
+			// assume that they are independent, i.e. that the fraction of models
+			// which match all the filters is the product of the fractions which
+			// match each filter.
+			for i, matched := range counts {
+				if complements[i] {
+					matched = total - matched
+				}
+				if matched <= 0 {
+					estimate = 0
+					break
+				}
+				if matched < total {
+					estimate *= float64(matched) / float64(total)
+				}
+			}
+		}
+		gotCount := int(math.Round(estimate))
+		if q.hasLast() && int(q.last) < gotCount {
+			gotCount = int(q.last)
+		}
+		if q.hasSample() && int(q.sample) < gotCount {
+			gotCount = int(q.sample)
+		}
+		(*count) = q.limitCount(gotCount)
+		return nil
+	})
+}
+
+// filterCountArgs returns the index ranges for the
+// estimate_filter_intersections script which count the models that match
+// filter without intersecting any sets. The number of matching models is the
+// sum of the counts, or, if complement is true, the total number of models
+// minus the sum of the counts.
+func (q *TransactionQuery) filterCountArgs(filter filter) (args redis.Args, complement bool, err error) {
+	spec := q.collection.spec
+	switch filter.op {
+	case isNullOp:
+		return redis.Args{"SCARD", spec.nullIndexKey(filter.fieldSpec), "", "", ""}, false, nil
+	case isNotNullOp:
+		fieldIndexKey, err := spec.fieldIndexKey(filter.fieldSpec.name)
+		if err != nil {
+			return nil, false, err
+		}
+		return redis.Args{"ZCARD", fieldIndexKey, "", "", ""}, false, nil
+	case inOp:
+		args := redis.Args{}
+		for i := 0; i < filter.value.Len(); i++ {
+			equalFilter := filter
+			equalFilter.op = equalOp
+			equalFilter.value = reflect.ValueOf(filter.value.Index(i).Interface())
+			equalArgs, _, err := q.filterCountArgs(equalFilter)
+			if err != nil {
+				return nil, false, err
+			}
+			args = append(args, equalArgs...)
+		}
+		return args, false, nil
+	case notEqualOp:
+		equalFilter := filter
+		equalFilter.op = equalOp
+		args, _, err := q.filterCountArgs(equalFilter)
+		return args, true, err
+	}
+	fieldIndexKey, err := spec.fieldIndexKey(filter.fieldSpec.name)
+	if err != nil {
+		return nil, false, err
+	}
+	switch filter.fieldSpec.indexKind {
+	case numericIndex:
+		min, max := numericFilterBounds(filter)
+		return redis.Args{"ZCOUNT", fieldIndexKey, min, max, ""}, false, nil
+	case booleanIndex:
+		min, max := boolFilterBounds(filter)
+		return redis.Args{"ZCOUNT", fieldIndexKey, min, max, ""}, false, nil
+	case stringIndex, integerIndex:
+		min, max := stringFilterBounds(filter)
+		membersKey := spec.stringMembersKey(filter.fieldSpec)
+		if filter.fieldSpec.collate != nil {
+			membersKey = spec.collatedMembersKey(filter.fieldSpec)
+		}
+		return redis.Args{"ZLEXCOUNT", fieldIndexKey, min, max, membersKey}, false, nil
+	case enumIndex:
+		ordinal, err := enumFilterOrdinal(filter)
+		if err != nil {
+			return nil, false, err
+		}
+		return redis.Args{"SCARD", spec.enumIndexKey(filter.fieldSpec, ordinal), "", "", ""}, false, nil
 	}
+	return nil, false, fmt.Errorf("zoom: cannot estimate the count for filter %s", filter)
 }
 
 // IDs will find the ids for models matching the query criteria and set the
@@ -193,10 +656,16 @@ func (q *TransactionQuery) Count(count *int) {
 // will be saved to the corresponding Transaction (if there is not already an
 // error for the Transaction) and returned when you call Transaction.Exec.
 func (q *TransactionQuery) IDs(ids *[]string) {
+	q = q.intercept("IDs")
 	if q.hasError() {
 		q.tx.setError(q.err)
 		return
 	}
+	if args, ok := q.rediSearchArgs(); ok && q.rediSearchWithinMaxResults(int(q.limit)) {
+		args = args.Add("LIMIT", q.offset, q.limit, "NOCONTENT")
+		q.tx.Command("FT.SEARCH", args, newRediSearchIDsHandler(q.query, ids))
+		return
+	}
 	idsKey, tmpKeys, err := generateIDsSet(q.query, q.tx)
 	if err != nil {
 		q.tx.setError(err)
@@ -214,6 +683,100 @@ func (q *TransactionQuery) IDs(ids *[]string) {
 	}
 }
 
+// IDsWithScores will find the ids for models matching the query criteria,
+// along with the value of the order field for each model, and set the value of
+// results. It works very similarly to Query.IDsWithScores, so you can check
+// the documentation for Query.IDsWithScores for more information. The first
+// error encountered will be saved to the corresponding Transaction (if there
+// is not already an error for the Transaction) and returned when you call
+// Transaction.Exec.
+func (q *TransactionQuery) IDsWithScores(results *[]IDScore) {
+	q = q.intercept("IDsWithScores")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if !q.hasOrder() || (!q.order.isExpr() && q.collection.spec.fieldsByName[q.order.fieldName].indexKind == stringIndex) {
+		q.tx.setError(fmt.Errorf("zoom: error in Query.IDsWithScores: the query must be ordered by a numeric or boolean field"))
+		return
+	}
+	if q.hasLast() && q.hasSample() {
+		q.tx.setError(fmt.Errorf("zoom: error in Query.IDsWithScores: Last cannot be combined with Sample"))
+		return
+	}
+	reverse := q.order.kind == descendingOrder
+	if fs := q.collection.spec.fieldsByName[q.order.fieldName]; !q.order.isExpr() && fs.indexKind == integerIndex {
+		// Integer indexes do not store the values as scores, so read the values
+		// of the order field from the model hashes instead.
+		if err := q.collection.checkHashLayout("Query.IDsWithScores"); err != nil {
+			q.tx.setError(err)
+			return
+		}
+		idsKey, tmpKeys, err := generateIDsSet(q.query, q.tx)
+		if err != nil {
+			q.tx.setError(err)
+			return
+		}
+		args := redis.Args{idsKey, "BY", "nosort", "GET", "#", "GET", q.collection.spec.name + ":*->" + fs.redisName}
+		if q.hasLimit() || q.hasOffset() {
+			limit := int(q.limit)
+			if limit == 0 {
+				limit = -1
+			}
+			args = args.Add("LIMIT", q.offset, limit)
+		}
+		if reverse {
+			args = args.Add("DESC")
+		}
+		q.tx.Command("SORT", args, newScanIDScoresHandler(results, nil))
+		if len(tmpKeys) > 0 {
+			q.tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
+		}
+		return
+	}
+	// Last does not preserve the scores, so we generate the set of ids without
+	// it and take the last ids ourselves. Since the query has an order, the
+	// scores in the set of ids are the values of the order field.
+	withoutLast := *q.query
+	withoutLast.last = 0
+	idsKey, tmpKeys, err := generateIDsSet(&withoutLast, q.tx)
+	if err != nil {
+		q.tx.setError(err)
+		return
+	}
+	if q.hasLast() {
+		// Read the last ids in the opposite order, then reverse them and apply the
+		// limit and offset in the handler.
+		command := "ZREVRANGE"
+		if reverse {
+			command = "ZRANGE"
+		}
+		q.tx.Command(command, redis.Args{idsKey, 0, int(q.last) - 1, "WITHSCORES"}, newScanIDScoresHandler(results, func(idScores []IDScore) []IDScore {
+			for i, j := 0, len(idScores)-1; i < j; i, j = i+1, j-1 {
+				idScores[i], idScores[j] = idScores[j], idScores[i]
+			}
+			start, stop := q.getStartStop()
+			if start > len(idScores) {
+				start = len(idScores)
+			}
+			if stop < 0 || stop >= len(idScores) {
+				stop = len(idScores) - 1
+			}
+			return idScores[start : stop+1]
+		}))
+	} else {
+		command := "ZRANGE"
+		if reverse {
+			command = "ZREVRANGE"
+		}
+		start, stop := q.getStartStop()
+		q.tx.Command(command, redis.Args{idsKey, start, stop, "WITHSCORES"}, newScanIDScoresHandler(results, nil))
+	}
+	if len(tmpKeys) > 0 {
+		q.tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
+	}
+}
+
 // StoreIDs will store the ids for for models matching the criteria in a list
 // identified by destKey. It works very similarly to Query.StoreIDs, so you can
 // check the documentation for Query.StoreIDs for more information. The first
@@ -221,6 +784,7 @@ func (q *TransactionQuery) IDs(ids *[]string) {
 // not already an error for the Transaction) and returned when you call
 // Transaction.Exec.
 func (q *TransactionQuery) StoreIDs(destKey string) {
+	q = q.intercept("StoreIDs")
 	if q.hasError() {
 		q.tx.setError(q.err)
 		return
@@ -243,3 +807,81 @@ func (q *TransactionQuery) StoreIDs(destKey string) {
 		q.tx.Command("DEL", (redis.Args{}).Add(tmpKeys...), nil)
 	}
 }
+
+// Delete will delete all the models that match the query criteria, including
+// their field indexes, and set the value of count to the number of models that
+// were deleted. It works very similarly to Query.Delete, so you can check the
+// documentation for Query.Delete for more information. Note that if the
+// transaction is split into batches (see Transaction.Batched) or executed with
+// ExecStreaming, the ids may be stored in one batch and the models deleted in
+// another, so another client could change which models match in between. You
+// may pass in nil for count if you do not care about the number of models that
+// were deleted. The first error encountered will be saved to the corresponding
+// Transaction (if there is not already an error for the Transaction) and
+// returned when you call Transaction.Exec.
+func (q *TransactionQuery) Delete(count *int) {
+	q = q.intercept("Delete")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if err := q.collection.checkUnreferenced("Query.Delete"); err != nil {
+		q.tx.setError(err)
+		return
+	}
+	var handler ReplyHandler
+	if count != nil {
+		handler = NewScanIntHandler(count)
+	}
+	idsKey := q.tx.newTmpKey("tmp:deleteIDs")
+	q.StoreIDs(idsKey)
+	q.tx.deleteModelsByListIDs(idsKey, q.collection.spec, handler)
+	q.tx.Command("DEL", redis.Args{idsKey}, nil)
+}
+
+// Update will set the given fields for all the models that match the query
+// criteria, updating any field indexes as needed, and set the value of count to
+// the number of models that were updated. It works very similarly to
+// Query.Update, so you can check the documentation for Query.Update for more
+// information. As with Delete, the update is only atomic if the transaction is
+// executed in a single MULTI/EXEC block. You may pass in nil for count if you
+// do not care about the number of models that were updated. The first error
+// encountered will be saved to the corresponding Transaction (if there is not
+// already an error for the Transaction) and returned when you call
+// Transaction.Exec.
+func (q *TransactionQuery) Update(fieldValues map[string]interface{}, count *int) {
+	q = q.intercept("Update")
+	if q.hasError() {
+		q.tx.setError(q.err)
+		return
+	}
+	if err := q.collection.checkHashLayout("Query.Update"); err != nil {
+		q.tx.setError(err)
+		return
+	}
+	fieldValues, err := q.tx.withUpdatedAt(q.collection, fieldValues)
+	if err != nil {
+		q.tx.setError(err)
+		return
+	}
+	fieldArgs, err := q.collection.spec.updateArgs(fieldValues)
+	if err != nil {
+		q.tx.setError(fmt.Errorf("zoom: error in Query.Update: %w", err))
+		return
+	}
+	var handler ReplyHandler
+	if count != nil {
+		handler = NewScanIntHandler(count)
+	}
+	idsKey := q.tx.newTmpKey("tmp:updateIDs")
+	q.StoreIDs(idsKey)
+	q.tx.updateModelsByListIDs(idsKey, q.collection.spec, fieldArgs, handler)
+	bitmapFields := []*fieldSpec{}
+	for _, fs := range q.collection.spec.fields {
+		if _, found := fieldValues[fs.name]; found && fs.hasBitmapIndex() {
+			bitmapFields = append(bitmapFields, fs)
+		}
+	}
+	q.tx.updateBitmapIndexes(q.collection.spec, idsKey, "synclist", bitmapFields)
+	q.tx.Command("DEL", redis.Args{idsKey}, nil)
+}
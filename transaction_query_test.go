@@ -69,3 +69,45 @@ func TestTransactionQueriesError(t *testing.T) {
 	}
 	checkForLeakedTmpKeys(t, query.query)
 }
+
+func TestTransactionQueryCountCommands(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// A single filter should be counted directly from the field index
+	var count int
+	tx := testPool.NewTransaction()
+	tx.Query(indexedTestModels).Filter("Int >", 3).Count(&count)
+	tx.Query(indexedTestModels).Filter("String <=", "foo").Offset(2).Count(&count)
+	tx.Query(indexedTestModels).Filter("Bool !=", true).Count(&count)
+	commands, err := tx.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedNames := []string{"ZCOUNT", "ZLEXCOUNT", "ZCOUNT"}
+	if len(commands) != len(expectedNames) {
+		t.Fatalf("Expected %d commands but got %d: %v", len(expectedNames), len(commands), commands)
+	}
+	for i, name := range expectedNames {
+		if commands[i].Name != name {
+			t.Errorf("Expected command %d to be %s but got %s", i, name, commands[i])
+		}
+	}
+
+	// Other queries should count the final set of ids without storing them in
+	// a list or extracting the ids for the order
+	tx = testPool.NewTransaction()
+	tx.Query(indexedTestModels).Filter("Int >", 3).Filter("Bool =", true).Order("String").Count(&count)
+	commands, err = tx.DryRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range commands {
+		if command.Name == "LLEN" || (command.Name == "EVALSHA" && command.Args[0] == extractIdsFromStringIndexScript.Hash()) {
+			t.Errorf("Unexpected command %s in count query", command)
+		}
+	}
+	if len(commands) < 2 || commands[len(commands)-2].Name != "ZCARD" {
+		t.Errorf("Expected the count to use ZCARD but got commands %v", commands)
+	}
+}
@@ -1,6 +1,11 @@
 package zoom
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/garyburd/redigo/redis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -77,3 +82,485 @@ func TestWatchKey(t *testing.T) {
 	require.NoError(t, err)
 	require.Exactly(t, expectedVal, got)
 }
+
+func TestExtractIDsByNumericRange(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+	models := createIndexedTestModels(5)
+	tx := testPool.NewTransaction()
+	for i, model := range models {
+		model.Int = i
+		tx.Save(indexedTestModels, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	testCases := []struct {
+		min, max    interface{}
+		expectedIDs []string
+	}{
+		{"-inf", "+inf", modelIDs(Models(models))},
+		{1, 3, modelIDs(Models(models[1:4]))},
+		{"(1", "(3", modelIDs(Models(models[2:3]))},
+	}
+	for i, tc := range testCases {
+		destKey := "TestExtractIDsByNumericRange:" + strconv.Itoa(i)
+		gotIDs := []string{}
+		tx := testPool.NewTransaction()
+		tx.ExtractIDsByNumericRange(indexedTestModels, "Int", destKey, tc.min, tc.max)
+		tx.Command("ZRANGE", redis.Args{destKey, 0, -1}, NewScanStringsHandler(&gotIDs))
+		require.NoError(t, tx.Exec())
+		assert.Equal(t, tc.expectedIDs, gotIDs, "test case %d", i)
+	}
+
+	// Using a field without a numeric index should result in an error.
+	tx = testPool.NewTransaction()
+	tx.ExtractIDsByNumericRange(indexedTestModels, "String", "foo", 0, 1)
+	assert.Error(t, tx.Exec())
+}
+
+func TestSyncModelIndexes(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+	model := &indexedTestModel{Int: 1, String: "foo", Bool: true}
+	require.NoError(t, indexedTestModels.Save(model))
+
+	// Change the model hash directly and sync the indexes by collection name
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err := conn.Do("HMSET", indexedTestModels.ModelKey(model.ID), "Int", 7, "String", "bar")
+	require.NoError(t, err)
+	tx := testPool.NewTransaction()
+	tx.SyncModelIndexes(indexedTestModels.Name(), model.ID, true, []FieldIndex{
+		{RedisName: "Int", Kind: "numeric"},
+		{RedisName: "String", Kind: "string"},
+	})
+	require.NoError(t, tx.Exec())
+	ids, err := indexedTestModels.NewQuery().Filter("Int =", 7).Filter("String =", "bar").IDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.ID}, ids)
+	count, err := indexedTestModels.NewQuery().Filter("String =", "foo").Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// An invalid kind of index should result in an error.
+	tx = testPool.NewTransaction()
+	tx.SyncModelIndexes(indexedTestModels.Name(), model.ID, true, []FieldIndex{{RedisName: "Int", Kind: "float"}})
+	assert.Error(t, tx.Exec())
+}
+
+func TestSyncModelIndexesInterleavedWrites(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+	model := &indexedTestModel{String: "foo"}
+	require.NoError(t, indexedTestModels.Save(model))
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	fs := indexedTestModels.spec.fieldsByName["String"]
+	indexKey := indexedTestModels.spec.indexKeyForField(fs)
+	expectMembers := func(values ...string) {
+		t.Helper()
+		expected := []string{}
+		for _, value := range values {
+			expected = append(expected, value+nullString+model.ID)
+		}
+		members, err := redis.Strings(conn.Do("ZRANGE", indexKey, 0, -1))
+		require.NoError(t, err)
+		assert.Equal(t, expected, members)
+		recorded, err := redis.StringMap(conn.Do("HGETALL", indexedTestModels.spec.stringMembersKey(fs)))
+		require.NoError(t, err)
+		if len(expected) == 0 {
+			assert.Empty(t, recorded)
+		} else {
+			assert.Equal(t, map[string]string{model.ID: expected[0]}, recorded)
+		}
+	}
+	sync := func() {
+		tx := testPool.NewTransaction()
+		tx.SyncModelIndexes(indexedTestModels.Name(), model.ID, true, []FieldIndex{{RedisName: "String", Kind: "string"}})
+		require.NoError(t, tx.Exec())
+	}
+	expectMembers("foo")
+
+	// Saving the model after the hash was changed outside of Zoom (but before
+	// the indexes were synced) should not leave the old member behind.
+	_, err := conn.Do("HSET", indexedTestModels.ModelKey(model.ID), "String", "bar")
+	require.NoError(t, err)
+	model.String = "baz"
+	require.NoError(t, indexedTestModels.Save(model))
+	expectMembers("baz")
+
+	// Syncing twice after the hash was changed outside of Zoom should have the
+	// same effect as syncing once.
+	_, err = conn.Do("HSET", indexedTestModels.ModelKey(model.ID), "String", "qux")
+	require.NoError(t, err)
+	sync()
+	sync()
+	expectMembers("qux")
+
+	// Deleting the model outside of Zoom should remove it from the index.
+	_, err = conn.Do("DEL", indexedTestModels.ModelKey(model.ID))
+	require.NoError(t, err)
+	sync()
+	expectMembers()
+}
+
+func TestExtractIDsByLexRange(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+	models := createIndexedTestModels(5)
+	tx := testPool.NewTransaction()
+	for i, model := range models {
+		model.String = strconv.Itoa(i)
+		tx.Save(indexedTestModels, model)
+	}
+	require.NoError(t, tx.Exec())
+
+	testCases := []struct {
+		min, max    string
+		expectedIDs []string
+	}{
+		{"-", "+", modelIDs(Models(models))},
+		{"[1", "[3", modelIDs(Models(models[1:4]))},
+		{"(1", "(3", modelIDs(Models(models[2:3]))},
+		{"(3", "+", modelIDs(Models(models[4:]))},
+	}
+	for i, tc := range testCases {
+		destKey := "TestExtractIDsByLexRange:" + strconv.Itoa(i)
+		gotIDs := []string{}
+		tx := testPool.NewTransaction()
+		tx.ExtractIDsByLexRange(indexedTestModels, "String", destKey, tc.min, tc.max)
+		tx.Command("ZRANGE", redis.Args{destKey, 0, -1}, NewScanStringsHandler(&gotIDs))
+		require.NoError(t, tx.Exec())
+		assert.Equal(t, tc.expectedIDs, gotIDs, "test case %d", i)
+	}
+
+	// An invalid range bound should result in an error.
+	tx = testPool.NewTransaction()
+	tx.ExtractIDsByLexRange(indexedTestModels, "String", "foo", "1", "+")
+	assert.Error(t, tx.Exec())
+}
+
+func TestExecInBatches(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Each handler should receive the reply for its own command
+	tx := testPool.NewTransaction()
+	got := make([]int, 10)
+	for i := range got {
+		tx.Command("INCR", redis.Args{"counter"}, NewScanIntHandler(&got[i]))
+	}
+	require.NoError(t, tx.ExecInBatches(3))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, got)
+
+	// If a command in one batch fails, later batches should not be sent
+	tx = testPool.NewTransaction()
+	tx.Command("SET", redis.Args{"notAnInt", "foo"}, nil)
+	tx.Command("INCR", redis.Args{"notAnInt"}, nil)
+	tx.Command("SET", redis.Args{"nextBatch", "bar"}, nil)
+	assert.Error(t, tx.ExecInBatches(2))
+	expectKeyDoesNotExist(t, "nextBatch")
+
+	// Transactions which are watching keys cannot be split
+	tx = testPool.NewTransaction()
+	require.NoError(t, tx.WatchKey("counter"))
+	tx.Command("INCR", redis.Args{"counter"}, nil)
+	tx.Command("INCR", redis.Args{"counter"}, nil)
+	assert.Error(t, tx.ExecInBatches(1))
+	assert.Error(t, testPool.NewTransaction().ExecInBatches(0))
+}
+
+func TestPoolBatchSize(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options.WithBatchSize(2))
+	defer func() {
+		_ = pool.Close()
+	}()
+	tx := pool.NewTransaction().Batched()
+	tx.Command("SET", redis.Args{"notAnInt", "foo"}, nil)
+	tx.Command("INCR", redis.Args{"notAnInt"}, nil)
+	tx.Command("SET", redis.Args{"nextBatch", "bar"}, nil)
+	assert.Error(t, tx.Exec())
+	expectKeyDoesNotExist(t, "nextBatch")
+
+	// A transaction which is not marked as batched (e.g. one used internally by
+	// Save) should not be split.
+	tx = pool.NewTransaction()
+	tx.Command("INCR", redis.Args{"notAnInt"}, nil)
+	tx.Command("INCR", redis.Args{"notAnInt"}, nil)
+	tx.Command("SET", redis.Args{"sameBatch", "bar"}, nil)
+	assert.Error(t, tx.Exec())
+	expectKeyExists(t, "sameBatch")
+}
+
+func TestAtomic(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// An atomic transaction should not be split, even if the pool has a default
+	// batch size.
+	pool := NewPoolWithOptions(testPool.options.WithBatchSize(2))
+	defer func() {
+		_ = pool.Close()
+	}()
+	tx := pool.NewTransaction().Batched().Atomic()
+	tx.Command("SET", redis.Args{"notAnInt", "foo"}, nil)
+	tx.Command("INCR", redis.Args{"notAnInt"}, nil)
+	tx.Command("SET", redis.Args{"sameBatch", "bar"}, nil)
+	assert.Error(t, tx.Exec())
+	expectKeyExists(t, "sameBatch")
+
+	tx = testPool.NewTransaction().Atomic()
+	tx.Command("INCR", redis.Args{"counter"}, nil)
+	tx.Command("INCR", redis.Args{"counter"}, nil)
+	assert.Error(t, tx.ExecInBatches(1))
+}
+
+func TestDryRun(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	model := &testModel{
+		Int:    42,
+		String: "foo",
+		Bool:   true,
+	}
+	tx := testPool.NewTransaction()
+	before := indexScore(time.Now())
+	tx.Save(testModels, model)
+	after := indexScore(time.Now())
+	commands, err := tx.DryRun()
+	require.NoError(t, err)
+	require.Len(t, commands, 2)
+	require.Len(t, commands[1].Args, 6)
+	score, ok := commands[1].Args[4].(int64)
+	require.True(t, ok, "expected the score to be an int64 but got %T", commands[1].Args[4])
+	assert.True(t, score >= before && score <= after, "expected the score to be the time the model was saved")
+	expected := []CommandDescription{
+		{
+			Name: "HMSET",
+			Args: []interface{}{testModels.ModelKey(model.ID), "Int", 42, "String", "foo", "Bool", true},
+		},
+		{
+			Name: "EVALSHA",
+			Args: []interface{}{addToIndexScript.Hash(), 0, testModels.IndexKey(), model.ID, score, 1},
+		},
+	}
+	assert.Equal(t, expected, commands)
+	assert.Equal(t, fmt.Sprintf("EVALSHA %s 0 %s %s %d 1", addToIndexScript.Hash(), testModels.IndexKey(), model.ID, score), commands[1].String())
+
+	// Nothing should have been written to the database
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	size, err := redis.Int(conn.Do("DBSIZE"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	// Scripts should be described as EVALSHA commands
+	tx = testPool.NewTransaction()
+	tx.Delete(indexedTestModels, "foo", nil)
+	commands, err = tx.DryRun()
+	require.NoError(t, err)
+	expectedScript := CommandDescription{
+		Name: "EVALSHA",
+		Args: []interface{}{deleteStringIndexScript.Hash(), 0, indexedTestModels.Name(), "foo", "String", "string", indexedTestModels.Name() + ":", ""},
+	}
+	assert.Contains(t, commands, expectedScript)
+
+	// Errors should be returned
+	tx = testPool.NewTransaction()
+	tx.Save(nil, model)
+	_, err = tx.DryRun()
+	assert.Error(t, err)
+}
+
+func TestTransactionTimeout(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// A command which blocks for longer than the timeout should return an error
+	tx := testPool.NewTransaction().Timeout(50 * time.Millisecond)
+	tx.Command("BLPOP", redis.Args{"emptyList", 2}, nil)
+	start := time.Now()
+	assert.Error(t, tx.Exec())
+	assert.True(t, time.Since(start) < time.Second, "Expected Exec to return after the timeout")
+
+	// Transactions which reply in time should not be affected
+	tx = testPool.NewTransaction().Timeout(time.Second)
+	var value string
+	tx.Command("SET", redis.Args{"foo", "bar"}, nil)
+	tx.Command("GET", redis.Args{"foo"}, NewScanStringHandler(&value))
+	require.NoError(t, tx.Exec())
+	assert.Equal(t, "bar", value)
+}
+
+func TestConcurrentTransaction(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Actions can be added from multiple goroutines at once
+	tx := testPool.NewTransaction()
+	done := make(chan struct{})
+	numGoroutines, numModels := 10, 20
+	for i := 0; i < numGoroutines; i++ {
+		go func(i int) {
+			defer func() {
+				done <- struct{}{}
+			}()
+			for j := 0; j < numModels; j++ {
+				tx.Save(indexedTestModels, &indexedTestModel{Int: i, String: strconv.Itoa(j)})
+			}
+		}(i)
+	}
+	for i := 0; i < numGoroutines; i++ {
+		<-done
+	}
+	require.NoError(t, tx.Exec())
+	count, err := indexedTestModels.Count()
+	require.NoError(t, err)
+	assert.Equal(t, numGoroutines*numModels, count)
+	count, err = indexedTestModels.NewQuery().Filter("Int =", 3).Count()
+	require.NoError(t, err)
+	assert.Equal(t, numModels, count)
+
+	// A transaction can only be executed once
+	assert.Equal(t, errTransactionDone, tx.Exec())
+	_, err = tx.DryRun()
+	assert.Equal(t, errTransactionDone, err)
+	assert.Equal(t, errTransactionDone, tx.WatchKey("foo"))
+}
+
+func TestTransactionClone(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	template := testPool.NewTransaction().Atomic().Timeout(time.Second).WithActor("worker")
+	template.Command("SET", redis.Args{"foo", "bar"}, nil)
+	clone := template.Clone()
+	assert.True(t, clone.atomic)
+	assert.Equal(t, time.Second, clone.timeout)
+	assert.Equal(t, "worker", clone.actor)
+	commands, err := clone.DryRun()
+	require.NoError(t, err)
+	assert.Empty(t, commands)
+
+	// Clones can be built and executed in parallel
+	done := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func(i int) {
+			tx := template.Clone()
+			tx.Command("SET", redis.Args{"key" + strconv.Itoa(i), i}, nil)
+			done <- tx.Exec()
+		}(i)
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, <-done)
+	}
+	require.NoError(t, template.Exec())
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	for i := 0; i < 5; i++ {
+		value, err := redis.Int(conn.Do("GET", "key"+strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+}
+
+func TestExecStreaming(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	// Each handler should be called with the reply for its own command, before
+	// the replies for commands outside the window are read
+	tx := testPool.NewTransaction()
+	got := make([]int, 10)
+	for i := range got {
+		i := i
+		tx.Command("INCR", redis.Args{"counter"}, func(reply interface{}) error {
+			if err := NewScanIntHandler(&got[i])(reply); err != nil {
+				return err
+			}
+			if i+3 < len(got) && got[i+3] != 0 {
+				return fmt.Errorf("the reply for command %d was handled too early", i+3)
+			}
+			return nil
+		})
+	}
+	require.NoError(t, tx.ExecStreaming(3))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, got)
+
+	// If a command fails, the commands which were not sent before its reply was
+	// read should not be sent and the handlers for the commands after the
+	// failure should not be called
+	tx = testPool.NewTransaction()
+	called := false
+	tx.Command("SET", redis.Args{"notAnInt", "foo"}, nil)
+	tx.Command("INCR", redis.Args{"notAnInt"}, nil)
+	tx.Command("SET", redis.Args{"sameWindow", "bar"}, func(interface{}) error {
+		called = true
+		return nil
+	})
+	tx.Command("PING", nil, nil)
+	tx.Command("SET", redis.Args{"nextWindow", "bar"}, nil)
+	err := tx.ExecStreaming(3)
+	var txErr TransactionError
+	require.True(t, errors.As(err, &txErr))
+	assert.Equal(t, 4, txErr.Executed)
+	assert.Equal(t, 1, txErr.Errors[0].Index)
+	assert.False(t, called)
+	expectKeyExists(t, "sameWindow")
+	expectKeyDoesNotExist(t, "nextWindow")
+
+	// Handler errors should be returned
+	tx = testPool.NewTransaction()
+	handlerErr := errors.New("handler error")
+	tx.Command("PING", nil, func(interface{}) error { return handlerErr })
+	tx.Command("SET", redis.Args{"afterHandlerError", "bar"}, nil)
+	assert.Equal(t, handlerErr, tx.ExecStreaming(1))
+	expectKeyDoesNotExist(t, "afterHandlerError")
+
+	// Transactions which need MULTI/EXEC cannot be streamed
+	tx = testPool.NewTransaction()
+	require.NoError(t, tx.WatchKey("counter"))
+	tx.Command("INCR", redis.Args{"counter"}, nil)
+	assert.Error(t, tx.ExecStreaming(10))
+	tx = testPool.NewTransaction().Atomic()
+	tx.Command("INCR", redis.Args{"counter"}, nil)
+	tx.Command("INCR", redis.Args{"counter"}, nil)
+	assert.Error(t, tx.ExecStreaming(10))
+	assert.Error(t, testPool.NewTransaction().ExecStreaming(0))
+}
+
+func TestExecWithTimings(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	tx := testPool.NewTransaction()
+	tx.Command("SET", redis.Args{"foo", "bar"}, func(interface{}) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	tx.modelCommand("abc", "GET", redis.Args{"foo"}, nil)
+	tx.Command("INCR", redis.Args{"foo"}, nil)
+	timings, err := tx.ExecWithTimings()
+	require.Error(t, err)
+	require.Len(t, timings, 3)
+	for i, name := range []string{"SET", "GET", "INCR"} {
+		assert.Equal(t, i, timings[i].Index)
+		assert.Equal(t, name, timings[i].Name)
+		assert.True(t, timings[i].Duration > 0)
+	}
+	assert.True(t, timings[0].HandlerDuration >= 10*time.Millisecond)
+	assert.Equal(t, "abc", timings[1].ModelID)
+	assert.Equal(t, time.Duration(0), timings[2].HandlerDuration)
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File ttl.go contains code for the ttl option of the zoom struct tag, which
+// lets individual fields of the main hash expire independently of the model.
+
+package zoom
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// parseFieldTTL parses the value of the ttl option of the zoom struct tag for
+// the field with the given name, which is a positive number of seconds.
+func parseFieldTTL(fieldName string, option string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(option)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("zoom: ttl option for field %s must be a positive number of seconds. Got: %s", fieldName, option)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// hasFieldTTL returns true iff any field of the model type has the ttl option.
+func (ms *modelSpec) hasFieldTTL() bool {
+	for _, fs := range ms.fields {
+		if fs.ttl > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// expireFields adds HEXPIRE commands to the transaction which set the TTL of
+// the fields with the ttl option which are written by hashArgs, the arguments
+// of an HMSET or HSET command for the main hash of the model with the given
+// id. Writing a field removes its TTL, so this must be called each time a
+// field with the ttl option is written.
+func (t *Transaction) expireFields(spec *modelSpec, id string, hashArgs redis.Args) {
+	if !spec.hasFieldTTL() {
+		return
+	}
+	// The first element of hashArgs is the model key, which is followed by
+	// pairs of redis names and values. Fields with the same TTL are expired
+	// with a single command.
+	redisNamesByTTL := map[time.Duration][]string{}
+	ttls := []time.Duration{}
+	for _, fs := range spec.fields {
+		if fs.ttl == 0 {
+			continue
+		}
+		for i := 1; i+1 < len(hashArgs); i += 2 {
+			if hashArgs[i] != fs.redisName {
+				continue
+			}
+			if _, found := redisNamesByTTL[fs.ttl]; !found {
+				ttls = append(ttls, fs.ttl)
+			}
+			redisNamesByTTL[fs.ttl] = append(redisNamesByTTL[fs.ttl], fs.redisName)
+			break
+		}
+	}
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] < ttls[j] })
+	for _, ttl := range ttls {
+		redisNames := redisNamesByTTL[ttl]
+		args := redis.Args{hashArgs[0], int64(ttl / time.Second), "FIELDS", len(redisNames)}.AddFlat(redisNames)
+		t.modelCommand(id, "HEXPIRE", args, nil)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File ttl_test.go tests the code in ttl.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ttlTestModel struct {
+	Name    string
+	Summary string `zoom:"ttl=300"`
+	Score   *int   `zoom:"ttl=300,lazy"`
+	Preview []byte `zoom:"ttl=60"`
+	RandomID
+}
+
+func TestFieldTTL(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options)
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&ttlTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+
+	// Saving a model should set the ttl of the fields with the ttl option,
+	// grouped by ttl
+	score := 42
+	model := &ttlTestModel{Name: "a", Summary: "short", Score: &score, Preview: []byte("p")}
+	model.SetModelID("foo")
+	tx := pool.NewTransaction()
+	tx.Save(col, model)
+	commands, err := tx.DryRun()
+	require.NoError(t, err)
+	key := col.ModelKey("foo")
+	assert.Contains(t, commands, CommandDescription{Name: "HEXPIRE", Args: []interface{}{key, int64(60), "FIELDS", 1, "Preview"}})
+	assert.Contains(t, commands, CommandDescription{Name: "HEXPIRE", Args: []interface{}{key, int64(300), "FIELDS", 2, "Summary", "Score"}})
+	tx = pool.NewTransaction()
+	tx.SaveFields(col, []string{"Name"}, model)
+	commands, err = tx.DryRun()
+	require.NoError(t, err)
+	for _, command := range commands {
+		assert.NotEqual(t, "HEXPIRE", command.Name, "fields which are not saved should not be expired")
+	}
+	tx = pool.NewTransaction()
+	tx.SetRawField(col, "foo", "Summary", "raw")
+	commands, err = tx.DryRun()
+	require.NoError(t, err)
+	assert.Contains(t, commands, CommandDescription{Name: "HEXPIRE", Args: []interface{}{key, int64(300), "FIELDS", 1, "Summary"}})
+
+	// Expired fields should be treated as absent
+	require.NoError(t, col.Save(model))
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("HDEL", key, "Summary", "Score")
+	require.NoError(t, err)
+	found := &ttlTestModel{Summary: "stale", Score: &score}
+	require.NoError(t, col.FindFields("foo", []string{"Summary", "Score", "Preview"}, found))
+	assert.Equal(t, &ttlTestModel{Preview: []byte("p"), RandomID: model.RandomID}, found)
+	present, err := col.FindWithPresence("foo", &ttlTestModel{})
+	require.NoError(t, err)
+	assert.False(t, present["Summary"])
+	assert.True(t, present["Preview"])
+	found = &ttlTestModel{Summary: "stale"}
+	require.NoError(t, col.Find("foo", found))
+	assert.Equal(t, "", found.Summary)
+	assert.Equal(t, "a", found.Name)
+
+	// Fields with a ttl cannot be updated in place
+	_, err = col.NewQuery().Update(map[string]interface{}{"Summary": "new"})
+	assert.Error(t, err)
+	assert.Error(t, col.GetSet("foo", map[string]interface{}{"Summary": "new"}, &ttlTestModel{}))
+
+	// Invalid options should be rejected
+	type badTTLModel struct {
+		Summary string `zoom:"ttl=0"`
+		RandomID
+	}
+	type indexedTTLModel struct {
+		Summary string `zoom:"ttl=5,index"`
+		RandomID
+	}
+	for _, model := range []Model{&badTTLModel{}, &indexedTTLModel{}} {
+		_, err := pool.NewCollection(model)
+		assert.Error(t, err, "expected an error when registering %T", model)
+	}
+	_, err = pool.NewCollectionWithOptions(&ttlTestModel{}, DefaultCollectionOptions.WithName("documentTTLTestModel").WithLayout(DocumentLayout))
+	assert.Error(t, err)
+}
+
+func TestFieldTTLCache(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	pool := NewPoolWithOptions(testPool.options.WithCacheSize(10))
+	defer func() {
+		_ = pool.Close()
+	}()
+	col, err := pool.NewCollectionWithOptions(&ttlTestModel{}, DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	assert.Nil(t, pool.cacheFor(col), "collections with a ttl field should not be cached")
+
+	// An expired field should not be returned after the model was found once
+	model := &ttlTestModel{Name: "a", Summary: "short"}
+	require.NoError(t, col.Save(model))
+	found := &ttlTestModel{}
+	require.NoError(t, col.Find(model.ID, found))
+	assert.Equal(t, "short", found.Summary)
+	conn := pool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("HDEL", col.ModelKey(model.ID), "Summary")
+	require.NoError(t, err)
+	found = &ttlTestModel{}
+	require.NoError(t, col.Find(model.ID, found))
+	assert.Equal(t, "", found.Summary)
+	assert.Equal(t, "a", found.Name)
+}
@@ -0,0 +1,371 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+// File typed_collection.go contains TypedCollection and TypedQuery, which use
+// generics to wrap Collection and Query with type-safe methods. They require
+// Go 1.18 or later.
+
+package zoom
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ModelPointer is a constraint satisfied by pointers to T which implement the
+// Model interface (e.g. *Person, if *Person has ModelID and SetModelID
+// methods).
+type ModelPointer[T any] interface {
+	*T
+	Model
+}
+
+// TypedCollection wraps a Collection of models of type *T and provides
+// type-safe versions of its methods. Use NewTypedCollection to create one. The
+// second type parameter is always *T and can be omitted, e.g.:
+//
+//	people, err := zoom.NewTypedCollection[Person](People)
+//	person, err := people.Find(id) // person has type *Person
+type TypedCollection[T any, PT ModelPointer[T]] struct {
+	collection *Collection
+}
+
+// NewTypedCollection returns a TypedCollection which wraps c. It returns an
+// error if c is not a collection of models of type *T.
+func NewTypedCollection[T any, PT ModelPointer[T]](c *Collection) (*TypedCollection[T, PT], error) {
+	if c == nil {
+		return nil, newNilCollectionError("NewTypedCollection")
+	}
+	if typ := reflect.TypeOf((*T)(nil)); typ != c.spec.typ {
+		return nil, fmt.Errorf("zoom: Error in NewTypedCollection: Collection %s holds models of type %s, not %s", c.Name(), c.spec.typ.String(), typ.String())
+	}
+	return &TypedCollection[T, PT]{collection: c}, nil
+}
+
+// Collection returns the underlying Collection.
+func (tc *TypedCollection[T, PT]) Collection() *Collection {
+	return tc.collection
+}
+
+// Save is like Collection.Save.
+func (tc *TypedCollection[T, PT]) Save(model *T) error {
+	return tc.collection.Save(PT(model))
+}
+
+// SaveFields is like Collection.SaveFields.
+func (tc *TypedCollection[T, PT]) SaveFields(fieldNames []string, model *T) error {
+	return tc.collection.SaveFields(fieldNames, PT(model))
+}
+
+// SaveChanged is like Collection.SaveChanged.
+func (tc *TypedCollection[T, PT]) SaveChanged(model *T) error {
+	return tc.collection.SaveChanged(PT(model))
+}
+
+// GetSet is like Collection.GetSet but allocates and returns a new model
+// holding the old values.
+func (tc *TypedCollection[T, PT]) GetSet(id string, fieldValues map[string]interface{}) (*T, error) {
+	old := new(T)
+	if err := tc.collection.GetSet(id, fieldValues, PT(old)); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// Find is like Collection.Find but allocates and returns a new model.
+func (tc *TypedCollection[T, PT]) Find(id string) (*T, error) {
+	model := new(T)
+	if err := tc.collection.Find(id, PT(model)); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// FindWith is like Collection.FindWith but allocates and returns a new model.
+func (tc *TypedCollection[T, PT]) FindWith(id string, fieldNames ...string) (*T, error) {
+	model := new(T)
+	if err := tc.collection.FindWith(id, PT(model), fieldNames...); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// FindWithPresence is like Collection.FindWithPresence but allocates and
+// returns a new model.
+func (tc *TypedCollection[T, PT]) FindWithPresence(id string) (*T, map[string]bool, error) {
+	model := new(T)
+	present, err := tc.collection.FindWithPresence(id, PT(model))
+	if err != nil {
+		return nil, nil, err
+	}
+	return model, present, nil
+}
+
+// FindFields is like Collection.FindFields but allocates and returns a new
+// model.
+func (tc *TypedCollection[T, PT]) FindFields(id string, fieldNames []string) (*T, error) {
+	model := new(T)
+	if err := tc.collection.FindFields(id, fieldNames, PT(model)); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// FindAll is like Collection.FindAll but allocates and returns a new slice of
+// models.
+func (tc *TypedCollection[T, PT]) FindAll() ([]*T, error) {
+	models := []*T{}
+	if err := tc.collection.FindAll(&models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// FindAllInBatches is like Collection.FindAllInBatches but allocates and
+// returns a new slice of models.
+func (tc *TypedCollection[T, PT]) FindAllInBatches(batchSize int) ([]*T, error) {
+	models := []*T{}
+	if err := tc.collection.FindAllInBatches(&models, batchSize); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// Random is like Collection.Random but allocates and returns a new slice of
+// models.
+func (tc *TypedCollection[T, PT]) Random(n int) ([]*T, error) {
+	models := []*T{}
+	if err := tc.collection.Random(n, &models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// ReleaseModels is like Collection.ReleaseModels, but since models is not a
+// pointer, the length of the caller's slice is not changed.
+func (tc *TypedCollection[T, PT]) ReleaseModels(models []*T) error {
+	return tc.collection.ReleaseModels(&models)
+}
+
+// ForEachBatch is like Collection.ForEachBatch but passes the models in each
+// batch to fn.
+func (tc *TypedCollection[T, PT]) ForEachBatch(batchSize int, fn func(models []*T) error) error {
+	models := []*T{}
+	return tc.collection.ForEachBatch(&models, batchSize, func() error {
+		return fn(models)
+	})
+}
+
+// Exists is like Collection.Exists.
+func (tc *TypedCollection[T, PT]) Exists(id string) (bool, error) {
+	return tc.collection.Exists(id)
+}
+
+// ExistsMany is like Collection.ExistsMany.
+func (tc *TypedCollection[T, PT]) ExistsMany(ids []string) (map[string]bool, error) {
+	return tc.collection.ExistsMany(ids)
+}
+
+// Count is like Collection.Count.
+func (tc *TypedCollection[T, PT]) Count() (int, error) {
+	return tc.collection.Count()
+}
+
+// Delete is like Collection.Delete.
+func (tc *TypedCollection[T, PT]) Delete(id string) (bool, error) {
+	return tc.collection.Delete(id)
+}
+
+// DeleteAll is like Collection.DeleteAll.
+func (tc *TypedCollection[T, PT]) DeleteAll() (int, error) {
+	return tc.collection.DeleteAll()
+}
+
+// NewQuery is like Collection.NewQuery but returns a TypedQuery.
+func (tc *TypedCollection[T, PT]) NewQuery() *TypedQuery[T, PT] {
+	return &TypedQuery[T, PT]{query: tc.collection.NewQuery()}
+}
+
+// TypedQuery wraps a Query for models of type *T and provides type-safe
+// versions of its finisher methods. The modifier methods work exactly like the
+// corresponding methods of Query.
+type TypedQuery[T any, PT ModelPointer[T]] struct {
+	query *Query
+}
+
+// Query returns the underlying Query.
+func (q *TypedQuery[T, PT]) Query() *Query {
+	return q.query
+}
+
+// String returns a string representation of the query.
+func (q *TypedQuery[T, PT]) String() string {
+	return q.query.String()
+}
+
+// Order is like Query.Order.
+func (q *TypedQuery[T, PT]) Order(fieldName string) *TypedQuery[T, PT] {
+	q.query.Order(fieldName)
+	return q
+}
+
+// Limit is like Query.Limit.
+func (q *TypedQuery[T, PT]) Limit(amount uint) *TypedQuery[T, PT] {
+	q.query.Limit(amount)
+	return q
+}
+
+// Offset is like Query.Offset.
+func (q *TypedQuery[T, PT]) Offset(amount uint) *TypedQuery[T, PT] {
+	q.query.Offset(amount)
+	return q
+}
+
+// Last is like Query.Last.
+func (q *TypedQuery[T, PT]) Last(n uint) *TypedQuery[T, PT] {
+	q.query.Last(n)
+	return q
+}
+
+// Timeout is like Query.Timeout.
+func (q *TypedQuery[T, PT]) Timeout(d time.Duration) *TypedQuery[T, PT] {
+	q.query.Timeout(d)
+	return q
+}
+
+// Parallel is like Query.Parallel.
+func (q *TypedQuery[T, PT]) Parallel(workers int) *TypedQuery[T, PT] {
+	q.query.Parallel(workers)
+	return q
+}
+
+// Include is like Query.Include.
+func (q *TypedQuery[T, PT]) Include(fields ...string) *TypedQuery[T, PT] {
+	q.query.Include(fields...)
+	return q
+}
+
+// Unscoped is like Query.Unscoped.
+func (q *TypedQuery[T, PT]) Unscoped() *TypedQuery[T, PT] {
+	q.query.Unscoped()
+	return q
+}
+
+// WithValue is like Query.WithValue.
+func (q *TypedQuery[T, PT]) WithValue(key, value interface{}) *TypedQuery[T, PT] {
+	q.query.WithValue(key, value)
+	return q
+}
+
+// Exclude is like Query.Exclude.
+func (q *TypedQuery[T, PT]) Exclude(fields ...string) *TypedQuery[T, PT] {
+	q.query.Exclude(fields...)
+	return q
+}
+
+// Filter is like Query.Filter.
+func (q *TypedQuery[T, PT]) Filter(filterString string, value interface{}) *TypedQuery[T, PT] {
+	q.query.Filter(filterString, value)
+	return q
+}
+
+// FilterRange is like Query.FilterRange.
+func (q *TypedQuery[T, PT]) FilterRange(fieldName string, min interface{}, max interface{}, inclusive bool) *TypedQuery[T, PT] {
+	q.query.FilterRange(fieldName, min, max, inclusive)
+	return q
+}
+
+// FilterID is like Query.FilterID.
+func (q *TypedQuery[T, PT]) FilterID(operator string, id string) *TypedQuery[T, PT] {
+	q.query.FilterID(operator, id)
+	return q
+}
+
+// FilterIDPrefix is like Query.FilterIDPrefix.
+func (q *TypedQuery[T, PT]) FilterIDPrefix(prefix string) *TypedQuery[T, PT] {
+	q.query.FilterIDPrefix(prefix)
+	return q
+}
+
+// Search is like Query.Search.
+func (q *TypedQuery[T, PT]) Search(fieldName string, text string) *TypedQuery[T, PT] {
+	q.query.Search(fieldName, text)
+	return q
+}
+
+// Join is like Query.Join.
+func (q *TypedQuery[T, PT]) Join(fieldName string, target *Collection) *TypedQuery[T, PT] {
+	q.query.Join(fieldName, target)
+	return q
+}
+
+// Run is like Query.Run but allocates and returns a new slice of models.
+func (q *TypedQuery[T, PT]) Run() ([]*T, error) {
+	models := []*T{}
+	if err := q.query.Run(&models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// RunOne is like Query.RunOne but allocates and returns a new model.
+func (q *TypedQuery[T, PT]) RunOne() (*T, error) {
+	model := new(T)
+	if err := q.query.RunOne(PT(model)); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// First is like Query.First but allocates and returns a new model.
+func (q *TypedQuery[T, PT]) First() (*T, error) {
+	model := new(T)
+	if err := q.query.First(PT(model)); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// LastOne is like Query.LastOne but allocates and returns a new model.
+func (q *TypedQuery[T, PT]) LastOne() (*T, error) {
+	model := new(T)
+	if err := q.query.LastOne(PT(model)); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// RunExactlyOne is like Query.RunExactlyOne but allocates and returns a new
+// model.
+func (q *TypedQuery[T, PT]) RunExactlyOne() (*T, error) {
+	model := new(T)
+	if err := q.query.RunExactlyOne(PT(model)); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// Count is like Query.Count.
+func (q *TypedQuery[T, PT]) Count() (int, error) {
+	return q.query.Count()
+}
+
+// EstimateCount is like Query.EstimateCount.
+func (q *TypedQuery[T, PT]) EstimateCount() (int, error) {
+	return q.query.EstimateCount()
+}
+
+// IDs is like Query.IDs.
+func (q *TypedQuery[T, PT]) IDs() ([]string, error) {
+	return q.query.IDs()
+}
+
+// Delete is like Query.Delete.
+func (q *TypedQuery[T, PT]) Delete() (int, error) {
+	return q.query.Delete()
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+// File typed_collection_test.go tests the code in typed_collection.go
+
+package zoom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedCollection(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := NewTypedCollection[indexedTestModel](indexedTestModels)
+	require.NoError(t, err)
+	expected := createIndexedTestModels(5)
+	for _, model := range expected {
+		require.NoError(t, models.Save(model))
+	}
+
+	got, err := models.Find(expected[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, expected[0], got)
+	_, err = models.Find("invalid")
+	assert.IsType(t, ModelNotFoundError{}, err)
+
+	all, err := models.FindAll()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, all)
+	all, err = models.FindAllInBatches(2)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expected, all)
+	batched := []*indexedTestModel{}
+	require.NoError(t, models.ForEachBatch(2, func(batch []*indexedTestModel) error {
+		assert.True(t, len(batch) <= 2)
+		batched = append(batched, batch...)
+		return nil
+	}))
+	assert.ElementsMatch(t, expected, batched)
+
+	ordered, err := models.NewQuery().Order("-Int").Run()
+	require.NoError(t, err)
+	require.Len(t, ordered, len(expected))
+	for i := 1; i < len(ordered); i++ {
+		assert.True(t, ordered[i-1].Int >= ordered[i].Int, "Models were not in the correct order")
+	}
+	first, err := models.NewQuery().Order("Int").RunOne()
+	require.NoError(t, err)
+	assert.Equal(t, ordered[len(ordered)-1].Int, first.Int)
+
+	// The type parameter must match the type of the collection
+	_, err = NewTypedCollection[testModel](indexedTestModels)
+	assert.Error(t, err)
+}
@@ -13,6 +13,7 @@ import (
 	"math/big"
 	"net"
 	"reflect"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -21,10 +22,10 @@ import (
 )
 
 var (
-	// delString is used as a suffix for string index tricks. This is a string which equals the ASCII
-	// DEL character and is the highest possible value (in terms of codepoint, which is also
-	// how redis sorts strings) for an ASCII character.
-	delString = string([]byte{byte(127)})
+	// maxByteString is used as a suffix for string index tricks. This is a string which consists
+	// of the byte 0xFF, which is the highest possible value for a byte (redis sorts strings byte
+	// by byte) and never occurs in valid UTF-8, so it sorts after every model id.
+	maxByteString = string([]byte{byte(255)})
 	// nullString is used as a suffix for string index tricks. This is a string which equals the ASCII
 	// NULL character and is the lowest possible value (in terms of codepoint, which is also
 	// how redis sorts strings) for an ASCII character.
@@ -36,6 +37,9 @@ var (
 func init() {
 	// Set chars to the 58 non-ambiguous characters use by base58 encoding
 	uniuri.StdChars = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
+	// Cache the hardware id before any models can be saved from multiple
+	// goroutines.
+	hardwareID = getHardwareID()
 }
 
 // Models converts in to []Model. It will panic if the underlying type
@@ -124,6 +128,15 @@ func typeIsString(typ reflect.Type) bool {
 	return k == reflect.String || ((k == reflect.Slice || k == reflect.Array) && typ.Elem().Kind() == reflect.Uint8)
 }
 
+// typeIsFullTextSearchable returns true iff typ is a string or a pointer to a
+// string.
+func typeIsFullTextSearchable(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.String
+}
+
 // typeIsNumeric returns true iff typ is one of the numeric primitive types
 func typeIsNumeric(typ reflect.Type) bool {
 	k := typ.Kind()
@@ -141,6 +154,17 @@ func typeIsBool(typ reflect.Type) bool {
 	return k == reflect.Bool
 }
 
+// typeIsBitmapIndexable returns true iff typ is a bool, a string, or one of the
+// integer types, i.e. a type which may have a bitmap index.
+func typeIsBitmapIndexable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
 // typeIsPrimative returns true iff typ is a primitive type, i.e. either a
 // string, bool, or numeric type.
 func typeIsPrimative(typ reflect.Type) bool {
@@ -149,7 +173,9 @@ func typeIsPrimative(typ reflect.Type) bool {
 
 // numericScore returns a float64 which is the score for val in a sorted set.
 // If val is a pointer, it will keep dereferencing until it reaches the underlying
-// value. It panics if val is not a numeric type or a pointer to a numeric type.
+// value. Values of types which implement Scorer use the score returned by
+// IndexScore. It panics if val is not a numeric type, a Scorer, or a pointer
+// to either.
 func numericScore(val reflect.Value) float64 {
 	for val.Kind() == reflect.Ptr {
 		val = val.Elem()
@@ -164,11 +190,93 @@ func numericScore(val reflect.Value) float64 {
 	case reflect.Float32, reflect.Float64:
 		return val.Float()
 	default:
+		if score, ok := scorerScore(val); ok {
+			return score
+		}
 		msg := fmt.Sprintf("zoom: attempt to call numericScore on non-numeric type %s", val.Type().String())
 		panic(msg)
 	}
 }
 
+// typeIsInteger64 returns true iff typ is an int64 or a uint64. Fields of these
+// types are indexed with an integer index instead of a numeric index, because
+// not all 64-bit integers can be represented exactly as a float64 score.
+func typeIsInteger64(typ reflect.Type) bool {
+	k := typ.Kind()
+	return k == reflect.Int64 || k == reflect.Uint64
+}
+
+// integerIndexValue returns the value stored in an integer index for val, which
+// must be an int64 or a uint64. If val is a pointer, it will keep dereferencing
+// until it reaches the underlying value. The values for non-negative integers
+// are "1" followed by the integer padded with zeros to 20 digits, and the values
+// for negative integers are "0" followed by the 9's complement of the absolute
+// value, also padded to 20 digits. This way, every value has the same length and
+// the lexicographical order of the values is the same as the numerical order of
+// the integers. The Lua scripts which maintain integer indexes use the same
+// encoding.
+func integerIndexValue(val reflect.Value) string {
+	for val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeInt64(val.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return encodeUint64(val.Uint())
+	default:
+		msg := fmt.Sprintf("zoom: attempt to call integerIndexValue on non-integer type %s", val.Type().String())
+		panic(msg)
+	}
+}
+
+// encodeStringIndexValue returns the value stored in a string index for the
+// value of a string field. Since the members of string indexes consist of the
+// value, a NULL byte, and the id, any NULL bytes in the value are replaced
+// with the bytes 0x01 0x01, and any 0x01 bytes with 0x01 0x02. This way the
+// value never contains the separator and the lexicographical order of the
+// encoded values is the same as the order of the values, so that arbitrary
+// strings can be filtered and sorted correctly. Values which contain neither
+// byte (i.e. almost all values) are not changed. The Lua scripts which maintain
+// string indexes use the same encoding.
+func encodeStringIndexValue(value string) string {
+	if strings.IndexAny(value, "\x00\x01") == -1 {
+		return value
+	}
+	encoded := make([]byte, 0, len(value)+1)
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case 0x00:
+			encoded = append(encoded, 0x01, 0x01)
+		case 0x01:
+			encoded = append(encoded, 0x01, 0x02)
+		default:
+			encoded = append(encoded, value[i])
+		}
+	}
+	return string(encoded)
+}
+
+// encodeInt64 returns the value stored in an integer index for i. See
+// integerIndexValue.
+func encodeInt64(i int64) string {
+	if i >= 0 {
+		return encodeUint64(uint64(i))
+	}
+	// Negate i + 1 instead of i to avoid overflowing for math.MinInt64.
+	digits := []byte(fmt.Sprintf("%020d", uint64(-(i+1))+1))
+	for j, digit := range digits {
+		digits[j] = '9' - digit + '0'
+	}
+	return "0" + string(digits)
+}
+
+// encodeUint64 returns the value stored in an integer index for u. See
+// integerIndexValue.
+func encodeUint64(u uint64) string {
+	return fmt.Sprintf("1%020d", u)
+}
+
 // boolScore returns an int which is the score for val in a sorted set.
 // If val is a pointer, it will keep dereferencing until it reaches the underlying
 // value. It panics if val is not a boolean or a pointer to a boolean.
@@ -214,12 +322,21 @@ func generateRandomID() string {
 	return getTimeString() + getAtomicCounter() + getHardwareID() + uniuri.NewLen(6)
 }
 
+// IDForTime returns the prefix of the ids which RandomID generates at time t
+// (with second precision). Since the prefix is the unix time encoded with
+// base58, the ids generated at or after t are lexically greater than or equal
+// to IDForTime(t), and the ids generated before t are less than it, so it can
+// be used with Query.FilterID or Collection.FindIDRange to find the models
+// which were created in a certain time window. This only holds for ids
+// generated by RandomID.
+func IDForTime(t time.Time) string {
+	return string(base58.EncodeBig(nil, big.NewInt(t.UTC().Unix())))
+}
+
 // getTimeString returns the current UTC unix time with second precision encoded
 // with base58 encoding.
 func getTimeString() string {
-	timeInt := time.Now().UTC().Unix()
-	timeBytes := base58.EncodeBig(nil, big.NewInt(timeInt))
-	return string(timeBytes)
+	return IDForTime(time.Now())
 }
 
 // getHardwareID returns a unique identifier for the current machine. It does this
@@ -252,20 +369,15 @@ func getHardwareID() string {
 	return hardwareID
 }
 
-var counter int32
+var counter uint32
 
 // getAtomicCounter returns the base58 encoding of a counter which cycles through
 // the values in the range 0 to 11,316,495. This is the range that can be represented
 // with 4 base58 characters. The returned result will be padded with zeros such that
-// it is always 4 characters long.
+// it is always 4 characters long. It is safe to call from multiple goroutines.
 func getAtomicCounter() string {
-	atomic.AddInt32(&counter, 1)
-	if counter > 58*58*58*58-1 {
-		// Reset the counter if we're beyond what we
-		// can represent with 4 base58 characters
-		atomic.StoreInt32(&counter, 0)
-	}
-	counterBytes := base58.EncodeBig(nil, big.NewInt(int64(counter)))
+	value := atomic.AddUint32(&counter, 1) % (58 * 58 * 58 * 58)
+	counterBytes := base58.EncodeBig(nil, big.NewInt(int64(value)))
 	counterStr := string(counterBytes)
 	switch len(counterStr) {
 	case 0:
@@ -8,6 +8,7 @@
 package zoom
 
 import (
+	"math"
 	"reflect"
 	"testing"
 )
@@ -74,3 +75,33 @@ func TestCompareAsStringSet(t *testing.T) {
 }
 
 // TODO: test other functions which may be mising from here!
+
+func TestIntegerIndexValue(t *testing.T) {
+	// The values should sort in the same order as the integers
+	ints := []int64{math.MinInt64, math.MinInt64 + 1, -1 << 53, -10, -9, -1, 0, 1, 9, 10, 1<<53 + 1, math.MaxInt64 - 1, math.MaxInt64}
+	for i := 1; i < len(ints); i++ {
+		prev, cur := encodeInt64(ints[i-1]), encodeInt64(ints[i])
+		if prev >= cur {
+			t.Errorf("Expected encoding of %d (%s) to be less than encoding of %d (%s)", ints[i-1], prev, ints[i], cur)
+		}
+		if len(prev) != len(cur) {
+			t.Errorf("Expected encodings to have the same length but got %s and %s", prev, cur)
+		}
+	}
+	uints := []uint64{0, 1, 1 << 53, 1<<53 + 1, math.MaxInt64, 1 << 63, math.MaxUint64 - 1, math.MaxUint64}
+	for i := 1; i < len(uints); i++ {
+		prev, cur := encodeUint64(uints[i-1]), encodeUint64(uints[i])
+		if prev >= cur {
+			t.Errorf("Expected encoding of %d (%s) to be less than encoding of %d (%s)", uints[i-1], prev, uints[i], cur)
+		}
+	}
+	// Signed and unsigned values should be encoded the same way, so filters
+	// work regardless of the type of the field
+	if encodeInt64(42) != encodeUint64(42) {
+		t.Errorf("Expected encodeInt64(42) to equal encodeUint64(42) but got %s and %s", encodeInt64(42), encodeUint64(42))
+	}
+	value := int64(-5)
+	if got := integerIndexValue(reflect.ValueOf(&value)); got != encodeInt64(value) {
+		t.Errorf("Expected integerIndexValue to dereference pointers. Expected %s but got %s", encodeInt64(value), got)
+	}
+}
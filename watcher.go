@@ -0,0 +1,180 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File watcher.go contains code related to watching for writes made to model
+// hashes outside of Zoom and re-syncing the corresponding field indexes.
+
+package zoom
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ExternalWriteWatcher listens for keyspace notifications on the keys of a
+// collection and re-syncs the field indexes for any model whose hash was
+// modified or deleted. Use Pool.WatchExternalWrites to create one.
+type ExternalWriteWatcher struct {
+	collection *Collection
+	pool       *Pool
+	psc        redis.PubSubConn
+	done       chan struct{}
+	mut        sync.Mutex
+	err        error
+	closed     bool
+}
+
+// externalWriteEvents is the set of keyspace events which indicate that a
+// model hash was changed or removed.
+var externalWriteEvents = map[string]bool{
+	"hset":         true,
+	"hdel":         true,
+	"hincrby":      true,
+	"hincrbyfloat": true,
+	"del":          true,
+	"expired":      true,
+	"evicted":      true,
+	"rename_from":  true,
+	"rename_to":    true,
+	"restore":      true,
+}
+
+// WatchExternalWrites subscribes to keyspace notifications for the keys of the
+// given collection and re-syncs the field indexes whenever a model hash is
+// modified or deleted. This is useful when other services write directly to
+// the hashes that Zoom manages, which would otherwise leave the field indexes
+// in an inconsistent state. The watcher runs in a separate goroutine and uses
+// its own connection until Close is called.
+//
+// Redis only publishes keyspace notifications if the notify-keyspace-events
+// config option is set to include at least "Kgh" (plus "x" and "e" if you want
+// expired and evicted models to be handled). WatchExternalWrites does not
+// change the config. Note that Redis does not report which client made a
+// change, so writes made by Zoom itself will also trigger a (harmless) re-sync.
+// Each re-sync only touches the entries of the changed model, so its cost does
+// not depend on the size of the indexes.
+func (p *Pool) WatchExternalWrites(collection *Collection) (*ExternalWriteWatcher, error) {
+	if collection == nil {
+		return nil, newNilCollectionError("WatchExternalWrites")
+	}
+	if err := collection.checkHashLayout("WatchExternalWrites"); err != nil {
+		return nil, err
+	}
+	w := &ExternalWriteWatcher{
+		collection: collection,
+		pool:       p,
+		psc:        redis.PubSubConn{Conn: p.NewConn()},
+		done:       make(chan struct{}),
+	}
+	if err := w.psc.PSubscribe(w.pattern()); err != nil {
+		_ = w.psc.Close()
+		return nil, err
+	}
+	go w.listen()
+	return w, nil
+}
+
+// pattern returns the channel pattern for keyspace notifications on all the
+// keys of the collection.
+func (w *ExternalWriteWatcher) pattern() string {
+	return fmt.Sprintf("__keyspace@%d__:%s:*", w.pool.options.Database, w.collection.Name())
+}
+
+// listen receives notifications until the connection is closed.
+func (w *ExternalWriteWatcher) listen() {
+	defer close(w.done)
+	for {
+		switch v := w.psc.Receive().(type) {
+		case redis.PMessage:
+			if err := w.handleNotification(v.Channel, string(v.Data)); err != nil {
+				w.setError(err)
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			w.mut.Lock()
+			closed := w.closed
+			w.mut.Unlock()
+			if !closed {
+				w.setError(v)
+			}
+			return
+		}
+	}
+}
+
+// handleNotification re-syncs the indexes for the model identified by channel
+// if event indicates that the model hash was changed or removed.
+func (w *ExternalWriteWatcher) handleNotification(channel string, event string) error {
+	if !externalWriteEvents[event] {
+		return nil
+	}
+	id, ok := w.modelIDForChannel(channel)
+	if !ok {
+		return nil
+	}
+	t := w.pool.NewTransaction()
+	t.syncModelIndexes(w.collection, id)
+	if err := t.Exec(); err != nil {
+		return err
+	}
+	return w.collection.syncCollatedIndexes([]string{id}, w.collection.spec.collatedFields())
+}
+
+// modelIDForChannel returns the model id corresponding to the key in the given
+// keyspace notification channel. It returns false if the key is not the key for
+// a model hash (e.g. if it is the key for a field index).
+func (w *ExternalWriteWatcher) modelIDForChannel(channel string) (string, bool) {
+	prefix := fmt.Sprintf("__keyspace@%d__:%s:", w.pool.options.Database, w.collection.Name())
+	if !strings.HasPrefix(channel, prefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(channel, prefix)
+	if id == "" || id == "all" || strings.Contains(id, ":") {
+		return "", false
+	}
+	for _, fs := range w.collection.spec.fieldsWithComputed() {
+		if fs.indexKind != noIndex && fs.redisName == id {
+			return "", false
+		}
+	}
+	return id, true
+}
+
+// setError sets the error for the watcher iff it was not already set.
+func (w *ExternalWriteWatcher) setError(err error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Err returns the first error (if any) that occurred while receiving
+// notifications or re-syncing indexes.
+func (w *ExternalWriteWatcher) Err() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.err
+}
+
+// Close stops the watcher and closes its connection. It blocks until the
+// watcher goroutine has exited.
+func (w *ExternalWriteWatcher) Close() error {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mut.Unlock()
+	err := w.psc.Close()
+	<-w.done
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File watcher_test.go tests the code in watcher.go
+
+package zoom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchExternalWritesClose(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	w, err := testPool.WatchExternalWrites(indexedTestModels)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.NoError(t, w.Err())
+	// Closing twice should be a no-op
+	assert.NoError(t, w.Close())
+
+	_, err = testPool.WatchExternalWrites(nil)
+	assert.Error(t, err)
+}
+
+func TestExternalWriteWatcherModelIDForChannel(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	w := &ExternalWriteWatcher{collection: indexedTestModels, pool: testPool}
+	prefix := fmt.Sprintf("__keyspace@%d__:%s:", testPool.options.Database, indexedTestModels.Name())
+	testCases := []struct {
+		channel    string
+		expectedID string
+		expectedOk bool
+	}{
+		{prefix + "abc", "abc", true},
+		{prefix + "all", "", false},
+		{prefix + "Int", "", false},
+		{prefix + "String", "", false},
+		{prefix + "tmp:abc", "", false},
+		{prefix, "", false},
+		{"__keyspace@0__:other:abc", "", false},
+	}
+	for _, tc := range testCases {
+		id, ok := w.modelIDForChannel(tc.channel)
+		assert.Equal(t, tc.expectedOk, ok, "channel: %s", tc.channel)
+		assert.Equal(t, tc.expectedID, id, "channel: %s", tc.channel)
+	}
+}
+
+func TestExternalWriteWatcherHandleNotification(t *testing.T) {
+	testingSetUp()
+	defer testingTearDown()
+
+	models, err := createAndSaveIndexedTestModels(2)
+	require.NoError(t, err)
+	model, other := models[0], models[1]
+	w := &ExternalWriteWatcher{collection: indexedTestModels, pool: testPool}
+	channel := fmt.Sprintf("__keyspace@%d__:%s", testPool.options.Database, indexedTestModels.ModelKey(model.ID))
+
+	// Change the field values directly, without going through Zoom
+	conn := testPool.NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	oldModel := *model
+	// Use a small int so that the old and new scores are not equal as floats
+	model.Int = oldModel.Int%1000 + 1000
+	model.String = model.String + "x"
+	model.Bool = !model.Bool
+	_, err = conn.Do("HMSET", indexedTestModels.ModelKey(model.ID), "Int", model.Int, "String", model.String, "Bool", model.Bool)
+	require.NoError(t, err)
+
+	// Events which do not modify the hash should be ignored
+	require.NoError(t, w.handleNotification(channel, "expire"))
+	for _, fieldName := range []string{"Int", "String", "Bool"} {
+		expectIndexExists(t, indexedTestModels, &oldModel, fieldName)
+	}
+
+	// The indexes should be synced after an hset event
+	require.NoError(t, w.handleNotification(channel, "hset"))
+	for _, fieldName := range []string{"Int", "String", "Bool"} {
+		expectIndexExists(t, indexedTestModels, model, fieldName)
+		expectIndexDoesNotExist(t, indexedTestModels, &oldModel, fieldName)
+		expectIndexExists(t, indexedTestModels, other, fieldName)
+	}
+	expectSetContains(t, indexedTestModels.IndexKey(), model.ID)
+
+	// Delete the hash directly and make sure the model is removed from all
+	// indexes after a del event
+	_, err = conn.Do("DEL", indexedTestModels.ModelKey(model.ID))
+	require.NoError(t, err)
+	require.NoError(t, w.handleNotification(channel, "del"))
+	for _, fieldName := range []string{"Int", "String", "Bool"} {
+		expectIndexDoesNotExist(t, indexedTestModels, model, fieldName)
+		expectIndexExists(t, indexedTestModels, other, fieldName)
+	}
+	expectSetDoesNotContain(t, indexedTestModels.IndexKey(), model.ID)
+	expectSetContains(t, indexedTestModels.IndexKey(), other.ID)
+}
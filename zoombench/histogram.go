@@ -0,0 +1,136 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File histogram.go contains Histogram, which records the latencies of the
+// operations in a benchmark.
+
+package zoombench
+
+import "time"
+
+// numBuckets is the number of buckets in a Histogram. The upper bound of the
+// last bucket is about 36 minutes, and longer latencies are also counted in it.
+const numBuckets = 32
+
+// Histogram records latencies in buckets whose upper bounds are powers of two
+// microseconds (1µs, 2µs, 4µs, and so on), so percentiles are accurate to
+// within a factor of two. The zero value is an empty histogram. A Histogram is
+// not safe for concurrent use.
+type Histogram struct {
+	counts [numBuckets]int64
+	count  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// Bucket is a single bucket of a Histogram.
+type Bucket struct {
+	// UpperBound is the longest latency counted in the bucket. The lower bound
+	// is the UpperBound of the previous bucket.
+	UpperBound time.Duration
+	// Count is the number of latencies in the bucket.
+	Count int64
+}
+
+// bucketUpperBound returns the upper bound of the bucket with index i.
+func bucketUpperBound(i int) time.Duration {
+	return time.Microsecond << uint(i)
+}
+
+// Record adds a single latency to the histogram.
+func (h *Histogram) Record(d time.Duration) {
+	i := 0
+	for i < numBuckets-1 && d > bucketUpperBound(i) {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds all the latencies recorded by other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	h.count += other.count
+	h.sum += other.sum
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count returns the number of latencies recorded.
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Mean returns the mean of the latencies recorded, or 0 if there are none.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Max returns the longest latency recorded, or 0 if there are none.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Percentile returns an upper bound for the given percentile (between 0 and
+// 100) of the latencies recorded, i.e. the upper bound of the bucket which
+// contains it (or Max if that is smaller). It returns 0 if there are no
+// latencies.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(p / 100 * float64(h.count))
+	if float64(rank) < p/100*float64(h.count) {
+		rank++
+	}
+	if rank < 1 {
+		rank = 1
+	}
+	cumulative := int64(0)
+	for i, count := range h.counts {
+		cumulative += count
+		if cumulative >= rank {
+			if bound := bucketUpperBound(i); bound < h.max {
+				return bound
+			}
+			break
+		}
+	}
+	return h.max
+}
+
+// Buckets returns the buckets of the histogram from the first to the last one
+// which is not empty.
+func (h *Histogram) Buckets() []Bucket {
+	first, last := -1, -1
+	for i, count := range h.counts {
+		if count > 0 {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	buckets := []Bucket{}
+	if first == -1 {
+		return buckets
+	}
+	for i := first; i <= last; i++ {
+		buckets = append(buckets, Bucket{
+			UpperBound: bucketUpperBound(i),
+			Count:      h.counts[i],
+		})
+	}
+	return buckets
+}
@@ -0,0 +1,409 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// Package zoombench is a load generator for Zoom. It saves and finds models
+// with a configurable size and number of indexes from several goroutines
+// against a Redis server and reports the throughput and latency of each kind
+// of operation, which helps with sizing a deployment. The cmd/zoombench
+// command runs it from the command line.
+package zoombench
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/albrow/zoom"
+)
+
+// Options configures a benchmark run.
+type Options struct {
+	// Concurrency is the number of goroutines which send operations at the same
+	// time. It should not be larger than the MaxActive option of the pool.
+	Concurrency int
+	// Duration is how long operations are sent for, not counting the time it
+	// takes to save the initial models.
+	Duration time.Duration
+	// Fields is the number of string fields of each model, which are not
+	// indexed.
+	Fields int
+	// FieldSize is the length in bytes of the value of each string field.
+	FieldSize int
+	// Indexes is the number of indexed int64 fields of each model, in addition
+	// to Fields. Each index makes saving a model slower.
+	Indexes int
+	// Models is the number of models which are saved before the benchmark
+	// starts. Reads find one of them at random and writes overwrite one of them
+	// at random.
+	Models int
+	// Name is the name of the collection the models are saved in. Any models
+	// already in the collection are deleted, and so are the models saved by the
+	// benchmark when it finishes.
+	Name string
+	// ReadRatio is the fraction of the operations (between 0 and 1) which are
+	// reads. The rest are writes.
+	ReadRatio float64
+}
+
+// DefaultOptions is the default set of options for a benchmark run.
+var DefaultOptions = Options{
+	Concurrency: 8,
+	Duration:    10 * time.Second,
+	Fields:      4,
+	FieldSize:   32,
+	Indexes:     1,
+	Models:      1000,
+	Name:        "ZoomBench",
+	ReadRatio:   0.8,
+}
+
+// WithConcurrency returns a new copy of the options with the Concurrency
+// property set to the given value. It does not mutate the original options.
+func (options Options) WithConcurrency(concurrency int) Options {
+	options.Concurrency = concurrency
+	return options
+}
+
+// WithDuration returns a new copy of the options with the Duration property
+// set to the given value. It does not mutate the original options.
+func (options Options) WithDuration(duration time.Duration) Options {
+	options.Duration = duration
+	return options
+}
+
+// WithFields returns a new copy of the options with the Fields property set
+// to the given value. It does not mutate the original options.
+func (options Options) WithFields(fields int) Options {
+	options.Fields = fields
+	return options
+}
+
+// WithFieldSize returns a new copy of the options with the FieldSize property
+// set to the given value. It does not mutate the original options.
+func (options Options) WithFieldSize(size int) Options {
+	options.FieldSize = size
+	return options
+}
+
+// WithIndexes returns a new copy of the options with the Indexes property set
+// to the given value. It does not mutate the original options.
+func (options Options) WithIndexes(indexes int) Options {
+	options.Indexes = indexes
+	return options
+}
+
+// WithModels returns a new copy of the options with the Models property set
+// to the given value. It does not mutate the original options.
+func (options Options) WithModels(models int) Options {
+	options.Models = models
+	return options
+}
+
+// WithName returns a new copy of the options with the Name property set to
+// the given value. It does not mutate the original options.
+func (options Options) WithName(name string) Options {
+	options.Name = name
+	return options
+}
+
+// WithReadRatio returns a new copy of the options with the ReadRatio property
+// set to the given value. It does not mutate the original options.
+func (options Options) WithReadRatio(ratio float64) Options {
+	options.ReadRatio = ratio
+	return options
+}
+
+// validate returns an error if the options cannot be used for a run.
+func (options Options) validate() error {
+	switch {
+	case options.Concurrency < 1:
+		return fmt.Errorf("Concurrency must be at least 1 but got %d", options.Concurrency)
+	case options.Duration <= 0:
+		return fmt.Errorf("Duration must be positive but got %s", options.Duration)
+	case options.Fields < 0:
+		return fmt.Errorf("Fields cannot be negative but got %d", options.Fields)
+	case options.FieldSize < 0:
+		return fmt.Errorf("FieldSize cannot be negative but got %d", options.FieldSize)
+	case options.Indexes < 0:
+		return fmt.Errorf("Indexes cannot be negative but got %d", options.Indexes)
+	case options.Fields+options.Indexes == 0:
+		return fmt.Errorf("models must have at least one field (Fields or Indexes)")
+	case options.Models < 1:
+		return fmt.Errorf("Models must be at least 1 but got %d", options.Models)
+	case options.ReadRatio < 0 || options.ReadRatio > 1:
+		return fmt.Errorf("ReadRatio must be between 0 and 1 but got %g", options.ReadRatio)
+	}
+	return nil
+}
+
+// OpStats are the statistics for a single kind of operation.
+type OpStats struct {
+	// Errors is the number of operations which returned an error. Their
+	// latencies are not recorded.
+	Errors int64
+	// Latency records the latencies of the operations which succeeded.
+	Latency Histogram
+}
+
+// Result is the result of a benchmark run.
+type Result struct {
+	// Options are the options used for the run.
+	Options Options
+	// Elapsed is how long operations were actually sent for.
+	Elapsed time.Duration
+	// Reads are the statistics for finding a model.
+	Reads OpStats
+	// Writes are the statistics for saving a model.
+	Writes OpStats
+	// FirstError is the first error returned by an operation, or nil if there
+	// were no errors.
+	FirstError error
+}
+
+// OpsPerSec returns the number of successful operations of the given kind per
+// second.
+func (r *Result) OpsPerSec(stats *OpStats) float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(stats.Latency.Count()) / r.Elapsed.Seconds()
+}
+
+// benchmark holds the state of a single benchmark run.
+type benchmark struct {
+	options    Options
+	collection *zoom.DynamicCollection
+}
+
+// Run runs a benchmark against the Redis server of pool with the given options.
+// It registers a new collection with the name options.Name, so the name must
+// not already be registered with the pool. Run returns an error if the options
+// are invalid or the initial models cannot be saved. Errors returned by the
+// operations during the benchmark are counted in the result instead.
+func Run(pool *zoom.Pool, options Options) (*Result, error) {
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("zoombench: invalid options: %w", err)
+	}
+	schema := zoom.Schema{Name: options.Name}
+	for i := 0; i < options.Fields; i++ {
+		schema.Fields = append(schema.Fields, zoom.SchemaField{
+			Name: "Field" + strconv.Itoa(i),
+			Type: "string",
+		})
+	}
+	for i := 0; i < options.Indexes; i++ {
+		schema.Fields = append(schema.Fields, zoom.SchemaField{
+			Name:  "Index" + strconv.Itoa(i),
+			Type:  "int64",
+			Index: "integer",
+		})
+	}
+	collection, err := pool.NewDynamicCollection(schema, zoom.DefaultCollectionOptions.WithIndex(true))
+	if err != nil {
+		return nil, fmt.Errorf("zoombench: %w", err)
+	}
+	b := &benchmark{
+		options:    options,
+		collection: collection,
+	}
+	if _, err := collection.DeleteAll(); err != nil {
+		return nil, fmt.Errorf("zoombench: could not delete existing models: %w", err)
+	}
+	defer func() {
+		_, _ = collection.DeleteAll()
+	}()
+	if err := b.populate(); err != nil {
+		return nil, fmt.Errorf("zoombench: could not save the initial models: %w", err)
+	}
+	return b.run(), nil
+}
+
+// populate saves the initial models, using up to options.Concurrency
+// goroutines.
+func (b *benchmark) populate() error {
+	ids := make(chan int)
+	errs := make(chan error, b.options.Concurrency)
+	wg := sync.WaitGroup{}
+	for w := 0; w < b.options.Concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for id := range ids {
+				if err := b.collection.Save(b.newModel(r, id)); err != nil {
+					errs <- err
+					// Keep draining ids so that the sender is not blocked.
+					for range ids {
+					}
+					return
+				}
+			}
+		}(time.Now().UnixNano() + int64(w))
+	}
+	for id := 0; id < b.options.Models; id++ {
+		ids <- id
+	}
+	close(ids)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// run sends operations from options.Concurrency goroutines until
+// options.Duration has passed and returns the combined result.
+func (b *benchmark) run() *Result {
+	results := make([]*Result, b.options.Concurrency)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	deadline := start.Add(b.options.Duration)
+	for w := range results {
+		results[w] = &Result{}
+		wg.Add(1)
+		go func(result *Result, seed int64) {
+			defer wg.Done()
+			b.work(result, rand.New(rand.NewSource(seed)), deadline)
+		}(results[w], start.UnixNano()+int64(w))
+	}
+	wg.Wait()
+	total := &Result{
+		Options: b.options,
+		Elapsed: time.Since(start),
+	}
+	for _, result := range results {
+		total.Reads.merge(&result.Reads)
+		total.Writes.merge(&result.Writes)
+		if total.FirstError == nil {
+			total.FirstError = result.FirstError
+		}
+	}
+	return total
+}
+
+// work sends operations until deadline and records them in result.
+func (b *benchmark) work(result *Result, r *rand.Rand, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		id := r.Intn(b.options.Models)
+		stats := &result.Writes
+		var err error
+		start := time.Now()
+		if r.Float64() < b.options.ReadRatio {
+			stats = &result.Reads
+			_, err = b.collection.Find(strconv.Itoa(id))
+		} else {
+			err = b.collection.Save(b.newModel(r, id))
+		}
+		latency := time.Since(start)
+		if err != nil {
+			stats.Errors++
+			if result.FirstError == nil {
+				result.FirstError = err
+			}
+			continue
+		}
+		stats.Latency.Record(latency)
+	}
+}
+
+// merge adds the statistics in other to s.
+func (s *OpStats) merge(other *OpStats) {
+	s.Errors += other.Errors
+	s.Latency.Merge(&other.Latency)
+}
+
+// letters are the characters used for the values of string fields.
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// newModel returns a model with the given id and random field values.
+func (b *benchmark) newModel(r *rand.Rand, id int) map[string]interface{} {
+	model := map[string]interface{}{
+		zoom.DynamicIDKey: strconv.Itoa(id),
+	}
+	value := make([]byte, b.options.FieldSize)
+	for i := 0; i < b.options.Fields; i++ {
+		for j := range value {
+			value[j] = letters[r.Intn(len(letters))]
+		}
+		model["Field"+strconv.Itoa(i)] = string(value)
+	}
+	for i := 0; i < b.options.Indexes; i++ {
+		model["Index"+strconv.Itoa(i)] = r.Int63()
+	}
+	return model
+}
+
+// Report writes a human-readable summary of the result to w, with the
+// throughput and latency percentiles of each kind of operation followed by
+// their latency histograms.
+func (r *Result) Report(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%d models with %d fields of %d bytes and %d indexes, %d goroutines, %.0f%% reads, %s\n\n",
+		r.Options.Models, r.Options.Fields, r.Options.FieldSize, r.Options.Indexes,
+		r.Options.Concurrency, r.Options.ReadRatio*100, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(b, "%-6s %10s %8s %10s %10s %10s %10s %10s %10s\n",
+		"op", "count", "errors", "ops/sec", "mean", "p50", "p90", "p99", "max")
+	ops := []struct {
+		name  string
+		stats *OpStats
+	}{
+		{"read", &r.Reads},
+		{"write", &r.Writes},
+	}
+	for _, op := range ops {
+		h := &op.stats.Latency
+		fmt.Fprintf(b, "%-6s %10d %8d %10.0f %10s %10s %10s %10s %10s\n",
+			op.name, h.Count(), op.stats.Errors, r.OpsPerSec(op.stats),
+			formatLatency(h.Mean()), formatLatency(h.Percentile(50)), formatLatency(h.Percentile(90)),
+			formatLatency(h.Percentile(99)), formatLatency(h.Max()))
+	}
+	for _, op := range ops {
+		buckets := op.stats.Latency.Buckets()
+		if len(buckets) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "\n%s latency:\n", op.name)
+		writeHistogram(b, buckets, op.stats.Latency.Count())
+	}
+	if r.FirstError != nil {
+		fmt.Fprintf(b, "\nfirst error: %s\n", r.FirstError)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// histogramWidth is the width of the longest bar written by writeHistogram.
+const histogramWidth = 40
+
+// writeHistogram writes a bar for each of the buckets to b.
+func writeHistogram(b *strings.Builder, buckets []Bucket, total int64) {
+	largest := int64(0)
+	for _, bucket := range buckets {
+		if bucket.Count > largest {
+			largest = bucket.Count
+		}
+	}
+	for _, bucket := range buckets {
+		bar := int(bucket.Count * histogramWidth / largest)
+		if bar == 0 && bucket.Count > 0 {
+			bar = 1
+		}
+		fmt.Fprintf(b, "  <= %8s %6.2f%% %s\n", formatLatency(bucket.UpperBound),
+			float64(bucket.Count)*100/float64(total), strings.Repeat("#", bar))
+	}
+}
+
+// formatLatency formats d with a precision which suits latencies.
+func formatLatency(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
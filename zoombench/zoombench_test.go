@@ -0,0 +1,91 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File zoombench_test.go tests the code in zoombench.go and histogram.go
+
+package zoombench
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/albrow/zoom/internal/testpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var database = flag.Int("bench-database", testpool.BenchDatabase, "the redis database number to use for testing zoombench")
+
+func TestRun(t *testing.T) {
+	pool := testpool.New(t, *database)
+
+	options := DefaultOptions.
+		WithConcurrency(4).
+		WithDuration(200 * time.Millisecond).
+		WithModels(50).
+		WithIndexes(2).
+		WithReadRatio(0.5)
+	result, err := Run(pool, options)
+	require.NoError(t, err)
+	require.NoError(t, result.FirstError)
+	assert.Equal(t, options, result.Options)
+	assert.True(t, result.Elapsed >= options.Duration, "expected elapsed time to be at least %s but got %s", options.Duration, result.Elapsed)
+	assert.True(t, result.Reads.Latency.Count() > 0, "expected at least one read")
+	assert.True(t, result.Writes.Latency.Count() > 0, "expected at least one write")
+	assert.True(t, result.OpsPerSec(&result.Reads) > 0)
+
+	// The models are deleted when the benchmark finishes.
+	conn := pool.NewConn()
+	count, err := conn.Do("SCARD", options.Name+":all")
+	_ = conn.Close()
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, count)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, result.Report(buf))
+	assert.Contains(t, buf.String(), "ops/sec")
+	assert.Contains(t, buf.String(), "read latency:")
+	assert.Contains(t, buf.String(), "write latency:")
+
+	// Invalid options are rejected before anything is written.
+	_, err = Run(pool, options.WithName("Other").WithReadRatio(2))
+	assert.Error(t, err)
+	_, err = Run(pool, options.WithName("Other").WithFields(0).WithIndexes(0))
+	assert.Error(t, err)
+}
+
+func TestHistogram(t *testing.T) {
+	h := &Histogram{}
+	assert.Equal(t, time.Duration(0), h.Percentile(50))
+	assert.Equal(t, []Bucket{}, h.Buckets())
+
+	for i := 0; i < 90; i++ {
+		h.Record(3 * time.Microsecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.Record(100 * time.Microsecond)
+	}
+	h.Record(5 * time.Millisecond)
+	assert.EqualValues(t, 100, h.Count())
+	assert.Equal(t, 5*time.Millisecond, h.Max())
+	assert.Equal(t, (270+900+5000)*time.Microsecond/100, h.Mean())
+	assert.Equal(t, 4*time.Microsecond, h.Percentile(50))
+	assert.Equal(t, 4*time.Microsecond, h.Percentile(90))
+	assert.Equal(t, 128*time.Microsecond, h.Percentile(99))
+	assert.Equal(t, 5*time.Millisecond, h.Percentile(100))
+
+	buckets := h.Buckets()
+	require.Len(t, buckets, 12)
+	assert.Equal(t, Bucket{UpperBound: 4 * time.Microsecond, Count: 90}, buckets[0])
+	assert.Equal(t, Bucket{UpperBound: 128 * time.Microsecond, Count: 9}, buckets[5])
+	assert.Equal(t, Bucket{UpperBound: 8192 * time.Microsecond, Count: 1}, buckets[11])
+
+	other := &Histogram{}
+	other.Record(time.Second)
+	h.Merge(other)
+	assert.EqualValues(t, 101, h.Count())
+	assert.Equal(t, time.Second, h.Max())
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// Package zoomfixtures loads test fixtures from YAML or JSON files into Zoom
+// collections and deletes them again afterwards, so that integration tests
+// can share a standard way of seeding the database.
+//
+// A fixture file maps the names of collections to a list of models. The
+// fields of each model are decoded like JSON into a new model of the type
+// registered for the collection, so they use the names of the struct fields
+// (or their json struct tags). The special field "id" sets the id of the
+// model. Models without an id are given a deterministic one consisting of the
+// lowercase name of the collection and the position of the model, starting at
+// 1, e.g. "person-2". For example:
+//
+//	Person:
+//	  - id: alice
+//	    Name: Alice
+//	    Age: 25
+//	  - Name: Bob # has the id "person-2"
+//	    Age: 30
+package zoomfixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/albrow/zoom"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of fixture data.
+type Format int
+
+const (
+	// YAML is the format of fixture files with the .yaml or .yml extension.
+	YAML Format = iota
+	// JSON is the format of fixture files with the .json extension.
+	JSON
+)
+
+// IDField is the name of the field which sets the id of a model in a fixture
+// file.
+const IDField = "id"
+
+// Fixtures are the models which were loaded by Load or LoadData.
+type Fixtures struct {
+	pool *zoom.Pool
+	// names are the names of the collections with at least one model, in the
+	// order the models were saved.
+	names  []string
+	models map[string][]zoom.Model
+}
+
+// Load reads the fixture files at the given paths and saves the models in
+// them to the collections registered with pool, in a single transaction. The
+// format of each file is determined by its extension, and the models in each
+// collection are saved in the order they appear in the files. Load returns an
+// error without
+// saving anything if a file refers to a collection which is not registered,
+// a model has a field which its type does not have, or two models in the same
+// collection have the same id.
+func Load(pool *zoom.Pool, paths ...string) (*Fixtures, error) {
+	f := newFixtures(pool)
+	for _, path := range paths {
+		var format Format
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			format = YAML
+		case ".json":
+			format = JSON
+		default:
+			return nil, fmt.Errorf("zoomfixtures: cannot determine the format of %s: expected a .yaml, .yml or .json file", path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("zoomfixtures: %w", err)
+		}
+		if err := f.add(data, format); err != nil {
+			return nil, fmt.Errorf("zoomfixtures: error in %s: %w", path, err)
+		}
+	}
+	if err := f.save(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadData is like Load but reads the fixtures from data, which has the given
+// format, e.g. so that fixtures can be embedded in a test file.
+func LoadData(pool *zoom.Pool, format Format, data []byte) (*Fixtures, error) {
+	f := newFixtures(pool)
+	if err := f.add(data, format); err != nil {
+		return nil, fmt.Errorf("zoomfixtures: %w", err)
+	}
+	if err := f.save(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadForTest is like Load but fails the test if the fixtures could not be
+// loaded, and deletes the models when the test and its subtests have
+// finished.
+func LoadForTest(tb testing.TB, pool *zoom.Pool, paths ...string) *Fixtures {
+	tb.Helper()
+	f, err := Load(pool, paths...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := f.Teardown(); err != nil {
+			tb.Error(err)
+		}
+	})
+	return f
+}
+
+func newFixtures(pool *zoom.Pool) *Fixtures {
+	return &Fixtures{
+		pool:   pool,
+		models: map[string][]zoom.Model{},
+	}
+}
+
+// add decodes the models in data, which has the given format, and adds them to
+// f without saving them.
+func (f *Fixtures) add(data []byte, format Format) error {
+	records := map[string][]map[string]interface{}{}
+	switch format {
+	case YAML:
+		if err := yaml.Unmarshal(data, &records); err != nil {
+			return err
+		}
+	case JSON:
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %d", format)
+	}
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		collection, found := f.pool.Collection(name)
+		if !found {
+			return fmt.Errorf("no collection named %s is registered", name)
+		}
+		for _, record := range records[name] {
+			model, err := f.decode(collection, record)
+			if err != nil {
+				return err
+			}
+			if f.Model(name, model.ModelID()) != nil {
+				return fmt.Errorf("there is more than one %s with id %s", name, model.ModelID())
+			}
+			if len(f.models[name]) == 0 {
+				f.names = append(f.names, name)
+			}
+			f.models[name] = append(f.models[name], model)
+		}
+	}
+	return nil
+}
+
+// decode returns a new model for the given collection with the fields in
+// record.
+func (f *Fixtures) decode(collection *zoom.Collection, record map[string]interface{}) (zoom.Model, error) {
+	name := collection.Name()
+	id := strings.ToLower(name) + "-" + strconv.Itoa(len(f.models[name])+1)
+	fields := map[string]interface{}{}
+	for field, value := range record {
+		if field == IDField {
+			id = fmt.Sprint(value)
+			continue
+		}
+		fields[field] = value
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode %s %s: %w", name, id, err)
+	}
+	model := collection.NewModel()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(model); err != nil {
+		return nil, fmt.Errorf("could not decode %s %s: %w", name, id, err)
+	}
+	model.SetModelID(id)
+	return model, nil
+}
+
+// save saves all the models in f in a single transaction.
+func (f *Fixtures) save() error {
+	tx := f.pool.NewTransaction()
+	for _, name := range f.names {
+		collection, _ := f.pool.Collection(name)
+		for _, model := range f.models[name] {
+			tx.Save(collection, model)
+		}
+	}
+	if err := tx.Exec(); err != nil {
+		return fmt.Errorf("zoomfixtures: could not save fixtures: %w", err)
+	}
+	return nil
+}
+
+// Models returns the models which were loaded into the collection with the
+// given name, in the order they were saved.
+func (f *Fixtures) Models(collectionName string) []zoom.Model {
+	return f.models[collectionName]
+}
+
+// Model returns the model with the given id which was loaded into the
+// collection with the given name, or nil if there is none.
+func (f *Fixtures) Model(collectionName string, id string) zoom.Model {
+	for _, model := range f.models[collectionName] {
+		if model.ModelID() == id {
+			return model
+		}
+	}
+	return nil
+}
+
+// Teardown deletes all the models which were loaded, including their indexes,
+// in a single transaction. Models which were already deleted are ignored.
+func (f *Fixtures) Teardown() error {
+	tx := f.pool.NewTransaction()
+	for _, name := range f.names {
+		collection, _ := f.pool.Collection(name)
+		for _, model := range f.models[name] {
+			tx.Delete(collection, model.ModelID(), nil)
+		}
+	}
+	if err := tx.Exec(); err != nil {
+		return fmt.Errorf("zoomfixtures: could not delete fixtures: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File zoomfixtures_test.go tests the code in zoomfixtures.go
+
+package zoomfixtures
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/albrow/zoom"
+	"github.com/albrow/zoom/internal/testpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var database = flag.Int("fixtures-database", testpool.FixturesDatabase, "the redis database number to use for testing zoomfixtures")
+
+type FixturePerson struct {
+	Name string
+	Age  int `zoom:"index"`
+	zoom.RandomID
+}
+
+type FixturePet struct {
+	Name    string
+	OwnerID string
+	zoom.RandomID
+}
+
+func newTestPool(t *testing.T) (*zoom.Pool, *zoom.Collection) {
+	pool := testpool.New(t, *database)
+	people, err := pool.NewCollectionWithOptions(&FixturePerson{}, zoom.DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	_, err = pool.NewCollectionWithOptions(&FixturePet{}, zoom.DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	return pool, people
+}
+
+func TestLoad(t *testing.T) {
+	pool, people := newTestPool(t)
+
+	fixtures, err := Load(pool, "testdata/people.yaml", "testdata/more_people.json")
+	require.NoError(t, err)
+	expected := []zoom.Model{
+		&FixturePerson{Name: "Alice", Age: 25, RandomID: zoom.RandomID{ID: "alice"}},
+		&FixturePerson{Name: "Bob", Age: 30, RandomID: zoom.RandomID{ID: "fixtureperson-2"}},
+		&FixturePerson{Name: "Carol", Age: 35, RandomID: zoom.RandomID{ID: "fixtureperson-3"}},
+	}
+	assert.Equal(t, expected, fixtures.Models("FixturePerson"))
+	assert.Equal(t, &FixturePet{Name: "Fido", OwnerID: "alice", RandomID: zoom.RandomID{ID: "fido"}}, fixtures.Model("FixturePet", "fido"))
+	assert.Nil(t, fixtures.Model("FixturePet", "rex"))
+
+	// The models and their indexes are saved
+	got := []*FixturePerson{}
+	require.NoError(t, people.NewQuery().Filter("Age >=", 30).Order("Age").Run(&got))
+	assert.Equal(t, expected[1:], []zoom.Model{got[0], got[1]})
+
+	// Teardown deletes the models and their indexes
+	require.NoError(t, fixtures.Teardown())
+	count, err := people.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	ids, err := people.NewQuery().Filter("Age >=", 0).IDs()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestLoadForTest(t *testing.T) {
+	pool, people := newTestPool(t)
+
+	t.Run("load", func(t *testing.T) {
+		LoadForTest(t, pool, "testdata/more_people.json")
+		count, err := people.Count()
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+	count, err := people.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestLoadErrors(t *testing.T) {
+	pool, people := newTestPool(t)
+
+	for name, data := range map[string]string{
+		"unknown collection": `{"Nope": [{"Name": "Alice"}]}`,
+		"unknown field":      `{"FixturePerson": [{"Nmae": "Alice"}]}`,
+		"wrong type":         `{"FixturePerson": [{"Age": "old"}]}`,
+		"duplicate id":       `{"FixturePerson": [{"id": "a"}, {"id": "a"}]}`,
+		"invalid json":       `{`,
+	} {
+		_, err := LoadData(pool, JSON, []byte(data))
+		assert.Error(t, err, name)
+	}
+	_, err := Load(pool, "testdata/people.txt")
+	assert.Error(t, err)
+	_, err = Load(pool, "testdata/missing.yaml")
+	assert.Error(t, err)
+
+	// Nothing is saved if there is an error
+	count, err := people.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
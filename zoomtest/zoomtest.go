@@ -0,0 +1,391 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// Package zoomtest contains helpers for testing code which uses Zoom.
+//
+// SnapshotCollection compares everything a collection stores in Redis (the
+// main hashes of the models, fields which are stored in their own keys, the
+// set of all ids and the indexes) with a golden file, so that tests can catch
+// unintended changes to the data layer. Run the tests with the
+// -zoomtest.update flag to create or update the golden files:
+//
+//	go test ./... -zoomtest.update
+//
+// The golden representation is a deterministic text format with one line per
+// key followed by one indented line per field, member or element:
+//
+//	Person:Age (zset)
+//	  "alice" 25
+//	  "bob" 30
+//	Person:alice (hash)
+//	  "Age" = "25"
+//	  "Name" = "Alice"
+//
+// Random ids and timestamps differ between runs, so the models in a snapshot
+// should have fixed ids (e.g. loaded with zoomfixtures) and should not use
+// automatic timestamps.
+package zoomtest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/albrow/zoom"
+	"github.com/garyburd/redigo/redis"
+)
+
+// update is the flag which causes SnapshotCollection to write the golden
+// files instead of comparing them.
+var update = flag.Bool("zoomtest.update", false, "update the golden files of zoomtest.SnapshotCollection instead of comparing them")
+
+// SnapshotDir is the directory, relative to the package being tested, in which
+// SnapshotCollection stores golden files.
+var SnapshotDir = filepath.Join("testdata", "snapshots")
+
+// maxDiffLines is the maximum number of lines which are compared line by line
+// when a snapshot does not match its golden file. Larger differences are
+// reported without aligning the lines.
+const maxDiffLines = 2000
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// SnapshotCollection serializes all the keys of the collection (see
+// SerializeCollection) and compares them with the golden file for the test
+// and collection, which is named after the test and the collection in
+// SnapshotDir, e.g. "testdata/snapshots/TestSave.Person.golden". It fails the
+// test with a diff if they are different or if the golden file does not
+// exist. If the -zoomtest.update flag is set, the golden file is written
+// instead.
+func SnapshotCollection(tb testing.TB, collection *zoom.Collection) {
+	tb.Helper()
+	got, err := SerializeCollection(collection)
+	if err != nil {
+		tb.Fatal(err)
+		return
+	}
+	path := GoldenPath(tb, collection)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tb.Fatal(err)
+			return
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			tb.Fatal(err)
+			return
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		tb.Fatalf("zoomtest: golden file %s does not exist. Run the test with -zoomtest.update to create it", path)
+		return
+	} else if err != nil {
+		tb.Fatal(err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		tb.Errorf("zoomtest: snapshot of collection %s does not match %s (run the test with -zoomtest.update to update it):\n%s", collection.Name(), path, diff(string(want), string(got)))
+	}
+}
+
+// GoldenPath returns the path of the golden file which SnapshotCollection
+// uses for the given test and collection. Characters of the name of the test
+// which are not allowed in file names (e.g. the slashes in the names of
+// subtests) are replaced by underscores.
+func GoldenPath(tb testing.TB, collection *zoom.Collection) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', ' ':
+			return '_'
+		}
+		return r
+	}, tb.Name())
+	return filepath.Join(SnapshotDir, name+"."+collection.Name()+".golden")
+}
+
+// SerializeCollection returns the golden representation of all the keys of
+// the collection, i.e. all the keys which start with the collection name
+// followed by a colon. The keys are sorted, as are the fields of hashes and
+// the members of sets. Sorted sets are written in the order of their scores,
+// except for the set of all ids (see Collection.IndexKey), whose scores are
+// the times at which the models were saved and which is written like a set.
+// Lists are written in their order, and streams as the fields of each entry without the
+// entry ids, which depend on the time. All values are quoted as Go strings.
+// The remaining time to live of the keys is not included.
+func SerializeCollection(collection *zoom.Collection) ([]byte, error) {
+	conn := collection.Pool().NewConn()
+	defer func() {
+		_ = conn.Close()
+	}()
+	keys, err := scanKeys(conn, collection.Name()+":*")
+	if err != nil {
+		return nil, fmt.Errorf("zoomtest: could not scan keys of collection %s: %w", collection.Name(), err)
+	}
+	sort.Strings(keys)
+	buf := &bytes.Buffer{}
+	for _, key := range keys {
+		if err := writeKey(conn, buf, key, key == collection.IndexKey()); err != nil {
+			return nil, fmt.Errorf("zoomtest: could not serialize key %s: %w", key, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// scanKeys returns all the keys which match the given pattern.
+func scanKeys(conn redis.Conn, pattern string) ([]string, error) {
+	keys := []string{}
+	seen := map[string]bool{}
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		cursor, err = redis.Int(values[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		page, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		// SCAN can return the same key more than once.
+		for _, key := range page {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// writeKey writes the golden representation of the given key to buf. If
+// omitScores is true, the members of a sorted set are written like the
+// members of a set. Keys which were deleted after they were scanned are
+// skipped.
+func writeKey(conn redis.Conn, buf *bytes.Buffer, key string, omitScores bool) error {
+	keyType, err := redis.String(conn.Do("TYPE", key))
+	if err != nil {
+		return err
+	}
+	var lines []string
+	switch keyType {
+	case "none":
+		return nil
+	case "string":
+		value, err := redis.String(conn.Do("GET", key))
+		if err != nil {
+			return err
+		}
+		lines = []string{strconv.Quote(value)}
+	case "hash":
+		values, err := redis.StringMap(conn.Do("HGETALL", key))
+		if err != nil {
+			return err
+		}
+		for field, value := range values {
+			lines = append(lines, strconv.Quote(field)+" = "+strconv.Quote(value))
+		}
+		sort.Strings(lines)
+	case "set":
+		members, err := redis.Strings(conn.Do("SMEMBERS", key))
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			lines = append(lines, strconv.Quote(member))
+		}
+		sort.Strings(lines)
+	case "zset":
+		if omitScores {
+			members, err := redis.Strings(conn.Do("ZRANGE", key, 0, -1))
+			if err != nil {
+				return err
+			}
+			for _, member := range members {
+				lines = append(lines, strconv.Quote(member))
+			}
+			sort.Strings(lines)
+			break
+		}
+		values, err := redis.Strings(conn.Do("ZRANGE", key, 0, -1, "WITHSCORES"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(values); i += 2 {
+			lines = append(lines, strconv.Quote(values[i])+" "+values[i+1])
+		}
+	case "list":
+		elements, err := redis.Strings(conn.Do("LRANGE", key, 0, -1))
+		if err != nil {
+			return err
+		}
+		for _, element := range elements {
+			lines = append(lines, strconv.Quote(element))
+		}
+	case "stream":
+		entries, err := redis.Values(conn.Do("XRANGE", key, "-", "+"))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			values, err := redis.Values(entry, nil)
+			if err != nil {
+				return err
+			}
+			if len(values) != 2 {
+				return fmt.Errorf("unexpected stream entry %v", values)
+			}
+			fields, err := redis.Strings(values[1], nil)
+			if err != nil {
+				return err
+			}
+			quoted := make([]string, len(fields))
+			for i, field := range fields {
+				quoted[i] = strconv.Quote(field)
+			}
+			lines = append(lines, strings.Join(quoted, " "))
+		}
+	default:
+		return fmt.Errorf("unsupported key type %s", keyType)
+	}
+	fmt.Fprintf(buf, "%s (%s)\n", key, keyType)
+	for _, line := range lines {
+		fmt.Fprintf(buf, "  %s\n", line)
+	}
+	return nil
+}
+
+// diff returns a line-based diff between want and got, in which removed lines
+// start with "-", added lines start with "+", and unchanged lines start with
+// a space. Only the lines around the changes are included.
+func diff(want string, got string) string {
+	a := splitLines(want)
+	b := splitLines(got)
+	// Lines which are the same at the start and end of both are not compared.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := []string{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, " "+line)
+	}
+	ops = append(ops, diffLines(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, " "+line)
+	}
+	// Only keep the unchanged lines which are close to a change.
+	out := &strings.Builder{}
+	lastWritten := -1
+	for i, op := range ops {
+		if op[0] == ' ' {
+			continue
+		}
+		start := i - diffContext
+		if start <= lastWritten {
+			start = lastWritten + 1
+		} else if lastWritten >= 0 || start > 0 {
+			out.WriteString("...\n")
+		}
+		if start < 0 {
+			start = 0
+		}
+		for j := start; j <= i; j++ {
+			out.WriteString(ops[j])
+		}
+		lastWritten = i
+		for j := i + 1; j < len(ops) && j <= i+diffContext && ops[j][0] == ' '; j++ {
+			out.WriteString(ops[j])
+			lastWritten = j
+		}
+	}
+	result := out.String()
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	return result
+}
+
+// splitLines splits s into lines which each end with a newline, except for
+// the last line if s does not end with a newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines aligns a and b by their longest common subsequence and returns
+// the lines of both with the prefixes used by diff. If there are too many
+// lines to align, all the lines of a are removed and all the lines of b are
+// added.
+func diffLines(a []string, b []string) []string {
+	ops := []string{}
+	if len(a)*len(b) > maxDiffLines*maxDiffLines {
+		for _, line := range a {
+			ops = append(ops, "-"+line)
+		}
+		for _, line := range b {
+			ops = append(ops, "+"+line)
+		}
+		return ops
+	}
+	// lengths[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, " "+a[i])
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			ops = append(ops, "-"+a[i])
+			i++
+		default:
+			ops = append(ops, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, "+"+b[j])
+	}
+	return ops
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+// File zoomtest_test.go tests the code in zoomtest.go
+
+package zoomtest
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/albrow/zoom"
+	"github.com/albrow/zoom/internal/testpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var database = flag.Int("zoomtest-database", testpool.ZoomtestDatabase, "the redis database number to use for testing zoomtest")
+
+type SnapshotPerson struct {
+	Name   string   `zoom:"index"`
+	Age    int      `zoom:"index"`
+	Emails []string `zoom:"set"`
+	Pets   []string `zoom:"list"`
+	zoom.RandomID
+}
+
+func newTestCollection(t *testing.T) *zoom.Collection {
+	pool := testpool.New(t, *database)
+	people, err := pool.NewCollectionWithOptions(&SnapshotPerson{}, zoom.DefaultCollectionOptions.WithIndex(true))
+	require.NoError(t, err)
+	models := []*SnapshotPerson{
+		{Name: "Alice", Age: 25, Emails: []string{"alice@example.com", "a@example.com"}, Pets: []string{"Rex", "Fido"}},
+		{Name: "Bob \"the builder\"\n", Age: 30},
+	}
+	models[0].SetModelID("alice")
+	models[1].SetModelID("bob")
+	for _, model := range models {
+		require.NoError(t, people.Save(model))
+	}
+	return people
+}
+
+// recorder is a testing.TB which records failures instead of failing the
+// test.
+type recorder struct {
+	testing.TB
+	errors []string
+	fatal  bool
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatal(args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprint(args...))
+	r.fatal = true
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+	r.fatal = true
+}
+
+func TestSnapshotCollection(t *testing.T) {
+	people := newTestCollection(t)
+
+	// The snapshot should match the golden file in testdata
+	SnapshotCollection(t, people)
+	assert.Equal(t, filepath.Join("testdata", "snapshots", "TestSnapshotCollection.SnapshotPerson.golden"), GoldenPath(t, people))
+
+	// Changes to the models should be reported as a diff
+	require.NoError(t, people.SaveFields([]string{"Age"}, &SnapshotPerson{Age: 26, RandomID: zoom.RandomID{ID: "alice"}}))
+	r := &recorder{TB: t}
+	SnapshotCollection(r, people)
+	require.Len(t, r.errors, 1)
+	assert.False(t, r.fatal)
+	assert.Contains(t, r.errors[0], "-  \"Age\" = \"25\"\n+  \"Age\" = \"26\"\n")
+	assert.Contains(t, r.errors[0], "-  \"alice\" 25\n")
+	assert.Contains(t, r.errors[0], "+  \"alice\" 26\n")
+
+	// Golden files should be written with the update flag, and a missing
+	// golden file should fail the test
+	defer func(dir string) {
+		SnapshotDir = dir
+	}(SnapshotDir)
+	SnapshotDir = t.TempDir()
+	t.Run("sub/test", func(t *testing.T) {
+		r := &recorder{TB: t}
+		SnapshotCollection(r, people)
+		require.Len(t, r.errors, 1)
+		assert.True(t, r.fatal)
+		assert.Contains(t, r.errors[0], "does not exist")
+
+		*update = true
+		defer func() {
+			*update = false
+		}()
+		SnapshotCollection(t, people)
+		path := GoldenPath(t, people)
+		assert.Equal(t, filepath.Join(SnapshotDir, "TestSnapshotCollection_sub_test.SnapshotPerson.golden"), path)
+		written, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		expected, err := SerializeCollection(people)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(written))
+	})
+}
+
+func TestDiff(t *testing.T) {
+	want := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	got := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	assert.Equal(t, " a\n-b\n+B\n c\n d\n e\n...\n h\n i\n j\n+k\n", diff(want, got))
+	assert.Equal(t, "+x\n", diff("", "x\n"))
+}